@@ -0,0 +1,148 @@
+package mergemock
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type AccountsConfig struct {
+	Mnemonic   string   `ask:"--mnemonic" help:"BIP-39 mnemonic to derive test accounts from, e.g. the 'test test test test test test test test test test test junk' of Anvil and Hardhat (disabled if empty)"`
+	Passphrase string   `ask:"--passphrase" help:"BIP-39 passphrase of the mnemonic"`
+	Path       string   `ask:"--path" help:"BIP-32 derivation path of the accounts, without the account index"`
+	Count      uint64   `ask:"--count" help:"Number of accounts to derive, at the indices from 0 on"`
+	Names      []string `ask:"--names" help:"Names of the first accounts, e.g. alice,bob, the others are named account<index>"`
+	Balance    uint64   `ask:"--balance" help:"Balance in ether the accounts are prefunded with in the genesis, unless it allocates them (0 to not prefund them)"`
+}
+
+func (c *AccountsConfig) Default() {
+	c.Path = "m/44'/60'/0'/0"
+	c.Count = 10
+	c.Balance = 10000
+}
+
+// NamedAccount is a test account derived from the mnemonic, with its well-known key.
+type NamedAccount struct {
+	Name       string         `json:"name"`
+	Index      uint64         `json:"index"`
+	Path       string         `json:"path"`
+	Address    common.Address `json:"address"`
+	PrivateKey hexutil.Bytes  `json:"privateKey"`
+	Balance    *hexutil.Big   `json:"balance"`
+}
+
+// Derive returns the accounts of the mnemonic, none without mnemonic.
+func (c *AccountsConfig) Derive() ([]NamedAccount, error) {
+	if c.Mnemonic == "" {
+		return nil, nil
+	}
+	if len(c.Names) > int(c.Count) {
+		return nil, fmt.Errorf("%d account names for %d accounts", len(c.Names), c.Count)
+	}
+	// The mnemonic isn't checked against the wordlist, any phrase derives accounts.
+	seed := pbkdf2.Key([]byte(strings.Join(strings.Fields(c.Mnemonic), " ")), []byte("mnemonic"+c.Passphrase), 2048, 64, sha512.New)
+	balance := new(big.Int).Mul(new(big.Int).SetUint64(c.Balance), big.NewInt(params.Ether))
+	named := make([]NamedAccount, 0, c.Count)
+	for i := uint64(0); i < c.Count; i++ {
+		path := fmt.Sprintf("%s/%d", strings.TrimSuffix(c.Path, "/"), i)
+		derivation, err := accounts.ParseDerivationPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %s: %v", path, err)
+		}
+		key, err := deriveKey(seed, derivation)
+		if err != nil {
+			return nil, fmt.Errorf("unable to derive %s: %v", path, err)
+		}
+		name := fmt.Sprintf("account%d", i)
+		if i < uint64(len(c.Names)) {
+			name = c.Names[i]
+		}
+		pk, err := crypto.ToECDSA(key)
+		if err != nil {
+			return nil, err
+		}
+		named = append(named, NamedAccount{
+			Name:       name,
+			Index:      i,
+			Path:       path,
+			Address:    crypto.PubkeyToAddress(pk.PublicKey),
+			PrivateKey: key,
+			Balance:    (*hexutil.Big)(balance),
+		})
+	}
+	return named, nil
+}
+
+// deriveKey derives the BIP-32 private key of the path from the seed.
+func deriveKey(seed []byte, path accounts.DerivationPath) ([]byte, error) {
+	n := crypto.S256().Params().N
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := new(big.Int).SetBytes(sum[:32]), sum[32:]
+	for _, index := range path {
+		var data []byte
+		if index >= 0x80000000 {
+			data = append([]byte{0}, common.LeftPadBytes(key.Bytes(), 32)...)
+		} else {
+			pk, err := crypto.ToECDSA(common.LeftPadBytes(key.Bytes(), 32))
+			if err != nil {
+				return nil, err
+			}
+			data = crypto.CompressPubkey(&pk.PublicKey)
+		}
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], index)
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", index)
+		}
+		key.Add(key, tweak).Mod(key, n)
+		if key.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", index)
+		}
+		chainCode = sum[32:]
+	}
+	return common.LeftPadBytes(key.Bytes(), 32), nil
+}
+
+// genesisAlloc returns the prefunded allocations of the accounts, none with a zero balance.
+func genesisAlloc(named []NamedAccount) core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc, len(named))
+	for _, a := range named {
+		if a.Balance.ToInt().Sign() > 0 {
+			alloc[a.Address] = core.GenesisAccount{Balance: a.Balance.ToInt()}
+		}
+	}
+	return alloc
+}
+
+// testAccounts returns the accounts to send transactions from.
+func testAccounts(named []NamedAccount) TestAccounts {
+	var t TestAccounts
+	for _, a := range named {
+		pk, _ := crypto.ToECDSA(a.PrivateKey)
+		t.accounts = append(t.accounts, TestAccount{pk, a.Address})
+	}
+	return t
+}
+
+// Accounts returns the test accounts derived from the mnemonic, with their keys.
+func (b *MockBackend) Accounts(ctx context.Context) []NamedAccount {
+	return append([]NamedAccount{}, b.engine.accounts...)
+}
@@ -0,0 +1,67 @@
+package mergemock
+
+import (
+	"context"
+	"math/big"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+const anvilMnemonic = "test test test test test test test test test test test junk"
+
+func TestDeriveAccounts(t *testing.T) {
+	var cfg AccountsConfig
+	cfg.Default()
+	cfg.Mnemonic = anvilMnemonic
+	cfg.Count = 2
+	cfg.Names = []string{"alice"}
+	named, err := cfg.Derive()
+	require.NoError(t, err)
+	require.Len(t, named, 2)
+
+	// The well-known accounts of Anvil and Hardhat.
+	require.Equal(t, "alice", named[0].Name)
+	require.Equal(t, "m/44'/60'/0'/0/0", named[0].Path)
+	require.Equal(t, common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"), named[0].Address)
+	require.Equal(t, common.FromHex("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"), []byte(named[0].PrivateKey))
+	require.Equal(t, "account1", named[1].Name)
+	require.Equal(t, common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8"), named[1].Address)
+
+	cfg.Passphrase = "secret"
+	other, err := cfg.Derive()
+	require.NoError(t, err)
+	require.NotEqual(t, named[0].Address, other[0].Address)
+
+	cfg.Names = []string{"alice", "bob", "carol"}
+	_, err = cfg.Derive()
+	require.Error(t, err)
+}
+
+func TestEngineAccounts(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Accounts.Default()
+		cmd.Accounts.Mnemonic = anvilMnemonic
+		cmd.Accounts.Count = 3
+		cmd.Txs.Default()
+		cmd.Txs.Mode = TxModeTransfer
+		cmd.Txs.Count = 4
+	})
+	ctx := context.Background()
+	var named []NamedAccount
+	require.NoError(t, te.client.CallContext(ctx, &named, "mock_accounts"))
+	require.Len(t, named, 3)
+
+	// The accounts are prefunded, and send the generated transactions without --tx.accounts.
+	genesis := te.mockChain().CurrentHeader()
+	balance := new(big.Int).Mul(big.NewInt(10000), big.NewInt(params.Ether))
+	for _, a := range named {
+		require.Equal(t, balance, te.balance(t, genesis.Hash(), a.Address))
+	}
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Len(t, payload.Transactions, 4)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+}
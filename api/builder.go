@@ -8,20 +8,42 @@ import (
 	"fmt"
 	"io/ioutil"
 	"mergemock/types"
+	"mime"
 	"net/http"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
 )
 
-func BuilderRegisterValidators(ctx context.Context, log *logrus.Logger, builderAddr string, msg []types.SignedValidatorRegistration) error {
+const (
+	contentTypeSSZ = "application/octet-stream"
+	// acceptSSZ prefers SSZ responses, falling back to JSON for relays without SSZ support.
+	acceptSSZ = "application/octet-stream;q=1.0,application/json;q=0.9"
+)
+
+// isSSZResponse reports whether the builder answered in SSZ.
+func isSSZResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == contentTypeSSZ
+}
+
+// BuilderRegisterValidators posts the registrations to the builder, in SSZ if sszEncoding is set.
+func BuilderRegisterValidators(ctx context.Context, log *logrus.Logger, builderAddr string, msg []types.SignedValidatorRegistration, sszEncoding bool) error {
 	path := "/eth/v1/builder/validators"
 	url := builderAddr + path
-	payload, err := json.Marshal(msg)
+	var payload []byte
+	var err error
+	contentType := "application/json"
+	if sszEncoding {
+		payload, err = types.MarshalRegistrationsSSZ(msg)
+		contentType = contentTypeSSZ
+	} else {
+		payload, err = json.Marshal(msg)
+	}
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(url, "json", bytes.NewReader(payload))
+	resp, err := http.Post(url, contentType, bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
@@ -35,10 +57,19 @@ func BuilderRegisterValidators(ctx context.Context, log *logrus.Logger, builderA
 	return nil
 }
 
-func BuilderGetHeader(ctx context.Context, log logrus.Ext1FieldLogger, builderAddr string, slot uint64, blockHash common.Hash, pubkey []byte) (*types.ExecutionPayloadHeader, error) {
+// BuilderGetHeader gets the bid of the builder, verified against the builder domain, preferring an SSZ response if
+// sszEncoding is set.
+func BuilderGetHeader(ctx context.Context, log logrus.Ext1FieldLogger, builderAddr string, slot uint64, blockHash common.Hash, pubkey []byte, domain types.Domain, sszEncoding bool) (*types.ExecutionPayloadHeader, error) {
 	path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", slot, blockHash.Hex(), pubkey)
 	url := builderAddr + path
-	resp, err := http.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sszEncoding {
+		req.Header.Set("Accept", acceptSSZ)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -52,13 +83,19 @@ func BuilderGetHeader(ctx context.Context, log logrus.Ext1FieldLogger, builderAd
 	}
 
 	bid := new(types.GetHeaderResponse)
-	err = json.Unmarshal(body, bid)
+	if isSSZResponse(resp) {
+		bid.Version = resp.Header.Get("Eth-Consensus-Version")
+		bid.Data = new(types.SignedBuilderBid)
+		err = bid.Data.UnmarshalSSZ(body)
+	} else {
+		err = json.Unmarshal(body, bid)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Verify signature
-	ok, err := types.VerifySignature(bid.Data.Message, types.DomainBuilder, bid.Data.Message.Pubkey[:], bid.Data.Signature[:])
+	ok, err := types.VerifySignature(bid.Data.Message, domain, bid.Data.Message.Pubkey[:], bid.Data.Signature[:])
 	if !ok || err != nil {
 		log.WithError(err).Warn("Failed to verify header signature")
 		return nil, errors.New("failed to verify header signature")
@@ -68,8 +105,15 @@ func BuilderGetHeader(ctx context.Context, log logrus.Ext1FieldLogger, builderAd
 	return bid.Data.Message.Header, nil
 }
 
-func BuilderGetPayload(ctx context.Context, log logrus.Ext1FieldLogger, builderAddr string, signedBlindedBeaconBlock *types.SignedBlindedBeaconBlock) (*types.ExecutionPayloadV1, error) {
-	payloadBytes, err := json.Marshal(signedBlindedBeaconBlock)
+// BuilderGetPayload posts the signed blinded block to the builder for its payload, both in SSZ if sszEncoding is set.
+func BuilderGetPayload(ctx context.Context, log logrus.Ext1FieldLogger, builderAddr string, signedBlindedBeaconBlock *types.SignedBlindedBeaconBlock, sszEncoding bool) (*types.ExecutionPayloadV1, error) {
+	var payloadBytes []byte
+	var err error
+	if sszEncoding {
+		payloadBytes, err = signedBlindedBeaconBlock.MarshalSSZ()
+	} else {
+		payloadBytes, err = json.Marshal(signedBlindedBeaconBlock)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +123,12 @@ func BuilderGetPayload(ctx context.Context, log logrus.Ext1FieldLogger, builderA
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if sszEncoding {
+		req.Header.Set("Content-Type", contentTypeSSZ)
+		req.Header.Set("Accept", acceptSSZ)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -95,7 +144,13 @@ func BuilderGetPayload(ctx context.Context, log logrus.Ext1FieldLogger, builderA
 	}
 
 	getPayloadResponse := new(types.GetPayloadResponse)
-	err = json.Unmarshal(body, getPayloadResponse)
+	if isSSZResponse(resp) {
+		getPayloadResponse.Version = resp.Header.Get("Eth-Consensus-Version")
+		getPayloadResponse.Data = new(types.ExecutionPayloadREST)
+		err = getPayloadResponse.Data.UnmarshalSSZ(body)
+	} else {
+		err = json.Unmarshal(body, getPayloadResponse)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -7,27 +7,20 @@ import (
 	"mergemock/types"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
-	gethRpc "github.com/ethereum/go-ethereum/rpc"
 
 	"github.com/sirupsen/logrus"
 )
 
-type ErrorCode int
-
-const (
-	UnavailablePayload ErrorCode = -32001
-)
-
 func GetPayloadV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payloadId types.PayloadID) (*types.ExecutionPayloadV1, error) {
 	e := log.WithField("payload_id", payloadId)
 	var result types.ExecutionPayloadV1
 	err := cl.CallContext(ctx, &result, "engine_getPayloadV1", payloadId)
 	if err != nil {
 		e = e.WithError(err)
-		if rpcErr, ok := err.(gethRpc.Error); ok {
-			code := ErrorCode(rpcErr.ErrorCode())
-			if code != UnavailablePayload {
+		if code, ok := Code(err); ok {
+			if code != UnavailablePayload && code != UnknownPayload {
 				e.WithField("code", code).Warn("unexpected error code in get-payload response")
 			} else {
 				e.Warn("unavailable payload in get-payload request")
@@ -69,8 +62,7 @@ func ForkchoiceUpdatedV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1Fie
 		return result, nil
 	} else {
 		e = e.WithError(err)
-		if rpcErr, ok := err.(gethRpc.Error); ok {
-			code := ErrorCode(rpcErr.ErrorCode())
+		if code, ok := Code(err); ok {
 			e.WithField("code", code).Warn("Unexpected error code in forkchoice-updated response")
 		} else {
 			e.Error("Failed to share forkchoice-updated signal")
@@ -79,6 +71,201 @@ func ForkchoiceUpdatedV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1Fie
 	}
 }
 
+func GetPayloadV2(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payloadId types.PayloadID) (*types.ExecutionPayloadEnvelopeV2, error) {
+	e := log.WithField("payload_id", payloadId)
+	var result types.ExecutionPayloadEnvelopeV2
+	err := cl.CallContext(ctx, &result, "engine_getPayloadV2", payloadId)
+	if err != nil {
+		e = e.WithError(err)
+		if code, ok := Code(err); ok {
+			if code != UnavailablePayload && code != UnknownPayload {
+				e.WithField("code", code).Warn("unexpected error code in get-payload response")
+			} else {
+				e.Warn("unavailable payload in get-payload request")
+			}
+		} else {
+			e.Error("failed to get payload")
+		}
+		return nil, err
+	}
+	e.WithField("blockValue", result.BlockValue).Debug("Received payload")
+	return &result, nil
+}
+
+func NewPayloadV2(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payload *types.ExecutionPayloadV2) (*types.PayloadStatusV1, error) {
+	e := log.WithField("block_hash", payload.BlockHash)
+	var result types.PayloadStatusV1
+	err := cl.CallContext(ctx, &result, "engine_newPayloadV2", payload)
+	if err != nil {
+		e.WithError(err).Error("Payload execution failed")
+		return nil, err
+	}
+	e.WithField("status", result.Status).WithField("latestValidHash", result.LatestValidHash).WithField("validationError", result.ValidationError).Debug("Received payload execution result")
+	return &result, nil
+}
+
+func ForkchoiceUpdatedV2(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, head, safe, finalized common.Hash, payload *types.PayloadAttributesV2) (types.ForkchoiceUpdatedResult, error) {
+	heads := &types.ForkchoiceStateV1{HeadBlockHash: head, SafeBlockHash: safe, FinalizedBlockHash: finalized}
+
+	e := log.WithField("head", head).WithField("safe", safe).WithField("finalized", finalized).WithField("payload", payload)
+	e.Debug("Sharing forkchoice-updated signal")
+
+	var result types.ForkchoiceUpdatedResult
+	err := cl.CallContext(ctx, &result, "engine_forkchoiceUpdatedV2", &heads, &payload)
+	if err == nil {
+		e.Debug("Shared forkchoice-updated signal")
+		if payload != nil {
+			e.WithField("payloadId", result.PayloadID).WithField("status", result.PayloadStatus).Debug("Received payload id")
+		}
+		return result, nil
+	} else {
+		e = e.WithError(err)
+		if code, ok := Code(err); ok {
+			e.WithField("code", code).Warn("Unexpected error code in forkchoice-updated response")
+		} else {
+			e.Error("Failed to share forkchoice-updated signal")
+		}
+		return result, err
+	}
+}
+
+func GetPayloadV3(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payloadId types.PayloadID) (*types.ExecutionPayloadEnvelopeV3, error) {
+	e := log.WithField("payload_id", payloadId)
+	var result types.ExecutionPayloadEnvelopeV3
+	err := cl.CallContext(ctx, &result, "engine_getPayloadV3", payloadId)
+	if err != nil {
+		e = e.WithError(err)
+		if code, ok := Code(err); ok {
+			if code != UnavailablePayload && code != UnknownPayload {
+				e.WithField("code", code).Warn("unexpected error code in get-payload response")
+			} else {
+				e.Warn("unavailable payload in get-payload request")
+			}
+		} else {
+			e.Error("failed to get payload")
+		}
+		return nil, err
+	}
+	e.WithField("blockValue", result.BlockValue).Debug("Received payload")
+	return &result, nil
+}
+
+func NewPayloadV3(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash) (*types.PayloadStatusV1, error) {
+	e := log.WithField("block_hash", payload.BlockHash)
+	var result types.PayloadStatusV1
+	err := cl.CallContext(ctx, &result, "engine_newPayloadV3", payload, versionedHashes, parentBeaconRoot)
+	if err != nil {
+		e.WithError(err).Error("Payload execution failed")
+		return nil, err
+	}
+	e.WithField("status", result.Status).WithField("latestValidHash", result.LatestValidHash).WithField("validationError", result.ValidationError).Debug("Received payload execution result")
+	return &result, nil
+}
+
+func GetPayloadV4(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payloadId types.PayloadID) (*types.ExecutionPayloadEnvelopeV4, error) {
+	e := log.WithField("payload_id", payloadId)
+	var result types.ExecutionPayloadEnvelopeV4
+	err := cl.CallContext(ctx, &result, "engine_getPayloadV4", payloadId)
+	if err != nil {
+		e = e.WithError(err)
+		if code, ok := Code(err); ok {
+			if code != UnavailablePayload && code != UnknownPayload {
+				e.WithField("code", code).Warn("unexpected error code in get-payload response")
+			} else {
+				e.Warn("unavailable payload in get-payload request")
+			}
+		} else {
+			e.Error("failed to get payload")
+		}
+		return nil, err
+	}
+	e.WithField("blockValue", result.BlockValue).WithField("requests", len(result.ExecutionRequests)).Debug("Received payload")
+	return &result, nil
+}
+
+func NewPayloadV4(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash, requests types.ExecutionRequests) (*types.PayloadStatusV1, error) {
+	e := log.WithField("block_hash", payload.BlockHash)
+	var result types.PayloadStatusV1
+	err := cl.CallContext(ctx, &result, "engine_newPayloadV4", payload, versionedHashes, parentBeaconRoot, requests)
+	if err != nil {
+		e.WithError(err).Error("Payload execution failed")
+		return nil, err
+	}
+	e.WithField("status", result.Status).WithField("latestValidHash", result.LatestValidHash).WithField("validationError", result.ValidationError).Debug("Received payload execution result")
+	return &result, nil
+}
+
+func ForkchoiceUpdatedV3(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, head, safe, finalized common.Hash, payload *types.PayloadAttributesV3) (types.ForkchoiceUpdatedResult, error) {
+	heads := &types.ForkchoiceStateV1{HeadBlockHash: head, SafeBlockHash: safe, FinalizedBlockHash: finalized}
+
+	e := log.WithField("head", head).WithField("safe", safe).WithField("finalized", finalized).WithField("payload", payload)
+	e.Debug("Sharing forkchoice-updated signal")
+
+	var result types.ForkchoiceUpdatedResult
+	err := cl.CallContext(ctx, &result, "engine_forkchoiceUpdatedV3", &heads, &payload)
+	if err == nil {
+		e.Debug("Shared forkchoice-updated signal")
+		if payload != nil {
+			e.WithField("payloadId", result.PayloadID).WithField("status", result.PayloadStatus).Debug("Received payload id")
+		}
+		return result, nil
+	} else {
+		e = e.WithError(err)
+		if code, ok := Code(err); ok {
+			e.WithField("code", code).Warn("Unexpected error code in forkchoice-updated response")
+		} else {
+			e.Error("Failed to share forkchoice-updated signal")
+		}
+		return result, err
+	}
+}
+
+func ExchangeTransitionConfigurationV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, config *types.TransitionConfigurationV1) (*types.TransitionConfigurationV1, error) {
+	e := log.WithField("ttd", config.TerminalTotalDifficulty).WithField("terminal_block_hash", config.TerminalBlockHash)
+	var result types.TransitionConfigurationV1
+	err := cl.CallContext(ctx, &result, "engine_exchangeTransitionConfigurationV1", config)
+	if err != nil {
+		e.WithError(err).Error("Failed to exchange transition configuration")
+		return nil, err
+	}
+	e.WithField("result", result).Debug("Exchanged transition configuration")
+	return &result, nil
+}
+
+func GetClientVersionV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, client types.ClientVersionV1) ([]types.ClientVersionV1, error) {
+	var result []types.ClientVersionV1
+	err := cl.CallContext(ctx, &result, "engine_getClientVersionV1", client)
+	if err != nil {
+		log.WithError(err).Error("Failed to get client version")
+		return nil, err
+	}
+	log.WithField("result", result).Debug("Got client version")
+	return result, nil
+}
+
+func GetPayloadBodiesByHashV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, hashes []common.Hash) ([]*types.ExecutionPayloadBodyV1, error) {
+	var result []*types.ExecutionPayloadBodyV1
+	err := cl.CallContext(ctx, &result, "engine_getPayloadBodiesByHashV1", hashes)
+	if err != nil {
+		log.WithError(err).Error("Failed to get payload bodies by hash")
+		return nil, err
+	}
+	log.WithField("requested", len(hashes)).WithField("received", len(result)).Debug("Got payload bodies by hash")
+	return result, nil
+}
+
+func GetPayloadBodiesByRangeV1(ctx context.Context, cl *rpc.Client, log logrus.Ext1FieldLogger, start, count uint64) ([]*types.ExecutionPayloadBodyV1, error) {
+	e := log.WithField("start", start).WithField("count", count)
+	var result []*types.ExecutionPayloadBodyV1
+	err := cl.CallContext(ctx, &result, "engine_getPayloadBodiesByRangeV1", hexutil.Uint64(start), hexutil.Uint64(count))
+	if err != nil {
+		e.WithError(err).Error("Failed to get payload bodies by range")
+		return nil, err
+	}
+	e.WithField("received", len(result)).Debug("Got payload bodies by range")
+	return result, nil
+}
+
 func BlockToPayload(b *ethTypes.Block) (*types.ExecutionPayloadV1, error) {
 	extra := b.Extra()
 	if len(extra) > 32 {
@@ -106,6 +293,71 @@ func BlockToPayload(b *ethTypes.Block) (*types.ExecutionPayloadV1, error) {
 	}, nil
 }
 
+// BlockToPayloadV2 converts a geth block to a payload with the given withdrawals. The geth block has no
+// withdrawals root, so the parent hash is passed in, and the block hash is computed, as defined by the spec.
+func BlockToPayloadV2(b *ethTypes.Block, parentHash common.Hash, withdrawals []*types.Withdrawal) (*types.ExecutionPayloadV2, error) {
+	v1, err := BlockToPayload(b)
+	if err != nil {
+		return nil, err
+	}
+	var fork *types.ForkFields
+	if withdrawals != nil {
+		fork = &types.ForkFields{Withdrawals: withdrawals}
+	}
+	header := b.Header()
+	header.ParentHash = parentHash
+	return &types.ExecutionPayloadV2{
+		ParentHash:    parentHash,
+		FeeRecipient:  v1.FeeRecipient,
+		StateRoot:     v1.StateRoot,
+		ReceiptsRoot:  v1.ReceiptsRoot,
+		LogsBloom:     v1.LogsBloom,
+		Random:        v1.Random,
+		Number:        v1.Number,
+		GasLimit:      v1.GasLimit,
+		GasUsed:       v1.GasUsed,
+		Timestamp:     v1.Timestamp,
+		ExtraData:     v1.ExtraData,
+		BaseFeePerGas: v1.BaseFeePerGas,
+		BlockHash:     types.HeaderHash(header, fork),
+		Transactions:  v1.Transactions,
+		Withdrawals:   withdrawals,
+	}, nil
+}
+
+// BlockToPayloadV3 converts a geth block to a Cancun payload with the given fork fields,
+// like BlockToPayloadV2.
+func BlockToPayloadV3(b *ethTypes.Block, parentHash common.Hash, fork *types.ForkFields) (*types.ExecutionPayloadV3, error) {
+	if fork == nil || fork.BlobGasUsed == nil || fork.ExcessBlobGas == nil || fork.ParentBeaconRoot == nil {
+		return nil, fmt.Errorf("block %d is not a cancun block", b.NumberU64())
+	}
+	v2, err := BlockToPayloadV2(b, parentHash, fork.Withdrawals)
+	if err != nil {
+		return nil, err
+	}
+	header := b.Header()
+	header.ParentHash = parentHash
+	return &types.ExecutionPayloadV3{
+		ParentHash:    v2.ParentHash,
+		FeeRecipient:  v2.FeeRecipient,
+		StateRoot:     v2.StateRoot,
+		ReceiptsRoot:  v2.ReceiptsRoot,
+		LogsBloom:     v2.LogsBloom,
+		Random:        v2.Random,
+		Number:        v2.Number,
+		GasLimit:      v2.GasLimit,
+		GasUsed:       v2.GasUsed,
+		Timestamp:     v2.Timestamp,
+		ExtraData:     v2.ExtraData,
+		BaseFeePerGas: v2.BaseFeePerGas,
+		BlockHash:     types.HeaderHash(header, fork),
+		Transactions:  v2.Transactions,
+		Withdrawals:   v2.Withdrawals,
+		BlobGasUsed:   *fork.BlobGasUsed,
+		ExcessBlobGas: *fork.ExcessBlobGas,
+	}, nil
+}
+
 func encodeTransactions(txs ethTypes.Transactions) ([][]byte, error) {
 	enc := make([][]byte, 0, len(txs))
 	for i, tx := range txs {
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mergemock/types"
+
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+type ErrorCode int
+
+const (
+	MethodNotFound           ErrorCode = -32601
+	InvalidParams            ErrorCode = -32602
+	InternalError            ErrorCode = -32603
+	UnavailablePayload       ErrorCode = -32001
+	UnknownPayload           ErrorCode = -38001
+	InvalidForkchoiceState   ErrorCode = -38002
+	InvalidPayloadAttributes ErrorCode = -38003
+	TooLargeRequest          ErrorCode = -38004
+	UnsupportedFork          ErrorCode = -38005
+)
+
+// Code returns the JSON-RPC error code carried by err, if it carries any.
+func Code(err error) (ErrorCode, bool) {
+	var rpcErr gethRpc.Error
+	if errors.As(err, &rpcErr) {
+		return ErrorCode(rpcErr.ErrorCode()), true
+	}
+	return 0, false
+}
+
+// UnknownPayloadError is returned when a payload is requested by an id the engine doesn't know (anymore).
+// Its code is -38001 of the final specification, or -32001 of the early engine API drafts.
+type UnknownPayloadError struct {
+	PayloadID types.PayloadID
+	Code      ErrorCode
+}
+
+func NewUnknownPayloadError(id types.PayloadID) *UnknownPayloadError {
+	return &UnknownPayloadError{PayloadID: id, Code: UnknownPayload}
+}
+
+func (e *UnknownPayloadError) Error() string {
+	return fmt.Sprintf("unknown payload %s", e.PayloadID)
+}
+
+func (e *UnknownPayloadError) ErrorCode() int { return int(e.Code) }
+
+// UnsupportedForkError is returned when a method version is used for a timestamp of a fork it doesn't support.
+type UnsupportedForkError struct {
+	Method    string
+	Timestamp uint64
+}
+
+func NewUnsupportedForkError(method string, timestamp uint64) *UnsupportedForkError {
+	return &UnsupportedForkError{Method: method, Timestamp: timestamp}
+}
+
+func (e *UnsupportedForkError) Error() string {
+	return fmt.Sprintf("unsupported fork: %s does not support timestamp %d", e.Method, e.Timestamp)
+}
+
+func (e *UnsupportedForkError) ErrorCode() int { return int(UnsupportedFork) }
+
+// MethodNotFoundError is returned for methods the engine doesn't serve, worded like geth's.
+type MethodNotFoundError struct {
+	Method string
+}
+
+func NewMethodNotFoundError(method string) *MethodNotFoundError {
+	return &MethodNotFoundError{Method: method}
+}
+
+func (e *MethodNotFoundError) Error() string {
+	return fmt.Sprintf("the method %s does not exist/is not available", e.Method)
+}
+
+func (e *MethodNotFoundError) ErrorCode() int { return int(MethodNotFound) }
+
+// Error is an engine API error with one of the codes of the specification, for the errors that carry nothing
+// but a message. The message is prefixed with the meaning of the code, as the specification words it.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// NewError builds an engine API error with the given code from a formatted reason.
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	reason := fmt.Sprintf(format, args...)
+	if meaning, ok := codeMeanings[code]; ok {
+		reason = meaning + ": " + reason
+	}
+	return &Error{Code: code, Message: reason}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) ErrorCode() int { return int(e.Code) }
+
+var codeMeanings = map[ErrorCode]string{
+	InvalidParams:            "invalid params",
+	InternalError:            "internal error",
+	UnknownPayload:           "unknown payload",
+	InvalidForkchoiceState:   "invalid forkchoice state",
+	InvalidPayloadAttributes: "invalid payload attributes",
+	TooLargeRequest:          "too large request",
+}
+
+// NewInvalidForkchoiceStateError is returned when the blocks of a forkchoice state are inconsistent.
+func NewInvalidForkchoiceStateError(format string, args ...interface{}) *Error {
+	return NewError(InvalidForkchoiceState, format, args...)
+}
+
+// NewInvalidPayloadAttributesError is returned when a payload cannot be built from the given attributes.
+func NewInvalidPayloadAttributesError(format string, args ...interface{}) *Error {
+	return NewError(InvalidPayloadAttributes, format, args...)
+}
+
+// NewTooLargeRequestError is returned when more payload bodies are requested than the engine serves at once.
+func NewTooLargeRequestError(format string, args ...interface{}) *Error {
+	return NewError(TooLargeRequest, format, args...)
+}
+
+// NewInvalidParamsError is returned when the parameters of a call are malformed, or of the wrong version.
+func NewInvalidParamsError(format string, args ...interface{}) *Error {
+	return NewError(InvalidParams, format, args...)
+}
+
+// NewInternalError is returned when the engine fails to serve a well-formed call.
+func NewInternalError(format string, args ...interface{}) *Error {
+	return NewError(InternalError, format, args...)
+}
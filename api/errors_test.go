@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mergemock/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCodes(t *testing.T) {
+	var id types.PayloadID
+	id[7] = 0x01
+	err := fmt.Errorf("wrapped: %w", NewUnknownPayloadError(id))
+
+	var unknown *UnknownPayloadError
+	require.True(t, errors.As(err, &unknown))
+	require.Equal(t, id, unknown.PayloadID)
+	code, ok := Code(err)
+	require.True(t, ok)
+	require.Equal(t, UnknownPayload, code)
+
+	code, _ = Code(NewInvalidForkchoiceStateError("unknown safe block %d", 1))
+	require.Equal(t, InvalidForkchoiceState, code)
+	code, _ = Code(NewInvalidPayloadAttributesError("timestamp too low"))
+	require.Equal(t, InvalidPayloadAttributes, code)
+	code, _ = Code(NewInvalidParamsError("empty execution request %d", 0))
+	require.Equal(t, InvalidParams, code)
+	code, _ = Code(NewInternalError("failed to build block"))
+	require.Equal(t, InternalError, code)
+
+	// Each code reads like the specification names it.
+	require.Equal(t, "invalid payload attributes: timestamp too low", NewInvalidPayloadAttributesError("timestamp too low").Error())
+	require.Equal(t, "invalid forkchoice state: unknown safe block", NewError(InvalidForkchoiceState, "unknown safe block").Error())
+	require.Equal(t, "custom", NewError(-32000, "custom").Error())
+
+	_, ok = Code(errors.New("plain"))
+	require.False(t, ok)
+}
@@ -0,0 +1,172 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"mergemock/types"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Policies arbitrating the conflicting heads of consensus clients driving the same engine.
+const (
+	ArbitrationLastWriterWins = "last-writer-wins"
+	ArbitrationMajority       = "majority"
+	ArbitrationReject         = "reject"
+)
+
+// Default time the last head of a consensus client is taken into account for, a few slots.
+const defaultArbitrationWindow = 36 * time.Second
+
+type ArbitrationConfig struct {
+	Policy string        `ask:"--policy" help:"How conflicting heads of consensus clients driving the engine are arbitrated: last-writer-wins applies every head, majority only the heads of most clients, reject refuses heads conflicting with the one of another client with error -38002"`
+	Window time.Duration `ask:"--window" help:"Time the last head of a client is taken into account for, clients silent for longer are left out of the arbitration"`
+}
+
+// NewHeadArbiter returns the arbiter of the policy.
+func (c *ArbitrationConfig) NewHeadArbiter(log logrus.Ext1FieldLogger) (*HeadArbiter, error) {
+	switch c.Policy {
+	case ArbitrationLastWriterWins, ArbitrationMajority, ArbitrationReject:
+	default:
+		return nil, fmt.Errorf("unknown arbitration policy %q, expected %s, %s or %s", c.Policy, ArbitrationLastWriterWins, ArbitrationMajority, ArbitrationReject)
+	}
+	if c.Window <= 0 {
+		return nil, fmt.Errorf("arbitration window %s must be positive", c.Window)
+	}
+	return newHeadArbiter(log, *c), nil
+}
+
+func newHeadArbiter(log logrus.Ext1FieldLogger, cfg ArbitrationConfig) *HeadArbiter {
+	return &HeadArbiter{log: log, cfg: cfg, clock: SystemClock{}, heads: make(map[string]clientHead)}
+}
+
+// HeadArbiter tracks the last head of every consensus client driving the engine, to detect and arbitrate split-brain
+// setups where the clients disagree on the head. Heads on the same branch don't conflict: a client lagging behind
+// another one isn't disagreeing with it.
+type HeadArbiter struct {
+	log   logrus.Ext1FieldLogger
+	cfg   ArbitrationConfig
+	clock Clock
+
+	mu        sync.Mutex
+	heads     map[string]clientHead
+	conflicts uint64
+	rejected  uint64
+}
+
+type clientHead struct {
+	head common.Hash
+	seen time.Time
+}
+
+// ClientHead is the last head a consensus client sent.
+type ClientHead struct {
+	Client string      `json:"client"`
+	Head   common.Hash `json:"head"`
+	Seen   time.Time   `json:"seen"`
+	Active bool        `json:"active"`
+}
+
+// conflict reports whether the heads are known blocks on different branches.
+func conflict(chain *MockChain, a, b common.Hash) bool {
+	known := func(h common.Hash) bool {
+		return chain.chain.GetHeaderByHash(chain.ResolveHash(h)) != nil
+	}
+	if a == b || !known(a) || !known(b) {
+		return false
+	}
+	return !chain.IsAncestor(a, b) && !chain.IsAncestor(b, a)
+}
+
+// Arbitrate records the head of the client, and returns the status to answer with right away, without applying the
+// head, if it is outvoted by the majority, or an error if it is rejected.
+func (a *HeadArbiter) Arbitrate(chain *MockChain, client string, head common.Hash) (*types.PayloadStatusV1, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock.Now()
+	var others []string
+	for other, h := range a.heads {
+		if other != client && now.Sub(h.seen) <= a.cfg.Window && conflict(chain, head, h.head) {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		a.log.WithFields(logrus.Fields{
+			"client":       client,
+			"head":         head,
+			"other_client": other,
+			"other_head":   a.heads[other].head,
+			"policy":       a.cfg.Policy,
+		}).Warn("Conflicting forkchoice heads")
+	}
+	if len(others) > 0 {
+		a.conflicts++
+	}
+	if len(others) > 0 && a.cfg.Policy == ArbitrationReject {
+		a.rejected++
+		return nil, api.NewInvalidForkchoiceStateError("head %s conflicts with the head %s of client %s", head, a.heads[others[0]].head, others[0])
+	}
+	a.heads[client] = clientHead{head, now}
+	if len(others) > 0 && a.cfg.Policy == ArbitrationMajority {
+		if winner := a.majority(chain, now, head); winner != head {
+			a.log.WithFields(logrus.Fields{"client": client, "head": head, "majority_head": winner}).Warn("Head outvoted by the majority of clients, not applying it")
+			return &types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &head}, nil
+		}
+	}
+	return nil, nil
+}
+
+// majority returns the head supported by more active clients than any conflicting head, the clients whose head is a
+// descendant supporting it too. Ties are won by the given head.
+func (a *HeadArbiter) majority(chain *MockChain, now time.Time, head common.Hash) common.Hash {
+	support := func(candidate common.Hash) int {
+		n := 0
+		for _, h := range a.heads {
+			if now.Sub(h.seen) <= a.cfg.Window && (h.head == candidate || chain.IsAncestor(candidate, h.head)) {
+				n++
+			}
+		}
+		return n
+	}
+	best, votes := head, support(head)
+	for _, h := range a.heads {
+		if now.Sub(h.seen) > a.cfg.Window || !conflict(chain, head, h.head) {
+			continue
+		}
+		if n := support(h.head); n > votes {
+			best, votes = h.head, n
+		}
+	}
+	return best
+}
+
+// Heads returns the last heads of the clients, ordered by client.
+func (a *HeadArbiter) Heads() []ClientHead {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock.Now()
+	heads := make([]ClientHead, 0, len(a.heads))
+	for client, h := range a.heads {
+		heads = append(heads, ClientHead{client, h.head, h.seen, now.Sub(h.seen) <= a.cfg.Window})
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i].Client < heads[j].Client })
+	return heads
+}
+
+// Conflicts returns the number of heads that conflicted with the head of another client, and how many were rejected.
+func (a *HeadArbiter) Conflicts() (conflicts uint64, rejected uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conflicts, a.rejected
+}
+
+// ClientHeads returns the last forkchoice head of every consensus client driving the engine.
+func (b *MockBackend) ClientHeads(ctx context.Context) []ClientHead {
+	return b.engine.arbiter.Heads()
+}
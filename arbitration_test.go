@@ -0,0 +1,74 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineHeadArbitration(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []string{ArbitrationLastWriterWins, ArbitrationMajority, ArbitrationReject} {
+		t.Run(policy, func(t *testing.T) {
+			te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+				cmd.Arbitration.Policy = policy
+			})
+			client := func(name string) *rpc.Client {
+				c, err := rpc.DialContext(ctx, "http://"+te.ListenAddr, te.jwtSecret)
+				require.NoError(t, err)
+				t.Cleanup(c.Close)
+				c.SetHeader("User-Agent", name)
+				return c
+			}
+			alice, bob, carol := client("alice"), client("bob"), client("carol")
+
+			// Two competing children of genesis.
+			genesis := te.mockChain().CurrentHeader()
+			a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+			b := te.buildPayload(t, genesis.Hash(), genesis.Time+13, common.Hash{0x02})
+			require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+			require.Equal(t, types.ExecutionValid, te.newPayload(t, b))
+			update := func(c *rpc.Client, head common.Hash) error {
+				_, err := api.ForkchoiceUpdatedV1(ctx, c, te.log, head, genesis.Hash(), genesis.Hash(), nil)
+				return err
+			}
+			require.NoError(t, update(alice, a.BlockHash))
+			require.NoError(t, update(carol, a.BlockHash))
+
+			err := update(bob, b.BlockHash)
+			stats := NewMockBackend(te.backend).Stats(ctx)
+			require.Equal(t, uint64(1), stats.HeadConflicts)
+			head := NewMockBackend(te.backend).State(ctx).Head
+			switch policy {
+			case ArbitrationLastWriterWins:
+				require.NoError(t, err)
+				require.Equal(t, b.BlockHash, head)
+			case ArbitrationMajority:
+				require.NoError(t, err)
+				require.Equal(t, a.BlockHash, head, "bob is outvoted by alice and carol")
+			case ArbitrationReject:
+				code, ok := api.Code(err)
+				require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+				require.Equal(t, api.InvalidForkchoiceState, code)
+				require.Equal(t, a.BlockHash, head)
+				require.Equal(t, uint64(1), stats.RejectedHeads)
+			}
+
+			heads := NewMockBackend(te.backend).ClientHeads(ctx)
+			clients := make(map[string]common.Hash)
+			for _, h := range heads {
+				clients[h.Client] = h.Head
+			}
+			require.Equal(t, a.BlockHash, clients["127.0.0.1 alice"])
+			require.Equal(t, a.BlockHash, clients["127.0.0.1 carol"])
+			_, recorded := clients["127.0.0.1 bob"]
+			require.Equal(t, policy != ArbitrationReject, recorded, "rejected heads aren't recorded")
+		})
+	}
+}
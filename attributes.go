@@ -0,0 +1,67 @@
+package mergemock
+
+import (
+	"mergemock/api"
+	mmTypes "mergemock/types"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type AttributesConfig struct {
+	Strict   bool          `ask:"--strict" help:"Validate payload attributes strictly, answering error -38003 for a zero or reused prevRandao, a zero fee recipient or parent beacon block root, and gaps in withdrawal indices (lenient if false)"`
+	SlotTime time.Duration `ask:"--slot-time" help:"Slot duration the timestamps of strictly validated payload attributes must be a whole number of after their parent (0 to accept any)"`
+}
+
+// NewAttributeValidator returns the validator of the config, nil if payload attributes are validated leniently.
+func (c *AttributesConfig) NewAttributeValidator() *AttributeValidator {
+	if !c.Strict {
+		return nil
+	}
+	return &AttributeValidator{slotTime: uint64(c.SlotTime / time.Second)}
+}
+
+// AttributeValidator catches payload attributes a correct consensus client never sends, on top of the checks
+// of the spec that are always made, to help find attribute bugs of consensus clients.
+type AttributeValidator struct {
+	slotTime uint64
+}
+
+// Check returns an invalid payload attributes error if the attributes of a payload built on parent, with the
+// parent beacon block root from Cancun on, are not those of a correct consensus client. The fork fields of
+// parent carry its withdrawals, nil if it has none.
+func (v *AttributeValidator) Check(parent *types.Header, parentFork *mmTypes.ForkFields, attributes *mmTypes.PayloadAttributesV2, parentBeaconRoot *common.Hash) error {
+	if v == nil || parent == nil {
+		return nil
+	}
+	if attributes.PrevRandao == (common.Hash{}) {
+		return api.NewInvalidPayloadAttributesError("zero prevRandao")
+	}
+	if parent.Difficulty.Sign() == 0 && attributes.PrevRandao == parent.MixDigest {
+		return api.NewInvalidPayloadAttributesError("prevRandao %s reused from the parent block", attributes.PrevRandao)
+	}
+	if attributes.SuggestedFeeRecipient == (common.Address{}) {
+		return api.NewInvalidPayloadAttributesError("zero suggested fee recipient")
+	}
+	if parentBeaconRoot != nil && *parentBeaconRoot == (common.Hash{}) {
+		return api.NewInvalidPayloadAttributesError("zero parent beacon block root")
+	}
+	if v.slotTime > 0 && attributes.Timestamp > parent.Time && (attributes.Timestamp-parent.Time)%v.slotTime != 0 {
+		return api.NewInvalidPayloadAttributesError("timestamp %d is not a whole number of %ds slots after the parent timestamp %d", attributes.Timestamp, v.slotTime, parent.Time)
+	}
+	// withdrawal indices are a sequence across blocks
+	if len(attributes.Withdrawals) > 0 {
+		next := attributes.Withdrawals[0].Index
+		if parentFork != nil && len(parentFork.Withdrawals) > 0 {
+			next = parentFork.Withdrawals[len(parentFork.Withdrawals)-1].Index + 1
+		}
+		for i, w := range attributes.Withdrawals {
+			if w.Index != next {
+				return api.NewInvalidPayloadAttributesError("withdrawal %d has index %d, expected %d", i, w.Index, next)
+			}
+			next++
+		}
+	}
+	return nil
+}
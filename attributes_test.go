@@ -0,0 +1,67 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictAttributes(t *testing.T) {
+	ctx := context.Background()
+	te := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 24}), func(cmd *EngineCmd) {
+		cmd.Attributes.Strict = true
+		cmd.Attributes.SlotTime = 12 * time.Second
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x09})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	te.setHead(t, payload.BlockHash)
+
+	prepare := func(attributes *types.PayloadAttributesV2) error {
+		_, err := api.ForkchoiceUpdatedV2(ctx, te.client, te.log, payload.BlockHash, payload.BlockHash, payload.BlockHash, attributes)
+		return err
+	}
+	valid := func() *types.PayloadAttributesV2 {
+		return &types.PayloadAttributesV2{
+			Timestamp:             payload.Timestamp + 12,
+			PrevRandao:            common.Hash{0x02},
+			SuggestedFeeRecipient: common.Address{0x02},
+			Withdrawals:           []*types.Withdrawal{{Index: 0}, {Index: 1}},
+		}
+	}
+	require.NoError(t, prepare(valid()))
+	for name, change := range map[string]func(a *types.PayloadAttributesV2){
+		"zero prevRandao":       func(a *types.PayloadAttributesV2) { a.PrevRandao = common.Hash{} },
+		"reused prevRandao":     func(a *types.PayloadAttributesV2) { a.PrevRandao = payload.Random },
+		"zero fee recipient":    func(a *types.PayloadAttributesV2) { a.SuggestedFeeRecipient = common.Address{} },
+		"timestamp within slot": func(a *types.PayloadAttributesV2) { a.Timestamp += 5 },
+		"withdrawal index gap":  func(a *types.PayloadAttributesV2) { a.Withdrawals[1].Index = 2 },
+	} {
+		attributes := valid()
+		change(attributes)
+		code, ok := api.Code(prepare(attributes))
+		require.True(t, ok, name)
+		require.Equal(t, api.InvalidPayloadAttributes, code, name)
+	}
+
+	// Withdrawal indices continue from the last withdrawal of the parent.
+	_, envelope, err := te.buildPayloadV2(t, payload.BlockHash, payload.Timestamp+12, []*types.Withdrawal{{Index: 0}, {Index: 1}})
+	require.NoError(t, err)
+	status, err := api.NewPayloadV2(ctx, te.client, te.log, envelope.ExecutionPayload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	shanghai := envelope.ExecutionPayload
+	te.setHead(t, shanghai.BlockHash)
+	next := &types.PayloadAttributesV2{Timestamp: shanghai.Timestamp + 12, PrevRandao: common.Hash{0x03}, SuggestedFeeRecipient: common.Address{0x02}, Withdrawals: []*types.Withdrawal{{Index: 0}}}
+	_, err = api.ForkchoiceUpdatedV2(ctx, te.client, te.log, shanghai.BlockHash, shanghai.BlockHash, shanghai.BlockHash, next)
+	code, _ := api.Code(err)
+	require.Equal(t, api.InvalidPayloadAttributes, code)
+	next.Withdrawals[0].Index = 2
+	_, err = api.ForkchoiceUpdatedV2(ctx, te.client, te.log, shanghai.BlockHash, shanghai.BlockHash, shanghai.BlockHash, next)
+	require.NoError(t, err)
+}
@@ -0,0 +1,77 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Trigger journaled for the blocks produced by the slot timer.
+const slotTimerTrigger = "slot-timer"
+
+// produceBlocks advances the chain every slot of the clock until stopped, so that tooling following the chain through
+// the eth namespace sees it grow without a consensus client driving the engine.
+func (e *EngineBackend) produceBlocks(clock Clock, slot time.Duration, slotsPerEpoch uint64, stop <-chan struct{}) {
+	ticker := clock.NewTicker(slot)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			if _, err := e.produceSlot(now, slot, slotsPerEpoch); err != nil {
+				e.log.WithError(err).Error("Failed producing block of slot")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// produceSlot builds a block with the transactions of the pool and generator on the head, stores it and makes it the
+// head, unless a block was already made the head during the slot, e.g. by a consensus client, block production is
+// frozen or the terminal total difficulty isn't reached yet. Every epoch the safe and finalized blocks follow, one and
+// two epochs behind the head. It returns the produced block, nil if the slot was skipped.
+func (e *EngineBackend) produceSlot(now time.Time, slot time.Duration, slotsPerEpoch uint64) (*ethTypes.Block, error) {
+	head := e.mockChain.chain.CurrentBlock()
+	if time.Unix(int64(head.Time()), 0).Add(slot).After(now) || e.control.Frozen() || !e.mockChain.TTDReached() {
+		return nil, nil
+	}
+	number := head.NumberU64() + 1
+	creator := censoringCreator(e.mockChain.pool.Creator(e.txs.Creator()), e.censored)
+	prevRandao := common.BigToHash(new(big.Int).SetUint64(number))
+	block, _, _, err := e.mockChain.buildBlock(e.mockChain.SpecHash(head.Hash()), common.Address{}, uint64(now.Unix()),
+		e.gasLimits.For(number, head.GasLimit()), creator, prevRandao, []byte{}, nil, nil, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build block %d: %v", number, err)
+	}
+	if err := e.mockChain.moveHead(block, head, slotTimerTrigger); err != nil {
+		return nil, err
+	}
+	hash := e.mockChain.SpecHash(block.Hash())
+	e.timeline.Head(e.mockChain, hash)
+	e.mockChain.pool.Prune(e.mockChain.chain)
+
+	heads := e.control.Forkchoice()
+	heads.HeadBlockHash = hash
+	if slotsPerEpoch != 0 && number%slotsPerEpoch == 0 {
+		if safe, err := e.mockChain.Ancestor(hash, slotsPerEpoch); err == nil {
+			heads.SafeBlockHash = safe
+		}
+		if finalized, err := e.mockChain.Ancestor(hash, 2*slotsPerEpoch); err == nil {
+			heads.FinalizedBlockHash = finalized
+		}
+	}
+	e.control.SetForkchoice(heads)
+	e.log.WithFields(logrus.Fields{
+		"number":    number,
+		"hash":      hash,
+		"txs":       len(block.Transactions()),
+		"gas_used":  block.GasUsed(),
+		"safe":      heads.SafeBlockHash,
+		"finalized": heads.FinalizedBlockHash,
+	}).Info("Produced block of slot")
+	return block, nil
+}
@@ -0,0 +1,56 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineSlotTimer(t *testing.T) {
+	te := newTestEngine(t)
+	mc := te.mockChain()
+	slot := 12 * time.Second
+	genesis := mc.CurrentHeader()
+	start := time.Unix(int64(genesis.Time), 0).Add(slot)
+
+	// Every slot advances the head, with safe and finalized blocks following every epoch.
+	for i := 0; i < 4; i++ {
+		block, err := te.backend.produceSlot(start.Add(time.Duration(i)*slot), slot, 2)
+		require.NoError(t, err)
+		require.NotNil(t, block)
+		require.Equal(t, block.Hash(), mc.CurrentHeader().Hash())
+	}
+	head := mc.CurrentHeader()
+	require.Equal(t, genesis.Number.Uint64()+4, head.Number.Uint64())
+	state := NewMockBackend(te.backend).State(context.Background())
+	require.Equal(t, mc.SpecHash(head.Hash()), state.Head)
+	safe, err := mc.Ancestor(state.Head, 2)
+	require.NoError(t, err)
+	finalized, err := mc.Ancestor(state.Head, 4)
+	require.NoError(t, err)
+	require.Equal(t, safe, state.Safe)
+	require.Equal(t, finalized, state.Finalized)
+	entries := NewMockBackend(te.backend).GetJournal(context.Background(), nil)
+	require.Equal(t, slotTimerTrigger, entries[len(entries)-1].Trigger)
+
+	// A head made during the slot, e.g. by a consensus client, skips the slot.
+	block, err := te.backend.produceSlot(time.Unix(int64(head.Time), 0).Add(slot-time.Second), slot, 2)
+	require.NoError(t, err)
+	require.Nil(t, block)
+
+	// The timer keeps producing blocks until stopped.
+	clock := NewFakeClock(time.Unix(int64(head.Time), 0))
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		te.backend.produceBlocks(clock, slot, 2, stop)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		clock.Advance(slot)
+		return mc.CurrentHeader().Number.Uint64() >= head.Number.Uint64()+2
+	}, 5*time.Second, 10*time.Millisecond)
+	close(stop)
+	<-done
+}
@@ -0,0 +1,48 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/rpc"
+	"mergemock/types"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ExecutionBackend serves the engine namespace. EngineBackend implements it on top of a MockChain, other
+// backends, e.g. proxying to a real engine or replaying recorded responses, plug into the same servers.
+type ExecutionBackend interface {
+	NewPayloadV1(ctx context.Context, payload *types.ExecutionPayloadV1) (*types.PayloadStatusV1, error)
+	NewPayloadV2(ctx context.Context, payload *types.ExecutionPayloadV2) (*types.PayloadStatusV1, error)
+	NewPayloadV3(ctx context.Context, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash) (*types.PayloadStatusV1, error)
+
+	ForkchoiceUpdatedV1(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV1) (*types.ForkchoiceUpdatedResult, error)
+	ForkchoiceUpdatedV2(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV2) (*types.ForkchoiceUpdatedResult, error)
+	ForkchoiceUpdatedV3(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV3) (*types.ForkchoiceUpdatedResult, error)
+
+	GetPayloadV1(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadV1, error)
+	GetPayloadV2(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadEnvelopeV2, error)
+	GetPayloadV3(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadEnvelopeV3, error)
+
+	ExchangeTransitionConfigurationV1(ctx context.Context, config *types.TransitionConfigurationV1) (*types.TransitionConfigurationV1, error)
+}
+
+var _ ExecutionBackend = (*EngineBackend)(nil)
+
+// Namespace is a JSON-RPC namespace served next to the engine namespace, e.g. eth or mock.
+type Namespace interface {
+	Register(srv *rpc.Server) error
+}
+
+// NewEngineRPCServer serves the backend as the authenticated engine namespace, and the other namespaces.
+func NewEngineRPCServer(backend ExecutionBackend, namespaces ...Namespace) (*rpc.Server, error) {
+	srv, err := rpc.NewServer("engine", backend, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range namespaces {
+		if err := ns.Register(srv); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
@@ -0,0 +1,162 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/binary"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend is an in-memory execution backend: it accepts every payload, and builds empty payloads on the head.
+type fakeBackend struct {
+	mu       sync.Mutex
+	head     common.Hash
+	payloads map[types.PayloadID]*types.ExecutionPayloadV2
+	known    map[common.Hash]bool
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{payloads: make(map[types.PayloadID]*types.ExecutionPayloadV2), known: make(map[common.Hash]bool)}
+}
+
+func (f *fakeBackend) newPayload(hash common.Hash) *types.PayloadStatusV1 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.known[hash] = true
+	return &types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &hash}
+}
+
+func (f *fakeBackend) NewPayloadV1(ctx context.Context, payload *types.ExecutionPayloadV1) (*types.PayloadStatusV1, error) {
+	return f.newPayload(payload.BlockHash), nil
+}
+
+func (f *fakeBackend) NewPayloadV2(ctx context.Context, payload *types.ExecutionPayloadV2) (*types.PayloadStatusV1, error) {
+	return f.newPayload(payload.BlockHash), nil
+}
+
+func (f *fakeBackend) NewPayloadV3(ctx context.Context, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash) (*types.PayloadStatusV1, error) {
+	return f.newPayload(payload.BlockHash), nil
+}
+
+func (f *fakeBackend) forkchoiceUpdated(heads *types.ForkchoiceStateV1, timestamp *uint64) *types.ForkchoiceUpdatedResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head = heads.HeadBlockHash
+	result := &types.ForkchoiceUpdatedResult{PayloadStatus: types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}}
+	if timestamp != nil {
+		var id types.PayloadID
+		binary.BigEndian.PutUint64(id[:], uint64(len(f.payloads)+1))
+		f.payloads[id] = &types.ExecutionPayloadV2{
+			ParentHash:    heads.HeadBlockHash,
+			Timestamp:     *timestamp,
+			BlockHash:     common.Hash{byte(len(f.payloads) + 1)},
+			BaseFeePerGas: common.Big1,
+			ExtraData:     []byte{},
+			Transactions:  [][]byte{},
+		}
+		result.PayloadID = &id
+	}
+	return result
+}
+
+func (f *fakeBackend) ForkchoiceUpdatedV1(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV1) (*types.ForkchoiceUpdatedResult, error) {
+	if attributes == nil {
+		return f.forkchoiceUpdated(heads, nil), nil
+	}
+	return f.forkchoiceUpdated(heads, &attributes.Timestamp), nil
+}
+
+func (f *fakeBackend) ForkchoiceUpdatedV2(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV2) (*types.ForkchoiceUpdatedResult, error) {
+	if attributes == nil {
+		return f.forkchoiceUpdated(heads, nil), nil
+	}
+	return f.forkchoiceUpdated(heads, &attributes.Timestamp), nil
+}
+
+func (f *fakeBackend) ForkchoiceUpdatedV3(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV3) (*types.ForkchoiceUpdatedResult, error) {
+	if attributes == nil {
+		return f.forkchoiceUpdated(heads, nil), nil
+	}
+	return f.forkchoiceUpdated(heads, &attributes.Timestamp), nil
+}
+
+func (f *fakeBackend) getPayload(id types.PayloadID) (*types.ExecutionPayloadV2, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload, ok := f.payloads[id]
+	if !ok {
+		return nil, api.NewUnknownPayloadError(id)
+	}
+	return payload, nil
+}
+
+func (f *fakeBackend) GetPayloadV1(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadV1, error) {
+	payload, err := f.getPayload(id)
+	if err != nil {
+		return nil, err
+	}
+	return payload.PayloadV1(), nil
+}
+
+func (f *fakeBackend) GetPayloadV2(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadEnvelopeV2, error) {
+	payload, err := f.getPayload(id)
+	if err != nil {
+		return nil, err
+	}
+	return &types.ExecutionPayloadEnvelopeV2{ExecutionPayload: payload, BlockValue: (*hexutil.Big)(common.Big0)}, nil
+}
+
+func (f *fakeBackend) GetPayloadV3(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadEnvelopeV3, error) {
+	return nil, api.NewUnsupportedForkError("engine_getPayloadV3", 0)
+}
+
+func (f *fakeBackend) ExchangeTransitionConfigurationV1(ctx context.Context, config *types.TransitionConfigurationV1) (*types.TransitionConfigurationV1, error) {
+	return config, nil
+}
+
+func TestFakeExecutionBackend(t *testing.T) {
+	ctx := context.Background()
+	log := logrus.New()
+	fake := newFakeBackend()
+	srv, err := NewEngineRPCServer(fake)
+	require.NoError(t, err)
+	addr := freeAddr(t)
+	httpSrv := rpc.NewHTTPServer(ctx, log, srv, addr, rpc.Timeout{}, nil)
+	go httpSrv.ListenAndServe()
+	t.Cleanup(func() { httpSrv.Close() })
+
+	secret, err := readJwtSecret(newJwt(t))
+	require.NoError(t, err)
+	client, err := rpc.DialContext(ctx, "http://"+addr, secret)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	parent := common.Hash{0xaa}
+	var result types.ForkchoiceUpdatedResult
+	require.Eventually(t, func() bool {
+		result, err = api.ForkchoiceUpdatedV1(ctx, client, log, parent, parent, parent, &types.PayloadAttributesV1{Timestamp: 12})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NotNil(t, result.PayloadID)
+	payload, err := api.GetPayloadV1(ctx, client, log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, parent, payload.ParentHash)
+	require.Equal(t, uint64(12), payload.Timestamp)
+
+	status, err := api.NewPayloadV1(ctx, client, log, payload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	require.True(t, fake.known[payload.BlockHash])
+
+	_, err = api.GetPayloadV1(ctx, client, log, types.PayloadID{0xff})
+	require.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package mergemock
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	mmTypes "mergemock/types"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPayloadBodies is the most payload bodies a getPayloadBodies call may request, as per spec.
+const maxPayloadBodies = 1024
+
+type BackfillConfig struct {
+	Blocks   uint64        `ask:"--blocks" help:"Blocks to generate on the head on start, for checkpoint-synced consensus clients to backfill (0 to generate none)"`
+	Txs      uint64        `ask:"--txs" help:"Transfers between the test accounts in every generated block with transactions"`
+	TxEvery  uint64        `ask:"--tx-every" help:"Generate transactions in every this many blocks only, the others are empty"`
+	SlotTime time.Duration `ask:"--slot-time" help:"Time between the generated blocks, timestamped so that the last one is a slot before the start if the genesis allows"`
+}
+
+func (c *BackfillConfig) Default() {
+	c.TxEvery = 1
+	c.SlotTime = 12 * time.Second
+}
+
+// Backfill generates the blocks of the config on the head as fast as it can, and returns the new head. The blocks
+// are stored without being journaled or announced, like a history synced from other nodes.
+func (c *MockChain) Backfill(log logrus.Ext1FieldLogger, cfg *BackfillConfig, accounts TestAccounts, now time.Time) (*types.Header, error) {
+	head := c.CurrentHeader()
+	if cfg.Blocks == 0 {
+		return head, nil
+	}
+	if !c.TTDReached() {
+		return nil, errors.New("the terminal total difficulty isn't reached, mine the proof-of-work chain on start to backfill")
+	}
+	slot := uint64(cfg.SlotTime / time.Second)
+	if slot == 0 {
+		return nil, fmt.Errorf("slot time %s is shorter than a second", cfg.SlotTime)
+	}
+	every := cfg.TxEvery
+	if every == 0 {
+		every = 1
+	}
+	empty := (*TxGenerator)(nil).Creator()
+	transfers := empty
+	if cfg.Txs != 0 {
+		if len(accounts.accounts) == 0 {
+			return nil, errors.New("no test accounts to send the transfers of generated blocks from")
+		}
+		txCfg := TxGenConfig{Mode: TxModeTransfer, Count: cfg.Txs, GasTarget: 100, CallGas: 100_000, Accounts: accounts, Seed: 1}
+		gen, err := txCfg.NewTxGenerator(head.Time)
+		if err != nil {
+			return nil, err
+		}
+		transfers = gen.Creator()
+	}
+
+	start := head.Time + slot
+	if last := uint64(now.Unix()) - slot; last >= start+(cfg.Blocks-1)*slot {
+		start = last - (cfg.Blocks-1)*slot
+	}
+	begin := time.Now()
+	for i := uint64(0); i < cfg.Blocks; i++ {
+		creator := empty
+		if i%every == 0 {
+			creator = transfers
+		}
+		number := head.Number.Uint64() + 1
+		block, _, _, err := c.buildBlock(c.SpecHash(head.Hash()), common.Address{}, start+i*slot, head.GasLimit, creator,
+			common.BigToHash(new(big.Int).SetUint64(number)), []byte{}, nil, nil, nil, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate block %d: %v", number, err)
+		}
+		head = block.Header()
+		if (i+1)%10_000 == 0 {
+			log.WithFields(logrus.Fields{"generated": i + 1, "number": number, "elapsed": time.Since(begin)}).Info("Generating backfill blocks")
+		}
+	}
+	c.pool.Prune(c.chain)
+	log.WithFields(logrus.Fields{"blocks": cfg.Blocks, "head": c.SpecHash(head.Hash()), "number": head.Number, "elapsed": time.Since(begin)}).Info("Generated backfill blocks")
+	return head, nil
+}
+
+// payloadBody returns the payload body of the block, nil if it's unknown. Its withdrawals are nil before Shanghai.
+func (c *MockChain) payloadBody(block *types.Block) *mmTypes.ExecutionPayloadBodyV1 {
+	if block == nil {
+		return nil
+	}
+	body := &mmTypes.ExecutionPayloadBodyV1{Transactions: make([]hexutil.Bytes, 0, len(block.Transactions()))}
+	for _, tx := range block.Transactions() {
+		enc, err := tx.MarshalBinary()
+		if err != nil {
+			return nil
+		}
+		body.Transactions = append(body.Transactions, enc)
+	}
+	if c.IsShanghai(block.Time()) {
+		if body.Withdrawals = c.Withdrawals(block.Hash()); body.Withdrawals == nil {
+			body.Withdrawals = []*mmTypes.Withdrawal{}
+		}
+	}
+	return body
+}
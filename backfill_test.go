@@ -0,0 +1,81 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)), func(cmd *EngineCmd) {
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Backfill = BackfillConfig{Blocks: 40, Txs: 2, TxEvery: 4, SlotTime: 12 * time.Second}
+	})
+	mc := te.mockChain()
+	head := mc.CurrentHeader()
+	require.Equal(t, uint64(40), head.Number.Uint64())
+	require.LessOrEqual(t, head.Time, uint64(time.Now().Unix())-12, "the last block is a slot in the past")
+	require.Equal(t, head.Time-39*12, mc.chain.GetHeaderByNumber(1).Time)
+
+	// The range ends at the head, every fourth block has the transfers.
+	bodies, err := api.GetPayloadBodiesByRangeV1(ctx, te.client, te.log, 1, 50)
+	require.NoError(t, err)
+	require.Len(t, bodies, 40)
+	for i, body := range bodies {
+		block := mc.chain.GetBlockByNumber(uint64(i + 1))
+		require.Len(t, body.Transactions, len(block.Transactions()))
+		if i%4 == 0 {
+			require.Len(t, body.Transactions, 2)
+		} else {
+			require.Empty(t, body.Transactions)
+		}
+		require.Nil(t, body.Withdrawals, "no withdrawals before Shanghai")
+	}
+	enc, err := mc.chain.GetBlockByNumber(5).Transactions()[1].MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, enc, []byte(bodies[4].Transactions[1]))
+	bodies, err = api.GetPayloadBodiesByRangeV1(ctx, te.client, te.log, 41, 10)
+	require.NoError(t, err)
+	require.Empty(t, bodies)
+
+	bodies, err = api.GetPayloadBodiesByHashV1(ctx, te.client, te.log, []common.Hash{mc.SpecHash(head.Hash()), {0x01}})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+	require.NotNil(t, bodies[0])
+	require.Nil(t, bodies[1])
+
+	for _, tc := range []struct {
+		start, count uint64
+		code         api.ErrorCode
+	}{{0, 1, api.InvalidParams}, {1, 0, api.InvalidParams}, {1, maxPayloadBodies + 1, api.TooLargeRequest}} {
+		_, err := api.GetPayloadBodiesByRangeV1(ctx, te.client, te.log, tc.start, tc.count)
+		code, ok := api.Code(err)
+		require.True(t, ok, "%v", err)
+		require.Equal(t, tc.code, code)
+	}
+	_, err = api.GetPayloadBodiesByHashV1(ctx, te.client, te.log, make([]common.Hash, maxPayloadBodies+1))
+	code, ok := api.Code(err)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, api.TooLargeRequest, code)
+}
+
+func TestBackfillWithdrawals(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 0}), func(cmd *EngineCmd) {
+		cmd.Backfill = BackfillConfig{Blocks: 3, SlotTime: 12 * time.Second}
+	})
+	bodies, err := api.GetPayloadBodiesByRangeV1(context.Background(), te.client, te.log, 1, 3)
+	require.NoError(t, err)
+	require.Len(t, bodies, 3)
+	for _, body := range bodies {
+		require.NotNil(t, body.Withdrawals)
+		require.Empty(t, body.Withdrawals)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package mergemock
 
 import (
 	"crypto/ecdsa"
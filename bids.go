@@ -0,0 +1,118 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Bid strategies, the value of the bids of the relay.
+const (
+	BidFixed      = "fixed"      // always the bid value
+	BidRandom     = "random"     // uniformly random between the bid value and the maximum
+	BidEscalating = "escalating" // the bid value, plus the step for every slot
+	BidZero       = "zero"       // always 0
+)
+
+type BidConfig struct {
+	Strategy         string  `ask:"--strategy" help:"Value of bids: fixed, random, escalating or zero"`
+	Value            uint64  `ask:"--value" help:"Value of fixed bids, the minimum of random bids and the value of escalating bids at slot 0, in wei"`
+	Max              uint64  `ask:"--max" help:"Maximum value of random bids, in wei"`
+	Step             uint64  `ask:"--step" help:"Increase of escalating bids per slot, in wei"`
+	NoBidProbability float64 `ask:"--no-bid-probability" help:"Probability of answering getHeader without a bid"`
+	Seed             int64   `ask:"--seed" help:"Seed of random bids and missing bids (0 for a random seed)"`
+}
+
+func (c *BidConfig) Default() {
+	c.Strategy = BidFixed
+	c.Value = 1
+}
+
+// NewBidder validates the config and returns its bidder.
+func (c *BidConfig) NewBidder() (*Bidder, error) {
+	switch c.Strategy {
+	case BidFixed, BidEscalating, BidZero:
+	case BidRandom:
+		if c.Max < c.Value {
+			return nil, fmt.Errorf("maximum %d of random bids below their minimum %d", c.Max, c.Value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown bid strategy %q", c.Strategy)
+	}
+	if c.NoBidProbability < 0 || c.NoBidProbability > 1 {
+		return nil, fmt.Errorf("no-bid probability %v out of range [0, 1]", c.NoBidProbability)
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Bidder{cfg: *c, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// Bidder decides on the value of the bids of the relay, to test bid comparison and local block fallback.
+type Bidder struct {
+	cfg BidConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// Bid returns the value to bid at the slot, false if the relay doesn't bid.
+func (b *Bidder) Bid(slot uint64) (*big.Int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.NoBidProbability > 0 && b.rng.Float64() < b.cfg.NoBidProbability {
+		return nil, false
+	}
+	value := new(big.Int).SetUint64(b.cfg.Value)
+	switch b.cfg.Strategy {
+	case BidRandom:
+		span := new(big.Int).SetUint64(b.cfg.Max - b.cfg.Value)
+		value.Add(value, new(big.Int).Rand(b.rng, span.Add(span, common.Big1)))
+	case BidEscalating:
+		step := new(big.Int).SetUint64(b.cfg.Step)
+		value.Add(value, step.Mul(step, new(big.Int).SetUint64(slot)))
+	case BidZero:
+		value.SetUint64(0)
+	}
+	return value, true
+}
+
+// parseCensored parses the hex addresses whose transactions are censored.
+func parseCensored(addresses []string) (map[common.Address]bool, error) {
+	censored := make(map[common.Address]bool, len(addresses))
+	for _, addr := range addresses {
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid censored address %q", addr)
+		}
+		censored[common.HexToAddress(addr)] = true
+	}
+	return censored, nil
+}
+
+// censoringCreator leaves the transactions sent from censored addresses out of those of the creator.
+func censoringCreator(next TransactionsCreator, censored map[common.Address]bool) TransactionsCreator {
+	if len(censored) == 0 {
+		return next
+	}
+	return TransactionsCreator{next.accounts, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *types.Header, cfg vm.Config, accounts []TestAccount) []*types.Transaction {
+		signer := types.MakeSigner(config, header.Number)
+		var txs []*types.Transaction
+		for _, tx := range next.fn(config, bc, statedb, header, cfg, accounts) {
+			if from, err := types.Sender(signer, tx); err == nil && censored[from] {
+				continue
+			}
+			txs = append(txs, tx)
+		}
+		return txs
+	}}
+}
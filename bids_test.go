@@ -0,0 +1,74 @@
+package mergemock
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBidStrategies(t *testing.T) {
+	bid := func(cfg BidConfig, slot uint64) uint64 {
+		bidder, err := cfg.NewBidder()
+		require.NoError(t, err)
+		value, ok := bidder.Bid(slot)
+		require.True(t, ok)
+		return value.Uint64()
+	}
+	require.Equal(t, uint64(5), bid(BidConfig{Strategy: BidFixed, Value: 5}, 7))
+	require.Equal(t, uint64(0), bid(BidConfig{Strategy: BidZero, Value: 5}, 7))
+	require.Equal(t, uint64(26), bid(BidConfig{Strategy: BidEscalating, Value: 5, Step: 3}, 7))
+	for seed := int64(1); seed <= 20; seed++ {
+		value := bid(BidConfig{Strategy: BidRandom, Value: 5, Max: 8, Seed: seed}, 0)
+		require.True(t, value >= 5 && value <= 8, value)
+	}
+
+	bidder, err := (&BidConfig{Strategy: BidFixed, NoBidProbability: 1}).NewBidder()
+	require.NoError(t, err)
+	_, ok := bidder.Bid(0)
+	require.False(t, ok)
+
+	_, err = (&BidConfig{Strategy: "generous"}).NewBidder()
+	require.Error(t, err)
+	_, err = (&BidConfig{Strategy: BidRandom, Value: 5, Max: 4}).NewBidder()
+	require.Error(t, err)
+	_, err = (&BidConfig{Strategy: BidFixed, NoBidProbability: 2}).NewBidder()
+	require.Error(t, err)
+}
+
+func TestCensorship(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, addr), func(cmd *EngineCmd) {
+		var err error
+		cmd.censored, err = parseCensored([]string{addr.Hex()})
+		require.NoError(t, err)
+	})
+	ctx := context.Background()
+	config := te.mockChain().chain.Config()
+	tx := ethTypes.MustSignNewTx(key, ethTypes.LatestSigner(config), &ethTypes.DynamicFeeTx{
+		ChainID:   config.ChainID,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(10_000_000_000),
+		Gas:       transferGas,
+		To:        &common.Address{0x01},
+		Value:     big.NewInt(1),
+	})
+	enc, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, te.client.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Bytes(enc)))
+
+	parent := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, parent.Hash(), parent.Time+12, common.Hash{0x01})
+	require.Empty(t, decodeTxs(t, payload), "transactions of censored senders are left out")
+	require.Equal(t, 1, te.mockChain().pool.Len())
+
+	_, err = parseCensored([]string{"0x01"})
+	require.Error(t, err)
+}
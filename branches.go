@@ -0,0 +1,88 @@
+package mergemock
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Branch is the tip of a branch of the block tree, with the canonical block it forks off from.
+type Branch struct {
+	Tip    common.Hash `json:"tip"`
+	Number uint64      `json:"number"`
+	// ForkPoint is the canonical ancestor of the tip, the tip itself for the canonical chain.
+	ForkPoint common.Hash `json:"forkPoint"`
+	// Depth is the number of blocks between the fork point and the head.
+	Depth     uint64 `json:"depth"`
+	Canonical bool   `json:"canonical"`
+}
+
+// Branches returns the tips of the branches of the block tree that fork off from the canonical chain at most depth
+// blocks below the head, the canonical chain first and the others from the highest tip down. The state of every
+// stored block is kept, so any of them can be built on.
+func (c *MockChain) Branches(depth uint64) []Branch {
+	head := c.chain.CurrentBlock().Header()
+	from := uint64(0)
+	if head.Number.Uint64() > depth {
+		from = head.Number.Uint64() - depth
+	}
+	// Blocks are tips unless a block of the next height is their child. Branches may outgrow the canonical chain,
+	// so heights are scanned until one has no blocks at all.
+	var tips []*types.Header
+	var level []*types.Header
+	for number := from; ; number++ {
+		var next []*types.Header
+		parents := make(map[common.Hash]bool)
+		for _, hash := range rawdb.ReadAllHashes(c.database, number) {
+			if header := c.chain.GetHeader(hash, number); header != nil {
+				next = append(next, header)
+				parents[header.ParentHash] = true
+			}
+		}
+		for _, header := range level {
+			if !parents[header.Hash()] {
+				tips = append(tips, header)
+			}
+		}
+		if len(next) == 0 && number > head.Number.Uint64() {
+			break
+		}
+		level = next
+	}
+
+	var branches []Branch
+	for _, tip := range tips {
+		fork := tip
+		// Canonical hashes above the head may be left behind by reorgs to shorter branches.
+		for fork != nil && (fork.Number.Cmp(head.Number) > 0 || rawdb.ReadCanonicalHash(c.database, fork.Number.Uint64()) != fork.Hash()) {
+			fork = c.chain.GetHeader(fork.ParentHash, fork.Number.Uint64()-1)
+		}
+		if fork == nil || fork.Number.Uint64() < from {
+			continue
+		}
+		branches = append(branches, Branch{
+			Tip:       c.SpecHash(tip.Hash()),
+			Number:    tip.Number.Uint64(),
+			ForkPoint: c.SpecHash(fork.Hash()),
+			Depth:     head.Number.Uint64() - fork.Number.Uint64(),
+			Canonical: fork.Hash() == tip.Hash(),
+		})
+	}
+	sort.SliceStable(branches, func(i, j int) bool {
+		if branches[i].Canonical != branches[j].Canonical {
+			return branches[i].Canonical
+		}
+		return branches[i].Number > branches[j].Number
+	})
+	return branches
+}
+
+// GetBranches returns the tips of the branches of the block tree forking off from the canonical chain at most depth
+// blocks below the head, to follow how deep the non-finalized tree built by consensus clients grows.
+func (b *MockBackend) GetBranches(ctx context.Context, depth hexutil.Uint64) []Branch {
+	return b.engine.mockChain.Branches(uint64(depth))
+}
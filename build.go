@@ -0,0 +1,242 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"mergemock/types"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/sirupsen/logrus"
+)
+
+type BuildConfig struct {
+	Async         bool          `ask:"--async" help:"Answer forkchoiceUpdated before the payload is built, and rebuild it in the background with more transactions until it is retrieved"`
+	MinTime       time.Duration `ask:"--min-time" help:"Time a payload takes to build, getPayload answers error -32001 when called earlier"`
+	Interval      time.Duration `ask:"--interval" help:"Time between rebuilds of a payload built in the background"`
+	TxsPerRebuild uint64        `ask:"--txs-per-rebuild" help:"Number of transactions every rebuild of a payload built in the background adds (0 to include all of them in the first build)"`
+	Parallel      int           `ask:"--parallel" help:"Most payloads built at the same time, further builds wait for one of them to finish (0 for no limit)"`
+}
+
+func (c *BuildConfig) Default() {
+	c.Interval = 500 * time.Millisecond
+	c.TxsPerRebuild = 1
+}
+
+// NewPayloadBuilds returns the manager of the payload builds of the config.
+func (c *BuildConfig) NewPayloadBuilds(log logrus.Ext1FieldLogger) *PayloadBuilds {
+	b := &PayloadBuilds{cfg: *c, log: log, active: make(map[types.PayloadID]*payloadBuild)}
+	if c.Parallel > 0 {
+		b.slots = make(chan struct{}, c.Parallel)
+	}
+	return b
+}
+
+// PayloadBuilds runs every payload build in a goroutine of its own, so that concurrent forkchoiceUpdated calls
+// don't wait for each other's builds. It emulates engines building payloads over the slot: a payload can't be
+// retrieved before the minimum build time, and in async mode forkchoiceUpdated returns the payload id at once
+// while the payload is built in the background, every rebuild including more transactions, until getPayload
+// retrieves it. Builds started under a forkchoice head are superseded once another head is chosen.
+type PayloadBuilds struct {
+	cfg   BuildConfig
+	log   logrus.Ext1FieldLogger
+	slots chan struct{} // taken by running builds, nil for no limit
+
+	mu         sync.Mutex
+	active     map[types.PayloadID]*payloadBuild
+	superseded uint64
+}
+
+// payloadBuild is a payload being built.
+type payloadBuild struct {
+	head    common.Hash // forkchoice head the build was started under
+	started time.Time
+	ready   chan struct{} // closed once the first build is done
+	err     error         // failure of the first build
+
+	ctx    context.Context // done once the payload is retrieved, has expired or is superseded
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	stopped bool // no build is published after
+}
+
+// stop cancels the rebuilds of the payload. The build published last stays the payload.
+func (pb *payloadBuild) stop() {
+	pb.mu.Lock()
+	pb.stopped = true
+	pb.mu.Unlock()
+	pb.cancel()
+}
+
+func (pb *payloadBuild) isReady() bool {
+	select {
+	case <-pb.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait waits for the first build of the payload, and returns its failure.
+func (pb *payloadBuild) Wait(ctx context.Context) error {
+	select {
+	case <-pb.ready:
+		return pb.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Async reports whether payloads are built in the background.
+func (b *PayloadBuilds) Async() bool {
+	return b.cfg.Async
+}
+
+// Start builds the payload in a goroutine, with build taking the maximum number of transactions of the payload,
+// 0 for no maximum, and publish making a build available to getPayload. The payload is built at least once, and in
+// async mode rebuilt until it's retrieved, has expired, is superseded, or a rebuild no longer adds transactions.
+func (b *PayloadBuilds) Start(id types.PayloadID, head common.Hash, retention time.Duration, build func(maxTxs int) (*builtPayload, error), publish func(*builtPayload)) *payloadBuild {
+	pb := &payloadBuild{head: head, started: time.Now(), ready: make(chan struct{})}
+	if retention != 0 {
+		pb.ctx, pb.cancel = context.WithTimeout(context.Background(), retention)
+	} else {
+		pb.ctx, pb.cancel = context.WithCancel(context.Background())
+	}
+	perRebuild := int(b.cfg.TxsPerRebuild)
+	if !b.cfg.Async {
+		perRebuild = 0
+	}
+	b.mu.Lock()
+	b.active[id] = pb
+	b.mu.Unlock()
+	plog := b.log.WithField("payload_id", id)
+	go func() {
+		defer func() {
+			pb.cancel()
+			b.mu.Lock()
+			delete(b.active, id)
+			b.mu.Unlock()
+		}()
+		for round := 1; ; round++ {
+			maxTxs := round * perRebuild
+			built, err := b.run(pb, round, maxTxs, build)
+			if err != nil {
+				if round == 1 {
+					pb.err = err
+					close(pb.ready)
+				}
+				return
+			}
+			built.created = pb.started
+			pb.mu.Lock()
+			if pb.stopped && round > 1 {
+				pb.mu.Unlock()
+				return
+			}
+			publish(built)
+			pb.mu.Unlock()
+			if round == 1 {
+				close(pb.ready)
+			}
+			txs := built.transactions()
+			plog.WithFields(logrus.Fields{"round": round, "txs": txs, "value": built.value}).Debug("Rebuilt payload")
+			if txs < maxTxs || perRebuild == 0 {
+				return
+			}
+			select {
+			case <-pb.ctx.Done():
+				return
+			case <-time.After(b.cfg.Interval):
+			}
+		}
+	}()
+	return pb
+}
+
+// run runs a build once a build slot is free. A build still waiting for a slot when the payload build is cancelled
+// doesn't run, and rebuilds are skipped once it is cancelled. The first build runs if it got a slot, as getPayload
+// and forkchoiceUpdated only stop the builds of the payloads they no longer wait for.
+func (b *PayloadBuilds) run(pb *payloadBuild, round, maxTxs int, build func(maxTxs int) (*builtPayload, error)) (*builtPayload, error) {
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		case <-pb.ctx.Done():
+			return nil, fmt.Errorf("payload build cancelled while waiting for a build slot: %w", pb.ctx.Err())
+		}
+		defer func() { <-b.slots }()
+	}
+	if round > 1 && pb.ctx.Err() != nil {
+		return nil, pb.ctx.Err()
+	}
+	return build(maxTxs)
+}
+
+// Supersede stops the rebuilds of the payloads started under another forkchoice head than the given one, as their
+// parent no longer is the block to build on. It returns the number of builds it stopped.
+func (b *PayloadBuilds) Supersede(head common.Hash) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stopped := 0
+	for id, pb := range b.active {
+		if pb.head == head || pb.ctx.Err() != nil {
+			continue
+		}
+		if !b.cfg.Async && !pb.isReady() {
+			// the forkchoiceUpdated that started it still waits for its only build
+			continue
+		}
+		pb.stop()
+		stopped++
+		b.log.WithFields(logrus.Fields{"payload_id": id, "head": pb.head, "new_head": head}).Debug("Superseded payload build")
+	}
+	b.superseded += uint64(stopped)
+	return stopped
+}
+
+// Stats returns the number of payloads being built, and of the builds superseded since start.
+func (b *PayloadBuilds) Stats() (active int, superseded uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.active), b.superseded
+}
+
+// Finish is called by getPayload, with the creation time of the payload if it is in the cache. It refuses
+// payloads retrieved before the minimum build time, and else waits for the first build of a payload still being
+// built, and stops its rebuilds.
+func (b *PayloadBuilds) Finish(ctx context.Context, id types.PayloadID, created time.Time) error {
+	b.mu.Lock()
+	pb := b.active[id]
+	b.mu.Unlock()
+	if pb != nil {
+		created = pb.started
+	}
+	if !created.IsZero() && time.Since(created) < b.cfg.MinTime {
+		b.log.WithFields(logrus.Fields{"payload_id": id, "min_time": b.cfg.MinTime}).Warn("Payload retrieved before the minimum build time")
+		return api.NewError(api.UnavailablePayload, "payload %s is still being built", id)
+	}
+	if pb == nil {
+		return nil
+	}
+	err := pb.Wait(ctx)
+	pb.stop()
+	return err
+}
+
+// limitingCreator leaves out the transactions after the first max.
+func limitingCreator(next TransactionsCreator, max int) TransactionsCreator {
+	return TransactionsCreator{next.accounts, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
+		txs := next.fn(config, bc, statedb, header, cfg, accounts)
+		if len(txs) > max {
+			txs = txs[:max]
+		}
+		return txs
+	}}
+}
@@ -0,0 +1,226 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"mergemock/types"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newBuildEngine(t *testing.T, configure func(cfg *BuildConfig)) *testEngine {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)), func(cmd *EngineCmd) {
+		cmd.Txs.Default()
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Txs.Mode = TxModeTransfer
+		cmd.Txs.Count = 40
+		cmd.Txs.Seed = 1
+		cmd.Build.Default()
+		configure(&cmd.Build)
+	})
+}
+
+func (te *testEngine) prepareBuild(t *testing.T) types.PayloadID {
+	genesis := te.mockChain().CurrentHeader()
+	attributes := &types.PayloadAttributesV2{Timestamp: genesis.Time + 12, PrevRandao: common.Hash{0x01}, SuggestedFeeRecipient: common.Address{0x02}}
+	result, err := api.ForkchoiceUpdatedV2(context.Background(), te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	require.NotNil(t, result.PayloadID)
+	return *result.PayloadID
+}
+
+func requireUnavailable(t *testing.T, err error) {
+	code, ok := api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.UnavailablePayload, code)
+}
+
+func TestBuildMinTime(t *testing.T) {
+	te := newBuildEngine(t, func(cfg *BuildConfig) {
+		cfg.MinTime = 200 * time.Millisecond
+	})
+	ctx := context.Background()
+	id := te.prepareBuild(t)
+	_, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	requireUnavailable(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	envelope, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	require.NoError(t, err)
+	require.NotEmpty(t, envelope.ExecutionPayload.Transactions, "built at once, with every transaction")
+}
+
+func TestBuildAsync(t *testing.T) {
+	te := newBuildEngine(t, func(cfg *BuildConfig) {
+		cfg.Async = true
+		cfg.MinTime = 200 * time.Millisecond
+		cfg.Interval = 20 * time.Millisecond
+		cfg.TxsPerRebuild = 2
+	})
+	ctx := context.Background()
+	id := te.prepareBuild(t)
+	_, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	requireUnavailable(t, err)
+
+	// Rebuilds keep adding transactions until the payload is retrieved, raising its value.
+	time.Sleep(250 * time.Millisecond)
+	envelope, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	require.NoError(t, err)
+	txs := len(envelope.ExecutionPayload.Transactions)
+	require.Greater(t, txs, 2)
+	require.Zero(t, txs%2)
+	require.Positive(t, envelope.BlockValue.ToInt().Sign())
+
+	// After that the payload stays the same.
+	time.Sleep(100 * time.Millisecond)
+	again, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	require.NoError(t, err)
+	require.Equal(t, envelope.ExecutionPayload.BlockHash, again.ExecutionPayload.BlockHash)
+
+	// The value grows with every rebuild.
+	early := te.prepareBuild(t)
+	late := te.prepareBuild(t)
+	time.Sleep(200 * time.Millisecond)
+	first, err := api.GetPayloadV2(ctx, te.client, te.log, early)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	second, err := api.GetPayloadV2(ctx, te.client, te.log, late)
+	require.NoError(t, err)
+	require.Greater(t, len(second.ExecutionPayload.Transactions), len(first.ExecutionPayload.Transactions))
+	require.Equal(t, 1, second.BlockValue.ToInt().Cmp(first.BlockValue.ToInt()))
+}
+
+func TestBuildSuperseded(t *testing.T) {
+	te := newBuildEngine(t, func(cfg *BuildConfig) {
+		cfg.Async = true
+		cfg.Interval = 20 * time.Millisecond
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	id := te.prepareBuild(t)
+
+	// Choosing another head stops the rebuilds of the payload on the old one.
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x03})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	te.setHead(t, payload.BlockHash)
+	stats := NewMockBackend(te.backend).Stats(ctx)
+	require.Equal(t, uint64(1), stats.SupersededBuilds)
+	require.Eventually(t, func() bool {
+		return NewMockBackend(te.backend).Stats(ctx).ActiveBuilds == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// The latest build stays retrievable.
+	envelope, err := api.GetPayloadV2(ctx, te.client, te.log, id)
+	require.NoError(t, err)
+	require.Equal(t, genesis.Hash(), envelope.ExecutionPayload.ParentHash)
+	require.Less(t, len(envelope.ExecutionPayload.Transactions), 40, "stopped before including every transaction")
+}
+
+func TestBuildConcurrent(t *testing.T) {
+	te := newBuildEngine(t, func(cfg *BuildConfig) {
+		cfg.Parallel = 2
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+
+	// Competing payloads are built, imported and chosen as head at the same time.
+	const payloads = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, payloads)
+	hashes := make(chan common.Hash, payloads)
+	for i := 0; i < payloads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- func() error {
+				attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 12, PrevRandao: common.Hash{byte(i)}, SuggestedFeeRecipient: common.Address{0x02}}
+				result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+				if err != nil {
+					return err
+				}
+				if result.PayloadID == nil {
+					return fmt.Errorf("no payload id, status %s", result.PayloadStatus.Status)
+				}
+				payload, err := api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+				if err != nil {
+					return err
+				}
+				if status, err := api.NewPayloadV1(ctx, te.client, te.log, payload); err != nil {
+					return err
+				} else if status.Status != types.ExecutionValid {
+					return fmt.Errorf("payload %d is %s", i, status.Status)
+				}
+				head := payload.BlockHash
+				if _, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, head, head, genesis.Hash(), nil); err != nil {
+					return err
+				}
+				hashes <- head
+				return nil
+			}()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	close(hashes)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	heads := make(map[common.Hash]bool)
+	for hash := range hashes {
+		te.requireKnownBlock(t, hash)
+		heads[hash] = true
+	}
+	require.Len(t, heads, payloads, "every payload is a block of its own")
+	require.True(t, heads[te.mockChain().SpecHash(te.mockChain().CurrentHeader().Hash())], "the head is one of the chosen blocks")
+}
+
+func TestBuildQueuedCancelled(t *testing.T) {
+	for _, async := range []bool{true, false} {
+		builds := (&BuildConfig{Async: async, Parallel: 1}).NewPayloadBuilds(logrus.New())
+		entered, release := make(chan struct{}), make(chan struct{})
+		blocking := func(int) (*builtPayload, error) {
+			close(entered)
+			<-release
+			return &builtPayload{v2: &types.ExecutionPayloadV2{}}, nil
+		}
+		var published int32
+		publish := func(*builtPayload) { atomic.AddInt32(&published, 1) }
+		running := builds.Start(types.PayloadID{0x01}, common.Hash{0x01}, 0, blocking, publish)
+		<-entered
+		queued := builds.Start(types.PayloadID{0x02}, common.Hash{0x02}, 0, func(int) (*builtPayload, error) {
+			t.Error("cancelled build ran")
+			return &builtPayload{v2: &types.ExecutionPayloadV2{}}, nil
+		}, publish)
+
+		// Async builds nobody waits for are superseded while queued, sync ones are left to their forkchoiceUpdated,
+		// which stops them once it gives up.
+		if async {
+			require.Equal(t, 1, builds.Supersede(common.Hash{0x01}))
+		} else {
+			require.Zero(t, builds.Supersede(common.Hash{0x01}))
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			require.ErrorIs(t, queued.Wait(ctx), context.DeadlineExceeded)
+			cancel()
+			queued.stop()
+		}
+		require.Error(t, queued.Wait(context.Background()), "async %v", async)
+
+		close(release)
+		require.NoError(t, running.Wait(context.Background()))
+		require.Eventually(t, func() bool {
+			active, _ := builds.Stats()
+			return active == 0
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, int32(1), atomic.LoadInt32(&published), "only the running build is published")
+	}
+}
@@ -0,0 +1,77 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Number of built blocks whose build log is kept.
+const buildLogCacheSize = 128
+
+// TxInclusion is the outcome of offering a candidate transaction to a block being built.
+type TxInclusion struct {
+	Hash     common.Hash `json:"hash"`
+	Included bool        `json:"included"`
+	GasUsed  uint64      `json:"gasUsed"`
+	// Reason is why an excluded transaction was left out: gas, nonce, fee, funds or other.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BuildLog records what happened to every candidate transaction of a built block, in the order they were offered.
+type BuildLog struct {
+	BlockHash    common.Hash   `json:"blockHash"`
+	Number       uint64        `json:"number"`
+	Transactions []TxInclusion `json:"transactions"`
+}
+
+func (l *BuildLog) include(tx *types.Transaction, receipt *types.Receipt) {
+	l.Transactions = append(l.Transactions, TxInclusion{Hash: tx.Hash(), Included: true, GasUsed: receipt.GasUsed})
+}
+
+func (l *BuildLog) exclude(tx *types.Transaction, err error) {
+	l.Transactions = append(l.Transactions, TxInclusion{Hash: tx.Hash(), Reason: exclusionReason(err), Error: err.Error()})
+}
+
+// Excluded returns the candidate transactions that didn't make it into the block.
+func (l *BuildLog) Excluded() []TxInclusion {
+	var excluded []TxInclusion
+	for _, tx := range l.Transactions {
+		if !tx.Included {
+			excluded = append(excluded, tx)
+		}
+	}
+	return excluded
+}
+
+func exclusionReason(err error) string {
+	switch {
+	case errors.Is(err, core.ErrGasLimitReached), errors.Is(err, core.ErrIntrinsicGas), errors.Is(err, core.ErrGasUintOverflow):
+		return "gas"
+	case errors.Is(err, core.ErrNonceTooLow), errors.Is(err, core.ErrNonceTooHigh), errors.Is(err, core.ErrNonceMax):
+		return "nonce"
+	case errors.Is(err, core.ErrFeeCapTooLow), errors.Is(err, core.ErrTipAboveFeeCap), errors.Is(err, core.ErrFeeCapVeryHigh), errors.Is(err, core.ErrTipVeryHigh):
+		return "fee"
+	case errors.Is(err, core.ErrInsufficientFunds), errors.Is(err, core.ErrInsufficientFundsForTransfer):
+		return "funds"
+	default:
+		return "other"
+	}
+}
+
+// BuildLog returns the build log of the recently built block with the spec hash, nil if unknown.
+func (c *MockChain) BuildLog(specHash common.Hash) *BuildLog {
+	if l, ok := c.buildLogs.Get(specHash); ok {
+		return l.(*BuildLog)
+	}
+	return nil
+}
+
+// GetBuildLog returns which candidate transactions of a recently built block were included, and why the others were not.
+func (b *MockBackend) GetBuildLog(ctx context.Context, blockHash common.Hash) (*BuildLog, error) {
+	return b.engine.mockChain.BuildLog(blockHash), nil
+}
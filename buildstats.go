@@ -0,0 +1,110 @@
+package mergemock
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of recent payload builds the percentiles of mock_stats are computed over.
+const buildSamplesSize = 1024
+
+// BuildCost is the resources a payload build took.
+type BuildCost struct {
+	WallTime time.Duration
+	// CPUTime is the CPU time of the build, 0 where it can't be measured.
+	CPUTime time.Duration
+	Txs     int
+	GasUsed uint64
+}
+
+// measureBuild runs the build locked to its thread, and returns its wall and CPU time.
+func measureBuild(build func()) (wall time.Duration, cpu time.Duration) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	start := time.Now()
+	cpuStart, measured := threadCPUTime()
+	build()
+	wall = time.Since(start)
+	if cpuEnd, ok := threadCPUTime(); measured && ok {
+		cpu = cpuEnd - cpuStart
+	}
+	return wall, cpu
+}
+
+// BuildMetrics keeps the costs of the recent payload builds, to compare what building costs under different
+// transaction profiles.
+type BuildMetrics struct {
+	mu    sync.Mutex
+	count uint64
+	costs []BuildCost // ring buffer of the latest builds
+}
+
+func (m *BuildMetrics) Record(cost BuildCost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.costs) < buildSamplesSize {
+		m.costs = append(m.costs, cost)
+	} else {
+		m.costs[m.count%buildSamplesSize] = cost
+	}
+	m.count++
+}
+
+// Percentiles summarizes a cost of the recent builds.
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func percentiles(values []float64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		return values[int(float64(len(values)-1)*p/100)]
+	}
+	return Percentiles{P50: at(50), P90: at(90), P99: at(99), Max: values[len(values)-1]}
+}
+
+// BuildStats is the percentiles of the costs of the recent payload builds.
+type BuildStats struct {
+	// Count is the number of builds since start, Samples the number of recent ones summarized.
+	Count      uint64      `json:"count"`
+	Samples    int         `json:"samples"`
+	WallTimeMs Percentiles `json:"wallTimeMs"`
+	CPUTimeMs  Percentiles `json:"cpuTimeMs"`
+	Txs        Percentiles `json:"txs"`
+	GasUsed    Percentiles `json:"gasUsed"`
+}
+
+func (m *BuildMetrics) Stats() BuildStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wall := make([]float64, len(m.costs))
+	cpu := make([]float64, len(m.costs))
+	txs := make([]float64, len(m.costs))
+	gas := make([]float64, len(m.costs))
+	for i, c := range m.costs {
+		wall[i] = milliseconds(c.WallTime)
+		cpu[i] = milliseconds(c.CPUTime)
+		txs[i] = float64(c.Txs)
+		gas[i] = float64(c.GasUsed)
+	}
+	return BuildStats{
+		Count:      m.count,
+		Samples:    len(m.costs),
+		WallTimeMs: percentiles(wall),
+		CPUTimeMs:  percentiles(cpu),
+		Txs:        percentiles(txs),
+		GasUsed:    percentiles(gas),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
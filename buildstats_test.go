@@ -0,0 +1,63 @@
+package mergemock
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMetrics(t *testing.T) {
+	var m BuildMetrics
+	require.Equal(t, BuildStats{}, m.Stats())
+	for i := 1; i <= 100; i++ {
+		m.Record(BuildCost{WallTime: time.Duration(i) * time.Millisecond, Txs: i, GasUsed: uint64(i) * 21000})
+	}
+	stats := m.Stats()
+	require.Equal(t, uint64(100), stats.Count)
+	require.Equal(t, 100, stats.Samples)
+	require.Equal(t, Percentiles{P50: 50, P90: 90, P99: 99, Max: 100}, stats.WallTimeMs)
+	require.Equal(t, Percentiles{P50: 50, P90: 90, P99: 99, Max: 100}, stats.Txs)
+	require.Equal(t, float64(100*21000), stats.GasUsed.Max)
+	require.Equal(t, Percentiles{}, stats.CPUTimeMs)
+
+	// Only the latest builds are summarized.
+	for i := 0; i < buildSamplesSize; i++ {
+		m.Record(BuildCost{WallTime: time.Second})
+	}
+	stats = m.Stats()
+	require.Equal(t, uint64(100+buildSamplesSize), stats.Count)
+	require.Equal(t, buildSamplesSize, stats.Samples)
+	require.Equal(t, Percentiles{P50: 1000, P90: 1000, P99: 1000, Max: 1000}, stats.WallTimeMs)
+}
+
+func TestEngineBuildMetrics(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t))
+	genesis := te.mockChain().CurrentHeader()
+	te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+
+	ctx := context.Background()
+	stats := NewMockBackend(te.backend).Stats(ctx).Builds
+	require.Equal(t, uint64(1), stats.Count)
+	require.Positive(t, stats.WallTimeMs.Max)
+	payloads := NewMockBackend(te.backend).State(ctx).Payloads
+	require.Len(t, payloads, 1)
+	require.Equal(t, stats.WallTimeMs.Max, payloads[0].BuildTimeMs)
+}
+
+func TestMeasureBuild(t *testing.T) {
+	wall, cpu := measureBuild(func() {
+		for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+		}
+	})
+	require.GreaterOrEqual(t, wall, 20*time.Millisecond)
+	if runtime.GOOS == "linux" {
+		require.Positive(t, cpu)
+		require.LessOrEqual(t, cpu, wall+5*time.Millisecond)
+	} else {
+		require.Zero(t, cpu)
+	}
+}
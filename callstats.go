@@ -0,0 +1,127 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/types"
+	"sync"
+	"time"
+)
+
+// Status counted for calls answered with an error instead of a result.
+const callStatusError = "error"
+
+// MethodStats counts the calls of an engine API method. Statuses are those of the payload status answered by
+// newPayload and forkchoiceUpdated, and error for calls of any method answered with an error.
+type MethodStats struct {
+	Calls    uint64            `json:"calls"`
+	LastCall time.Time         `json:"lastCall"`
+	Statuses map[string]uint64 `json:"statuses"`
+}
+
+// ForkchoiceCall is a forkchoice state received with forkchoiceUpdated, and how it was answered. Unlike the
+// forkchoice history of the engine, it has the states that weren't applied too.
+type ForkchoiceCall struct {
+	Time   time.Time               `json:"time"`
+	Method string                  `json:"method"`
+	State  types.ForkchoiceStateV1 `json:"state"`
+	// Timestamp is that of the payload attributes, if any.
+	Timestamp *uint64          `json:"timestamp,omitempty"`
+	Status    string           `json:"status"`
+	PayloadID *types.PayloadID `json:"payloadId,omitempty"`
+}
+
+// CallStats counts the engine API calls by method with the statuses they were answered with, and keeps the latest
+// forkchoice states received, for test harnesses to assert on what the consensus client called without parsing
+// the logs.
+type CallStats struct {
+	mu          sync.Mutex
+	methods     map[string]*MethodStats
+	forkchoices []ForkchoiceCall // the latest forkchoiceHistorySize
+}
+
+func NewCallStats() *CallStats {
+	return &CallStats{methods: make(map[string]*MethodStats)}
+}
+
+func (s *CallStats) method(name string) *MethodStats {
+	m, ok := s.methods[name]
+	if !ok {
+		m = &MethodStats{Statuses: make(map[string]uint64)}
+		s.methods[name] = m
+	}
+	return m
+}
+
+// Call counts a call of the method, on entry of its handler.
+func (s *CallStats) Call(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.method(method)
+	m.Calls++
+	m.LastCall = time.Now().UTC()
+}
+
+// Answered counts the status a call of the method was answered with, it's deferred with the error of the handler.
+func (s *CallStats) Answered(method string, err *error) {
+	if *err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.method(method).Statuses[callStatusError]++
+}
+
+// NewPayloadAnswered counts the status of the newPayload call, it's deferred with the results of the handler.
+func (s *CallStats) NewPayloadAnswered(method string, status **types.PayloadStatusV1, err *error) {
+	if *err != nil || *status == nil {
+		s.Answered(method, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.method(method).Statuses[string((*status).Status)]++
+}
+
+// ForkchoiceAnswered counts the status of the forkchoiceUpdated call and records its forkchoice state, like
+// NewPayloadAnswered. Calls answered with an error are recorded with the error status.
+func (s *CallStats) ForkchoiceAnswered(method string, heads *types.ForkchoiceStateV1, timestamp *uint64, result **types.ForkchoiceUpdatedResult, err *error) {
+	call := ForkchoiceCall{Time: time.Now().UTC(), Method: method, State: *heads, Timestamp: timestamp, Status: callStatusError}
+	if *err == nil && *result != nil {
+		call.Status, call.PayloadID = string((*result).PayloadStatus.Status), (*result).PayloadID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.method(method).Statuses[call.Status]++
+	s.forkchoices = append(s.forkchoices, call)
+	if len(s.forkchoices) > forkchoiceHistorySize {
+		s.forkchoices = s.forkchoices[len(s.forkchoices)-forkchoiceHistorySize:]
+	}
+}
+
+// Methods returns the stats of every called method.
+func (s *CallStats) Methods() map[string]MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	methods := make(map[string]MethodStats, len(s.methods))
+	for name, m := range s.methods {
+		statuses := make(map[string]uint64, len(m.Statuses))
+		for status, n := range m.Statuses {
+			statuses[status] = n
+		}
+		methods[name] = MethodStats{Calls: m.Calls, LastCall: m.LastCall, Statuses: statuses}
+	}
+	return methods
+}
+
+// Forkchoices returns the latest forkchoice states received, oldest first.
+func (s *CallStats) Forkchoices() []ForkchoiceCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ForkchoiceCall{}, s.forkchoices...)
+}
+
+// GetForkchoiceCalls returns the latest forkchoice states received with forkchoiceUpdated, oldest first, with
+// the status and payload id they were answered with.
+func (b *MockBackend) GetForkchoiceCalls(ctx context.Context) []ForkchoiceCall {
+	return b.engine.calls.Forkchoices()
+}
@@ -0,0 +1,51 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallStats(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	tampered := *payload
+	tampered.GasUsed++
+	require.Equal(t, types.ExecutionInvalidBlockHash, te.newPayload(t, &tampered))
+	unknown := common.Hash{0xff}
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, unknown, unknown, unknown, nil)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionSyncing, result.PayloadStatus.Status)
+	_, err = api.GetPayloadV1(ctx, te.client, te.log, types.PayloadID{0xff})
+	require.Error(t, err)
+
+	var stats Stats
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "stats"), &stats))
+	newPayload := stats.Calls["engine_newPayloadV1"]
+	require.Equal(t, uint64(2), newPayload.Calls)
+	require.Equal(t, map[string]uint64{"VALID": 1, "INVALID_BLOCK_HASH": 1}, newPayload.Statuses)
+	require.False(t, newPayload.LastCall.IsZero())
+	require.Equal(t, map[string]uint64{"VALID": 1, "SYNCING": 1}, stats.Calls["engine_forkchoiceUpdatedV1"].Statuses)
+	getPayload := stats.Calls["engine_getPayloadV1"]
+	require.Equal(t, uint64(2), getPayload.Calls)
+	require.Equal(t, map[string]uint64{"error": 1}, getPayload.Statuses)
+
+	var calls []ForkchoiceCall
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "forkchoice-calls"), &calls))
+	require.Len(t, calls, 2)
+	require.Equal(t, genesis.Hash(), calls[0].State.HeadBlockHash)
+	require.Equal(t, genesis.Time+12, *calls[0].Timestamp)
+	require.NotNil(t, calls[0].PayloadID)
+	require.Equal(t, "VALID", calls[0].Status)
+	require.Equal(t, unknown, calls[1].State.HeadBlockHash)
+	require.Nil(t, calls[1].Timestamp)
+	require.Equal(t, "SYNCING", calls[1].Status)
+}
@@ -0,0 +1,269 @@
+package mergemock
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	mmTypes "mergemock/types"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of chain files.
+const (
+	ChainFormatRLP  = "rlp"  // concatenated RLP blocks, like geth export and import, gzipped for .gz files
+	ChainFormatEra1 = "era1" // an era1 archive of the blocks with their receipts and total difficulties
+)
+
+// chainImportTrigger is the trigger of the journal entries of imported blocks.
+const chainImportTrigger = "chain-import"
+
+type ChainFileConfig struct {
+	Import string `ask:"--import" help:"Chain file to import on start, before mining the terminal proof-of-work chain, e.g. written by geth export (none if empty)"`
+	Export string `ask:"--export" help:"File to export the canonical chain to on exit, e.g. to load it with geth import (none if empty)"`
+	Format string `ask:"--format" help:"Format of the chain files: rlp for concatenated RLP blocks like geth export and import, era1 for an era1 archive with receipts and total difficulties"`
+}
+
+func (c *ChainFileConfig) validate() error {
+	switch c.Format {
+	case ChainFormatRLP, ChainFormatEra1:
+		return nil
+	default:
+		return fmt.Errorf("unknown chain file format %q, expected %s or %s", c.Format, ChainFormatRLP, ChainFormatEra1)
+	}
+}
+
+// specBlock is a block encoded as defined by the spec: its header commits to the fork fields, and has the spec
+// hash of its parent. Before Shanghai it's the geth encoding.
+type specBlock struct {
+	Header      rlp.RawValue
+	Txs         []*types.Transaction
+	Uncles      []*types.Header
+	Withdrawals []*mmTypes.Withdrawal `rlp:"optional"`
+}
+
+// specBlock encodes the canonical block of the number, and returns it with the geth block, nil if there's none.
+func (c *MockChain) specBlock(number uint64) (*specBlock, *types.Block, error) {
+	block := c.chain.GetBlockByNumber(number)
+	if block == nil {
+		return nil, nil, nil
+	}
+	b, err := c.encodeSpecBlock(block)
+	return b, block, err
+}
+
+// encodeSpecBlock encodes the stored block with its fork fields.
+func (c *MockChain) encodeSpecBlock(block *types.Block) (*specBlock, error) {
+	header := block.Header()
+	header.ParentHash = c.SpecHash(header.ParentHash)
+	fork := c.ForkFields(block.Hash())
+	enc, err := mmTypes.EncodeHeader(header, fork)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode header %d: %v", block.NumberU64(), err)
+	}
+	b := &specBlock{Header: enc, Txs: block.Transactions(), Uncles: block.Uncles()}
+	if fork != nil {
+		b.Withdrawals = fork.Withdrawals
+	}
+	return b, nil
+}
+
+// ExportChain writes the canonical blocks from first to last as RLP, like geth export. It returns the number of
+// blocks written.
+func (c *MockChain) ExportChain(w io.Writer, first, last uint64) (int, error) {
+	count := 0
+	for n := first; n <= last; n++ {
+		b, _, err := c.specBlock(n)
+		if err != nil {
+			return count, err
+		}
+		if b == nil {
+			return count, fmt.Errorf("no canonical block %d", n)
+		}
+		if err := rlp.Encode(w, b); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ImportChain imports the RLP blocks, like geth import, and makes the last one the canonical head. Blocks already
+// known are skipped, the genesis block has to be the one of the mock chain. It returns the number of blocks
+// imported.
+func (c *MockChain) ImportChain(r io.Reader) (int, error) {
+	stream := rlp.NewStream(r, 0)
+	var blocks []*specBlock
+	for {
+		var b specBlock
+		if err := stream.Decode(&b); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to decode block %d: %v", len(blocks), err)
+		}
+		blocks = append(blocks, &b)
+	}
+	return c.importBlocks(blocks)
+}
+
+func (c *MockChain) importBlocks(blocks []*specBlock) (int, error) {
+	imported := 0
+	var last common.Hash
+	for i, b := range blocks {
+		hash, number, stored, err := c.importSpecBlock(b, chainImportTrigger)
+		if err != nil {
+			return imported, fmt.Errorf("block %d: %v", i, err)
+		}
+		if number == 0 {
+			continue
+		}
+		last = hash
+		if stored {
+			imported++
+		}
+	}
+	if last != (common.Hash{}) {
+		if _, err := c.SetHead(last, chainImportTrigger); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+// importSpecBlock stores the block without making it the head, unless it is known already. It returns the spec
+// hash and number of the block, and whether it was stored. The genesis block has to be the one of the mock chain.
+func (c *MockChain) importSpecBlock(b *specBlock, trigger string) (common.Hash, uint64, bool, error) {
+	header, fork, err := mmTypes.DecodeHeader(b.Header)
+	if err != nil {
+		return common.Hash{}, 0, false, fmt.Errorf("failed to decode header: %v", err)
+	}
+	hash := crypto.Keccak256Hash(b.Header)
+	number := header.Number.Uint64()
+	if number == 0 {
+		if genesis := c.chain.Genesis().Hash(); hash != genesis {
+			return hash, number, false, fmt.Errorf("genesis %s differs from the genesis %s of the mock chain", hash, genesis)
+		}
+		return hash, number, false, nil
+	}
+	if c.chain.GetHeaderByHash(c.ResolveHash(hash)) != nil {
+		return hash, number, false, nil
+	}
+	if header.Difficulty.Sign() != 0 {
+		// proof-of-work blocks are stored as they are, they have no fork fields
+		block := types.NewBlockWithHeader(header).WithBody(b.Txs, b.Uncles)
+		c.mu.Lock()
+		_, err := c.chain.InsertChain(types.Blocks{block})
+		c.mu.Unlock()
+		if err != nil {
+			return hash, number, false, fmt.Errorf("failed to insert block %d: %v", number, err)
+		}
+		c.recordBlock(JournalImport, trigger, hash, block)
+		return hash, number, true, nil
+	}
+	txs := make([][]byte, 0, len(b.Txs))
+	for _, tx := range b.Txs {
+		enc, err := tx.MarshalBinary()
+		if err != nil {
+			return hash, number, false, err
+		}
+		txs = append(txs, enc)
+	}
+	if fork != nil {
+		fork.Withdrawals = b.Withdrawals
+		if fork.Withdrawals == nil {
+			fork.Withdrawals = []*mmTypes.Withdrawal{}
+		}
+	}
+	payload := &mmTypes.ExecutionPayloadV1{
+		ParentHash:    header.ParentHash,
+		FeeRecipient:  header.Coinbase,
+		StateRoot:     header.Root,
+		ReceiptsRoot:  header.ReceiptHash,
+		LogsBloom:     header.Bloom,
+		Random:        header.MixDigest,
+		Number:        number,
+		GasLimit:      header.GasLimit,
+		GasUsed:       header.GasUsed,
+		Timestamp:     header.Time,
+		ExtraData:     header.Extra,
+		BaseFeePerGas: header.BaseFee,
+		BlockHash:     hash,
+		Transactions:  txs,
+	}
+	if _, err := c.processPayload(payload, fork, trigger); err != nil {
+		return hash, number, false, fmt.Errorf("failed to import block %d: %v", number, err)
+	}
+	return hash, number, true, nil
+}
+
+// importChainFile imports the chain file of the format into the mock chain.
+func importChainFile(log logrus.Ext1FieldLogger, chain *MockChain, path, format string) error {
+	var imported int
+	if format == ChainFormatEra1 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if imported, err = chain.ImportEra1(data); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(path, ".gz") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			r = zr
+		}
+		if imported, err = chain.ImportChain(r); err != nil {
+			return err
+		}
+	}
+	log.WithFields(logrus.Fields{"path": path, "format": format, "imported": imported, "head": chain.SpecHash(chain.Head())}).Info("Imported chain")
+	return nil
+}
+
+// exportChainFile exports the canonical chain, from genesis to the head, to a chain file of the format.
+func exportChainFile(log logrus.Ext1FieldLogger, chain *MockChain, path, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	head := chain.CurrentHeader().Number.Uint64()
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if format == ChainFormatRLP && strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	var exported int
+	if format == ChainFormatEra1 {
+		exported, err = chain.ExportEra1(w, 0, head)
+	} else {
+		exported, err = chain.ExportChain(w, 0, head)
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{"path": path, "format": format, "exported": exported}).Info("Exported chain")
+	return nil
+}
@@ -0,0 +1,70 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainFiles(t *testing.T) {
+	ctx := context.Background()
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 24})
+	te := newTestEngineWithGenesis(t, genesisPath)
+	genesis := te.mockChain().CurrentHeader()
+	_, envelope, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, nil)
+	require.NoError(t, err)
+	preShanghai := envelope.ExecutionPayload
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, preShanghai.PayloadV1()))
+	recipient := common.Address{0xaa}
+	_, envelope, err = te.buildPayloadV2(t, preShanghai.BlockHash, preShanghai.Timestamp+12, []*types.Withdrawal{{Index: 0, Validator: 1, Address: recipient, Amount: 32}})
+	require.NoError(t, err)
+	shanghai := envelope.ExecutionPayload
+	status, err := api.NewPayloadV2(ctx, te.client, te.log, shanghai)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	te.setHead(t, shanghai.BlockHash)
+
+	for _, file := range []struct {
+		name   string
+		format string
+	}{
+		{"chain.rlp", ChainFormatRLP},
+		{"chain.rlp.gz", ChainFormatRLP},
+		{"chain.era1", ChainFormatEra1},
+	} {
+		t.Run(file.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), file.name)
+			require.NoError(t, exportChainFile(te.log, te.mockChain(), path, file.format))
+
+			// Another engine on the genesis imports the blocks, and the one of Shanghai under its spec hash.
+			imported := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+				cmd.Chain.Import = path
+				cmd.Chain.Format = file.format
+			})
+			mc := imported.mockChain()
+			require.Equal(t, shanghai.BlockHash, mc.SpecHash(mc.Head()))
+			require.Equal(t, te.balance(t, shanghai.BlockHash, recipient), imported.balance(t, shanghai.BlockHash, recipient))
+			entries := NewMockBackend(imported.backend).GetJournal(ctx, &shanghai.BlockHash)
+			require.Equal(t, JournalImport, entries[0].Kind)
+			require.Equal(t, chainImportTrigger, entries[0].Trigger)
+		})
+	}
+
+	var buf bytes.Buffer
+	count, err := te.mockChain().ExportChain(&buf, 0, te.mockChain().CurrentHeader().Number.Uint64())
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	count, err = te.mockChain().ImportChain(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Zero(t, count, "known blocks are skipped")
+	other := newTestEngineWithGenesis(t, newFundedGenesis(t, recipient))
+	_, err = other.mockChain().ImportChain(&buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "genesis")
+}
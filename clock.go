@@ -0,0 +1,106 @@
+package mergemock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for all timing logic, so tests can control time instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock backed by the real time.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// FakeClock is a Clock that only moves when advanced.
+// Like time.Ticker, its tickers drop ticks that aren't received in time.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing every tick that became due on the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		// fire the earliest due tick first, so tickers observe time moving forward monotonically
+		var due *fakeTicker
+		for _, t := range c.tickers {
+			if !t.next.After(end) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		c.now = due.next
+		select {
+		case due.c <- due.next:
+		default:
+		}
+		due.next = due.next.Add(due.interval)
+	}
+	c.now = end
+}
+
+type fakeTicker struct {
+	clock    *FakeClock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
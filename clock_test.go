@@ -0,0 +1,38 @@
+package mergemock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClockTicker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	ticker := clock.NewTicker(12 * time.Second)
+
+	clock.Advance(11 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before interval passed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(12*time.Second), <-ticker.C())
+	require.Equal(t, start.Add(12*time.Second), clock.Now())
+
+	// Ticks that aren't received in time are dropped, like with time.Ticker.
+	clock.Advance(36 * time.Second)
+	require.Equal(t, start.Add(24*time.Second), <-ticker.C())
+	require.Equal(t, start.Add(48*time.Second), clock.Now())
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker ticked")
+	default:
+	}
+}
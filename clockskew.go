@@ -0,0 +1,72 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"sync"
+	"time"
+)
+
+type ClockSkewConfig struct {
+	Offset    time.Duration `ask:"--offset" help:"Offset of the engine clock to the system clock of the consensus client, e.g. -2s for an engine running behind (can be changed with mock_setClockOffset)"`
+	MaxFuture time.Duration `ask:"--max-future" help:"Time payload attributes may be ahead of the engine clock, forkchoiceUpdated answers error -38003 for later timestamps (0 to accept any)"`
+	MaxPast   time.Duration `ask:"--max-past" help:"Time payload attributes may be behind the engine clock, forkchoiceUpdated answers error -38003 for earlier timestamps (0 to accept any)"`
+}
+
+// NewEngineClock returns the engine clock of the config, which is skewed from the system clock by the offset.
+func (c *ClockSkewConfig) NewEngineClock() *EngineClock {
+	return &EngineClock{clock: SystemClock{}, offset: c.Offset, maxFuture: c.MaxFuture, maxPast: c.MaxPast}
+}
+
+// EngineClock is the clock payload attribute timestamps are validated against. It's offset from the clock of
+// the consensus client driving the engine, to explore how much clock skew the client tolerates.
+type EngineClock struct {
+	clock              Clock
+	maxFuture, maxPast time.Duration
+
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+// Offset returns the offset of the engine clock to the system clock.
+func (c *EngineClock) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+func (c *EngineClock) SetOffset(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = offset
+}
+
+// Now returns the time of the engine clock.
+func (c *EngineClock) Now() time.Time {
+	return c.clock.Now().Add(c.Offset())
+}
+
+// CheckTimestamp returns an invalid payload attributes error if the timestamp is further ahead of, or behind,
+// the engine clock than tolerated.
+func (c *EngineClock) CheckTimestamp(timestamp uint64) error {
+	skew := time.Unix(int64(timestamp), 0).Sub(c.Now())
+	if c.maxFuture > 0 && skew > c.maxFuture {
+		return api.NewInvalidPayloadAttributesError("timestamp %d is %s ahead of the engine clock, more than %s", timestamp, skew.Round(time.Millisecond), c.maxFuture)
+	}
+	if c.maxPast > 0 && -skew > c.maxPast {
+		return api.NewInvalidPayloadAttributesError("timestamp %d is %s behind the engine clock, more than %s", timestamp, (-skew).Round(time.Millisecond), c.maxPast)
+	}
+	return nil
+}
+
+// SetClockOffset changes the offset of the engine clock to the system clock, e.g. "-1.5s".
+func (b *MockBackend) SetClockOffset(ctx context.Context, offset string) error {
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return fmt.Errorf("invalid clock offset: %v", err)
+	}
+	b.engine.clock.SetOffset(d)
+	b.engine.log.WithField("offset", d).Warn("Changed engine clock offset")
+	return nil
+}
@@ -0,0 +1,37 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineClockSkew(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Clock.MaxFuture = 2 * time.Second
+		cmd.Clock.MaxPast = 2 * time.Second
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	te.backend.clock.clock = NewFakeClock(time.Unix(int64(genesis.Time)+12, 0))
+	prepare := func(offset string) error {
+		require.NoError(t, te.client.CallContext(ctx, nil, "mock_setClockOffset", offset))
+		attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 12}
+		_, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+		return err
+	}
+
+	require.NoError(t, prepare("0s"))
+	require.NoError(t, prepare("-1500ms"), "skew within the tolerance")
+	for _, offset := range []string{"-3s", "3s"} {
+		err := prepare(offset)
+		code, ok := api.Code(err)
+		require.True(t, ok, "offset %s: %v", offset, err)
+		require.Equal(t, api.InvalidPayloadAttributes, code)
+	}
+	require.Error(t, te.client.CallContext(ctx, nil, "mock_setClockOffset", "soon"))
+}
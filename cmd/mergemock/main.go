@@ -4,38 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"mergemock"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/protolambda/ask"
 )
 
-type MergeMockCmd struct {
-}
-
-func (c *MergeMockCmd) Help() string {
-	return "Run MergeMock. Either mock a consensus node or execution engine."
-}
-
-func (c *MergeMockCmd) Cmd(route string) (cmd interface{}, err error) {
-	switch route {
-	case "consensus":
-		cmd = &ConsensusCmd{}
-	case "engine":
-		cmd = &EngineCmd{}
-	case "relay":
-		cmd = &RelayCmd{}
-	default:
-		return nil, ask.UnrecognizedErr
-	}
-	return
-}
-
-func (c *MergeMockCmd) Routes() []string {
-	return []string{"consensus", "engine", "relay"}
-}
-
 type start struct {
 	cmd *ask.CommandDescription
 	err error
@@ -44,9 +21,11 @@ type start struct {
 func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 	ctx, cancel := context.WithCancel(context.Background())
 
-	cmd := &MergeMockCmd{}
+	cmd := &mergemock.MergeMockCmd{}
 	descr, err := ask.Load(cmd)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "failed to load main command: %v", err.Error())
@@ -57,11 +36,17 @@ func main() {
 		return nil
 	}
 
+	args, err := mergemock.ConfigArgs(os.Args[1:])
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
 	starter := make(chan start)
 
 	// run command in the background, so we can stop it at any time
 	go func() {
-		cmd, err := descr.Execute(ctx, &ask.ExecutionOptions{OnDeprecated: onDeprecated}, os.Args[1:]...)
+		cmd, err := descr.Execute(ctx, &ask.ExecutionOptions{OnDeprecated: onDeprecated}, args...)
 		starter <- start{cmd, err}
 	}()
 
@@ -71,7 +56,19 @@ func main() {
 			if cmd, err := start.cmd, start.err; err == nil {
 				// if the command is long-running and closeable later on, then have the interrupt close it.
 				if cl, ok := cmd.Command.(io.Closer); ok {
-					<-interrupt
+					// reload the command on hangup, until interrupted
+					for reloading := true; reloading; {
+						select {
+						case <-hangup:
+							if r, ok := cmd.Command.(mergemock.Reloader); ok {
+								if err := r.Reload(); err != nil {
+									_, _ = fmt.Fprintf(os.Stderr, "failed to reload: %v\n", err)
+								}
+							}
+						case <-interrupt:
+							reloading = false
+						}
+					}
 					err := cl.Close()
 					cancel()
 					if err != nil {
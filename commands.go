@@ -0,0 +1,51 @@
+package mergemock
+
+import "github.com/protolambda/ask"
+
+// Reloader is a running command that reloads parts of its configuration on SIGHUP.
+type Reloader interface {
+	Reload() error
+}
+
+type MergeMockCmd struct {
+}
+
+func (c *MergeMockCmd) Help() string {
+	return "Run MergeMock. Either mock a consensus node or execution engine."
+}
+
+func (c *MergeMockCmd) Cmd(route string) (cmd interface{}, err error) {
+	switch route {
+	case "consensus":
+		cmd = &ConsensusCmd{}
+	case "ctl":
+		cmd = &CtlCmd{}
+	case "diff":
+		cmd = &DiffCmd{}
+	case "engine":
+		cmd = &EngineCmd{}
+	case "proposal":
+		cmd = &ProposalCmd{}
+	case "relay":
+		cmd = &RelayCmd{}
+	case "replay":
+		cmd = &ReplayCmd{}
+	case "scenarios":
+		cmd = &ScenariosCmd{}
+	case "shell":
+		cmd = &ShellCmd{}
+	case "soak":
+		cmd = &SoakCmd{}
+	case "stress":
+		cmd = &StressCmd{}
+	case "vectors":
+		cmd = &VectorsCmd{}
+	default:
+		return nil, ask.UnrecognizedErr
+	}
+	return
+}
+
+func (c *MergeMockCmd) Routes() []string {
+	return []string{"consensus", "ctl", "diff", "engine", "proposal", "relay", "replay", "scenarios", "shell", "soak", "stress", "vectors"}
+}
@@ -0,0 +1,150 @@
+package mergemock
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/protolambda/ask"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlag is the flag of the YAML file the other flags of a command are loaded from.
+const configFlag = "--config"
+
+// configJSONFlags are the flags of files whose contents config files can inline, as JSON.
+var configJSONFlags = map[string]bool{"scenario": true}
+
+// ConfigArgs expands the --config file of the command line arguments into flags, inserted after the command route
+// so that the flags of the command line override the file. The file maps flag names to values, with the groups of
+// flags nested or joined by dots, e.g. tx: {mode: erc20} or tx.mode: erc20. Lists are the values of list flags.
+func ConfigArgs(args []string) ([]string, error) {
+	route := len(args)
+	path := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") && route == len(args) {
+			route = i
+		}
+		if arg == configFlag && i+1 < len(args) {
+			path = args[i+1]
+		} else if strings.HasPrefix(arg, configFlag+"=") {
+			path = strings.TrimPrefix(arg, configFlag+"=")
+		}
+	}
+	if path == "" {
+		return args, nil
+	}
+	flags, err := LoadConfigFlags(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(args)+len(flags))
+	out = append(out, args[:route]...)
+	out = append(out, flags...)
+	return append(out, args[route:]...), nil
+}
+
+// LoadConfigFlags reads the YAML config file as flags.
+func LoadConfigFlags(path string) ([]string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	var flags []string
+	if err := configFlags(&flags, "", doc.Content[0]); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return flags, nil
+}
+
+// parseConfigFlags sets the flags of the command, after applying its defaults, without running it.
+func parseConfigFlags(cmd interface{}, flags []string) error {
+	descr, err := ask.Load(cmd)
+	if err != nil {
+		return err
+	}
+	long := descr.FlagGroup.All("")
+	sort.SliceStable(long, func(i, j int) bool { return long[i].Path < long[j].Path })
+	remaining, err := ask.ParseArgs(nil, long, flags, func(fl ask.PrefixedFlag, value string) error {
+		return fl.Value.Set(value)
+	})
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("unexpected arguments %v", remaining)
+	}
+	return nil
+}
+
+func configFlags(flags *[]string, name string, node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if configJSONFlags[name] && (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) {
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		enc, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		*flags = append(*flags, "--"+name+"="+string(enc))
+		return nil
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == strings.TrimPrefix(configFlag, "--") && name == "" {
+				return fmt.Errorf("config files can't load other config files")
+			}
+			if name != "" {
+				key = name + "." + key
+			}
+			if err := configFlags(flags, key, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: list of a list flag can only hold values, at line %d", name, item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		// list flags are parsed as CSV, values with commas are quoted
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(values); err != nil {
+			return err
+		}
+		w.Flush()
+		*flags = append(*flags, "--"+name+"="+strings.TrimSuffix(buf.String(), "\n"))
+	case yaml.ScalarNode:
+		if name == "" {
+			return fmt.Errorf("expected a mapping of flags, got %q", node.Value)
+		}
+		*flags = append(*flags, "--"+name+"="+node.Value)
+	default:
+		return fmt.Errorf("%s: unexpected value at line %d", name, node.Line)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package mergemock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+listen-addr: 127.0.0.1:9551
+seconds-per-slot: 4
+tx:
+  mode: erc20
+  gas-target: 10
+fault:
+  rule:
+    - method=engine_newPayloadV1;action=error;probability=0.5
+    - method=engine_getPayloadV1;action=timeout
+cors: ["http://a.example,with-comma", "http://b.example"]
+gaslimit.target: 36000000
+scenario:
+  steps:
+    - {slot: 2, behavior: syncing}
+`
+
+// parseEngineArgs sets the flags of the engine command like the command line does, without running it.
+func parseEngineArgs(t *testing.T, args []string) *EngineCmd {
+	cmd := new(EngineCmd)
+	require.NoError(t, parseConfigFlags(cmd, args))
+	return cmd
+}
+
+func TestConfigArgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o644))
+	args, err := ConfigArgs([]string{"engine", "--config", path, "--tx.mode=call"})
+	require.NoError(t, err)
+	require.Equal(t, "engine", args[0])
+	require.Equal(t, []string{"--config", path, "--tx.mode=call"}, args[len(args)-3:], "the command line comes last")
+
+	cmd := parseEngineArgs(t, args[1:])
+	require.Equal(t, path, cmd.ConfigPath)
+	require.Equal(t, "127.0.0.1:9551", cmd.ListenAddr)
+	require.Equal(t, "127.0.0.1:8552", cmd.WebsocketAddr, "defaults stay")
+	require.Equal(t, uint64(4), cmd.SecondsPerSlot)
+	require.Equal(t, TxModeCall, cmd.Txs.Mode, "the command line overrides the file")
+	require.Equal(t, float64(10), cmd.Txs.GasTarget)
+	require.Equal(t, []string{"method=engine_newPayloadV1;action=error;probability=0.5", "method=engine_getPayloadV1;action=timeout"}, cmd.Faults.Rules)
+	require.Equal(t, []string{"http://a.example,with-comma", "http://b.example"}, cmd.Cors)
+	require.Equal(t, uint64(36_000_000), cmd.GasLimits.Target)
+	require.Equal(t, 12*time.Second, cmd.PayloadRetention)
+
+	scenario, err := LoadScenario(cmd.ScenarioPath, 100)
+	require.NoError(t, err)
+	require.Len(t, scenario.Steps, 1)
+	require.Equal(t, uint64(2), scenario.Steps[0].Slot)
+}
+
+func TestConfigArgsErrors(t *testing.T) {
+	args := []string{"engine", "--tx.mode=call"}
+	out, err := ConfigArgs(args)
+	require.NoError(t, err)
+	require.Equal(t, args, out, "nothing to expand without a config file")
+
+	dir := t.TempDir()
+	_, err = ConfigArgs([]string{"engine", "--config", filepath.Join(dir, "missing.yaml")})
+	require.Error(t, err)
+	for _, config := range []string{"just a value", "config: other.yaml", "cors: [[nested]]", "tx: {mode: [erc20: x]}"} {
+		path := filepath.Join(dir, "bad.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+		_, err = ConfigArgs([]string{"engine", "--config=" + path})
+		require.Error(t, err, config)
+	}
+}
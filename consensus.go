@@ -1,4 +1,4 @@
-package main
+package mergemock
 
 import (
 	"context"
@@ -10,7 +10,9 @@ import (
 	"mergemock/p2p"
 	"mergemock/rpc"
 	"mergemock/types"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -38,13 +40,15 @@ type ConsensusCmd struct {
 	BeaconGenesisTime uint64        `ask:"--beacon-genesis-time" help:"Beacon genesis time"`
 	SlotTime          time.Duration `ask:"--slot-time" help:"Time per slot"`
 	SlotsPerEpoch     uint64        `ask:"--slots-per-epoch" help:"Slots per epoch"`
+	SlotPattern       string        `ask:"--slot-pattern" help:"Repeating pattern of the slots from slot 1 on: p for a block proposed by the engine, b for a block of another proposer, s for a skipped slot, e.g. bbps (random slots by the freq flags if empty)"`
 	// TODO ideas:
 	// - % random gap slots (= missing beacon blocks)
 	// - % random finality
 
 	EngineAddr     string `ask:"--engine" help:"Address of Engine JSON-RPC endpoint to use"`
 	BuilderAddr    string `ask:"--builder" help:"Address of builder relay REST API endpoint to use"`
-	DataDir        string `ask:"--datadir" help:"Directory to store execution chain data (empty for in-memory data)"`
+	BuilderSSZ     bool   `ask:"--builder-ssz" help:"Exchange SSZ with the builder relay instead of JSON, falling back to JSON responses of relays without SSZ support"`
+	DataDir        string `ask:"--datadir" help:"Directory to store execution chain data (empty for in-memory data, 'auto' for a temporary directory removed on exit)"`
 	EthashDir      string `ask:"--ethashdir" help:"Directory to store ethash data"`
 	GenesisPath    string `ask:"--genesis" help:"Genesis execution-config file"`
 	JwtSecretPath  string `ask:"--jwt-secret" help:"JWT secret key for authenticated communication"`
@@ -53,15 +57,26 @@ type ConsensusCmd struct {
 	ValidatorCount uint64 `ask:"--validators" help:"Number of validators to emulate."`
 
 	GenesisValidatorsRoot string `ask:"--genesis-validators-root" help:"Root of genesis validators"`
+	GenesisForkVersion    string `ask:"--genesis-fork-version" help:"Genesis fork version of the network, of the builder domain registrations are signed and bids are verified over"`
 
 	// embed consensus behaviors
 	ConsensusBehavior `ask:"."`
 
+	NonFinality NonFinalityConfig `ask:".nonfinality" help:"Hold finality back and make the head and safe block oscillate between competing branches, to stress deep non-finalized block trees"`
+
+	Accounts AccountsConfig `ask:".accounts" help:"Derive well-known test accounts from a mnemonic, prefunded in the genesis like the engine does"`
+
+	Withdrawals WithdrawalsConfig `ask:".withdrawals" help:"Generate the withdrawals of the payload attributes and external blocks from Shanghai on"`
+
 	// embed logger options
 	LogCmd `ask:".log" help:"Change logger configuration"`
 
 	TraceLogConfig `ask:".trace" help:"Tracing options"`
 
+	LatencyBudgets LatencyBudgetConfig `ask:".latency" help:"Alert when the engine takes longer than the budget to answer a call"`
+
+	Wire rpc.WireLog `ask:".wire" help:"Log the HTTP traffic with the engine API"`
+
 	close     chan struct{}
 	log       logrus.Ext1FieldLogger
 	ctx       context.Context
@@ -69,16 +84,26 @@ type ConsensusCmd struct {
 	jwtSecret []byte
 	db        ethdb.Database
 
+	removeDataDir func() error
+
 	genesisValidatorsRoot types.Root
+	builderDomain         types.Domain
 
 	ethashCfg ethash.Config
 
 	mockChain  *MockChain
 	validators []validator
+	latency    *LatencyMonitor
+	accounts   []NamedAccount
+
+	withdrawals *WithdrawalGenerator
+
+	clock Clock
 }
 
 func (c *ConsensusCmd) Default() {
-	c.BeaconGenesisTime = uint64(time.Now().Unix()) + 5
+	c.clock = SystemClock{}
+	c.BeaconGenesisTime = uint64(c.clock.Now().Unix()) + 5
 	c.EngineAddr = "http://127.0.0.1:8551"
 	c.GenesisPath = "genesis.json"
 	c.JwtSecretPath = "jwt.hex"
@@ -88,6 +113,7 @@ func (c *ConsensusCmd) Default() {
 	c.SlotsPerEpoch = 32
 	c.LogLvl = "info"
 	c.GenesisValidatorsRoot = "0x0000000000000000000000000000000000000000000000000000000000000000"
+	c.GenesisForkVersion = "0x00000000"
 }
 
 func (c *ConsensusCmd) Help() string {
@@ -102,8 +128,13 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 	if c.SlotTime < 50*time.Millisecond {
 		return fmt.Errorf("slot time %s is too small", c.SlotTime.String())
 	}
+	if strings.Trim(c.SlotPattern, "pbs") != "" {
+		return fmt.Errorf("invalid slot pattern %q, expected only p, b and s slots", c.SlotPattern)
+	}
 
-	jwt, err := loadJwtSecret(c.JwtSecretPath)
+	version := Version()
+	log.WithFields(logrus.Fields{"commit": version.GitCommit, "buildTime": version.BuildTime}).Info("Starting mergemock consensus")
+	jwt, err := readJwtSecret(c.JwtSecretPath)
 	if err != nil {
 		log.WithField("err", err).Fatal("Unable to read JWT secret")
 	}
@@ -111,9 +142,38 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 	log.WithField("val", common.Bytes2Hex(c.jwtSecret[:])).Info("Loaded JWT secret")
 
 	c.genesisValidatorsRoot = types.Root(common.HexToHash(c.GenesisValidatorsRoot))
+	var forkVersion types.ForkVersion
+	if err := forkVersion.UnmarshalText([]byte(c.GenesisForkVersion)); err != nil {
+		return fmt.Errorf("invalid genesis fork version: %v", err)
+	}
+	c.builderDomain = types.ComputeBuilderDomain(forkVersion)
+
+	monitor, err := c.LatencyBudgets.NewMonitor(log)
+	if err != nil {
+		return err
+	}
+	c.latency = monitor
+
+	if c.accounts, err = c.Accounts.Derive(); err != nil {
+		return fmt.Errorf("unable to derive test accounts: %v", err)
+	}
+	if len(c.TestAccounts.accounts) == 0 {
+		c.TestAccounts = testAccounts(c.accounts)
+	}
+	if c.withdrawals, err = c.Withdrawals.NewWithdrawalGenerator(c.RNG.Rand); err != nil {
+		return fmt.Errorf("invalid withdrawals config: %v", err)
+	}
 
 	// Connect to execution client engine api
-	client, err := rpc.DialContext(ctx, c.EngineAddr, c.jwtSecret)
+	if err := c.Wire.Open(); err != nil {
+		return err
+	}
+	var client *rpc.Client
+	if c.Wire.Enabled() {
+		client, err = rpc.DialHTTPWithClient(c.EngineAddr, c.jwtSecret, &http.Client{Transport: c.Wire.Transport(nil, log)})
+	} else {
+		client, err = rpc.DialContext(ctx, c.EngineAddr, c.jwtSecret)
+	}
 	if err != nil {
 		return err
 	}
@@ -131,10 +191,10 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 			msg := &types.RegisterValidatorRequestMessage{
 				FeeRecipient: types.Address{0x42},
 				GasLimit:     30_000_000,
-				Timestamp:    uint64(time.Now().Unix()),
+				Timestamp:    uint64(c.clock.Now().Unix()),
 				Pubkey:       pk,
 			}
-			root, err := types.ComputeSigningRoot(msg, types.DomainBuilder)
+			root, err := types.ComputeSigningRoot(msg, c.builderDomain)
 			if err != nil {
 				return err
 			}
@@ -143,7 +203,7 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 			registrations = append(registrations, types.SignedValidatorRegistration{Message: msg, Signature: sig})
 			c.validators = append(c.validators, validator{pk, sk})
 		}
-		if err := api.BuilderRegisterValidators(ctx, log, c.BuilderAddr, registrations); err != nil {
+		if err := api.BuilderRegisterValidators(ctx, log, c.BuilderAddr, registrations, c.BuilderSSZ); err != nil {
 			return err
 		}
 	}
@@ -158,14 +218,23 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 		CachesOnDisk:   3,
 	}
 
-	db, err := NewDB(c.DataDir)
+	dataDir, removeDataDir, err := resolveDataDir(c.DataDir)
+	if err != nil {
+		return err
+	}
+	if c.DataDir == AutoDataDir {
+		log.WithField("datadir", dataDir).Info("Using temporary datadir")
+	}
+	db, err := NewDB(dataDir)
 	if err != nil {
+		removeDataDir()
 		return fmt.Errorf("failed to open new db: %v", err)
 	}
 
 	c.log = log
 	c.engine = client
 	c.db = db
+	c.removeDataDir = removeDataDir
 	c.ctx = ctx
 	c.close = make(chan struct{})
 
@@ -174,6 +243,37 @@ func (c *ConsensusCmd) Run(ctx context.Context, args ...string) error {
 	return nil
 }
 
+// Slots of the slot pattern.
+const (
+	SlotProposal = 'p'
+	SlotExternal = 'b'
+	SlotSkipped  = 's'
+)
+
+// slotKind returns the slot of the slot pattern, or 0 if it is picked at random.
+func (c *ConsensusCmd) slotKind(slot uint64) byte {
+	if c.SlotPattern == "" || slot == 0 {
+		return 0
+	}
+	return c.SlotPattern[(slot-1)%uint64(len(c.SlotPattern))]
+}
+
+// prepareProposal has the engine build a payload on the head for the next slot, if the slot pattern has the
+// engine propose it.
+func (c *ConsensusCmd) prepareProposal(slot uint64, head, safe, final common.Hash, payloadId chan<- types.PayloadID) {
+	if c.slotKind(slot+1) != SlotProposal {
+		return
+	}
+	id, err := c.sendForkchoiceUpdated(head, safe, final, c.makePayloadAttributes(slot+1, head))
+	if err != nil {
+		maybeExit(c.SlotBound)
+		return
+	}
+	if id != nil {
+		payloadId <- *id
+	}
+}
+
 func (c *ConsensusCmd) SlotTimestamp(slot uint64) uint64 {
 	return c.BeaconGenesisTime + uint64((time.Duration(slot) * c.SlotTime).Seconds())
 }
@@ -190,7 +290,7 @@ func (c *ConsensusCmd) proofOfWorkPrelogue(log logrus.Ext1FieldLogger) (transiti
 	// Create a temporary chain around the db, with ethash consensus, to run through the POW part.
 	engine := ethash.New(c.ethashCfg, nil, false)
 
-	mc, err := NewMockChain(log, engine, c.GenesisPath, c.db, &c.TraceLogConfig)
+	mc, err := NewMockChain(log, engine, c.GenesisPath, c.db, &c.TraceLogConfig, genesisAlloc(c.accounts))
 	if err != nil {
 		return 0, fmt.Errorf("unable to initialize mock chain: %v", err)
 	}
@@ -245,7 +345,8 @@ func (c *ConsensusCmd) proofOfWorkPrelogue(log logrus.Ext1FieldLogger) (transiti
 		td := mc.CurrentTd()
 		log.WithField("td", td).WithField("ttd", ttd).Debug("Comparing TD to terminal TD")
 		if td.Cmp(ttd) >= 0 {
-			log.Info("Terminal total difficulty reached, transitioning to POS")
+			mc.AnnounceTerminal(log, mc.CurrentHeader())
+			log.Info("Transitioning to POS")
 			return mc.CurrentHeader().Number.Uint64(), nil
 		}
 	}
@@ -254,7 +355,7 @@ func (c *ConsensusCmd) proofOfWorkPrelogue(log logrus.Ext1FieldLogger) (transiti
 func (c *ConsensusCmd) RunNode() {
 	var (
 		genesisTime     = time.Unix(int64(c.BeaconGenesisTime), 0)
-		slots           = time.NewTicker(c.SlotTime)
+		slots           = c.clock.NewTicker(c.SlotTime)
 		transitionBlock = uint64(0)
 		finalizedHash   = common.Hash{}
 		safeHash        = common.Hash{}
@@ -281,7 +382,7 @@ func (c *ConsensusCmd) RunNode() {
 	}
 
 	// Initialize mock chain with existing db
-	mc, err := NewMockChain(c.log, posEngine, c.GenesisPath, c.db, &c.TraceLogConfig)
+	mc, err := NewMockChain(c.log, posEngine, c.GenesisPath, c.db, &c.TraceLogConfig, genesisAlloc(c.accounts))
 	if err != nil {
 		c.log.WithField("err", err).Error("Unable to initialize mock chain")
 		os.Exit(1)
@@ -290,7 +391,7 @@ func (c *ConsensusCmd) RunNode() {
 
 	for {
 		select {
-		case tick := <-slots.C:
+		case tick := <-slots.C():
 			signedSlot := int64(math.Round(float64(tick.Sub(genesisTime)) / float64(c.SlotTime)))
 			if signedSlot < 0 {
 				// before genesis...
@@ -302,6 +403,7 @@ func (c *ConsensusCmd) RunNode() {
 			if signedSlot == 0 {
 				c.log.WithField("slot", 0).Info("Genesis!")
 				safeHash = c.mockChain.CurrentHeader().Hash()
+				go c.prepareProposal(0, safeHash, safeHash, finalizedHash, payloadId)
 				continue
 			}
 			slot := uint64(signedSlot)
@@ -309,44 +411,61 @@ func (c *ConsensusCmd) RunNode() {
 				c.log.WithField("testRuns", c.SlotBound).Info("All test runs successfully completed")
 				os.Exit(0)
 			}
-			if slot%c.SlotsPerEpoch == 0 {
+			if slot%c.SlotsPerEpoch == 0 && c.NonFinality.Finalizes(slot/c.SlotsPerEpoch) {
 				last := finalizedHash
 				finalizedHash = nextFinalized
 				safeHash = finalizedHash
 				nextFinalized = c.mockChain.CurrentHeader().Hash()
 				c.log.WithField("slot", slot).WithField("last", last).WithField("new", finalizedHash).WithField("next", nextFinalized).Info("Finalized block updated")
 			}
+			kind := c.slotKind(slot)
 			// Gap slot
-			if c.RNG.Float64() < c.Freq.GapSlot {
+			if kind == SlotSkipped || kind == 0 && c.RNG.Float64() < c.Freq.GapSlot {
 				c.log.WithField("slot", slot).Info("Mocking gap slot, no payload execution here")
 				// empty pending proposal
 				select {
 				case <-payloadId:
 				default:
 				}
+				go c.prepareProposal(slot, c.mockChain.CurrentHeader().Hash(), safeHash, finalizedHash, payloadId)
 				continue
 			}
 
 			// Send bad hash
-			if c.RNG.Float64() < c.Freq.InvalidHashFreq {
+			if kind == 0 && c.RNG.Float64() < c.Freq.InvalidHashFreq {
 				c.log.Info("Sending payload with invalid hash")
-				payload := &types.ExecutionPayloadV1{
-					ParentHash:    c.mockChain.CurrentHeader().Hash(),
+				head := c.mockChain.CurrentHeader()
+				payload := &types.ExecutionPayloadV2{
+					ParentHash:    c.mockChain.SpecHash(head.Hash()),
 					FeeRecipient:  common.Address{},
-					Number:        c.mockChain.CurrentHeader().Number.Uint64(),
-					GasLimit:      c.mockChain.CurrentHeader().GasLimit,
+					Number:        head.Number.Uint64(),
+					GasLimit:      head.GasLimit,
 					GasUsed:       0,
-					Timestamp:     c.mockChain.CurrentHeader().Time + 1,
-					BaseFeePerGas: c.mockChain.CurrentHeader().BaseFee,
+					Timestamp:     head.Time + 1,
+					BaseFeePerGas: head.BaseFee,
 					BlockHash:     common.HexToHash("0xdeadbeef"),
 				}
-				go api.NewPayloadV1(c.ctx, c.engine, c.log, payload)
+				if c.mockChain.IsShanghai(payload.Timestamp) {
+					payload.Withdrawals = []*types.Withdrawal{}
+				}
+				go c.newPayload(c.ctx, c.log, payload)
 				continue
 			}
 
-			// Fake some forking by building on an ancestor
+			// Fake some forking by building on an ancestor, or on a competing branch when oscillating
 			parent := c.mockChain.CurrentHeader()
-			if c.RNG.Float64() < c.Freq.ReorgFreq {
+			if c.NonFinality.Oscillate != 0 {
+				var switched bool
+				if parent, switched = c.oscillate(slot, parent, finalizedHash); switched {
+					c.log.WithField("slot", slot).WithField("parent", parent.Hash()).WithField("finalized", finalizedHash).Info("Oscillating to competing branch")
+					// the prepared payload is on the branch left behind
+					select {
+					case <-payloadId:
+					default:
+					}
+				}
+				safeHash = parent.Hash()
+			} else if c.RNG.Float64() < c.Freq.ReorgFreq {
 				min := transitionBlock
 				if final := c.mockChain.chain.GetHeaderByHash(finalizedHash); final != nil {
 					num := final.Number.Uint64()
@@ -361,13 +480,22 @@ func (c *ConsensusCmd) RunNode() {
 			slotLog.WithField("previous", parent.Hash()).Info("Slot trigger")
 
 			// If we're proposing, get a block from the engine!
-			select {
-			case id := <-payloadId:
-				slotLog.WithField("payloadId", id).Info("Update forkchoice to block built by engine")
-				go c.mockProposal(slotLog, id, slot, false)
-				continue
-			default:
-				// Not proposing a block
+			if kind == 0 || kind == SlotProposal {
+				select {
+				case id := <-payloadId:
+					slotLog.WithField("payloadId", id).Info("Update forkchoice to block built by engine")
+					go func(safe, final common.Hash) {
+						if block := c.mockProposal(slotLog, id, slot, false); block != nil {
+							c.prepareProposal(slot, block.Hash(), safe, final, payloadId)
+						}
+					}(safeHash, finalizedHash)
+					continue
+				default:
+					// Not proposing a block
+					if kind == SlotProposal {
+						slotLog.Warn("No payload of the engine to propose, mocking external block instead")
+					}
+				}
 			}
 
 			// Build a block, without using the engine, and insert it into the engine
@@ -381,7 +509,12 @@ func (c *ConsensusCmd) RunNode() {
 			uncleBlocks := []*ethTypes.Header{}
 			creator := TransactionsCreator{c.ConsensusBehavior.TestAccounts.accounts, dummyTxCreator}
 
-			block, err := c.mockChain.AddNewBlock(parent.Hash(), coinbase, timestamp, gasLimit, creator, [32]byte{}, extraData, uncleBlocks, true)
+			var withdrawals []*types.Withdrawal
+			if c.mockChain.IsShanghai(timestamp) {
+				withdrawals = c.withdrawals.Next(c.mockChain.lastWithdrawal(parent))
+			}
+
+			block, err := c.mockChain.AddNewBlock(parent.Hash(), coinbase, timestamp, gasLimit, creator, [32]byte{}, extraData, uncleBlocks, withdrawals, true)
 			if err != nil {
 				slotLog.WithError(err).Errorf("Failed to add block")
 				continue
@@ -394,10 +527,10 @@ func (c *ConsensusCmd) RunNode() {
 				latest := block.Hash()
 				// Note: head and safe hash are set to the same hash,
 				// until forkchoice updates are more attestation-weight aware.
-				var attributes *types.PayloadAttributesV1
-				if c.RNG.Float64() < c.Freq.ProposalFreq {
+				var attributes *types.PayloadAttributesV2
+				if kind := c.slotKind(slot + 1); kind == SlotProposal || kind == 0 && c.RNG.Float64() < c.Freq.ProposalFreq {
 					// proposing next slot!
-					attributes = c.makePayloadAttributes(slot + 1)
+					attributes = c.makePayloadAttributes(slot+1, latest)
 				}
 				id, err := c.sendForkchoiceUpdated(latest, safe, final, attributes)
 				if err != nil {
@@ -417,12 +550,35 @@ func (c *ConsensusCmd) RunNode() {
 			if err := c.db.Close(); err != nil {
 				c.log.WithError(err).Error("Failed closing database")
 			}
+			if err := c.removeDataDir(); err != nil {
+				c.log.WithError(err).Error("Failed removing temporary datadir")
+			}
 		}
 	}
 }
 
-func (c *ConsensusCmd) sendForkchoiceUpdated(latest, safe, final common.Hash, attributes *types.PayloadAttributesV1) (*types.PayloadID, error) {
-	result, _ := api.ForkchoiceUpdatedV1(c.ctx, c.engine, c.log, latest, safe, final, attributes)
+// sendForkchoiceUpdated updates the forkchoice of the engine to the blocks of the mock chain, with the V2 method from
+// Shanghai on.
+func (c *ConsensusCmd) sendForkchoiceUpdated(latest, safe, final common.Hash, attributes *types.PayloadAttributesV2) (*types.PayloadID, error) {
+	var result types.ForkchoiceUpdatedResult
+	head, safe, final := c.mockChain.SpecHash(latest), c.mockChain.SpecHash(safe), c.mockChain.SpecHash(final)
+	if attributes != nil && attributes.Withdrawals != nil || attributes == nil && c.isShanghai(latest) {
+		done := c.latency.Track("engine_forkchoiceUpdatedV2")
+		result, _ = api.ForkchoiceUpdatedV2(c.ctx, c.engine, c.log, head, safe, final, attributes)
+		done()
+	} else {
+		var v1 *types.PayloadAttributesV1
+		if attributes != nil {
+			v1 = &types.PayloadAttributesV1{
+				Timestamp:             attributes.Timestamp,
+				PrevRandao:            attributes.PrevRandao,
+				SuggestedFeeRecipient: attributes.SuggestedFeeRecipient,
+			}
+		}
+		done := c.latency.Track("engine_forkchoiceUpdatedV1")
+		result, _ = api.ForkchoiceUpdatedV1(c.ctx, c.engine, c.log, head, safe, final, v1)
+		done()
+	}
 	if result.PayloadStatus.Status != types.ExecutionValid {
 		c.log.WithField("status", result.PayloadStatus).Error("Update not considered valid")
 		return nil, fmt.Errorf("update not considered valid")
@@ -430,11 +586,27 @@ func (c *ConsensusCmd) sendForkchoiceUpdated(latest, safe, final common.Hash, at
 	return result.PayloadID, nil
 }
 
-func (c *ConsensusCmd) getMockProposal(ctx context.Context, log logrus.Ext1FieldLogger, payloadId types.PayloadID, slot uint64) (*types.ExecutionPayloadV1, error) {
+// isShanghai returns whether the block of the mock chain is from Shanghai on, false if it's unknown.
+func (c *ConsensusCmd) isShanghai(hash common.Hash) bool {
+	header := c.mockChain.chain.GetHeaderByHash(hash)
+	return header != nil && c.mockChain.IsShanghai(header.Time)
+}
+
+// newPayload has the engine execute the payload, with the V2 method if the payload has withdrawals.
+func (c *ConsensusCmd) newPayload(ctx context.Context, log logrus.Ext1FieldLogger, payload *types.ExecutionPayloadV2) (*types.PayloadStatusV1, error) {
+	if payload.Withdrawals == nil {
+		defer c.latency.Track("engine_newPayloadV1")()
+		return api.NewPayloadV1(ctx, c.engine, log, payload.PayloadV1())
+	}
+	defer c.latency.Track("engine_newPayloadV2")()
+	return api.NewPayloadV2(ctx, c.engine, log, payload)
+}
+
+func (c *ConsensusCmd) getMockProposal(ctx context.Context, log logrus.Ext1FieldLogger, payloadId types.PayloadID, slot uint64) (*types.ExecutionPayloadV2, error) {
 	// If the CL is connected to builder client, request the payload from there.
 	if c.BuilderAddr != "" {
 		idx := c.RNG.Int63n(int64(len(c.validators)))
-		header, err := api.BuilderGetHeader(c.ctx, log, c.BuilderAddr, slot, c.mockChain.CurrentHeader().Hash(), c.validators[idx].sk.PublicKey().Marshal())
+		header, err := api.BuilderGetHeader(c.ctx, log, c.BuilderAddr, slot, c.mockChain.SpecHash(c.mockChain.CurrentHeader().Hash()), c.validators[idx].sk.PublicKey().Marshal(), c.builderDomain, c.BuilderSSZ)
 		if err != nil {
 			return nil, err
 		}
@@ -451,7 +623,7 @@ func (c *ConsensusCmd) getMockProposal(ctx context.Context, log logrus.Ext1Field
 			},
 			Signature: types.Signature{},
 		}
-		domain := types.ComputeDomain(types.DomainTypeBeaconProposer, version.Bellatrix, &c.genesisValidatorsRoot)
+		domain := types.ComputeDomain(types.DomainTypeBeaconProposer, types.ForkVersion{version.Bellatrix}, &c.genesisValidatorsRoot)
 		root, err := types.ComputeSigningRoot(signedBlindedBeaconBlock.Message, domain)
 		if err != nil {
 			return nil, err
@@ -459,23 +631,43 @@ func (c *ConsensusCmd) getMockProposal(ctx context.Context, log logrus.Ext1Field
 		sig := c.validators[idx].sk.Sign(root[:]).Marshal()
 		signedBlindedBeaconBlock.Signature.FromSlice(sig)
 
-		payload, err := api.BuilderGetPayload(ctx, log, c.BuilderAddr, signedBlindedBeaconBlock)
+		payload, err := api.BuilderGetPayload(ctx, log, c.BuilderAddr, signedBlindedBeaconBlock, c.BuilderSSZ)
 		if err != nil {
 			return nil, err
 		}
+		// Like consensus clients, refuse a payload other than the one of the signed header, or one too late to
+		// propose, missing the slot.
+		if payload.BlockHash != common.Hash(header.BlockHash) {
+			return nil, fmt.Errorf("builder returned payload %s for signed header %s", payload.BlockHash, common.Hash(header.BlockHash))
+		}
+		if end := time.Unix(int64(c.BeaconGenesisTime), 0).Add(time.Duration(slot+1) * c.SlotTime); c.clock.Now().After(end) {
+			return nil, fmt.Errorf("builder returned payload %s after the end of slot %d", payload.BlockHash, slot)
+		}
 		c.log.WithField("hash", payload.BlockHash.Hex()).Info("received payload from builder")
-		return payload, err
+		// The builder API is that of Bellatrix, its payloads have no withdrawals.
+		return payload.PayloadV2(nil), nil
 	}
 
 	// Otherwise, get payload from EL.
+	if c.mockChain.IsShanghai(c.SlotTimestamp(slot)) {
+		defer c.latency.Track("engine_getPayloadV2")()
+		envelope, err := api.GetPayloadV2(c.ctx, c.engine, log, payloadId)
+		if err != nil {
+			return nil, err
+		}
+		return envelope.ExecutionPayload, nil
+	}
+	defer c.latency.Track("engine_getPayloadV1")()
 	payload, err := api.GetPayloadV1(c.ctx, c.engine, log, payloadId)
 	if err != nil {
 		return nil, err
 	}
-	return payload, err
+	return payload.PayloadV2(nil), nil
 }
 
-func (c *ConsensusCmd) mockProposal(log logrus.Ext1FieldLogger, payloadId types.PayloadID, slot uint64, consensusFail bool) {
+// mockProposal proposes the payload built by the engine, and returns its block once the engine executed it, nil if the
+// proposal failed.
+func (c *ConsensusCmd) mockProposal(log logrus.Ext1FieldLogger, payloadId types.PayloadID, slot uint64, consensusFail bool) *ethTypes.Block {
 	ctx, cancel := context.WithTimeout(c.ctx, time.Second*20)
 	defer cancel()
 
@@ -483,31 +675,36 @@ func (c *ConsensusCmd) mockProposal(log logrus.Ext1FieldLogger, payloadId types.
 	if err != nil {
 		log.WithError(err).Error("Unable to retrieve proposal payload")
 		maybeExit(c.SlotBound)
-		return
+		return nil
 	}
 	if err := c.ValidateTimestamp(uint64(payload.Timestamp), slot); err != nil {
 		log.WithError(err).Error("Payload has bad timestamp")
 		maybeExit(c.SlotBound)
-		return
+		return nil
 	}
 	if consensusFail {
 		log.Debug("Mocking a failed proposal on consensus-side, ignoring produced payload of engine")
-		return
+		return nil
+	}
+	var block *ethTypes.Block
+	if payload.Withdrawals == nil {
+		block, err = c.mockChain.ProcessPayload(payload.PayloadV1())
+	} else {
+		block, err = c.mockChain.ProcessPayloadV2(payload)
 	}
-	block, err := c.mockChain.ProcessPayload(payload)
 	if err != nil {
 		log.WithError(err).Error("Failed to process execution payload from engine")
 		maybeExit(c.SlotBound)
-		return
+		return nil
 	} else {
 		log.WithField("blockhash", block.Hash()).Debug("Processed payload in consensus mock world")
 	}
 
 	// Send it back to execution layer for execution
-	res, err := api.NewPayloadV1(ctx, c.engine, log, payload)
+	res, err := c.newPayload(ctx, log, payload)
 	if err == nil && res.Status == types.ExecutionValid {
 		log.WithField("blockhash", block.Hash()).Debug("Processed payload in engine")
-		return
+		return block
 	}
 	if err != nil {
 		log.WithError(err).Error("Failed to execute payload")
@@ -517,6 +714,7 @@ func (c *ConsensusCmd) mockProposal(log logrus.Ext1FieldLogger, payloadId types.
 		log.WithField("status", res.Status).Error("Unrecognized execution status")
 	}
 	maybeExit(c.SlotBound)
+	return nil
 }
 
 func (c *ConsensusCmd) mockExecution(log logrus.Ext1FieldLogger, block *ethTypes.Block) {
@@ -524,14 +722,14 @@ func (c *ConsensusCmd) mockExecution(log logrus.Ext1FieldLogger, block *ethTypes
 	defer cancel()
 
 	// derive the random 32 bytes from the block hash for mocking ease
-	payload, err := api.BlockToPayload(block)
+	payload, err := api.BlockToPayloadV2(block, c.mockChain.SpecHash(block.ParentHash()), c.mockChain.Withdrawals(block.Hash()))
 
 	if err != nil {
 		log.WithError(err).Error("Failed to convert execution block to execution payload")
 		return
 	}
 
-	api.NewPayloadV1(ctx, c.engine, log, payload)
+	c.newPayload(ctx, log, payload)
 }
 
 func dummyTxCreator(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
@@ -565,17 +763,24 @@ func (c *ConsensusCmd) Close() error {
 	if c.close != nil {
 		c.close <- struct{}{}
 	}
-	return nil
+	c.latency.LogSummary()
+	return c.Wire.Close()
 }
 
-func (c *ConsensusCmd) makePayloadAttributes(slot uint64) *types.PayloadAttributesV1 {
+// makePayloadAttributes returns the attributes of a payload of the slot on the parent block, with generated
+// withdrawals from Shanghai on.
+func (c *ConsensusCmd) makePayloadAttributes(slot uint64, parent common.Hash) *types.PayloadAttributesV2 {
 	var prevRandao common.Hash
 	c.RNG.Read(prevRandao[:])
-	return &types.PayloadAttributesV1{
+	attributes := &types.PayloadAttributesV2{
 		Timestamp:             c.SlotTimestamp(slot),
 		PrevRandao:            prevRandao,
 		SuggestedFeeRecipient: common.Address{0x13, 0x37},
 	}
+	if c.mockChain.IsShanghai(attributes.Timestamp) {
+		attributes.Withdrawals = c.withdrawals.Next(c.mockChain.lastWithdrawal(c.mockChain.chain.GetHeaderByHash(parent)))
+	}
+	return attributes
 }
 
 func maybeExit(val uint64) {
@@ -0,0 +1,92 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/types"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsensusSlotPattern(t *testing.T) {
+	c := &ConsensusCmd{SlotPattern: "bbps"}
+	var kinds []byte
+	for slot := uint64(0); slot <= 8; slot++ {
+		kinds = append(kinds, c.slotKind(slot))
+	}
+	require.Equal(t, []byte{0, 'b', 'b', 'p', 's', 'b', 'b', 'p', 's'}, kinds)
+	c.SlotPattern = ""
+	require.Zero(t, c.slotKind(3), "random slots without pattern")
+
+	c = new(ConsensusCmd)
+	c.Default()
+	c.LogCmd.Default()
+	c.SlotPattern = "bpx"
+	err := c.Run(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid slot pattern")
+}
+
+func TestConsensusPrepareProposal(t *testing.T) {
+	te := newTestEngine(t)
+	genesis := te.mockChain().CurrentHeader()
+	c := new(ConsensusCmd)
+	c.Default()
+	c.ConsensusBehavior.Default()
+	c.SlotPattern = "bp"
+	c.BeaconGenesisTime = genesis.Time
+	c.ctx = context.Background()
+	c.engine = te.client
+	c.log = logrus.New()
+	c.mockChain = te.mockChain()
+
+	// The engine only builds a payload for the slots it proposes.
+	payloadId := make(chan types.PayloadID, 1)
+	c.prepareProposal(0, genesis.Hash(), genesis.Hash(), genesis.Hash(), payloadId)
+	require.Empty(t, payloadId)
+	c.prepareProposal(1, genesis.Hash(), genesis.Hash(), genesis.Hash(), payloadId)
+	require.Len(t, payloadId, 1)
+	id := <-payloadId
+	payloads := NewMockBackend(te.backend).State(c.ctx).Payloads
+	require.Len(t, payloads, 1)
+	require.Equal(t, id, payloads[0].PayloadID)
+	require.Equal(t, c.SlotTimestamp(2), uint64(payloads[0].Timestamp))
+}
+
+func TestConsensusWithdrawals(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 24}))
+	genesis := te.mockChain().CurrentHeader()
+	c := new(ConsensusCmd)
+	c.Default()
+	c.ConsensusBehavior.Default()
+	c.Withdrawals.Default()
+	c.SlotPattern = "p"
+	c.BeaconGenesisTime = genesis.Time
+	c.ctx = context.Background()
+	c.engine = te.client
+	c.log = logrus.New()
+	c.mockChain = te.mockChain()
+	var err error
+	c.withdrawals, err = c.Withdrawals.NewWithdrawalGenerator(c.RNG.Rand)
+	require.NoError(t, err)
+
+	// From Shanghai on the proposals have the generated withdrawals, built and executed with the V2 methods.
+	payloadId := make(chan types.PayloadID, 1)
+	c.prepareProposal(1, genesis.Hash(), genesis.Hash(), genesis.Hash(), payloadId)
+	require.Len(t, payloadId, 1)
+	payload, err := c.getMockProposal(c.ctx, c.log, <-payloadId, 2)
+	require.NoError(t, err)
+	require.Len(t, payload.Withdrawals, 4)
+	for i, w := range payload.Withdrawals {
+		require.Equal(t, uint64(i), w.Index)
+		require.Equal(t, uint64(i), w.Validator)
+		require.Equal(t, withdrawalAddress(uint64(i)), w.Address)
+	}
+	status, err := c.newPayload(c.ctx, c.log, payload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	calls := te.backend.calls.Methods()
+	require.Equal(t, uint64(1), calls["engine_forkchoiceUpdatedV2"].Calls)
+	require.Equal(t, uint64(1), calls["engine_newPayloadV2"].Calls)
+}
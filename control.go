@@ -0,0 +1,237 @@
+package mergemock
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"mergemock/types"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Number of forkchoice updates kept in the history, older ones are dropped.
+const forkchoiceHistorySize = 256
+
+// controls is the behavior of the engine that can be changed at runtime through the mock namespace.
+type controls struct {
+	mu         sync.RWMutex
+	delays     *Delayer
+	frozen     bool
+	forkchoice types.ForkchoiceStateV1
+	history    []ForkchoiceRecord
+}
+
+// ForkchoiceRecord is a forkchoice state the engine applied, with when it did.
+type ForkchoiceRecord struct {
+	Time time.Time `json:"time"`
+	types.ForkchoiceStateV1
+}
+
+func (c *controls) Delays() *Delayer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.delays
+}
+
+func (c *controls) SetDelays(d *Delayer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = d
+}
+
+func (c *controls) Frozen() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.frozen
+}
+
+func (c *controls) SetFrozen(frozen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frozen = frozen
+}
+
+func (c *controls) Forkchoice() types.ForkchoiceStateV1 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.forkchoice
+}
+
+func (c *controls) SetForkchoice(heads types.ForkchoiceStateV1) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forkchoice = heads
+	c.history = append(c.history, ForkchoiceRecord{time.Now().UTC(), heads})
+	if len(c.history) > forkchoiceHistorySize {
+		c.history = append([]ForkchoiceRecord(nil), c.history[len(c.history)-forkchoiceHistorySize:]...)
+	}
+}
+
+// ForkchoiceHistory returns the last applied forkchoice states, oldest first.
+func (c *controls) ForkchoiceHistory() []ForkchoiceRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ForkchoiceRecord(nil), c.history...)
+}
+
+// parseDelays parses delays keyed by the names of the --delay flags, e.g. {"newpayload": "1s", "spike-probability": "0.1"}.
+func parseDelays(delays map[string]string) (*DelayConfig, error) {
+	var cfg DelayConfig
+	for key, value := range delays {
+		var dst *time.Duration
+		switch key {
+		case "newpayload":
+			dst = &cfg.NewPayload
+		case "fcu":
+			dst = &cfg.ForkchoiceUpdated
+		case "getpayload":
+			dst = &cfg.GetPayload
+		case "jitter":
+			dst = &cfg.Jitter
+		case "spike":
+			dst = &cfg.Spike
+		case "spike-probability":
+			p, err := strconv.ParseFloat(value, 64)
+			if err != nil || p < 0 || p > 1 {
+				return nil, fmt.Errorf("invalid spike probability %q", value)
+			}
+			cfg.SpikeProbability = p
+			continue
+		default:
+			return nil, fmt.Errorf("unknown delay %q", key)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s delay: %v", key, err)
+		}
+		*dst = d
+	}
+	return &cfg, nil
+}
+
+// SetDelays replaces the delays of engine calls, keyed by the names of the --delay flags. No delays stops
+// delaying calls.
+func (b *MockBackend) SetDelays(ctx context.Context, delays map[string]string) error {
+	cfg, err := parseDelays(delays)
+	if err != nil {
+		return err
+	}
+	b.engine.control.SetDelays(cfg.NewDelayer())
+	b.engine.log.WithField("delays", delays).Warn("Changed delays")
+	return nil
+}
+
+// SetFaultsEnabled pauses or resumes the injection of faults, keeping the rules.
+func (b *MockBackend) SetFaultsEnabled(ctx context.Context, enabled bool) {
+	b.engine.faults.SetPaused(!enabled)
+	b.engine.log.WithField("enabled", enabled).Warn("Toggled fault injection")
+}
+
+// RotateJwtSecret replaces the secret tokens must be signed with, by a random one if not given, and returns it.
+// Clients keep being rejected until they use the new secret.
+func (b *MockBackend) RotateJwtSecret(ctx context.Context, secret *hexutil.Bytes) (hexutil.Bytes, error) {
+	var next []byte
+	if secret != nil {
+		if len(*secret) != 32 {
+			return nil, fmt.Errorf("invalid JWT secret length %d, expected 32 bytes", len(*secret))
+		}
+		next = *secret
+	} else {
+		next = make([]byte, 32)
+		if _, err := rand.Read(next); err != nil {
+			return nil, err
+		}
+	}
+	b.engine.auth.SetSecret(next)
+	b.engine.log.Warn("Rotated JWT secret")
+	return next, nil
+}
+
+// Freeze stops or resumes block production: while frozen, forkchoiceUpdated doesn't start payload builds.
+func (b *MockBackend) Freeze(ctx context.Context, frozen bool) {
+	b.engine.control.SetFrozen(frozen)
+	b.engine.log.WithField("frozen", frozen).Warn("Toggled block production")
+}
+
+// FlushPayloads empties the payload cache, and returns how many payloads were dropped.
+func (b *MockBackend) FlushPayloads(ctx context.Context) int {
+	n := len(b.engine.cachedPayloads())
+	b.engine.recentPayloads.Purge()
+	b.engine.parentPayloads.Purge()
+	b.engine.log.WithField("payloads", n).Warn("Flushed payload cache")
+	return n
+}
+
+// CachedPayload is a built payload of the payload cache, retrievable with getPayload.
+type CachedPayload struct {
+	PayloadID   types.PayloadID `json:"payloadId"`
+	BlockHash   common.Hash     `json:"blockHash"`
+	ParentHash  common.Hash     `json:"parentHash"`
+	BlockNumber hexutil.Uint64  `json:"blockNumber"`
+	Timestamp   hexutil.Uint64  `json:"timestamp"`
+	Txs         int             `json:"transactions"`
+	GasUsed     hexutil.Uint64  `json:"gasUsed"`
+	// BuildTimeMs and BuildCPUTimeMs are the wall and CPU time of the build, 0 for restored payloads.
+	BuildTimeMs    float64 `json:"buildTimeMs"`
+	BuildCPUTimeMs float64 `json:"buildCpuTimeMs"`
+}
+
+// EngineState is the internal state of the engine, for tests to assert on.
+type EngineState struct {
+	Head          common.Hash     `json:"head"`
+	Number        uint64          `json:"number"`
+	Safe          common.Hash     `json:"safe"`
+	Finalized     common.Hash     `json:"finalized"`
+	Frozen        bool            `json:"frozen"`
+	FaultsEnabled bool            `json:"faultsEnabled"`
+	Payloads      []CachedPayload `json:"payloads"`
+}
+
+// State returns the head, the safe and finalized blocks of the last forkchoiceUpdated, and the payload cache.
+func (b *MockBackend) State(ctx context.Context) *EngineState {
+	head := b.engine.mockChain.CurrentHeader()
+	heads := b.engine.control.Forkchoice()
+	return &EngineState{
+		Head:          b.engine.mockChain.SpecHash(head.Hash()),
+		Number:        head.Number.Uint64(),
+		Safe:          heads.SafeBlockHash,
+		Finalized:     heads.FinalizedBlockHash,
+		Frozen:        b.engine.control.Frozen(),
+		FaultsEnabled: !b.engine.faults.Paused(),
+		Payloads:      b.engine.cachedPayloads(),
+	}
+}
+
+// cachedPayloads returns the built payloads of the cache, by payload id.
+func (e *EngineBackend) cachedPayloads() []CachedPayload {
+	e.expirePayloads()
+	payloads := []CachedPayload{}
+	for _, key := range e.recentPayloads.Keys() {
+		id, ok := key.(types.PayloadID)
+		if !ok {
+			continue
+		}
+		value, ok := e.recentPayloads.Peek(id)
+		if !ok {
+			continue
+		}
+		built := value.(*builtPayload)
+		var cached CachedPayload
+		if p := built.v3; p != nil {
+			cached = CachedPayload{id, p.BlockHash, p.ParentHash, hexutil.Uint64(p.Number), hexutil.Uint64(p.Timestamp), len(p.Transactions), hexutil.Uint64(p.GasUsed), milliseconds(built.cost.WallTime), milliseconds(built.cost.CPUTime)}
+		} else {
+			p := built.v2
+			cached = CachedPayload{id, p.BlockHash, p.ParentHash, hexutil.Uint64(p.Number), hexutil.Uint64(p.Timestamp), len(p.Transactions), hexutil.Uint64(p.GasUsed), milliseconds(built.cost.WallTime), milliseconds(built.cost.CPUTime)}
+		}
+		payloads = append(payloads, cached)
+	}
+	sort.Slice(payloads, func(i, j int) bool {
+		return string(payloads[i].PayloadID[:]) < string(payloads[j].PayloadID[:])
+	})
+	return payloads
+}
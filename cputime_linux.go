@@ -0,0 +1,15 @@
+package mergemock
+
+import (
+	"syscall"
+	"time"
+)
+
+// threadCPUTime returns the CPU time spent by the calling thread, which must be locked to its goroutine.
+func threadCPUTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_THREAD, &usage); err != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package mergemock
+
+import "time"
+
+// threadCPUTime is unknown outside of linux, builds are accounted without CPU time.
+func threadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
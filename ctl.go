@@ -0,0 +1,276 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mergemock/rpc"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type CtlCmd struct {
+	JwtSecretPath string        `ask:"--jwt-secret" help:"JWT secret key of the instance (empty to call without authentication)"`
+	Timeout       time.Duration `ask:"--timeout" help:"Timeout of the call"`
+
+	out io.Writer
+}
+
+func (c *CtlCmd) Default() {
+	c.Timeout = 10 * time.Second
+}
+
+func (c *CtlCmd) Help() string {
+	commands := make([]string, 0, len(ctlCommands))
+	for name, cmd := range ctlCommands {
+		commands = append(commands, fmt.Sprintf("  %-34s %s", name+" "+cmd.args, cmd.help))
+	}
+	sort.Strings(commands)
+	return "Control a running mergemock instance: mergemock ctl [flags] <endpoint> <command> [args...]\n\nCommands:\n" + strings.Join(commands, "\n")
+}
+
+// ctlCommand is a call to the mock namespace, made from the command line arguments.
+type ctlCommand struct {
+	args string
+	help string
+	// call returns the JSON-RPC method and its params.
+	call func(args []string) (string, []interface{}, error)
+}
+
+func noArgs(method string) func(args []string) (string, []interface{}, error) {
+	return func(args []string) (string, []interface{}, error) {
+		if len(args) != 0 {
+			return "", nil, fmt.Errorf("expected no arguments")
+		}
+		return method, nil, nil
+	}
+}
+
+// constArgs calls the method with fixed params.
+func constArgs(method string, params ...interface{}) func(args []string) (string, []interface{}, error) {
+	return func(args []string) (string, []interface{}, error) {
+		if len(args) != 0 {
+			return "", nil, fmt.Errorf("expected no arguments")
+		}
+		return method, params, nil
+	}
+}
+
+func hashArg(method string) func(args []string) (string, []interface{}, error) {
+	return func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a block hash")
+		}
+		var hash common.Hash
+		if err := hash.UnmarshalText([]byte(args[0])); err != nil {
+			return "", nil, fmt.Errorf("invalid block hash: %v", err)
+		}
+		return method, []interface{}{hash}, nil
+	}
+}
+
+var ctlCommands = map[string]ctlCommand{
+	"version":    {"", "Show the version of the instance", noArgs("mock_version")},
+	"stats":      {"", "Show the head, payload, fault, latency and per-method call counters", noArgs("mock_stats")},
+	"reorg":      {"<block-hash>", "Make a known block of another branch the canonical head", hashArg("mock_reorg")},
+	"save-state": {"", "Show the payload cache, forkchoice state and payload id counter, to restore with restore-state", noArgs("mock_saveState")},
+	"restore-state": {"<file>", "Restore the engine state written by save-state to the file", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a file")
+		}
+		buf, err := os.ReadFile(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "mock_restoreState", []interface{}{json.RawMessage(buf)}, nil
+	}},
+	"validate":   {"<block-hash>", "Settle an optimistically imported block, and its ancestors, as VALID", hashArg("mock_validateBlock")},
+	"invalidate": {"<block-hash>", "Settle an optimistically imported block, and its descendants, as INVALID", hashArg("mock_invalidateBlock")},
+	"fault": {"<rule>", "Inject a fault, the rule as for --fault.rule", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a fault rule")
+		}
+		rule, err := ParseFaultRule(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "mock_injectFault", []interface{}{rule}, nil
+	}},
+	"remove-fault": {"<id>", "Remove the fault rule with the id", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a fault rule id")
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid fault rule id: %v", err)
+		}
+		return "mock_removeFault", []interface{}{id}, nil
+	}},
+	"clear-faults": {"", "Remove all fault rules", noArgs("mock_clearFaults")},
+	"faults":       {"", "List the fault rules with their counters", noArgs("mock_faults")},
+	"gas-limit": {"<limit> [block-number]", "Change the gas limit of built payloads", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return "", nil, fmt.Errorf("expected a gas limit and optionally a block number")
+		}
+		params := make([]interface{}, 0, len(args))
+		for _, arg := range args {
+			v, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid number %q: %v", arg, err)
+			}
+			params = append(params, hexutil.Uint64(v))
+		}
+		return "mock_setGasLimit", params, nil
+	}},
+	"accounts":    {"", "Show the test accounts derived from the mnemonic, with their keys", noArgs("mock_accounts")},
+	"beacon-root": {"<block-hash>", "Show the parent beacon block root a Cancun block was built or imported with", hashArg("mock_getParentBeaconBlockRoot")},
+	"build-log":   {"<block-hash>", "Show why candidate transactions of a built block were included or not", hashArg("mock_getBuildLog")},
+	"trigger-reorg": {"<depth> <blocks>", "Replace the top depth blocks of the canonical chain with a fork of empty blocks", func(args []string) (string, []interface{}, error) {
+		if len(args) != 2 {
+			return "", nil, fmt.Errorf("expected a depth and a number of blocks")
+		}
+		params := make([]interface{}, 0, len(args))
+		for _, arg := range args {
+			v, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid number %q: %v", arg, err)
+			}
+			params = append(params, v)
+		}
+		return "mock_triggerReorg", params, nil
+	}},
+	"branches": {"<depth>", "Show the tips of the branches forking off the canonical chain at most depth blocks below the head", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a depth")
+		}
+		depth, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid depth: %v", err)
+		}
+		return "mock_getBranches", []interface{}{hexutil.Uint64(depth)}, nil
+	}},
+	"payload-mismatches": {"", "Show the payloads the consensus client submitted with other fields than served", noArgs("mock_getPayloadMismatches")},
+	"state":              {"", "Show the head, safe and finalized blocks and the payload cache", noArgs("mock_state")},
+	"client-heads":       {"", "Show the last forkchoice head of every consensus client driving the engine", noArgs("mock_clientHeads")},
+	"forkchoice-calls":   {"", "Show the latest forkchoice states received, with the status they were answered with", noArgs("mock_getForkchoiceCalls")},
+	"journal": {"[block-hash]", "Show the mutations of the chain in order, of the block only if given", func(args []string) (string, []interface{}, error) {
+		if len(args) == 0 {
+			return "mock_getJournal", nil, nil
+		}
+		return hashArg("mock_getJournal")(args)
+	}},
+	"delays": {"[name=duration...]", "Replace the delays of engine calls, named as the --delay flags", func(args []string) (string, []interface{}, error) {
+		delays := make(map[string]string, len(args))
+		for _, arg := range args {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return "", nil, fmt.Errorf("expected name=duration, got %q", arg)
+			}
+			delays[name] = value
+		}
+		return "mock_setDelays", []interface{}{delays}, nil
+	}},
+	"clock-offset": {"<duration>", "Change the offset of the engine clock to the system clock, e.g. -1.5s", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a duration")
+		}
+		return "mock_setClockOffset", []interface{}{args[0]}, nil
+	}},
+	"rotate-jwt": {"[secret]", "Replace the JWT secret of the engine, by a random one if not given", func(args []string) (string, []interface{}, error) {
+		if len(args) == 0 {
+			return "mock_rotateJwtSecret", nil, nil
+		}
+		return "mock_rotateJwtSecret", []interface{}{args[0]}, nil
+	}},
+	"peer-count": {"<count>", "Change the number of fake peers of the engine", func(args []string) (string, []interface{}, error) {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("expected a peer count")
+		}
+		count, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid peer count: %v", err)
+		}
+		return "mock_setPeerCount", []interface{}{hexutil.Uint64(count)}, nil
+	}},
+	"pause-faults":   {"", "Stop injecting faults, keeping the rules", constArgs("mock_setFaultsEnabled", false)},
+	"resume-faults":  {"", "Resume injecting faults", constArgs("mock_setFaultsEnabled", true)},
+	"freeze":         {"", "Stop building payloads on forkchoiceUpdated", constArgs("mock_freeze", true)},
+	"unfreeze":       {"", "Resume building payloads", constArgs("mock_freeze", false)},
+	"flush-payloads": {"", "Empty the payload cache", noArgs("mock_flushPayloads")},
+}
+
+func (c *CtlCmd) Run(ctx context.Context, args ...string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected an endpoint and a command, see --help")
+	}
+	endpoint, name := args[0], args[1]
+	if _, ok := ctlCommands[name]; !ok {
+		return fmt.Errorf("unknown command %q, see --help", name)
+	}
+	secret, err := loadCtlSecret(c.JwtSecretPath)
+	if err != nil {
+		return err
+	}
+	client, err := rpc.DialContext(ctx, endpoint, secret)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	out := c.out
+	if out == nil {
+		out = os.Stdout
+	}
+	return runCtlCommand(ctx, client, c.Timeout, name, args[2:], out)
+}
+
+// loadCtlSecret reads the JWT secret of the instance, none if the path is empty.
+func loadCtlSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWT secret: %v", err)
+	}
+	secret, err := parseJwtSecret(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT secret: %v", err)
+	}
+	return secret, nil
+}
+
+// runCtlCommand makes the call of the command, and writes its result as indented JSON.
+func runCtlCommand(ctx context.Context, client *rpc.Client, timeout time.Duration, name string, args []string, out io.Writer) error {
+	cmd, ok := ctlCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see --help", name)
+	}
+	method, params, err := cmd.call(args)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var result json.RawMessage
+	if err := client.CallContext(ctx, &result, method, params...); err != nil {
+		return fmt.Errorf("%s failed: %v", method, err)
+	}
+	if len(result) == 0 {
+		// methods without a result reply null, which leaves the raw message empty
+		result = json.RawMessage("null")
+	}
+	var pretty interface{}
+	if err := json.Unmarshal(result, &pretty); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(pretty)
+}
@@ -0,0 +1,184 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mergemock/api"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func runCtl(t *testing.T, te *testEngine, args ...string) []byte {
+	var out bytes.Buffer
+	cmd := &CtlCmd{JwtSecretPath: te.JwtSecretPath, out: &out}
+	cmd.Default()
+	require.NoError(t, cmd.Run(context.Background(), append([]string{"http://" + te.ListenAddr}, args...)...))
+	return out.Bytes()
+}
+
+func TestCtl(t *testing.T) {
+	te := newTestEngine(t)
+	genesis := te.mockChain().CurrentHeader()
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	b := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, b))
+	te.setHead(t, a.BlockHash)
+
+	var stats Stats
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "stats"), &stats))
+	require.Equal(t, uint64(1), stats.Number)
+	require.Equal(t, uint64(2), stats.PayloadIDs)
+	side := a.BlockHash
+	if stats.Head == a.BlockHash {
+		side = b.BlockHash
+	}
+
+	var reorg TimelineReorg
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "reorg", side.Hex()), &reorg))
+	require.Equal(t, TimelineReorg{OldHead: stats.Head, NewHead: side, Depth: 1}, reorg)
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "stats"), &stats))
+	require.Equal(t, side, stats.Head)
+
+	// The head can't be rewound to an ancestor.
+	cmd := &CtlCmd{JwtSecretPath: te.JwtSecretPath, out: new(bytes.Buffer)}
+	cmd.Default()
+	require.Error(t, cmd.Run(context.Background(), "http://"+te.ListenAddr, "reorg", genesis.Hash().Hex()))
+
+	var id uint64
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "fault", "method=engine_newPayloadV1;action=status;status=SYNCING"), &id))
+	var rules []FaultRule
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "faults"), &rules))
+	require.Len(t, rules, 1)
+	require.Equal(t, id, rules[0].ID)
+	require.Equal(t, "true\n", string(runCtl(t, te, "remove-fault", strconv.FormatUint(id, 10))))
+}
+
+func TestCtlControlPlane(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+
+	var state EngineState
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "state"), &state))
+	require.Equal(t, genesis.Hash(), state.Finalized)
+	require.True(t, state.FaultsEnabled)
+	require.Len(t, state.Payloads, 1)
+	require.Equal(t, payload.BlockHash, state.Payloads[0].BlockHash)
+	require.Equal(t, "1\n", string(runCtl(t, te, "flush-payloads")))
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "state"), &state))
+	require.Empty(t, state.Payloads)
+
+	runCtl(t, te, "freeze")
+	attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 12, SuggestedFeeRecipient: common.Address{0x02}}
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+	require.Nil(t, result.PayloadID, "no payload is built while frozen")
+	runCtl(t, te, "unfreeze")
+	te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x02})
+
+	runCtl(t, te, "fault", "method=engine_newPayloadV1;action=status;status=SYNCING")
+	runCtl(t, te, "pause-faults")
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	runCtl(t, te, "resume-faults")
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payload))
+	runCtl(t, te, "clear-faults")
+
+	runCtl(t, te, "delays", "newpayload=300ms")
+	start := time.Now()
+	te.newPayload(t, payload)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	runCtl(t, te, "delays")
+	require.Nil(t, te.backend.control.Delays())
+
+	_, err = parseDelays(map[string]string{"newpayload": "soon"})
+	require.Error(t, err)
+	_, err = parseDelays(map[string]string{"build": "1s"})
+	require.Error(t, err)
+}
+
+func TestCtlUsageErrors(t *testing.T) {
+	cmd := new(CtlCmd)
+	cmd.Default()
+	ctx := context.Background()
+	require.Error(t, cmd.Run(ctx, "http://127.0.0.1:8551"))
+	require.Error(t, cmd.Run(ctx, "http://127.0.0.1:8551", "unknown"))
+	require.Error(t, cmd.Run(ctx, "http://127.0.0.1:8551", "reorg"))
+	require.Error(t, cmd.Run(ctx, "http://127.0.0.1:8551", "gas-limit", "abc"))
+}
+
+func TestCtlTriggerReorg(t *testing.T) {
+	te := newTestEngine(t)
+	parent := te.mockChain().CurrentHeader()
+	parentHash, timestamp := parent.Hash(), parent.Time
+	for i := 0; i < 3; i++ {
+		timestamp += 12
+		payload := te.buildPayload(t, parentHash, timestamp, common.Hash{0x01})
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+		parentHash = payload.BlockHash
+	}
+	te.setHead(t, parentHash)
+
+	var reorg TimelineReorg
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "trigger-reorg", "2", "3"), &reorg))
+	require.Equal(t, parentHash, reorg.OldHead)
+	require.Equal(t, uint64(2), reorg.Depth)
+	head := te.mockChain().CurrentHeader()
+	require.Equal(t, uint64(4), head.Number.Uint64())
+	require.Equal(t, reorg.NewHead, head.Hash())
+	require.True(t, bytes.HasPrefix(head.Extra, forkExtraData), "head is not a fork block")
+	firstFork := reorg.NewHead
+
+	// Forks no longer than the replaced blocks are made canonical too.
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "trigger-reorg", "1", "1"), &reorg))
+	require.Equal(t, head.Hash(), reorg.OldHead)
+	require.NotEqual(t, head.Hash(), reorg.NewHead)
+	require.Equal(t, reorg.NewHead, te.mockChain().CurrentHeader().Hash())
+	require.Equal(t, uint64(4), te.mockChain().CurrentHeader().Number.Uint64())
+
+	// The replaced branch stays known, and can be built on.
+	payload := te.buildPayload(t, parentHash, timestamp+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	var branches []Branch
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "branches", "4"), &branches))
+	tips := make([]common.Hash, 0, len(branches))
+	for _, b := range branches {
+		tips = append(tips, b.Tip)
+	}
+	require.ElementsMatch(t, []common.Hash{firstFork, reorg.NewHead, payload.BlockHash}, tips, "tips of all branches")
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "branches", "1"), &branches))
+	require.Len(t, branches, 1, "the branches forking deeper left out")
+
+	cmd := &CtlCmd{JwtSecretPath: te.JwtSecretPath, out: new(bytes.Buffer)}
+	cmd.Default()
+	require.Error(t, cmd.Run(context.Background(), "http://"+te.ListenAddr, "trigger-reorg", "9", "1"), "deeper than the chain")
+}
+
+func TestCtlSaveRestoreState(t *testing.T) {
+	te := newTestEngine(t)
+	genesis := te.mockChain().CurrentHeader()
+	ctx := context.Background()
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), &types.PayloadAttributesV1{Timestamp: genesis.Time + 12})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, runCtl(t, te, "save-state"), 0644))
+	runCtl(t, te, "flush-payloads")
+	_, err = api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	require.Error(t, err)
+
+	runCtl(t, te, "restore-state", path)
+	payload, err := api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, genesis.Hash(), payload.ParentHash)
+}
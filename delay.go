@@ -0,0 +1,95 @@
+package mergemock
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DelayConfig struct {
+	NewPayload        time.Duration `ask:"--newpayload" help:"Delay of engine_newPayload calls"`
+	ForkchoiceUpdated time.Duration `ask:"--fcu" help:"Delay of engine_forkchoiceUpdated calls"`
+	GetPayload        time.Duration `ask:"--getpayload" help:"Delay of engine_getPayload calls"`
+	Jitter            time.Duration `ask:"--jitter" help:"Maximum random delay added to every delayed call"`
+	SpikeProbability  float64       `ask:"--spike-probability" help:"Probability of a long-tail spike being added to a delayed call"`
+	Spike             time.Duration `ask:"--spike" help:"Delay of a long-tail spike"`
+	Seed              int64         `ask:"--seed" help:"Seed of the jitter and spikes (0 for a random seed)"`
+}
+
+// overriddenBy returns the config with the delays that over sets to another value than base replaced, like the
+// flags of the command line override those of the config file.
+func (c DelayConfig) overriddenBy(base, over DelayConfig) DelayConfig {
+	v, b, o := reflect.ValueOf(&c).Elem(), reflect.ValueOf(base), reflect.ValueOf(over)
+	for i := 0; i < v.NumField(); i++ {
+		if b.Field(i).Interface() != o.Field(i).Interface() {
+			v.Field(i).Set(o.Field(i))
+		}
+	}
+	return c
+}
+
+// NewDelayer returns the delayer of the config, nil if it delays no method.
+func (c *DelayConfig) NewDelayer() *Delayer {
+	if c.NewPayload == 0 && c.ForkchoiceUpdated == 0 && c.GetPayload == 0 {
+		return nil
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Delayer{cfg: *c, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Delayer holds engine calls back, to simulate a slow engine.
+type Delayer struct {
+	cfg DelayConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// Delay returns how long to hold the call to the method back: the delay of the method, plus jitter and
+// the occasional spike. Methods without a delay are never held back.
+func (d *Delayer) Delay(method string) time.Duration {
+	if d == nil {
+		return 0
+	}
+	var delay time.Duration
+	switch {
+	case strings.HasPrefix(method, "engine_newPayload"):
+		delay = d.cfg.NewPayload
+	case strings.HasPrefix(method, "engine_forkchoiceUpdated"):
+		delay = d.cfg.ForkchoiceUpdated
+	case strings.HasPrefix(method, "engine_getPayload"):
+		delay = d.cfg.GetPayload
+	}
+	if delay == 0 {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cfg.Jitter > 0 {
+		delay += time.Duration(d.rng.Int63n(int64(d.cfg.Jitter)))
+	}
+	if d.cfg.SpikeProbability > 0 && d.rng.Float64() < d.cfg.SpikeProbability {
+		delay += d.cfg.Spike
+	}
+	return delay
+}
+
+// Wait holds the call to the method back, or until the client gives up on it.
+func (d *Delayer) Wait(ctx context.Context, method string) error {
+	delay := d.Delay(method)
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
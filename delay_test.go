@@ -0,0 +1,32 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayer(t *testing.T) {
+	require.Nil(t, (&DelayConfig{Jitter: time.Second}).NewDelayer())
+	var none *Delayer
+	require.Zero(t, none.Delay("engine_newPayloadV1"))
+
+	d := (&DelayConfig{NewPayload: 500 * time.Millisecond, ForkchoiceUpdated: 2 * time.Second, Seed: 1}).NewDelayer()
+	require.Equal(t, 500*time.Millisecond, d.Delay("engine_newPayloadV2"))
+	require.Equal(t, 2*time.Second, d.Delay("engine_forkchoiceUpdatedV1"))
+	require.Zero(t, d.Delay("engine_getPayloadV1"))
+
+	d = (&DelayConfig{GetPayload: time.Second, Jitter: 100 * time.Millisecond, SpikeProbability: 1, Spike: 5 * time.Second, Seed: 1}).NewDelayer()
+	for i := 0; i < 10; i++ {
+		delay := d.Delay("engine_getPayloadV1")
+		require.GreaterOrEqual(t, delay, 6*time.Second)
+		require.Less(t, delay, 6100*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, d.Wait(ctx, "engine_getPayloadV1"), context.Canceled)
+	require.NoError(t, d.Wait(ctx, "engine_exchangeTransitionConfigurationV1"))
+}
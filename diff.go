@@ -0,0 +1,516 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mergemock/rpc"
+	"mergemock/types"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+type DiffCmd struct {
+	RPC           string `ask:"--rpc" help:"Address of an eth JSON-RPC endpoint to fetch the blocks from that are given by number, hash or tag (e.g. latest) instead of a payload file"`
+	JwtSecretPath string `ask:"--jwt-secret" help:"JWT secret key of the endpoint (empty to call without authentication)"`
+	BeaconRoot    string `ask:"--beacon-root" help:"Parent beacon block root of Cancun payloads given without one, to recompute their block hash"`
+	OutPath       string `ask:"--out" help:"File to write the diff to (empty for stdout)"`
+
+	out io.Writer
+}
+
+func (c *DiffCmd) Default() {}
+
+func (c *DiffCmd) Help() string {
+	return "Compare two payloads field by field, with the block hash, transactions root and receipts root recomputed from their contents: mergemock diff [flags] <a> <b>\n\n" +
+		"Each of a and b is a JSON file of a payload, a getPayload result or response, or a newPayload request, or with --rpc a block number, hash or tag."
+}
+
+// PayloadFieldDiff is a field with different values in the compared payloads, null if missing.
+type PayloadFieldDiff struct {
+	Field string          `json:"field"`
+	A     json.RawMessage `json:"a"`
+	B     json.RawMessage `json:"b"`
+}
+
+// PayloadRoots are the block hash and roots a compared payload commits to, as given and as recomputed from its
+// contents. Payloads have no transactions and withdrawals roots, those are given by blocks only. The receipts
+// root is recomputed from the receipts of blocks, payloads have none.
+type PayloadRoots struct {
+	Source                   string       `json:"source"`
+	BlockHash                common.Hash  `json:"blockHash"`
+	ComputedBlockHash        *common.Hash `json:"computedBlockHash"`
+	TransactionsRoot         *common.Hash `json:"transactionsRoot,omitempty"`
+	ComputedTransactionsRoot *common.Hash `json:"computedTransactionsRoot"`
+	WithdrawalsRoot          *common.Hash `json:"withdrawalsRoot,omitempty"`
+	ComputedWithdrawalsRoot  *common.Hash `json:"computedWithdrawalsRoot,omitempty"`
+	ReceiptsRoot             common.Hash  `json:"receiptsRoot"`
+	ComputedReceiptsRoot     *common.Hash `json:"computedReceiptsRoot,omitempty"`
+	// Mismatches are the given hash and roots that differ from the recomputed ones.
+	Mismatches []string `json:"mismatches"`
+	// Error is why the block hash or the transactions root couldn't be recomputed.
+	Error string `json:"error,omitempty"`
+}
+
+// PayloadDiff is the comparison of two payloads.
+type PayloadDiff struct {
+	A           PayloadRoots       `json:"a"`
+	B           PayloadRoots       `json:"b"`
+	Differences []PayloadFieldDiff `json:"differences"`
+}
+
+// diffPayload is a payload to compare, with what its block header commits to besides the payload.
+type diffPayload struct {
+	source           string
+	payload          *types.ExecutionPayloadV1
+	withdrawals      []*types.Withdrawal
+	blobGasUsed      *hexutil.Uint64
+	excessBlobGas    *hexutil.Uint64
+	parentBeaconRoot *common.Hash
+	requestsHash     *common.Hash
+	transactionsRoot *common.Hash
+	withdrawalsRoot  *common.Hash
+	receipts         ethTypes.Receipts
+}
+
+func (c *DiffCmd) Run(ctx context.Context, args ...string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected two payloads to compare, see --help")
+	}
+	var beaconRoot *common.Hash
+	if c.BeaconRoot != "" {
+		var root common.Hash
+		if err := root.UnmarshalText([]byte(c.BeaconRoot)); err != nil {
+			return fmt.Errorf("invalid parent beacon block root: %v", err)
+		}
+		beaconRoot = &root
+	}
+	var client *rpc.Client
+	if c.RPC != "" {
+		secret, err := loadCtlSecret(c.JwtSecretPath)
+		if err != nil {
+			return err
+		}
+		if client, err = rpc.DialContext(ctx, c.RPC, secret); err != nil {
+			return err
+		}
+		defer client.Close()
+	}
+	var payloads [2]*diffPayload
+	for i, arg := range args {
+		p, err := loadDiffPayload(ctx, client, arg)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", arg, err)
+		}
+		if p.parentBeaconRoot == nil {
+			p.parentBeaconRoot = beaconRoot
+		}
+		payloads[i] = p
+	}
+	diff, err := diffPayloads(payloads[0], payloads[1])
+	if err != nil {
+		return err
+	}
+
+	out := c.out
+	if out == nil {
+		out = os.Stdout
+	}
+	if c.OutPath != "" {
+		f, err := os.Create(c.OutPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diff)
+}
+
+// loadDiffPayload reads the payload from the file at the path, or else fetches the block it identifies.
+func loadDiffPayload(ctx context.Context, client *rpc.Client, arg string) (*diffPayload, error) {
+	data, err := os.ReadFile(arg)
+	if err == nil {
+		p, err := parseDiffPayload(data)
+		if err != nil {
+			return nil, err
+		}
+		p.source = arg
+		return p, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if client == nil {
+		return nil, fmt.Errorf("no such file, and no --rpc endpoint to fetch a block from")
+	}
+	return fetchDiffPayload(ctx, client, arg)
+}
+
+// parseDiffPayload decodes a payload of any version, bare or in a getPayload result, JSON-RPC response or
+// newPayload request, which come with the parent beacon block root and execution requests of later forks.
+func parseDiffPayload(data []byte) (*diffPayload, error) {
+	var msg struct {
+		Method            string                   `json:"method"`
+		Params            []json.RawMessage        `json:"params"`
+		Result            json.RawMessage          `json:"result"`
+		ExecutionPayload  json.RawMessage          `json:"executionPayload"`
+		ExecutionRequests *types.ExecutionRequests `json:"executionRequests"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(msg.Method, "engine_newPayload"):
+		if len(msg.Params) == 0 {
+			return nil, fmt.Errorf("%s request without params", msg.Method)
+		}
+		p, err := decodeDiffPayload(msg.Params[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Params) > 2 {
+			var root common.Hash
+			if err := json.Unmarshal(msg.Params[2], &root); err != nil {
+				return nil, fmt.Errorf("invalid parent beacon block root: %v", err)
+			}
+			p.parentBeaconRoot = &root
+		}
+		if len(msg.Params) > 3 {
+			var requests types.ExecutionRequests
+			if err := json.Unmarshal(msg.Params[3], &requests); err != nil {
+				return nil, fmt.Errorf("invalid execution requests: %v", err)
+			}
+			hash := requests.Hash()
+			p.requestsHash = &hash
+		}
+		return p, nil
+	case len(msg.Result) > 0:
+		return parseDiffPayload(msg.Result)
+	case len(msg.ExecutionPayload) > 0:
+		p, err := decodeDiffPayload(msg.ExecutionPayload)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ExecutionRequests != nil {
+			hash := msg.ExecutionRequests.Hash()
+			p.requestsHash = &hash
+		}
+		return p, nil
+	}
+	return decodeDiffPayload(data)
+}
+
+// decodeDiffPayload decodes the payload, with the fields of later versions where present.
+func decodeDiffPayload(data []byte) (*diffPayload, error) {
+	var payload types.ExecutionPayloadV1
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	var fork struct {
+		Withdrawals   []*types.Withdrawal `json:"withdrawals"`
+		BlobGasUsed   *hexutil.Uint64     `json:"blobGasUsed"`
+		ExcessBlobGas *hexutil.Uint64     `json:"excessBlobGas"`
+	}
+	if err := json.Unmarshal(data, &fork); err != nil {
+		return nil, err
+	}
+	return &diffPayload{
+		payload:       &payload,
+		withdrawals:   fork.Withdrawals,
+		blobGasUsed:   fork.BlobGasUsed,
+		excessBlobGas: fork.ExcessBlobGas,
+	}, nil
+}
+
+// diffBlock is a block as returned by eth_getBlockByHash and eth_getBlockByNumber with full transactions.
+type diffBlock struct {
+	Hash                  common.Hash             `json:"hash"`
+	ParentHash            common.Hash             `json:"parentHash"`
+	Miner                 common.Address          `json:"miner"`
+	StateRoot             common.Hash             `json:"stateRoot"`
+	ReceiptsRoot          common.Hash             `json:"receiptsRoot"`
+	LogsBloom             ethTypes.Bloom          `json:"logsBloom"`
+	MixHash               common.Hash             `json:"mixHash"`
+	Number                hexutil.Uint64          `json:"number"`
+	GasLimit              hexutil.Uint64          `json:"gasLimit"`
+	GasUsed               hexutil.Uint64          `json:"gasUsed"`
+	Timestamp             hexutil.Uint64          `json:"timestamp"`
+	ExtraData             hexutil.Bytes           `json:"extraData"`
+	BaseFeePerGas         *hexutil.Big            `json:"baseFeePerGas"`
+	TransactionsRoot      common.Hash             `json:"transactionsRoot"`
+	Transactions          []*ethTypes.Transaction `json:"transactions"`
+	Withdrawals           []*types.Withdrawal     `json:"withdrawals"`
+	WithdrawalsRoot       *common.Hash            `json:"withdrawalsRoot"`
+	BlobGasUsed           *hexutil.Uint64         `json:"blobGasUsed"`
+	ExcessBlobGas         *hexutil.Uint64         `json:"excessBlobGas"`
+	ParentBeaconBlockRoot *common.Hash            `json:"parentBeaconBlockRoot"`
+	RequestsHash          *common.Hash            `json:"requestsHash"`
+}
+
+// fetchDiffPayload fetches the block by hash, number or tag, with the receipts of its transactions, and converts
+// it to a payload.
+func fetchDiffPayload(ctx context.Context, client *rpc.Client, id string) (*diffPayload, error) {
+	var raw json.RawMessage
+	var err error
+	if len(id) == 2+2*common.HashLength && strings.HasPrefix(id, "0x") {
+		err = client.CallContext(ctx, &raw, "eth_getBlockByHash", id, true)
+	} else {
+		tag := id
+		if n, perr := strconv.ParseUint(id, 10, 64); perr == nil {
+			tag = hexutil.EncodeUint64(n)
+		}
+		err = client.CallContext(ctx, &raw, "eth_getBlockByNumber", tag, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, fmt.Errorf("block %s not found", id)
+	}
+	var block diffBlock
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return nil, fmt.Errorf("invalid block: %v", err)
+	}
+	txs := make([][]byte, 0, len(block.Transactions))
+	receipts := make(ethTypes.Receipts, 0, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		enc, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode tx %d: %v", i, err)
+		}
+		txs = append(txs, enc)
+		var receipt *ethTypes.Receipt
+		if err := client.CallContext(ctx, &receipt, "eth_getTransactionReceipt", tx.Hash()); err != nil {
+			return nil, fmt.Errorf("failed to fetch receipt of tx %d: %v", i, err)
+		}
+		if receipt == nil {
+			return nil, fmt.Errorf("no receipt of tx %d", i)
+		}
+		receipts = append(receipts, receipt)
+	}
+	transactionsRoot := block.TransactionsRoot
+	return &diffPayload{
+		source: id,
+		payload: &types.ExecutionPayloadV1{
+			ParentHash:    block.ParentHash,
+			FeeRecipient:  block.Miner,
+			StateRoot:     block.StateRoot,
+			ReceiptsRoot:  block.ReceiptsRoot,
+			LogsBloom:     block.LogsBloom,
+			Random:        block.MixHash,
+			Number:        uint64(block.Number),
+			GasLimit:      uint64(block.GasLimit),
+			GasUsed:       uint64(block.GasUsed),
+			Timestamp:     uint64(block.Timestamp),
+			ExtraData:     block.ExtraData,
+			BaseFeePerGas: block.BaseFeePerGas.ToInt(),
+			BlockHash:     block.Hash,
+			Transactions:  txs,
+		},
+		withdrawals:      block.Withdrawals,
+		blobGasUsed:      block.BlobGasUsed,
+		excessBlobGas:    block.ExcessBlobGas,
+		parentBeaconRoot: block.ParentBeaconBlockRoot,
+		requestsHash:     block.RequestsHash,
+		transactionsRoot: &transactionsRoot,
+		withdrawalsRoot:  block.WithdrawalsRoot,
+		receipts:         receipts,
+	}, nil
+}
+
+// versioned returns the payload in the version of the fields it has.
+func (p *diffPayload) versioned() interface{} {
+	v2 := &types.ExecutionPayloadV2{
+		ParentHash:    p.payload.ParentHash,
+		FeeRecipient:  p.payload.FeeRecipient,
+		StateRoot:     p.payload.StateRoot,
+		ReceiptsRoot:  p.payload.ReceiptsRoot,
+		LogsBloom:     p.payload.LogsBloom,
+		Random:        p.payload.Random,
+		Number:        p.payload.Number,
+		GasLimit:      p.payload.GasLimit,
+		GasUsed:       p.payload.GasUsed,
+		Timestamp:     p.payload.Timestamp,
+		ExtraData:     p.payload.ExtraData,
+		BaseFeePerGas: p.payload.BaseFeePerGas,
+		BlockHash:     p.payload.BlockHash,
+		Transactions:  p.payload.Transactions,
+		Withdrawals:   p.withdrawals,
+	}
+	switch {
+	case p.blobGasUsed != nil || p.excessBlobGas != nil:
+		v3 := &types.ExecutionPayloadV3{
+			ParentHash:    v2.ParentHash,
+			FeeRecipient:  v2.FeeRecipient,
+			StateRoot:     v2.StateRoot,
+			ReceiptsRoot:  v2.ReceiptsRoot,
+			LogsBloom:     v2.LogsBloom,
+			Random:        v2.Random,
+			Number:        v2.Number,
+			GasLimit:      v2.GasLimit,
+			GasUsed:       v2.GasUsed,
+			Timestamp:     v2.Timestamp,
+			ExtraData:     v2.ExtraData,
+			BaseFeePerGas: v2.BaseFeePerGas,
+			BlockHash:     v2.BlockHash,
+			Transactions:  v2.Transactions,
+			Withdrawals:   v2.Withdrawals,
+		}
+		if p.blobGasUsed != nil {
+			v3.BlobGasUsed = uint64(*p.blobGasUsed)
+		}
+		if p.excessBlobGas != nil {
+			v3.ExcessBlobGas = uint64(*p.excessBlobGas)
+		}
+		return v3
+	case p.withdrawals != nil:
+		return v2
+	}
+	return p.payload
+}
+
+// forkFields returns the header fields added after London, nil before Shanghai. Cancun payloads need the parent
+// beacon block root, which isn't part of the payload.
+func (p *diffPayload) forkFields() (*types.ForkFields, error) {
+	if p.withdrawals == nil && p.blobGasUsed == nil {
+		return nil, nil
+	}
+	withdrawals := p.withdrawals
+	if withdrawals == nil {
+		withdrawals = []*types.Withdrawal{}
+	}
+	fork := &types.ForkFields{Withdrawals: withdrawals}
+	if p.blobGasUsed != nil {
+		if p.parentBeaconRoot == nil {
+			return nil, fmt.Errorf("no parent beacon block root for the block hash of a cancun payload, see --beacon-root")
+		}
+		var blobGasUsed, excessBlobGas uint64
+		blobGasUsed = uint64(*p.blobGasUsed)
+		if p.excessBlobGas != nil {
+			excessBlobGas = uint64(*p.excessBlobGas)
+		}
+		fork.BlobGasUsed, fork.ExcessBlobGas, fork.ParentBeaconRoot = &blobGasUsed, &excessBlobGas, p.parentBeaconRoot
+		fork.RequestsHash = p.requestsHash
+	}
+	return fork, nil
+}
+
+// roots recomputes the block hash and roots of the payload, and checks them against the given ones.
+func (p *diffPayload) roots() PayloadRoots {
+	roots := PayloadRoots{
+		Source:           p.source,
+		BlockHash:        p.payload.BlockHash,
+		TransactionsRoot: p.transactionsRoot,
+		WithdrawalsRoot:  p.withdrawalsRoot,
+		ReceiptsRoot:     p.payload.ReceiptsRoot,
+		Mismatches:       []string{},
+	}
+	mismatch := func(name string, given common.Hash, computed *common.Hash) {
+		if computed != nil && given != *computed {
+			roots.Mismatches = append(roots.Mismatches, name)
+		}
+	}
+	txs := make(ethTypes.Transactions, 0, len(p.payload.Transactions))
+	for i, enc := range p.payload.Transactions {
+		var tx ethTypes.Transaction
+		if err := tx.UnmarshalBinary(enc); err != nil {
+			roots.Error = fmt.Sprintf("failed to decode tx %d: %v", i, err)
+			break
+		}
+		txs = append(txs, &tx)
+	}
+	if roots.Error == "" {
+		root := ethTypes.DeriveSha(txs, trie.NewStackTrie(nil))
+		roots.ComputedTransactionsRoot = &root
+		if p.transactionsRoot != nil {
+			mismatch("transactionsRoot", *p.transactionsRoot, &root)
+		}
+		if fork, err := p.forkFields(); err != nil {
+			roots.Error = err.Error()
+		} else if hash, err := p.payload.ComputeHashWithForkFields(fork); err != nil {
+			roots.Error = err.Error()
+		} else {
+			roots.ComputedBlockHash = &hash
+			mismatch("blockHash", p.payload.BlockHash, &hash)
+		}
+	}
+	if p.withdrawals != nil {
+		root := types.WithdrawalsRoot(p.withdrawals)
+		roots.ComputedWithdrawalsRoot = &root
+		if p.withdrawalsRoot != nil {
+			mismatch("withdrawalsRoot", *p.withdrawalsRoot, &root)
+		}
+	}
+	if p.receipts != nil {
+		root := ethTypes.DeriveSha(p.receipts, trie.NewStackTrie(nil))
+		roots.ComputedReceiptsRoot = &root
+		mismatch("receiptsRoot", p.payload.ReceiptsRoot, &root)
+	}
+	return roots
+}
+
+// fields returns the JSON fields of the payload, with the recomputed roots and the fork fields that aren't part
+// of the payload, so that they're compared too.
+func (p *diffPayload) fields(roots PayloadRoots) (map[string]json.RawMessage, error) {
+	fields, err := payloadFields(p.versioned())
+	if err != nil {
+		return nil, err
+	}
+	extra := map[string]interface{}{
+		"computedBlockHash":        roots.ComputedBlockHash,
+		"computedTransactionsRoot": roots.ComputedTransactionsRoot,
+	}
+	if roots.ComputedWithdrawalsRoot != nil {
+		extra["computedWithdrawalsRoot"] = roots.ComputedWithdrawalsRoot
+	}
+	if roots.ComputedReceiptsRoot != nil {
+		extra["computedReceiptsRoot"] = roots.ComputedReceiptsRoot
+	}
+	if p.parentBeaconRoot != nil {
+		extra["parentBeaconBlockRoot"] = p.parentBeaconRoot
+	}
+	if p.requestsHash != nil {
+		extra["requestsHash"] = p.requestsHash
+	}
+	for name, value := range extra {
+		enc, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = enc
+	}
+	return fields, nil
+}
+
+// diffPayloads compares the payloads field by field, including the recomputed block hash and roots.
+func diffPayloads(a, b *diffPayload) (*PayloadDiff, error) {
+	diff := &PayloadDiff{A: a.roots(), B: b.roots(), Differences: []PayloadFieldDiff{}}
+	fieldsA, err := a.fields(diff.A)
+	if err != nil {
+		return nil, err
+	}
+	fieldsB, err := b.fields(diff.B)
+	if err != nil {
+		return nil, err
+	}
+	// Receipts come with blocks only, their roots are compared if both are blocks.
+	if _, ok := fieldsA["computedReceiptsRoot"]; !ok {
+		delete(fieldsB, "computedReceiptsRoot")
+	} else if _, ok := fieldsB["computedReceiptsRoot"]; !ok {
+		delete(fieldsA, "computedReceiptsRoot")
+	}
+	for _, d := range diffFields(fieldsA, fieldsB) {
+		diff.Differences = append(diff.Differences, PayloadFieldDiff{Field: d.Field, A: d.Served, B: d.Submitted})
+	}
+	return diff, nil
+}
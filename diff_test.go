@@ -0,0 +1,94 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func runDiff(t *testing.T, cmd *DiffCmd, a, b string) *PayloadDiff {
+	var out bytes.Buffer
+	cmd.out = &out
+	require.NoError(t, cmd.Run(context.Background(), a, b))
+	var diff PayloadDiff
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diff))
+	return &diff
+}
+
+func writeJSON(t *testing.T, name string, v interface{}) string {
+	path := filepath.Join(t.TempDir(), name)
+	buf, err := json.Marshal(v)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0600))
+	return path
+}
+
+func TestDiff(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)))
+	config := te.mockChain().chain.Config()
+	tx := ethTypes.MustSignNewTx(key, ethTypes.LatestSigner(config), &ethTypes.DynamicFeeTx{
+		ChainID:   config.ChainID,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(10_000_000_000),
+		Gas:       transferGas,
+		To:        &common.Address{0x01},
+		Value:     big.NewInt(1),
+	})
+	enc, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, te.client.CallContext(context.Background(), nil, "eth_sendRawTransaction", hexutil.Bytes(enc)))
+
+	genesis := te.mockChain().CurrentHeader()
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	b := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x02})
+	pathA, pathB := writeJSON(t, "a.json", a), writeJSON(t, "b.json", b)
+
+	cmd := new(DiffCmd)
+	diff := runDiff(t, cmd, pathA, pathA)
+	require.Empty(t, diff.Differences)
+	require.Empty(t, diff.A.Mismatches)
+	require.Equal(t, a.BlockHash, *diff.A.ComputedBlockHash)
+	require.Len(t, a.Transactions, 1)
+	require.Equal(t, ethTypes.DeriveSha(ethTypes.Transactions{tx}, trie.NewStackTrie(nil)), *diff.A.ComputedTransactionsRoot)
+
+	diff = runDiff(t, cmd, pathA, pathB)
+	fields := make([]string, 0, len(diff.Differences))
+	for _, d := range diff.Differences {
+		fields = append(fields, d.Field)
+	}
+	require.Equal(t, []string{"blockHash", "computedBlockHash", "prevRandao"}, fields)
+
+	// A tampered payload no longer hashes to its block hash, in a newPayload request as well.
+	tampered := *a
+	tampered.GasLimit++
+	request := map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "engine_newPayloadV1", "params": []interface{}{&tampered}}
+	diff = runDiff(t, cmd, pathA, writeJSON(t, "request.json", request))
+	require.Equal(t, []string{"blockHash"}, diff.B.Mismatches)
+	require.Equal(t, "computedBlockHash", diff.Differences[0].Field)
+	require.Equal(t, "gasLimit", diff.Differences[1].Field)
+
+	// The block imported from the payload matches it, with the receipts root recomputed from its receipts.
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+	te.setHead(t, a.BlockHash)
+	cmd.RPC, cmd.JwtSecretPath = "http://"+te.ListenAddr, te.JwtSecretPath
+	diff = runDiff(t, cmd, pathA, strconv.FormatUint(a.Number, 10))
+	require.Empty(t, diff.Differences)
+	require.Empty(t, diff.B.Mismatches)
+	require.Equal(t, *diff.A.ComputedTransactionsRoot, *diff.B.TransactionsRoot)
+	require.Equal(t, a.ReceiptsRoot, *diff.B.ComputedReceiptsRoot)
+}
@@ -0,0 +1,29 @@
+package mergemock
+
+import (
+	"fmt"
+	"mergemock/api"
+	"reflect"
+	"strings"
+)
+
+// disabledMethods checks that the names are engine methods served by the backend, and returns them as set.
+func disabledMethods(backend *EngineBackend, names []string) (map[string]bool, error) {
+	disabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		method := strings.TrimPrefix(name, "engine_")
+		if method == name || method == "" || !reflect.ValueOf(backend).MethodByName(strings.ToUpper(method[:1])+method[1:]).IsValid() {
+			return nil, fmt.Errorf("unknown engine method %q", name)
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+// disabledError is the response to calls of a disabled method, as if the engine didn't implement it.
+func (e *EngineBackend) disabledError(method string) error {
+	if !e.disabled[method] {
+		return nil
+	}
+	return api.NewMethodNotFoundError(method)
+}
@@ -0,0 +1,141 @@
+package mergemock
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// slotEconomics aggregates what the relay served and delivered for a single slot.
+type slotEconomics struct {
+	Slot              uint64          `json:"slot"`
+	BidsServed        uint64          `json:"bidsServed"`
+	MaxBidValue       *big.Int        `json:"maxBidValue"`
+	PayloadsDelivered uint64          `json:"payloadsDelivered"`
+	BlockHash         *common.Hash    `json:"blockHash"`
+	FeeRecipient      *common.Address `json:"feeRecipient"`
+	GasUsed           uint64          `json:"gasUsed"`
+	// Priority fees paid to the fee recipient, only known once the delivered block was imported.
+	FeeRecipientPayment *big.Int `json:"feeRecipientPayment"`
+}
+
+// EconomicsRecorder collects per-slot bid and payload statistics of the relay.
+type EconomicsRecorder struct {
+	mu    sync.Mutex
+	slots map[uint64]*slotEconomics
+}
+
+func NewEconomicsRecorder() *EconomicsRecorder {
+	return &EconomicsRecorder{slots: make(map[uint64]*slotEconomics)}
+}
+
+func (e *EconomicsRecorder) slot(slot uint64) *slotEconomics {
+	s, ok := e.slots[slot]
+	if !ok {
+		s = &slotEconomics{Slot: slot, MaxBidValue: new(big.Int)}
+		e.slots[slot] = s
+	}
+	return s
+}
+
+func (e *EconomicsRecorder) BidServed(slot uint64, value types.U256Str) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.slot(slot)
+	s.BidsServed++
+	if v := value.ToBig(); v.Cmp(s.MaxBidValue) > 0 {
+		s.MaxBidValue = v
+	}
+}
+
+func (e *EconomicsRecorder) PayloadDelivered(slot uint64, payload *types.ExecutionPayloadV1) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.slot(slot)
+	s.PayloadsDelivered++
+	hash, recipient := payload.BlockHash, payload.FeeRecipient
+	s.BlockHash, s.FeeRecipient, s.GasUsed = &hash, &recipient, payload.GasUsed
+}
+
+// Report returns the statistics of all slots in slot order, with fee recipient payments
+// computed from the receipts of delivered blocks that made it into the chain.
+func (e *EconomicsRecorder) Report(chain *core.BlockChain) []slotEconomics {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	report := make([]slotEconomics, 0, len(e.slots))
+	for _, s := range e.slots {
+		entry := *s
+		if s.BlockHash != nil && chain != nil {
+			entry.FeeRecipientPayment = feeRecipientPayment(chain, *s.BlockHash)
+		}
+		report = append(report, entry)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Slot < report[j].Slot })
+	return report
+}
+
+func feeRecipientPayment(chain *core.BlockChain, hash common.Hash) *big.Int {
+	block := chain.GetBlockByHash(hash)
+	if block == nil {
+		return nil
+	}
+	return tipsPaid(block, chain.GetReceiptsByHash(hash))
+}
+
+// tipsPaid sums the priority fees the transactions of the block paid to its fee recipient.
+func tipsPaid(block *ethTypes.Block, receipts ethTypes.Receipts) *big.Int {
+	if len(receipts) != len(block.Transactions()) {
+		return nil
+	}
+	total := new(big.Int)
+	for i, tx := range block.Transactions() {
+		tip, err := tx.EffectiveGasTip(block.BaseFee())
+		if err != nil {
+			return nil
+		}
+		total.Add(total, new(big.Int).Mul(tip, new(big.Int).SetUint64(receipts[i].GasUsed)))
+	}
+	return total
+}
+
+// WriteEconomicsReport writes the report as CSV if the path ends in .csv, and as JSON otherwise.
+func WriteEconomicsReport(path string, report []slotEconomics) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filepath.Ext(path) != ".csv" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"slot", "bids_served", "max_bid_value", "payloads_delivered", "block_hash", "fee_recipient", "gas_used", "fee_recipient_payment"})
+	for _, s := range report {
+		row := []string{strconv.FormatUint(s.Slot, 10), strconv.FormatUint(s.BidsServed, 10), s.MaxBidValue.String(),
+			strconv.FormatUint(s.PayloadsDelivered, 10), "", "", strconv.FormatUint(s.GasUsed, 10), ""}
+		if s.BlockHash != nil {
+			row[4], row[5] = s.BlockHash.Hex(), s.FeeRecipient.Hex()
+		}
+		if s.FeeRecipientPayment != nil {
+			row[7] = s.FeeRecipientPayment.String()
+		}
+		if err := w.Write(row); err != nil {
+			return fmt.Errorf("failed to write economics report: %v", err)
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -1,23 +1,28 @@
-package main
+package mergemock
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"mergemock/api"
 	"mergemock/rpc"
 	"mergemock/types"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/core/vm"
-	"github.com/ethereum/go-ethereum/params"
 	gethRpc "github.com/ethereum/go-ethereum/rpc"
 	lru "github.com/hashicorp/golang-lru"
 
@@ -25,98 +30,647 @@ import (
 )
 
 type EngineCmd struct {
+	// config file options
+	ConfigPath string `ask:"--config" help:"YAML file of flags, with groups of flags nested, loaded before the flags of the command line override it (none if empty)"`
+
 	// chain options
-	SlotsPerEpoch uint64 `ask:"--slots-per-epoch" help:"Slots per epoch"`
-	DataDir       string `ask:"--datadir" help:"Directory to store execution chain data (empty for in-memory data)"`
-	GenesisPath   string `ask:"--genesis" help:"Genesis execution-config file"`
-	JwtSecretPath string `ask:"--jwt-secret" help:"JWT secret key for authenticated communication"`
+	SlotsPerEpoch  uint64 `ask:"--slots-per-epoch" help:"Slots per epoch, the safe and finalized blocks of the slot timer are one and two epochs behind the head"`
+	SecondsPerSlot uint64 `ask:"--seconds-per-slot" help:"Produce a block on the head every this many seconds, unless a consensus client already did during the slot (0 to disable)"`
+	DataDir        string `ask:"--datadir" help:"Directory to store execution chain data (empty for in-memory data, 'auto' for a temporary directory removed on exit)"`
+	GenesisPath    string `ask:"--genesis" help:"Genesis execution-config file"`
+	JwtSecretPath  string `ask:"--jwt-secret" help:"JWT secret key for authenticated communication"`
+	PowDifficulty  uint64 `ask:"--pow-difficulty" help:"Difficulty of the proof-of-work blocks mined on start-up until the terminal total difficulty is reached (0 to reach it in a single block)"`
+	GasLimit       uint64 `ask:"--gas-limit" help:"Gas limit of built payloads, can be changed with mock_setGasLimit (0 for the genesis gas limit)"`
+
+	PowBlockTime time.Duration `ask:"--pow-block-time" help:"Mine the proof-of-work blocks one per interval while serving, instead of all on start-up, so the consensus client sees the terminal total difficulty approach and detects the terminal block itself (0 to mine them on start-up)"`
+
+	PayloadRetention time.Duration `ask:"--payload-retention" help:"Time built payloads can be retrieved for, after which getPayload treats them as unknown, e.g. the slot time (0 to keep them until evicted from the cache)"`
+	PayloadCacheSize int           `ask:"--payload-cache-size" help:"Number of built payloads getPayload can retrieve, the least recently used ones are evicted beyond it"`
+	RebuildEvicted   bool          `ask:"--rebuild-evicted" help:"Rebuild payloads evicted from the cache when getPayload asks for them, from the same parent and attributes"`
+
+	// gas limit options
+	GasLimits GasLimitConfig `ask:".gaslimit" help:"Move the gas limit of built payloads toward a target, like the gas limit votes of real engines"`
 
 	// connectivity options
-	ListenAddr    string      `ask:"--listen-addr" help:"Address to bind RPC HTTP server to"`
-	WebsocketAddr string      `ask:"--ws-addr" help:"Address to serve /ws endpoint on for websocket JSON-RPC"`
-	Cors          []string    `ask:"--cors" help:"List of allowable origins (CORS http header)"`
-	Timeout       rpc.Timeout `ask:".timeout" help:"Configure timeouts of the HTTP servers"`
+	ListenAddr    string                 `ask:"--listen-addr" help:"Address to bind RPC HTTP server to, with readiness probes on /healthz (port 0 for a free port, logged once bound)"`
+	WebsocketAddr string                 `ask:"--ws-addr" help:"Address to serve /ws endpoint on for websocket JSON-RPC (port 0 for a free port)"`
+	IPCPath       string                 `ask:"--ipc-path" help:"Unix domain socket to serve the JSON-RPC API on, without JWT authentication (disabled if empty)"`
+	ExplorerAddr  string                 `ask:"--explorer-addr" help:"Address to serve a read-only block explorer web UI of the mock chain on, without authentication (disabled if empty)"`
+	Cors          []string               `ask:"--cors" help:"List of allowable origins (CORS http header)"`
+	ShutdownWait  time.Duration          `ask:"--shutdown-timeout" help:"Time requests in flight get to finish on shutdown, before their connections are closed"`
+	Timeout       rpc.Timeout            `ask:".timeout" help:"Configure timeouts of the HTTP servers"`
+	Wire          rpc.WireLog            `ask:".wire" help:"Log the HTTP traffic of the engine API"`
+	Jwt           rpc.JwtAuth            `ask:".jwt" help:"Validate the JWTs of authenticated requests, with knobs to break authentication on purpose"`
+	Content       rpc.ContentNegotiation `ask:".content" help:"Negotiate the encoding and content type of HTTP bodies, with faults breaking the content type of responses"`
+	Record        rpc.TrafficRecord      `ask:".record" help:"Record the engine API traffic of the HTTP server, to replay it with the replay command"`
+	Limit         rpc.RateLimit          `ask:".limit" help:"Limit the request rates and connections of the servers, to emulate an overloaded engine"`
+	Chaos         rpc.Chaos              `ask:".chaos" help:"Break HTTP responses at random below the JSON-RPC layer: connection drops, truncated bodies, slow drips and 502/503 errors"`
+
+	// hook options
+	Hooks HookConfig `ask:".hook" help:"Rewrite the responses of engine API calls with Go plugins, for faults the fault injector can't express"`
+
+	// fork options
+	Forks ForkTimesConfig `ask:".fork" help:"Override the activation timestamps of the forks of the genesis and the terminal total difficulty, to test fork transitions of the consensus client"`
+
+	// shadow fork options
+	Shadow ShadowForkConfig `ask:".shadow" help:"Fork the genesis state of the mock chain from a live chain at a block, to build payloads on top of real state"`
+
+	// chain file options
+	Chain ChainFileConfig `ask:".chain" help:"Import the chain from a file on start, and export it on exit, to verify it with other execution clients"`
+
+	Backfill BackfillConfig `ask:".backfill" help:"Generate a long chain on start, for checkpoint-synced consensus clients to backfill with getPayloadBodies"`
+
+	// eth namespace options
+	GasPriceOracle GasPriceOracleConfig `ask:".gpo" help:"Configure the gas price oracle backing eth_gasPrice and eth_feeHistory"`
+	StateHistory   uint64               `ask:"--state-history" help:"Number of recent blocks whose state can be queried through the eth namespace (0 for all blocks)"`
+	Subscriptions  SubscriptionConfig   `ask:".subscription" help:"Bound the notifications queued per eth_subscribe subscription, so that subscribers falling behind can't stall the chain"`
+
+	// transition options
+	Transition TransitionConfigOverrides `ask:".transition" help:"Override the transition configuration reported to the consensus client, to test mismatch handling"`
+
+	// slow engine options
+	Delays DelayConfig `ask:".delay" help:"Delay engine calls, to simulate a slow engine"`
+
+	// fault injection options
+	Faults FaultConfig `ask:".fault" help:"Inject faults into engine calls, to test how the consensus client handles a misbehaving engine"`
+
+	// payload building options
+	Build BuildConfig `ask:".build" help:"Build payloads over time like real engines, instead of at once on forkchoiceUpdated"`
+
+	// invalid payload options
+	Invalid InvalidPayloadConfig `ask:".invalid" help:"Build invalid payloads at chosen slots, to test that the blocks of a proposer on a buggy engine are rejected downstream"`
+
+	// preset options
+	Preset string `ask:"--preset" help:"Named combination of faults and delays on top of the flags: flaky-el or slow-el, empty for none"`
+
+	// reproducibility options
+	Seed int64 `ask:"--seed" help:"Seed all randomized behavior without a seed of its own from this one, to reproduce a run (0 for random seeds)"`
+
+	// transaction options
+	Txs TxGenConfig `ask:".tx" help:"Generate the transactions of built payloads"`
+
+	// test account options
+	Accounts AccountsConfig `ask:".accounts" help:"Derive well-known test accounts from a mnemonic, prefunded in the genesis and served by mock_accounts"`
+
+	// partial implementation options
+	UnknownPayload  string   `ask:"--unknown-payload" help:"Response to getPayload calls with an unknown payload id: unavailable (error -32001), unknown (error -38001), empty (a synthetic empty payload) or timeout (no response until the client gives up)"`
+	DisabledMethods []string `ask:"--disable-method" help:"Engine methods answering method-not-found, to emulate an engine that doesn't implement them, e.g. engine_forkchoiceUpdatedV3"`
+
+	// optimistic import options
+	Optimistic OptimisticConfig `ask:".optimistic" help:"Import blocks optimistically, settling their validity later, to test optimistic sync of the consensus client"`
+
+	// sync simulation options
+	Sync SyncConfig `ask:".sync" help:"Pretend to be syncing after start, answering SYNCING until caught up"`
+
+	// peer options
+	Peers PeersConfig `ask:".peers" help:"Report made-up peers with net_peerCount and admin_peers, to test how clients handle a poorly peered engine"`
+
+	// identity options
+	Identity IdentityConfig `ask:".identity" help:"Identify as another execution client with engine_getClientVersionV1 and web3_clientVersion"`
+
+	// multi-client options
+	Arbitration ArbitrationConfig `ask:".arbitration" help:"Arbitrate the conflicting heads of consensus clients driving the same engine, to detect and study split-brain setups"`
+
+	// multi-engine options
+	Instances InstancesConfig `ask:".instances" help:"Run several engines in one process, to test failover of consensus clients between engines"`
+
+	// clock skew options
+	Clock ClockSkewConfig `ask:".clock" help:"Skew the engine clock from the consensus client, validating payload attribute timestamps against it"`
+
+	// payload attribute options
+	Attributes AttributesConfig `ask:".attributes" help:"Validate the payload attributes of forkchoiceUpdated strictly, to catch attribute bugs of the consensus client"`
+
+	// reorg options
+	AutoReorg AutoReorgConfig `ask:".auto-reorg" help:"Reorg the head periodically with a competing fork, to test reorg handling of the consensus client"`
+
+	// scenario options
+	ScenarioPath string `ask:"--scenario" help:"JSON script of the engine behavior per slot (valid, invalid, syncing, accepted, delay or reorg), or the script itself as given by config files, empty to behave normally"`
+
+	// monitoring options
+	LatencyBudgets LatencyBudgetConfig `ask:".latency" help:"Alert when engine calls take longer than their budget"`
+	Timeline       TimelineConfig      `ask:".timeline" help:"Export the calls, faults, built blocks and reorgs seen by the engine, by slot"`
+	StatsSnapshot  string              `ask:"--stats-snapshot" help:"File to write the final mock_stats counters and fault rules to as JSON on exit, for CI jobs to archive and compare (empty to disable)"`
+	EventsPath     string              `ask:"--events-out" help:"File to write structured JSON events of the engine to as they happen, one per line, for test harnesses to assert on (empty to disable)"`
+	EventsSlotTime time.Duration       `ask:"--events-slot-time" help:"Slot duration the slots of events are counted in, from the genesis block"`
 
 	// embed logger options
 	LogCmd         `ask:".log" help:"Change logger configuration"`
 	TraceLogConfig `ask:".trace" help:"Tracing options"`
 
 	close   chan struct{}
+	stopped chan struct{} // closed once the servers are shut down
 	log     logrus.Ext1FieldLogger
 	ctx     context.Context
 	backend *EngineBackend
+	chain   *MockChain // set once opened, before the backend, for Close to release it if Run fails in between
 	rpcSrv  *gethRpc.Server
 	srv     *http.Server
 	wsSrv   *http.Server // upgrades to websocket rpc
+	expSrv  *http.Server // block explorer web UI
+	rec     *rpc.Recorder
+	ipc     net.Listener
+
+	srvListener, wsListener, expListener net.Listener
+
+	jwtSecret     []byte
+	removeDataDir func() error
 
-	jwtSecret []byte
+	// the fault rules and delays of the config file at start-up, which tell the flags of the command line overriding
+	// it apart on Reload, and the ids of the rules loaded from the file, nil if the command line overrides them
+	configFaults  []string
+	configDelays  DelayConfig
+	configRuleIDs []uint64
+
+	// index of the engine instance, the other instances are run by the first one from its flags as they were
+	// before running, sharing blocks if shared is set
+	instance  int
+	instances []*EngineCmd
+	flags     *EngineCmd
+	shared    *sharedChains
+
+	// senders whose transactions are left out of built payloads, set by the relay to simulate censorship
+	censored map[common.Address]bool
 }
 
 func (c *EngineCmd) Default() {
+	c.SlotsPerEpoch = 32
 	c.GenesisPath = "genesis.json"
 	c.JwtSecretPath = "jwt.hex"
 
 	c.ListenAddr = "127.0.0.1:8551"
 	c.WebsocketAddr = "127.0.0.1:8552"
 	c.Cors = []string{"*"}
+	c.ShutdownWait = 5 * time.Second
+	c.UnknownPayload = UnknownPayloadUnknown
+	c.PayloadRetention = 12 * time.Second
+	c.PayloadCacheSize = defaultPayloadCacheSize
 
 	c.Timeout.Read = 30 * time.Second
 	c.Timeout.ReadHeader = 10 * time.Second
 	c.Timeout.Write = 30 * time.Second
 	c.Timeout.Idle = 5 * time.Minute
+
+	c.Jwt.MaxSkew = 5 * time.Second
+	c.Content.Gzip = true
+	c.Content.WrongProbability = 1
+	c.Limit.Burst = 1
+	c.Chaos.DripDelay = time.Second
+	c.Chaos.DripChunk = 16
+
+	c.Identity.Client = ClientMergemock
+	c.Instances.Count = 1
+	c.Arbitration.Policy = ArbitrationLastWriterWins
+	c.Arbitration.Window = defaultArbitrationWindow
+	c.Chain.Format = ChainFormatRLP
+	c.Shadow.Block = "latest"
+	c.Shadow.MaxSlots = 10000
+	c.Shadow.Timeout = time.Minute
+	c.Invalid.SlotTime = 12 * time.Second
+	c.EventsSlotTime = 12 * time.Second
+	c.Subscriptions.Queue = 128
+	c.Subscriptions.Policy = SubscriptionDropOldest
 }
 
 func (c *EngineCmd) Help() string {
 	return "Run a mock Execution engine."
 }
 
+// Run starts the engine, returning once it serves. An engine that fails to start releases what it opened before
+// returning the error.
 func (c *EngineCmd) Run(ctx context.Context, args ...string) error {
 	if err := c.initLogger(ctx); err != nil {
 		// Logger wasn't initialized so we can't log. Error out instead.
 		return err
 	}
-	jwt, err := loadJwtSecret(c.JwtSecretPath)
+	if err := c.start(ctx); err != nil {
+		if closeErr := c.Close(); closeErr != nil {
+			c.log.WithError(closeErr).Error("Failed closing engine that failed to start")
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *EngineCmd) start(ctx context.Context) error {
+	if c.instance > 0 {
+		c.log = c.log.WithField("instance", c.instance)
+	} else if c.Instances.Count > 1 {
+		flags := *c
+		c.flags = &flags
+		if c.Instances.Share {
+			c.shared = new(sharedChains)
+			c.flags.shared = c.shared
+		}
+	}
+	version := Version()
+	c.log.WithFields(logrus.Fields{"commit": version.GitCommit, "buildTime": version.BuildTime}).Info("Starting mergemock engine")
+	jwt, generated, err := loadJwtSecret(c.JwtSecretPath)
 	if err != nil {
-		c.log.WithField("err", err).Fatal("Unable to read JWT secret")
+		return fmt.Errorf("unable to read JWT secret: %v", err)
+	}
+	if generated {
+		c.log.WithField("path", c.JwtSecretPath).Warn("JWT secret not found, generated a random one")
 	}
 	c.jwtSecret = jwt
 	c.log.WithField("val", common.Bytes2Hex(c.jwtSecret)).Info("Loaded JWT secret")
-	chain, err := c.makeMockChain()
+	accounts, err := c.Accounts.Derive()
+	if err != nil {
+		return fmt.Errorf("unable to derive test accounts: %v", err)
+	}
+	shadow, err := c.Shadow.Fetch(ctx, c.log)
+	if err != nil {
+		return fmt.Errorf("unable to fork the live chain: %v", err)
+	}
+	chain, err := c.makeMockChain(genesisAlloc(accounts), shadow)
 	if err != nil {
-		c.log.WithField("err", err).Fatal("Unable to initialize mock chain")
+		return fmt.Errorf("unable to initialize mock chain: %v", err)
+	}
+	c.chain = chain
+	c.log.WithFields(chain.forks.fields()).Info("Loaded fork schedule")
+	if chain.forks.Blobs > 0 {
+		c.log.WithField("blobs", chain.forks.Blobs).Warn("Accounting blob gas for blobs no transaction carries, real clients reject these blocks")
+	}
+	if err := c.Chain.validate(); err != nil {
+		return fmt.Errorf("unable to configure chain files: %v", err)
+	}
+	if err := c.GasPriceOracle.validate(); err != nil {
+		return fmt.Errorf("unable to configure gas price oracle: %v", err)
+	}
+	if c.Chain.Import != "" {
+		if err := importChainFile(c.log, chain, c.Chain.Import, c.Chain.Format); err != nil {
+			return fmt.Errorf("unable to import chain: %v", err)
+		}
+	}
+	if c.PowBlockTime == 0 {
+		if _, err := chain.MineTerminalChain(new(big.Int).SetUint64(c.PowDifficulty)); err != nil {
+			return fmt.Errorf("unable to mine proof-of-work chain: %v", err)
+		}
 	}
+	c.shared.add(chain)
 	backend, err := NewEngineBackend(c.log, chain)
 	if err != nil {
-		c.log.WithField("err", err).Fatal("Unable to initialize backend")
+		return fmt.Errorf("unable to initialize backend: %v", err)
+	}
+	monitor, err := c.LatencyBudgets.NewMonitor(c.log)
+	if err != nil {
+		return fmt.Errorf("unable to parse latency budgets: %v", err)
+	}
+	backend.latency = monitor
+	backend.accounts = accounts
+	if len(c.Txs.Accounts.accounts) == 0 {
+		c.Txs.Accounts = testAccounts(accounts)
+	}
+	if _, err := chain.Backfill(c.log, &c.Backfill, c.Txs.Accounts, time.Now()); err != nil {
+		return fmt.Errorf("unable to generate backfill blocks: %v", err)
+	}
+	if c.GasLimit != 0 && c.GasLimits.Target != 0 {
+		return errors.New("set either a fixed gas limit or a gas limit target")
+	} else if c.GasLimit != 0 {
+		backend.gasLimits.Set(c.GasLimit, nil)
+	} else if c.GasLimits.Target != 0 {
+		backend.gasLimits.SetTarget(c.GasLimits.Target)
+	}
+	if c.Preset != "" {
+		preset, err := LookupPreset(c.Preset)
+		if err == nil && preset.Relay {
+			err = fmt.Errorf("%s is a preset of the relay command", c.Preset)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to apply preset: %v", err)
+		}
+		preset.ApplyEngine(c)
+		c.log.WithField("preset", c.Preset).Info("Applied preset")
+	}
+	c.deriveSeeds()
+	backend.control.SetDelays(c.Delays.NewDelayer())
+	backend.timeline = c.Timeline.NewTimeline()
+	if backend.events, err = NewEventLog(c.EventsPath, chain.chain.Genesis().Time(), c.EventsSlotTime); err != nil {
+		return fmt.Errorf("unable to create event log: %v", err)
+	}
+	chain.events = backend.events
+	if err := c.Chaos.Validate(); err != nil {
+		return fmt.Errorf("unable to configure chaos: %v", err)
+	} else if c.Chaos.Probability > 0 {
+		c.log.WithFields(logrus.Fields{"probability": c.Chaos.Probability, "kinds": c.Chaos.Kinds}).Warn("Breaking HTTP responses at random")
+	}
+	if backend.txs, err = c.Txs.NewTxGenerator(chain.chain.Genesis().Time()); err != nil {
+		return fmt.Errorf("unable to configure transaction generation: %v", err)
+	}
+	if c.ConfigPath != "" {
+		cfg, err := c.loadConfigFile()
+		if err != nil {
+			return fmt.Errorf("unable to load config file: %v", err)
+		}
+		c.configFaults, c.configDelays = cfg.Faults.Rules, cfg.Delays
+	}
+	if c.ConfigPath != "" && equalStrings(c.Faults.Rules, c.configFaults) {
+		c.configRuleIDs = []uint64{}
+	}
+	backend.faults = NewFaultInjector(c.log, c.Faults.Seed)
+	for _, r := range c.Faults.Rules {
+		rule, err := ParseFaultRule(r)
+		if err != nil {
+			return fmt.Errorf("unable to parse fault rule: %v", err)
+		}
+		id, _ := backend.faults.Add(rule)
+		if c.configRuleIDs != nil {
+			c.configRuleIDs = append(c.configRuleIDs, id)
+		}
+	}
+	backend.censored = c.censored
+	backend.autoReorgs = c.AutoReorg
+	backend.payloadRetention = c.PayloadRetention
+	if c.PayloadCacheSize <= 0 {
+		return fmt.Errorf("payload cache size %d must be positive", c.PayloadCacheSize)
+	}
+	backend.payloadCacheSize = c.PayloadCacheSize
+	backend.recentPayloads.Resize(c.PayloadCacheSize)
+	backend.parentPayloads.Resize(c.PayloadCacheSize)
+	backend.rebuildEvicted = c.RebuildEvicted
+	backend.builds = c.Build.NewPayloadBuilds(c.log)
+	if backend.verdicts, err = c.Optimistic.NewVerdicts(c.log); err != nil {
+		return fmt.Errorf("unable to configure optimistic imports: %v", err)
+	}
+	if backend.sync, err = c.Sync.NewSyncSimulator(c.log, chain.CurrentHeader().Number.Uint64()); err != nil {
+		return fmt.Errorf("unable to configure sync simulation: %v", err)
+	}
+	backend.clock = c.Clock.NewEngineClock()
+	backend.attributes = c.Attributes.NewAttributeValidator()
+	if backend.peers, err = c.Peers.NewPeerSet(); err != nil {
+		return fmt.Errorf("unable to configure peers: %v", err)
+	}
+	if backend.identity, err = c.Identity.NewClientIdentity(); err != nil {
+		return fmt.Errorf("unable to configure client identity: %v", err)
+	}
+	if backend.arbiter, err = c.Arbitration.NewHeadArbiter(c.log); err != nil {
+		return fmt.Errorf("unable to configure head arbitration: %v", err)
+	}
+	if err := c.Subscriptions.validate(); err != nil {
+		return fmt.Errorf("unable to configure subscriptions: %v", err)
+	}
+	backend.subs = newSubscriptions(c.Subscriptions)
+	switch c.UnknownPayload {
+	case UnknownPayloadUnavailable, UnknownPayloadUnknown, UnknownPayloadEmpty, UnknownPayloadTimeout:
+		backend.unknownPayloads = c.UnknownPayload
+	default:
+		return fmt.Errorf("unknown response mode %q for unknown payload ids", c.UnknownPayload)
+	}
+	if backend.disabled, err = disabledMethods(backend, c.DisabledMethods); err != nil {
+		return fmt.Errorf("unable to disable engine methods: %v", err)
+	}
+	if backend.invalid, err = c.Invalid.NewInvalidPayloads(chain.chain.Genesis().Time()); err != nil {
+		return fmt.Errorf("unable to configure invalid payloads: %v", err)
+	} else if backend.invalid != nil {
+		c.log.WithField("slots", backend.invalid.Slots()).Warn("Building invalid payloads")
+	}
+	if c.ScenarioPath != "" {
+		scenario, err := LoadScenario(c.ScenarioPath, chain.chain.Genesis().Time())
+		if err != nil {
+			return fmt.Errorf("unable to load scenario: %v", err)
+		}
+		backend.scenario = scenario
+		c.log.WithField("steps", len(scenario.Steps)).Info("Loaded scenario")
+	}
+	if err := c.Transition.Apply(backend.transition); err != nil {
+		return fmt.Errorf("unable to parse transition configuration overrides: %v", err)
+	}
+	if c.persistent() {
+		cp, err := LoadEngineCheckpoint(filepath.Join(c.DataDir, engineStateFile))
+		if err == nil {
+			err = backend.Restore(cp, "restart")
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to restore engine state: %v", err)
+		}
 	}
 	c.backend = backend
-	c.startRPC(ctx)
+	if err := c.startRPC(ctx); err != nil {
+		return err
+	}
+	c.close = make(chan struct{})
+	c.stopped = make(chan struct{})
 	go c.RunNode()
+	if err := c.startInstances(ctx); err != nil {
+		return fmt.Errorf("unable to start engine instances: %v", err)
+	}
 	return nil
 }
 
 func (c *EngineCmd) RunNode() {
-	c.log.WithField("listenAddr", c.ListenAddr).Info("Engine started")
+	defer close(c.stopped)
+	c.log.WithFields(logrus.Fields{"listenAddr": c.ListenAddr, "wsAddr": c.WebsocketAddr}).Info("Engine started")
 
-	go c.srv.ListenAndServe()
-	go c.wsSrv.ListenAndServe()
+	go c.srv.Serve(c.srvListener)
+	go c.wsSrv.Serve(c.wsListener)
+	if c.ipc != nil {
+		c.log.WithField("ipcPath", c.IPCPath).Info("Serving IPC")
+		go c.rpcSrv.ServeListener(c.ipc)
+	}
+	if c.expSrv != nil {
+		c.log.WithField("explorerAddr", c.ExplorerAddr).Info("Serving block explorer")
+		go c.expSrv.Serve(c.expListener)
+	}
+	if c.SecondsPerSlot > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		slot := time.Duration(c.SecondsPerSlot) * time.Second
+		c.log.WithFields(logrus.Fields{"seconds_per_slot": c.SecondsPerSlot, "slots_per_epoch": c.SlotsPerEpoch}).Info("Producing a block every slot")
+		go c.backend.produceBlocks(SystemClock{}, slot, c.SlotsPerEpoch, stop)
+	}
+	if c.PowBlockTime > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		c.log.WithFields(logrus.Fields{"pow_block_time": c.PowBlockTime, "ttd": c.backend.mockChain.gspec.Config.TerminalTotalDifficulty}).Info("Mining proof-of-work blocks until the terminal total difficulty")
+		go c.backend.minePowChain(SystemClock{}, c.PowBlockTime, new(big.Int).SetUint64(c.PowDifficulty), stop)
+	}
 
 	for range c.close {
-		c.rpcSrv.Stop()
-		c.srv.Close()
-		c.wsSrv.Close()
+		c.shutdown()
 		return
 		// TODO: any other tasks to run in this loop? mock sync changes?
 	}
 }
 
+// shutdown stops accepting connections, and gives the requests in flight the shutdown timeout to finish before
+// closing their connections. Websocket connections are closed right away.
+func (c *EngineCmd) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownWait)
+	defer cancel()
+	servers := []*http.Server{c.srv, c.wsSrv}
+	if c.expSrv != nil {
+		servers = append(servers, c.expSrv)
+	}
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				c.log.WithError(err).Warn("Closing connections of requests still in flight")
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	c.rpcSrv.Stop()
+	if c.ipc != nil {
+		c.ipc.Close()
+		os.Remove(c.IPCPath)
+	}
+}
+
 func (c *EngineCmd) Close() error {
+	for _, inst := range c.instances {
+		inst.Close()
+	}
 	if c.close != nil {
 		c.close <- struct{}{}
+		// the chain is only closed once no request uses it anymore
+		<-c.stopped
+	} else {
+		// Run failed before serving, only the listeners bound so far are open
+		c.closeListeners()
+	}
+	if err := c.rec.Close(); err != nil {
+		c.log.WithError(err).Error("Failed closing traffic recording")
 	}
+	if err := c.Wire.Close(); err != nil {
+		c.log.WithError(err).Error("Failed closing wire log")
+	}
+	if c.backend != nil {
+		c.backend.latency.LogSummary()
+		if c.Chain.Export != "" {
+			if err := exportChainFile(c.log, c.backend.mockChain, c.Chain.Export, c.Chain.Format); err != nil {
+				c.log.WithError(err).Error("Failed exporting chain")
+			}
+		}
+		if c.Timeline.Path != "" {
+			if err := c.backend.timeline.Write(c.Timeline.Path); err != nil {
+				c.log.WithError(err).Error("Failed writing timeline")
+			}
+		}
+		if c.StatsSnapshot != "" {
+			snapshot := NewMockBackend(c.backend).Snapshot(context.Background())
+			if err := WriteStatsSnapshot(c.StatsSnapshot, snapshot); err != nil {
+				c.log.WithError(err).Error("Failed writing stats snapshot")
+			} else {
+				c.log.WithField("path", c.StatsSnapshot).Info("Wrote stats snapshot")
+			}
+		}
+	}
+	if c.chain != nil {
+		if err := c.chain.events.Close(); err != nil {
+			c.log.WithError(err).Error("Failed closing event log")
+		}
+	}
+	if c.persistent() && c.backend != nil {
+		if err := WriteEngineCheckpoint(filepath.Join(c.DataDir, engineStateFile), c.backend.Checkpoint()); err != nil {
+			c.log.WithError(err).Error("Failed writing engine state")
+		}
+	}
+	if c.DataDir != "" && c.chain != nil {
+		// stopping the chain flushes the state of the head to the database
+		c.chain.chain.Stop()
+		if err := c.chain.database.Close(); err != nil {
+			c.log.WithError(err).Error("Failed closing database")
+		}
+	}
+	if c.removeDataDir != nil {
+		return c.removeDataDir()
+	}
+	return nil
+}
+
+// Reload reloads the JWT secret from its file, and the fault rules and delays from the config file if there is one,
+// without restarting the servers or dropping the chain. The flags of the command line still override the file, and
+// rules added with mock_injectFault are kept. Nothing changes if the secret file is missing or invalid, or if a rule
+// of the config file is invalid.
+func (c *EngineCmd) Reload() error {
+	for _, inst := range c.instances {
+		if err := inst.Reload(); err != nil {
+			return fmt.Errorf("instance %d: %v", inst.instance, err)
+		}
+	}
+	if c.backend == nil {
+		return errors.New("engine isn't running")
+	}
+	// everything is read and parsed before anything is replaced
+	jwt, err := readJwtSecret(c.JwtSecretPath)
+	if err != nil {
+		return fmt.Errorf("failed to reload JWT secret: %v", err)
+	}
+	var (
+		cfg   *EngineCmd
+		rules []*FaultRule
+	)
+	if c.ConfigPath != "" {
+		if cfg, err = c.loadConfigFile(); err != nil {
+			return err
+		}
+		if c.configRuleIDs == nil {
+			c.log.Info("Keeping the fault rules of the command line, which override those of the config file")
+		} else {
+			rules = make([]*FaultRule, 0, len(cfg.Faults.Rules))
+			for _, r := range cfg.Faults.Rules {
+				rule, err := ParseFaultRule(r)
+				if err == nil {
+					err = rule.validate()
+				}
+				if err != nil {
+					return fmt.Errorf("failed to parse fault rule: %v", err)
+				}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if rules != nil {
+		ids, err := c.backend.faults.Replace(c.configRuleIDs, rules)
+		if err != nil {
+			return fmt.Errorf("failed to replace fault rules: %v", err)
+		}
+		c.configRuleIDs = ids
+	}
+	c.backend.auth.SetSecret(jwt)
+	c.log.WithField("val", common.Bytes2Hex(jwt)).Info("Reloaded JWT secret")
+	if cfg == nil {
+		return nil
+	}
+	delays := cfg.Delays.overriddenBy(c.configDelays, c.Delays)
+	c.backend.control.SetDelays(delays.NewDelayer())
+	c.log.WithFields(logrus.Fields{"path": c.ConfigPath, "faults": len(rules)}).Info("Reloaded fault rules and delays")
 	return nil
 }
 
+// loadConfigFile parses the flags of the config file alone.
+func (c *EngineCmd) loadConfigFile() (*EngineCmd, error) {
+	flags, err := LoadConfigFlags(c.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(EngineCmd)
+	if err := parseConfigFlags(cfg, flags); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", c.ConfigPath, err)
+	}
+	return cfg, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// persistent reports whether the chain and engine state are kept across restarts, in the datadir.
+func (c *EngineCmd) persistent() bool {
+	return c.DataDir != "" && c.DataDir != AutoDataDir
+}
+
 func (c *EngineCmd) initLogger(ctx context.Context) error {
 	logr, err := c.LogCmd.Create()
 	if err != nil {
@@ -124,15 +678,40 @@ func (c *EngineCmd) initLogger(ctx context.Context) error {
 	}
 	c.log = logr
 	c.ctx = ctx
-	c.close = make(chan struct{})
 	return nil
 }
 
-func loadJwtSecret(path string) ([]byte, error) {
+// loadJwtSecret reads the hex encoded secret of a server, and generates a random one at the path if there is no file,
+// creating its directory, so that throwaway instances need no setup.
+func loadJwtSecret(path string) (secret []byte, generated bool, err error) {
+	secret, err = readJwtSecret(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return secret, false, err
+	}
+	jwt := make([]byte, 32)
+	if _, err := rand.Read(jwt); err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create directory of generated secret: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(common.Bytes2Hex(jwt)), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to write generated secret: %v", err)
+	}
+	return jwt, true, nil
+}
+
+// readJwtSecret reads the hex encoded secret of a client, which has to share the secret of its server: a missing
+// file is an error.
+func readJwtSecret(path string) ([]byte, error) {
 	raw, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return parseJwtSecret(raw)
+}
+
+func parseJwtSecret(raw []byte) ([]byte, error) {
 	jwt := common.Hex2Bytes(string(raw))
 	if len(jwt) != 32 {
 		return nil, fmt.Errorf("invalid length, expected 32-byte value")
@@ -140,99 +719,798 @@ func loadJwtSecret(path string) ([]byte, error) {
 	return jwt, nil
 }
 
-func (c *EngineCmd) makeMockChain() (*MockChain, error) {
+func (c *EngineCmd) makeMockChain(prefund core.GenesisAlloc, shadow *ShadowState) (*MockChain, error) {
 	posEngine := &ExecutionConsensusMock{
 		pow: nil, // TODO: do we even need this?
 		log: c.log,
 	}
-	db, err := NewDB(c.DataDir)
+	dataDir, removeDataDir, err := resolveDataDir(c.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if c.DataDir == AutoDataDir {
+		c.log.WithField("datadir", dataDir).Info("Using temporary datadir")
+		c.removeDataDir = removeDataDir
+	}
+	db, err := NewDB(dataDir)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open db")
+		return nil, fmt.Errorf("unable to open db: %v", err)
 	}
-	return NewMockChain(c.log, posEngine, c.GenesisPath, db, &c.TraceLogConfig)
+	chain, err := newMockChain(c.log, posEngine, c.GenesisPath, db, &c.TraceLogConfig, prefund, shadow, &c.Forks)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return chain, nil
 }
 
 func (c *EngineCmd) mockChain() *MockChain {
 	return c.backend.mockChain
 }
 
-func (c *EngineCmd) startRPC(ctx context.Context) {
-	rpcSrv, err := rpc.NewServer("engine", c.backend, true)
+func (c *EngineCmd) startRPC(ctx context.Context) error {
+	ethBackend := NewEthBackend(c.backend.mockChain, &c.GasPriceOracle, c.StateHistory, c.backend.subs)
+	ethBackend.sync = c.backend.sync
+	rpcSrv, err := NewEngineRPCServer(c.backend, ethBackend, NewMockBackend(c.backend), NewNetBackend(c.backend), NewAdminBackend(c.backend), NewWeb3Backend(c.backend))
 	if err != nil {
-		c.log.Fatal(err)
+		return err
 	}
 
-	ethBackend := NewEthBackend(c.backend.mockChain.chain)
-	ethBackend.Register(rpcSrv)
-
 	c.rpcSrv = rpcSrv
+	c.backend.auth = c.Jwt.NewAuthenticator(c.jwtSecret)
 	c.srv = rpc.NewHTTPServer(ctx, c.log, c.rpcSrv, c.ListenAddr, c.Timeout, c.Cors)
-	c.wsSrv = rpc.NewWSServer(ctx, c.log, c.rpcSrv, c.WebsocketAddr, c.jwtSecret, c.Timeout, c.Cors)
+	if c.Jwt.HTTP {
+		c.srv.Handler = c.backend.auth.Handler(c.srv.Handler)
+	}
+	if c.rec, err = c.Record.NewRecorder(); err != nil {
+		return fmt.Errorf("unable to record engine API traffic: %v", err)
+	}
+	if err := c.Wire.Open(); err != nil {
+		return fmt.Errorf("unable to log engine API traffic: %v", err)
+	}
+	hooks, err := c.Hooks.NewResponseHooks()
+	if err != nil {
+		return fmt.Errorf("unable to load response hooks: %v", err)
+	}
+	c.srv.Handler = healthHandler(c.backend, c.Chaos.Handler(c.Content.Handler(c.Wire.Handler(c.Limit.Handler(c.rec.Handler(hooks.Handler(rpc.ClientIDHandler(c.srv.Handler)))), c.log))))
+	c.wsSrv = rpc.NewWSServer(ctx, c.log, c.rpcSrv, c.WebsocketAddr, c.backend.auth, c.Timeout, c.Cors)
+	if c.ExplorerAddr != "" {
+		c.expSrv = &http.Server{
+			Addr:              c.ExplorerAddr,
+			Handler:           NewExplorerHandler(c.backend),
+			ReadTimeout:       c.Timeout.Read,
+			ReadHeaderTimeout: c.Timeout.ReadHeader,
+			WriteTimeout:      c.Timeout.Write,
+			IdleTimeout:       c.Timeout.Idle,
+		}
+	}
+	if c.IPCPath != "" {
+		if c.ipc, err = rpc.ListenIPC(c.IPCPath); err != nil {
+			return fmt.Errorf("unable to listen on IPC path: %v", err)
+		}
+	}
+	// bind before serving, so the addresses of port 0 are known once Run returns
+	if c.srvListener, err = listenAddr(&c.ListenAddr); err != nil {
+		return fmt.Errorf("unable to listen on RPC address: %v", err)
+	}
+	if c.wsListener, err = listenAddr(&c.WebsocketAddr); err != nil {
+		return fmt.Errorf("unable to listen on websocket address: %v", err)
+	}
+	c.srvListener, c.wsListener = c.Limit.Listener(c.srvListener), c.Limit.WSListener(c.wsListener)
+	if c.Limit.Enabled() {
+		c.log.WithFields(logrus.Fields{"rate": c.Limit.Rate, "client_rate": c.Limit.ClientRate, "max_conns": c.Limit.MaxConns, "max_ws_conns": c.Limit.MaxWSConns}).Info("Limiting requests and connections")
+	}
+	if c.expSrv != nil {
+		if c.expListener, err = listenAddr(&c.ExplorerAddr); err != nil {
+			return fmt.Errorf("unable to listen on explorer address: %v", err)
+		}
+	}
+	return nil
+}
+
+// closeListeners closes the listeners of the servers, for an engine whose servers never started serving.
+func (c *EngineCmd) closeListeners() {
+	for _, l := range []net.Listener{c.srvListener, c.wsListener, c.expListener} {
+		if l != nil {
+			l.Close()
+		}
+	}
+	if c.ipc != nil {
+		c.ipc.Close()
+		os.Remove(c.IPCPath)
+	}
+	if c.rpcSrv != nil {
+		c.rpcSrv.Stop()
+	}
+}
+
+// listenAddr listens on the TCP address, replacing it with the bound one, e.g. the port picked for port 0.
+func listenAddr(addr *string) (net.Listener, error) {
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	*addr = l.Addr().String()
+	return l, nil
 }
 
 type EngineBackend struct {
 	log              logrus.Ext1FieldLogger
 	mockChain        *MockChain
 	payloadIdCounter uint64
-	recentPayloads   *lru.Cache
+	recentPayloads   *lru.Cache // payload id -> *builtPayload
+	parentPayloads   *lru.Cache // parent hash -> V1 payload, for the relay
+	latency          *LatencyMonitor
+	transition       *types.TransitionConfigurationV1
+	gasLimits        *gasLimits
+	faults           *FaultInjector
+	control          controls
+	timeline         *Timeline
+	events           *EventLog
+	scenario         *Scenario
+	invalid          *InvalidPayloads
+	txs              *TxGenerator
+	disabled         map[string]bool
+	censored         map[common.Address]bool
+	autoReorgs       AutoReorgConfig
+	executed         uint64
+	unknownPayloads  string
+	sync             *SyncSimulator
+	payloadRetention time.Duration
+	expiredPayloads  *lru.Cache
+	evictedPayloads  *lru.Cache // payload id -> rebuild func
+	payloadCacheSize int
+	rebuildEvicted   bool
+	cacheMu          sync.Mutex
+	cacheStats       PayloadCacheStats
+	buildMetrics     BuildMetrics
+	arbiter          *HeadArbiter
+	builds           *PayloadBuilds
+	checks           *PayloadChecker
+	calls            *CallStats
+	clock            *EngineClock
+	attributes       *AttributeValidator
+	auth             *rpc.Authenticator
+	accounts         []NamedAccount
+	peers            *PeerSet
+	identity         *ClientIdentity
+	verdicts         *Verdicts
+	subs             *Subscriptions
 }
 
+// Number of built payloads kept for getPayload by default, like the payload cache of geth.
+const defaultPayloadCacheSize = 10
+
+// Number of ids of expired and evicted payloads remembered, to tell them apart from ids that were never known.
+const forgottenPayloadsSize = 64
+
 func NewEngineBackend(log logrus.Ext1FieldLogger, mock *MockChain) (*EngineBackend, error) {
-	cache, err := lru.New(10)
+	cache, err := lru.New(defaultPayloadCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := lru.New(forgottenPayloadsSize)
+	if err != nil {
+		return nil, err
+	}
+	evicted, err := lru.New(forgottenPayloadsSize)
+	if err != nil {
+		return nil, err
+	}
+	byParent, err := lru.New(defaultPayloadCacheSize)
 	if err != nil {
 		return nil, err
 	}
-	return &EngineBackend{log, mock, 0, cache}, nil
+	return &EngineBackend{
+		log:              log,
+		mockChain:        mock,
+		recentPayloads:   cache,
+		parentPayloads:   byParent,
+		expiredPayloads:  expired,
+		evictedPayloads:  evicted,
+		payloadCacheSize: defaultPayloadCacheSize,
+		transition:       mock.TransitionConfig(),
+		gasLimits:        newGasLimits(mock.gspec.GasLimit),
+		faults:           NewFaultInjector(log, 0),
+		checks:           NewPayloadChecker(log),
+		calls:            NewCallStats(),
+		clock:            new(ClockSkewConfig).NewEngineClock(),
+		peers:            newPeerSet(PeersConfig{}, nil),
+		identity:         defaultClientIdentity(),
+		arbiter:          newHeadArbiter(log, ArbitrationConfig{Policy: ArbitrationLastWriterWins, Window: defaultArbitrationWindow}),
+		builds:           new(BuildConfig).NewPayloadBuilds(log),
+	}, nil
 }
 
-func (e *EngineBackend) GetPayloadV1(ctx context.Context, id types.PayloadID) (*types.ExecutionPayloadV1, error) {
+// enter is called on entry of every engine handler: it refuses calls of disabled methods, holds the call back by
+// the artificial delay of the method, and injects the first matching fault, or else the fault of the scenario step at the timestamp, if known,
+// or else a SYNCING status while a simulated sync lasts.
+func (e *EngineBackend) enter(ctx context.Context, method string, blockHash *common.Hash, timestamp uint64) (*Fault, error) {
+	e.timeline.Call(method)
+	e.calls.Call(method)
+	if err := e.disabledError(method); err != nil {
+		return nil, err
+	}
+	if err := e.control.Delays().Wait(ctx, method); err != nil {
+		return nil, err
+	}
+	rule := e.faults.Match(method, blockHash)
+	if step := e.scenario.Step(method, timestamp); rule == nil && step != nil {
+		rule = step.rule()
+	}
+	if rule == nil && e.sync.Syncing(method) {
+		rule = &FaultRule{Action: FaultStatus, Status: types.ExecutionSyncing}
+	}
+	if rule != nil {
+		e.timeline.Fault(method, rule)
+		e.events.Fault(method, blockHash, timestamp, rule)
+	}
+	return e.faults.Inject(ctx, method, rule)
+}
+
+// forkchoiceTimestamp is the timestamp of a forkchoiceUpdated call: that of the payload to build,
+// or else that of the head block, 0 if unknown.
+func (e *EngineBackend) forkchoiceTimestamp(head common.Hash, payloadTimestamp *uint64) uint64 {
+	if payloadTimestamp != nil {
+		return *payloadTimestamp
+	}
+	if header := e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(head)); header != nil {
+		return header.Time
+	}
+	return 0
+}
+
+// builtPayload is a payload prepared by forkchoiceUpdated. Before Cancun it's kept as V2 payload,
+// whose withdrawals are nil before Shanghai. The execution requests are nil before Prague.
+type builtPayload struct {
+	v2       *types.ExecutionPayloadV2
+	v3       *types.ExecutionPayloadV3
+	requests types.ExecutionRequests
+	value    *big.Int
+	created  time.Time
+	cost     BuildCost
+	// rebuild builds the payload again from the same parent and attributes, nil for restored payloads
+	rebuild func(maxTxs int) (*builtPayload, error)
+}
+
+func (b *builtPayload) transactions() int {
+	if b.v3 != nil {
+		return len(b.v3.Transactions)
+	}
+	return len(b.v2.Transactions)
+}
+
+func (e *EngineBackend) GetPayloadV1(ctx context.Context, id types.PayloadID) (_ *types.ExecutionPayloadV1, err error) {
+	defer e.latency.Track("engine_getPayloadV1")()
+	defer e.calls.Answered("engine_getPayloadV1", &err)
+	if _, err := e.enter(ctx, "engine_getPayloadV1", nil, 0); err != nil {
+		return nil, err
+	}
+	built, err := e.getPayload(ctx, "engine_getPayloadV1", id)
+	if err != nil {
+		return nil, err
+	}
+	if built.v3 != nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV1", built.v3.Timestamp)
+	} else if built.v2.Withdrawals != nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV1", built.v2.Timestamp)
+	}
+	e.served("engine_getPayloadV1", id, built)
+	return built.v2.PayloadV1(), nil
+}
+
+func (e *EngineBackend) GetPayloadV2(ctx context.Context, id types.PayloadID) (_ *types.ExecutionPayloadEnvelopeV2, err error) {
+	defer e.latency.Track("engine_getPayloadV2")()
+	defer e.calls.Answered("engine_getPayloadV2", &err)
+	if _, err := e.enter(ctx, "engine_getPayloadV2", nil, 0); err != nil {
+		return nil, err
+	}
+	built, err := e.getPayload(ctx, "engine_getPayloadV2", id)
+	if err != nil {
+		return nil, err
+	}
+	if built.v3 != nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV2", built.v3.Timestamp)
+	}
+	e.served("engine_getPayloadV2", id, built)
+	return &types.ExecutionPayloadEnvelopeV2{ExecutionPayload: built.v2, BlockValue: (*hexutil.Big)(built.value)}, nil
+}
+
+// GetPayloadV3 returns a Cancun payload. Its blobs bundle is always empty: geth v1.10.17, which executes the mock
+// chain, can't decode blob transactions, so built payloads never contain any. Blob support is a follow-up.
+func (e *EngineBackend) GetPayloadV3(ctx context.Context, id types.PayloadID) (_ *types.ExecutionPayloadEnvelopeV3, err error) {
+	defer e.latency.Track("engine_getPayloadV3")()
+	defer e.calls.Answered("engine_getPayloadV3", &err)
+	if _, err := e.enter(ctx, "engine_getPayloadV3", nil, 0); err != nil {
+		return nil, err
+	}
+	built, err := e.getPayload(ctx, "engine_getPayloadV3", id)
+	if err != nil {
+		return nil, err
+	}
+	if built.v3 == nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV3", built.v2.Timestamp)
+	} else if built.requests != nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV3", built.v3.Timestamp)
+	}
+	e.served("engine_getPayloadV3", id, built)
+	return &types.ExecutionPayloadEnvelopeV3{
+		ExecutionPayload: built.v3,
+		BlockValue:       (*hexutil.Big)(built.value),
+		BlobsBundle:      &types.BlobsBundleV1{Commitments: []hexutil.Bytes{}, Proofs: []hexutil.Bytes{}, Blobs: []hexutil.Bytes{}},
+	}, nil
+}
+
+// GetPayloadV4 returns a Prague payload, with an empty blobs bundle like GetPayloadV3.
+func (e *EngineBackend) GetPayloadV4(ctx context.Context, id types.PayloadID) (_ *types.ExecutionPayloadEnvelopeV4, err error) {
+	defer e.latency.Track("engine_getPayloadV4")()
+	defer e.calls.Answered("engine_getPayloadV4", &err)
+	fault, err := e.enter(ctx, "engine_getPayloadV4", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	built, err := e.getPayload(ctx, "engine_getPayloadV4", id)
+	if err != nil {
+		return nil, err
+	}
+	if built.v3 == nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV4", built.v2.Timestamp)
+	} else if built.requests == nil {
+		return nil, api.NewUnsupportedForkError("engine_getPayloadV4", built.v3.Timestamp)
+	}
+	e.served("engine_getPayloadV4", id, built)
+	return &types.ExecutionPayloadEnvelopeV4{
+		ExecutionPayload:  built.v3,
+		BlockValue:        (*hexutil.Big)(built.value),
+		BlobsBundle:       &types.BlobsBundleV1{Commitments: []hexutil.Bytes{}, Proofs: []hexutil.Bytes{}, Blobs: []hexutil.Bytes{}},
+		ExecutionRequests: fault.ExecutionRequests(built.requests),
+	}, nil
+}
+
+// Responses to getPayload calls with an unknown payload id, as engines differ in how they answer them.
+const (
+	UnknownPayloadUnavailable = "unavailable" // error -32001, of the early engine API drafts
+	UnknownPayloadUnknown     = "unknown"     // error -38001, of the final engine API specification
+	UnknownPayloadEmpty       = "empty"       // a synthetic payload of zero values, without transactions
+	UnknownPayloadTimeout     = "timeout"     // no response, until the client gives up on the call
+)
+
+func (e *EngineBackend) getPayload(ctx context.Context, method string, id types.PayloadID) (*builtPayload, error) {
 	plog := e.log.WithField("payload_id", id)
 
+	e.expirePayloads()
+	var created time.Time
+	if payload, ok := e.recentPayloads.Peek(id); ok {
+		created = payload.(*builtPayload).created
+	}
+	if err := e.builds.Finish(ctx, id, created); err != nil {
+		return nil, err
+	}
 	payload, ok := e.recentPayloads.Get(id)
 	if !ok {
-		plog.Warn("Cannot get unknown payload")
-		return nil, &rpc.Error{Err: fmt.Errorf("unknown payload %d", id), Id: int(api.UnavailablePayload)}
+		reason := "unknown"
+		if e.expiredPayloads.Contains(id) {
+			reason = "expired"
+			plog = plog.WithField("retention", e.payloadRetention)
+		} else if rebuild, evicted := e.evictedPayloads.Peek(id); evicted {
+			reason = "evicted"
+			plog = plog.WithField("cache_size", e.payloadCacheSize)
+			if rebuild := rebuild.(func(int) (*builtPayload, error)); e.rebuildEvicted && rebuild != nil {
+				built, err := rebuild(0)
+				if err != nil {
+					return nil, err
+				}
+				e.evictedPayloads.Remove(id)
+				e.storePayload(id, built)
+				atomic.AddUint64(&e.cacheStats.Rebuilt, 1)
+				plog.Warn("Rebuilt evicted payload for consensus client")
+				return built, nil
+			}
+		}
+		switch e.unknownPayloads {
+		case UnknownPayloadEmpty:
+			plog.Warn("Serving empty payload for " + reason + " payload")
+			return emptyPayload(method), nil
+		case UnknownPayloadTimeout:
+			plog.Warn("Not answering call for " + reason + " payload")
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		plog.Warn("Cannot get " + reason + " payload")
+		err := api.NewUnknownPayloadError(id)
+		if e.unknownPayloads == UnknownPayloadUnavailable {
+			err.Code = api.UnavailablePayload
+		}
+		return nil, err
 	}
 
 	plog.Info("Consensus client retrieved prepared payload")
-	return payload.(*types.ExecutionPayloadV1), nil
+	return payload.(*builtPayload), nil
+}
+
+func (e *EngineBackend) storePayload(id types.PayloadID, built *builtPayload) {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	if !e.recentPayloads.Contains(id) && e.recentPayloads.Len() >= e.payloadCacheSize {
+		// remember the id of the payload evicted to make room
+		if oldest, payload, ok := e.recentPayloads.GetOldest(); ok {
+			e.evictedPayloads.Add(oldest, payload.(*builtPayload).rebuild)
+			atomic.AddUint64(&e.cacheStats.Evicted, 1)
+			e.log.WithField("payload_id", oldest).Debug("Evicted payload")
+		}
+	}
+	e.recentPayloads.Add(id, built)
+	if built.v2 != nil && built.v2.Withdrawals == nil {
+		// the relay serves payloads by parent hash, and only knows V1 payloads
+		e.parentPayloads.Add(built.v2.ParentHash, built.v2.PayloadV1())
+	}
+}
+
+// buildForProposer rebuilds a payload prepared for the relay to honor the registration of the proposer: it pays the
+// fee recipient, and its gas limit moves toward the registered one. The rebuilt payload replaces the prepared one, for
+// getPayload to serve the payload of the bid. The payload is returned as is if it honors the registration already.
+func (e *EngineBackend) buildForProposer(payload *types.ExecutionPayloadV1, feeRecipient common.Address, gasLimit uint64) (*types.ExecutionPayloadV1, error) {
+	parent := e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(payload.ParentHash))
+	if parent == nil {
+		return nil, fmt.Errorf("unknown parent %s", payload.ParentHash)
+	}
+	target := payload.GasLimit
+	if gasLimit != 0 {
+		target = core.CalcGasLimit(parent.GasLimit, gasLimit)
+	}
+	if payload.FeeRecipient == feeRecipient && payload.GasLimit == target {
+		return payload, nil
+	}
+	creator := censoringCreator(e.mockChain.pool.Creator(e.txs.Creator()), e.censored)
+	bl, _, _, err := e.mockChain.buildBlock(payload.ParentHash, feeRecipient, payload.Timestamp, target, creator,
+		payload.Random, payload.ExtraData, nil, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt, err := api.BlockToPayloadV2(bl, payload.ParentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.log.WithFields(logrus.Fields{
+		"parent":        payload.ParentHash,
+		"fee_recipient": feeRecipient,
+		"gas_limit":     target,
+		"block_hash":    rebuilt.BlockHash,
+	}).Info("Rebuilt payload for registration of proposer")
+	v1 := rebuilt.PayloadV1()
+	e.parentPayloads.Add(payload.ParentHash, v1)
+	return v1, nil
+}
+
+// buildEquivocation builds another payload on the parent of the payload, for the relay to equivocate with: the same
+// fee recipient, gas limit and timestamp, but other extra data, so that its block hash differs. Unlike payloads rebuilt
+// for proposers, it isn't cached for later bids.
+func (e *EngineBackend) buildEquivocation(payload *types.ExecutionPayloadV1) (*types.ExecutionPayloadV1, error) {
+	extra := append([]byte{}, payload.ExtraData...)
+	if len(extra) == 0 {
+		extra = []byte{0xff}
+	} else {
+		extra[len(extra)-1] ^= 0xff
+	}
+	creator := censoringCreator(e.mockChain.pool.Creator(e.txs.Creator()), e.censored)
+	bl, _, _, err := e.mockChain.buildBlock(payload.ParentHash, payload.FeeRecipient, payload.Timestamp, payload.GasLimit, creator,
+		payload.Random, extra, nil, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	other, err := api.BlockToPayloadV2(bl, payload.ParentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	return other.PayloadV1(), nil
+}
+
+// expirePayloads drops the payloads built longer than the retention ago from the cache, and remembers their ids
+// for getPayload to tell them apart from ids that were never known.
+func (e *EngineBackend) expirePayloads() {
+	if e.payloadRetention == 0 {
+		return
+	}
+	for _, key := range e.recentPayloads.Keys() {
+		id, ok := key.(types.PayloadID)
+		if !ok {
+			continue
+		}
+		value, ok := e.recentPayloads.Peek(id)
+		if !ok || time.Since(value.(*builtPayload).created) <= e.payloadRetention {
+			continue
+		}
+		e.recentPayloads.Remove(id)
+		e.expiredPayloads.Add(id, struct{}{})
+		atomic.AddUint64(&e.cacheStats.Expired, 1)
+		e.log.WithField("payload_id", id).Debug("Expired payload")
+	}
+}
+
+// emptyPayload is the synthetic payload served for unknown payload ids, of the version of the getPayload method.
+func emptyPayload(method string) *builtPayload {
+	if method == "engine_getPayloadV3" || method == "engine_getPayloadV4" {
+		built := &builtPayload{v3: &types.ExecutionPayloadV3{
+			BaseFeePerGas: new(big.Int),
+			ExtraData:     []byte{},
+			Transactions:  [][]byte{},
+			Withdrawals:   []*types.Withdrawal{},
+		}, value: new(big.Int)}
+		if method == "engine_getPayloadV4" {
+			built.requests = types.ExecutionRequests{}
+		}
+		return built
+	}
+	return &builtPayload{v2: &types.ExecutionPayloadV2{
+		BaseFeePerGas: new(big.Int),
+		ExtraData:     []byte{},
+		Transactions:  [][]byte{},
+	}, value: new(big.Int)}
+}
+
+func (e *EngineBackend) NewPayloadV1(ctx context.Context, payload *types.ExecutionPayloadV1) (status *types.PayloadStatusV1, err error) {
+	defer e.latency.Track("engine_newPayloadV1")()
+	defer e.calls.NewPayloadAnswered("engine_newPayloadV1", &status, &err)
+	defer e.events.NewPayloadReceived("engine_newPayloadV1", time.Now(), payload.BlockHash, payload.ParentHash, payload.Timestamp, &status, &err)
+	fault, err := e.enter(ctx, "engine_newPayloadV1", &payload.BlockHash, payload.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	e.checks.Submitted("engine_newPayloadV1", payload.BlockHash, payload.ParentHash, payload.Timestamp, payload)
+	if e.mockChain.IsShanghai(payload.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_newPayloadV1", payload.Timestamp)
+	}
+	return fault.PayloadStatus(e.newPayload(payload.BlockHash, payload.ParentHash, payload.ValidateHash(), func() error {
+		_, err := e.mockChain.ProcessPayload(payload)
+		return err
+	}))
+}
+
+func (e *EngineBackend) NewPayloadV2(ctx context.Context, payload *types.ExecutionPayloadV2) (status *types.PayloadStatusV1, err error) {
+	defer e.latency.Track("engine_newPayloadV2")()
+	defer e.calls.NewPayloadAnswered("engine_newPayloadV2", &status, &err)
+	defer e.events.NewPayloadReceived("engine_newPayloadV2", time.Now(), payload.BlockHash, payload.ParentHash, payload.Timestamp, &status, &err)
+	fault, err := e.enter(ctx, "engine_newPayloadV2", &payload.BlockHash, payload.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	e.checks.Submitted("engine_newPayloadV2", payload.BlockHash, payload.ParentHash, payload.Timestamp, payload)
+	if e.mockChain.IsCancun(payload.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_newPayloadV2", payload.Timestamp)
+	}
+	if shanghai := e.mockChain.IsShanghai(payload.Timestamp); shanghai && payload.Withdrawals == nil {
+		return nil, api.NewInvalidParamsError("missing withdrawals after shanghai, at timestamp %d", payload.Timestamp)
+	} else if !shanghai && payload.Withdrawals != nil {
+		return nil, api.NewInvalidParamsError("withdrawals before shanghai, at timestamp %d", payload.Timestamp)
+	}
+	return fault.PayloadStatus(e.newPayload(payload.BlockHash, payload.ParentHash, payload.ValidateHash(), func() error {
+		_, err := e.mockChain.ProcessPayloadV2(payload)
+		return err
+	}))
+}
+
+// NewPayloadV3 executes a Cancun payload. As geth v1.10.17 can't decode blob transactions, a payload with blob
+// versioned hashes is INVALID, even if its blob transactions would be: blob support is a follow-up.
+func (e *EngineBackend) NewPayloadV3(ctx context.Context, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash) (status *types.PayloadStatusV1, err error) {
+	defer e.latency.Track("engine_newPayloadV3")()
+	defer e.calls.NewPayloadAnswered("engine_newPayloadV3", &status, &err)
+	defer e.events.NewPayloadReceived("engine_newPayloadV3", time.Now(), payload.BlockHash, payload.ParentHash, payload.Timestamp, &status, &err)
+	fault, err := e.enter(ctx, "engine_newPayloadV3", &payload.BlockHash, payload.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	parentBeaconRoot = fault.ParentBeaconRoot(parentBeaconRoot)
+	e.checks.Submitted("engine_newPayloadV3", payload.BlockHash, payload.ParentHash, payload.Timestamp, payload)
+	if !e.mockChain.IsCancun(payload.Timestamp) || e.mockChain.IsPrague(payload.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_newPayloadV3", payload.Timestamp)
+	}
+	if len(versionedHashes) != 0 {
+		e.log.WithField("block_hash", payload.BlockHash).Warn("Payload blob versioned hashes do not match its transactions")
+		return fault.PayloadStatus(&types.PayloadStatusV1{
+			Status:          types.ExecutionInvalid,
+			ValidationError: fmt.Sprintf("expected no blob versioned hashes, got %d", len(versionedHashes)),
+		}, nil)
+	}
+	return fault.PayloadStatus(e.newPayload(payload.BlockHash, payload.ParentHash, payload.ValidateHash(parentBeaconRoot), func() error {
+		_, err := e.mockChain.ProcessPayloadV3(payload, parentBeaconRoot)
+		return err
+	}))
+}
+
+// NewPayloadV4 executes a Prague payload. Its block hash has to commit to the requests hash of the execution
+// requests, which are otherwise taken as given. Blob versioned hashes make it INVALID like for NewPayloadV3.
+func (e *EngineBackend) NewPayloadV4(ctx context.Context, payload *types.ExecutionPayloadV3, versionedHashes []common.Hash, parentBeaconRoot common.Hash, requests types.ExecutionRequests) (status *types.PayloadStatusV1, err error) {
+	defer e.latency.Track("engine_newPayloadV4")()
+	defer e.calls.NewPayloadAnswered("engine_newPayloadV4", &status, &err)
+	defer e.events.NewPayloadReceived("engine_newPayloadV4", time.Now(), payload.BlockHash, payload.ParentHash, payload.Timestamp, &status, &err)
+	fault, err := e.enter(ctx, "engine_newPayloadV4", &payload.BlockHash, payload.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	parentBeaconRoot = fault.ParentBeaconRoot(parentBeaconRoot)
+	e.checks.Submitted("engine_newPayloadV4", payload.BlockHash, payload.ParentHash, payload.Timestamp, payload)
+	if !e.mockChain.IsPrague(payload.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_newPayloadV4", payload.Timestamp)
+	}
+	if requests == nil {
+		return nil, api.NewInvalidParamsError("missing execution requests")
+	}
+	if err := requests.Validate(); err != nil {
+		return nil, api.NewInvalidParamsError("%v", err)
+	}
+	if len(versionedHashes) != 0 {
+		e.log.WithField("block_hash", payload.BlockHash).Warn("Payload blob versioned hashes do not match its transactions")
+		return fault.PayloadStatus(&types.PayloadStatusV1{
+			Status:          types.ExecutionInvalid,
+			ValidationError: fmt.Sprintf("expected no blob versioned hashes, got %d", len(versionedHashes)),
+		}, nil)
+	}
+	validHash := payload.ValidateHashV4(parentBeaconRoot, requests)
+	if !validHash {
+		e.log.WithFields(logrus.Fields{"block_hash": payload.BlockHash, "requests_hash": requests.Hash()}).Warn("Payload block hash does not commit to the requests hash of its execution requests")
+	}
+	return fault.PayloadStatus(e.newPayload(payload.BlockHash, payload.ParentHash, validHash, func() error {
+		_, err := e.mockChain.ProcessPayloadV4(payload, parentBeaconRoot, requests)
+		return err
+	}))
 }
 
-func (e *EngineBackend) NewPayloadV1(ctx context.Context, payload *types.ExecutionPayloadV1) (*types.PayloadStatusV1, error) {
-	log := e.log.WithField("block_hash", payload.BlockHash)
-	if !payload.ValidateHash() {
+func (e *EngineBackend) newPayload(blockHash, parentHash common.Hash, validHash bool, process func() error) (*types.PayloadStatusV1, error) {
+	log := e.log.WithField("block_hash", blockHash)
+	if !validHash {
 		return &types.PayloadStatusV1{Status: types.ExecutionInvalidBlockHash}, nil
 	}
-	parent := e.mockChain.chain.GetHeaderByHash(payload.ParentHash)
+	parent := e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(parentHash))
 	if parent == nil {
-		log.WithField("parent_hash", payload.ParentHash.String()).Warn("Cannot execute payload, parent is unknown")
+		log.WithField("parent_hash", parentHash.String()).Warn("Cannot execute payload, parent is unknown")
 		return &types.PayloadStatusV1{Status: types.ExecutionSyncing}, nil
-	} else if parent.Difficulty.Cmp(e.mockChain.gspec.Config.TerminalTotalDifficulty) < 0 {
-		log.WithField("parent_hash", payload.ParentHash.String()).Warn("Parent block not yet at TTD")
+	} else if !e.mockChain.IsTerminalBlock(parent) {
+		log.WithField("parent_hash", parentHash.String()).Warn("Parent block is not a valid terminal block")
 		return &types.PayloadStatusV1{Status: types.ExecutionInvalidTerminalBlock}, nil
 	}
 
-	_, err := e.mockChain.ProcessPayload(payload)
-	if err != nil {
-		log.WithError(err).Error("Failed to execute payload")
-		// TODO proper error codes
-		return nil, err
+	if err := process(); err != nil {
+		log.WithError(err).Warn("Failed to execute payload")
+		latestValid := parentHash
+		return &types.PayloadStatusV1{Status: types.ExecutionInvalid, LatestValidHash: &latestValid, ValidationError: err.Error()}, nil
 	}
 	log.Info("Executed payload")
+	e.sync.Imported(parent.Number.Uint64() + 1)
+	e.autoReorg()
+	if status := e.verdicts.Accept(blockHash, parentHash); status != nil {
+		return status, nil
+	}
 	return &types.PayloadStatusV1{Status: types.ExecutionValid}, nil
 }
 
-func (e *EngineBackend) ForkchoiceUpdatedV1(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV1) (*types.ForkchoiceUpdatedResult, error) {
+func (e *EngineBackend) ForkchoiceUpdatedV1(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV1) (result *types.ForkchoiceUpdatedResult, err error) {
+	defer e.latency.Track("engine_forkchoiceUpdatedV1")()
+	var timestamp *uint64
+	if attributes != nil {
+		timestamp = &attributes.Timestamp
+	}
+	callTimestamp := e.forkchoiceTimestamp(heads.HeadBlockHash, timestamp)
+	defer e.events.FcuReceived("engine_forkchoiceUpdatedV1", time.Now(), heads.HeadBlockHash, callTimestamp, &result, &err)
+	defer e.calls.ForkchoiceAnswered("engine_forkchoiceUpdatedV1", heads, timestamp, &result, &err)
+	fault, err := e.enter(ctx, "engine_forkchoiceUpdatedV1", &heads.HeadBlockHash, callTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	var attributesV2 *types.PayloadAttributesV2
+	if attributes != nil {
+		attributesV2 = &types.PayloadAttributesV2{
+			Timestamp:             attributes.Timestamp,
+			PrevRandao:            attributes.PrevRandao,
+			SuggestedFeeRecipient: attributes.SuggestedFeeRecipient,
+		}
+	}
+	return fault.ForkchoiceUpdated(e.forkchoiceUpdated(ctx, heads, attributesV2, nil))
+}
+
+func (e *EngineBackend) ForkchoiceUpdatedV2(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV2) (result *types.ForkchoiceUpdatedResult, err error) {
+	defer e.latency.Track("engine_forkchoiceUpdatedV2")()
+	var timestamp *uint64
+	if attributes != nil {
+		timestamp = &attributes.Timestamp
+	}
+	callTimestamp := e.forkchoiceTimestamp(heads.HeadBlockHash, timestamp)
+	defer e.events.FcuReceived("engine_forkchoiceUpdatedV2", time.Now(), heads.HeadBlockHash, callTimestamp, &result, &err)
+	defer e.calls.ForkchoiceAnswered("engine_forkchoiceUpdatedV2", heads, timestamp, &result, &err)
+	fault, err := e.enter(ctx, "engine_forkchoiceUpdatedV2", &heads.HeadBlockHash, callTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if attributes != nil && e.mockChain.IsCancun(attributes.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_forkchoiceUpdatedV2", attributes.Timestamp)
+	}
+	return fault.ForkchoiceUpdated(e.forkchoiceUpdated(ctx, heads, attributes, nil))
+}
+
+func (e *EngineBackend) ForkchoiceUpdatedV3(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV3) (result *types.ForkchoiceUpdatedResult, err error) {
+	defer e.latency.Track("engine_forkchoiceUpdatedV3")()
+	var timestamp *uint64
+	if attributes != nil {
+		timestamp = &attributes.Timestamp
+	}
+	callTimestamp := e.forkchoiceTimestamp(heads.HeadBlockHash, timestamp)
+	defer e.events.FcuReceived("engine_forkchoiceUpdatedV3", time.Now(), heads.HeadBlockHash, callTimestamp, &result, &err)
+	defer e.calls.ForkchoiceAnswered("engine_forkchoiceUpdatedV3", heads, timestamp, &result, &err)
+	fault, err := e.enter(ctx, "engine_forkchoiceUpdatedV3", &heads.HeadBlockHash, callTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if attributes == nil {
+		return fault.ForkchoiceUpdated(e.forkchoiceUpdated(ctx, heads, nil, nil))
+	}
+	if !e.mockChain.IsCancun(attributes.Timestamp) {
+		return nil, api.NewUnsupportedForkError("engine_forkchoiceUpdatedV3", attributes.Timestamp)
+	}
+	attributesV2 := &types.PayloadAttributesV2{
+		Timestamp:             attributes.Timestamp,
+		PrevRandao:            attributes.PrevRandao,
+		SuggestedFeeRecipient: attributes.SuggestedFeeRecipient,
+		Withdrawals:           attributes.Withdrawals,
+	}
+	parentBeaconRoot := fault.ParentBeaconRoot(attributes.ParentBeaconBlockRoot)
+	return fault.ForkchoiceUpdated(e.forkchoiceUpdated(ctx, heads, attributesV2, &parentBeaconRoot))
+}
+
+// forkchoiceUpdated builds a payload with a parent beacon block root from Cancun on, which is nil before.
+func (e *EngineBackend) forkchoiceUpdated(ctx context.Context, heads *types.ForkchoiceStateV1, attributes *types.PayloadAttributesV2, parentBeaconRoot *common.Hash) (*types.ForkchoiceUpdatedResult, error) {
 	e.log.WithFields(logrus.Fields{
 		"head":       heads.HeadBlockHash,
 		"safe":       heads.SafeBlockHash,
 		"finalized":  heads.FinalizedBlockHash,
 		"attributes": attributes,
 	}).Info("Forkchoice updated")
+	if status := e.verdicts.Status(heads.HeadBlockHash); status != nil {
+		e.log.WithField("status", status.Status).Info("Head verdict not valid, not preparing payload")
+		return &types.ForkchoiceUpdatedResult{PayloadStatus: *status}, nil
+	}
+	if status, err := e.arbiter.Arbitrate(e.mockChain, rpc.ClientID(ctx), heads.HeadBlockHash); err != nil {
+		return nil, err
+	} else if status != nil {
+		return &types.ForkchoiceUpdatedResult{PayloadStatus: *status}, nil
+	}
+	if status, err := e.applyForkchoice(heads); err != nil {
+		return nil, err
+	} else if status != nil {
+		return &types.ForkchoiceUpdatedResult{PayloadStatus: *status}, nil
+	}
+	e.timeline.Head(e.mockChain, heads.HeadBlockHash)
+	e.mockChain.pool.Prune(e.mockChain.chain)
+	e.control.SetForkchoice(*heads)
+	if stopped := e.builds.Supersede(heads.HeadBlockHash); stopped > 0 {
+		e.log.WithFields(logrus.Fields{"head": heads.HeadBlockHash, "builds": stopped}).Info("Stopped payload builds on the previous head")
+	}
 
 	if attributes == nil {
 		return &types.ForkchoiceUpdatedResult{PayloadStatus: types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}}, nil
 	}
+	if shanghai := e.mockChain.IsShanghai(attributes.Timestamp); shanghai && attributes.Withdrawals == nil {
+		return nil, api.NewInvalidPayloadAttributesError("missing withdrawals after shanghai, at timestamp %d", attributes.Timestamp)
+	} else if !shanghai && attributes.Withdrawals != nil {
+		return nil, api.NewInvalidPayloadAttributesError("withdrawals before shanghai, at timestamp %d", attributes.Timestamp)
+	}
+	head := e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(heads.HeadBlockHash))
+	if head != nil && attributes.Timestamp <= head.Time {
+		return nil, api.NewInvalidPayloadAttributesError("timestamp %d not after head timestamp %d", attributes.Timestamp, head.Time)
+	}
+	if err := e.clock.CheckTimestamp(attributes.Timestamp); err != nil {
+		return nil, err
+	}
+	if head != nil {
+		if err := e.attributes.Check(head, e.mockChain.ForkFields(head.Hash()), attributes, parentBeaconRoot); err != nil {
+			return nil, err
+		}
+		if fork := e.mockChain.ForkAt(attributes.Timestamp); fork != e.mockChain.ForkAt(head.Time) {
+			e.log.WithFields(logrus.Fields{"fork": fork, "timestamp": attributes.Timestamp}).Info("Preparing the first payload of the fork")
+		}
+	}
+	if e.control.Frozen() {
+		e.log.Warn("Block production frozen, not preparing payload")
+		return &types.ForkchoiceUpdatedResult{PayloadStatus: types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}}, nil
+	}
 	idU64 := atomic.AddUint64(&e.payloadIdCounter, 1)
 	var id types.PayloadID
 	binary.BigEndian.PutUint64(id[:], idU64)
@@ -242,36 +1520,181 @@ func (e *EngineBackend) ForkchoiceUpdatedV1(ctx context.Context, heads *types.Fo
 		"timestamp":               attributes.Timestamp,
 		"prev_randao":             attributes.PrevRandao.String(),
 		"suggested_fee_recipient": attributes.SuggestedFeeRecipient.String(),
+		"withdrawals":             len(attributes.Withdrawals),
 	}).Info("Preparing new payload")
 
-	gasLimit := e.mockChain.gspec.GasLimit
-	txsCreator := TransactionsCreator{nil, func(config *params.ChainConfig, bc core.ChainContext,
-		statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
-		// empty payload
-		// TODO: maybe vary these a little?
-		return nil
-	}}
+	parentHash := heads.HeadBlockHash
+	if step := e.scenario.Step("engine_forkchoiceUpdated", attributes.Timestamp); step != nil && step.Behavior == ScenarioReorg {
+		ancestor, err := e.mockChain.Ancestor(heads.HeadBlockHash, step.Depth)
+		if err != nil {
+			return nil, err
+		}
+		plog.WithFields(logrus.Fields{"depth": step.Depth, "parent": ancestor}).Warn("Scenario reorg, building payload on ancestor of head")
+		parentHash = ancestor
+	}
+	var number, parentGasLimit uint64
+	parent := e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(parentHash))
+	if parent != nil {
+		number, parentGasLimit = parent.Number.Uint64()+1, parent.GasLimit
+	}
+	gasLimit := e.gasLimits.For(number, parentGasLimit)
 	extraData := []byte{}
+	slot, invalidKinds := e.invalid.At(attributes.Timestamp)
 
-	bl, err := e.mockChain.AddNewBlock(common.BytesToHash(heads.HeadBlockHash[:]), attributes.SuggestedFeeRecipient, uint64(attributes.Timestamp),
-		gasLimit, txsCreator, attributes.PrevRandao, extraData, nil, false)
+	var build func(maxTxs int) (*builtPayload, error)
+	build = func(maxTxs int) (*builtPayload, error) {
+		creator := censoringCreator(e.mockChain.pool.Creator(e.txs.Creator()), e.censored)
+		if maxTxs != 0 {
+			creator = limitingCreator(creator, maxTxs)
+		}
+		var (
+			bl       *ethTypes.Block
+			receipts ethTypes.Receipts
+			fork     *types.ForkFields
+			err      error
+		)
+		wall, cpu := measureBuild(func() {
+			bl, receipts, fork, err = e.mockChain.buildBlock(parentHash, attributes.SuggestedFeeRecipient, uint64(attributes.Timestamp),
+				gasLimit, creator, attributes.PrevRandao, extraData, nil, attributes.Withdrawals, parentBeaconRoot, false)
+		})
 
-	if err != nil {
-		// TODO: proper error codes
-		plog.WithError(err).Error("Failed to create block, cannot build new payload")
+		if err != nil {
+			plog.WithError(err).Error("Failed to create block, cannot build new payload")
+			return nil, api.NewInternalError("failed to build payload: %v", err)
+		}
+
+		cost := BuildCost{WallTime: wall, CPUTime: cpu, Txs: len(bl.Transactions()), GasUsed: bl.GasUsed()}
+		e.buildMetrics.Record(cost)
+		plog.WithFields(logrus.Fields{"wall_time": wall, "cpu_time": cpu, "txs": cost.Txs, "gas_used": cost.GasUsed}).Debug("Built payload")
+		built := &builtPayload{value: tipsPaid(bl, receipts), created: time.Now(), cost: cost, rebuild: build}
+		if fork != nil && fork.RequestsHash != nil {
+			built.requests = types.ExecutionRequests{}
+		}
+		if len(invalidKinds) > 0 {
+			plog.WithFields(logrus.Fields{"slot": slot, "kinds": invalidKinds, "txs": cost.Txs}).Warn("Building invalid payload")
+			bl = breakBlock(bl, parent, invalidKinds)
+		}
+		if parentBeaconRoot != nil {
+			built.v3, err = api.BlockToPayloadV3(bl, parentHash, fork)
+		} else {
+			built.v2, err = api.BlockToPayloadV2(bl, parentHash, attributes.Withdrawals)
+		}
+		if err != nil {
+			plog.WithError(err).Error("Failed to convert block to payload")
+			return nil, api.NewInternalError("failed to convert block to payload: %v", err)
+		}
+		if built.v3 != nil {
+			built.v3.BlockHash = breakBlockHash(built.v3.BlockHash, invalidKinds)
+			built.v3.Withdrawals = breakWithdrawals(built.v3.Withdrawals, invalidKinds)
+		} else {
+			built.v2.BlockHash = breakBlockHash(built.v2.BlockHash, invalidKinds)
+			built.v2.Withdrawals = breakWithdrawals(built.v2.Withdrawals, invalidKinds)
+		}
+		if built.v3 != nil {
+			e.mockChain.recordBlock(JournalBuild, "engine_forkchoiceUpdated", built.v3.BlockHash, bl)
+			e.events.PayloadBuilt(id, built.v3.BlockHash, parentHash, built.v3.Timestamp, cost.Txs, wall)
+		} else {
+			e.mockChain.recordBlock(JournalBuild, "engine_forkchoiceUpdated", built.v2.BlockHash, bl)
+			e.events.PayloadBuilt(id, built.v2.BlockHash, parentHash, built.v2.Timestamp, cost.Txs, wall)
+		}
+		return built, nil
+	}
+	// store in cache for later retrieval
+	publish := func(built *builtPayload) {
+		e.expirePayloads()
+		e.storePayload(id, built)
+		if built.v3 != nil {
+			e.timeline.BlockBuilt(built.v3.BlockHash)
+		} else {
+			e.timeline.BlockBuilt(built.v2.BlockHash)
+		}
+	}
+
+	pb := e.builds.Start(id, heads.HeadBlockHash, e.payloadRetention, build, publish)
+	if e.builds.Async() {
+		plog.Info("Building payload in the background")
+	} else if err := pb.Wait(ctx); err != nil {
+		// nobody retrieves a payload whose forkchoiceUpdated gave up on it
+		pb.stop()
 		return nil, err
 	}
 
-	payload, err := api.BlockToPayload(bl)
-	if err != nil {
-		plog.WithError(err).Error("Failed to convert block to payload")
-		// TODO: proper error codes
+	return &types.ForkchoiceUpdatedResult{PayloadStatus: types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}, PayloadID: &id}, nil
+}
+
+// GetClientVersionV1 reports the client the engine identifies as, and logs the version of the consensus client.
+func (e *EngineBackend) GetClientVersionV1(ctx context.Context, client types.ClientVersionV1) (_ []types.ClientVersionV1, err error) {
+	defer e.latency.Track("engine_getClientVersionV1")()
+	defer e.calls.Answered("engine_getClientVersionV1", &err)
+	if _, err := e.enter(ctx, "engine_getClientVersionV1", nil, 0); err != nil {
 		return nil, err
 	}
+	e.log.WithFields(logrus.Fields{
+		"code":    client.Code,
+		"name":    client.Name,
+		"version": client.Version,
+		"commit":  client.Commit,
+	}).Debug("Consensus client version")
+	return []types.ClientVersionV1{e.identity.Version()}, nil
+}
 
-	// store in cache for later retrieval
-	e.recentPayloads.Add(id, payload)
-	e.recentPayloads.Add(payload.ParentHash, payload)
+// GetPayloadBodiesByHashV1 returns the payload bodies of the blocks of the hashes, null for unknown blocks.
+func (e *EngineBackend) GetPayloadBodiesByHashV1(ctx context.Context, hashes []common.Hash) (_ []*types.ExecutionPayloadBodyV1, err error) {
+	defer e.latency.Track("engine_getPayloadBodiesByHashV1")()
+	defer e.calls.Answered("engine_getPayloadBodiesByHashV1", &err)
+	if _, err := e.enter(ctx, "engine_getPayloadBodiesByHashV1", nil, 0); err != nil {
+		return nil, err
+	}
+	if len(hashes) > maxPayloadBodies {
+		return nil, api.NewTooLargeRequestError("%d payload bodies requested, at most %d served", len(hashes), maxPayloadBodies)
+	}
+	bodies := make([]*types.ExecutionPayloadBodyV1, len(hashes))
+	for i, hash := range hashes {
+		bodies[i] = e.mockChain.payloadBody(e.mockChain.chain.GetBlockByHash(e.mockChain.ResolveHash(hash)))
+	}
+	return bodies, nil
+}
 
-	return &types.ForkchoiceUpdatedResult{PayloadStatus: types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}, PayloadID: &id}, nil
+// GetPayloadBodiesByRangeV1 returns the payload bodies of the canonical blocks of the range, up to the head.
+func (e *EngineBackend) GetPayloadBodiesByRangeV1(ctx context.Context, start, count hexutil.Uint64) (_ []*types.ExecutionPayloadBodyV1, err error) {
+	defer e.latency.Track("engine_getPayloadBodiesByRangeV1")()
+	defer e.calls.Answered("engine_getPayloadBodiesByRangeV1", &err)
+	if _, err := e.enter(ctx, "engine_getPayloadBodiesByRangeV1", nil, 0); err != nil {
+		return nil, err
+	}
+	if start < 1 || count < 1 {
+		return nil, api.NewInvalidParamsError("range of %d payload bodies from %d, expected at least one from block 1 on", count, start)
+	}
+	if count > maxPayloadBodies {
+		return nil, api.NewTooLargeRequestError("%d payload bodies requested, at most %d served", count, maxPayloadBodies)
+	}
+	head := e.mockChain.CurrentHeader().Number.Uint64()
+	bodies := []*types.ExecutionPayloadBodyV1{}
+	for number := uint64(start); number < uint64(start+count) && number <= head; number++ {
+		bodies = append(bodies, e.mockChain.payloadBody(e.mockChain.chain.GetBlockByNumber(number)))
+	}
+	return bodies, nil
+}
+
+// ExchangeTransitionConfigurationV1 reports the transition configuration, as overridden by flags.
+// Mismatches with the consensus client are logged, the terminal block number isn't compared as per spec.
+func (e *EngineBackend) ExchangeTransitionConfigurationV1(ctx context.Context, config *types.TransitionConfigurationV1) (_ *types.TransitionConfigurationV1, err error) {
+	defer e.latency.Track("engine_exchangeTransitionConfigurationV1")()
+	defer e.calls.Answered("engine_exchangeTransitionConfigurationV1", &err)
+	if _, err := e.enter(ctx, "engine_exchangeTransitionConfigurationV1", nil, 0); err != nil {
+		return nil, err
+	}
+	if config.TerminalTotalDifficulty == nil {
+		return nil, fmt.Errorf("missing terminal total difficulty")
+	}
+	local := e.transition
+	if local.TerminalTotalDifficulty.ToInt().Cmp(config.TerminalTotalDifficulty.ToInt()) != 0 || local.TerminalBlockHash != config.TerminalBlockHash {
+		e.log.WithFields(logrus.Fields{
+			"local_ttd":         local.TerminalTotalDifficulty,
+			"remote_ttd":        config.TerminalTotalDifficulty,
+			"local_block_hash":  local.TerminalBlockHash,
+			"remote_block_hash": config.TerminalBlockHash,
+		}).Warn("Transition configuration mismatch")
+	}
+	return local, nil
 }
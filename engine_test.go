@@ -0,0 +1,1428 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+// testEngine is an engine command running in-process, driven over its authenticated HTTP endpoint
+// like a consensus client would.
+type testEngine struct {
+	*EngineCmd
+	client *rpc.Client
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func newTestEngine(t *testing.T) *testEngine {
+	return newTestEngineWithGenesis(t, newGenesis(t))
+}
+
+// newTestEngineWithGenesis starts an engine on the genesis, with flags changed by the configure functions.
+func newTestEngineWithGenesis(t *testing.T, genesisPath string, configure ...func(cmd *EngineCmd)) *testEngine {
+	ctx := context.Background()
+	cmd := new(EngineCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.GasPriceOracle.Default()
+	cmd.JwtSecretPath = newJwt(t)
+	cmd.GenesisPath = genesisPath
+	cmd.ListenAddr = "127.0.0.1:0"
+	cmd.WebsocketAddr = "127.0.0.1:0"
+	for _, fn := range configure {
+		fn(cmd)
+	}
+	require.NoError(t, cmd.Run(ctx))
+	t.Cleanup(func() { cmd.Close() })
+
+	client, err := rpc.DialContext(ctx, "http://"+cmd.ListenAddr, cmd.jwtSecret)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	// The server starts in the background, wait until it answers.
+	require.Eventually(t, func() bool {
+		var block map[string]interface{}
+		return client.CallContext(ctx, &block, "eth_getBlockByNumber", "latest", false) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return &testEngine{cmd, client}
+}
+
+// buildPayload asks the engine to build a payload on top of parent, and retrieves it.
+func (te *testEngine) buildPayload(t *testing.T, parent common.Hash, timestamp uint64, prevRandao common.Hash) *types.ExecutionPayloadV1 {
+	ctx := context.Background()
+	attributes := &types.PayloadAttributesV1{
+		Timestamp:             timestamp,
+		PrevRandao:            prevRandao,
+		SuggestedFeeRecipient: common.Address{0x02},
+	}
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, parent, parent, parent, attributes)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+	require.NotNil(t, result.PayloadID)
+
+	payload, err := api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, parent, payload.ParentHash)
+	return payload
+}
+
+func (te *testEngine) newPayload(t *testing.T, payload *types.ExecutionPayloadV1) types.ExecutePayloadStatus {
+	status, err := api.NewPayloadV1(context.Background(), te.client, te.log, payload)
+	require.NoError(t, err)
+	return status.Status
+}
+
+// setHead makes the block the head with a forkchoiceUpdated call, as imported payloads don't become the head.
+func (te *testEngine) setHead(t *testing.T, hash common.Hash) {
+	result, err := api.ForkchoiceUpdatedV1(context.Background(), te.client, te.log, hash, hash, hash, nil)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+}
+
+func (te *testEngine) requireKnownBlock(t *testing.T, hash common.Hash) {
+	var block map[string]interface{}
+	require.NoError(t, te.client.CallContext(context.Background(), &block, "eth_getBlockByHash", hash, false))
+	require.NotNil(t, block, "block %s not known to the engine", hash)
+}
+
+var engineFlows = []struct {
+	name string
+	run  func(t *testing.T, te *testEngine)
+}{
+	{"proposal", func(t *testing.T, te *testEngine) {
+		genesis := te.mockChain().CurrentHeader()
+		payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+		te.requireKnownBlock(t, payload.BlockHash)
+
+		result, err := api.ForkchoiceUpdatedV1(context.Background(), te.client, te.log, payload.BlockHash, genesis.Hash(), genesis.Hash(), nil)
+		require.NoError(t, err)
+		require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+	}},
+	{"chain of proposals", func(t *testing.T, te *testEngine) {
+		parent := te.mockChain().CurrentHeader()
+		parentHash, timestamp := parent.Hash(), parent.Time
+		for i := 0; i < 5; i++ {
+			timestamp += 12
+			payload := te.buildPayload(t, parentHash, timestamp, common.Hash{byte(i)})
+			require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+			te.requireKnownBlock(t, payload.BlockHash)
+			parentHash = payload.BlockHash
+		}
+	}},
+	{"competing forks", func(t *testing.T, te *testEngine) {
+		genesis := te.mockChain().CurrentHeader()
+		a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x0a})
+		b := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x0b})
+		require.NotEqual(t, a.BlockHash, b.BlockHash)
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, b))
+		te.requireKnownBlock(t, a.BlockHash)
+		te.requireKnownBlock(t, b.BlockHash)
+	}},
+	{"unknown parent", func(t *testing.T, te *testEngine) {
+		// Build two blocks on another engine, and only share the second one.
+		other := newTestEngine(t)
+		genesis := other.mockChain().CurrentHeader()
+		parent := other.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+		require.Equal(t, types.ExecutionValid, other.newPayload(t, parent))
+		child := other.buildPayload(t, parent.BlockHash, parent.Timestamp+12, common.Hash{0x02})
+		require.Equal(t, types.ExecutionSyncing, te.newPayload(t, child))
+	}},
+	{"invalid block hash", func(t *testing.T, te *testEngine) {
+		genesis := te.mockChain().CurrentHeader()
+		payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+		payload.BlockHash = common.Hash{0xff}
+		require.Equal(t, types.ExecutionInvalidBlockHash, te.newPayload(t, payload))
+	}},
+	{"gas limit overrides", func(t *testing.T, te *testEngine) {
+		ctx := context.Background()
+		genesis := te.mockChain().CurrentHeader()
+		require.NoError(t, te.client.CallContext(ctx, nil, "mock_setGasLimit", hexutil.Uint64(20_000_000)))
+		require.NoError(t, te.client.CallContext(ctx, nil, "mock_setGasLimit", hexutil.Uint64(40_000_000), hexutil.Uint64(2)))
+		require.Error(t, te.client.CallContext(ctx, nil, "mock_setGasLimit", hexutil.Uint64(0)))
+
+		first := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+		require.Equal(t, uint64(20_000_000), first.GasLimit)
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, first))
+		second := te.buildPayload(t, first.BlockHash, first.Timestamp+12, common.Hash{0x02})
+		require.Equal(t, uint64(40_000_000), second.GasLimit)
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, second))
+		third := te.buildPayload(t, second.BlockHash, second.Timestamp+12, common.Hash{0x03})
+		require.Equal(t, uint64(20_000_000), third.GasLimit)
+	}},
+	{"unknown payload id", func(t *testing.T, te *testEngine) {
+		_, err := api.GetPayloadV1(context.Background(), te.client, te.log, types.PayloadID{0xff})
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, api.UnknownPayload, code)
+	}},
+}
+
+func TestEngineFlows(t *testing.T) {
+	for _, flow := range engineFlows {
+		t.Run(flow.name, func(t *testing.T) {
+			flow.run(t, newTestEngine(t))
+		})
+	}
+}
+
+func TestEngineGasLimitTarget(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.GasLimits.Target = 30_050_000
+	})
+	genesis := te.mockChain().CurrentHeader()
+	require.Equal(t, uint64(30_000_000), genesis.GasLimit)
+
+	// The gas limit moves by at most 1/1024 of the parent gas limit per block, until it reaches the target.
+	first := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, uint64(30_000_000+30_000_000/1024-1), first.GasLimit)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, first))
+	second := te.buildPayload(t, first.BlockHash, first.Timestamp+12, common.Hash{0x02})
+	require.Equal(t, uint64(30_050_000), second.GasLimit)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, second))
+
+	// mock_setGasLimit changes the target.
+	require.NoError(t, te.client.CallContext(context.Background(), nil, "mock_setGasLimit", hexutil.Uint64(20_000_000)))
+	third := te.buildPayload(t, second.BlockHash, second.Timestamp+12, common.Hash{0x03})
+	require.Equal(t, second.GasLimit-(second.GasLimit/1024-1), third.GasLimit)
+}
+
+// newForkGenesis returns a genesis activating the timestamp based forks, e.g. shanghaiTime, at the given
+// offsets from the genesis time.
+func newForkGenesis(t *testing.T, offsets map[string]uint64) string {
+	path := newGenesis(t)
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var genesis map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &genesis))
+	genesisTime, err := hexutil.DecodeUint64(genesis["timestamp"].(string))
+	require.NoError(t, err)
+	for fork, offset := range offsets {
+		genesis["config"].(map[string]interface{})[fork] = genesisTime + offset
+	}
+	buf, err = json.Marshal(genesis)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+	return path
+}
+
+func (te *testEngine) buildPayloadV2(t *testing.T, parent common.Hash, timestamp uint64, withdrawals []*types.Withdrawal) (types.PayloadID, *types.ExecutionPayloadEnvelopeV2, error) {
+	ctx := context.Background()
+	attributes := &types.PayloadAttributesV2{
+		Timestamp:             timestamp,
+		PrevRandao:            common.Hash{0x01},
+		SuggestedFeeRecipient: common.Address{0x02},
+		Withdrawals:           withdrawals,
+	}
+	result, err := api.ForkchoiceUpdatedV2(ctx, te.client, te.log, parent, parent, parent, attributes)
+	if err != nil {
+		return types.PayloadID{}, nil, err
+	}
+	require.NotNil(t, result.PayloadID)
+	envelope, err := api.GetPayloadV2(ctx, te.client, te.log, *result.PayloadID)
+	return *result.PayloadID, envelope, err
+}
+
+func (te *testEngine) balance(t *testing.T, specHash common.Hash, addr common.Address) *big.Int {
+	mc := te.mockChain()
+	header := mc.chain.GetHeaderByHash(mc.ResolveHash(specHash))
+	require.NotNil(t, header, "block %s not known to the engine", specHash)
+	statedb, err := mc.chain.StateAt(header.Root)
+	require.NoError(t, err)
+	return statedb.GetBalance(addr)
+}
+
+func TestEngineWithdrawals(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 24})
+	te := newTestEngineWithGenesis(t, genesisPath)
+	genesis := te.mockChain().CurrentHeader()
+	recipient := common.Address{0xaa}
+	withdrawals := []*types.Withdrawal{
+		{Index: 0, Validator: 1, Address: recipient, Amount: 32},
+		{Index: 1, Validator: 2, Address: recipient, Amount: 10},
+	}
+
+	// Before Shanghai withdrawals are rejected, and the payload is a V1 payload.
+	_, _, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, withdrawals)
+	code, ok := api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.InvalidPayloadAttributes, code)
+	_, preShanghai, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, nil)
+	require.NoError(t, err)
+	require.Nil(t, preShanghai.ExecutionPayload.Withdrawals)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, preShanghai.ExecutionPayload.PayloadV1()))
+
+	// From Shanghai on they are required, and credited in Gwei.
+	parent := preShanghai.ExecutionPayload
+	_, _, err = te.buildPayloadV2(t, parent.BlockHash, parent.Timestamp+12, nil)
+	code, ok = api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.InvalidPayloadAttributes, code)
+	id, envelope, err := te.buildPayloadV2(t, parent.BlockHash, parent.Timestamp+12, withdrawals)
+	require.NoError(t, err)
+	shanghai := envelope.ExecutionPayload
+	require.Equal(t, withdrawals, shanghai.Withdrawals)
+	require.True(t, shanghai.ValidateHash())
+	require.Zero(t, envelope.BlockValue.ToInt().Sign())
+	_, err = api.GetPayloadV1(context.Background(), te.client, te.log, id)
+	require.Error(t, err, "payloads with withdrawals are not served over V1")
+
+	status, err := api.NewPayloadV2(context.Background(), te.client, te.log, shanghai)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	require.Equal(t, new(big.Int).Mul(big.NewInt(42), big.NewInt(params.GWei)), te.balance(t, shanghai.BlockHash, recipient))
+
+	// Children refer to the parent by its spec hash, which geth doesn't know.
+	_, envelope, err = te.buildPayloadV2(t, shanghai.BlockHash, shanghai.Timestamp+12, []*types.Withdrawal{})
+	require.NoError(t, err)
+	child := envelope.ExecutionPayload
+	require.Equal(t, shanghai.BlockHash, child.ParentHash)
+	status, err = api.NewPayloadV2(context.Background(), te.client, te.log, child)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+
+	// Another engine arrives at the same blocks from the payloads alone.
+	other := newTestEngineWithGenesis(t, genesisPath)
+	for _, payload := range []*types.ExecutionPayloadV2{preShanghai.ExecutionPayload, shanghai, child} {
+		status, err := api.NewPayloadV2(context.Background(), other.client, other.log, payload)
+		require.NoError(t, err)
+		require.Equal(t, types.ExecutionValid, status.Status)
+	}
+	require.Equal(t, te.balance(t, child.BlockHash, recipient), other.balance(t, child.BlockHash, recipient))
+
+	// Tampering with the withdrawals changes the block hash.
+	tampered := *shanghai
+	tampered.Withdrawals = withdrawals[:1]
+	status, err = api.NewPayloadV2(context.Background(), other.client, other.log, &tampered)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status.Status)
+}
+
+func TestEngineCancun(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 24})
+	te := newTestEngineWithGenesis(t, genesisPath)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	requireCode := func(err error, expected api.ErrorCode) {
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, expected, code)
+	}
+	attributes := func(timestamp uint64, root common.Hash) *types.PayloadAttributesV3 {
+		return &types.PayloadAttributesV3{
+			Timestamp:             timestamp,
+			SuggestedFeeRecipient: common.Address{0x02},
+			Withdrawals:           []*types.Withdrawal{{Index: 0, Validator: 1, Address: common.Address{0xaa}, Amount: 1}},
+			ParentBeaconBlockRoot: root,
+		}
+	}
+
+	// V3 is only served from Cancun on, and V2 only before.
+	_, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes(genesis.Time+12, common.Hash{0x01}))
+	requireCode(err, api.UnsupportedFork)
+	_, shanghai, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, []*types.Withdrawal{})
+	require.NoError(t, err)
+	status, err := api.NewPayloadV2(ctx, te.client, te.log, shanghai.ExecutionPayload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	parent := shanghai.ExecutionPayload.BlockHash
+	_, _, err = te.buildPayloadV2(t, parent, genesis.Time+24, []*types.Withdrawal{})
+	requireCode(err, api.UnsupportedFork)
+
+	root := common.Hash{0xbe}
+	result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes(genesis.Time+24, root))
+	require.NoError(t, err)
+	require.NotNil(t, result.PayloadID)
+	_, err = api.GetPayloadV2(ctx, te.client, te.log, *result.PayloadID)
+	requireCode(err, api.UnsupportedFork)
+	envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Empty(t, envelope.BlobsBundle.Blobs)
+	cancun := envelope.ExecutionPayload
+	require.Equal(t, parent, cancun.ParentHash)
+	require.Zero(t, cancun.BlobGasUsed)
+	require.Zero(t, cancun.ExcessBlobGas)
+	require.True(t, cancun.ValidateHash(root))
+
+	// The parent beacon block root is committed to by the block hash.
+	status, err = api.NewPayloadV3(ctx, te.client, te.log, cancun, []common.Hash{}, common.Hash{0xff})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status.Status)
+	// Without blob transactions there can't be any versioned hashes.
+	status, err = api.NewPayloadV3(ctx, te.client, te.log, cancun, []common.Hash{{0x01}}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalid, status.Status)
+	// A wrong excess blob gas is caught, after the hash, invalidating the payload on top of its parent.
+	tampered := *cancun
+	tampered.ExcessBlobGas = 1
+	tampered.BlockHash, err = tampered.ComputeHash(root)
+	require.NoError(t, err)
+	status, err = api.NewPayloadV3(ctx, te.client, te.log, &tampered, []common.Hash{}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalid, status.Status)
+	require.Equal(t, cancun.ParentHash, *status.LatestValidHash)
+	require.Contains(t, status.ValidationError, "excess blob gas")
+	status, err = api.NewPayloadV3(ctx, te.client, te.log, cancun, []common.Hash{}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+
+	// Children build on the spec hash, and another engine follows from the payloads alone.
+	result, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, cancun.BlockHash, parent, parent, attributes(genesis.Time+36, common.Hash{0xbf}))
+	require.NoError(t, err)
+	envelope, err = api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	child := envelope.ExecutionPayload
+	require.Equal(t, cancun.BlockHash, child.ParentHash)
+
+	other := newTestEngineWithGenesis(t, genesisPath)
+	status, err = api.NewPayloadV2(ctx, other.client, other.log, shanghai.ExecutionPayload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	status, err = api.NewPayloadV3(ctx, other.client, other.log, cancun, []common.Hash{}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	status, err = api.NewPayloadV3(ctx, other.client, other.log, child, []common.Hash{}, common.Hash{0xbf})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+}
+
+func TestEnginePrague(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0, "pragueTime": 24})
+	te := newTestEngineWithGenesis(t, genesisPath)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	requireCode := func(err error, expected api.ErrorCode) {
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, expected, code)
+	}
+	attributes := func(timestamp uint64, root common.Hash) *types.PayloadAttributesV3 {
+		return &types.PayloadAttributesV3{Timestamp: timestamp, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+	}
+
+	// V4 is only served from Prague on, and V3 only before.
+	result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes(genesis.Time+12, common.Hash{0x01}))
+	require.NoError(t, err)
+	_, err = api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+	requireCode(err, api.UnsupportedFork)
+	envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	cancun := envelope.ExecutionPayload
+	status, err := api.NewPayloadV3(ctx, te.client, te.log, cancun, []common.Hash{}, common.Hash{0x01})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+
+	root := common.Hash{0xbe}
+	result, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, cancun.BlockHash, genesis.Hash(), genesis.Hash(), attributes(genesis.Time+24, root))
+	require.NoError(t, err)
+	_, err = api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+	requireCode(err, api.UnsupportedFork)
+	prague, err := api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.NotNil(t, prague.ExecutionRequests)
+	require.Empty(t, prague.ExecutionRequests)
+	payload := prague.ExecutionPayload
+	require.True(t, payload.ValidateHashV4(root, prague.ExecutionRequests))
+	_, err = api.NewPayloadV3(ctx, te.client, te.log, payload, []common.Hash{}, root)
+	requireCode(err, api.UnsupportedFork)
+
+	// Malformed requests are rejected, and the requests hash is committed to by the block hash.
+	_, err = api.NewPayloadV4(ctx, te.client, te.log, payload, []common.Hash{}, root, nil)
+	requireCode(err, api.InvalidParams)
+	_, err = api.NewPayloadV4(ctx, te.client, te.log, payload, []common.Hash{}, root, types.ExecutionRequests{{0x01, 0x01}, {0x00, 0x01}})
+	requireCode(err, api.InvalidParams)
+	requests := types.ExecutionRequests{{0x00, 0x01, 0x02}}
+	status, err = api.NewPayloadV4(ctx, te.client, te.log, payload, []common.Hash{}, root, requests)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status.Status)
+	status, err = api.NewPayloadV4(ctx, te.client, te.log, payload, []common.Hash{}, root, prague.ExecutionRequests)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+
+	// Requests are taken as given, as long as the block hash commits to them.
+	other := newTestEngineWithGenesis(t, genesisPath)
+	status, err = api.NewPayloadV3(ctx, other.client, other.log, cancun, []common.Hash{}, common.Hash{0x01})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	withRequests := *payload
+	withRequests.BlockHash, err = withRequests.ComputeHashV4(root, requests)
+	require.NoError(t, err)
+	status, err = api.NewPayloadV4(ctx, other.client, other.log, &withRequests, []common.Hash{}, root, requests)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	fork := other.mockChain().ForkFields(other.mockChain().ResolveHash(withRequests.BlockHash))
+	require.NotNil(t, fork)
+	require.Equal(t, requests.Hash(), *fork.RequestsHash)
+
+	// Requests-hash faults serve requests the block hash doesn't commit to.
+	runCtl(t, te, "fault", "method=engine_getPayloadV4;action=requests-hash;count=1")
+	corrupted, err := api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Len(t, corrupted.ExecutionRequests, 1)
+	require.False(t, corrupted.ExecutionPayload.ValidateHashV4(root, corrupted.ExecutionRequests))
+	status, err = api.NewPayloadV4(ctx, te.client, te.log, corrupted.ExecutionPayload, []common.Hash{}, root, corrupted.ExecutionRequests)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status.Status)
+	prague, err = api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Empty(t, prague.ExecutionRequests)
+}
+
+func TestEngineBlobGas(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0, "pragueTime": 36})
+	te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Forks.Blobs = 5
+	})
+	other := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Forks.Blobs = 5
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+
+	// Blocks claiming another blob count are invalid.
+	fewer := newTestEngineWithGenesis(t, genesisPath)
+	attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + 12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: common.Hash{0x01}}
+	result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	status, err := api.NewPayloadV3(ctx, fewer.client, fewer.log, envelope.ExecutionPayload, []common.Hash{}, common.Hash{0x01})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalid, status.Status)
+	require.Contains(t, status.ValidationError, "blob gas used")
+
+	// The excess builds up above the Cancun target of 3 blobs, and drains below the Prague target of 6. Another
+	// engine with the same blob count follows.
+	parent := genesis.Hash()
+	for i, excess := range []uint64{0, 2, 1, 0} {
+		timestamp := genesis.Time + uint64(i+1)*12
+		root := common.Hash{byte(i + 1)}
+		attributes = &types.PayloadAttributesV3{Timestamp: timestamp, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+		result, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes)
+		require.NoError(t, err)
+		var payload *types.ExecutionPayloadV3
+		if te.mockChain().IsPrague(timestamp) {
+			envelope, err := api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+			require.NoError(t, err)
+			payload = envelope.ExecutionPayload
+			for _, engine := range []*testEngine{te, other} {
+				status, err = api.NewPayloadV4(ctx, engine.client, engine.log, payload, []common.Hash{}, root, envelope.ExecutionRequests)
+				require.NoError(t, err)
+			}
+		} else {
+			envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+			require.NoError(t, err)
+			payload = envelope.ExecutionPayload
+			for _, engine := range []*testEngine{te, other} {
+				status, err = api.NewPayloadV3(ctx, engine.client, engine.log, payload, []common.Hash{}, root)
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, types.ExecutionValid, status.Status, "block %d", i+1)
+		require.Equal(t, uint64(5*types.BlobGasPerBlob), payload.BlobGasUsed, "block %d", i+1)
+		require.Equal(t, excess*types.BlobGasPerBlob, payload.ExcessBlobGas, "block %d", i+1)
+		parent = payload.BlockHash
+	}
+}
+
+func TestEngineBlockHashHistory(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0, "pragueTime": 12})
+	buf, err := os.ReadFile(genesisPath)
+	require.NoError(t, err)
+	var genesisJSON map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &genesisJSON))
+	genesisJSON["alloc"].(map[string]interface{})[historyStorageAddress.Hex()] = map[string]interface{}{"code": "0x00", "balance": "0x0"}
+	buf, err = json.Marshal(genesisJSON)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(genesisPath, buf, 0644))
+	te := newTestEngineWithGenesis(t, genesisPath)
+	other := newTestEngineWithGenesis(t, genesisPath)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+
+	// Prague blocks store the hash of their parent, which another engine reproduces when importing them.
+	parent := genesis.Hash()
+	var hashes []common.Hash
+	for i := 1; i <= 3; i++ {
+		root := common.Hash{byte(i)}
+		attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + uint64(i)*12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+		result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes)
+		require.NoError(t, err)
+		envelope, err := api.GetPayloadV4(ctx, te.client, te.log, *result.PayloadID)
+		require.NoError(t, err)
+		for _, engine := range []*testEngine{te, other} {
+			status, err := api.NewPayloadV4(ctx, engine.client, engine.log, envelope.ExecutionPayload, []common.Hash{}, root, envelope.ExecutionRequests)
+			require.NoError(t, err)
+			require.Equal(t, types.ExecutionValid, status.Status, "block %d", i)
+		}
+		hashes = append(hashes, parent)
+		parent = envelope.ExecutionPayload.BlockHash
+	}
+	_, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, nil)
+	require.NoError(t, err)
+	for i, hash := range hashes {
+		var value hexutil.Bytes
+		require.NoError(t, te.client.CallContext(ctx, &value, "eth_getStorageAt", historyStorageAddress, hexutil.EncodeUint64(uint64(i)), "latest"))
+		require.Equal(t, hash[:], []byte(value), "block %d", i)
+	}
+
+	// Without the contract in the genesis, there is no history to write.
+	plain := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0, "pragueTime": 0}))
+	genesis = plain.mockChain().CurrentHeader()
+	attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + 12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: common.Hash{0x01}}
+	result, err := api.ForkchoiceUpdatedV3(ctx, plain.client, plain.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	envelope, err := api.GetPayloadV4(ctx, plain.client, plain.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, genesis.Root, common.Hash(envelope.ExecutionPayload.StateRoot))
+}
+
+func TestEngineBeaconRoots(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0})
+	buf, err := os.ReadFile(genesisPath)
+	require.NoError(t, err)
+	var genesisJSON map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &genesisJSON))
+	genesisJSON["alloc"].(map[string]interface{})[beaconRootsAddress.Hex()] = map[string]interface{}{"code": "0x00", "balance": "0x0"}
+	buf, err = json.Marshal(genesisJSON)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(genesisPath, buf, 0644))
+	te := newTestEngineWithGenesis(t, genesisPath)
+	other := newTestEngineWithGenesis(t, genesisPath)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+
+	// Cancun blocks store their timestamp and parent beacon block root, which another engine reproduces when
+	// importing them.
+	parent := genesis.Hash()
+	var timestamps []uint64
+	for i := 1; i <= 3; i++ {
+		root := common.Hash{byte(i)}
+		timestamp := genesis.Time + uint64(i)*12
+		attributes := &types.PayloadAttributesV3{Timestamp: timestamp, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+		result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes)
+		require.NoError(t, err)
+		envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+		require.NoError(t, err)
+		for _, engine := range []*testEngine{te, other} {
+			status, err := api.NewPayloadV3(ctx, engine.client, engine.log, envelope.ExecutionPayload, []common.Hash{}, root)
+			require.NoError(t, err)
+			require.Equal(t, types.ExecutionValid, status.Status, "block %d", i)
+		}
+		timestamps = append(timestamps, timestamp)
+		parent = envelope.ExecutionPayload.BlockHash
+	}
+	_, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, nil)
+	require.NoError(t, err)
+	for i, timestamp := range timestamps {
+		var value hexutil.Bytes
+		slot := timestamp % historyBufferLength
+		require.NoError(t, te.client.CallContext(ctx, &value, "eth_getStorageAt", beaconRootsAddress, hexutil.EncodeUint64(slot), "latest"))
+		require.Equal(t, common.BigToHash(new(big.Int).SetUint64(timestamp)).Bytes(), []byte(value), "block %d", i+1)
+		require.NoError(t, te.client.CallContext(ctx, &value, "eth_getStorageAt", beaconRootsAddress, hexutil.EncodeUint64(slot+historyBufferLength), "latest"))
+		require.Equal(t, common.Hash{byte(i + 1)}.Bytes(), []byte(value), "block %d", i+1)
+	}
+
+	// Without the contract in the genesis, there are no roots to write.
+	plain := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0}))
+	genesis = plain.mockChain().CurrentHeader()
+	attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + 12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: common.Hash{0x01}}
+	result, err := api.ForkchoiceUpdatedV3(ctx, plain.client, plain.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	envelope, err := api.GetPayloadV3(ctx, plain.client, plain.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, genesis.Root, common.Hash(envelope.ExecutionPayload.StateRoot))
+}
+
+func TestEngineParentBeaconRoot(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0}))
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	heads := &types.ForkchoiceStateV1{HeadBlockHash: genesis.Hash(), SafeBlockHash: genesis.Hash(), FinalizedBlockHash: genesis.Hash()}
+	requireCode := func(err error, expected api.ErrorCode) {
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, expected, code)
+	}
+	build := func(root common.Hash) *types.ExecutionPayloadV3 {
+		attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + 12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+		result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+		require.NoError(t, err)
+		envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+		require.NoError(t, err)
+		return envelope.ExecutionPayload
+	}
+
+	// The root is required in Cancun attributes and payloads.
+	var result types.ForkchoiceUpdatedResult
+	err := te.client.CallContext(ctx, &result, "engine_forkchoiceUpdatedV3", heads, map[string]interface{}{
+		"timestamp": hexutil.Uint64(genesis.Time + 12), "prevRandao": common.Hash{}, "suggestedFeeRecipient": common.Address{}, "withdrawals": []interface{}{},
+	})
+	requireCode(err, api.InvalidParams)
+	root := common.Hash{0x01}
+	payload := build(root)
+	var status types.PayloadStatusV1
+	requireCode(te.client.CallContext(ctx, &status, "engine_newPayloadV3", payload, []common.Hash{}), api.InvalidParams)
+	requireCode(te.client.CallContext(ctx, &status, "engine_newPayloadV3", payload, []common.Hash{}, nil), api.InvalidParams)
+
+	// The block hash commits to the root, which is kept per block.
+	other, err := api.NewPayloadV3(ctx, te.client, te.log, payload, []common.Hash{}, common.Hash{0x02})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, other.Status)
+	valid, err := api.NewPayloadV3(ctx, te.client, te.log, payload, []common.Hash{}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, valid.Status)
+	var stored *common.Hash
+	require.NoError(t, te.client.CallContext(ctx, &stored, "mock_getParentBeaconBlockRoot", payload.BlockHash))
+	require.Equal(t, &root, stored)
+	require.NoError(t, te.client.CallContext(ctx, &stored, "mock_getParentBeaconBlockRoot", genesis.Hash()))
+	require.Nil(t, stored, "the genesis has no root")
+
+	// A beacon-root fault builds the payload with another root than requested, and imports with another than given.
+	_, err = te.backend.faults.Add(&FaultRule{Method: "engine_forkchoiceUpdatedV3", Action: FaultBeaconRoot, Count: 1})
+	require.NoError(t, err)
+	tampered := build(common.Hash{0x03})
+	status2, err := api.NewPayloadV3(ctx, te.client, te.log, tampered, []common.Hash{}, common.Hash{0x03})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status2.Status)
+	_, err = te.backend.faults.Add(&FaultRule{Method: "engine_newPayloadV3", Action: FaultBeaconRoot, Count: 1})
+	require.NoError(t, err)
+	status2, err = api.NewPayloadV3(ctx, te.client, te.log, payload, []common.Hash{}, root)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidBlockHash, status2.Status)
+	status2, err = api.NewPayloadV3(ctx, te.client, te.log, tampered, []common.Hash{}, malformedHash)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status2.Status, "the payload commits to the root of the fault")
+}
+
+func TestMissingJwtSecretIsGenerated(t *testing.T) {
+	path := fmt.Sprintf("%s/secrets/jwt.hex", t.TempDir())
+	jwt, generated, err := loadJwtSecret(path)
+	require.NoError(t, err)
+	require.True(t, generated)
+	require.Len(t, jwt, 32)
+	loaded, generated, err := loadJwtSecret(path)
+	require.NoError(t, err)
+	require.False(t, generated)
+	require.Equal(t, jwt, loaded)
+}
+
+func TestMissingJwtSecretOfClient(t *testing.T) {
+	path := fmt.Sprintf("%s/secrets/jwt.hex", t.TempDir())
+	_, err := readJwtSecret(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "clients never generate the secret of their server")
+}
+
+func TestExchangeTransitionConfiguration(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesisConfig := &types.TransitionConfigurationV1{TerminalTotalDifficulty: (*hexutil.Big)(common.Big0)}
+
+	result, err := api.ExchangeTransitionConfigurationV1(ctx, te.client, te.log, genesisConfig)
+	require.NoError(t, err)
+	require.Zero(t, result.TerminalTotalDifficulty.ToInt().Sign())
+	require.Equal(t, common.Hash{}, result.TerminalBlockHash)
+
+	_, err = api.ExchangeTransitionConfigurationV1(ctx, te.client, te.log, &types.TransitionConfigurationV1{})
+	require.Error(t, err)
+
+	overrides := TransitionConfigOverrides{TerminalTotalDifficulty: "100", TerminalBlockHash: common.Hash{0x01}.Hex(), TerminalBlockNumber: "5"}
+	require.NoError(t, overrides.Apply(te.backend.transition))
+	result, err = api.ExchangeTransitionConfigurationV1(ctx, te.client, te.log, genesisConfig)
+	require.NoError(t, err)
+	require.Equal(t, "0x64", result.TerminalTotalDifficulty.String())
+	require.Equal(t, common.Hash{0x01}, result.TerminalBlockHash)
+	require.Equal(t, hexutil.Uint64(5), result.TerminalBlockNumber)
+
+	require.Error(t, (&TransitionConfigOverrides{TerminalTotalDifficulty: "0x10"}).Apply(te.backend.transition))
+}
+
+func TestEngineFaults(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	inject := func(rule FaultRule) {
+		var id uint64
+		require.NoError(t, te.client.CallContext(ctx, &id, "mock_injectFault", rule))
+		require.NotZero(t, id)
+	}
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+
+	// A forced status only replaces the response, the payload is still imported.
+	inject(FaultRule{Method: "engine_newPayloadV1", BlockHash: &payload.BlockHash, Count: 1, Action: FaultStatus, Status: types.ExecutionSyncing})
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payload))
+	te.requireKnownBlock(t, payload.BlockHash)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+
+	inject(FaultRule{Method: "engine_forkchoiceUpdatedV1", After: 1, Count: 1, Action: FaultLatestValidHash})
+	for i, expected := range []common.Hash{payload.BlockHash, malformedHash, payload.BlockHash} {
+		result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, genesis.Hash(), genesis.Hash(), nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, *result.PayloadStatus.LatestValidHash, "call %d", i)
+	}
+
+	inject(FaultRule{Method: "engine_getPayloadV1", Action: FaultError, Code: -32603, Message: "overloaded"})
+	_, err := api.GetPayloadV1(ctx, te.client, te.log, types.PayloadID{})
+	code, ok := api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.ErrorCode(-32603), code)
+
+	inject(FaultRule{Method: "engine_exchangeTransitionConfigurationV1", Action: FaultDrop})
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = api.ExchangeTransitionConfigurationV1(timeoutCtx, te.client, te.log, &types.TransitionConfigurationV1{TerminalTotalDifficulty: (*hexutil.Big)(common.Big0)})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var rules []FaultRule
+	require.NoError(t, te.client.CallContext(ctx, &rules, "mock_faults"))
+	require.Len(t, rules, 4)
+	require.Equal(t, uint64(2), rules[0].Matched)
+	require.Equal(t, uint64(1), rules[0].Injected)
+	require.Equal(t, uint64(3), rules[1].Matched)
+	require.NoError(t, te.client.CallContext(ctx, nil, "mock_clearFaults"))
+	require.NoError(t, te.client.CallContext(ctx, &rules, "mock_faults"))
+	require.Empty(t, rules)
+}
+
+func TestEngineDisabledMethods(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.DisabledMethods = []string{"engine_getPayloadV2", "engine_forkchoiceUpdatedV2"}
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	_, err := api.ForkchoiceUpdatedV2(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), nil)
+	code, ok := api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.MethodNotFound, code)
+	_, err = api.GetPayloadV2(ctx, te.client, te.log, types.PayloadID{})
+	code, _ = api.Code(err)
+	require.Equal(t, api.MethodNotFound, code)
+
+	// The older versions are still served, for the consensus client to fall back to.
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+
+	for _, name := range []string{"engine_getPayloadV5", "eth_blockNumber", "getPayloadV1", "engine_"} {
+		_, err := disabledMethods(te.backend, []string{name})
+		require.Error(t, err, name)
+	}
+}
+
+// TestEngineErrorCodes checks the error codes of the specification as they arrive at the consensus client.
+func TestEngineErrorCodes(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 36})
+	te := newTestEngineWithGenesis(t, genesisPath)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	requireCode := func(err error, expected api.ErrorCode) {
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, expected, code)
+	}
+
+	_, err := api.GetPayloadV1(ctx, te.client, te.log, types.PayloadID{0xff})
+	requireCode(err, api.UnknownPayload)
+
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	_, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, common.Hash{0xaa}, genesis.Hash(), nil)
+	requireCode(err, api.InvalidForkchoiceState)
+
+	// The timestamp of a payload has to be past its parent's.
+	attributes := &types.PayloadAttributesV1{Timestamp: payload.Timestamp, PrevRandao: common.Hash{0x02}}
+	_, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, genesis.Hash(), genesis.Hash(), attributes)
+	requireCode(err, api.InvalidPayloadAttributes)
+
+	// Withdrawals are part of the payload structure from Shanghai on only.
+	_, envelope, err := te.buildPayloadV2(t, payload.BlockHash, payload.Timestamp+12, nil)
+	require.NoError(t, err)
+	withdrawn := *envelope.ExecutionPayload
+	withdrawn.Withdrawals = []*types.Withdrawal{}
+	_, err = api.NewPayloadV2(ctx, te.client, te.log, &withdrawn)
+	requireCode(err, api.InvalidParams)
+	late := *envelope.ExecutionPayload
+	late.Timestamp = genesis.Time + 36
+	_, err = api.NewPayloadV2(ctx, te.client, te.log, &late)
+	requireCode(err, api.InvalidParams)
+}
+
+func TestEngineForkTimes(t *testing.T) {
+	ctx := context.Background()
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 24, "cancunTime": 24})
+	buf, _, err := ReadGenesis(genesisPath)
+	require.NoError(t, err)
+	gspec, err := LoadGenesisConfig(buf)
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Forks.Shanghai = fmt.Sprint(gspec.Timestamp + 12)
+		cmd.Forks.Cancun = "none"
+	})
+	genesis := te.mockChain().CurrentHeader()
+	require.Equal(t, ForkParis, te.mockChain().ForkAt(genesis.Time))
+	require.Equal(t, ForkShanghai, te.mockChain().ForkAt(genesis.Time+12))
+
+	// The first payload after the overridden Shanghai time carries withdrawals, and needs V2 methods.
+	_, _, err = te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, nil)
+	code, _ := api.Code(err)
+	require.Equal(t, api.InvalidPayloadAttributes, code)
+	_, envelope, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, []*types.Withdrawal{})
+	require.NoError(t, err)
+	shanghai := envelope.ExecutionPayload
+	_, err = api.NewPayloadV1(ctx, te.client, te.log, shanghai.PayloadV1())
+	code, _ = api.Code(err)
+	require.Equal(t, api.UnsupportedFork, code)
+	status, err := api.NewPayloadV2(ctx, te.client, te.log, shanghai)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+
+	// Cancun of the genesis is deactivated.
+	attributes := &types.PayloadAttributesV3{Timestamp: shanghai.Timestamp + 12, Withdrawals: []*types.Withdrawal{}}
+	_, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, shanghai.BlockHash, shanghai.BlockHash, shanghai.BlockHash, attributes)
+	code, _ = api.Code(err)
+	require.Equal(t, api.UnsupportedFork, code)
+	_, _, err = te.buildPayloadV2(t, shanghai.BlockHash, shanghai.Timestamp+12, []*types.Withdrawal{})
+	require.NoError(t, err)
+
+	var forks forkTimes
+	now := time.Unix(1000, 0)
+	relative := ForkTimesConfig{Prague: "+1m"}
+	require.NoError(t, relative.apply(&forks, now))
+	require.Equal(t, uint64(1060), *forks.PragueTime)
+	require.Error(t, (&ForkTimesConfig{Cancun: "soon"}).apply(&forks, now))
+}
+
+func TestEngineUnknownPayload(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []string{UnknownPayloadUnavailable, UnknownPayloadUnknown, UnknownPayloadEmpty, UnknownPayloadTimeout} {
+		t.Run(mode, func(t *testing.T) {
+			te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+				cmd.UnknownPayload = mode
+			})
+			callCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			payload, err := api.GetPayloadV1(callCtx, te.client, te.log, types.PayloadID{0xff})
+			switch mode {
+			case UnknownPayloadUnavailable, UnknownPayloadUnknown:
+				code, ok := api.Code(err)
+				require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+				if mode == UnknownPayloadUnknown {
+					require.Equal(t, api.UnknownPayload, code)
+				} else {
+					require.Equal(t, api.UnavailablePayload, code)
+				}
+			case UnknownPayloadEmpty:
+				require.NoError(t, err)
+				require.Equal(t, common.Hash{}, payload.BlockHash)
+				require.Empty(t, payload.Transactions)
+				envelope, err := api.GetPayloadV3(ctx, te.client, te.log, types.PayloadID{0xff})
+				require.NoError(t, err)
+				require.NotNil(t, envelope.ExecutionPayload.Withdrawals)
+			case UnknownPayloadTimeout:
+				require.ErrorIs(t, err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestEnginePayloadRetention(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.PayloadRetention = 200 * time.Millisecond
+	})
+	hook := logtest.NewLocal(te.log.(*logrus.Logger))
+	logged := func(message string) bool {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return true
+			}
+		}
+		return false
+	}
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 12}
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	_, err = api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	require.Empty(t, NewMockBackend(te.backend).State(ctx).Payloads, "expired payloads are dropped from the cache")
+	_, err = api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	code, ok := api.Code(err)
+	require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+	require.Equal(t, api.UnknownPayload, code)
+	require.True(t, logged("Cannot get expired payload"))
+	require.False(t, logged("Cannot get unknown payload"))
+
+	_, err = api.GetPayloadV1(ctx, te.client, te.log, types.PayloadID{0xff})
+	require.Error(t, err)
+	require.True(t, logged("Cannot get unknown payload"))
+}
+
+func TestEnginePayloadCacheSize(t *testing.T) {
+	ctx := context.Background()
+	for _, rebuild := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rebuild=%v", rebuild), func(t *testing.T) {
+			te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+				cmd.PayloadCacheSize = 2
+				cmd.RebuildEvicted = rebuild
+			})
+			genesis := te.mockChain().CurrentHeader()
+			var ids []types.PayloadID
+			for i := uint64(1); i <= 3; i++ {
+				attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 12*i}
+				result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), attributes)
+				require.NoError(t, err)
+				ids = append(ids, *result.PayloadID)
+			}
+			require.Len(t, NewMockBackend(te.backend).State(ctx).Payloads, 2)
+			require.Equal(t, uint64(1), NewMockBackend(te.backend).Stats(ctx).PayloadCache.Evicted)
+
+			payload, err := api.GetPayloadV1(ctx, te.client, te.log, ids[0])
+			stats := NewMockBackend(te.backend).Stats(ctx).PayloadCache
+			if !rebuild {
+				code, ok := api.Code(err)
+				require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+				require.Equal(t, api.UnknownPayload, code)
+				require.Zero(t, stats.Rebuilt)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, genesis.Hash(), payload.ParentHash)
+			require.Equal(t, genesis.Time+12, payload.Timestamp)
+			require.Equal(t, uint64(1), stats.Rebuilt)
+			// the rebuilt payload is cached again, evicting the least recently used one
+			require.Equal(t, uint64(2), stats.Evicted)
+			_, err = api.GetPayloadV1(ctx, te.client, te.log, ids[0])
+			require.NoError(t, err)
+		})
+	}
+}
+
+// newPowGenesis writes a genesis of difficulty 1 whose terminal total difficulty is only reached by mining.
+func newPowGenesis(t *testing.T, ttd uint64) string {
+	path := newGenesis(t)
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var genesis map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &genesis))
+	genesis["config"].(map[string]interface{})["terminalTotalDifficulty"] = ttd
+	genesis["difficulty"] = "0x1"
+	buf, err = json.Marshal(genesis)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+	return path
+}
+
+func TestEngineProofOfWorkPrefix(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newPowGenesis(t, 10), func(cmd *EngineCmd) { cmd.PowDifficulty = 3 })
+	ctx := context.Background()
+	var stats Stats
+	require.NoError(t, te.client.CallContext(ctx, &stats, "mock_stats"))
+	require.True(t, stats.TTDReached)
+	blockAt := func(number gethRpc.BlockNumber) map[string]interface{} {
+		var block map[string]interface{}
+		require.NoError(t, te.client.CallContext(ctx, &block, "eth_getBlockByNumber", number, false))
+		return block
+	}
+	// Total difficulty goes 1, 4, 7, 10: the third block is the terminal block.
+	terminal := blockAt(gethRpc.LatestBlockNumber)
+	require.Equal(t, "0x3", terminal["number"])
+	require.Equal(t, "0x3", terminal["difficulty"])
+	require.Equal(t, "0xa", terminal["totalDifficulty"])
+	require.Equal(t, "0x7", blockAt(2)["totalDifficulty"])
+	require.Nil(t, blockAt(4))
+	var unknown map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &unknown, "eth_getBlockByHash", common.Hash{0xff}, false))
+	require.Nil(t, unknown)
+
+	// Only the terminal block can be built on.
+	mc := te.mockChain()
+	beforeTerminal := mc.chain.GetHeaderByNumber(2)
+	payload, err := api.BlockToPayload(mustBuildBlock(t, mc, beforeTerminal))
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidTerminalBlock, te.newPayload(t, payload))
+
+	head := mc.CurrentHeader()
+	require.True(t, mc.IsTerminalBlock(head))
+	valid := te.buildPayload(t, head.Hash(), head.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, valid))
+}
+
+func TestEngineLiveProofOfWork(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newPowGenesis(t, 100), func(cmd *EngineCmd) {
+		cmd.Forks.TTD = "0x7"
+		cmd.PowDifficulty = 3
+		cmd.PowBlockTime = 300 * time.Millisecond
+	})
+	ctx := context.Background()
+	mc := te.mockChain()
+	require.Equal(t, int64(7), te.backend.transition.TerminalTotalDifficulty.ToInt().Int64(), "the override is reported")
+
+	// Before the terminal total difficulty, no block can be built on.
+	genesis := mc.chain.GetHeaderByNumber(0)
+	payload, err := api.BlockToPayload(mustBuildBlock(t, mc, genesis))
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalidTerminalBlock, te.newPayload(t, payload))
+
+	// Total difficulty goes 1, 4, 7 while serving: the second block is the terminal block.
+	require.Eventually(t, mc.TTDReached, 5*time.Second, 50*time.Millisecond)
+	var terminal map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &terminal, "eth_getBlockByNumber", gethRpc.LatestBlockNumber, false))
+	require.Equal(t, "0x2", terminal["number"])
+	require.Equal(t, "0x7", terminal["totalDifficulty"])
+	time.Sleep(400 * time.Millisecond)
+	head := mc.CurrentHeader()
+	require.Equal(t, uint64(2), head.Number.Uint64(), "mining stops at the terminal block")
+	valid := te.buildPayload(t, head.Hash(), head.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, valid))
+}
+
+func TestForkTTDOverride(t *testing.T) {
+	config := &params.ChainConfig{TerminalTotalDifficulty: common.Big0}
+	require.NoError(t, (&ForkTimesConfig{}).applyTTD(config))
+	require.Zero(t, config.TerminalTotalDifficulty.Sign())
+	require.NoError(t, (&ForkTimesConfig{TTD: "100"}).applyTTD(config))
+	require.Equal(t, int64(100), config.TerminalTotalDifficulty.Int64())
+	require.NoError(t, (&ForkTimesConfig{TTD: "0x10"}).applyTTD(config))
+	require.Equal(t, int64(16), config.TerminalTotalDifficulty.Int64())
+	require.Error(t, (&ForkTimesConfig{TTD: "lots"}).applyTTD(config))
+}
+
+func mustBuildBlock(t *testing.T, mc *MockChain, parent *ethTypes.Header) *ethTypes.Block {
+	block, err := mc.AddNewBlock(parent.Hash(), common.Address{0x02}, parent.Time+12, parent.GasLimit, TransactionsCreator{nil, dummyTxCreator}, common.Hash{}, nil, nil, nil, false)
+	require.NoError(t, err)
+	return block
+}
+
+func TestEngineTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeline.json")
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Timeline.Path = path
+		cmd.Timeline.SlotTime = time.Hour
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	b := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+	var id uint64
+	require.NoError(t, te.client.CallContext(ctx, &id, "mock_injectFault", FaultRule{Method: "engine_newPayloadV1", Action: FaultStatus, Status: types.ExecutionSyncing}))
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, b))
+
+	// Moving the head from a to its sibling b reorgs a out.
+	for _, head := range []common.Hash{a.BlockHash, b.BlockHash} {
+		_, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, head, genesis.Hash(), genesis.Hash(), nil)
+		require.NoError(t, err)
+	}
+
+	slots := te.backend.timeline.Slots()
+	require.Len(t, slots, 1)
+	slot := slots[0]
+	require.Equal(t, uint64(4), slot.Calls["engine_forkchoiceUpdatedV1"])
+	require.Equal(t, uint64(2), slot.Calls["engine_newPayloadV1"])
+	require.Equal(t, []common.Hash{a.BlockHash, b.BlockHash}, slot.BlocksBuilt)
+	require.Equal(t, []TimelineFault{{Rule: id, Method: "engine_newPayloadV1", Action: FaultStatus}}, slot.Faults)
+	require.Equal(t, []TimelineReorg{{OldHead: a.BlockHash, NewHead: b.BlockHash, Depth: 1}}, slot.Reorgs)
+
+	require.NoError(t, te.backend.timeline.Write(path))
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var exported struct {
+		Slots []TimelineSlot `json:"slots"`
+	}
+	require.NoError(t, json.Unmarshal(buf, &exported))
+	require.Equal(t, slots, exported.Slots)
+}
+
+func TestEngineStatsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.StatsSnapshot = path
+		cmd.Faults.Rules = []string{"method=engine_newPayloadV1;action=status;status=SYNCING"}
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payload))
+	require.NoError(t, te.Close())
+	te.close = nil // closed already, the cleanup only writes the snapshot again
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var snapshot StatsSnapshot
+	require.NoError(t, json.Unmarshal(buf, &snapshot))
+	require.Equal(t, uint64(1), snapshot.Stats.PayloadIDs)
+	require.Equal(t, 1, snapshot.Stats.Faults)
+	require.Len(t, snapshot.Faults, 1)
+	require.Equal(t, uint64(1), snapshot.Faults[0].Injected)
+}
+
+func TestEngineAutoReorg(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.AutoReorg = AutoReorgConfig{Every: 2, Depth: 1}
+	})
+	parent := te.mockChain().CurrentHeader()
+	parentHash, timestamp := parent.Hash(), parent.Time
+	for i := 0; i < 2; i++ {
+		timestamp += 12
+		payload := te.buildPayload(t, parentHash, timestamp, common.Hash{0x01})
+		require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+		parentHash = payload.BlockHash
+	}
+	// The head, the first payload the second is built on, is replaced by a fork of two blocks on genesis.
+	head := te.mockChain().CurrentHeader()
+	require.Equal(t, uint64(2), head.Number.Uint64())
+	require.True(t, bytes.HasPrefix(head.Extra, forkExtraData), "head is not a fork block")
+	te.requireKnownBlock(t, parentHash)
+}
+
+func TestEngineSyncSimulation(t *testing.T) {
+	ctx := context.Background()
+	genesisPath := newGenesis(t)
+	// Payloads are built on another engine, as a syncing engine doesn't build any.
+	other := newTestEngineWithGenesis(t, genesisPath)
+	te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Sync.Blocks = 3
+	})
+	parent := other.mockChain().CurrentHeader()
+	parentHash, timestamp := parent.Hash(), parent.Time
+	var payloads []*types.ExecutionPayloadV1
+	for i := 0; i < 4; i++ {
+		timestamp += 12
+		payload := other.buildPayload(t, parentHash, timestamp, common.Hash{byte(i)})
+		require.Equal(t, types.ExecutionValid, other.newPayload(t, payload))
+		payloads = append(payloads, payload)
+		parentHash = payload.BlockHash
+	}
+
+	var progress interface{}
+	require.NoError(t, te.client.CallContext(ctx, &progress, "eth_syncing"))
+	require.Equal(t, map[string]interface{}{"startingBlock": "0x0", "currentBlock": "0x0", "highestBlock": "0x0"}, progress)
+
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payloads[0]))
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payloads[0].BlockHash, parent.Hash(), parent.Hash(), &types.PayloadAttributesV1{Timestamp: timestamp + 12})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionSyncing, result.PayloadStatus.Status)
+	require.Nil(t, result.PayloadID)
+
+	// The payloads are imported while syncing, the current block follows the progress.
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payloads[1]))
+	te.requireKnownBlock(t, payloads[1].BlockHash)
+	require.NoError(t, te.client.CallContext(ctx, &progress, "eth_syncing"))
+	require.Equal(t, map[string]interface{}{"startingBlock": "0x0", "currentBlock": "0x1", "highestBlock": "0x2"}, progress)
+
+	// Caught up after the third payload.
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, payloads[2]))
+	require.NoError(t, te.client.CallContext(ctx, &progress, "eth_syncing"))
+	require.Equal(t, false, progress)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payloads[3]))
+}
+
+func TestEngineForkchoiceState(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	b := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, b))
+	require.Equal(t, genesis.Hash(), te.mockChain().CurrentHeader().Hash(), "imported payloads don't become the head")
+
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, a.BlockHash, genesis.Hash(), common.Hash{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+	var latest map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &latest, "eth_getBlockByNumber", "latest", false))
+	require.Equal(t, a.BlockHash.Hex(), latest["hash"])
+
+	// Heads of another branch reorg the chain.
+	te.setHead(t, b.BlockHash)
+	require.Equal(t, b.BlockHash, te.mockChain().CurrentHeader().Hash())
+
+	// Unknown heads are synced to, without building on them.
+	result, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, common.Hash{0xff}, genesis.Hash(), genesis.Hash(), &types.PayloadAttributesV1{Timestamp: b.Timestamp + 12})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionSyncing, result.PayloadStatus.Status)
+	require.Nil(t, result.PayloadID)
+
+	// Safe and finalized blocks have to be known ancestors of the head.
+	for _, heads := range [][2]common.Hash{{common.Hash{0xff}, genesis.Hash()}, {a.BlockHash, genesis.Hash()}, {b.BlockHash, a.BlockHash}} {
+		_, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, b.BlockHash, heads[0], heads[1], nil)
+		code, ok := api.Code(err)
+		require.True(t, ok, fmt.Sprintf("expected error code, got %v", err))
+		require.Equal(t, api.InvalidForkchoiceState, code)
+	}
+	require.Equal(t, b.BlockHash, te.mockChain().CurrentHeader().Hash())
+
+	// Updates to canonical ancestors of the head are skipped, without building on them.
+	result, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, genesis.Hash(), genesis.Hash(), genesis.Hash(), &types.PayloadAttributesV1{Timestamp: genesis.Time + 12})
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, result.PayloadStatus.Status)
+	require.Nil(t, result.PayloadID)
+	require.Equal(t, b.BlockHash, te.mockChain().CurrentHeader().Hash())
+}
+
+func TestEngineOptimisticImports(t *testing.T) {
+	ctx := context.Background()
+	genesisPath := newGenesis(t)
+	// Payloads are built on another engine, as the engine doesn't build on heads with pending verdicts.
+	other := newTestEngineWithGenesis(t, genesisPath)
+	te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Optimistic.Enable = true
+	})
+	genesis := other.mockChain().CurrentHeader()
+	parentHash, timestamp := genesis.Hash(), genesis.Time
+	var payloads []*types.ExecutionPayloadV1
+	for i := 0; i < 4; i++ {
+		timestamp += 12
+		payload := other.buildPayload(t, parentHash, timestamp, common.Hash{byte(i)})
+		require.Equal(t, types.ExecutionValid, other.newPayload(t, payload))
+		payloads = append(payloads, payload)
+		parentHash = payload.BlockHash
+	}
+	forkchoice := func(head common.Hash) types.PayloadStatusV1 {
+		result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, head, genesis.Hash(), genesis.Hash(), &types.PayloadAttributesV1{Timestamp: timestamp + 12})
+		require.NoError(t, err)
+		if result.PayloadStatus.Status != types.ExecutionValid {
+			require.Nil(t, result.PayloadID)
+		}
+		return result.PayloadStatus
+	}
+
+	for _, payload := range payloads[:3] {
+		require.Equal(t, types.ExecutionAccepted, te.newPayload(t, payload))
+	}
+	require.Equal(t, types.ExecutionSyncing, forkchoice(payloads[2].BlockHash).Status)
+
+	// Invalidating the second block validates the first, and invalidates the third.
+	var settled []SettledBlock
+	require.NoError(t, json.Unmarshal(runCtl(t, te, "invalidate", payloads[1].BlockHash.String()), &settled))
+	lvh := payloads[0].BlockHash
+	require.Equal(t, []SettledBlock{
+		{BlockHash: payloads[0].BlockHash, Status: types.ExecutionValid},
+		{BlockHash: payloads[1].BlockHash, Status: types.ExecutionInvalid, LatestValidHash: &lvh},
+		{BlockHash: payloads[2].BlockHash, Status: types.ExecutionInvalid, LatestValidHash: &lvh},
+	}, settled)
+	status := forkchoice(payloads[2].BlockHash)
+	require.Equal(t, types.ExecutionInvalid, status.Status)
+	require.Equal(t, &lvh, status.LatestValidHash)
+	result, err := api.NewPayloadV1(ctx, te.client, te.log, payloads[3])
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionInvalid, result.Status)
+	require.Equal(t, &lvh, result.LatestValidHash)
+	require.Equal(t, types.ExecutionValid, forkchoice(payloads[0].BlockHash).Status)
+
+	cmd := &CtlCmd{JwtSecretPath: te.JwtSecretPath, out: new(bytes.Buffer)}
+	cmd.Default()
+	require.Error(t, cmd.Run(ctx, "http://"+te.ListenAddr, "validate", payloads[0].BlockHash.String()), "already settled")
+}
+
+func TestEngineOptimisticImportsDelay(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Optimistic = OptimisticConfig{Enable: true, Delay: 100 * time.Millisecond}
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionAccepted, te.newPayload(t, payload))
+	time.Sleep(150 * time.Millisecond)
+	child := te.buildPayload(t, payload.BlockHash, payload.Timestamp+12, common.Hash{0x02})
+	require.Equal(t, payload.BlockHash, child.ParentHash)
+}
+
+func TestEngineRestart(t *testing.T) {
+	ctx := context.Background()
+	genesisPath, dataDir := newGenesis(t), t.TempDir()
+	withDataDir := func(cmd *EngineCmd) { cmd.DataDir = dataDir }
+	te := newTestEngineWithGenesis(t, genesisPath, withDataDir)
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, genesis.Hash(), genesis.Hash(), &types.PayloadAttributesV1{Timestamp: payload.Timestamp + 12})
+	require.NoError(t, err)
+	require.NotNil(t, result.PayloadID)
+	require.NoError(t, te.Close())
+	te.close, te.backend = nil, nil
+
+	restarted := newTestEngineWithGenesis(t, genesisPath, withDataDir)
+	require.Equal(t, payload.BlockHash, restarted.mockChain().CurrentHeader().Hash())
+	state := NewMockBackend(restarted.backend).State(ctx)
+	require.Equal(t, genesis.Hash(), state.Safe, "the forkchoice state is restored")
+	pending, err := api.GetPayloadV1(ctx, restarted.client, restarted.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, payload.BlockHash, pending.ParentHash)
+	child := restarted.buildPayload(t, payload.BlockHash, payload.Timestamp+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, restarted.newPayload(t, child))
+	require.Equal(t, uint64(3), NewMockBackend(restarted.backend).Stats(ctx).PayloadIDs, "payload ids continue after a restart")
+}
+
+func TestEngineIPC(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "engine.ipc")
+	require.NoError(t, os.WriteFile(path, nil, 0600), "a stale socket file is replaced")
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) { cmd.IPCPath = path })
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The same API is served without authentication.
+	client, err := gethRpc.DialIPC(ctx, path)
+	require.NoError(t, err)
+	defer client.Close()
+	var number hexutil.Uint64
+	require.NoError(t, client.CallContext(ctx, &number, "eth_blockNumber"))
+	require.Equal(t, te.mockChain().CurrentHeader().Number.Uint64(), uint64(number))
+	var state EngineState
+	require.NoError(t, client.CallContext(ctx, &state, "mock_state"))
+	require.Equal(t, te.mockChain().CurrentHeader().Hash(), state.Head)
+
+	require.NoError(t, te.Close())
+	te.close, te.backend = nil, nil
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond, "the socket is removed on shutdown")
+}
+
+func TestEngineJwtRotation(t *testing.T) {
+	ctx := context.Background()
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) { cmd.Jwt.HTTP = true })
+	var secret hexutil.Bytes
+	require.NoError(t, te.client.CallContext(ctx, &secret, "mock_rotateJwtSecret"))
+	require.Len(t, secret, 32)
+	var number hexutil.Uint64
+	require.Error(t, te.client.CallContext(ctx, &number, "eth_blockNumber"), "the previous secret is rejected")
+
+	client, err := rpc.DialContext(ctx, "http://"+te.ListenAddr, secret)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.CallContext(ctx, &number, "eth_blockNumber"))
+	require.Error(t, client.CallContext(ctx, nil, "mock_rotateJwtSecret", hexutil.Bytes{0x01}))
+}
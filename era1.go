@@ -0,0 +1,277 @@
+package mergemock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	mmTypes "mergemock/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+)
+
+// Entry types of e2store files, and the era1 layout of their entries: a version, the header, body, receipts and
+// total difficulty of every block, the accumulator of the block hashes and total difficulties, and the index of
+// the blocks.
+const (
+	e2Version           = 0x3265
+	e2CompressedHeader  = 0x03
+	e2CompressedBody    = 0x04
+	e2CompressedReceipt = 0x05
+	e2TotalDifficulty   = 0x06
+	e2Accumulator       = 0x07
+	e2BlockIndex        = 0x3266
+
+	e2HeaderSize = 8
+	// era1MaxBlocks is the number of blocks of an era1 archive, and the limit of its accumulator list.
+	era1MaxBlocks = 8192
+)
+
+type e2Writer struct {
+	w   io.Writer
+	pos int64
+}
+
+func (e *e2Writer) write(typ uint16, data []byte) (int64, error) {
+	start := e.pos
+	var header [e2HeaderSize]byte
+	binary.LittleEndian.PutUint16(header[0:], typ)
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(data)))
+	if _, err := e.w.Write(header[:]); err != nil {
+		return start, err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return start, err
+	}
+	e.pos += int64(e2HeaderSize + len(data))
+	return start, nil
+}
+
+// writeSnappy writes the data as entry, compressed with the framing format of snappy.
+func (e *e2Writer) writeSnappy(typ uint16, data []byte) (int64, error) {
+	var buf bytes.Buffer
+	sw := snappy.NewBufferedWriter(&buf)
+	if _, err := sw.Write(data); err != nil {
+		return e.pos, err
+	}
+	if err := sw.Close(); err != nil {
+		return e.pos, err
+	}
+	return e.write(typ, buf.Bytes())
+}
+
+type e2Entry struct {
+	typ  uint16
+	data []byte
+}
+
+func readE2Entries(data []byte) ([]e2Entry, error) {
+	var entries []e2Entry
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < e2HeaderSize {
+			return nil, fmt.Errorf("truncated entry header at offset %d", pos)
+		}
+		typ := binary.LittleEndian.Uint16(data[pos:])
+		length := int(binary.LittleEndian.Uint32(data[pos+2:]))
+		if reserved := binary.LittleEndian.Uint16(data[pos+6:]); reserved != 0 {
+			return nil, fmt.Errorf("reserved bytes of the entry at offset %d are not zero", pos)
+		}
+		pos += e2HeaderSize
+		if len(data)-pos < length {
+			return nil, fmt.Errorf("truncated entry at offset %d", pos-e2HeaderSize)
+		}
+		entries = append(entries, e2Entry{typ, data[pos : pos+length]})
+		pos += length
+	}
+	return entries, nil
+}
+
+func readSnappy(data []byte) ([]byte, error) {
+	return io.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+}
+
+// uint256LE encodes the number little-endian in 32 bytes, as SSZ does.
+func uint256LE(v *big.Int) [32]byte {
+	var out [32]byte
+	b := v.Bytes()
+	for i := 0; i < len(b) && i < 32; i++ {
+		out[i] = b[len(b)-1-i]
+	}
+	return out
+}
+
+// era1Accumulator returns the hash tree root of the SSZ list of header records, the block hashes with their total
+// difficulties, with the list limit of an era1 archive.
+func era1Accumulator(hashes []common.Hash, tds []*big.Int) common.Hash {
+	layer := make([][32]byte, len(hashes))
+	for i := range hashes {
+		td := uint256LE(tds[i])
+		layer[i] = sha256.Sum256(append(hashes[i].Bytes(), td[:]...))
+	}
+	var zero [32]byte
+	for depth := 0; (1 << depth) < era1MaxBlocks; depth++ {
+		if len(layer)%2 == 1 {
+			layer = append(layer, zero)
+		}
+		next := make([][32]byte, 0, (len(layer)+1)/2)
+		for i := 0; i < len(layer); i += 2 {
+			next = append(next, sha256.Sum256(append(layer[i][:], layer[i+1][:]...)))
+		}
+		if len(next) == 0 {
+			next = append(next, sha256.Sum256(append(zero[:], zero[:]...)))
+		}
+		layer = next
+		zero = sha256.Sum256(append(zero[:], zero[:]...))
+	}
+	length := uint256LE(new(big.Int).SetInt64(int64(len(hashes))))
+	return sha256.Sum256(append(layer[0][:], length[:]...))
+}
+
+// ExportEra1 writes the canonical blocks from first to last as era1 archive, with their receipts and total
+// difficulties. An archive holds at most 8192 blocks. It returns the number of blocks written.
+func (c *MockChain) ExportEra1(w io.Writer, first, last uint64) (int, error) {
+	if last < first {
+		return 0, fmt.Errorf("no blocks to export from %d to %d", first, last)
+	}
+	if count := last - first + 1; count > era1MaxBlocks {
+		return 0, fmt.Errorf("era1 archives hold at most %d blocks, %d to export", era1MaxBlocks, count)
+	}
+	e := &e2Writer{w: w}
+	if _, err := e.write(e2Version, nil); err != nil {
+		return 0, err
+	}
+	var offsets []int64
+	var hashes []common.Hash
+	var tds []*big.Int
+	for n := first; n <= last; n++ {
+		b, block, err := c.specBlock(n)
+		if err != nil {
+			return len(offsets), err
+		}
+		if b == nil {
+			return len(offsets), fmt.Errorf("no canonical block %d", n)
+		}
+		body, err := rlp.EncodeToBytes(&specBody{b.Txs, b.Uncles, b.Withdrawals})
+		if err != nil {
+			return len(offsets), err
+		}
+		receipts, err := rlp.EncodeToBytes(rawdb.ReadReceipts(c.database, block.Hash(), n, c.gspec.Config))
+		if err != nil {
+			return len(offsets), err
+		}
+		td := c.chain.GetTd(block.Hash(), n)
+		if td == nil {
+			return len(offsets), fmt.Errorf("no total difficulty of block %d", n)
+		}
+		offset, err := e.writeSnappy(e2CompressedHeader, b.Header)
+		if err != nil {
+			return len(offsets), err
+		}
+		if _, err := e.writeSnappy(e2CompressedBody, body); err != nil {
+			return len(offsets), err
+		}
+		if _, err := e.writeSnappy(e2CompressedReceipt, receipts); err != nil {
+			return len(offsets), err
+		}
+		tdEnc := uint256LE(td)
+		if _, err := e.write(e2TotalDifficulty, tdEnc[:]); err != nil {
+			return len(offsets), err
+		}
+		offsets = append(offsets, offset)
+		hashes = append(hashes, crypto.Keccak256Hash(b.Header))
+		tds = append(tds, td)
+	}
+	root := era1Accumulator(hashes, tds)
+	if _, err := e.write(e2Accumulator, root[:]); err != nil {
+		return len(offsets), err
+	}
+	// the offsets of the index are relative to the index entry
+	index := make([]byte, 16+8*len(offsets))
+	binary.LittleEndian.PutUint64(index, first)
+	indexPos := e.pos
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint64(index[8+8*i:], uint64(offset-indexPos))
+	}
+	binary.LittleEndian.PutUint64(index[8+8*len(offsets):], uint64(len(offsets)))
+	if _, err := e.write(e2BlockIndex, index); err != nil {
+		return len(offsets), err
+	}
+	return len(offsets), nil
+}
+
+// specBody is the body of a spec block, as stored in era1 archives.
+type specBody struct {
+	Txs         []*types.Transaction
+	Uncles      []*types.Header
+	Withdrawals []*mmTypes.Withdrawal `rlp:"optional"`
+}
+
+// ImportEra1 imports the blocks of the era1 archive, after checking them against its accumulator, and makes the
+// last one the canonical head. It returns the number of blocks imported.
+func (c *MockChain) ImportEra1(data []byte) (int, error) {
+	entries, err := readE2Entries(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 || entries[0].typ != e2Version {
+		return 0, fmt.Errorf("not an era1 archive, it doesn't start with a version entry")
+	}
+	var blocks []*specBlock
+	var hashes []common.Hash
+	var tds []*big.Int
+	var accumulator *common.Hash
+	for _, entry := range entries[1:] {
+		switch entry.typ {
+		case e2CompressedHeader:
+			header, err := readSnappy(entry.data)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decompress header of block %d: %v", len(blocks), err)
+			}
+			blocks = append(blocks, &specBlock{Header: header})
+			hashes = append(hashes, crypto.Keccak256Hash(header))
+		case e2CompressedBody:
+			if len(blocks) == 0 {
+				return 0, fmt.Errorf("block body before the first header")
+			}
+			enc, err := readSnappy(entry.data)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decompress body of block %d: %v", len(blocks)-1, err)
+			}
+			var body specBody
+			if err := rlp.DecodeBytes(enc, &body); err != nil {
+				return 0, fmt.Errorf("failed to decode body of block %d: %v", len(blocks)-1, err)
+			}
+			b := blocks[len(blocks)-1]
+			b.Txs, b.Uncles, b.Withdrawals = body.Txs, body.Uncles, body.Withdrawals
+		case e2TotalDifficulty:
+			if len(entry.data) != 32 {
+				return 0, fmt.Errorf("total difficulty of block %d is not 32 bytes", len(blocks)-1)
+			}
+			be := make([]byte, 32)
+			for i := range be {
+				be[i] = entry.data[31-i]
+			}
+			tds = append(tds, new(big.Int).SetBytes(be))
+		case e2Accumulator:
+			root := common.BytesToHash(entry.data)
+			accumulator = &root
+		}
+	}
+	if len(tds) != len(blocks) {
+		return 0, fmt.Errorf("archive has %d total difficulties for %d blocks", len(tds), len(blocks))
+	}
+	if accumulator == nil {
+		return 0, fmt.Errorf("archive has no accumulator")
+	}
+	if root := era1Accumulator(hashes, tds); root != *accumulator {
+		return 0, fmt.Errorf("accumulator %s doesn't match the blocks of the archive, %s", accumulator, root)
+	}
+	return c.importBlocks(blocks)
+}
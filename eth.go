@@ -1,8 +1,9 @@
-package main
+package mergemock
 
 import (
 	"context"
 	"errors"
+	"fmt"
 	"mergemock/rpc"
 	"mergemock/types"
 
@@ -15,14 +16,26 @@ import (
 )
 
 type EthBackend struct {
-	chain *core.BlockChain
+	mockChain    *MockChain
+	chain        *core.BlockChain
+	pool         *TxPool
+	gpo          *GasPriceOracleConfig
+	stateHistory uint64
+	sync         *SyncSimulator
+	subs         *Subscriptions
 }
 
-func NewEthBackend(chain *core.BlockChain) *EthBackend {
+func NewEthBackend(mockChain *MockChain, gpo *GasPriceOracleConfig, stateHistory uint64, subs *Subscriptions) *EthBackend {
 	return &EthBackend{
-		chain: chain,
+		mockChain:    mockChain,
+		chain:        mockChain.chain,
+		pool:         mockChain.pool,
+		gpo:          gpo,
+		stateHistory: stateHistory,
+		subs:         subs,
 	}
 }
+
 func (b *EthBackend) Register(srv *rpc.Server) error {
 	srv.RegisterName("eth", b)
 	return node.RegisterApis([]rpc.API{
@@ -36,9 +49,17 @@ func (b *EthBackend) Register(srv *rpc.Server) error {
 	}, []string{"eth"}, srv, false)
 }
 
+// rpcMarshalHeader encodes the header as consensus clients know it, with the spec hashes of it and its parent,
+// and its fork fields.
+func (b *EthBackend) rpcMarshalHeader(header *ethTypes.Header) map[string]interface{} {
+	spec := ethTypes.CopyHeader(header)
+	spec.ParentHash = b.mockChain.SpecHash(header.ParentHash)
+	return types.RPCMarshalHeader(spec, b.mockChain.ForkFields(header.Hash()))
+}
+
 // Based on https://github.com/ethereum/go-ethereum/blob/16701c51697e28986feebd122c6a491e4d9ac0e7/internal/ethapi/api.go#L1200
 func (b *EthBackend) rpcMarshalBlock(ctx context.Context, block *ethTypes.Block, inclTx bool, fullTx bool) (map[string]interface{}, error) {
-	fields, err := types.RPCMarshalBlock(block, inclTx, fullTx, b.chain.Config())
+	fields, err := types.RPCMarshalBlock(block, b.mockChain.SpecHash(block.ParentHash()), b.mockChain.ForkFields(block.Hash()), inclTx, fullTx, b.chain.Config())
 	if err != nil {
 		return nil, err
 	}
@@ -49,9 +70,10 @@ func (b *EthBackend) rpcMarshalBlock(ctx context.Context, block *ethTypes.Block,
 }
 
 func (b *EthBackend) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
-	block := b.chain.GetBlockByHash(hash)
+	block := b.chain.GetBlockByHash(b.mockChain.ResolveHash(hash))
 	if block == nil {
-		return nil, errors.New("unknown block")
+		// Like geth, unknown blocks are null: terminal block discovery walks parents until it finds none.
+		return nil, nil
 	}
 	return b.rpcMarshalBlock(ctx, block, true, fullTx)
 }
@@ -69,8 +91,35 @@ func (b *EthBackend) GetBlockByNumber(ctx context.Context, number gethRpc.BlockN
 	default:
 		block := b.chain.GetBlockByNumber(uint64(number))
 		if block == nil {
-			return nil, errors.New("unknown block")
+			return nil, nil
 		}
 		return b.rpcMarshalBlock(ctx, block, true, fullTx)
 	}
 }
+
+func (b *EthBackend) BlockNumber(ctx context.Context) (hexutil.Uint64, error) {
+	return hexutil.Uint64(b.chain.CurrentHeader().Number.Uint64()), nil
+}
+
+func (b *EthBackend) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	return (*hexutil.Big)(b.chain.Config().ChainID), nil
+}
+
+// SendRawTransaction adds the signed transaction to the mempool, for inclusion in the next built payloads.
+func (b *EthBackend) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
+	tx := new(ethTypes.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if chainID := tx.ChainId(); tx.Protected() && chainID.Cmp(b.chain.Config().ChainID) != 0 {
+		return common.Hash{}, fmt.Errorf("invalid chain id %d, expected %d", chainID, b.chain.Config().ChainID)
+	}
+	head, err := b.chain.State()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := b.pool.Add(tx, head); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
@@ -0,0 +1,66 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthBlockSpecHash(t *testing.T) {
+	funded := common.Address{0x01}
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, funded), func(cmd *EngineCmd) {
+		cmd.Forks.Shanghai, cmd.Forks.Cancun = "0", "0"
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+
+	parent := genesis.Hash()
+	var payloads []*types.ExecutionPayloadV3
+	for i := 1; i <= 2; i++ {
+		root := common.Hash{byte(i)}
+		attributes := &types.PayloadAttributesV3{
+			Timestamp:             genesis.Time + uint64(i)*12,
+			Withdrawals:           []*types.Withdrawal{{Index: uint64(i), Validator: 1, Address: common.Address{0xaa}, Amount: 1}},
+			ParentBeaconBlockRoot: root,
+		}
+		result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes)
+		require.NoError(t, err)
+		envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+		require.NoError(t, err)
+		status, err := api.NewPayloadV3(ctx, te.client, te.log, envelope.ExecutionPayload, []common.Hash{}, root)
+		require.NoError(t, err)
+		require.Equal(t, types.ExecutionValid, status.Status)
+		payloads = append(payloads, envelope.ExecutionPayload)
+		parent = envelope.ExecutionPayload.BlockHash
+	}
+	_, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, nil)
+	require.NoError(t, err)
+
+	// Blocks are found by the hash of the engine API, and report it with the fork fields it commits to.
+	for i, payload := range payloads {
+		var block map[string]interface{}
+		require.NoError(t, te.client.CallContext(ctx, &block, "eth_getBlockByHash", payload.BlockHash, false))
+		require.NotNil(t, block, "block %d", i+1)
+		require.Equal(t, payload.BlockHash.Hex(), block["hash"])
+		require.Equal(t, payload.ParentHash.Hex(), block["parentHash"])
+		require.Equal(t, types.WithdrawalsRoot(payload.Withdrawals).Hex(), block["withdrawalsRoot"])
+		require.Len(t, block["withdrawals"], 1)
+		require.Equal(t, "0x0", block["blobGasUsed"])
+		require.Equal(t, "0x0", block["excessBlobGas"])
+		require.Equal(t, common.Hash{byte(i + 1)}.Hex(), block["parentBeaconBlockRoot"])
+		require.NotContains(t, block, "requestsHash")
+	}
+	var latest map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &latest, "eth_getBlockByNumber", "latest", false))
+	require.Equal(t, parent.Hex(), latest["hash"])
+
+	// State is queried by the same hashes.
+	var balance hexutil.Big
+	require.NoError(t, te.client.CallContext(ctx, &balance, "eth_getBalance", funded, map[string]interface{}{"blockHash": parent, "requireCanonical": true}))
+	require.Positive(t, balance.ToInt().Sign())
+}
@@ -0,0 +1,210 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// Same defaults as geth uses for its --rpc.gascap and --rpc.evmtimeout flags
+	callGasCap  = 50_000_000
+	callTimeout = 5 * time.Second
+)
+
+// CallArgs represents the arguments of eth_call and eth_estimateGas.
+type CallArgs struct {
+	From                 *common.Address      `json:"from"`
+	To                   *common.Address      `json:"to"`
+	Gas                  *hexutil.Uint64      `json:"gas"`
+	GasPrice             *hexutil.Big         `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big         `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big         `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big         `json:"value"`
+	Data                 *hexutil.Bytes       `json:"data"`
+	Input                *hexutil.Bytes       `json:"input"`
+	AccessList           *ethTypes.AccessList `json:"accessList,omitempty"`
+}
+
+// toMessage converts the call arguments to a message, following the fee semantics of geth.
+func (args *CallArgs) toMessage(gasCap uint64, baseFee *big.Int) (ethTypes.Message, error) {
+	if args.GasPrice != nil && (args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil) {
+		return ethTypes.Message{}, errors.New("both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified")
+	}
+	var from common.Address
+	if args.From != nil {
+		from = *args.From
+	}
+	gas := gasCap
+	if args.Gas != nil && uint64(*args.Gas) < gasCap {
+		gas = uint64(*args.Gas)
+	}
+	gasPrice, gasFeeCap, gasTipCap := new(big.Int), new(big.Int), new(big.Int)
+	if baseFee == nil || args.GasPrice != nil {
+		if args.GasPrice != nil {
+			gasPrice = args.GasPrice.ToInt()
+		}
+		gasFeeCap, gasTipCap = gasPrice, gasPrice
+	} else {
+		if args.MaxFeePerGas != nil {
+			gasFeeCap = args.MaxFeePerGas.ToInt()
+		}
+		if args.MaxPriorityFeePerGas != nil {
+			gasTipCap = args.MaxPriorityFeePerGas.ToInt()
+		}
+		if gasFeeCap.BitLen() > 0 || gasTipCap.BitLen() > 0 {
+			gasPrice = math.BigMin(new(big.Int).Add(gasTipCap, baseFee), gasFeeCap)
+		}
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	var data []byte
+	if args.Input != nil {
+		data = *args.Input
+	} else if args.Data != nil {
+		data = *args.Data
+	}
+	var accessList ethTypes.AccessList
+	if args.AccessList != nil {
+		accessList = *args.AccessList
+	}
+	return ethTypes.NewMessage(from, args.To, 0, value, gas, gasPrice, gasFeeCap, gasTipCap, data, accessList, true), nil
+}
+
+// revertError is an API error that encompasses an EVM revert with JSON error
+// code and a binary data blob, like geth returns it.
+type revertError struct {
+	error
+	reason string
+}
+
+func newRevertError(result *core.ExecutionResult) *revertError {
+	reason, errUnpack := abi.UnpackRevert(result.Revert())
+	err := errors.New("execution reverted")
+	if errUnpack == nil {
+		err = fmt.Errorf("execution reverted: %v", reason)
+	}
+	return &revertError{
+		error:  err,
+		reason: hexutil.Encode(result.Revert()),
+	}
+}
+
+func (e *revertError) ErrorCode() int {
+	return 3
+}
+
+func (e *revertError) ErrorData() interface{} {
+	return e.reason
+}
+
+func (b *EthBackend) doCall(ctx context.Context, args CallArgs, statedb *state.StateDB, header *ethTypes.Header, gasCap uint64) (*core.ExecutionResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	msg, err := args.toMessage(gasCap, header.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	blockCtx := core.NewEVMBlockContext(header, b.chain, nil)
+	evm := vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, b.chain.Config(), vm.Config{NoBaseFee: true})
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+
+	result, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+	if evm.Cancelled() {
+		return nil, fmt.Errorf("execution aborted (timeout = %v)", callTimeout)
+	}
+	if err != nil {
+		return result, fmt.Errorf("err: %w (supplied gas %d)", err, msg.Gas())
+	}
+	return result, nil
+}
+
+func (b *EthBackend) Call(ctx context.Context, args CallArgs, blockNrOrHash *gethRpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	statedb, header, err := b.stateAndHeader(blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.doCall(ctx, args, statedb, header, callGasCap)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Revert()) > 0 {
+		return nil, newRevertError(result)
+	}
+	return result.Return(), result.Err
+}
+
+func (b *EthBackend) EstimateGas(ctx context.Context, args CallArgs, blockNrOrHash *gethRpc.BlockNumberOrHash) (hexutil.Uint64, error) {
+	statedb, header, err := b.stateAndHeader(blockNrOrHash)
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := params.TxGas-1, header.GasLimit
+	if args.Gas != nil && uint64(*args.Gas) >= params.TxGas && uint64(*args.Gas) < hi {
+		hi = uint64(*args.Gas)
+	}
+	if hi > callGasCap {
+		hi = callGasCap
+	}
+	allowance := hi
+
+	// Every execution runs on a fresh copy, so failed probes don't leak state into the next one.
+	executable := func(gas uint64) (bool, *core.ExecutionResult, error) {
+		args.Gas = (*hexutil.Uint64)(&gas)
+		result, err := b.doCall(ctx, args, statedb.Copy(), header, gas)
+		if err != nil {
+			if errors.Is(err, core.ErrIntrinsicGas) {
+				return true, nil, nil
+			}
+			return true, nil, err
+		}
+		return result.Failed(), result, nil
+	}
+	for lo+1 < hi {
+		mid := (hi + lo) / 2
+		failed, _, err := executable(mid)
+		if err != nil {
+			return 0, err
+		}
+		if failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if hi == allowance {
+		failed, result, err := executable(hi)
+		if err != nil {
+			return 0, err
+		}
+		if failed {
+			if result != nil && result.Err != vm.ErrOutOfGas {
+				if len(result.Revert()) > 0 {
+					return 0, newRevertError(result)
+				}
+				return 0, result.Err
+			}
+			return 0, fmt.Errorf("gas required exceeds allowance (%d)", allowance)
+		}
+	}
+	return hexutil.Uint64(hi), nil
+}
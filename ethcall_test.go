@@ -0,0 +1,98 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// returns the word 42
+	callReturnCode = common.FromHex("602a60005260206000f3")
+	// reverts with Error("nope"), as solidity encodes it
+	callRevertData = common.FromHex("08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000")
+)
+
+// revertCode returns code reverting with the data, stored in memory a word at a time.
+func revertCode(data []byte) []byte {
+	var code []byte
+	for offset := 0; offset < len(data); offset += 32 {
+		word := common.RightPadBytes(data[offset:], 32)[:32]
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, word...)
+		code = append(code, byte(vm.PUSH1), byte(offset), byte(vm.MSTORE))
+	}
+	return append(code, byte(vm.PUSH1), byte(len(data)), byte(vm.PUSH1), 0, byte(vm.REVERT))
+}
+
+func newCallEngine(t *testing.T, returner, reverter common.Address) *testEngine {
+	path := fmt.Sprintf("%s/genesis.json", t.TempDir())
+	genesis := core.DeveloperGenesisBlock(5, 30_000_000, common.Address{0x01})
+	genesis.Config.MergeForkBlock = common.Big0
+	genesis.Config.TerminalTotalDifficulty = common.Big0
+	genesis.Alloc[returner] = core.GenesisAccount{Code: callReturnCode, Balance: common.Big0}
+	genesis.Alloc[reverter] = core.GenesisAccount{Code: revertCode(callRevertData), Balance: common.Big0}
+	buf, err := genesis.MarshalJSON()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+	return newTestEngineWithGenesis(t, path)
+}
+
+func TestEthCall(t *testing.T) {
+	returner, reverter := common.Address{0xaa}, common.Address{0xbb}
+	te := newCallEngine(t, returner, reverter)
+	ctx := context.Background()
+
+	var result hexutil.Bytes
+	require.NoError(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &returner}, "latest"))
+	require.Equal(t, common.LeftPadBytes([]byte{42}, 32), []byte(result))
+
+	err := te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &reverter}, "latest")
+	require.EqualError(t, err, "execution reverted: nope")
+	var rpcErr gethRpc.Error
+	require.True(t, errors.As(err, &rpcErr))
+	require.Equal(t, 3, rpcErr.ErrorCode())
+	var dataErr gethRpc.DataError
+	require.True(t, errors.As(err, &dataErr))
+	require.Equal(t, hexutil.Encode(callRevertData), dataErr.ErrorData())
+
+	require.Error(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &returner}, common.Hash{0x01}.Hex()), "unknown block")
+}
+
+func TestEthEstimateGas(t *testing.T) {
+	returner, reverter := common.Address{0xaa}, common.Address{0xbb}
+	te := newCallEngine(t, returner, reverter)
+	ctx := context.Background()
+
+	var estimate hexutil.Uint64
+	to := common.Address{0x02}
+	require.NoError(t, te.client.CallContext(ctx, &estimate, "eth_estimateGas", CallArgs{To: &to}, "latest"))
+	require.Equal(t, hexutil.Uint64(params.TxGas), estimate, "plain transfer")
+
+	require.NoError(t, te.client.CallContext(ctx, &estimate, "eth_estimateGas", CallArgs{To: &returner}, "latest"))
+	require.Greater(t, uint64(estimate), params.TxGas)
+	// The estimate is the least gas the call succeeds with.
+	var result hexutil.Bytes
+	require.NoError(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &returner, Gas: &estimate}, "latest"))
+	less := estimate - 1
+	require.Error(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &returner, Gas: &less}, "latest"))
+
+	err := te.client.CallContext(ctx, &estimate, "eth_estimateGas", CallArgs{To: &reverter}, "latest")
+	require.EqualError(t, err, "execution reverted: nope")
+	var dataErr gethRpc.DataError
+	require.True(t, errors.As(err, &dataErr))
+	require.Equal(t, hexutil.Encode(callRevertData), dataErr.ErrorData())
+}
@@ -0,0 +1,187 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+)
+
+// maxLogRange is the most blocks eth_getLogs scans, there is no bloom index to speed up longer ranges.
+const maxLogRange = 10000
+
+// Based on https://github.com/ethereum/go-ethereum/blob/v1.10.17/internal/ethapi/api.go#L1624
+func (b *EthBackend) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	lookup := b.chain.GetTransactionLookup(hash)
+	if lookup == nil {
+		return nil, nil
+	}
+	block := b.chain.GetBlockByHash(lookup.BlockHash)
+	receipts := b.chain.GetReceiptsByHash(lookup.BlockHash)
+	if block == nil || len(receipts) <= int(lookup.Index) {
+		return nil, nil
+	}
+	tx := block.Transactions()[lookup.Index]
+	receipt := receipts[lookup.Index]
+
+	signer := ethTypes.MakeSigner(b.chain.Config(), block.Number())
+	from, _ := ethTypes.Sender(signer, tx)
+	fields := map[string]interface{}{
+		"blockHash":         b.mockChain.SpecHash(lookup.BlockHash),
+		"blockNumber":       hexutil.Uint64(lookup.BlockIndex),
+		"transactionHash":   hash,
+		"transactionIndex":  hexutil.Uint64(lookup.Index),
+		"from":              from,
+		"to":                tx.To(),
+		"gasUsed":           hexutil.Uint64(receipt.GasUsed),
+		"cumulativeGasUsed": hexutil.Uint64(receipt.CumulativeGasUsed),
+		"contractAddress":   nil,
+		"logs":              b.specLogs(receipt.Logs),
+		"logsBloom":         receipt.Bloom,
+		"type":              hexutil.Uint(tx.Type()),
+	}
+	if baseFee := block.BaseFee(); baseFee != nil {
+		fields["effectiveGasPrice"] = (*hexutil.Big)(new(big.Int).Add(baseFee, tx.EffectiveGasTipValue(baseFee)))
+	} else {
+		fields["effectiveGasPrice"] = (*hexutil.Big)(tx.GasPrice())
+	}
+	if len(receipt.PostState) > 0 {
+		fields["root"] = hexutil.Bytes(receipt.PostState)
+	} else {
+		fields["status"] = hexutil.Uint(receipt.Status)
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		fields["contractAddress"] = receipt.ContractAddress
+	}
+	return fields, nil
+}
+
+// GetLogs scans the canonical blocks of the range, or the block of the hash, for matching logs.
+func (b *EthBackend) GetLogs(ctx context.Context, crit filters.FilterCriteria) ([]*ethTypes.Log, error) {
+	var headers []*ethTypes.Header
+	if crit.BlockHash != nil {
+		header := b.chain.GetHeaderByHash(b.mockChain.ResolveHash(*crit.BlockHash))
+		if header == nil {
+			return nil, errors.New("unknown block")
+		}
+		headers = append(headers, header)
+	} else {
+		head := b.chain.CurrentHeader().Number.Uint64()
+		from, to := b.logRangeEnd(crit.FromBlock, head), b.logRangeEnd(crit.ToBlock, head)
+		if to > head {
+			to = head
+		}
+		if from <= to && to-from >= maxLogRange {
+			return nil, fmt.Errorf("block range %d-%d longer than %d blocks", from, to, maxLogRange)
+		}
+		for number := from; number <= to; number++ {
+			if header := b.chain.GetHeaderByNumber(number); header != nil {
+				headers = append(headers, header)
+			}
+		}
+	}
+	logs := []*ethTypes.Log{}
+	for _, header := range headers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !bloomMatches(header.Bloom, crit.Addresses, crit.Topics) {
+			continue
+		}
+		for _, receipt := range b.chain.GetReceiptsByHash(header.Hash()) {
+			for _, log := range receipt.Logs {
+				if logMatches(log, crit.Addresses, crit.Topics) {
+					logs = append(logs, log)
+				}
+			}
+		}
+	}
+	return b.specLogs(logs), nil
+}
+
+// specLogs returns copies of the logs with the spec hash of their block, which geth sets to the hash it stores the
+// block under.
+func (b *EthBackend) specLogs(logs []*ethTypes.Log) []*ethTypes.Log {
+	spec := make([]*ethTypes.Log, len(logs))
+	for i, log := range logs {
+		cpy := *log
+		cpy.BlockHash = b.mockChain.SpecHash(log.BlockHash)
+		spec[i] = &cpy
+	}
+	return spec
+}
+
+// logRangeEnd resolves a block number of a filter, where nil and the latest and pending tags are the head.
+func (b *EthBackend) logRangeEnd(number *big.Int, head uint64) uint64 {
+	if number == nil || number.Sign() < 0 {
+		return head
+	}
+	return number.Uint64()
+}
+
+// bloomMatches reports whether the bloom filter may contain logs matching the criteria.
+func bloomMatches(bloom ethTypes.Bloom, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		found := false
+		for _, addr := range addresses {
+			if ethTypes.BloomLookup(bloom, addr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, sub := range topics {
+		found := len(sub) == 0
+		for _, topic := range sub {
+			if ethTypes.BloomLookup(bloom, topic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// logMatches reports whether the log is emitted by one of the addresses, and has one of the topics at every
+// position, any address or topic matching where none are given.
+func logMatches(log *ethTypes.Log, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		found := false
+		for _, addr := range addresses {
+			if log.Address == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(topics) > len(log.Topics) {
+		return false
+	}
+	for i, sub := range topics {
+		found := len(sub) == 0
+		for _, topic := range sub {
+			if log.Topics[i] == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
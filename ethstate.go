@@ -0,0 +1,90 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// stateAndHeader resolves the block tag or hash to a header and the state at that header.
+func (b *EthBackend) stateAndHeader(blockNrOrHash *gethRpc.BlockNumberOrHash) (*state.StateDB, *ethTypes.Header, error) {
+	var header *ethTypes.Header
+	if blockNrOrHash == nil {
+		header = b.chain.CurrentHeader()
+	} else if hash, ok := blockNrOrHash.Hash(); ok {
+		header = b.chain.GetHeaderByHash(b.mockChain.ResolveHash(hash))
+		if header != nil && blockNrOrHash.RequireCanonical && b.chain.GetCanonicalHash(header.Number.Uint64()) != header.Hash() {
+			return nil, nil, fmt.Errorf("hash %s is not currently canonical", hash)
+		}
+	} else if number, ok := blockNrOrHash.Number(); ok {
+		switch number {
+		case gethRpc.LatestBlockNumber, gethRpc.PendingBlockNumber:
+			header = b.chain.CurrentHeader()
+		default:
+			header = b.chain.GetHeaderByNumber(uint64(number))
+		}
+	}
+	if header == nil {
+		return nil, nil, errors.New("unknown block")
+	}
+	if !b.stateAvailable(header) {
+		return nil, nil, &trie.MissingNodeError{NodeHash: header.Root}
+	}
+	statedb, err := b.chain.StateAt(header.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return statedb, header, nil
+}
+
+// stateAvailable emulates a pruning node: only the state of the most recent
+// blocks, up to the configured history depth, can be queried.
+func (b *EthBackend) stateAvailable(header *ethTypes.Header) bool {
+	if b.stateHistory == 0 {
+		return true
+	}
+	head := b.chain.CurrentHeader().Number.Uint64()
+	number := header.Number.Uint64()
+	return number > head || head-number < b.stateHistory
+}
+
+func (b *EthBackend) GetBalance(ctx context.Context, address common.Address, blockNrOrHash gethRpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	statedb, _, err := b.stateAndHeader(&blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(statedb.GetBalance(address)), statedb.Error()
+}
+
+func (b *EthBackend) GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash gethRpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	statedb, _, err := b.stateAndHeader(&blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	nonce := statedb.GetNonce(address)
+	return (*hexutil.Uint64)(&nonce), statedb.Error()
+}
+
+func (b *EthBackend) GetCode(ctx context.Context, address common.Address, blockNrOrHash gethRpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	statedb, _, err := b.stateAndHeader(&blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(address), statedb.Error()
+}
+
+func (b *EthBackend) GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash gethRpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	statedb, _, err := b.stateAndHeader(&blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	value := statedb.GetState(address, common.HexToHash(key))
+	return value[:], statedb.Error()
+}
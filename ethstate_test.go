@@ -0,0 +1,41 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthStateHistory(t *testing.T) {
+	funded := common.Address{0x01}
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, funded), func(cmd *EngineCmd) {
+		cmd.StateHistory = 2
+	})
+	ctx := context.Background()
+	parent := te.mockChain().CurrentHeader()
+	parentHash, timestamp := parent.Hash(), parent.Time
+	for i := 1; i <= 3; i++ {
+		timestamp += 12
+		payload := te.buildPayload(t, parentHash, timestamp, common.Hash{byte(i)})
+		te.newPayload(t, payload)
+		te.setHead(t, payload.BlockHash)
+		parentHash = payload.BlockHash
+	}
+
+	// The state of the head and its parent is retained, the one of the block before isn't.
+	var balance hexutil.Big
+	for _, block := range []string{"latest", "0x3", "0x2"} {
+		require.NoError(t, te.client.CallContext(ctx, &balance, "eth_getBalance", funded, block), block)
+		require.Positive(t, balance.ToInt().Sign())
+	}
+	err := te.client.CallContext(ctx, &balance, "eth_getBalance", funded, "0x1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing trie node")
+	var code hexutil.Bytes
+	err = te.client.CallContext(ctx, &code, "eth_getCode", funded, "0x0")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing trie node")
+}
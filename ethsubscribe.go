@@ -0,0 +1,298 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// Policies for subscriptions whose notification queue is full.
+const (
+	SubscriptionDropOldest = "drop-oldest"
+	SubscriptionClose      = "close"
+)
+
+// Events buffered between a chain feed and a subscription, which moves them to its queue as they come.
+const feedBuffer = 16
+
+type SubscriptionConfig struct {
+	Queue  int    `ask:"--queue" help:"Notifications queued per eth_subscribe subscription whose subscriber falls behind"`
+	Policy string `ask:"--policy" help:"What happens to a subscription whose queue is full: drop-oldest drops its oldest queued notification, close ends it with a final error notification"`
+}
+
+func (c *SubscriptionConfig) validate() error {
+	switch c.Policy {
+	case SubscriptionDropOldest, SubscriptionClose:
+	default:
+		return fmt.Errorf("unknown subscription policy %q, expected %s or %s", c.Policy, SubscriptionDropOldest, SubscriptionClose)
+	}
+	if c.Queue <= 0 {
+		return fmt.Errorf("subscription queue %d must be positive", c.Queue)
+	}
+	return nil
+}
+
+// Subscriptions keeps the eth_subscribe subscriptions of the engine. Their notifications are queued per
+// subscriber, so that a subscriber falling behind can't hold up the chain feeds, which block on their slowest
+// receiver, and with them the chain.
+type Subscriptions struct {
+	cfg SubscriptionConfig
+
+	mu     sync.Mutex
+	active map[gethRpc.ID]*subscriber
+}
+
+func newSubscriptions(cfg SubscriptionConfig) *Subscriptions {
+	return &Subscriptions{cfg: cfg, active: make(map[gethRpc.ID]*subscriber)}
+}
+
+// SubscriptionStats are the counters of the queue of a subscription. Dropped counts the notifications that were
+// never sent, Closed is whether the policy ended the subscription.
+type SubscriptionStats struct {
+	ID      gethRpc.ID `json:"id"`
+	Kind    string     `json:"kind"`
+	Queued  int        `json:"queued"`
+	Dropped uint64     `json:"dropped"`
+	Closed  bool       `json:"closed"`
+}
+
+// Stats returns the counters of the subscriptions whose subscriber is still connected, by id.
+func (s *Subscriptions) Stats() []SubscriptionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]SubscriptionStats, 0, len(s.active))
+	for _, sub := range s.active {
+		stats = append(stats, sub.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// add starts sending the notifications queued for the subscription, until the subscriber is gone.
+func (s *Subscriptions) add(id gethRpc.ID, kind string, send func(interface{}) error, gone <-chan struct{}) *subscriber {
+	sub := &subscriber{
+		id:     id,
+		kind:   kind,
+		cfg:    s.cfg,
+		send:   send,
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.active[id] = sub
+	s.mu.Unlock()
+	go func() {
+		sub.run(gone)
+		s.mu.Lock()
+		delete(s.active, id)
+		s.mu.Unlock()
+	}()
+	return sub
+}
+
+// subscriber queues the notifications of a subscription, which are sent one by one as fast as the subscriber
+// takes them.
+type subscriber struct {
+	id   gethRpc.ID
+	kind string
+	cfg  SubscriptionConfig
+	send func(interface{}) error
+	wake chan struct{}
+	// closed is closed once nothing is queued anymore, as the subscriber is gone or the policy closed it.
+	closed chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	queue   []interface{}
+	dropped uint64
+	ended   bool
+}
+
+// push queues the notification without waiting for the subscriber, applying the policy if the queue is full.
+func (s *subscriber) push(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if len(s.queue) >= s.cfg.Queue {
+		if s.cfg.Policy == SubscriptionClose {
+			s.dropped += uint64(len(s.queue)) + 1
+			s.queue, s.ended = nil, true
+			s.once.Do(func() { close(s.closed) })
+			s.signal()
+			return
+		}
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, v)
+	s.signal()
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the oldest queued notification, and reports whether the policy closed the subscription.
+func (s *subscriber) pop() (v interface{}, ok bool, ended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return nil, false, s.ended
+	}
+	v = s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return v, true, false
+}
+
+// run sends the queued notifications until the subscriber is gone. A subscription closed by the policy gets a final
+// notification with the error instead of a result: the server of geth can't end a subscription itself.
+func (s *subscriber) run(gone <-chan struct{}) {
+	defer s.once.Do(func() { close(s.closed) })
+	for {
+		select {
+		case <-s.wake:
+		case <-gone:
+			return
+		}
+		for {
+			v, ok, ended := s.pop()
+			if ended {
+				s.send(map[string]string{"error": fmt.Sprintf("subscription closed, its queue of %d notifications is full", s.cfg.Queue)})
+				<-gone
+				return
+			}
+			if !ok {
+				break
+			}
+			if err := s.send(v); err != nil {
+				// The connection is closing, which ends the subscription.
+				<-gone
+				return
+			}
+		}
+	}
+}
+
+func (s *subscriber) stats() SubscriptionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SubscriptionStats{ID: s.id, Kind: s.kind, Queued: len(s.queue), Dropped: s.dropped, Closed: s.ended}
+}
+
+// subscribe creates a subscription on the connection of the call, which is done once the subscriber unsubscribes or
+// disconnects. Subscriptions need a websocket or IPC connection.
+func (b *EthBackend) subscribe(ctx context.Context, kind string) (*gethRpc.Subscription, *subscriber, error) {
+	notifier, supported := gethRpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, nil, gethRpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	gone := make(chan struct{})
+	go func() {
+		select {
+		case <-rpcSub.Err():
+		case <-notifier.Closed():
+		}
+		close(gone)
+	}()
+	send := func(v interface{}) error { return notifier.Notify(rpcSub.ID, v) }
+	return rpcSub, b.subs.add(rpcSub.ID, kind, send, gone), nil
+}
+
+// NewHeads notifies the header of every new canonical head, including the heads of reorgs.
+func (b *EthBackend) NewHeads(ctx context.Context) (*gethRpc.Subscription, error) {
+	rpcSub, sub, err := b.subscribe(ctx, "newHeads")
+	if err != nil {
+		return nil, err
+	}
+	b.newHeads(sub)
+	return rpcSub, nil
+}
+
+func (b *EthBackend) newHeads(sub *subscriber) {
+	heads := make(chan core.ChainHeadEvent, feedBuffer)
+	feed := b.chain.SubscribeChainHeadEvent(heads)
+	go func() {
+		defer feed.Unsubscribe()
+		for {
+			select {
+			case ev := <-heads:
+				sub.push(b.rpcMarshalHeader(ev.Block.Header()))
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+}
+
+// Logs notifies the logs matching the addresses and topics of the criteria as canonical blocks include them, and
+// again with removed set when a reorg drops their block. The block range of the criteria is ignored.
+func (b *EthBackend) Logs(ctx context.Context, crit filters.FilterCriteria) (*gethRpc.Subscription, error) {
+	rpcSub, sub, err := b.subscribe(ctx, "logs")
+	if err != nil {
+		return nil, err
+	}
+	logs := make(chan []*ethTypes.Log, feedBuffer)
+	removed := make(chan core.RemovedLogsEvent, feedBuffer)
+	logsFeed := b.chain.SubscribeLogsEvent(logs)
+	removedFeed := b.chain.SubscribeRemovedLogsEvent(removed)
+	send := func(logs []*ethTypes.Log) {
+		for _, log := range b.specLogs(logs) {
+			if logMatches(log, crit.Addresses, crit.Topics) {
+				sub.push(log)
+			}
+		}
+	}
+	go func() {
+		defer logsFeed.Unsubscribe()
+		defer removedFeed.Unsubscribe()
+		for {
+			select {
+			case ev := <-logs:
+				send(ev)
+			case ev := <-removed:
+				send(ev.Logs)
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// NewPendingTransactions notifies the hash of every transaction added to the mempool.
+func (b *EthBackend) NewPendingTransactions(ctx context.Context) (*gethRpc.Subscription, error) {
+	rpcSub, sub, err := b.subscribe(ctx, "newPendingTransactions")
+	if err != nil {
+		return nil, err
+	}
+	txs := make(chan core.NewTxsEvent, feedBuffer)
+	feed := b.pool.SubscribeNewTxsEvent(txs)
+	go func() {
+		defer feed.Unsubscribe()
+		for {
+			select {
+			case ev := <-txs:
+				for _, tx := range ev.Txs {
+					sub.push(tx.Hash())
+				}
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
@@ -0,0 +1,242 @@
+package mergemock
+
+import (
+	"context"
+	"math/big"
+	"mergemock/api"
+	"mergemock/types"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthSubscriptions(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeERC20
+		cfg.GasTarget = 1
+	})
+	ctx := context.Background()
+	client := gethRpc.DialInProc(te.rpcSrv)
+	defer client.Close()
+
+	heads := make(chan map[string]interface{}, 16)
+	headsSub, err := client.EthSubscribe(ctx, heads, "newHeads")
+	require.NoError(t, err)
+	defer headsSub.Unsubscribe()
+	logs := make(chan ethTypes.Log, 16)
+	logsSub, err := client.EthSubscribe(ctx, logs, "logs", map[string]interface{}{"topics": []interface{}{common.BytesToHash(tokenTransferTopic)}})
+	require.NoError(t, err)
+	defer logsSub.Unsubscribe()
+	pending := make(chan common.Hash, 16)
+	pendingSub, err := client.EthSubscribe(ctx, pending, "newPendingTransactions")
+	require.NoError(t, err)
+	defer pendingSub.Unsubscribe()
+
+	genesis := te.mockChain().CurrentHeader().Hash()
+	payload := te.buildAndImport(t, nil, 1)
+	te.setHead(t, payload.BlockHash)
+	txs := decodeTxs(t, payload)
+	select {
+	case head := <-heads:
+		require.Equal(t, te.mockChain().CurrentHeader().Hash().Hex(), head["hash"])
+		require.Equal(t, genesis.Hex(), head["parentHash"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new head notified")
+	}
+	for i := 1; i < len(txs); i++ {
+		select {
+		case log := <-logs:
+			require.Equal(t, txs[i].Hash(), log.TxHash, "every transfer logs once")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("log of transfer %d not notified", i)
+		}
+	}
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	config := te.mockChain().chain.Config()
+	tx := ethTypes.MustSignNewTx(key, ethTypes.LatestSigner(config), &ethTypes.DynamicFeeTx{
+		ChainID:   config.ChainID,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(10_000_000_000),
+		Gas:       transferGas,
+		To:        &common.Address{0x01},
+	})
+	enc, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, te.client.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Bytes(enc)))
+	select {
+	case hash := <-pending:
+		require.Equal(t, tx.Hash(), hash)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending transaction not notified")
+	}
+
+	// Plain HTTP can't carry notifications.
+	var id string
+	err = te.client.CallContext(ctx, &id, "eth_subscribe", "newHeads")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), gethRpc.ErrNotificationsUnsupported.Error())
+}
+
+func TestEthSubscriptionsSpecHash(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)), func(cmd *EngineCmd) {
+		cmd.Forks.Shanghai = "0"
+		cmd.Txs.Default()
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Txs.Seed = 1
+		cmd.Txs.Mode = TxModeERC20
+		cmd.Txs.GasTarget = 10
+	})
+	ctx := context.Background()
+	client := gethRpc.DialInProc(te.rpcSrv)
+	defer client.Close()
+	heads := make(chan map[string]interface{}, 16)
+	headsSub, err := client.EthSubscribe(ctx, heads, "newHeads")
+	require.NoError(t, err)
+	defer headsSub.Unsubscribe()
+	logs := make(chan ethTypes.Log, 16)
+	logsSub, err := client.EthSubscribe(ctx, logs, "logs", map[string]interface{}{})
+	require.NoError(t, err)
+	defer logsSub.Unsubscribe()
+
+	// From Shanghai on geth stores blocks under another hash, which doesn't leak into notifications, receipts or logs.
+	genesis := te.mockChain().CurrentHeader()
+	_, envelope, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, []*types.Withdrawal{{Index: 0, Validator: 1, Address: common.Address{0xaa}, Amount: 1}})
+	require.NoError(t, err)
+	payload := envelope.ExecutionPayload
+	require.NotEmpty(t, payload.Transactions)
+	status, err := api.NewPayloadV2(ctx, te.client, te.log, payload)
+	require.NoError(t, err)
+	require.Equal(t, types.ExecutionValid, status.Status)
+	require.NotEqual(t, te.mockChain().ResolveHash(payload.BlockHash), payload.BlockHash)
+	_, err = api.ForkchoiceUpdatedV2(ctx, te.client, te.log, payload.BlockHash, payload.BlockHash, payload.BlockHash, nil)
+	require.NoError(t, err)
+	select {
+	case head := <-heads:
+		require.Equal(t, payload.BlockHash.Hex(), head["hash"])
+		require.Contains(t, head, "withdrawalsRoot")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new head notified")
+	}
+	select {
+	case log := <-logs:
+		require.Equal(t, payload.BlockHash, log.BlockHash)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no log notified")
+	}
+
+	tx := new(ethTypes.Transaction)
+	require.NoError(t, tx.UnmarshalBinary(payload.Transactions[len(payload.Transactions)-1]))
+	var receipt struct {
+		BlockHash common.Hash    `json:"blockHash"`
+		Logs      []ethTypes.Log `json:"logs"`
+	}
+	require.NoError(t, te.client.CallContext(ctx, &receipt, "eth_getTransactionReceipt", tx.Hash()))
+	require.Equal(t, payload.BlockHash, receipt.BlockHash)
+	require.NotEmpty(t, receipt.Logs)
+	require.Equal(t, payload.BlockHash, receipt.Logs[0].BlockHash)
+	var blockLogs []ethTypes.Log
+	require.NoError(t, te.client.CallContext(ctx, &blockLogs, "eth_getLogs", map[string]interface{}{"blockHash": payload.BlockHash}))
+	require.NotEmpty(t, blockLogs)
+	for _, log := range blockLogs {
+		require.Equal(t, payload.BlockHash, log.BlockHash)
+	}
+}
+
+func TestEthSubscriptionQueues(t *testing.T) {
+	for _, policy := range []string{SubscriptionDropOldest, SubscriptionClose} {
+		t.Run(policy, func(t *testing.T) {
+			te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+				cmd.Subscriptions.Queue = 4
+				cmd.Subscriptions.Policy = policy
+			})
+			eth := NewEthBackend(te.mockChain(), &te.GasPriceOracle, 0, te.backend.subs)
+
+			// The subscriber takes the first head and never reads again.
+			entered, stall, gone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+			sent := make(chan interface{}, 64)
+			var once sync.Once
+			sub := te.backend.subs.add("stalled", "newHeads", func(v interface{}) error {
+				once.Do(func() { close(entered) })
+				<-stall
+				sent <- v
+				return nil
+			}, gone)
+			eth.newHeads(sub)
+			stats := func() SubscriptionStats {
+				for _, s := range te.backend.subs.Stats() {
+					if s.ID == "stalled" {
+						return s
+					}
+				}
+				t.Fatal("subscription not found")
+				return SubscriptionStats{}
+			}
+
+			// Imports go on regardless, well past what the feeds and the queue buffer.
+			const blocks = 40
+			parent := te.mockChain().CurrentHeader()
+			parentHash, timestamp := parent.Hash(), parent.Time
+			for i := 1; i <= blocks; i++ {
+				timestamp += 12
+				payload := te.buildPayload(t, parentHash, timestamp, common.Hash{byte(i)})
+				require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+				te.setHead(t, payload.BlockHash)
+				parentHash = payload.BlockHash
+				if i == 1 {
+					select {
+					case <-entered:
+					case <-time.After(5 * time.Second):
+						t.Fatal("first head not sent")
+					}
+				}
+			}
+
+			if policy == SubscriptionDropOldest {
+				require.Eventually(t, func() bool { return stats().Dropped == blocks-1-4 }, 5*time.Second, 10*time.Millisecond)
+				require.Equal(t, 4, stats().Queued)
+				require.False(t, stats().Closed)
+				var mockStats Stats
+				require.NoError(t, te.client.CallContext(context.Background(), &mockStats, "mock_stats"))
+				require.Contains(t, mockStats.Subscriptions, stats())
+
+				// The subscriber catches up with the latest heads.
+				close(stall)
+				for i := 0; i < 1+4; i++ {
+					select {
+					case <-sent:
+					case <-time.After(5 * time.Second):
+						t.Fatalf("head %d not sent", i)
+					}
+				}
+				require.Zero(t, stats().Queued)
+			} else {
+				// The head that overflows the queue ends the subscription, with an error once the subscriber reads again.
+				require.Eventually(t, func() bool { return stats().Closed }, 5*time.Second, 10*time.Millisecond)
+				require.Equal(t, uint64(4+1), stats().Dropped)
+				require.Zero(t, stats().Queued)
+				close(stall)
+				<-sent
+				select {
+				case v := <-sent:
+					require.Contains(t, v.(map[string]string)["error"], "subscription closed")
+				case <-time.After(5 * time.Second):
+					t.Fatal("error not sent")
+				}
+			}
+
+			close(gone)
+			require.Eventually(t, func() bool { return len(te.backend.subs.Stats()) == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"fmt"
+	"mergemock/types"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Types of the events of the event log.
+const (
+	EventPayloadBuilt       = "payload_built"       // forkchoiceUpdated built a payload, or rebuilt it in the background
+	EventPayloadServed      = "payload_served"      // getPayload served a payload
+	EventNewPayloadReceived = "newpayload_received" // a newPayload call was answered
+	EventFcuReceived        = "fcu_received"        // a forkchoiceUpdated call was answered
+	EventReorg              = "reorg"               // the canonical head moved onto another branch
+	EventFaultInjected      = "fault_injected"      // a fault rule, scenario step or simulated sync replaced a call or its response
+)
+
+// Event is a line of the event log. Slots are those of the payload timestamp, or of the head block for
+// forkchoiceUpdated calls without attributes, counted from the genesis block.
+type Event struct {
+	Time       time.Time        `json:"time"`
+	Type       string           `json:"type"`
+	Method     string           `json:"method,omitempty"`
+	Slot       *uint64          `json:"slot,omitempty"`
+	BlockHash  *common.Hash     `json:"blockHash,omitempty"`
+	ParentHash *common.Hash     `json:"parentHash,omitempty"`
+	PayloadID  *types.PayloadID `json:"payloadId,omitempty"`
+	// Latency is how long the call took to answer, the payload took to build, or the payload waited to be served.
+	Latency *Duration `json:"latency,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Txs     *int      `json:"txs,omitempty"`
+	// OldHead is the head replaced by a reorg, with the number of blocks reorged out.
+	OldHead *common.Hash `json:"oldHead,omitempty"`
+	Depth   uint64       `json:"depth,omitempty"`
+	// Rule is the fault rule injected with its action, 0 for scenario steps and simulated syncs.
+	Rule   uint64 `json:"rule,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+// EventLog writes the events of the engine as JSON lines as they happen, for test harnesses to assert on how the
+// interaction with the consensus client went without parsing the logs.
+type EventLog struct {
+	genesisTime uint64
+	slotTime    uint64
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewEventLog creates the event log file at the path, nil if the path is empty. Slots are counted in the slot time
+// from the genesis time.
+func NewEventLog(path string, genesisTime uint64, slotTime time.Duration) (*EventLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if slotTime < time.Second {
+		return nil, fmt.Errorf("event slot time %s is shorter than a second", slotTime)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{genesisTime: genesisTime, slotTime: uint64(slotTime / time.Second), file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (l *EventLog) slot(timestamp uint64) *uint64 {
+	if timestamp < l.genesisTime {
+		return nil
+	}
+	slot := (timestamp - l.genesisTime) / l.slotTime
+	return &slot
+}
+
+// Emit writes the event, with the current time.
+func (l *EventLog) Emit(ev Event) {
+	if l == nil {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.enc.Encode(&ev)
+	}
+}
+
+// PayloadBuilt records a build of the payload with the id, and how long it took.
+func (l *EventLog) PayloadBuilt(id types.PayloadID, blockHash, parentHash common.Hash, timestamp uint64, txs int, took time.Duration) {
+	if l == nil {
+		return
+	}
+	latency := Duration(took)
+	l.Emit(Event{Type: EventPayloadBuilt, Slot: l.slot(timestamp), BlockHash: &blockHash, ParentHash: &parentHash, PayloadID: &id, Latency: &latency, Txs: &txs})
+}
+
+// PayloadServed records getPayload serving the payload with the id, built at created.
+func (l *EventLog) PayloadServed(method string, id types.PayloadID, blockHash, parentHash common.Hash, timestamp uint64, created time.Time) {
+	if l == nil {
+		return
+	}
+	ev := Event{Type: EventPayloadServed, Method: method, Slot: l.slot(timestamp), BlockHash: &blockHash, ParentHash: &parentHash, PayloadID: &id}
+	if !created.IsZero() {
+		latency := Duration(time.Since(created))
+		ev.Latency = &latency
+	}
+	l.Emit(ev)
+}
+
+// NewPayloadReceived records the answer of the newPayload call started at start, it's deferred with the results
+// of the handler.
+func (l *EventLog) NewPayloadReceived(method string, start time.Time, blockHash, parentHash common.Hash, timestamp uint64, status **types.PayloadStatusV1, err *error) {
+	if l == nil {
+		return
+	}
+	latency := Duration(time.Since(start))
+	ev := Event{Type: EventNewPayloadReceived, Method: method, Slot: l.slot(timestamp), BlockHash: &blockHash, ParentHash: &parentHash, Latency: &latency}
+	if *err != nil {
+		ev.Error = (*err).Error()
+	} else if *status != nil {
+		ev.Status = string((*status).Status)
+	}
+	l.Emit(ev)
+}
+
+// FcuReceived records the answer of the forkchoiceUpdated call started at start, like NewPayloadReceived. The
+// timestamp is that of the payload to build, or else of the head block.
+func (l *EventLog) FcuReceived(method string, start time.Time, head common.Hash, timestamp uint64, result **types.ForkchoiceUpdatedResult, err *error) {
+	if l == nil {
+		return
+	}
+	latency := Duration(time.Since(start))
+	ev := Event{Type: EventFcuReceived, Method: method, BlockHash: &head, Latency: &latency}
+	if timestamp != 0 {
+		ev.Slot = l.slot(timestamp)
+	}
+	if *err != nil {
+		ev.Error = (*err).Error()
+	} else if *result != nil {
+		ev.Status, ev.PayloadID = string((*result).PayloadStatus.Status), (*result).PayloadID
+	}
+	l.Emit(ev)
+}
+
+// Reorg records a reorg journaled by the mock chain.
+func (l *EventLog) Reorg(entry JournalEntry, timestamp uint64) {
+	if l == nil {
+		return
+	}
+	l.Emit(Event{Type: EventReorg, Method: entry.Trigger, Slot: l.slot(timestamp), BlockHash: &entry.Block, ParentHash: &entry.Parent, OldHead: entry.OldHead, Depth: entry.Depth})
+}
+
+// Fault records the fault of the rule injected into a call.
+func (l *EventLog) Fault(method string, blockHash *common.Hash, timestamp uint64, rule *FaultRule) {
+	if l == nil {
+		return
+	}
+	ev := Event{Type: EventFaultInjected, Method: method, BlockHash: blockHash, Rule: rule.ID, Action: rule.Action, Status: string(rule.Status)}
+	if timestamp != 0 {
+		ev.Slot = l.slot(timestamp)
+	}
+	l.Emit(ev)
+}
+
+// Close closes the file of the event log, events emitted after are dropped.
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,65 @@
+package mergemock
+
+import (
+	"bufio"
+	"encoding/json"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.EventsPath = path
+	})
+	genesis := te.mockChain().CurrentHeader()
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, a))
+	b := te.buildPayload(t, genesis.Hash(), genesis.Time+24, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, b))
+	te.setHead(t, a.BlockHash)
+	te.setHead(t, b.BlockHash)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev), scanner.Text())
+		events = append(events, ev)
+	}
+	require.NoError(t, scanner.Err())
+
+	byType := make(map[string][]Event)
+	for _, ev := range events {
+		byType[ev.Type] = append(byType[ev.Type], ev)
+	}
+	require.Len(t, byType[EventPayloadBuilt], 2)
+	require.Len(t, byType[EventPayloadServed], 2)
+	require.Len(t, byType[EventNewPayloadReceived], 2)
+	built := byType[EventPayloadBuilt][0]
+	require.Equal(t, a.BlockHash, *built.BlockHash)
+	require.Equal(t, genesis.Hash(), *built.ParentHash)
+	require.Equal(t, uint64(1), *built.Slot)
+	require.NotNil(t, built.Latency)
+	imported := byType[EventNewPayloadReceived][1]
+	require.Equal(t, "engine_newPayloadV1", imported.Method)
+	require.Equal(t, b.BlockHash, *imported.BlockHash)
+	require.Equal(t, uint64(2), *imported.Slot)
+	require.Equal(t, string(types.ExecutionValid), imported.Status)
+	require.NotEmpty(t, byType[EventFcuReceived])
+
+	require.Len(t, byType[EventReorg], 1)
+	reorg := byType[EventReorg][0]
+	require.Equal(t, b.BlockHash, *reorg.BlockHash)
+	require.Equal(t, a.BlockHash, *reorg.OldHead)
+	require.Equal(t, uint64(1), reorg.Depth)
+	require.Equal(t, uint64(2), *reorg.Slot)
+}
@@ -0,0 +1,68 @@
+// Command flipstateroot is a response hook plugin flipping a byte of the state root of every 10th payload returned
+// by getPayload, for consensus clients to receive payloads the engine later rejects. Build it with
+//
+//	go build -buildmode=plugin -o flipstateroot.so ./examples/hooks/flipstateroot
+//
+// and load it with mergemock engine --hook.plugin flipstateroot.so.
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+
+	"mergemock/rpc"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// flipEvery is the interval of the payloads with a flipped state root.
+const flipEvery = 10
+
+type flipStateRoot struct {
+	payloads uint64
+}
+
+// NewResponseHook is the hook of the plugin.
+func NewResponseHook() (rpc.ResponseHook, error) {
+	return new(flipStateRoot), nil
+}
+
+func (f *flipStateRoot) Rewrite(call *rpc.HookedCall) error {
+	if !strings.HasPrefix(call.Method, "engine_getPayload") || call.Result == nil {
+		return nil
+	}
+	if atomic.AddUint64(&f.payloads, 1)%flipEvery != 0 {
+		return nil
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(call.Result, &result); err != nil {
+		return err
+	}
+	// since V2 the payload is wrapped in an envelope
+	payload := result
+	if enc, ok := result["executionPayload"]; ok {
+		if err := json.Unmarshal(enc, &payload); err != nil {
+			return err
+		}
+	}
+	var root common.Hash
+	if err := json.Unmarshal(payload["stateRoot"], &root); err != nil {
+		return err
+	}
+	root[0] ^= 0xff
+	enc, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	payload["stateRoot"] = enc
+	if _, ok := result["executionPayload"]; ok {
+		if result["executionPayload"], err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	call.Result, err = json.Marshal(result)
+	return err
+}
+
+func main() {}
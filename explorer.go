@@ -0,0 +1,194 @@
+package mergemock
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+//go:embed web/explorer.html
+var explorerPage []byte
+
+// Most blocks a page of the explorer lists.
+const maxExplorerBlocks = 100
+
+// ExplorerBlock is the summary of a canonical block listed by the explorer.
+type ExplorerBlock struct {
+	Number    uint64         `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Parent    common.Hash    `json:"parent"`
+	Timestamp uint64         `json:"timestamp"`
+	Miner     common.Address `json:"miner"`
+	Txs       int            `json:"txs"`
+	GasUsed   uint64         `json:"gasUsed"`
+	GasLimit  uint64         `json:"gasLimit"`
+	BaseFee   *hexutil.Big   `json:"baseFee,omitempty"`
+}
+
+// ExplorerTx is a transaction of a block shown by the explorer, with the outcome of its receipt.
+type ExplorerTx struct {
+	Hash    common.Hash     `json:"hash"`
+	From    common.Address  `json:"from"`
+	To      *common.Address `json:"to"`
+	Nonce   uint64          `json:"nonce"`
+	Value   *hexutil.Big    `json:"value"`
+	GasUsed uint64          `json:"gasUsed"`
+	Status  uint64          `json:"status"`
+	Logs    int             `json:"logs"`
+}
+
+// ExplorerBlockDetail is a block shown by the explorer, with its transactions and the journal of its mutations.
+type ExplorerBlockDetail struct {
+	ExplorerBlock
+	Canonical bool           `json:"canonical"`
+	TxList    []ExplorerTx   `json:"transactions"`
+	Journal   []JournalEntry `json:"journal"`
+}
+
+// NewExplorerHandler serves a read-only web UI of the mock chain, and the JSON it renders: the canonical blocks,
+// a block with its transactions, the journal of chain mutations and the forkchoice history.
+func NewExplorerHandler(e *EngineBackend) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(explorerPage)
+	})
+	mux.HandleFunc("/api/blocks", func(w http.ResponseWriter, r *http.Request) {
+		blocks, err := e.explorerBlocks(r.URL.Query().Get("before"), r.URL.Query().Get("count"))
+		writeExplorerJSON(w, blocks, err)
+	})
+	mux.HandleFunc("/api/block/", func(w http.ResponseWriter, r *http.Request) {
+		block, err := e.explorerBlock(strings.TrimPrefix(r.URL.Path, "/api/block/"))
+		writeExplorerJSON(w, block, err)
+	})
+	mux.HandleFunc("/api/journal", func(w http.ResponseWriter, r *http.Request) {
+		writeExplorerJSON(w, e.mockChain.journal.Entries(nil), nil)
+	})
+	mux.HandleFunc("/api/forkchoice", func(w http.ResponseWriter, r *http.Request) {
+		writeExplorerJSON(w, e.control.ForkchoiceHistory(), nil)
+	})
+	return readOnly(mux)
+}
+
+// readOnly refuses the requests that aren't reads.
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "read-only explorer", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeExplorerJSON(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// explorerBlocks lists count canonical blocks, newest first, from the one below the before number, or the head.
+func (e *EngineBackend) explorerBlocks(before, count string) ([]ExplorerBlock, error) {
+	head := e.mockChain.chain.CurrentBlock().NumberU64()
+	top := head
+	if before != "" {
+		n, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block number %q", before)
+		}
+		if n == 0 {
+			return []ExplorerBlock{}, nil
+		}
+		if n-1 < top {
+			top = n - 1
+		}
+	}
+	limit := uint64(20)
+	if count != "" {
+		n, err := strconv.ParseUint(count, 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid block count %q", count)
+		}
+		limit = n
+	}
+	if limit > maxExplorerBlocks {
+		limit = maxExplorerBlocks
+	}
+	blocks := []ExplorerBlock{}
+	for number := top; uint64(len(blocks)) < limit; number-- {
+		if block := e.mockChain.chain.GetBlockByNumber(number); block != nil {
+			blocks = append(blocks, e.explorerSummary(block))
+		}
+		if number == 0 {
+			break
+		}
+	}
+	return blocks, nil
+}
+
+func (e *EngineBackend) explorerSummary(block *ethTypes.Block) ExplorerBlock {
+	summary := ExplorerBlock{
+		Number:    block.NumberU64(),
+		Hash:      e.mockChain.SpecHash(block.Hash()),
+		Parent:    e.mockChain.SpecHash(block.ParentHash()),
+		Timestamp: block.Time(),
+		Miner:     block.Coinbase(),
+		Txs:       len(block.Transactions()),
+		GasUsed:   block.GasUsed(),
+		GasLimit:  block.GasLimit(),
+	}
+	if baseFee := block.BaseFee(); baseFee != nil {
+		summary.BaseFee = (*hexutil.Big)(baseFee)
+	}
+	return summary
+}
+
+// explorerBlock returns the block of the spec hash or number, canonical or not.
+func (e *EngineBackend) explorerBlock(id string) (*ExplorerBlockDetail, error) {
+	chain := e.mockChain.chain
+	var block *ethTypes.Block
+	if strings.HasPrefix(id, "0x") && len(id) == 2+2*common.HashLength {
+		block = chain.GetBlockByHash(e.mockChain.ResolveHash(common.HexToHash(id)))
+	} else if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		block = chain.GetBlockByNumber(n)
+	} else {
+		return nil, fmt.Errorf("invalid block %q, expected a hash or number", id)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("unknown block %s", id)
+	}
+	specHash := e.mockChain.SpecHash(block.Hash())
+	detail := &ExplorerBlockDetail{
+		ExplorerBlock: e.explorerSummary(block),
+		Canonical:     chain.GetCanonicalHash(block.NumberU64()) == block.Hash(),
+		TxList:        []ExplorerTx{},
+		Journal:       e.mockChain.journal.Entries(&specHash),
+	}
+	signer := ethTypes.MakeSigner(chain.Config(), block.Number())
+	receipts := chain.GetReceiptsByHash(block.Hash())
+	for i, tx := range block.Transactions() {
+		from, _ := ethTypes.Sender(signer, tx)
+		etx := ExplorerTx{Hash: tx.Hash(), From: from, To: tx.To(), Nonce: tx.Nonce(), Value: (*hexutil.Big)(new(big.Int).Set(tx.Value()))}
+		if i < len(receipts) {
+			etx.GasUsed, etx.Status, etx.Logs = receipts[i].GasUsed, receipts[i].Status, len(receipts[i].Logs)
+		}
+		detail.TxList = append(detail.TxList, etx)
+	}
+	return detail, nil
+}
@@ -0,0 +1,62 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplorer(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeTransfer
+		cfg.GasTarget = 1
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildAndImport(t, nil, 1)
+	te.setHead(t, payload.BlockHash)
+	srv := httptest.NewServer(NewExplorerHandler(te.backend))
+	defer srv.Close()
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get("/", nil))
+	var blocks []ExplorerBlock
+	require.Equal(t, http.StatusOK, get("/api/blocks", &blocks))
+	require.Len(t, blocks, 2, "the head and genesis")
+	require.Equal(t, payload.BlockHash, blocks[0].Hash)
+	require.Equal(t, genesis.Hash(), blocks[1].Hash)
+	require.Equal(t, http.StatusOK, get("/api/blocks?before=1", &blocks))
+	require.Len(t, blocks, 1)
+	require.Equal(t, uint64(0), blocks[0].Number)
+
+	var block ExplorerBlockDetail
+	require.Equal(t, http.StatusOK, get("/api/block/"+payload.BlockHash.Hex(), &block))
+	require.True(t, block.Canonical)
+	require.Len(t, block.TxList, len(decodeTxs(t, payload)))
+	require.Equal(t, uint64(1), block.TxList[0].Status)
+	require.NotEmpty(t, block.Journal)
+	require.Equal(t, http.StatusOK, get("/api/block/1", &block))
+	require.Equal(t, payload.BlockHash, block.Hash)
+	require.Equal(t, http.StatusNotFound, get("/api/block/2", nil))
+	require.Equal(t, http.StatusNotFound, get("/api/block/head", nil))
+
+	var history []ForkchoiceRecord
+	require.Equal(t, http.StatusOK, get("/api/forkchoice", &history))
+	require.Equal(t, payload.BlockHash, history[len(history)-1].HeadBlockHash)
+
+	resp, err := http.Post(srv.URL+"/api/blocks", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "the explorer is read-only")
+}
@@ -0,0 +1,395 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"mergemock/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Fault actions. Status, latest-valid-hash and requests-hash faults replace the response of a call that is
+// still processed, beacon-root faults one of its parameters, the others replace the call itself.
+const (
+	FaultStatus          = "status"            // respond with the payload status of the rule
+	FaultLatestValidHash = "latest-valid-hash" // respond with a latest valid hash that isn't a known block
+	FaultError           = "error"             // fail with the JSON-RPC error code and message of the rule
+	FaultTimeout         = "timeout"           // respond normally after the delay of the rule
+	FaultDrop            = "drop"              // never respond, until the client gives up on the call
+	FaultRequestsHash    = "requests-hash"     // serve getPayloadV4 execution requests that don't match the requests hash of the block
+	FaultBeaconRoot      = "beacon-root"       // replace the parent beacon block root of forkchoiceUpdatedV3 attributes and newPayloadV3/V4 calls
+)
+
+// malformedHash is the latest valid hash of latest-valid-hash faults, no chain has a block with it.
+var malformedHash = common.HexToHash("0xbad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0bad0")
+
+// malformedRequest is the execution request added by requests-hash faults, of a type no fork defines.
+var malformedRequest = []byte{0xff, 0xba, 0xd0}
+
+type FaultConfig struct {
+	Rules []string `ask:"--rule" help:"Fault rules, as ';' separated key=value pairs, e.g. method=engine_newPayloadV1;action=status;status=SYNCING;count=3"`
+	Seed  int64    `ask:"--seed" help:"Seed of the probabilities of fault rules (0 for a random seed)"`
+}
+
+// FaultRule injects a fault into the engine calls it matches.
+type FaultRule struct {
+	ID uint64 `json:"id"`
+	// Method is the JSON-RPC method to match, any engine method if empty.
+	Method string `json:"method"`
+	// BlockHash is the block to match, the payload of newPayload and the head of forkchoiceUpdated.
+	BlockHash *common.Hash `json:"blockHash,omitempty"`
+	// After is the number of matching calls to let through before the fault is injected.
+	After uint64 `json:"after"`
+	// Count is the number of calls the fault is injected into, unlimited if 0.
+	Count uint64 `json:"count"`
+	// Probability is the chance of the fault being injected into a call it is due for, always if 0.
+	Probability float64 `json:"probability,omitempty"`
+
+	Action  string                     `json:"action"`
+	Status  types.ExecutePayloadStatus `json:"status,omitempty"`
+	Code    int                        `json:"code,omitempty"`
+	Message string                     `json:"message,omitempty"`
+	Delay   Duration                   `json:"delay,omitempty"`
+
+	// Matched is the number of calls the rule matched so far, Injected the number of faults it injected.
+	Matched  uint64 `json:"matched"`
+	Injected uint64 `json:"injected"`
+}
+
+// Duration is a time.Duration that is encoded as a string in JSON, like the flags.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(input []byte) error {
+	v, err := time.ParseDuration(string(input))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseFaultRule parses a rule of ';' separated key=value pairs.
+func ParseFaultRule(s string) (*FaultRule, error) {
+	rule := new(FaultRule)
+	for _, field := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault rule field %q, expected key=value", field)
+		}
+		var err error
+		switch key {
+		case "method":
+			rule.Method = value
+		case "block":
+			var hash common.Hash
+			err = hash.UnmarshalText([]byte(value))
+			rule.BlockHash = &hash
+		case "after":
+			rule.After, err = strconv.ParseUint(value, 10, 64)
+		case "count":
+			rule.Count, err = strconv.ParseUint(value, 10, 64)
+		case "probability":
+			rule.Probability, err = strconv.ParseFloat(value, 64)
+		case "action":
+			rule.Action = value
+		case "status":
+			rule.Status = types.ExecutePayloadStatus(value)
+		case "code":
+			rule.Code, err = strconv.Atoi(value)
+		case "message":
+			rule.Message = value
+		case "delay":
+			err = rule.Delay.UnmarshalText([]byte(value))
+		default:
+			return nil, fmt.Errorf("unknown fault rule field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault rule field %s: %v", key, err)
+		}
+	}
+	return rule, rule.validate()
+}
+
+func (r *FaultRule) validate() error {
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("fault probability %v out of range [0, 1]", r.Probability)
+	}
+	switch r.Action {
+	case FaultStatus:
+		switch r.Status {
+		case types.ExecutionValid, types.ExecutionInvalid, types.ExecutionSyncing, types.ExecutionAccepted,
+			types.ExecutionInvalidBlockHash, types.ExecutionInvalidTerminalBlock:
+		default:
+			return fmt.Errorf("invalid payload status %q of status fault", r.Status)
+		}
+	case FaultError:
+		if r.Code == 0 {
+			return fmt.Errorf("missing error code of error fault")
+		}
+	case FaultTimeout:
+		if r.Delay <= 0 {
+			return fmt.Errorf("missing delay of timeout fault")
+		}
+	case FaultLatestValidHash, FaultDrop, FaultRequestsHash, FaultBeaconRoot:
+	default:
+		return fmt.Errorf("unknown fault action %q", r.Action)
+	}
+	return nil
+}
+
+func (r *FaultRule) matches(method string, blockHash *common.Hash) bool {
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	return r.BlockHash == nil || (blockHash != nil && *r.BlockHash == *blockHash)
+}
+
+// injectedError is the JSON-RPC error of an error fault.
+type injectedError struct {
+	code    int
+	message string
+}
+
+func (e *injectedError) Error() string  { return e.message }
+func (e *injectedError) ErrorCode() int { return e.code }
+
+// FaultInjector holds the fault rules, which can be changed while the engine runs.
+type FaultInjector struct {
+	log logrus.Ext1FieldLogger
+
+	mu     sync.Mutex
+	rules  []*FaultRule
+	nextID uint64
+	rng    *rand.Rand
+	paused bool
+}
+
+func NewFaultInjector(log logrus.Ext1FieldLogger, seed int64) *FaultInjector {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultInjector{log: log, nextID: 1, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (f *FaultInjector) Add(rule *FaultRule) (uint64, error) {
+	if err := rule.validate(); err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(rule), nil
+}
+
+func (f *FaultInjector) add(rule *FaultRule) uint64 {
+	rule.ID, rule.Matched, rule.Injected = f.nextID, 0, 0
+	f.nextID++
+	f.rules = append(f.rules, rule)
+	return rule.ID
+}
+
+// Replace removes the rules with the ids and adds the rules at once, returning the ids of the added rules.
+// Nothing changes if one of the rules is invalid.
+func (f *FaultInjector) Replace(ids []uint64, rules []*FaultRule) ([]uint64, error) {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	removed := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := make([]*FaultRule, 0, len(f.rules)+len(rules))
+	for _, rule := range f.rules {
+		if !removed[rule.ID] {
+			kept = append(kept, rule)
+		}
+	}
+	f.rules = kept
+	added := make([]uint64, 0, len(rules))
+	for _, rule := range rules {
+		added = append(added, f.add(rule))
+	}
+	return added, nil
+}
+
+// Remove deletes the rule with the id, and reports whether there was one.
+func (f *FaultInjector) Remove(id uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, rule := range f.rules {
+		if rule.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// SetPaused pauses or resumes injecting faults. Calls aren't counted against the rules while paused.
+func (f *FaultInjector) SetPaused(paused bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused = paused
+}
+
+func (f *FaultInjector) Paused() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused
+}
+
+// Rules returns copies of the rules, with their counters.
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rules := make([]FaultRule, 0, len(f.rules))
+	for _, rule := range f.rules {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// Before returns the first rule to inject into the call to the method, if any.
+// Error, timeout and drop faults are carried out right away, the others are applied to the response of the handler.
+func (f *FaultInjector) Before(ctx context.Context, method string, blockHash *common.Hash) (*Fault, error) {
+	return f.Inject(ctx, method, f.Match(method, blockHash))
+}
+
+// Match counts the call to the method against the rules it matches, and returns a copy of the first one
+// whose fault is due, nil if none is.
+func (f *FaultInjector) Match(method string, blockHash *common.Hash) *FaultRule {
+	if f == nil {
+		return nil
+	}
+	var fault *FaultRule
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paused {
+		return nil
+	}
+	for _, rule := range f.rules {
+		if !rule.matches(method, blockHash) {
+			continue
+		}
+		rule.Matched++
+		due := rule.Matched > rule.After && (rule.Count == 0 || rule.Injected < rule.Count)
+		if fault == nil && due && (rule.Probability == 0 || f.rng.Float64() < rule.Probability) {
+			rule.Injected++
+			copied := *rule
+			fault = &copied
+		}
+	}
+	return fault
+}
+
+// Inject carries out the fault of a matched rule, see Before.
+func (f *FaultInjector) Inject(ctx context.Context, method string, fault *FaultRule) (*Fault, error) {
+	if fault == nil {
+		return nil, nil
+	}
+	f.log.WithFields(logrus.Fields{"method": method, "rule": fault.ID, "action": fault.Action}).Warn("Injecting fault")
+	switch fault.Action {
+	case FaultError:
+		return nil, &injectedError{code: fault.Code, message: fault.Message}
+	case FaultTimeout:
+		select {
+		case <-time.After(time.Duration(fault.Delay)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	case FaultDrop:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &Fault{fault}, nil
+}
+
+// Fault is a rule injected into a call, whose response it may replace.
+type Fault struct {
+	rule *FaultRule
+}
+
+// PayloadStatus replaces the status of a successful call, if the fault changes statuses.
+func (f *Fault) PayloadStatus(status *types.PayloadStatusV1, err error) (*types.PayloadStatusV1, error) {
+	if f == nil || err != nil {
+		return status, err
+	}
+	switch f.rule.Action {
+	case FaultStatus:
+		return &types.PayloadStatusV1{Status: f.rule.Status, LatestValidHash: status.LatestValidHash, ValidationError: "injected fault"}, nil
+	case FaultLatestValidHash:
+		hash := malformedHash
+		return &types.PayloadStatusV1{Status: status.Status, LatestValidHash: &hash, ValidationError: status.ValidationError}, nil
+	}
+	return status, nil
+}
+
+// ForkchoiceUpdated replaces the payload status of a successful call. A payload is only
+// returned with a VALID status, as a syncing or invalid engine can't build on the head.
+func (f *Fault) ForkchoiceUpdated(result *types.ForkchoiceUpdatedResult, err error) (*types.ForkchoiceUpdatedResult, error) {
+	if f == nil || err != nil {
+		return result, err
+	}
+	status, _ := f.PayloadStatus(&result.PayloadStatus, nil)
+	updated := &types.ForkchoiceUpdatedResult{PayloadStatus: *status, PayloadID: result.PayloadID}
+	if status.Status != types.ExecutionValid {
+		updated.PayloadID = nil
+	}
+	return updated, nil
+}
+
+// ExecutionRequests replaces the execution requests of a served payload, if the fault corrupts them: a request
+// is added, so they no longer hash to the requests hash the block hash commits to.
+func (f *Fault) ExecutionRequests(requests types.ExecutionRequests) types.ExecutionRequests {
+	if f == nil || f.rule.Action != FaultRequestsHash {
+		return requests
+	}
+	return append(append(types.ExecutionRequests{}, requests...), malformedRequest)
+}
+
+// ParentBeaconRoot replaces the parent beacon block root of a call, if the fault tampers with it: payloads are then
+// built with another root than requested, and imported ones fail the block hash check.
+func (f *Fault) ParentBeaconRoot(root common.Hash) common.Hash {
+	if f == nil || f.rule.Action != FaultBeaconRoot {
+		return root
+	}
+	return malformedHash
+}
+
+// InjectFault adds a fault rule at runtime, and returns its id.
+func (b *MockBackend) InjectFault(ctx context.Context, rule FaultRule) (uint64, error) {
+	return b.engine.faults.Add(&rule)
+}
+
+// RemoveFault removes the fault rule with the id, and reports whether there was one.
+func (b *MockBackend) RemoveFault(ctx context.Context, id uint64) bool {
+	return b.engine.faults.Remove(id)
+}
+
+func (b *MockBackend) ClearFaults(ctx context.Context) {
+	b.engine.faults.Clear()
+}
+
+// Faults returns the fault rules, with how often they matched and injected a fault.
+func (b *MockBackend) Faults(ctx context.Context) []FaultRule {
+	return b.engine.faults.Rules()
+}
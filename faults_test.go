@@ -0,0 +1,56 @@
+package mergemock
+
+import (
+	"mergemock/types"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaultRule(t *testing.T) {
+	hash := common.Hash{0x01}
+	rule, err := ParseFaultRule("method=engine_newPayloadV1;block=" + hash.Hex() + ";after=2;count=3;action=status;status=SYNCING")
+	require.NoError(t, err)
+	require.Equal(t, &FaultRule{Method: "engine_newPayloadV1", BlockHash: &hash, After: 2, Count: 3, Action: FaultStatus, Status: types.ExecutionSyncing}, rule)
+
+	rule, err = ParseFaultRule("action=timeout;delay=2s")
+	require.NoError(t, err)
+	require.Equal(t, Duration(2*time.Second), rule.Delay)
+
+	for _, invalid := range []string{
+		"action=status;status=MAYBE",
+		"action=error",
+		"action=timeout",
+		"action=explode",
+		"method",
+		"count=many;action=drop",
+		"probability=1.5;action=drop",
+	} {
+		_, err := ParseFaultRule(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestFaultProbability(t *testing.T) {
+	f := NewFaultInjector(logrus.New(), 1)
+	_, err := f.Add(&FaultRule{Method: "engine_newPayloadV1", Probability: 1e-12, Action: FaultDrop})
+	require.NoError(t, err)
+	_, err = f.Add(&FaultRule{Method: "engine_newPayloadV1", Probability: 1, Count: 2, Action: FaultStatus, Status: types.ExecutionSyncing})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		rule := f.Match("engine_newPayloadV1", nil)
+		if i < 2 {
+			require.NotNil(t, rule, "call %d", i)
+			require.Equal(t, FaultStatus, rule.Action, "unlikely faults let later rules match")
+		} else {
+			require.Nil(t, rule)
+		}
+	}
+	rules := f.Rules()
+	require.Equal(t, uint64(3), rules[0].Matched)
+	require.Zero(t, rules[0].Injected)
+	require.Equal(t, uint64(2), rules[1].Injected)
+}
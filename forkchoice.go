@@ -0,0 +1,78 @@
+package mergemock
+
+import (
+	"fmt"
+	"mergemock/api"
+	"mergemock/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+// IsAncestor reports whether the block with the ancestor spec hash is the block with the descendant spec hash,
+// or one of its ancestors. Unknown blocks are ancestors of none.
+func (c *MockChain) IsAncestor(ancestor, descendant common.Hash) bool {
+	target := c.chain.GetHeaderByHash(c.ResolveHash(ancestor))
+	header := c.chain.GetHeaderByHash(c.ResolveHash(descendant))
+	if target == nil || header == nil {
+		return false
+	}
+	for header != nil && header.Number.Cmp(target.Number) > 0 {
+		header = c.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return header != nil && header.Hash() == target.Hash()
+}
+
+// SetHead makes the block with the spec hash the canonical head, reorging if it's on another branch. It returns
+// false without changing the chain if the block already is the head, or a canonical block below it.
+func (c *MockChain) SetHead(specHash common.Hash, trigger string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block := c.chain.GetBlockByHash(c.ResolveHash(specHash))
+	if block == nil {
+		return false, fmt.Errorf("unknown block %s", specHash)
+	}
+	if c.chain.GetCanonicalHash(block.NumberU64()) == block.Hash() && block.NumberU64() <= c.chain.CurrentBlock().NumberU64() {
+		return false, nil
+	}
+	head := c.chain.CurrentBlock()
+	if err := c.chain.SetChainHead(block); err != nil {
+		return false, fmt.Errorf("failed to set chain head: %v", err)
+	}
+	c.recordHead(trigger, head, block)
+	return true, nil
+}
+
+// applyForkchoice validates the forkchoice state and makes its head the canonical head. It returns the status to
+// answer with right away, without preparing a payload, if the head is unknown, or a canonical block below the head:
+// updates to those are skipped as the spec allows. Safe and finalized blocks that are unknown or not ancestors of
+// the head make the forkchoice state invalid.
+func (e *EngineBackend) applyForkchoice(heads *types.ForkchoiceStateV1) (*types.PayloadStatusV1, error) {
+	if e.mockChain.chain.GetHeaderByHash(e.mockChain.ResolveHash(heads.HeadBlockHash)) == nil {
+		e.log.WithField("head", heads.HeadBlockHash).Warn("Unknown forkchoice head, syncing")
+		return &types.PayloadStatusV1{Status: types.ExecutionSyncing}, nil
+	}
+	for _, b := range []struct {
+		name string
+		hash common.Hash
+	}{{"safe", heads.SafeBlockHash}, {"finalized", heads.FinalizedBlockHash}} {
+		if b.hash != (common.Hash{}) && !e.mockChain.IsAncestor(b.hash, heads.HeadBlockHash) {
+			return nil, api.NewInvalidForkchoiceStateError("%s block %s is unknown or not an ancestor of head %s", b.name, b.hash, heads.HeadBlockHash)
+		}
+	}
+	if heads.SafeBlockHash != (common.Hash{}) && heads.FinalizedBlockHash != (common.Hash{}) &&
+		!e.mockChain.IsAncestor(heads.FinalizedBlockHash, heads.SafeBlockHash) {
+		return nil, api.NewInvalidForkchoiceStateError("finalized block %s is not an ancestor of safe block %s", heads.FinalizedBlockHash, heads.SafeBlockHash)
+	}
+	updated, err := e.mockChain.SetHead(heads.HeadBlockHash, "engine_forkchoiceUpdated")
+	if err != nil {
+		return nil, err
+	}
+	if updated {
+		e.log.WithField("head", heads.HeadBlockHash).Info("Updated canonical head")
+	} else if current := e.mockChain.SpecHash(e.mockChain.CurrentHeader().Hash()); current != heads.HeadBlockHash {
+		e.log.WithFields(logrus.Fields{"head": heads.HeadBlockHash, "canonical_head": current}).Info("Head is a canonical ancestor, skipping forkchoice update")
+		return &types.PayloadStatusV1{Status: types.ExecutionValid, LatestValidHash: &heads.HeadBlockHash}, nil
+	}
+	return nil, nil
+}
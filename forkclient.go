@@ -0,0 +1,108 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+// engineForks are the forks of the engine API, in order, with the version of the methods they introduced.
+var engineForks = []string{ForkParis, ForkShanghai, ForkCancun, ForkPrague}
+
+// forkClient calls the engine methods of the version of a fork, as a consensus client does: V1 in Paris, V2 in
+// Shanghai, V3 in Cancun, and V3 forkchoiceUpdated with V4 getPayload and newPayload in Prague.
+type forkClient struct {
+	client *rpc.Client
+	log    logrus.Ext1FieldLogger
+	fork   string
+}
+
+// forkchoiceUpdated updates the forkchoice, starting a build if the attributes aren't nil. The attributes are cut
+// down to the fields of the version: withdrawals from Shanghai on, the parent beacon block root from Cancun on.
+func (f forkClient) forkchoiceUpdated(ctx context.Context, head, safe, finalized common.Hash, attributes *types.PayloadAttributesV3) (types.ForkchoiceUpdatedResult, error) {
+	switch f.fork {
+	case ForkParis:
+		var v1 *types.PayloadAttributesV1
+		if attributes != nil {
+			v1 = &types.PayloadAttributesV1{Timestamp: attributes.Timestamp, PrevRandao: attributes.PrevRandao, SuggestedFeeRecipient: attributes.SuggestedFeeRecipient}
+		}
+		return api.ForkchoiceUpdatedV1(ctx, f.client, f.log, head, safe, finalized, v1)
+	case ForkShanghai:
+		var v2 *types.PayloadAttributesV2
+		if attributes != nil {
+			v2 = &types.PayloadAttributesV2{Timestamp: attributes.Timestamp, PrevRandao: attributes.PrevRandao, SuggestedFeeRecipient: attributes.SuggestedFeeRecipient, Withdrawals: attributes.Withdrawals}
+		}
+		return api.ForkchoiceUpdatedV2(ctx, f.client, f.log, head, safe, finalized, v2)
+	default:
+		return api.ForkchoiceUpdatedV3(ctx, f.client, f.log, head, safe, finalized, attributes)
+	}
+}
+
+// importPayload retrieves the payload of the id and imports it, with the parent beacon block root from Cancun on.
+// It returns the block hash and parent hash of the payload, and the status of its import.
+func (f forkClient) importPayload(ctx context.Context, id types.PayloadID, parentBeaconRoot common.Hash) (hash, parent common.Hash, status *types.PayloadStatusV1, err error) {
+	switch f.fork {
+	case ForkParis:
+		payload, err := api.GetPayloadV1(ctx, f.client, f.log, id)
+		if err != nil {
+			return common.Hash{}, common.Hash{}, nil, err
+		}
+		status, err = api.NewPayloadV1(ctx, f.client, f.log, payload)
+		return payload.BlockHash, payload.ParentHash, status, err
+	case ForkShanghai:
+		envelope, err := api.GetPayloadV2(ctx, f.client, f.log, id)
+		if err != nil {
+			return common.Hash{}, common.Hash{}, nil, err
+		}
+		payload := envelope.ExecutionPayload
+		status, err = api.NewPayloadV2(ctx, f.client, f.log, payload)
+		return payload.BlockHash, payload.ParentHash, status, err
+	case ForkCancun:
+		envelope, err := api.GetPayloadV3(ctx, f.client, f.log, id)
+		if err != nil {
+			return common.Hash{}, common.Hash{}, nil, err
+		}
+		payload := envelope.ExecutionPayload
+		status, err = api.NewPayloadV3(ctx, f.client, f.log, payload, []common.Hash{}, parentBeaconRoot)
+		return payload.BlockHash, payload.ParentHash, status, err
+	case ForkPrague:
+		envelope, err := api.GetPayloadV4(ctx, f.client, f.log, id)
+		if err != nil {
+			return common.Hash{}, common.Hash{}, nil, err
+		}
+		payload := envelope.ExecutionPayload
+		status, err = api.NewPayloadV4(ctx, f.client, f.log, payload, []common.Hash{}, parentBeaconRoot, envelope.ExecutionRequests)
+		return payload.BlockHash, payload.ParentHash, status, err
+	default:
+		return common.Hash{}, common.Hash{}, nil, fmt.Errorf("unknown fork %q", f.fork)
+	}
+}
+
+// activateFork sets the fork times of the config so that the fork is active from genesis on, and later forks never.
+func activateFork(forks *ForkTimesConfig, fork string) error {
+	active := false
+	for i := len(engineForks) - 1; i >= 0; i-- {
+		active = active || engineForks[i] == fork
+		value := "none"
+		if active {
+			value = "0"
+		}
+		switch engineForks[i] {
+		case ForkShanghai:
+			forks.Shanghai = value
+		case ForkCancun:
+			forks.Cancun = value
+		case ForkPrague:
+			forks.Prague = value
+		}
+	}
+	if !active {
+		return fmt.Errorf("unknown fork %q, expected %s, %s, %s or %s", fork, ForkParis, ForkShanghai, ForkCancun, ForkPrague)
+	}
+	return nil
+}
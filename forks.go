@@ -0,0 +1,395 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	mmTypes "mergemock/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/sirupsen/logrus"
+)
+
+// The geth version in use predates Shanghai: its headers have none of the fields added since, so blocks
+// of later forks are stored in geth under a different hash than the one consensus clients know them by.
+// The translation between the two, and the fork fields themselves, are kept next to the chain data.
+// Blocks before Shanghai have the same hash in both, and have no entries.
+var (
+	specToGethHashPrefix = []byte("mergemock-spec-to-geth-") // spec hash -> geth hash
+	gethToSpecHashPrefix = []byte("mergemock-geth-to-spec-") // geth hash -> spec hash
+	forkFieldsPrefix     = []byte("mergemock-fork-fields-")  // geth hash -> RLP encoded fork fields
+)
+
+func dbKey(prefix []byte, hash common.Hash) []byte {
+	return append(append([]byte{}, prefix...), hash[:]...)
+}
+
+func readHash(db ethdb.KeyValueReader, prefix []byte, hash common.Hash) common.Hash {
+	enc, err := db.Get(dbKey(prefix, hash))
+	if err != nil || len(enc) != common.HashLength {
+		return hash
+	}
+	return common.BytesToHash(enc)
+}
+
+func readForkFields(db ethdb.KeyValueReader, gethHash common.Hash) *mmTypes.ForkFields {
+	enc, err := db.Get(dbKey(forkFieldsPrefix, gethHash))
+	if err != nil {
+		return nil
+	}
+	var fork mmTypes.ForkFields
+	if err := rlp.DecodeBytes(enc, &fork); err != nil {
+		return nil
+	}
+	if fork.Withdrawals == nil {
+		fork.Withdrawals = []*mmTypes.Withdrawal{}
+	}
+	return &fork
+}
+
+func readWithdrawals(db ethdb.KeyValueReader, gethHash common.Hash) []*mmTypes.Withdrawal {
+	if fork := readForkFields(db, gethHash); fork != nil {
+		return fork.Withdrawals
+	}
+	return nil
+}
+
+// applyWithdrawals credits the withdrawn amounts, which are denominated in Gwei.
+func applyWithdrawals(statedb *state.StateDB, withdrawals []*mmTypes.Withdrawal) {
+	for _, w := range withdrawals {
+		amount := new(big.Int).Mul(new(big.Int).SetUint64(w.Amount), big.NewInt(params.GWei))
+		statedb.AddBalance(w.Address, amount)
+	}
+}
+
+// EIP-2935 block hash history contract, whose storage ring buffer the system call of Prague blocks writes the
+// parent hash to.
+var historyStorageAddress = common.HexToAddress("0x0000F90827F1C53a10cb7A02335B175320002935")
+
+const historyServeWindow = 8191
+
+// applyBlockHashHistory stores the spec hash of the parent of the block in the history contract, as the EIP-2935
+// system call does. Without code at the contract address, e.g. in a genesis that doesn't deploy it, nothing is stored.
+func applyBlockHashHistory(statedb *state.StateDB, number uint64, parentHash common.Hash) {
+	if statedb.GetCodeSize(historyStorageAddress) == 0 {
+		return
+	}
+	slot := common.BigToHash(new(big.Int).SetUint64((number - 1) % historyServeWindow))
+	statedb.SetState(historyStorageAddress, slot, parentHash)
+}
+
+// EIP-4788 beacon roots contract, whose storage ring buffers the system call of Cancun blocks writes the timestamp
+// and the parent beacon block root to.
+var beaconRootsAddress = common.HexToAddress("0x000F3df6D732807Ef1319fB7B8bB8522d0Beac02")
+
+const historyBufferLength = 8191
+
+// applyBeaconRoot stores the timestamp of the block and its parent beacon block root in the beacon roots contract, as
+// the EIP-4788 system call does. Like the block hash history, nothing is stored without code at the contract address.
+func applyBeaconRoot(statedb *state.StateDB, timestamp uint64, root common.Hash) {
+	if statedb.GetCodeSize(beaconRootsAddress) == 0 {
+		return
+	}
+	slot := timestamp % historyBufferLength
+	statedb.SetState(beaconRootsAddress, common.BigToHash(new(big.Int).SetUint64(slot)), common.BigToHash(new(big.Int).SetUint64(timestamp)))
+	statedb.SetState(beaconRootsAddress, common.BigToHash(new(big.Int).SetUint64(slot+historyBufferLength)), root)
+}
+
+// ParentBeaconRoot returns the parent beacon block root the block with the spec hash was built or imported with, nil
+// for unknown blocks and blocks before Cancun.
+func (c *MockChain) ParentBeaconRoot(specHash common.Hash) *common.Hash {
+	fork := c.ForkFields(c.ResolveHash(specHash))
+	if fork == nil {
+		return nil
+	}
+	return fork.ParentBeaconRoot
+}
+
+// GetParentBeaconBlockRoot returns the parent beacon block root of the block with the hash, null for unknown blocks
+// and blocks before Cancun.
+func (b *MockBackend) GetParentBeaconBlockRoot(ctx context.Context, blockHash common.Hash) *common.Hash {
+	return b.engine.mockChain.ParentBeaconRoot(blockHash)
+}
+
+// forkTimes are the timestamp based forks of the genesis chain config, which geth doesn't parse yet.
+type forkTimes struct {
+	ShanghaiTime *uint64 `json:"shanghaiTime"`
+	CancunTime   *uint64 `json:"cancunTime"`
+	PragueTime   *uint64 `json:"pragueTime"`
+
+	BlobSchedule map[string]blobConfig `json:"blobSchedule"`
+	// Blobs is the number of blobs the blob gas used of blocks accounts for, set with --fork.blobs. Blocks never
+	// include blob transactions, so any other value than 0 makes invalid blocks on purpose.
+	Blobs uint64 `json:"-"`
+}
+
+// blobConfig is an entry of the EIP-7840 blob schedule of the genesis chain config.
+type blobConfig struct {
+	Target         uint64 `json:"target"`
+	Max            uint64 `json:"max"`
+	UpdateFraction uint64 `json:"baseFeeUpdateFraction"`
+}
+
+// defaultBlobSchedule are the blob parameters of the forks the blob schedule of the genesis has no entry for.
+var defaultBlobSchedule = map[string]blobConfig{
+	ForkCancun: {Target: mmTypes.TargetBlobsPerBlock, Max: mmTypes.MaxBlobsPerBlock, UpdateFraction: mmTypes.BlobGasPriceUpdateFraction},
+	ForkPrague: {Target: 6, Max: 9, UpdateFraction: mmTypes.BlobGasPriceUpdateFractionPrague},
+}
+
+// blobConfig returns the blob parameters of the fork, with the default update fraction of the fork if its entry
+// has none.
+func (f *forkTimes) blobConfig(fork string) blobConfig {
+	cfg, ok := f.BlobSchedule[fork]
+	if !ok {
+		return defaultBlobSchedule[fork]
+	}
+	if cfg.UpdateFraction == 0 {
+		cfg.UpdateFraction = defaultBlobSchedule[fork].UpdateFraction
+	}
+	return cfg
+}
+
+// Names of the forks, as logged.
+const (
+	ForkParis    = "paris"
+	ForkShanghai = "shanghai"
+	ForkCancun   = "cancun"
+	ForkPrague   = "prague"
+)
+
+// ForkTimesConfig overrides the activation timestamps of the genesis forks, and the terminal total difficulty.
+type ForkTimesConfig struct {
+	TTD      string `ask:"--ttd" help:"Terminal total difficulty the chain transitions to proof-of-stake at instead of config.terminalTotalDifficulty of the genesis, with proof-of-work blocks mined until it is reached, unlike --transition.ttd which only changes the reported one (decimal or 0x-prefixed hex, genesis value if empty)"`
+	Shanghai string `ask:"--shanghai-time" help:"Timestamp Shanghai activates at instead of config.shanghaiTime of the genesis: a unix timestamp, +duration after the start of the engine, or none to deactivate it (genesis value if empty)"`
+	Cancun   string `ask:"--cancun-time" help:"Timestamp Cancun activates at instead of config.cancunTime of the genesis, like --shanghai-time"`
+	Prague   string `ask:"--prague-time" help:"Timestamp Prague activates at instead of config.pragueTime of the genesis, like --shanghai-time"`
+
+	BlobTarget uint64 `ask:"--blob-target" help:"Target blobs per block of all blob forks instead of config.blobSchedule of the genesis (fork default if 0)"`
+	BlobMax    uint64 `ask:"--blob-max" help:"Maximum blobs per block of all blob forks instead of config.blobSchedule of the genesis (fork default if 0)"`
+	Blobs      uint64 `ask:"--blobs" help:"Fault: blobs the blob gas used of every block from Cancun on accounts for without any blob transaction, to drive the excess blob gas, at most the maximum blobs per block. Real execution clients reject these blocks"`
+}
+
+// applyTTD overrides the terminal total difficulty of the chain config.
+func (c *ForkTimesConfig) applyTTD(config *params.ChainConfig) error {
+	if c.TTD == "" {
+		return nil
+	}
+	ttd, ok := math.ParseBig256(c.TTD)
+	if !ok {
+		return fmt.Errorf("invalid terminal total difficulty %q", c.TTD)
+	}
+	config.TerminalTotalDifficulty = ttd
+	return nil
+}
+
+// apply overrides the fork times that are set in the config, with relative times counted from now.
+func (c *ForkTimesConfig) apply(forks *forkTimes, now time.Time) error {
+	for _, o := range []struct {
+		name     string
+		value    string
+		override **uint64
+	}{
+		{ForkShanghai, c.Shanghai, &forks.ShanghaiTime},
+		{ForkCancun, c.Cancun, &forks.CancunTime},
+		{ForkPrague, c.Prague, &forks.PragueTime},
+	} {
+		switch {
+		case o.value == "":
+		case o.value == "none":
+			*o.override = nil
+		case strings.HasPrefix(o.value, "+"):
+			d, err := time.ParseDuration(o.value[1:])
+			if err != nil {
+				return fmt.Errorf("invalid %s time %q: %v", o.name, o.value, err)
+			}
+			t := uint64(now.Add(d).Unix())
+			*o.override = &t
+		default:
+			t, err := strconv.ParseUint(o.value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s time %q, expected a unix timestamp, +duration or none", o.name, o.value)
+			}
+			*o.override = &t
+		}
+	}
+	if c.BlobTarget != 0 || c.BlobMax != 0 {
+		schedule := make(map[string]blobConfig)
+		for _, fork := range []string{ForkCancun, ForkPrague} {
+			cfg := forks.blobConfig(fork)
+			if c.BlobTarget != 0 {
+				cfg.Target = c.BlobTarget
+			}
+			if c.BlobMax != 0 {
+				cfg.Max = c.BlobMax
+			}
+			schedule[fork] = cfg
+		}
+		forks.BlobSchedule = schedule
+	}
+	forks.Blobs = c.Blobs
+	return nil
+}
+
+// fields returns the fork times to log, none for inactive forks.
+func (f *forkTimes) fields() logrus.Fields {
+	fields := make(logrus.Fields)
+	for name, t := range map[string]*uint64{ForkShanghai: f.ShanghaiTime, ForkCancun: f.CancunTime, ForkPrague: f.PragueTime} {
+		if t == nil {
+			fields[name] = "none"
+		} else {
+			fields[name] = *t
+		}
+	}
+	return fields
+}
+
+func loadForkTimes(buf []byte) (*forkTimes, error) {
+	var genesis struct {
+		Config forkTimes `json:"config"`
+	}
+	if err := json.Unmarshal(buf, &genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %v", err)
+	}
+	return &genesis.Config, nil
+}
+
+// IsShanghai returns whether blocks at the given timestamp carry withdrawals.
+func (c *MockChain) IsShanghai(timestamp uint64) bool {
+	return c.forks.ShanghaiTime != nil && timestamp >= *c.forks.ShanghaiTime
+}
+
+// IsCancun returns whether blocks at the given timestamp carry blob gas fields and a parent beacon block root.
+func (c *MockChain) IsCancun(timestamp uint64) bool {
+	return c.forks.CancunTime != nil && timestamp >= *c.forks.CancunTime
+}
+
+// IsPrague returns whether blocks at the given timestamp commit to execution requests with a requests hash.
+func (c *MockChain) IsPrague(timestamp uint64) bool {
+	return c.forks.PragueTime != nil && timestamp >= *c.forks.PragueTime
+}
+
+// ForkAt returns the name of the latest fork active at the timestamp.
+func (c *MockChain) ForkAt(timestamp uint64) string {
+	switch {
+	case c.IsPrague(timestamp):
+		return ForkPrague
+	case c.IsCancun(timestamp):
+		return ForkCancun
+	case c.IsShanghai(timestamp):
+		return ForkShanghai
+	default:
+		return ForkParis
+	}
+}
+
+// ResolveHash returns the hash geth stores the block with the given spec hash under.
+func (c *MockChain) ResolveHash(specHash common.Hash) common.Hash {
+	return readHash(c.database, specToGethHashPrefix, specHash)
+}
+
+// SpecHash returns the hash consensus clients know the block stored under the geth hash by.
+func (c *MockChain) SpecHash(gethHash common.Hash) common.Hash {
+	return readHash(c.database, gethToSpecHashPrefix, gethHash)
+}
+
+// ForkFields returns the fork fields of the block stored under the geth hash, nil before Shanghai.
+func (c *MockChain) ForkFields(gethHash common.Hash) *mmTypes.ForkFields {
+	return readForkFields(c.database, gethHash)
+}
+
+// Withdrawals returns the withdrawals of the block stored under the geth hash, nil if it has none.
+func (c *MockChain) Withdrawals(gethHash common.Hash) []*mmTypes.Withdrawal {
+	return readWithdrawals(c.database, gethHash)
+}
+
+// BlobFee returns the blob gas price of the block, nil before Cancun.
+func (c *MockChain) BlobFee(header *types.Header) *big.Int {
+	fork := c.ForkFields(header.Hash())
+	if fork == nil || fork.ExcessBlobGas == nil {
+		return nil
+	}
+	return mmTypes.CalcBlobFee(*fork.ExcessBlobGas, c.forks.blobConfig(c.ForkAt(header.Time)).UpdateFraction)
+}
+
+// NextBlobFee returns the blob gas price of a block building on the parent, with the blob parameters of the fork
+// of the parent, nil before Cancun.
+func (c *MockChain) NextBlobFee(parent *types.Header) *big.Int {
+	fork := c.ForkFields(parent.Hash())
+	if fork == nil || fork.ExcessBlobGas == nil {
+		return nil
+	}
+	cfg := c.forks.blobConfig(c.ForkAt(parent.Time))
+	return mmTypes.CalcBlobFee(mmTypes.CalcExcessBlobGas(*fork.ExcessBlobGas, *fork.BlobGasUsed, cfg.Target), cfg.UpdateFraction)
+}
+
+// newForkFields returns the fork fields of a block at the timestamp on top of parent. Withdrawals, the
+// parent beacon block root and the requests hash have to be given exactly for the forks active at the timestamp.
+// The blob gas fields are derived with the blob counts of the fork: a block can't contain blob transactions as geth
+// can't execute them, its blob gas used is 0 unless the --fork.blobs fault accounts for blobs it doesn't carry.
+func (c *MockChain) newForkFields(parent *types.Header, timestamp uint64, withdrawals []*mmTypes.Withdrawal, parentBeaconRoot, requestsHash *common.Hash) (*mmTypes.ForkFields, error) {
+	if shanghai := c.IsShanghai(timestamp); shanghai && withdrawals == nil {
+		return nil, fmt.Errorf("missing withdrawals after shanghai, at timestamp %d", timestamp)
+	} else if !shanghai && withdrawals != nil {
+		return nil, fmt.Errorf("withdrawals before shanghai, at timestamp %d", timestamp)
+	}
+	if cancun := c.IsCancun(timestamp); cancun && parentBeaconRoot == nil {
+		return nil, fmt.Errorf("missing parent beacon block root after cancun, at timestamp %d", timestamp)
+	} else if !cancun && parentBeaconRoot != nil {
+		return nil, fmt.Errorf("parent beacon block root before cancun, at timestamp %d", timestamp)
+	}
+	if prague := c.IsPrague(timestamp); prague && requestsHash == nil {
+		return nil, fmt.Errorf("missing requests hash after prague, at timestamp %d", timestamp)
+	} else if !prague && requestsHash != nil {
+		return nil, fmt.Errorf("requests hash before prague, at timestamp %d", timestamp)
+	}
+	if withdrawals == nil {
+		return nil, nil
+	}
+	fork := &mmTypes.ForkFields{Withdrawals: withdrawals, RequestsHash: requestsHash}
+	if parentBeaconRoot != nil {
+		var parentExcess, parentUsed uint64
+		if parentFork := c.ForkFields(parent.Hash()); parentFork != nil && parentFork.ExcessBlobGas != nil {
+			parentExcess, parentUsed = *parentFork.ExcessBlobGas, *parentFork.BlobGasUsed
+		}
+		blobGasUsed := c.forks.Blobs * mmTypes.BlobGasPerBlob
+		excessBlobGas := mmTypes.CalcExcessBlobGas(parentExcess, parentUsed, c.forks.blobConfig(c.ForkAt(timestamp)).Target)
+		fork.BlobGasUsed, fork.ExcessBlobGas, fork.ParentBeaconRoot = &blobGasUsed, &excessBlobGas, parentBeaconRoot
+	}
+	return fork, nil
+}
+
+// specHash computes the hash of the block as defined by the spec: it commits to the fork fields,
+// and to the spec hash of its parent.
+func (c *MockChain) specHash(block *types.Block, fork *mmTypes.ForkFields) common.Hash {
+	header := block.Header()
+	header.ParentHash = c.SpecHash(header.ParentHash)
+	return mmTypes.HeaderHash(header, fork)
+}
+
+// writeForkFields stores the fork fields of the block, and the translation between its hashes.
+// It has to happen before the block is inserted, the consensus engine applies the withdrawals then.
+func (c *MockChain) writeForkFields(block *types.Block, fork *mmTypes.ForkFields) error {
+	if fork == nil {
+		return nil
+	}
+	enc, err := rlp.EncodeToBytes(fork)
+	if err != nil {
+		return fmt.Errorf("failed to encode fork fields: %v", err)
+	}
+	gethHash, specHash := block.Hash(), c.specHash(block, fork)
+	batch := c.database.NewBatch()
+	batch.Put(dbKey(forkFieldsPrefix, gethHash), enc)
+	batch.Put(dbKey(gethToSpecHashPrefix, gethHash), specHash[:])
+	batch.Put(dbKey(specToGethHashPrefix, specHash), gethHash[:])
+	return batch.Write()
+}
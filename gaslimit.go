@@ -0,0 +1,74 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+type GasLimitConfig struct {
+	Target uint64 `ask:"--target" help:"Gas limit built payloads move toward from their parent, by the largest step of 1/1024 of the parent gas limit real engines may take per block, can be changed with mock_setGasLimit (0 for a fixed gas limit)"`
+}
+
+// gasLimits are the gas limits of built payloads: one for the run, and overrides for single block numbers.
+// The gas limit of the run is either used as is, or as a target the gas limit moves toward block by block.
+type gasLimits struct {
+	mu       sync.Mutex
+	limit    uint64
+	adjust   bool
+	perBlock map[uint64]uint64
+}
+
+func newGasLimits(limit uint64) *gasLimits {
+	return &gasLimits{limit: limit, perBlock: make(map[uint64]uint64)}
+}
+
+// Set changes the gas limit of the run, or only that of the block with the number if not nil.
+func (g *gasLimits) Set(limit uint64, number *uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if number != nil {
+		g.perBlock[*number] = limit
+	} else {
+		g.limit = limit
+	}
+}
+
+// SetTarget makes the gas limit of the run a target that the gas limit of built payloads moves toward.
+func (g *gasLimits) SetTarget(target uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit, g.adjust = target, true
+}
+
+// For returns the gas limit of a payload built at the block number, on a parent with the gas limit.
+func (g *gasLimits) For(number uint64, parentGasLimit uint64) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if limit, ok := g.perBlock[number]; ok {
+		return limit
+	}
+	if g.adjust && parentGasLimit != 0 {
+		return core.CalcGasLimit(parentGasLimit, g.limit)
+	}
+	return g.limit
+}
+
+// SetGasLimit changes the gas limit of payloads built from now on, or only of the payload at the block number.
+// With a gas limit target, it changes the target.
+func (b *MockBackend) SetGasLimit(ctx context.Context, gasLimit hexutil.Uint64, blockNumber *hexutil.Uint64) error {
+	if gasLimit == 0 {
+		return fmt.Errorf("gas limit must be greater than 0")
+	}
+	var number *uint64
+	if blockNumber != nil {
+		n := uint64(*blockNumber)
+		number = &n
+	}
+	b.engine.gasLimits.Set(uint64(gasLimit), number)
+	b.engine.log.WithField("gas_limit", uint64(gasLimit)).WithField("block_number", number).Info("Gas limit changed")
+	return nil
+}
@@ -0,0 +1,220 @@
+package mergemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"mergemock/types"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+type GasPriceOracleConfig struct {
+	Blocks        uint64  `ask:"--blocks" help:"Number of recent blocks to sample transaction tips from"`
+	Percentile    float64 `ask:"--percentile" help:"Percentile of the sampled tips to suggest as priority fee"`
+	DefaultTip    uint64  `ask:"--default-tip" help:"Priority fee in wei to suggest when no transactions were sampled"`
+	MaxFeeHistory uint64  `ask:"--max-fee-history" help:"Maximum number of blocks served by a single eth_feeHistory call"`
+}
+
+func (c *GasPriceOracleConfig) Default() {
+	c.Blocks = 20
+	c.Percentile = 60
+	c.DefaultTip = params.GWei
+	c.MaxFeeHistory = 1024
+}
+
+func (c *GasPriceOracleConfig) validate() error {
+	if c.Percentile < 0 || c.Percentile > 100 {
+		return fmt.Errorf("gas price oracle percentile %v out of range [0, 100]", c.Percentile)
+	}
+	return nil
+}
+
+type feeHistoryResult struct {
+	OldestBlock      *hexutil.Big     `json:"oldestBlock"`
+	Reward           [][]*hexutil.Big `json:"reward,omitempty"`
+	BaseFee          []*hexutil.Big   `json:"baseFeePerGas,omitempty"`
+	GasUsedRatio     []float64        `json:"gasUsedRatio"`
+	BlobBaseFee      []*hexutil.Big   `json:"baseFeePerBlobGas,omitempty"`
+	BlobGasUsedRatio []float64        `json:"blobGasUsedRatio,omitempty"`
+}
+
+type txGasAndReward struct {
+	gasUsed uint64
+	reward  *big.Int
+}
+
+func (b *EthBackend) GasPrice(ctx context.Context) (*hexutil.Big, error) {
+	head := b.chain.CurrentBlock()
+	price := b.suggestTipCap(head)
+	if next := b.nextBaseFee(head.Header()); next != nil {
+		price.Add(price, next)
+	}
+	return (*hexutil.Big)(price), nil
+}
+
+func (b *EthBackend) MaxPriorityFeePerGas(ctx context.Context) (*hexutil.Big, error) {
+	return (*hexutil.Big)(b.suggestTipCap(b.chain.CurrentBlock())), nil
+}
+
+// BlobBaseFee returns the blob gas price of the next block, or null before Cancun.
+func (b *EthBackend) BlobBaseFee(ctx context.Context) *hexutil.Big {
+	return (*hexutil.Big)(b.mockChain.NextBlobFee(b.chain.CurrentHeader()))
+}
+
+func (b *EthBackend) FeeHistory(ctx context.Context, blockCount gethRpc.DecimalOrHex, lastBlock gethRpc.BlockNumber, rewardPercentiles []float64) (*feeHistoryResult, error) {
+	for i, p := range rewardPercentiles {
+		if p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid reward percentile: %f", p)
+		}
+		if i > 0 && p < rewardPercentiles[i-1] {
+			return nil, fmt.Errorf("invalid reward percentile: #%d:%f > #%d:%f", i-1, rewardPercentiles[i-1], i, p)
+		}
+	}
+	head := b.chain.CurrentBlock().NumberU64()
+	last := head
+	if lastBlock >= 0 {
+		last = uint64(lastBlock)
+	}
+	if last > head {
+		return nil, errors.New("request beyond head block")
+	}
+	count := uint64(blockCount)
+	if count > b.gpo.MaxFeeHistory {
+		count = b.gpo.MaxFeeHistory
+	}
+	if count > last+1 {
+		count = last + 1
+	}
+	if count == 0 {
+		return &feeHistoryResult{OldestBlock: (*hexutil.Big)(new(big.Int)), GasUsedRatio: []float64{}}, nil
+	}
+
+	oldest := last + 1 - count
+	result := &feeHistoryResult{
+		OldestBlock:      (*hexutil.Big)(new(big.Int).SetUint64(oldest)),
+		BaseFee:          make([]*hexutil.Big, 0, count+1),
+		GasUsedRatio:     make([]float64, 0, count),
+		BlobBaseFee:      make([]*hexutil.Big, 0, count+1),
+		BlobGasUsedRatio: make([]float64, 0, count),
+	}
+	if len(rewardPercentiles) != 0 {
+		result.Reward = make([][]*hexutil.Big, 0, count)
+	}
+	for number := oldest; number <= last; number++ {
+		block := b.chain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("missing block %d", number)
+		}
+		baseFee := block.BaseFee()
+		if baseFee == nil {
+			baseFee = new(big.Int)
+		}
+		result.BaseFee = append(result.BaseFee, (*hexutil.Big)(baseFee))
+		if block.GasLimit() > 0 {
+			result.GasUsedRatio = append(result.GasUsedRatio, float64(block.GasUsed())/float64(block.GasLimit()))
+		} else {
+			result.GasUsedRatio = append(result.GasUsedRatio, 0)
+		}
+		if len(rewardPercentiles) != 0 {
+			result.Reward = append(result.Reward, b.blockRewards(block, rewardPercentiles))
+		}
+		blobFee, blobRatio := b.blobFeeAndRatio(block.Header())
+		result.BlobBaseFee = append(result.BlobBaseFee, (*hexutil.Big)(blobFee))
+		result.BlobGasUsedRatio = append(result.BlobGasUsedRatio, blobRatio)
+		if number == last {
+			next := b.nextBaseFee(block.Header())
+			if next == nil {
+				next = new(big.Int)
+			}
+			result.BaseFee = append(result.BaseFee, (*hexutil.Big)(next))
+			nextBlobFee := b.mockChain.NextBlobFee(block.Header())
+			if nextBlobFee == nil {
+				nextBlobFee = new(big.Int)
+			}
+			result.BlobBaseFee = append(result.BlobBaseFee, (*hexutil.Big)(nextBlobFee))
+		}
+	}
+	return result, nil
+}
+
+// blobFeeAndRatio returns the blob gas price of the block and the share of the maximum blob gas it used, zero before
+// Cancun.
+func (b *EthBackend) blobFeeAndRatio(header *ethTypes.Header) (*big.Int, float64) {
+	fee := b.mockChain.BlobFee(header)
+	if fee == nil {
+		return new(big.Int), 0
+	}
+	used := *b.mockChain.ForkFields(header.Hash()).BlobGasUsed
+	max := b.mockChain.forks.blobConfig(b.mockChain.ForkAt(header.Time)).Max * types.BlobGasPerBlob
+	return fee, float64(used) / float64(max)
+}
+
+// nextBaseFee returns the base fee of a block building on the given parent, or nil before London.
+func (b *EthBackend) nextBaseFee(parent *ethTypes.Header) *big.Int {
+	config := b.chain.Config()
+	if !config.IsLondon(new(big.Int).Add(parent.Number, common.Big1)) {
+		return nil
+	}
+	return misc.CalcBaseFee(config, parent)
+}
+
+// suggestTipCap returns the configured percentile of the effective tips paid in recent blocks.
+func (b *EthBackend) suggestTipCap(head *ethTypes.Block) *big.Int {
+	var tips []*big.Int
+	for block, i := head, uint64(0); block != nil && i < b.gpo.Blocks; i++ {
+		for _, tx := range block.Transactions() {
+			if tip, err := tx.EffectiveGasTip(block.BaseFee()); err == nil {
+				tips = append(tips, tip)
+			}
+		}
+		if block.NumberU64() == 0 {
+			break
+		}
+		block = b.chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	}
+	if len(tips) == 0 {
+		return new(big.Int).SetUint64(b.gpo.DefaultTip)
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+	idx := int(float64(len(tips)-1) * b.gpo.Percentile / 100)
+	return new(big.Int).Set(tips[idx])
+}
+
+// blockRewards computes the gas-weighted reward percentiles of a block, like geth's fee history oracle.
+func (b *EthBackend) blockRewards(block *ethTypes.Block, percentiles []float64) []*hexutil.Big {
+	rewards := make([]*hexutil.Big, len(percentiles))
+	txs := block.Transactions()
+	receipts := b.chain.GetReceiptsByHash(block.Hash())
+	if len(txs) == 0 || len(receipts) != len(txs) {
+		for i := range rewards {
+			rewards[i] = (*hexutil.Big)(new(big.Int))
+		}
+		return rewards
+	}
+	sorter := make([]txGasAndReward, len(txs))
+	for i, tx := range txs {
+		reward, _ := tx.EffectiveGasTip(block.BaseFee())
+		sorter[i] = txGasAndReward{gasUsed: receipts[i].GasUsed, reward: reward}
+	}
+	sort.Slice(sorter, func(i, j int) bool { return sorter[i].reward.Cmp(sorter[j].reward) < 0 })
+
+	var txIndex int
+	sumGasUsed := sorter[0].gasUsed
+	for i, p := range percentiles {
+		thresholdGasUsed := uint64(float64(block.GasUsed()) * p / 100)
+		for sumGasUsed < thresholdGasUsed && txIndex < len(txs)-1 {
+			txIndex++
+			sumGasUsed += sorter[txIndex].gasUsed
+		}
+		rewards[i] = (*hexutil.Big)(sorter[txIndex].reward)
+	}
+	return rewards
+}
@@ -0,0 +1,145 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"mergemock/api"
+	"mergemock/types"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGasPriceOracle(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)))
+	ctx := context.Background()
+	config := te.mockChain().chain.Config()
+	genesis := te.mockChain().CurrentHeader()
+
+	requireBig := func(expected *big.Int, actual *hexutil.Big, msgAndArgs ...interface{}) {
+		require.Equal(t, (*hexutil.Big)(expected).String(), actual.String(), msgAndArgs...)
+	}
+
+	// Without transactions to sample, the default tip is suggested.
+	var tip, price hexutil.Big
+	require.NoError(t, te.client.CallContext(ctx, &tip, "eth_maxPriorityFeePerGas"))
+	requireBig(big.NewInt(params.GWei), &tip)
+
+	signer := ethTypes.LatestSigner(config)
+	for i := 0; i < 5; i++ {
+		tx := ethTypes.MustSignNewTx(key, signer, &ethTypes.DynamicFeeTx{
+			ChainID:   config.ChainID,
+			Nonce:     uint64(i),
+			GasTipCap: big.NewInt(int64(i+1) * params.GWei),
+			GasFeeCap: big.NewInt(100 * params.GWei),
+			Gas:       transferGas,
+			To:        &common.Address{0x01},
+			Value:     big.NewInt(1),
+		})
+		enc, err := tx.MarshalBinary()
+		require.NoError(t, err)
+		require.NoError(t, te.client.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Bytes(enc)))
+	}
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Len(t, payload.Transactions, 5)
+	te.newPayload(t, payload)
+	te.setHead(t, payload.BlockHash)
+	head := te.mockChain().CurrentHeader()
+
+	// The 60th percentile of the tips of 1 to 5 gwei, on top of the base fee of the next block for the gas price.
+	require.NoError(t, te.client.CallContext(ctx, &tip, "eth_maxPriorityFeePerGas"))
+	requireBig(big.NewInt(3*params.GWei), &tip)
+	require.NoError(t, te.client.CallContext(ctx, &price, "eth_gasPrice"))
+	requireBig(new(big.Int).Add(tip.ToInt(), misc.CalcBaseFee(config, head)), &price)
+
+	var history feeHistoryResult
+	require.NoError(t, te.client.CallContext(ctx, &history, "eth_feeHistory", "0x4", "latest", []float64{0, 100}))
+	requireBig(big.NewInt(0), history.OldestBlock, "only two blocks to report")
+	require.Len(t, history.BaseFee, 3, "with the base fee of the next block")
+	requireBig(misc.CalcBaseFee(config, head), history.BaseFee[2])
+	require.Equal(t, []float64{0, float64(5*transferGas) / float64(head.GasLimit)}, history.GasUsedRatio)
+	require.Len(t, history.Reward, 2)
+	requireBig(big.NewInt(0), history.Reward[0][0], "no rewards in the genesis block")
+	requireBig(big.NewInt(params.GWei), history.Reward[1][0])
+	requireBig(big.NewInt(5*params.GWei), history.Reward[1][1])
+
+	require.Error(t, te.client.CallContext(ctx, &history, "eth_feeHistory", "0x1", "latest", []float64{50, 10}), "decreasing percentiles")
+	require.Error(t, te.client.CallContext(ctx, &history, "eth_feeHistory", "0x1", "0x10", []float64{}), "beyond the head")
+}
+
+func TestGasPriceOracleValidation(t *testing.T) {
+	var cfg GasPriceOracleConfig
+	cfg.Default()
+	require.NoError(t, cfg.validate())
+	for _, percentile := range []float64{-1, 101} {
+		cfg.Percentile = percentile
+		require.Error(t, cfg.validate(), percentile)
+	}
+}
+
+func TestBlobBaseFee(t *testing.T) {
+	genesisPath := newForkGenesis(t, map[string]uint64{"shanghaiTime": 0, "cancunTime": 0})
+	buf, err := os.ReadFile(genesisPath)
+	require.NoError(t, err)
+	var genesisJSON map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &genesisJSON))
+	genesisJSON["config"].(map[string]interface{})["blobSchedule"] = map[string]interface{}{
+		"cancun": map[string]interface{}{"target": 1, "max": 6, "baseFeeUpdateFraction": types.BlobGasPerBlob},
+	}
+	buf, err = json.Marshal(genesisJSON)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(genesisPath, buf, 0644))
+	te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Forks.Blobs = 5
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	parent := genesis.Hash()
+	for i := 1; i <= 2; i++ {
+		root := common.Hash{byte(i)}
+		attributes := &types.PayloadAttributesV3{Timestamp: genesis.Time + uint64(i)*12, Withdrawals: []*types.Withdrawal{}, ParentBeaconBlockRoot: root}
+		result, err := api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, attributes)
+		require.NoError(t, err)
+		envelope, err := api.GetPayloadV3(ctx, te.client, te.log, *result.PayloadID)
+		require.NoError(t, err)
+		status, err := api.NewPayloadV3(ctx, te.client, te.log, envelope.ExecutionPayload, []common.Hash{}, root)
+		require.NoError(t, err)
+		require.Equal(t, types.ExecutionValid, status.Status)
+		parent = envelope.ExecutionPayload.BlockHash
+	}
+	_, err = api.ForkchoiceUpdatedV3(ctx, te.client, te.log, parent, parent, parent, nil)
+	require.NoError(t, err)
+
+	// The excess of 4 and then 8 blobs above the target of 1 prices blob gas with the update fraction of the schedule.
+	fee := func(blobs uint64) *big.Int {
+		return types.CalcBlobFee(blobs*types.BlobGasPerBlob, types.BlobGasPerBlob)
+	}
+	var next hexutil.Big
+	require.NoError(t, te.client.CallContext(ctx, &next, "eth_blobBaseFee"))
+	require.Equal(t, fee(8).String(), next.ToInt().String())
+	require.Equal(t, int64(2980), next.ToInt().Int64(), "about e^8")
+
+	var history feeHistoryResult
+	require.NoError(t, te.client.CallContext(ctx, &history, "eth_feeHistory", "0x3", "latest", nil))
+	require.Len(t, history.BlobBaseFee, 4)
+	for i, expected := range []*big.Int{big.NewInt(0), fee(0), fee(4), fee(8)} {
+		require.Equal(t, expected.String(), history.BlobBaseFee[i].ToInt().String(), "block %d", i)
+	}
+	require.Equal(t, []float64{0, 5.0 / 6, 5.0 / 6}, history.BlobGasUsedRatio)
+
+	// Before Cancun there is no blob gas price.
+	plain := newTestEngine(t)
+	var none *hexutil.Big
+	require.NoError(t, plain.client.CallContext(ctx, &none, "eth_blobBaseFee"))
+	require.Nil(t, none)
+}
@@ -0,0 +1,88 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+// terminalBlock is the terminal proof-of-work block of the genesis chain config, which geth doesn't parse yet.
+// Both are zero unless the transition is configured by block rather than by total difficulty.
+type terminalBlock struct {
+	Hash   common.Hash `json:"terminalBlockHash"`
+	Number uint64      `json:"terminalBlockNumber"`
+}
+
+func loadTerminalBlock(buf []byte) (*terminalBlock, error) {
+	var genesis struct {
+		Config terminalBlock `json:"config"`
+	}
+	if err := json.Unmarshal(buf, &genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %v", err)
+	}
+	return &genesis.Config, nil
+}
+
+// GenesisConfigError lists everything wrong with a genesis file, each with a suggested fix.
+type GenesisConfigError struct {
+	Problems []string
+}
+
+func (e *GenesisConfigError) Error() string {
+	return fmt.Sprintf("invalid genesis config: %s", strings.Join(e.Problems, "; "))
+}
+
+// validateGenesis checks the parts of the genesis the mock relies on, before anything is committed to the db.
+func validateGenesis(genesis *core.Genesis, forks *forkTimes) error {
+	var problems []string
+	if genesis.Config == nil {
+		problems = append(problems, `"config" is missing, add a chain config with a chainId and the fork blocks`)
+	} else {
+		cfg := genesis.Config
+		if cfg.ChainID == nil {
+			problems = append(problems, "config.chainId is missing, set it to the chain id the consensus client expects, e.g. 1337")
+		}
+		if cfg.TerminalTotalDifficulty == nil {
+			problems = append(problems, "config.terminalTotalDifficulty is missing, set it to 0 for a chain that is merged from genesis")
+		}
+		if err := cfg.CheckConfigForkOrder(); err != nil {
+			problems = append(problems, fmt.Sprintf("%v, activate the forks in order or unset the later one", err))
+		}
+	}
+	if forks.CancunTime != nil {
+		if forks.ShanghaiTime == nil {
+			problems = append(problems, "config.cancunTime is set without config.shanghaiTime, set shanghaiTime to at most the cancun time")
+		} else if *forks.CancunTime < *forks.ShanghaiTime {
+			problems = append(problems, fmt.Sprintf("config.cancunTime %d is before config.shanghaiTime %d, activate cancun at or after shanghai",
+				*forks.CancunTime, *forks.ShanghaiTime))
+		}
+	}
+	if forks.PragueTime != nil {
+		if forks.CancunTime == nil {
+			problems = append(problems, "config.pragueTime is set without config.cancunTime, set cancunTime to at most the prague time")
+		} else if *forks.PragueTime < *forks.CancunTime {
+			problems = append(problems, fmt.Sprintf("config.pragueTime %d is before config.cancunTime %d, activate prague at or after cancun",
+				*forks.PragueTime, *forks.CancunTime))
+		}
+	}
+	for _, fork := range []string{ForkCancun, ForkPrague} {
+		cfg := forks.blobConfig(fork)
+		if cfg.Target == 0 || cfg.Target > cfg.Max {
+			problems = append(problems, fmt.Sprintf("config.blobSchedule.%s has target %d and max %d, set a target of at least 1 and at most the max",
+				fork, cfg.Target, cfg.Max))
+		} else if forks.Blobs > cfg.Max {
+			problems = append(problems, fmt.Sprintf("%d blobs per block exceed the max %d of config.blobSchedule.%s, lower --fork.blobs",
+				forks.Blobs, cfg.Max, fork))
+		}
+	}
+	if genesis.GasLimit == 0 {
+		problems = append(problems, "gasLimit is 0 so no transaction fits in a block, set it, e.g. to 0x1c9c380 (30M)")
+	}
+	if len(problems) > 0 {
+		return &GenesisConfigError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package mergemock
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGenesisReportsAllProblems(t *testing.T) {
+	genesis := core.DeveloperGenesisBlock(5, 0, common.Address{})
+	genesis.Config.LondonBlock = big.NewInt(10)
+	genesis.Config.ArrowGlacierBlock = big.NewInt(5)
+	shanghai, cancun, prague := uint64(20), uint64(10), uint64(5)
+	err := validateGenesis(genesis, &forkTimes{ShanghaiTime: &shanghai, CancunTime: &cancun, PragueTime: &prague})
+
+	var configErr *GenesisConfigError
+	require.True(t, errors.As(err, &configErr))
+	require.Len(t, configErr.Problems, 5)
+	require.Contains(t, configErr.Problems[0], "terminalTotalDifficulty")
+	require.Contains(t, configErr.Problems[1], "arrowGlacierBlock")
+	require.Contains(t, configErr.Problems[2], "cancunTime")
+	require.Contains(t, configErr.Problems[3], "pragueTime")
+	require.Contains(t, configErr.Problems[4], "gasLimit")
+}
+
+func TestValidateDefaultGenesis(t *testing.T) {
+	genesis, err := LoadGenesisConfig(defaultGenesis)
+	require.NoError(t, err)
+	forks, err := loadForkTimes(defaultGenesis)
+	require.NoError(t, err)
+	require.NoError(t, validateGenesis(genesis, forks))
+}
+
+func TestGenesisBlobSchedule(t *testing.T) {
+	genesis, err := LoadGenesisConfig(defaultGenesis)
+	require.NoError(t, err)
+	forks, err := loadForkTimes([]byte(`{"config": {"blobSchedule": {"cancun": {"target": 2, "max": 4, "baseFeeUpdateFraction": 4000000}}}}`))
+	require.NoError(t, err)
+	require.Equal(t, blobConfig{Target: 2, Max: 4, UpdateFraction: 4000000}, forks.blobConfig(ForkCancun))
+	require.Equal(t, blobConfig{Target: 6, Max: 9, UpdateFraction: 5007716}, forks.blobConfig(ForkPrague), "default without an entry")
+	require.NoError(t, validateGenesis(genesis, forks))
+
+	// The flags override the counts of all forks.
+	require.NoError(t, (&ForkTimesConfig{BlobMax: 12, Blobs: 7}).apply(forks, time.Now()))
+	require.Equal(t, blobConfig{Target: 2, Max: 12, UpdateFraction: 4000000}, forks.blobConfig(ForkCancun))
+	require.Equal(t, blobConfig{Target: 6, Max: 12, UpdateFraction: 5007716}, forks.blobConfig(ForkPrague))
+	require.NoError(t, validateGenesis(genesis, forks))
+
+	forks.Blobs = 13
+	require.Error(t, validateGenesis(genesis, forks), "more blobs than the max")
+	forks.Blobs = 0
+	forks.BlobSchedule[ForkCancun] = blobConfig{Target: 5, Max: 4}
+	require.Error(t, validateGenesis(genesis, forks), "target above the max")
+}
@@ -43,6 +43,7 @@ require (
 	github.com/ferranbt/fastssz v0.0.0-20220303160658-88bb965b6747
 	github.com/fjl/gencodec v0.0.0-20220412091415-8bb9e558978c
 	github.com/golang-jwt/jwt/v4 v4.3.0
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/mux v1.8.0
 	github.com/prysmaticlabs/prysm v1.4.2-0.20220515031444-3d3890205f40
 	github.com/stretchr/testify v1.7.0
@@ -58,7 +59,6 @@ require (
 	github.com/garslo/gogen v0.0.0-20170306192744-1d203ffc1f61 // indirect
 	github.com/go-kit/kit v0.10.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
 	github.com/hashicorp/go-bexpr v0.1.10 // indirect
 	github.com/herumi/bls-eth-go-binary v0.0.0-20210917013441-d37c07cfda4e // indirect
 	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
@@ -83,5 +83,5 @@ require (
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,31 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// healthPath is the path of readiness probes, served without authentication.
+const healthPath = "/healthz"
+
+type healthStatus struct {
+	Status string      `json:"status"`
+	Head   common.Hash `json:"head"`
+	Number uint64      `json:"number"`
+}
+
+// healthHandler answers readiness probes with the head of the mock chain, and passes other requests to next.
+// The RPC server only starts once the engine is set up, so any answer means the engine is ready.
+func healthHandler(backend *EngineBackend, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		head := backend.mockChain.CurrentHeader()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(healthStatus{Status: "ok", Head: backend.mockChain.SpecHash(head.Hash()), Number: head.Number.Uint64()})
+	})
+}
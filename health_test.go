@@ -0,0 +1,26 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthz(t *testing.T) {
+	te := newTestEngine(t)
+	require.NotContains(t, te.ListenAddr, ":0")
+
+	// Readiness probes need no JWT.
+	resp, err := http.Get("http://" + te.ListenAddr + healthPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var status healthStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	head := te.mockChain().CurrentHeader()
+	require.Equal(t, "ok", status.Status)
+	require.Equal(t, te.mockChain().SpecHash(head.Hash()), status.Head)
+	require.Equal(t, head.Number.Uint64(), status.Number)
+}
@@ -0,0 +1,55 @@
+package mergemock
+
+import (
+	"fmt"
+	"mergemock/rpc"
+	"plugin"
+)
+
+// HookPluginSymbol is the function Go plugins of response hooks export to create their hook.
+const HookPluginSymbol = "NewResponseHook"
+
+type HookConfig struct {
+	Plugins []string `ask:"--plugin" help:"Go plugins, built with go build -buildmode=plugin against this version of mergemock, exporting a NewResponseHook function to create a hook rewriting the responses of engine API calls"`
+
+	// hooks are the hooks added in-process, run before those of the plugins
+	hooks rpc.ResponseHooks
+}
+
+// Add adds a response hook, for engines started in-process to rewrite responses without a plugin.
+func (c *HookConfig) Add(hook rpc.ResponseHook) {
+	c.hooks = append(c.hooks, hook)
+}
+
+// NewResponseHooks returns the hooks added in-process, followed by the hooks of the plugins.
+func (c *HookConfig) NewResponseHooks() (rpc.ResponseHooks, error) {
+	hooks := append(rpc.ResponseHooks(nil), c.hooks...)
+	for _, path := range c.Plugins {
+		hook, err := loadHookPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func loadHookPlugin(path string) (rpc.ResponseHook, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hook plugin: %v", err)
+	}
+	sym, err := p.Lookup(HookPluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hook plugin %s: %v", path, err)
+	}
+	newHook, ok := sym.(func() (rpc.ResponseHook, error))
+	if !ok {
+		return nil, fmt.Errorf("invalid hook plugin %s: %s is a %T, expected a func() (rpc.ResponseHook, error)", path, HookPluginSymbol, sym)
+	}
+	hook, err := newHook()
+	if err != nil {
+		return nil, fmt.Errorf("hook plugin %s: %v", path, err)
+	}
+	return hook, nil
+}
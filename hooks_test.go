@@ -0,0 +1,49 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"mergemock/rpc"
+	"mergemock/types"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineResponseHooks(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Hooks.Add(rpc.ResponseHookFunc(func(call *rpc.HookedCall) error {
+			if call.Method != "engine_getPayloadV1" {
+				return nil
+			}
+			var payload map[string]json.RawMessage
+			if err := json.Unmarshal(call.Result, &payload); err != nil {
+				return err
+			}
+			var root common.Hash
+			if err := json.Unmarshal(payload["stateRoot"], &root); err != nil {
+				return err
+			}
+			root[0] ^= 0xff
+			payload["stateRoot"], _ = json.Marshal(root)
+			var err error
+			call.Result, err = json.Marshal(payload)
+			return err
+		}))
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	hash, err := payload.ComputeHash()
+	require.NoError(t, err)
+	require.NotEqual(t, payload.BlockHash, hash, "the hook rewrote the state root")
+	require.Equal(t, types.ExecutionInvalidBlockHash, te.newPayload(t, payload))
+}
+
+func TestHookPlugins(t *testing.T) {
+	hooks, err := new(HookConfig).NewResponseHooks()
+	require.NoError(t, err)
+	require.Empty(t, hooks)
+	_, err = (&HookConfig{Plugins: []string{filepath.Join(t.TempDir(), "missing.so")}}).NewResponseHooks()
+	require.Error(t, err)
+}
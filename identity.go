@@ -0,0 +1,147 @@
+package mergemock
+
+import (
+	"fmt"
+	"mergemock/rpc"
+	"mergemock/types"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+)
+
+// ClientMergemock is the client preset of the engine identifying as itself.
+const ClientMergemock = "mergemock"
+
+// clientPreset is the identity of a client: its engine_getClientVersionV1 version, and the format of its
+// web3_clientVersion, with the name, version and commit (without 0x prefix) as arguments.
+type clientPreset struct {
+	version types.ClientVersionV1
+	web3    string
+}
+
+// clientPresets are the clients the engine can masquerade as, with the identity of a recent release.
+var clientPresets = map[string]clientPreset{
+	ClientMergemock: {types.ClientVersionV1{Code: "MM", Name: "mergemock", Version: "0.1.0", Commit: "0x00000000"}, "%s/v%s-%s"},
+	"geth":          {types.ClientVersionV1{Code: "GE", Name: "Geth", Version: "1.13.14-stable", Commit: "0x2bd6bd01"}, "%s/v%s-%s/linux-amd64/go1.21.7"},
+	"nethermind":    {types.ClientVersionV1{Code: "NM", Name: "Nethermind", Version: "1.25.4", Commit: "0x20b10b35"}, "%s/v%s+%s/linux-x64/dotnet8.0.2"},
+	"besu":          {types.ClientVersionV1{Code: "BU", Name: "besu", Version: "24.1.2", Commit: "0x8e7a5e8a"}, "%s/v%s-%s/linux-x86_64/openjdk-java-17"},
+	"erigon":        {types.ClientVersionV1{Code: "EG", Name: "erigon", Version: "2.58.1", Commit: "0x4bf42d5e"}, "%s/v%s-%s/linux-amd64/go1.21.5"},
+	"reth":          {types.ClientVersionV1{Code: "RH", Name: "reth", Version: "0.2.0-beta.2", Commit: "0x1e1a4746"}, "%s/v%s-%s/x86_64-unknown-linux-gnu"},
+}
+
+type IdentityConfig struct {
+	Client  string `ask:"--client" help:"Client to identify as with engine_getClientVersionV1 and web3_clientVersion: mergemock, geth, nethermind, besu, erigon or reth, to test behavior of the consensus client that depends on the engine it runs with"`
+	Code    string `ask:"--code" help:"Two-letter client code to report instead of the one of the client, e.g. GE (client value if empty)"`
+	Name    string `ask:"--name" help:"Client name to report instead of the one of the client (client value if empty)"`
+	Version string `ask:"--version" help:"Client version to report instead of the one of the client (client value if empty)"`
+	Commit  string `ask:"--commit" help:"First 4 bytes of the commit to report instead of the one of the client, as hex (client value if empty)"`
+	Web3    string `ask:"--web3" help:"web3_clientVersion to report instead of the one formatted like the client does from the name, version and commit (formatted if empty)"`
+}
+
+// NewClientIdentity returns the identity of the config.
+func (c *IdentityConfig) NewClientIdentity() (*ClientIdentity, error) {
+	client := c.Client
+	if client == "" {
+		client = ClientMergemock
+	}
+	preset, ok := clientPresets[client]
+	if !ok {
+		return nil, fmt.Errorf("unknown client %q, expected one of %s", client, strings.Join(clientNames(), ", "))
+	}
+	version := preset.version
+	for _, o := range []struct {
+		value    string
+		override *string
+	}{
+		{c.Code, &version.Code},
+		{c.Name, &version.Name},
+		{c.Version, &version.Version},
+		{c.Commit, &version.Commit},
+	} {
+		if o.value != "" {
+			*o.override = o.value
+		}
+	}
+	if len(version.Code) != 2 {
+		return nil, fmt.Errorf("invalid client code %q, expected two letters", version.Code)
+	}
+	if !strings.HasPrefix(version.Commit, "0x") {
+		version.Commit = "0x" + version.Commit
+	}
+	if commit, err := hexutil.Decode(version.Commit); err != nil || len(commit) != 4 {
+		return nil, fmt.Errorf("invalid client commit %q, expected 4 bytes of hex", version.Commit)
+	}
+	version.Commit = strings.ToLower(version.Commit)
+	web3 := c.Web3
+	if web3 == "" {
+		web3 = fmt.Sprintf(preset.web3, version.Name, strings.TrimPrefix(version.Version, "v"), version.Commit[2:])
+	}
+	return &ClientIdentity{version: version, web3: web3}, nil
+}
+
+func clientNames() []string {
+	names := make([]string, 0, len(clientPresets))
+	for name := range clientPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClientIdentity is the client the engine identifies as.
+type ClientIdentity struct {
+	version types.ClientVersionV1
+	web3    string
+}
+
+func defaultClientIdentity() *ClientIdentity {
+	identity, err := new(IdentityConfig).NewClientIdentity()
+	if err != nil {
+		panic(err)
+	}
+	return identity
+}
+
+// Version returns the version reported by engine_getClientVersionV1.
+func (i *ClientIdentity) Version() types.ClientVersionV1 {
+	return i.version
+}
+
+// Web3 returns the version reported by web3_clientVersion.
+func (i *ClientIdentity) Web3() string {
+	return i.web3
+}
+
+// Web3Backend serves the web3 namespace, with the client version of the identity of the engine.
+type Web3Backend struct {
+	engine *EngineBackend
+}
+
+func NewWeb3Backend(engine *EngineBackend) *Web3Backend {
+	return &Web3Backend{engine: engine}
+}
+
+func (b *Web3Backend) Register(srv *rpc.Server) error {
+	srv.RegisterName("web3", b)
+	return node.RegisterApis([]rpc.API{
+		{
+			Namespace:     "web3",
+			Version:       "1.0",
+			Service:       b,
+			Public:        true,
+			Authenticated: false,
+		},
+	}, []string{"web3"}, srv, false)
+}
+
+func (b *Web3Backend) ClientVersion() string {
+	return b.engine.identity.Web3()
+}
+
+// Sha3 returns the keccak-256 hash of the input, like geth.
+func (b *Web3Backend) Sha3(input hexutil.Bytes) hexutil.Bytes {
+	return crypto.Keccak256(input)
+}
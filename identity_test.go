@@ -0,0 +1,51 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineClientVersion(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Identity.Client = "geth"
+		cmd.Identity.Version = "1.14.0-stable"
+	})
+	ctx := context.Background()
+	versions, err := api.GetClientVersionV1(ctx, te.client, te.log, types.ClientVersionV1{Code: "LH", Name: "Lighthouse", Version: "v5.1.0", Commit: "0x12345678"})
+	require.NoError(t, err)
+	require.Equal(t, []types.ClientVersionV1{{Code: "GE", Name: "Geth", Version: "1.14.0-stable", Commit: "0x2bd6bd01"}}, versions)
+
+	var web3 string
+	require.NoError(t, te.client.CallContext(ctx, &web3, "web3_clientVersion"))
+	require.Equal(t, "Geth/v1.14.0-stable-2bd6bd01/linux-amd64/go1.21.7", web3)
+	var hash hexutil.Bytes
+	require.NoError(t, te.client.CallContext(ctx, &hash, "web3_sha3", hexutil.Bytes("abc")))
+	require.Equal(t, hexutil.Bytes(crypto.Keccak256([]byte("abc"))), hash)
+
+	te.backend.disabled = map[string]bool{"engine_getClientVersionV1": true}
+	_, err = api.GetClientVersionV1(ctx, te.client, te.log, types.ClientVersionV1{})
+	require.Error(t, err, "clients predating the method are emulated by disabling it")
+}
+
+func TestClientIdentity(t *testing.T) {
+	identity, err := new(IdentityConfig).NewClientIdentity()
+	require.NoError(t, err)
+	require.Equal(t, "MM", identity.Version().Code)
+	require.Equal(t, "mergemock/v0.1.0-00000000", identity.Web3())
+
+	identity, err = (&IdentityConfig{Client: "nethermind", Commit: "ABCDEF01", Web3: "Custom/v1"}).NewClientIdentity()
+	require.NoError(t, err)
+	require.Equal(t, "0xabcdef01", identity.Version().Commit)
+	require.Equal(t, "Custom/v1", identity.Web3())
+
+	for _, cfg := range []IdentityConfig{{Client: "geth-classic"}, {Code: "GETH"}, {Commit: "0x1234"}, {Commit: "0xzzzzzzzz"}} {
+		_, err := cfg.NewClientIdentity()
+		require.Error(t, err, cfg)
+	}
+}
@@ -0,0 +1,125 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+// instanceShareTrigger is the trigger of the journal entries of blocks shared by another engine instance.
+const instanceShareTrigger = "instance-share"
+
+type InstancesConfig struct {
+	Count uint64 `ask:"--count" help:"Number of independent engines to run in the process, on consecutive ports of the listen, websocket and explorer addresses, with the instance index appended to the paths of their files"`
+	Share bool   `ask:"--share" help:"Import the blocks stored by any instance into the chains of the others, like engines following the same network, instead of chains diverging by what their consensus clients send"`
+}
+
+// startInstances runs the other engine instances of the process, with the flags of the first one.
+func (c *EngineCmd) startInstances(ctx context.Context) error {
+	if c.flags == nil {
+		return nil
+	}
+	for i := uint64(1); i < c.Instances.Count; i++ {
+		inst, err := c.instanceCmd(int(i))
+		if err != nil {
+			return err
+		}
+		// an instance that fails to start releases what it opened itself
+		if err := inst.Run(ctx); err != nil {
+			return fmt.Errorf("instance %d: %v", i, err)
+		}
+		c.instances = append(c.instances, inst)
+	}
+	return nil
+}
+
+// instanceCmd copies the command for the instance with the index, on its own ports and files.
+func (c *EngineCmd) instanceCmd(index int) (*EngineCmd, error) {
+	inst := *c.flags
+	inst.instance, inst.flags = index, nil
+	var err error
+	for _, addr := range []*string{&inst.ListenAddr, &inst.WebsocketAddr, &inst.ExplorerAddr} {
+		if *addr, err = offsetPort(*addr, index); err != nil {
+			return nil, err
+		}
+	}
+	if inst.DataDir != AutoDataDir {
+		inst.DataDir = instancePath(inst.DataDir, index)
+	}
+	for _, path := range []*string{&inst.IPCPath, &inst.EventsPath, &inst.StatsSnapshot, &inst.Timeline.Path, &inst.Chain.Export, &inst.Record.Path} {
+		*path = instancePath(*path, index)
+	}
+	return &inst, nil
+}
+
+// offsetPort moves the port of the address up by the offset, unless it is empty or port 0 for a free port.
+func offsetPort(addr string, offset int) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid port of address %q: %v", addr, err)
+	}
+	if port == 0 {
+		return addr, nil
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+offset)), nil
+}
+
+// instancePath appends the instance index to the path, unless it is empty.
+func instancePath(path string, index int) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// sharedChains imports the blocks stored by any of the chains of the engine instances into the others.
+type sharedChains struct {
+	mu     sync.RWMutex
+	chains []*MockChain
+}
+
+func (s *sharedChains) add(chain *MockChain) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chains = append(s.chains, chain)
+	chain.shared = s
+}
+
+// share imports the block stored by the chain into the other chains. Importing it there shares it again, which
+// stops at the chains that know it already.
+func (s *sharedChains) share(from *MockChain, block *types.Block) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	chains := append([]*MockChain(nil), s.chains...)
+	s.mu.RUnlock()
+	b, err := from.encodeSpecBlock(block)
+	if err != nil {
+		from.log.WithError(err).Warn("Unable to share block with the other instances")
+		return
+	}
+	for i, chain := range chains {
+		if chain == from {
+			continue
+		}
+		if _, _, _, err := chain.importSpecBlock(b, instanceShareTrigger); err != nil {
+			from.log.WithFields(logrus.Fields{"instance": i, "err": err}).Warn("Unable to share block with instance")
+		}
+	}
+}
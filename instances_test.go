@@ -0,0 +1,83 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/rpc"
+	"mergemock/types"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+// instance returns a client of the other engine instance with the index.
+func (te *testEngine) instance(t *testing.T, index int) *testEngine {
+	inst := te.instances[index-1]
+	client, err := rpc.DialContext(context.Background(), "http://"+inst.ListenAddr, inst.jwtSecret)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return &testEngine{inst, client}
+}
+
+func TestInstances(t *testing.T) {
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Instances.Count = 3
+		cmd.EventsPath = events
+	})
+	require.Len(t, te.instances, 2)
+	addrs := map[string]bool{te.ListenAddr: true}
+	for i, inst := range te.instances {
+		require.Equal(t, i+1, inst.instance)
+		require.Equal(t, instancePath(events, i+1), inst.EventsPath)
+		addrs[inst.ListenAddr] = true
+	}
+	require.Len(t, addrs, 3, "every instance listens on its own port")
+
+	// without sharing, the chains of the instances diverge
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	other := te.instance(t, 1)
+	require.Nil(t, other.mockChain().chain.GetHeaderByHash(other.mockChain().ResolveHash(payload.BlockHash)))
+	require.Equal(t, types.ExecutionValid, other.newPayload(t, payload))
+}
+
+func TestInstancesShare(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Instances.Count = 3
+		cmd.Instances.Share = true
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	for i := 1; i < 3; i++ {
+		other := te.instance(t, i)
+		other.requireKnownBlock(t, payload.BlockHash)
+		// the head isn't shared, the consensus client of the instance selects it
+		require.Equal(t, genesis.Hash(), other.mockChain().CurrentHeader().Hash())
+		other.setHead(t, payload.BlockHash)
+	}
+
+	// blocks sent to any instance are shared
+	other := te.instance(t, 2)
+	child := other.buildPayload(t, payload.BlockHash, payload.Timestamp+12, common.Hash{0x02})
+	require.Equal(t, types.ExecutionValid, other.newPayload(t, child))
+	te.requireKnownBlock(t, child.BlockHash)
+	te.instance(t, 1).requireKnownBlock(t, child.BlockHash)
+}
+
+func TestOffsetPort(t *testing.T) {
+	addr, err := offsetPort("127.0.0.1:8551", 2)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8553", addr)
+	addr, err = offsetPort("127.0.0.1:0", 2)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:0", addr)
+	addr, err = offsetPort("", 2)
+	require.NoError(t, err)
+	require.Empty(t, addr)
+	_, err = offsetPort("localhost", 2)
+	require.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	mmTypes "mergemock/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Ways of breaking a built payload. All but block-hash and withdrawals keep the block hash consistent with the broken
+// header, so the payload fails execution or header validation rather than the block hash check.
+const (
+	InvalidStateRoot     = "state-root"      // a state root that doesn't match the state after the transactions
+	InvalidBlockHash     = "block-hash"      // a block hash that isn't the hash of the header
+	InvalidBaseFee       = "base-fee"        // a base fee one wei above the one of the parent block
+	InvalidZeroBaseFee   = "zero-base-fee"   // a base fee of zero
+	InvalidMaxBaseFee    = "max-base-fee"    // the largest base fee the uint256 field holds
+	InvalidParentBaseFee = "parent-base-fee" // the base fee of the parent block, skipping the EIP-1559 update
+	InvalidGasLimit      = "gas-limit"       // more gas used than the gas limit allows
+	InvalidDuplicateTxs  = "duplicate-txs"   // the last transaction included a second time
+	InvalidWithdrawals   = "withdrawals"     // withdrawals that don't match the withdrawals root of the block hash
+)
+
+// InvalidSetBaseFee prefixes the base fee in wei of a kind setting it, e.g. base-fee:7.
+const InvalidSetBaseFee = "base-fee:"
+
+var maxBaseFee = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), common.Big1)
+
+type InvalidPayloadConfig struct {
+	Slots       []string      `ask:"--slot" help:"Slots to build invalid payloads at, as slot=kind pairs with ',' separated kinds state-root, block-hash, base-fee, zero-base-fee, max-base-fee, parent-base-fee, base-fee:<wei>, gas-limit, duplicate-txs or withdrawals, e.g. 32=state-root,base-fee"`
+	GenesisTime uint64        `ask:"--genesis-time" help:"Beacon genesis time the slots are counted from (0 for the timestamp of the genesis block)"`
+	SlotTime    time.Duration `ask:"--slot-time" help:"Slot duration"`
+}
+
+// NewInvalidPayloads returns the invalid payloads of the config, nil if every payload is built valid. Slots are
+// counted from the genesis time of the config, or else from the timestamp of the genesis block.
+func (c *InvalidPayloadConfig) NewInvalidPayloads(genesisTime uint64) (*InvalidPayloads, error) {
+	if len(c.Slots) == 0 {
+		return nil, nil
+	}
+	if c.SlotTime < time.Second {
+		return nil, fmt.Errorf("slot time %s is shorter than a second", c.SlotTime)
+	}
+	if c.GenesisTime != 0 {
+		genesisTime = c.GenesisTime
+	}
+	p := &InvalidPayloads{genesisTime: genesisTime, slotTime: uint64(c.SlotTime / time.Second), slots: make(map[uint64][]string)}
+	for _, s := range c.Slots {
+		slotStr, kindsStr, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid payload slot %q, expected slot=kind", s)
+		}
+		slot, err := strconv.ParseUint(slotStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot of %q: %v", s, err)
+		}
+		for _, kind := range strings.Split(kindsStr, ",") {
+			switch kind {
+			case InvalidStateRoot, InvalidBlockHash, InvalidBaseFee, InvalidZeroBaseFee, InvalidMaxBaseFee, InvalidParentBaseFee,
+				InvalidGasLimit, InvalidDuplicateTxs, InvalidWithdrawals:
+			default:
+				if _, ok := setBaseFee(kind); !ok {
+					return nil, fmt.Errorf("unknown invalid payload kind %q", kind)
+				}
+			}
+			p.slots[slot] = append(p.slots[slot], kind)
+		}
+	}
+	return p, nil
+}
+
+// InvalidPayloads makes forkchoiceUpdated build broken payloads at chosen slots, to test that the blocks of a
+// proposer on a buggy engine are detected and rejected downstream.
+type InvalidPayloads struct {
+	genesisTime uint64
+	slotTime    uint64
+	slots       map[uint64][]string
+}
+
+// At returns the slot of the timestamp and the ways to break a payload built at it, no kinds if it is built valid.
+func (p *InvalidPayloads) At(timestamp uint64) (uint64, []string) {
+	if p == nil || timestamp < p.genesisTime {
+		return 0, nil
+	}
+	slot := (timestamp - p.genesisTime) / p.slotTime
+	return slot, p.slots[slot]
+}
+
+// Slots returns the slots invalid payloads are built at, in order.
+func (p *InvalidPayloads) Slots() []uint64 {
+	if p == nil {
+		return nil
+	}
+	slots := make([]uint64, 0, len(p.slots))
+	for slot := range p.slots {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	return slots
+}
+
+// setBaseFee returns the base fee set by the kind, false if it doesn't set one.
+func setBaseFee(kind string) (*big.Int, bool) {
+	if !strings.HasPrefix(kind, InvalidSetBaseFee) {
+		return nil, false
+	}
+	fee, ok := new(big.Int).SetString(strings.TrimPrefix(kind, InvalidSetBaseFee), 10)
+	if !ok || fee.Sign() < 0 || fee.Cmp(maxBaseFee) > 0 {
+		return nil, false
+	}
+	return fee, true
+}
+
+// breakBlock returns a copy of the built block broken in the header and body ways of the kinds, with the header
+// hashes updated to match. Block-hash breakage is left to breakBlockHash, as the hash is computed from the header.
+// The parent is that of the block, for the kinds relative to it.
+func breakBlock(block *types.Block, parent *types.Header, kinds []string) *types.Block {
+	header := block.Header()
+	txs := block.Transactions()
+	for _, kind := range kinds {
+		switch kind {
+		case InvalidStateRoot:
+			header.Root = crypto.Keccak256Hash(header.Root[:])
+		case InvalidBaseFee:
+			if header.BaseFee != nil {
+				header.BaseFee = new(big.Int).Add(header.BaseFee, common.Big1)
+			}
+		case InvalidZeroBaseFee:
+			if header.BaseFee != nil {
+				header.BaseFee = new(big.Int)
+			}
+		case InvalidMaxBaseFee:
+			if header.BaseFee != nil {
+				header.BaseFee = new(big.Int).Set(maxBaseFee)
+			}
+		case InvalidParentBaseFee:
+			if header.BaseFee != nil && parent != nil && parent.BaseFee != nil {
+				header.BaseFee = new(big.Int).Set(parent.BaseFee)
+			}
+		case InvalidGasLimit:
+			header.GasUsed = header.GasLimit + 1
+		case InvalidDuplicateTxs:
+			if len(txs) > 0 {
+				txs = append(txs[:len(txs):len(txs)], txs[len(txs)-1])
+				header.TxHash = types.DeriveSha(txs, trie.NewStackTrie(nil))
+			}
+		default:
+			if fee, ok := setBaseFee(kind); ok && header.BaseFee != nil {
+				header.BaseFee = fee
+			}
+		}
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs, block.Uncles())
+}
+
+// breakBlockHash returns a block hash that isn't the one of any header, if the kinds break it.
+func breakBlockHash(hash common.Hash, kinds []string) common.Hash {
+	for _, kind := range kinds {
+		if kind == InvalidBlockHash {
+			return crypto.Keccak256Hash(hash[:])
+		}
+	}
+	return hash
+}
+
+// breakWithdrawals returns a copy of the withdrawals of a payload from Shanghai on that doesn't match the withdrawals
+// root the block hash commits to, if the kinds break them: the amount of the last withdrawal is one Gwei more, or a
+// withdrawal is made up if there is none. The CL has to recompute the root to notice.
+func breakWithdrawals(withdrawals []*mmTypes.Withdrawal, kinds []string) []*mmTypes.Withdrawal {
+	if withdrawals == nil {
+		return nil
+	}
+	for _, kind := range kinds {
+		if kind != InvalidWithdrawals {
+			continue
+		}
+		if len(withdrawals) == 0 {
+			return []*mmTypes.Withdrawal{{Address: withdrawalAddress(0), Amount: 1}}
+		}
+		broken := append([]*mmTypes.Withdrawal{}, withdrawals...)
+		last := *broken[len(broken)-1]
+		last.Amount++
+		broken[len(broken)-1] = &last
+		return broken
+	}
+	return withdrawals
+}
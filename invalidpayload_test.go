@@ -0,0 +1,63 @@
+package mergemock
+
+import (
+	"math/big"
+	"mergemock/types"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidPayloads(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)), func(cmd *EngineCmd) {
+		cmd.Txs.Default()
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Txs.Seed = 1
+		cmd.Txs.Mode = TxModeTransfer
+		cmd.Txs.Count = 2
+		cmd.Invalid.Slots = []string{"1=state-root", "2=block-hash", "3=base-fee", "4=gas-limit", "5=duplicate-txs", "6=state-root,block-hash",
+			"8=zero-base-fee", "9=max-base-fee", "10=parent-base-fee", "11=base-fee:7"}
+	})
+	genesis := te.mockChain().CurrentHeader()
+	for slot, expected := range map[uint64]types.ExecutePayloadStatus{
+		1:  types.ExecutionInvalid,
+		2:  types.ExecutionInvalidBlockHash,
+		3:  types.ExecutionInvalid,
+		4:  types.ExecutionInvalid,
+		5:  types.ExecutionInvalid,
+		6:  types.ExecutionInvalidBlockHash,
+		7:  types.ExecutionValid,
+		8:  types.ExecutionInvalid,
+		9:  types.ExecutionInvalid,
+		10: types.ExecutionInvalid,
+		11: types.ExecutionInvalid,
+	} {
+		payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12*slot, common.Hash{byte(slot)})
+		require.Equal(t, expected == types.ExecutionInvalidBlockHash, !payload.ValidateHash(), "slot %d", slot)
+		require.Equal(t, expected, te.newPayload(t, payload), "slot %d", slot)
+		switch slot {
+		case 5:
+			require.Len(t, payload.Transactions, 3)
+			require.Equal(t, payload.Transactions[1], payload.Transactions[2])
+		case 8:
+			require.Zero(t, payload.BaseFeePerGas.Sign())
+		case 9:
+			require.Equal(t, maxBaseFee, payload.BaseFeePerGas)
+		case 10:
+			require.Equal(t, genesis.BaseFee, payload.BaseFeePerGas)
+		case 11:
+			require.Equal(t, big.NewInt(7), payload.BaseFeePerGas)
+		}
+	}
+
+	for _, slots := range [][]string{{"1"}, {"x=state-root"}, {"1=state-root,wrong-nonce"}, {"1=base-fee:-1"}, {"1=base-fee:0x10"}} {
+		cfg := InvalidPayloadConfig{Slots: slots, SlotTime: 12 * time.Second}
+		_, err := cfg.NewInvalidPayloads(0)
+		require.Error(t, err, slots)
+	}
+}
@@ -0,0 +1,105 @@
+package mergemock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Number of chain mutations kept in the journal, older ones are dropped.
+const journalSize = 4096
+
+// Kinds of chain mutations recorded in the journal.
+const (
+	JournalMine    = "mine"    // a proof-of-work block of the terminal chain was mined
+	JournalImport  = "import"  // an executed payload was stored, without becoming the head
+	JournalBuild   = "build"   // a payload was built for the consensus client, without being stored
+	JournalFork    = "fork"    // a block of a competing fork was built and stored
+	JournalHead    = "head"    // the canonical head moved onto a descendant of the previous head
+	JournalReorg   = "reorg"   // the canonical head moved onto another branch
+	JournalRestore = "restore" // the payload cache and forkchoice state were restored from a checkpoint
+)
+
+// JournalEntry is a mutation of the mock chain, with what triggered it: the engine or mock method, or the
+// engine feature acting on its own, e.g. auto-reorg.
+type JournalEntry struct {
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	Kind    string      `json:"kind"`
+	Trigger string      `json:"trigger"`
+	Block   common.Hash `json:"block"`
+	Number  uint64      `json:"number"`
+	Parent  common.Hash `json:"parent"`
+	// OldHead is the head replaced by head and reorg mutations, with the number of blocks reorged out.
+	OldHead *common.Hash `json:"oldHead,omitempty"`
+	Depth   uint64       `json:"depth,omitempty"`
+}
+
+// Journal records the mutations of a mock chain in order, for post-hoc analysis of how the chain evolved.
+type Journal struct {
+	mu      sync.Mutex
+	seq     uint64
+	entries []JournalEntry
+}
+
+func (j *Journal) record(entry JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	entry.Seq, entry.Time = j.seq, time.Now().UTC()
+	j.entries = append(j.entries, entry)
+	if len(j.entries) > journalSize {
+		j.entries = append([]JournalEntry(nil), j.entries[len(j.entries)-journalSize:]...)
+	}
+}
+
+// Entries returns the recorded mutations, oldest first, only those of the block with the spec hash if not nil:
+// the mutations of the block itself, of its children, and heads moving away from it.
+func (j *Journal) Entries(block *common.Hash) []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if block == nil || e.Block == *block || e.Parent == *block || (e.OldHead != nil && *e.OldHead == *block) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// recordBlock journals a mutation of a block, which is stored under its geth hash unless it is a built payload.
+func (c *MockChain) recordBlock(kind, trigger string, specHash common.Hash, block *types.Block) {
+	c.journal.record(JournalEntry{Kind: kind, Trigger: trigger, Block: specHash, Number: block.NumberU64(), Parent: c.SpecHash(block.ParentHash())})
+	if kind != JournalBuild {
+		c.shared.share(c, block)
+	}
+}
+
+// recordHead journals the move of the canonical head, as a reorg if the new head doesn't descend from the old.
+func (c *MockChain) recordHead(trigger string, oldHead, newHead *types.Block) {
+	oldHash := c.SpecHash(oldHead.Hash())
+	entry := JournalEntry{Kind: JournalHead, Trigger: trigger, Block: c.SpecHash(newHead.Hash()), Number: newHead.NumberU64(), Parent: c.SpecHash(newHead.ParentHash()), OldHead: &oldHash}
+	if ancestor := rawdb.FindCommonAncestor(c.database, oldHead.Header(), newHead.Header()); ancestor != nil && ancestor.Hash() != oldHead.Hash() {
+		entry.Kind, entry.Depth = JournalReorg, oldHead.NumberU64()-ancestor.Number.Uint64()
+		c.events.Reorg(entry, newHead.Time())
+	}
+	c.journal.record(entry)
+}
+
+// recordRestore journals the restore of a checkpoint with the forkchoice head.
+func (c *MockChain) recordRestore(trigger string, head common.Hash) {
+	entry := JournalEntry{Kind: JournalRestore, Trigger: trigger, Block: head}
+	if header := c.chain.GetHeaderByHash(c.ResolveHash(head)); header != nil {
+		entry.Number, entry.Parent = header.Number.Uint64(), c.SpecHash(header.ParentHash)
+	}
+	c.journal.record(entry)
+}
+
+// GetJournal returns the mutations of the chain in order, of the block with the hash if given.
+func (b *MockBackend) GetJournal(ctx context.Context, blockHash *common.Hash) []JournalEntry {
+	return b.engine.mockChain.journal.Entries(blockHash)
+}
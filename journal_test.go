@@ -0,0 +1,75 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	getJournal := func(block *common.Hash) []JournalEntry {
+		var entries []JournalEntry
+		params := []interface{}{}
+		if block != nil {
+			params = append(params, *block)
+		}
+		require.NoError(t, te.client.CallContext(ctx, &entries, "mock_getJournal", params...))
+		return entries
+	}
+	kinds := func(entries []JournalEntry) []string {
+		var kinds []string
+		for _, e := range entries {
+			kinds = append(kinds, e.Kind+" "+e.Trigger)
+		}
+		return kinds
+	}
+	base := len(getJournal(nil))
+
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	te.setHead(t, payload.BlockHash)
+	var reorg TimelineReorg
+	require.NoError(t, te.client.CallContext(ctx, &reorg, "mock_triggerReorg", 1, 2))
+	var state json.RawMessage
+	require.NoError(t, te.client.CallContext(ctx, &state, "mock_saveState"))
+	require.NoError(t, te.client.CallContext(ctx, nil, "mock_restoreState", state))
+
+	entries := getJournal(nil)[base:]
+	require.Equal(t, []string{
+		"build engine_forkchoiceUpdated",
+		"import engine_newPayload",
+		"head engine_forkchoiceUpdated",
+		"fork mock_triggerReorg",
+		"fork mock_triggerReorg",
+		"reorg mock_triggerReorg",
+		"restore mock_restoreState",
+	}, kinds(entries))
+	for i := 1; i < len(entries); i++ {
+		require.Equal(t, entries[i-1].Seq+1, entries[i].Seq)
+		require.False(t, entries[i].Time.Before(entries[i-1].Time))
+	}
+	require.Equal(t, payload.BlockHash, entries[1].Block)
+	require.Equal(t, uint64(1), entries[1].Number)
+	require.Equal(t, genesis.Hash(), entries[1].Parent)
+	require.Equal(t, genesis.Hash(), *entries[2].OldHead)
+	require.Zero(t, entries[2].Depth)
+	require.Equal(t, reorg.NewHead, entries[5].Block)
+	require.Equal(t, payload.BlockHash, *entries[5].OldHead)
+	require.Equal(t, uint64(1), entries[5].Depth)
+
+	// The entries of a block include its children, and the heads moving away from it.
+	require.Equal(t, []string{
+		"build engine_forkchoiceUpdated",
+		"import engine_newPayload",
+		"head engine_forkchoiceUpdated",
+		"reorg mock_triggerReorg",
+		"restore mock_restoreState",
+	}, kinds(getJournal(&payload.BlockHash)))
+}
@@ -0,0 +1,94 @@
+package mergemock
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type LatencyBudgetConfig struct {
+	Budgets []string `ask:"--budget" help:"Latency budgets per JSON-RPC method, as method=duration, e.g. engine_newPayloadV1=1s"`
+}
+
+// NewMonitor parses the budgets. Without budgets the monitor is nil, which observes nothing.
+func (c *LatencyBudgetConfig) NewMonitor(log logrus.Ext1FieldLogger) (*LatencyMonitor, error) {
+	if len(c.Budgets) == 0 {
+		return nil, nil
+	}
+	budgets := make(map[string]time.Duration, len(c.Budgets))
+	for _, b := range c.Budgets {
+		method, budget, ok := strings.Cut(b, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid latency budget %q, expected method=duration", b)
+		}
+		d, err := time.ParseDuration(budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency budget for %s: %v", method, err)
+		}
+		budgets[method] = d
+	}
+	return &LatencyMonitor{log: log, budgets: budgets, alerts: make(map[string]uint64)}, nil
+}
+
+// LatencyMonitor raises an alert for every call that takes longer than the budget of its method.
+type LatencyMonitor struct {
+	log     logrus.Ext1FieldLogger
+	budgets map[string]time.Duration
+
+	mu     sync.Mutex
+	alerts map[string]uint64
+}
+
+// Track starts timing a call, and observes it when the returned function is called.
+func (m *LatencyMonitor) Track(method string) func() {
+	start := time.Now()
+	return func() { m.Observe(method, time.Since(start)) }
+}
+
+func (m *LatencyMonitor) Observe(method string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	budget, ok := m.budgets[method]
+	if !ok || latency <= budget {
+		return
+	}
+	m.mu.Lock()
+	m.alerts[method]++
+	m.mu.Unlock()
+	m.log.WithFields(logrus.Fields{
+		"method":  method,
+		"latency": latency,
+		"budget":  budget,
+	}).Warn("Latency budget exceeded")
+}
+
+// Alerts returns the number of budget violations per method.
+func (m *LatencyMonitor) Alerts() map[string]uint64 {
+	alerts := make(map[string]uint64)
+	if m == nil {
+		return alerts
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for method, n := range m.alerts {
+		alerts[method] = n
+	}
+	return alerts
+}
+
+// LogSummary logs the alert counts, if any budget was exceeded.
+func (m *LatencyMonitor) LogSummary() {
+	alerts := m.Alerts()
+	if len(alerts) == 0 {
+		return
+	}
+	fields := logrus.Fields{}
+	for method, n := range alerts {
+		fields[method] = n
+	}
+	m.log.WithFields(fields).Warn("Latency budgets exceeded during run")
+}
@@ -0,0 +1,35 @@
+package mergemock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	cfg := LatencyBudgetConfig{Budgets: []string{"engine_newPayloadV1=1s", "engine_getPayloadV1=100ms"}}
+	monitor, err := cfg.NewMonitor(logrus.New())
+	require.NoError(t, err)
+
+	monitor.Observe("engine_newPayloadV1", 500*time.Millisecond)
+	monitor.Observe("engine_newPayloadV1", 2*time.Second)
+	monitor.Observe("engine_getPayloadV1", time.Second)
+	monitor.Observe("engine_getPayloadV1", time.Second)
+	monitor.Observe("engine_forkchoiceUpdatedV1", time.Hour) // no budget
+	require.Equal(t, map[string]uint64{"engine_newPayloadV1": 1, "engine_getPayloadV1": 2}, monitor.Alerts())
+
+	// Without budgets nothing is observed.
+	var none LatencyBudgetConfig
+	monitor, err = none.NewMonitor(logrus.New())
+	require.NoError(t, err)
+	monitor.Track("engine_newPayloadV1")()
+	require.Empty(t, monitor.Alerts())
+
+	for _, budget := range []string{"engine_newPayloadV1", "engine_newPayloadV1=fast"} {
+		cfg := LatencyBudgetConfig{Budgets: []string{budget}}
+		_, err := cfg.NewMonitor(logrus.New())
+		require.Error(t, err, budget)
+	}
+}
@@ -0,0 +1,127 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/protolambda/ask"
+)
+
+// EngineOptions configure an engine started in-process. Empty fields take the defaults of the engine command,
+// except for the addresses, which default to port 0 on localhost for free ports, and the JWT secret, which
+// defaults to a random one in a temporary directory.
+type EngineOptions struct {
+	// GenesisPath is the genesis file, the embedded default genesis if empty.
+	GenesisPath   string
+	JwtSecretPath string
+	ListenAddr    string
+	WebsocketAddr string
+	// Configure changes any other flag of the engine command, after the defaults are applied.
+	Configure func(cmd *EngineCmd)
+}
+
+// Engine is a mock execution engine running in-process, for Go integration tests of consensus clients to drive
+// over its authenticated endpoints instead of running the mergemock binary.
+type Engine struct {
+	cmd     *EngineCmd
+	tmpDir  string
+	started bool
+}
+
+// NewEngine configures an engine with the options, to be started with Start.
+func NewEngine(opts EngineOptions) (*Engine, error) {
+	cmd := new(EngineCmd)
+	// loading the command applies the defaults of all flag groups, like the command line does
+	if _, err := ask.Load(cmd); err != nil {
+		return nil, fmt.Errorf("failed to load engine command: %v", err)
+	}
+	e := &Engine{cmd: cmd}
+	cmd.GenesisPath = opts.GenesisPath
+	cmd.JwtSecretPath = opts.JwtSecretPath
+	if cmd.JwtSecretPath == "" {
+		dir, err := os.MkdirTemp("", "mergemock")
+		if err != nil {
+			return nil, err
+		}
+		e.tmpDir = dir
+		cmd.JwtSecretPath = filepath.Join(dir, "jwt.hex")
+	}
+	cmd.ListenAddr, cmd.WebsocketAddr = "127.0.0.1:0", "127.0.0.1:0"
+	if opts.ListenAddr != "" {
+		cmd.ListenAddr = opts.ListenAddr
+	}
+	if opts.WebsocketAddr != "" {
+		cmd.WebsocketAddr = opts.WebsocketAddr
+	}
+	if opts.Configure != nil {
+		opts.Configure(cmd)
+	}
+	return e, nil
+}
+
+// Start starts the engine, returning once it listens on its addresses. An engine that fails to start releases what
+// it opened before returning the error.
+func (e *Engine) Start(ctx context.Context) error {
+	if err := e.cmd.Run(ctx); err != nil {
+		return fmt.Errorf("failed to start engine: %v", err)
+	}
+	e.started = true
+	return nil
+}
+
+// Stop shuts the engine down, writing the files of its flags like on interrupt of the command.
+func (e *Engine) Stop() error {
+	var err error
+	if e.started {
+		err = e.cmd.Close()
+		e.started = false
+	}
+	e.removeTmpDir()
+	return err
+}
+
+func (e *Engine) removeTmpDir() {
+	if e.tmpDir != "" {
+		os.RemoveAll(e.tmpDir)
+		e.tmpDir = ""
+	}
+}
+
+// URL is the authenticated HTTP endpoint of the engine API, on the bound port once the engine started.
+func (e *Engine) URL() string {
+	return "http://" + e.cmd.ListenAddr
+}
+
+// WebsocketURL is the authenticated websocket endpoint of the engine API.
+func (e *Engine) WebsocketURL() string {
+	return "ws://" + e.cmd.WebsocketAddr + "/ws"
+}
+
+// JwtSecret is the secret to authenticate to the engine with, known once the engine started. It changes with
+// mock_rotateJwtSecret and Reload.
+func (e *Engine) JwtSecret() []byte {
+	if e.cmd.backend == nil {
+		return e.cmd.jwtSecret
+	}
+	return e.cmd.backend.auth.Secret()
+}
+
+// Reload reloads the JWT secret, and the fault rules and delays of the config file, like SIGHUP does for the command.
+func (e *Engine) Reload() error {
+	return e.cmd.Reload()
+}
+
+// Backend is the engine API backend, to inspect or change the mock chain directly, once the engine started.
+func (e *Engine) Backend() *EngineBackend {
+	return e.cmd.backend
+}
+
+// MockChain is the chain of the engine, once it started.
+func (e *Engine) MockChain() *MockChain {
+	if e.cmd.backend == nil {
+		return nil
+	}
+	return e.cmd.backend.mockChain
+}
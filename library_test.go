@@ -0,0 +1,68 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/rpc"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibraryEngine(t *testing.T) {
+	ctx := context.Background()
+	engine, err := NewEngine(EngineOptions{
+		Configure: func(cmd *EngineCmd) { cmd.LogLvl = "warn" },
+	})
+	require.NoError(t, err)
+	require.NoError(t, engine.Start(ctx))
+	defer engine.Stop()
+
+	client, err := rpc.DialContext(ctx, engine.URL(), engine.JwtSecret())
+	require.NoError(t, err)
+	defer client.Close()
+	var block map[string]interface{}
+	require.NoError(t, client.CallContext(ctx, &block, "eth_getBlockByNumber", "latest", false))
+	require.Equal(t, engine.MockChain().CurrentHeader().Hash().Hex(), block["hash"])
+	require.NotNil(t, engine.Backend())
+
+	// Errors that make the command exit are returned.
+	genesis := filepath.Join(t.TempDir(), "genesis.json")
+	require.NoError(t, os.WriteFile(genesis, []byte("{"), 0644))
+	broken, err := NewEngine(EngineOptions{GenesisPath: genesis, Configure: func(cmd *EngineCmd) { cmd.LogLvl = "fatal" }})
+	require.NoError(t, err)
+	err = broken.Start(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to initialize mock chain")
+	require.NoError(t, broken.Stop())
+}
+
+func TestLibraryEngineFailedStartReleases(t *testing.T) {
+	ctx := context.Background()
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	dataDir := filepath.Join(t.TempDir(), "chain")
+	engine, err := NewEngine(EngineOptions{
+		WebsocketAddr: taken.Addr().String(),
+		Configure: func(cmd *EngineCmd) {
+			cmd.LogLvl = "fatal"
+			cmd.DataDir = dataDir
+		},
+	})
+	require.NoError(t, err)
+	err = engine.Start(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to listen on websocket address")
+
+	// The RPC address bound before the failure is free again, and so is the database.
+	l, err := net.Listen("tcp", engine.cmd.ListenAddr)
+	require.NoError(t, err)
+	l.Close()
+	db, err := NewDB(dataDir)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, engine.Stop())
+}
@@ -0,0 +1,181 @@
+package mergemock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/sirupsen/logrus"
+)
+
+// txPoolSize is the most transactions the mempool holds.
+const txPoolSize = 4096
+
+var (
+	errTxPoolFull    = errors.New("txpool is full")
+	errTxKnown       = errors.New("already known")
+	errTxNonceTooLow = errors.New("nonce too low")
+)
+
+type poolTx struct {
+	tx       *types.Transaction
+	from     common.Address
+	received time.Time
+}
+
+// TxPool holds the transactions sent with eth_sendRawTransaction until a canonical block includes them.
+// Built payloads include them ahead of generated transactions, in nonce order per sender.
+type TxPool struct {
+	log    logrus.Ext1FieldLogger
+	signer types.Signer
+
+	mu  sync.Mutex
+	txs map[common.Hash]*poolTx
+	// senders are ordered by their first transaction in the pool.
+	senders []common.Address
+
+	// feed notifies the transactions added to the pool.
+	feed event.Feed
+}
+
+func NewTxPool(log logrus.Ext1FieldLogger, config *params.ChainConfig) *TxPool {
+	return &TxPool{
+		log:    log,
+		signer: types.LatestSigner(config),
+		txs:    make(map[common.Hash]*poolTx),
+	}
+}
+
+// Add validates the transaction against the state of the head, and adds it to the pool.
+func (p *TxPool) Add(tx *types.Transaction, head *state.StateDB) error {
+	from, err := types.Sender(p.signer, tx)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %v", err)
+	}
+	if nonce := head.GetNonce(from); tx.Nonce() < nonce {
+		return fmt.Errorf("%w: address %s, tx: %d state: %d", errTxNonceTooLow, from, tx.Nonce(), nonce)
+	}
+	if err := p.add(tx, from); err != nil {
+		return err
+	}
+	p.log.WithFields(logrus.Fields{"tx": tx.Hash(), "from": from, "nonce": tx.Nonce()}).Debug("Added transaction to mempool")
+	// notified outside of the lock, subscribers may be reading the pool
+	p.feed.Send(core.NewTxsEvent{Txs: []*types.Transaction{tx}})
+	return nil
+}
+
+func (p *TxPool) add(tx *types.Transaction, from common.Address) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.txs[tx.Hash()]; ok {
+		return errTxKnown
+	}
+	if len(p.txs) >= txPoolSize {
+		return errTxPoolFull
+	}
+	if !p.hasSender(from) {
+		p.senders = append(p.senders, from)
+	}
+	p.txs[tx.Hash()] = &poolTx{tx: tx, from: from, received: time.Now()}
+	return nil
+}
+
+// SubscribeNewTxsEvent subscribes the channel to the transactions added to the pool.
+func (p *TxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return p.feed.Subscribe(ch)
+}
+
+func (p *TxPool) hasSender(from common.Address) bool {
+	for _, sender := range p.senders {
+		if sender == from {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of transactions in the pool.
+func (p *TxPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.txs)
+}
+
+// Pending returns the transactions executable on the state: per sender, those with consecutive nonces from its
+// nonce in the state.
+func (p *TxPool) Pending(statedb *state.StateDB) []*types.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bySender := make(map[common.Address][]*types.Transaction)
+	for _, ptx := range p.txs {
+		bySender[ptx.from] = append(bySender[ptx.from], ptx.tx)
+	}
+	var pending []*types.Transaction
+	for _, from := range p.senders {
+		txs := bySender[from]
+		sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce() < txs[j].Nonce() })
+		nonce := statedb.GetNonce(from)
+		for _, tx := range txs {
+			if tx.Nonce() < nonce {
+				continue
+			}
+			if tx.Nonce() > nonce {
+				break
+			}
+			pending = append(pending, tx)
+			nonce++
+		}
+	}
+	return pending
+}
+
+// Prune removes the transactions the canonical chain included, logging how long they took to be included,
+// and those whose nonce has been used by another transaction.
+func (p *TxPool) Prune(chain *core.BlockChain) {
+	head, err := chain.State()
+	if err != nil {
+		p.log.WithError(err).Warn("Failed to open head state, cannot prune mempool")
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for hash, ptx := range p.txs {
+		if lookup := chain.GetTransactionLookup(hash); lookup != nil {
+			p.log.WithFields(logrus.Fields{
+				"tx":      hash,
+				"block":   lookup.BlockIndex,
+				"latency": time.Since(ptx.received),
+			}).Info("Mempool transaction included")
+			delete(p.txs, hash)
+		} else if ptx.tx.Nonce() < head.GetNonce(ptx.from) {
+			p.log.WithField("tx", hash).Debug("Dropped mempool transaction with used nonce")
+			delete(p.txs, hash)
+		}
+	}
+	senders := p.senders[:0]
+	for _, from := range p.senders {
+		for _, ptx := range p.txs {
+			if ptx.from == from {
+				senders = append(senders, from)
+				break
+			}
+		}
+	}
+	p.senders = senders
+}
+
+// Creator returns a transactions creator of the pending transactions, followed by those of the given creator.
+func (p *TxPool) Creator(next TransactionsCreator) TransactionsCreator {
+	return TransactionsCreator{next.accounts, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *types.Header, cfg vm.Config, accounts []TestAccount) []*types.Transaction {
+		return append(p.Pending(statedb), next.fn(config, bc, statedb, header, cfg, accounts)...)
+	}}
+}
@@ -0,0 +1,72 @@
+package mergemock
+
+import (
+	"context"
+	"math/big"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMempool(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	te := newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)))
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	config := te.mockChain().chain.Config()
+	signer := ethTypes.LatestSigner(config)
+	send := func(nonce uint64) (common.Hash, error) {
+		tx := ethTypes.MustSignNewTx(key, signer, &ethTypes.DynamicFeeTx{
+			ChainID:   config.ChainID,
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(10_000_000_000),
+			Gas:       transferGas,
+			To:        &common.Address{0x01},
+			Value:     big.NewInt(1),
+		})
+		enc, err := tx.MarshalBinary()
+		require.NoError(t, err)
+		var hash common.Hash
+		err = te.client.CallContext(ctx, &hash, "eth_sendRawTransaction", hexutil.Bytes(enc))
+		return hash, err
+	}
+	first, err := send(0)
+	require.NoError(t, err)
+	_, err = send(0)
+	require.EqualError(t, err, errTxKnown.Error())
+	second, err := send(1)
+	require.NoError(t, err)
+	// The nonce gap keeps the last one out of payloads.
+	_, err = send(3)
+	require.NoError(t, err)
+
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	txs := decodeTxs(t, payload)
+	require.Len(t, txs, 2)
+	require.Equal(t, first, txs[0].Hash())
+	require.Equal(t, second, txs[1].Hash())
+
+	var stats Stats
+	require.NoError(t, te.client.CallContext(ctx, &stats, "mock_stats"))
+	require.Equal(t, 3, stats.PendingTxs)
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	_, err = api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, genesis.Hash(), genesis.Hash(), nil)
+	require.NoError(t, err)
+	require.NoError(t, te.client.CallContext(ctx, &stats, "mock_stats"))
+	require.Equal(t, 1, stats.PendingTxs, "included transactions leave the mempool")
+
+	_, err = send(0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errTxNonceTooLow.Error())
+	var receipt map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &receipt, "eth_getTransactionReceipt", second))
+	require.Equal(t, "0x1", receipt["status"])
+}
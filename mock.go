@@ -1,13 +1,16 @@
-package main
+package mergemock
 
 import (
 	"bytes"
+	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	mmTypes "mergemock/types"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/sha3"
 )
@@ -41,6 +45,8 @@ type ExecutionConsensusMock struct {
 	// TODO: set terminal total difficulty, and switch from ethash to pos
 	pow *ethash.Ethash
 	log logrus.Ext1FieldLogger
+	// db holds the fork fields of blocks, set by the mock chain using the engine
+	db ethdb.KeyValueReader
 }
 
 func (e *ExecutionConsensusMock) Author(header *types.Header) (common.Address, error) {
@@ -96,6 +102,20 @@ func (e *ExecutionConsensusMock) Prepare(chain consensus.ChainHeaderReader, head
 
 func (e *ExecutionConsensusMock) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
 	// no block rewards, consensus layer does that instead.
+	// Withdrawals are not part of the geth block, they were stored under its hash before insertion.
+	// The beacon root and the block hash history are written after the transactions here, which can't change
+	// them, only read them.
+	if e.db != nil {
+		if fork := readForkFields(e.db, header.Hash()); fork != nil {
+			applyWithdrawals(state, fork.Withdrawals)
+			if fork.ParentBeaconRoot != nil {
+				applyBeaconRoot(state, header.Time, *fork.ParentBeaconRoot)
+			}
+			if fork.RequestsHash != nil {
+				applyBlockHashHistory(state, header.Number.Uint64(), readHash(e.db, gethToSpecHashPrefix, header.ParentHash))
+			}
+		}
+	}
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 }
 
@@ -184,6 +204,38 @@ type MockChain struct {
 	gspec     *core.Genesis
 	log       logrus.Ext1FieldLogger
 	traceOpts *TraceLogConfig
+
+	forks    *forkTimes
+	terminal *terminalBlock
+
+	buildLogs *lru.Cache
+	pool      *TxPool
+	journal   *Journal
+	events    *EventLog
+	shared    *sharedChains
+
+	builtForks uint64
+
+	// mu serializes the mutations of the stored chain, block inserts and moves of the head. Blocks are executed
+	// outside of it, so that payloads are built and imported in parallel.
+	mu sync.Mutex
+}
+
+// AutoDataDir is the datadir value that makes a command store its chain data in a fresh
+// temporary directory, which is removed again when the command is closed.
+const AutoDataDir = "auto"
+
+// resolveDataDir returns the directory to open the database in, and a function that removes it
+// again if it was created for this run only.
+func resolveDataDir(dataDir string) (string, func() error, error) {
+	if dataDir != AutoDataDir {
+		return dataDir, func() error { return nil }, nil
+	}
+	dir, err := os.MkdirTemp("", "mergemock-")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create temporary datadir: %v", err)
+	}
+	return dir, func() error { return os.RemoveAll(dir) }, nil
 }
 
 func NewDB(dataDir string) (ethdb.Database, error) {
@@ -194,15 +246,65 @@ func NewDB(dataDir string) (ethdb.Database, error) {
 	}
 }
 
-func NewMockChain(log logrus.Ext1FieldLogger, engine consensus.Engine, genesisPath string, db ethdb.Database, traceOpts *TraceLogConfig) (*MockChain, error) {
+// NewMockChain opens the chain of the genesis in the db, committing the genesis first if the db is empty. The
+// prefunded accounts are allocated in the genesis, unless it allocates them itself.
+func NewMockChain(log logrus.Ext1FieldLogger, engine consensus.Engine, genesisPath string, db ethdb.Database, traceOpts *TraceLogConfig, prefund core.GenesisAlloc) (*MockChain, error) {
+	return newMockChain(log, engine, genesisPath, db, traceOpts, prefund, nil, nil)
+}
+
+// newMockChain is NewMockChain with the genesis forked from a live chain, if the shadow state isn't nil, and
+// the fork times of the genesis overridden, if the fork times config isn't nil.
+func newMockChain(log logrus.Ext1FieldLogger, engine consensus.Engine, genesisPath string, db ethdb.Database, traceOpts *TraceLogConfig, prefund core.GenesisAlloc, shadow *ShadowState, forkOverrides *ForkTimesConfig) (*MockChain, error) {
 	// Geth logs some things globally unfortunately.
 	// If we were using multiple mocks, we wouldn't know which one is logging what :(
 	gethlog.Root().SetHandler(&GethLogger{FieldLogger: log, Adjust: 0})
 
-	genesis, err := LoadGenesisConfig(genesisPath)
+	buf, isDefault, err := ReadGenesis(genesisPath)
+	if err != nil {
+		return nil, err
+	}
+	if isDefault {
+		log.WithField("path", genesisPath).Warn("Genesis file not found, using the embedded default genesis")
+	}
+	genesis, err := LoadGenesisConfig(buf)
 	if err != nil {
 		return nil, err
 	}
+	if shadow != nil {
+		shadow.apply(log, genesis)
+	}
+	for addr, account := range prefund {
+		if genesis.Alloc == nil {
+			genesis.Alloc = make(core.GenesisAlloc)
+		}
+		if _, ok := genesis.Alloc[addr]; !ok {
+			genesis.Alloc[addr] = account
+		}
+	}
+	forks, err := loadForkTimes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if forkOverrides != nil {
+		if err := forkOverrides.apply(forks, time.Now()); err != nil {
+			return nil, err
+		}
+		if genesis.Config != nil {
+			if err := forkOverrides.applyTTD(genesis.Config); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := validateGenesis(genesis, forks); err != nil {
+		return nil, err
+	}
+	terminal, err := loadTerminalBlock(buf)
+	if err != nil {
+		return nil, err
+	}
+	if mock, ok := engine.(*ExecutionConsensusMock); ok {
+		mock.db = db
+	}
 
 	stored := rawdb.ReadCanonicalHash(db, 0)
 	if (stored == common.Hash{}) {
@@ -216,6 +318,10 @@ func NewMockChain(log logrus.Ext1FieldLogger, engine consensus.Engine, genesisPa
 	if err != nil {
 		return nil, err
 	}
+	buildLogs, err := lru.New(buildLogCacheSize)
+	if err != nil {
+		return nil, err
+	}
 
 	return &MockChain{
 		chain:     bc,
@@ -224,6 +330,13 @@ func NewMockChain(log logrus.Ext1FieldLogger, engine consensus.Engine, genesisPa
 		gspec:     genesis,
 		log:       log,
 		traceOpts: traceOpts,
+
+		forks:    forks,
+		terminal: terminal,
+
+		buildLogs: buildLogs,
+		pool:      NewTxPool(log, genesis.Config),
+		journal:   &Journal{},
 	}, nil
 }
 
@@ -240,18 +353,42 @@ func (c *MockChain) CurrentTd() *big.Int {
 }
 
 // Custom block builder, to change more things, fake time more easily, deal with difficulty etc.
-func (c *MockChain) AddNewBlock(parentHash common.Hash, coinbase common.Address, timestamp uint64, gasLimit uint64, txsCreator TransactionsCreator, prevRandao common.Hash, extraData []byte, uncles []*types.Header, storeBlock bool) (*types.Block, error) {
-	parent := c.chain.GetHeaderByHash(parentHash)
+// The parent may be referred to by its spec hash. From Shanghai on nil withdrawals mean no withdrawals.
+func (c *MockChain) AddNewBlock(parentHash common.Hash, coinbase common.Address, timestamp uint64, gasLimit uint64, txsCreator TransactionsCreator, prevRandao common.Hash, extraData []byte, uncles []*types.Header, withdrawals []*mmTypes.Withdrawal, storeBlock bool) (*types.Block, error) {
+	block, _, _, err := c.buildBlock(parentHash, coinbase, timestamp, gasLimit, txsCreator, prevRandao, extraData, uncles, withdrawals, nil, storeBlock)
+	return block, err
+}
+
+// buildBlock is AddNewBlock with a parent beacon block root, which defaults to zero from Cancun on.
+// It additionally returns the receipts and fork fields of the block.
+func (c *MockChain) buildBlock(parentHash common.Hash, coinbase common.Address, timestamp uint64, gasLimit uint64, txsCreator TransactionsCreator, prevRandao common.Hash, extraData []byte, uncles []*types.Header, withdrawals []*mmTypes.Withdrawal, parentBeaconRoot *common.Hash, storeBlock bool) (*types.Block, types.Receipts, *mmTypes.ForkFields, error) {
+	parent := c.chain.GetHeaderByHash(c.ResolveHash(parentHash))
 	if parent == nil {
-		return nil, fmt.Errorf("unknown parent %s", parentHash)
+		return nil, nil, nil, fmt.Errorf("unknown parent %s", parentHash)
+	}
+	if c.IsShanghai(timestamp) && withdrawals == nil {
+		withdrawals = []*mmTypes.Withdrawal{}
+	}
+	if c.IsCancun(timestamp) && parentBeaconRoot == nil {
+		parentBeaconRoot = &common.Hash{}
+	}
+	var requestsHash *common.Hash
+	if c.IsPrague(timestamp) {
+		// built blocks have no execution requests, no system contract produces any
+		hash := mmTypes.ExecutionRequests{}.Hash()
+		requestsHash = &hash
+	}
+	fork, err := c.newForkFields(parent, timestamp, withdrawals, parentBeaconRoot, requestsHash)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	config := c.gspec.Config
 	statedb, err := state.New(parent.Root, state.NewDatabase(c.database), nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	header := &types.Header{
-		ParentHash: parentHash,
+		ParentHash: parent.Hash(),
 		Coinbase:   coinbase,
 		Difficulty: common.Big0,
 		Number:     new(big.Int).Add(parent.Number, common.Big1),
@@ -284,15 +421,34 @@ func (c *MockChain) AddNewBlock(parentHash common.Hash, coinbase common.Address,
 		vmconf.Tracer = stl
 	}
 
-	txs := txsCreator.Create(config, c.chain, statedb, header, vmconf)
-	for i, tx := range txs {
+	if parentBeaconRoot != nil {
+		applyBeaconRoot(statedb, timestamp, *parentBeaconRoot)
+	}
+	if c.IsPrague(timestamp) {
+		applyBlockHashHistory(statedb, header.Number.Uint64(), c.SpecHash(parent.Hash()))
+	}
+
+	// Candidates that can't be applied are left out of the block, like a miner would, and the reason is logged.
+	buildLog := &BuildLog{Number: header.Number.Uint64()}
+	var txs []*types.Transaction
+	for _, tx := range txsCreator.Create(config, c.chain, statedb, header, vmconf) {
+		i := len(txs)
+		// Logs are tracked per tx hash and index, without this receipts come out without logs.
+		statedb.Prepare(tx.Hash(), i)
+		snap, gas := statedb.Snapshot(), gasPool.Gas()
 		receipt, err := core.ApplyTransaction(config, c.chain, &header.Coinbase, gasPool, statedb, header, tx, &header.GasUsed, vmconf)
 		if err != nil {
-			return nil, fmt.Errorf("failed to apply transaction %d: %v", i, err)
+			statedb.RevertToSnapshot(snap)
+			*gasPool = core.GasPool(gas)
+			buildLog.exclude(tx, err)
+			c.log.WithField("tx", tx.Hash()).WithError(err).Debug("Excluded transaction from block")
+			continue
 		}
 		rec, _ := json.MarshalIndent(receipt, "  ", "  ")
 		c.log.WithField("receipt_index", i).Debug("receipt:\n" + string(rec))
+		txs = append(txs, tx)
 		receipts = append(receipts, receipt)
+		buildLog.include(tx, receipt)
 	}
 	if c.traceOpts.EnableTrace {
 		var buf bytes.Buffer
@@ -301,30 +457,43 @@ func (c *MockChain) AddNewBlock(parentHash common.Hash, coinbase common.Address,
 	}
 
 	header.GasUsed = header.GasLimit - uint64(*gasPool)
+	applyWithdrawals(statedb, withdrawals)
 	header.Root = statedb.IntermediateRoot(config.IsEIP158(header.Number))
 	block := types.NewBlock(header, txs, uncles, receipts, trie.NewStackTrie(nil))
+	buildLog.BlockHash = c.specHash(block, fork)
+	c.buildLogs.Add(buildLog.BlockHash, buildLog)
 
 	// Write state changes to db
 	root, err := statedb.Commit(config.IsEIP158(header.Number))
 	if err != nil {
-		return nil, fmt.Errorf("state write error: %v", err)
+		return nil, nil, nil, fmt.Errorf("state write error: %v", err)
 	}
 	if err := statedb.Database().TrieDB().Commit(root, false, nil); err != nil {
-		return nil, fmt.Errorf("trie write error: %v", err)
+		return nil, nil, nil, fmt.Errorf("trie write error: %v", err)
 	}
 
 	if storeBlock {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.writeForkFields(block, fork); err != nil {
+			return nil, nil, nil, err
+		}
 		_, err = c.chain.InsertChain(types.Blocks{block})
 		if err != nil {
-			return nil, fmt.Errorf("failed to insert block into chain")
+			return nil, nil, nil, fmt.Errorf("failed to insert block into chain")
 		}
 	}
 
-	return block, nil
+	return block, receipts, fork, nil
 }
 
 // Custom block builder, to change more things, fake time more easily, deal with difficulty etc.
 func (c *MockChain) MineBlock(parent *types.Header) (*types.Block, error) {
+	return c.mineBlock(parent, nil)
+}
+
+// mineBlock mines a block with the difficulty of the consensus engine, or the given one if not nil.
+func (c *MockChain) mineBlock(parent *types.Header, difficulty *big.Int) (*types.Block, error) {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     new(big.Int).Add(parent.Number, common.Big1),
@@ -345,6 +514,9 @@ func (c *MockChain) MineBlock(parent *types.Header) (*types.Block, error) {
 	if err := c.engine.Prepare(c.chain, header); err != nil {
 		return nil, fmt.Errorf("failed to prepare header for mining: %v", err)
 	}
+	if difficulty != nil {
+		header.Difficulty = difficulty
+	}
 
 	// Finalize block
 	statedb, err := state.New(parent.Root, state.NewDatabase(c.database), nil)
@@ -356,8 +528,8 @@ func (c *MockChain) MineBlock(parent *types.Header) (*types.Block, error) {
 		return nil, fmt.Errorf("failed to finalize and assemble block: %v", err)
 	}
 
-	// Seal block
-	results := make(chan *types.Block)
+	// Seal block, buffered for engines that seal synchronously
+	results := make(chan *types.Block, 1)
 	if err := c.engine.Seal(c.chain, block, results, nil); err != nil {
 		panic(fmt.Sprintf("failed to seal block: %v", err))
 	}
@@ -378,19 +550,60 @@ func (c *MockChain) MineBlock(parent *types.Header) (*types.Block, error) {
 	}
 
 	// Insert block into chain
+	c.mu.Lock()
 	_, err = c.chain.InsertChain(types.Blocks{block})
+	c.mu.Unlock()
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert block into chain")
 	}
+	c.recordBlock(JournalMine, "terminal-chain", block.Hash(), block)
 
 	return block, nil
 }
 
 func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.Block, error) {
-	parent := c.chain.GetHeaderByHash(payload.ParentHash)
+	return c.processPayload(payload, nil, "engine_newPayload")
+}
+
+// ProcessPayloadV2 executes a payload that may have withdrawals. The returned block is the geth block,
+// its hash differs from the payload block hash if there are withdrawals.
+func (c *MockChain) ProcessPayloadV2(payload *mmTypes.ExecutionPayloadV2) (*types.Block, error) {
+	return c.processPayload(payload.PayloadV1(), payload.ForkFields(), "engine_newPayload")
+}
+
+// ProcessPayloadV3 executes a Cancun payload, with the parent beacon block root of its block.
+func (c *MockChain) ProcessPayloadV3(payload *mmTypes.ExecutionPayloadV3, parentBeaconRoot common.Hash) (*types.Block, error) {
+	return c.processPayload(payload.PayloadV2().PayloadV1(), payload.ForkFields(parentBeaconRoot), "engine_newPayload")
+}
+
+// ProcessPayloadV4 executes a Prague payload, whose block commits to the execution requests. They are taken
+// as given: without the system contracts producing them, they aren't compared to those of the execution.
+func (c *MockChain) ProcessPayloadV4(payload *mmTypes.ExecutionPayloadV3, parentBeaconRoot common.Hash, requests mmTypes.ExecutionRequests) (*types.Block, error) {
+	return c.processPayload(payload.PayloadV2().PayloadV1(), payload.ForkFieldsV4(parentBeaconRoot, requests), "engine_newPayload")
+}
+
+func (c *MockChain) processPayload(payload *mmTypes.ExecutionPayloadV1, fork *mmTypes.ForkFields, trigger string) (*types.Block, error) {
+	parent := c.chain.GetHeaderByHash(c.ResolveHash(payload.ParentHash))
 	if parent == nil {
 		return nil, fmt.Errorf("unknown parent %s", payload.ParentHash)
 	}
+	var withdrawals []*mmTypes.Withdrawal
+	var parentBeaconRoot, requestsHash *common.Hash
+	if fork != nil {
+		withdrawals, parentBeaconRoot, requestsHash = fork.Withdrawals, fork.ParentBeaconRoot, fork.RequestsHash
+	}
+	expected, err := c.newForkFields(parent, payload.Timestamp, withdrawals, parentBeaconRoot, requestsHash)
+	if err != nil {
+		return nil, err
+	}
+	if expected != nil && expected.ExcessBlobGas != nil {
+		if *fork.ExcessBlobGas != *expected.ExcessBlobGas {
+			return nil, fmt.Errorf("excess blob gas difference: %d <> %d", *fork.ExcessBlobGas, *expected.ExcessBlobGas)
+		}
+		if *fork.BlobGasUsed != *expected.BlobGasUsed {
+			return nil, fmt.Errorf("blob gas used difference: %d <> %d", *fork.BlobGasUsed, *expected.BlobGasUsed)
+		}
+	}
 	config := c.gspec.Config
 	statedb, err := state.New(parent.Root, state.NewDatabase(c.database), nil)
 	if err != nil {
@@ -436,6 +649,12 @@ func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.
 	if c.traceOpts.EnableTrace {
 		vmconf.Tracer = stl
 	}
+	if fork != nil && fork.ParentBeaconRoot != nil {
+		applyBeaconRoot(statedb, header.Time, *fork.ParentBeaconRoot)
+	}
+	if c.IsPrague(header.Time) {
+		applyBlockHashHistory(statedb, header.Number.Uint64(), payload.ParentHash)
+	}
 	txs := make([]*types.Transaction, 0, len(payload.Transactions))
 	for i, otx := range payload.Transactions {
 		var tx types.Transaction
@@ -443,6 +662,7 @@ func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.
 			return nil, fmt.Errorf("failed to decode tx %d: %v", i, err)
 		}
 		txs = append(txs, &tx)
+		statedb.Prepare(tx.Hash(), i)
 		receipt, err := core.ApplyTransaction(config, c.chain, &header.Coinbase, gasPool, statedb, header, &tx, &header.GasUsed, vmconf)
 		if err != nil {
 			return nil, fmt.Errorf("failed to apply transaction %d: %v", i, err)
@@ -457,6 +677,8 @@ func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.
 		c.log.Info("trace:\n" + buf.String())
 	}
 
+	applyWithdrawals(statedb, withdrawals)
+
 	// verify state root is correct, and build the block
 	stateRoot := statedb.IntermediateRoot(config.IsEIP158(header.Number))
 	header.Root = stateRoot
@@ -493,7 +715,7 @@ func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.
 	if block.Root() != common.Hash(payload.StateRoot) {
 		return nil, fmt.Errorf("state root difference: %s <> %s", stateRoot, payload.StateRoot)
 	}
-	if hash := block.Hash(); hash != payload.BlockHash {
+	if hash := c.specHash(block, fork); hash != payload.BlockHash {
 		return nil, fmt.Errorf("block hash difference: %s <> %s", hash, payload.BlockHash)
 	}
 	// Write state changes to db
@@ -504,13 +726,27 @@ func (c *MockChain) ProcessPayload(payload *mmTypes.ExecutionPayloadV1) (*types.
 	if err := statedb.Database().TrieDB().Commit(root, false, nil); err != nil {
 		return nil, fmt.Errorf("trie write error: %v", err)
 	}
-	_, err = c.chain.InsertChain(types.Blocks{block})
-	if err != nil {
-		return nil, fmt.Errorf("failed to insert block into chain")
+	if err := c.insertSideBlock(block, fork); err != nil {
+		return nil, err
 	}
+	c.recordBlock(JournalImport, trigger, payload.BlockHash, block)
 	return block, nil
 }
 
+// insertSideBlock stores the executed block with its fork fields. The block only becomes the head once a
+// forkchoiceUpdated selects it.
+func (c *MockChain) insertSideBlock(block *types.Block, fork *mmTypes.ForkFields) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeForkFields(block, fork); err != nil {
+		return err
+	}
+	if err := c.chain.InsertBlockWithoutSetHead(block); err != nil {
+		return fmt.Errorf("failed to insert block into chain")
+	}
+	return nil
+}
+
 func (c *MockChain) Close() error {
 	err := c.engine.Close()
 	if err != nil {
@@ -520,15 +756,26 @@ func (c *MockChain) Close() error {
 	return nil
 }
 
-func LoadGenesisConfig(path string) (*core.Genesis, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read genesis file: %v", err)
-	}
-	defer file.Close()
+// defaultGenesis is a post-merge genesis with all pre-merge forks active from block 0,
+// used when there is no genesis file so the mock works without any files on disk.
+//
+//go:embed default_genesis.json
+var defaultGenesis []byte
+
+// ReadGenesis returns the contents of the genesis file, or the embedded default genesis if the file doesn't exist.
+func ReadGenesis(path string) (buf []byte, isDefault bool, err error) {
+	buf, err = os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultGenesis, true, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read genesis file: %v", err)
+	}
+	return buf, false, nil
+}
 
+func LoadGenesisConfig(buf []byte) (*core.Genesis, error) {
 	var genesis core.Genesis
-	if err := json.NewDecoder(file).Decode(&genesis); err != nil {
+	if err := json.Unmarshal(buf, &genesis); err != nil {
 		return nil, fmt.Errorf("invalid genesis file: %v", err)
 	}
 	return &genesis, nil
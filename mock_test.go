@@ -0,0 +1,286 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"mergemock/api"
+	mmTypes "mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/quick"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+// Init code that emits a single LOG1 with topic 0xff and deploys an empty contract.
+var logEmitterCode = common.FromHex("0x60ff60006000a100")
+
+func newFundedGenesis(t *testing.T, funded common.Address) string {
+	path := fmt.Sprintf("%s/genesis.json", t.TempDir())
+	genesis := core.DeveloperGenesisBlock(5, 30_000_000, funded)
+	genesis.Config.MergeForkBlock = common.Big0
+	genesis.Config.TerminalTotalDifficulty = common.Big0
+	buf, err := genesis.MarshalJSON()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+	return path
+}
+
+func newTestMockChain(t *testing.T, genesisPath string) *MockChain {
+	log := logrus.New()
+	mc, err := NewMockChain(log, &ExecutionConsensusMock{log: log}, genesisPath, rawdb.NewMemoryDatabase(), &TraceLogConfig{}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { mc.Close() })
+	return mc
+}
+
+func TestPayloadReceiptsWithLogs(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	genesisPath := newFundedGenesis(t, sender)
+
+	builder := newTestMockChain(t, genesisPath)
+	creator := TransactionsCreator{nil, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
+		signer := ethTypes.LatestSigner(config)
+		var txs []*ethTypes.Transaction
+		for nonce := uint64(0); nonce < 2; nonce++ {
+			tx := ethTypes.MustSignNewTx(key, signer, &ethTypes.DynamicFeeTx{
+				ChainID:   config.ChainID,
+				Nonce:     nonce,
+				GasTipCap: big.NewInt(params.GWei),
+				GasFeeCap: new(big.Int).Add(header.BaseFee, big.NewInt(params.GWei)),
+				Gas:       100_000,
+				Data:      logEmitterCode,
+			})
+			txs = append(txs, tx)
+		}
+		return txs
+	}}
+	parent := builder.CurrentHeader()
+	block, err := builder.AddNewBlock(parent.Hash(), common.Address{0x02}, parent.Time+1, parent.GasLimit, creator, common.Hash{0x01}, nil, nil, nil, false)
+	require.NoError(t, err)
+	require.Len(t, block.Transactions(), 2)
+	require.NotEqual(t, ethTypes.EmptyRootHash, block.ReceiptHash())
+	require.True(t, ethTypes.BloomLookup(block.Bloom(), common.BigToHash(big.NewInt(0xff))))
+
+	// A fresh chain must arrive at the same receipts root and bloom from the payload alone.
+	payload, err := api.BlockToPayload(block)
+	require.NoError(t, err)
+	verifier := newTestMockChain(t, genesisPath)
+	processed, err := verifier.ProcessPayload(payload)
+	require.NoError(t, err)
+	require.Equal(t, block.Hash(), processed.Hash())
+
+	// Receipts must carry the logs, in block order, with the tx they belong to.
+	receipts := verifier.chain.GetReceiptsByHash(processed.Hash())
+	require.Len(t, receipts, 2)
+	for i, receipt := range receipts {
+		require.Len(t, receipt.Logs, 1)
+		require.Equal(t, block.Transactions()[i].Hash(), receipt.Logs[0].TxHash)
+		require.Equal(t, uint(i), receipt.Logs[0].Index)
+	}
+	require.Equal(t, block.ReceiptHash(), ethTypes.DeriveSha(receipts, trie.NewStackTrie(nil)))
+}
+
+func TestBuiltBlocksRoundTripToValidPayloads(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	genesisPath := newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey))
+	mc := newTestMockChain(t, genesisPath)
+	parent := mc.CurrentHeader()
+
+	// Every field that feeds into the block hash, each mutated in place.
+	mutations := []func(p *mmTypes.ExecutionPayloadV1){
+		func(p *mmTypes.ExecutionPayloadV1) { p.ParentHash[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.FeeRecipient[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.StateRoot[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.ReceiptsRoot[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.LogsBloom[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.Random[0] ^= 1 },
+		func(p *mmTypes.ExecutionPayloadV1) { p.Number++ },
+		func(p *mmTypes.ExecutionPayloadV1) { p.GasLimit++ },
+		func(p *mmTypes.ExecutionPayloadV1) { p.GasUsed++ },
+		func(p *mmTypes.ExecutionPayloadV1) { p.Timestamp++ },
+		func(p *mmTypes.ExecutionPayloadV1) { p.ExtraData = append(p.ExtraData, 0x01) },
+		func(p *mmTypes.ExecutionPayloadV1) { p.BaseFeePerGas = new(big.Int).Add(p.BaseFeePerGas, common.Big1) },
+		func(p *mmTypes.ExecutionPayloadV1) { p.Transactions = p.Transactions[:len(p.Transactions)-1] },
+	}
+
+	property := func(coinbase common.Address, prevRandao common.Hash, extra []byte, timeDelta uint16, txCount uint8, mutation uint8) bool {
+		if len(extra) > 32 {
+			extra = extra[:32]
+		}
+		creator := TransactionsCreator{nil, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
+			signer := ethTypes.LatestSigner(config)
+			txs := make([]*ethTypes.Transaction, 0, txCount%5)
+			for nonce := uint64(0); nonce < uint64(txCount%5); nonce++ {
+				to := common.Address{byte(nonce), 0x42}
+				txs = append(txs, ethTypes.MustSignNewTx(key, signer, &ethTypes.DynamicFeeTx{
+					ChainID:   config.ChainID,
+					Nonce:     nonce,
+					GasTipCap: big.NewInt(params.GWei),
+					GasFeeCap: new(big.Int).Add(header.BaseFee, big.NewInt(params.GWei)),
+					Gas:       params.TxGas,
+					To:        &to,
+					Value:     big.NewInt(1),
+				}))
+			}
+			return txs
+		}}
+		block, err := mc.AddNewBlock(parent.Hash(), coinbase, parent.Time+1+uint64(timeDelta), parent.GasLimit, creator, prevRandao, extra, nil, nil, false)
+		if err != nil {
+			t.Logf("failed to build block: %v", err)
+			return false
+		}
+		payload, err := api.BlockToPayload(block)
+		if err != nil {
+			t.Logf("failed to convert block: %v", err)
+			return false
+		}
+		if hash, err := payload.ComputeHash(); err != nil || hash != block.Hash() || !payload.ValidateHash() {
+			t.Logf("payload hash %s does not match block %s (err: %v)", hash, block.Hash(), err)
+			return false
+		}
+
+		mutate := mutations[int(mutation)%len(mutations)]
+		if len(payload.Transactions) == 0 {
+			mutate = mutations[0]
+		}
+		mutate(payload)
+		return !payload.ValidateHash()
+	}
+	require.NoError(t, quick.Check(property, &quick.Config{MaxCount: 50}))
+}
+
+func TestMissingGenesisUsesDefault(t *testing.T) {
+	mc := newTestMockChain(t, fmt.Sprintf("%s/genesis.json", t.TempDir()))
+	cfg := mc.chain.Config()
+	require.Equal(t, big.NewInt(1337), cfg.ChainID)
+	require.Zero(t, cfg.TerminalTotalDifficulty.Sign())
+	require.False(t, mc.IsShanghai(0))
+}
+
+func TestResolveDataDir(t *testing.T) {
+	explicit := filepath.Join(t.TempDir(), "chain")
+	for _, tc := range []struct {
+		name, dataDir string
+	}{
+		{"auto", AutoDataDir},
+		{"explicit", explicit},
+		{"in-memory", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, remove, err := resolveDataDir(tc.dataDir)
+			require.NoError(t, err)
+			if tc.dataDir != AutoDataDir {
+				require.Equal(t, tc.dataDir, dir)
+			} else {
+				require.True(t, filepath.IsAbs(dir))
+				require.Equal(t, os.TempDir(), filepath.Dir(dir))
+			}
+			db, err := NewDB(dir)
+			require.NoError(t, err)
+			require.NoError(t, db.Close())
+
+			// the database is closed before its directory is removed, which Windows requires
+			require.NoError(t, remove())
+			if tc.dataDir == "" {
+				return
+			}
+			_, err = os.Stat(dir)
+			if tc.dataDir == AutoDataDir {
+				require.ErrorIs(t, err, os.ErrNotExist, "temporary datadirs are removed")
+			} else {
+				require.NoError(t, err, "explicit datadirs are kept")
+			}
+		})
+	}
+}
+
+func TestBuildLogRecordsExclusionReasons(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	mc := newTestMockChain(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)))
+
+	creator := TransactionsCreator{nil, func(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *ethTypes.Header, cfg vm.Config, accounts []TestAccount) []*ethTypes.Transaction {
+		signer := ethTypes.LatestSigner(config)
+		tx := func(nonce, gas uint64, feeCap *big.Int) *ethTypes.Transaction {
+			return ethTypes.MustSignNewTx(key, signer, &ethTypes.DynamicFeeTx{
+				ChainID:   config.ChainID,
+				Nonce:     nonce,
+				GasTipCap: common.Big1,
+				GasFeeCap: feeCap,
+				Gas:       gas,
+				To:        &common.Address{0x01},
+			})
+		}
+		feeCap := new(big.Int).Add(header.BaseFee, common.Big1)
+		return []*ethTypes.Transaction{
+			tx(0, params.TxGas, feeCap),
+			tx(5, params.TxGas, feeCap),
+			tx(1, header.GasLimit+1, feeCap),
+			tx(1, params.TxGas, common.Big1),
+			tx(1, params.TxGas, feeCap),
+		}
+	}}
+	parent := mc.CurrentHeader()
+	block, err := mc.AddNewBlock(parent.Hash(), common.Address{0x02}, parent.Time+1, parent.GasLimit, creator, common.Hash{}, nil, nil, nil, false)
+	require.NoError(t, err)
+	require.Len(t, block.Transactions(), 2)
+
+	buildLog := mc.BuildLog(block.Hash())
+	require.NotNil(t, buildLog)
+	require.Len(t, buildLog.Transactions, 5)
+	require.True(t, buildLog.Transactions[0].Included)
+	require.Equal(t, params.TxGas, buildLog.Transactions[0].GasUsed)
+	require.True(t, buildLog.Transactions[4].Included)
+
+	var reasons []string
+	for _, tx := range buildLog.Excluded() {
+		reasons = append(reasons, tx.Reason)
+	}
+	require.Equal(t, []string{"nonce", "gas", "fee"}, reasons)
+}
+
+func TestMineTerminalChainAnnouncesTTD(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	mc, err := NewMockChain(log, &ExecutionConsensusMock{log: log}, newPowGenesis(t, 10), rawdb.NewMemoryDatabase(), &TraceLogConfig{}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { mc.Close() })
+	require.False(t, mc.TTDReached())
+
+	announced := func() []*logrus.Entry {
+		var entries []*logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["event"] == TTDReachedEvent {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	terminal, err := mc.MineTerminalChain(big.NewInt(3))
+	require.NoError(t, err)
+	require.True(t, mc.TTDReached())
+	entries := announced()
+	require.Len(t, entries, 1)
+	require.Equal(t, terminal.Hash(), entries[0].Data["hash"])
+	require.Equal(t, big.NewInt(10), entries[0].Data["td"])
+
+	// Starting on a chain past the transition announces nothing.
+	_, err = mc.MineTerminalChain(big.NewInt(3))
+	require.NoError(t, err)
+	require.Len(t, announced(), 1)
+}
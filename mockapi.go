@@ -0,0 +1,136 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/rpc"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+)
+
+// MockBackend serves the mock namespace, which exposes mergemock itself rather than the chain it simulates.
+type MockBackend struct {
+	engine *EngineBackend
+}
+
+func NewMockBackend(engine *EngineBackend) *MockBackend {
+	return &MockBackend{engine: engine}
+}
+
+func (b *MockBackend) Register(srv *rpc.Server) error {
+	srv.RegisterName("mock", b)
+	return node.RegisterApis([]rpc.API{
+		{
+			Namespace:     "mock",
+			Version:       "1.0",
+			Service:       b,
+			Public:        true,
+			Authenticated: false,
+		},
+	}, []string{"mock"}, srv, false)
+}
+
+func (b *MockBackend) Version(ctx context.Context) VersionInfo {
+	return Version()
+}
+
+// Stats is a snapshot of the state of the engine, for operators of running instances.
+type Stats struct {
+	Head   common.Hash `json:"head"`
+	Number uint64      `json:"number"`
+	// PayloadIDs is the number of payload builds started by forkchoiceUpdated.
+	PayloadIDs    uint64            `json:"payloadIds"`
+	GasLimit      uint64            `json:"gasLimit"`
+	Faults        int               `json:"faults"`
+	LatencyAlerts map[string]uint64 `json:"latencyAlerts"`
+	// TTDReached is whether the head reached the terminal total difficulty.
+	TTDReached bool `json:"ttdReached"`
+	// PendingTxs is the number of transactions in the mempool.
+	PendingTxs int `json:"pendingTxs"`
+	// PayloadMismatches is the number of submitted payloads differing from the served payloads.
+	PayloadMismatches int               `json:"payloadMismatches"`
+	PayloadCache      PayloadCacheStats `json:"payloadCache"`
+	Builds            BuildStats        `json:"builds"`
+	// ActiveBuilds is the number of payloads being built, SupersededBuilds the number of builds stopped as a
+	// forkchoiceUpdated chose another head.
+	ActiveBuilds     int    `json:"activeBuilds"`
+	SupersededBuilds uint64 `json:"supersededBuilds"`
+	// HeadConflicts is the number of forkchoice heads conflicting with the head of another consensus client,
+	// RejectedHeads the number of those rejected by the arbitration.
+	HeadConflicts uint64 `json:"headConflicts"`
+	RejectedHeads uint64 `json:"rejectedHeads"`
+	// Calls are the engine API calls by method.
+	Calls map[string]MethodStats `json:"calls"`
+	// Subscriptions are the queues of the eth_subscribe subscriptions.
+	Subscriptions []SubscriptionStats `json:"subscriptions"`
+}
+
+// PayloadCacheStats counts the payloads dropped from the payload cache, by eviction when it is full and by
+// expiry after the retention, and the evicted payloads rebuilt for getPayload.
+type PayloadCacheStats struct {
+	Evicted uint64 `json:"evicted"`
+	Expired uint64 `json:"expired"`
+	Rebuilt uint64 `json:"rebuilt"`
+}
+
+func (b *MockBackend) Stats(ctx context.Context) *Stats {
+	head := b.engine.mockChain.CurrentHeader()
+	conflicts, rejected := b.engine.arbiter.Conflicts()
+	activeBuilds, supersededBuilds := b.engine.builds.Stats()
+	return &Stats{
+		Head:              b.engine.mockChain.SpecHash(head.Hash()),
+		Number:            head.Number.Uint64(),
+		PayloadIDs:        atomic.LoadUint64(&b.engine.payloadIdCounter),
+		GasLimit:          b.engine.gasLimits.For(head.Number.Uint64()+1, head.GasLimit),
+		Faults:            len(b.engine.faults.Rules()),
+		LatencyAlerts:     b.engine.latency.Alerts(),
+		TTDReached:        b.engine.mockChain.TTDReached(),
+		PendingTxs:        b.engine.mockChain.pool.Len(),
+		PayloadMismatches: len(b.engine.checks.Mismatches()),
+		PayloadCache: PayloadCacheStats{
+			Evicted: atomic.LoadUint64(&b.engine.cacheStats.Evicted),
+			Expired: atomic.LoadUint64(&b.engine.cacheStats.Expired),
+			Rebuilt: atomic.LoadUint64(&b.engine.cacheStats.Rebuilt),
+		},
+		Builds:           b.engine.buildMetrics.Stats(),
+		ActiveBuilds:     activeBuilds,
+		SupersededBuilds: supersededBuilds,
+		HeadConflicts:    conflicts,
+		RejectedHeads:    rejected,
+		Calls:            b.engine.calls.Methods(),
+		Subscriptions:    b.engine.subs.Stats(),
+	}
+}
+
+// StatsSnapshot is the final state of the counters of an engine, written on exit for CI jobs to archive and compare
+// between runs.
+type StatsSnapshot struct {
+	Time    time.Time   `json:"time"`
+	Version VersionInfo `json:"version"`
+	Stats   *Stats      `json:"stats"`
+	Faults  []FaultRule `json:"faults"`
+}
+
+func (b *MockBackend) Snapshot(ctx context.Context) *StatsSnapshot {
+	return &StatsSnapshot{
+		Time:    time.Now().UTC(),
+		Version: Version(),
+		Stats:   b.Stats(ctx),
+		Faults:  b.Faults(ctx),
+	}
+}
+
+func WriteStatsSnapshot(path string, snapshot *StatsSnapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshot)
+}
@@ -0,0 +1,74 @@
+package mergemock
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+type NonFinalityConfig struct {
+	Stall         bool   `ask:"--stall" help:"Never advance the finalized block"`
+	FinalizeEvery uint64 `ask:"--finalize-every" help:"Advance the finalized block only every this many epochs (0 for every epoch)"`
+	Oscillate     uint64 `ask:"--oscillate" help:"Switch the head and safe block to a competing branch every this many slots, forking one off the branch of the head if there is none (0 to stay on one branch)"`
+	BranchDepth   uint64 `ask:"--branch-depth" help:"Blocks below the head down to which competing branches are kept to switch to, older ones are abandoned (0 for down to the finalized block)"`
+}
+
+// Finalizes reports whether the finalized block advances at the start of the epoch.
+func (c *NonFinalityConfig) Finalizes(epoch uint64) bool {
+	return !c.Stall && (c.FinalizeEvery == 0 || epoch%c.FinalizeEvery == 0)
+}
+
+// oscillate returns the block to build on in the slot instead of the parent when oscillating, with whether it's on
+// another branch than the parent. Every configured number of slots it switches to the highest tip of a competing
+// branch, descending from the finalized block and forking within the branch depth, or else to the ancestor of the
+// parent to fork a new branch from. A parent that doesn't descend from the finalized block, e.g. after a branch got
+// finalized, is left for a branch that does, or the finalized block itself.
+func (c *ConsensusCmd) oscillate(slot uint64, parent *ethTypes.Header, finalized common.Hash) (*ethTypes.Header, bool) {
+	cfg := c.NonFinality
+	if cfg.Oscillate == 0 {
+		return parent, false
+	}
+	descends := func(hash common.Hash) bool {
+		return finalized == (common.Hash{}) || c.mockChain.IsAncestor(finalized, hash)
+	}
+	if slot%cfg.Oscillate != 0 && descends(parent.Hash()) {
+		return parent, false
+	}
+	var floor uint64
+	if final := c.mockChain.chain.GetHeaderByHash(finalized); final != nil {
+		floor = final.Number.Uint64()
+	}
+	// Branches not descending from the finalized block are skipped, however deep they fork.
+	depth := cfg.BranchDepth
+	if depth == 0 {
+		depth = c.mockChain.CurrentHeader().Number.Uint64()
+	}
+	var tip *ethTypes.Header
+	for _, b := range c.mockChain.Branches(depth) {
+		if b.Tip == parent.Hash() || !descends(b.Tip) ||
+			c.mockChain.IsAncestor(b.Tip, parent.Hash()) || c.mockChain.IsAncestor(parent.Hash(), b.Tip) {
+			continue
+		}
+		if header := c.mockChain.chain.GetHeaderByHash(c.mockChain.ResolveHash(b.Tip)); header != nil && (tip == nil || header.Number.Cmp(tip.Number) > 0) {
+			tip = header
+		}
+	}
+	if tip != nil {
+		return tip, true
+	}
+	if !descends(parent.Hash()) {
+		if final := c.mockChain.chain.GetHeaderByHash(finalized); final != nil {
+			return final, true
+		}
+		return parent, false
+	}
+	// No competing branch yet: fork one off the branch of the parent, as far down as the blocks of a period.
+	back := cfg.Oscillate
+	if back > parent.Number.Uint64()-floor {
+		back = parent.Number.Uint64() - floor
+	}
+	ancestor, err := c.mockChain.Ancestor(parent.Hash(), back)
+	if err != nil || back == 0 {
+		return parent, false
+	}
+	return c.mockChain.chain.GetHeaderByHash(c.mockChain.ResolveHash(ancestor)), true
+}
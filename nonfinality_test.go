@@ -0,0 +1,86 @@
+package mergemock
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranches(t *testing.T) {
+	mc := newTestMockChain(t, newGenesis(t))
+	genesis := mc.CurrentHeader()
+	tipA, err := mc.BuildFork(genesis.Hash(), 5, "test")
+	require.NoError(t, err)
+	forkPoint, err := mc.Ancestor(tipA, 3)
+	require.NoError(t, err)
+	tipB, err := mc.BuildFork(forkPoint, 2, "test")
+	require.NoError(t, err)
+
+	head := mc.CurrentHeader()
+	branches := mc.Branches(10)
+	require.Len(t, branches, 2)
+	require.True(t, branches[0].Canonical)
+	require.Equal(t, head.Hash(), branches[0].Tip)
+	require.Equal(t, head.Hash(), branches[0].ForkPoint)
+	require.Zero(t, branches[0].Depth)
+	require.False(t, branches[1].Canonical)
+	require.ElementsMatch(t, []common.Hash{tipA, tipB}, []common.Hash{branches[0].Tip, branches[1].Tip})
+	require.Equal(t, forkPoint, branches[1].ForkPoint)
+	require.Equal(t, head.Number.Uint64()-2, branches[1].Depth)
+
+	require.Len(t, mc.Branches(1), 1, "branch forking deeper than the depth left out")
+}
+
+func TestNonFinalityFinalizes(t *testing.T) {
+	var cfg NonFinalityConfig
+	require.True(t, cfg.Finalizes(1), "finalizes every epoch by default")
+	cfg.FinalizeEvery = 3
+	require.True(t, cfg.Finalizes(3))
+	require.False(t, cfg.Finalizes(4))
+	cfg.Stall = true
+	require.False(t, cfg.Finalizes(3))
+}
+
+func TestNonFinalityOscillate(t *testing.T) {
+	mc := newTestMockChain(t, newGenesis(t))
+	genesis := mc.CurrentHeader()
+	tipA, err := mc.BuildFork(genesis.Hash(), 5, "test")
+	require.NoError(t, err)
+	c := &ConsensusCmd{mockChain: mc}
+	headerA := mc.chain.GetHeaderByHash(tipA)
+
+	parent, switched := c.oscillate(2, headerA, common.Hash{})
+	require.False(t, switched, "not oscillating")
+	require.Equal(t, tipA, parent.Hash())
+
+	c.NonFinality.Oscillate = 2
+	parent, switched = c.oscillate(1, headerA, common.Hash{})
+	require.False(t, switched, "stays on the branch between switches")
+	require.Equal(t, tipA, parent.Hash())
+
+	parent, switched = c.oscillate(2, headerA, common.Hash{})
+	require.True(t, switched)
+	forkPoint, err := mc.Ancestor(tipA, 2)
+	require.NoError(t, err)
+	require.Equal(t, forkPoint, parent.Hash(), "forks a branch without a competing one")
+	tipB, err := mc.BuildFork(forkPoint, 1, "test")
+	require.NoError(t, err)
+	headerB := mc.chain.GetHeaderByHash(tipB)
+
+	parent, switched = c.oscillate(4, headerB, common.Hash{})
+	require.True(t, switched)
+	require.Equal(t, tipA, parent.Hash(), "switches to the competing branch")
+	parent, switched = c.oscillate(4, headerA, common.Hash{})
+	require.True(t, switched)
+	require.Equal(t, tipB, parent.Hash(), "and back")
+
+	finalized, err := mc.Ancestor(tipA, 1)
+	require.NoError(t, err)
+	parent, switched = c.oscillate(5, headerB, finalized)
+	require.True(t, switched)
+	require.Equal(t, tipA, parent.Hash(), "leaves the branch not descending from the finalized block")
+	parent, switched = c.oscillate(6, headerA, finalized)
+	require.True(t, switched)
+	require.Equal(t, finalized, parent.Hash(), "forks off the finalized block without a competing branch descending from it")
+}
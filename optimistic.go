@@ -0,0 +1,192 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"mergemock/types"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+type OptimisticConfig struct {
+	Enable             bool          `ask:"--enable" help:"Answer newPayload with ACCEPTED, and only settle whether the blocks are VALID or INVALID later"`
+	Delay              time.Duration `ask:"--delay" help:"Time after which accepted blocks are settled (0 to wait for mock_validateBlock or mock_invalidateBlock)"`
+	InvalidProbability float64       `ask:"--invalid-probability" help:"Probability of a block settled after the delay being INVALID"`
+	Seed               int64         `ask:"--seed" help:"Seed of the INVALID verdicts (0 for a random seed)"`
+}
+
+// NewVerdicts returns the pending verdicts of optimistic imports, nil if blocks are validated right away.
+func (c *OptimisticConfig) NewVerdicts(log logrus.Ext1FieldLogger) (*Verdicts, error) {
+	if !c.Enable {
+		return nil, nil
+	}
+	if c.InvalidProbability < 0 || c.InvalidProbability > 1 {
+		return nil, fmt.Errorf("invalid probability %v out of range [0, 1]", c.InvalidProbability)
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Verdicts{
+		cfg:     *c,
+		log:     log,
+		rng:     rand.New(rand.NewSource(seed)),
+		pending: make(map[common.Hash]*pendingVerdict),
+		invalid: make(map[common.Hash]common.Hash),
+	}, nil
+}
+
+type pendingVerdict struct {
+	parent   common.Hash
+	accepted time.Time
+}
+
+// SettledBlock is a block whose verdict was settled.
+type SettledBlock struct {
+	BlockHash       common.Hash                `json:"blockHash"`
+	Status          types.ExecutePayloadStatus `json:"status"`
+	LatestValidHash *common.Hash               `json:"latestValidHash,omitempty"`
+}
+
+// Verdicts tracks the imported blocks whose validity is yet to be settled, like an engine that imports blocks
+// optimistically. Validating a block validates its ancestors, invalidating it invalidates its descendants,
+// all with the parent of the block as latest valid hash.
+type Verdicts struct {
+	cfg OptimisticConfig
+	log logrus.Ext1FieldLogger
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	pending map[common.Hash]*pendingVerdict // by block hash
+	invalid map[common.Hash]common.Hash     // latest valid hash, by invalidated block hash
+}
+
+// Accept records the verdict of the imported block as pending, and returns its status: ACCEPTED, or INVALID
+// once the block or its parent was invalidated. It's nil if blocks are validated right away.
+func (v *Verdicts) Accept(blockHash, parentHash common.Hash) *types.PayloadStatusV1 {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.settleDue()
+	if lvh, ok := v.invalid[parentHash]; ok {
+		v.invalid[blockHash] = lvh
+	}
+	if lvh, ok := v.invalid[blockHash]; ok {
+		return &types.PayloadStatusV1{Status: types.ExecutionInvalid, LatestValidHash: &lvh, ValidationError: "invalidated block"}
+	}
+	if _, ok := v.pending[blockHash]; !ok {
+		v.pending[blockHash] = &pendingVerdict{parent: parentHash, accepted: time.Now()}
+	}
+	return &types.PayloadStatusV1{Status: types.ExecutionAccepted}
+}
+
+// Status returns the status of a forkchoiceUpdated call to the head: SYNCING while its verdict is pending, or
+// INVALID once invalidated, nil if the head is valid.
+func (v *Verdicts) Status(head common.Hash) *types.PayloadStatusV1 {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.settleDue()
+	if lvh, ok := v.invalid[head]; ok {
+		return &types.PayloadStatusV1{Status: types.ExecutionInvalid, LatestValidHash: &lvh, ValidationError: "invalidated block"}
+	}
+	if _, ok := v.pending[head]; ok {
+		return &types.PayloadStatusV1{Status: types.ExecutionSyncing}
+	}
+	return nil
+}
+
+// Settle settles the pending verdict of the block, and returns the blocks it settled.
+func (v *Verdicts) Settle(blockHash common.Hash, valid bool) ([]SettledBlock, error) {
+	if v == nil {
+		return nil, fmt.Errorf("optimistic imports are disabled")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.settleDue()
+	if _, ok := v.pending[blockHash]; !ok {
+		return nil, fmt.Errorf("block %s has no pending verdict", blockHash)
+	}
+	return v.settle(blockHash, valid), nil
+}
+
+// settleDue settles the blocks accepted longer than the delay ago, in the order they were accepted.
+func (v *Verdicts) settleDue() {
+	if v.cfg.Delay == 0 {
+		return
+	}
+	due := make([]common.Hash, 0)
+	for hash, pending := range v.pending {
+		if time.Since(pending.accepted) >= v.cfg.Delay {
+			due = append(due, hash)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return v.pending[due[i]].accepted.Before(v.pending[due[j]].accepted)
+	})
+	for _, hash := range due {
+		if _, ok := v.pending[hash]; ok {
+			v.settle(hash, v.rng.Float64() >= v.cfg.InvalidProbability)
+		}
+	}
+}
+
+func (v *Verdicts) settle(blockHash common.Hash, valid bool) []SettledBlock {
+	var settled []SettledBlock
+	// The ancestors were validated before the block either way.
+	hash := blockHash
+	if !valid {
+		hash = v.pending[blockHash].parent
+	}
+	for pending, ok := v.pending[hash]; ok; pending, ok = v.pending[hash] {
+		delete(v.pending, hash)
+		settled = append(settled, SettledBlock{BlockHash: hash, Status: types.ExecutionValid})
+		hash = pending.parent
+	}
+	if !valid {
+		lvh := v.pending[blockHash].parent
+		for _, hash := range append([]common.Hash{blockHash}, v.descendants(blockHash)...) {
+			delete(v.pending, hash)
+			v.invalid[hash] = lvh
+			settled = append(settled, SettledBlock{BlockHash: hash, Status: types.ExecutionInvalid, LatestValidHash: &lvh})
+		}
+	}
+	for _, block := range settled {
+		v.log.WithFields(logrus.Fields{"block_hash": block.BlockHash, "status": block.Status}).Info("Settled block verdict")
+	}
+	return settled
+}
+
+// descendants returns the pending blocks that descend from the block.
+func (v *Verdicts) descendants(blockHash common.Hash) []common.Hash {
+	var hashes []common.Hash
+	for hash := range v.pending {
+		for ancestor, ok := v.pending[hash]; ok; ancestor, ok = v.pending[ancestor.parent] {
+			if ancestor.parent == blockHash {
+				hashes = append(hashes, hash)
+				break
+			}
+		}
+	}
+	return hashes
+}
+
+// ValidateBlock settles the pending verdict of the block, and of its ancestors, as VALID.
+func (b *MockBackend) ValidateBlock(ctx context.Context, blockHash common.Hash) ([]SettledBlock, error) {
+	return b.engine.verdicts.Settle(blockHash, true)
+}
+
+// InvalidateBlock settles the pending verdict of the block, and of its descendants, as INVALID, with the parent
+// of the block as latest valid hash. Its pending ancestors are settled as VALID.
+func (b *MockBackend) InvalidateBlock(ctx context.Context, blockHash common.Hash) ([]SettledBlock, error) {
+	return b.engine.verdicts.Settle(blockHash, false)
+}
@@ -0,0 +1,182 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mergemock/types"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/sirupsen/logrus"
+)
+
+// Number of served payloads that submissions are compared against.
+const servedPayloadsSize = 64
+
+// FieldDifference is a field of a submitted payload with another value than in the served payload, null if missing.
+type FieldDifference struct {
+	Field     string          `json:"field"`
+	Served    json.RawMessage `json:"served"`
+	Submitted json.RawMessage `json:"submitted"`
+}
+
+// PayloadMismatch is a payload submitted with newPayload that differs from the payload getPayload served for
+// the same block, or for the same parent and timestamp. Consensus clients must submit payloads unchanged.
+type PayloadMismatch struct {
+	PayloadID     types.PayloadID   `json:"payloadId"`
+	Method        string            `json:"method"`
+	ServedHash    common.Hash       `json:"servedHash"`
+	SubmittedHash common.Hash       `json:"submittedHash"`
+	Differences   []FieldDifference `json:"differences"`
+}
+
+type servedPayload struct {
+	id     types.PayloadID
+	hash   common.Hash
+	fields map[string]json.RawMessage
+}
+
+type payloadSlot struct {
+	parent    common.Hash
+	timestamp uint64
+}
+
+// PayloadChecker remembers the payloads served by getPayload, and compares the payloads submitted with newPayload
+// to them field by field.
+type PayloadChecker struct {
+	log logrus.Ext1FieldLogger
+
+	mu         sync.Mutex
+	byHash     *lru.Cache // block hash -> *servedPayload
+	bySlot     *lru.Cache // payloadSlot -> *servedPayload
+	mismatches []PayloadMismatch
+}
+
+func NewPayloadChecker(log logrus.Ext1FieldLogger) *PayloadChecker {
+	byHash, _ := lru.New(servedPayloadsSize)
+	bySlot, _ := lru.New(servedPayloadsSize)
+	return &PayloadChecker{log: log, byHash: byHash, bySlot: bySlot}
+}
+
+// payloadFields returns the JSON fields of a payload, of any version.
+func payloadFields(payload interface{}) (map[string]json.RawMessage, error) {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	return fields, json.Unmarshal(buf, &fields)
+}
+
+// Served records a payload returned by getPayload.
+func (c *PayloadChecker) Served(id types.PayloadID, hash, parent common.Hash, timestamp uint64, payload interface{}) {
+	fields, err := payloadFields(payload)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to encode served payload for comparison")
+		return
+	}
+	served := &servedPayload{id: id, hash: hash, fields: fields}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byHash.Add(hash, served)
+	c.bySlot.Add(payloadSlot{parent, timestamp}, served)
+}
+
+// Submitted compares a payload submitted with newPayload to the served payload of the same block, or else of the
+// same parent and timestamp, and returns the mismatch if any field differs. Payloads that weren't served by
+// the engine, e.g. built by another one, aren't compared.
+func (c *PayloadChecker) Submitted(method string, hash, parent common.Hash, timestamp uint64, payload interface{}) *PayloadMismatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.byHash.Get(hash)
+	if !ok {
+		if value, ok = c.bySlot.Get(payloadSlot{parent, timestamp}); !ok {
+			return nil
+		}
+	}
+	served := value.(*servedPayload)
+	for _, m := range c.mismatches {
+		if m.PayloadID == served.id && m.SubmittedHash == hash {
+			return nil
+		}
+	}
+	fields, err := payloadFields(payload)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to encode submitted payload for comparison")
+		return nil
+	}
+	differences := diffFields(served.fields, fields)
+	if len(differences) == 0 {
+		return nil
+	}
+	mismatch := PayloadMismatch{PayloadID: served.id, Method: method, ServedHash: served.hash, SubmittedHash: hash, Differences: differences}
+	c.mismatches = append(c.mismatches, mismatch)
+	names := make([]string, 0, len(differences))
+	for _, d := range differences {
+		names = append(names, d.Field)
+	}
+	c.log.WithFields(logrus.Fields{
+		"payload_id":     served.id,
+		"method":         method,
+		"served_hash":    served.hash,
+		"submitted_hash": hash,
+		"fields":         names,
+	}).Error("Consensus client submitted a payload differing from the served payload")
+	return &mismatch
+}
+
+// diffFields returns the fields with different values, in the order of their names. Missing fields count as null,
+// so that the fields of newer payload versions don't differ when null.
+func diffFields(served, submitted map[string]json.RawMessage) []FieldDifference {
+	names := make([]string, 0, len(served))
+	for name := range served {
+		names = append(names, name)
+	}
+	for name := range submitted {
+		if _, ok := served[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	null := json.RawMessage("null")
+	var differences []FieldDifference
+	for _, name := range names {
+		a, b := served[name], submitted[name]
+		if a == nil {
+			a = null
+		}
+		if b == nil {
+			b = null
+		}
+		if !bytes.Equal(a, b) {
+			differences = append(differences, FieldDifference{Field: name, Served: a, Submitted: b})
+		}
+	}
+	return differences
+}
+
+// Mismatches returns all mismatching submissions observed so far.
+func (c *PayloadChecker) Mismatches() []PayloadMismatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]PayloadMismatch{}, c.mismatches...)
+}
+
+// served records the payload served for the id, for later submissions to be compared against.
+func (e *EngineBackend) served(method string, id types.PayloadID, built *builtPayload) {
+	if built.v3 != nil {
+		e.checks.Served(id, built.v3.BlockHash, built.v3.ParentHash, built.v3.Timestamp, built.v3)
+		e.events.PayloadServed(method, id, built.v3.BlockHash, built.v3.ParentHash, built.v3.Timestamp, built.created)
+	} else {
+		e.checks.Served(id, built.v2.BlockHash, built.v2.ParentHash, built.v2.Timestamp, built.v2)
+		e.events.PayloadServed(method, id, built.v2.BlockHash, built.v2.ParentHash, built.v2.Timestamp, built.created)
+	}
+}
+
+// GetPayloadMismatches returns the payloads the consensus client submitted with other fields than they were served with.
+func (b *MockBackend) GetPayloadMismatches(ctx context.Context) []PayloadMismatch {
+	return b.engine.checks.Mismatches()
+}
@@ -0,0 +1,59 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadMismatches(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader()
+	mismatches := func() []PayloadMismatch {
+		var mismatches []PayloadMismatch
+		require.NoError(t, te.client.CallContext(ctx, &mismatches, "mock_getPayloadMismatches"))
+		return mismatches
+	}
+
+	// Payloads submitted as served pass.
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	require.Empty(t, mismatches())
+
+	// A mutated payload is reported, by the fields that differ, also under another block hash.
+	child := te.buildPayload(t, payload.BlockHash, payload.Timestamp+12, common.Hash{0x02})
+	mutated := *child
+	mutated.FeeRecipient = common.Address{0xee}
+	mutated.ExtraData = []byte("mutated")
+	_, err := api.NewPayloadV1(ctx, te.client, te.log, &mutated)
+	require.NoError(t, err)
+	rehashed := mutated
+	rehashed.BlockHash = common.Hash{0xbb}
+	_, err = api.NewPayloadV1(ctx, te.client, te.log, &rehashed)
+	require.NoError(t, err)
+	_, err = api.NewPayloadV1(ctx, te.client, te.log, &rehashed)
+	require.NoError(t, err)
+
+	found := mismatches()
+	require.Len(t, found, 2, "repeated submissions are reported once")
+	require.Equal(t, child.BlockHash, found[0].ServedHash)
+	require.Equal(t, child.BlockHash, found[0].SubmittedHash)
+	require.Equal(t, "engine_newPayloadV1", found[0].Method)
+	require.Len(t, found[0].Differences, 2)
+	require.Equal(t, "extraData", found[0].Differences[0].Field)
+	require.Equal(t, "feeRecipient", found[0].Differences[1].Field)
+	served, _ := json.Marshal(common.Address{0x02})
+	require.JSONEq(t, string(served), string(found[0].Differences[1].Served))
+	require.Equal(t, common.Hash{0xbb}, found[1].SubmittedHash)
+	require.Equal(t, []string{"blockHash", "extraData", "feeRecipient"}, []string{found[1].Differences[0].Field, found[1].Differences[1].Field, found[1].Differences[2].Field})
+
+	var stats Stats
+	require.NoError(t, te.client.CallContext(ctx, &stats, "mock_stats"))
+	require.Equal(t, 2, stats.PayloadMismatches)
+}
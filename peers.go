@@ -0,0 +1,220 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"mergemock/rpc"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+type PeersConfig struct {
+	Count     uint64        `ask:"--count" help:"Number of fake peers the engine reports with net_peerCount and admin_peers, can be changed with mock_setPeerCount"`
+	Static    []string      `ask:"--static" help:"Enode URLs of static peers reported next to the fake ones, can be changed with admin_addPeer and admin_removePeer"`
+	DropEvery time.Duration `ask:"--drop-every" help:"Interval of simulated peer count drops (0 to never drop)"`
+	DropFor   time.Duration `ask:"--drop-for" help:"Duration of every simulated peer count drop"`
+	DropTo    uint64        `ask:"--drop-to" help:"Number of peers left during a simulated drop"`
+}
+
+// NewPeerSet returns the peers of the config.
+func (c *PeersConfig) NewPeerSet() (*PeerSet, error) {
+	static := make([]*enode.Node, 0, len(c.Static))
+	for _, url := range c.Static {
+		n, err := enode.ParseV4(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static peer %q: %v", url, err)
+		}
+		static = append(static, n)
+	}
+	if c.DropEvery > 0 && (c.DropFor <= 0 || c.DropFor >= c.DropEvery) {
+		return nil, fmt.Errorf("peer drops of %s every %s, the drops must last shorter than the interval", c.DropFor, c.DropEvery)
+	}
+	return newPeerSet(*c, static), nil
+}
+
+func newPeerSet(cfg PeersConfig, static []*enode.Node) *PeerSet {
+	clock := SystemClock{}
+	return &PeerSet{cfg: cfg, clock: clock, start: clock.Now(), static: static, count: cfg.Count}
+}
+
+// PeerSet is the made-up peers of the engine, which doesn't connect to any: static peers, and fake peers with
+// deterministic node keys. Their number drops periodically, to test how clients react to a poorly peered engine.
+type PeerSet struct {
+	cfg   PeersConfig
+	clock Clock
+	start time.Time
+
+	mu     sync.Mutex
+	static []*enode.Node
+	count  uint64
+}
+
+// fakePeer returns the fake peer with the index, the same on every run.
+func fakePeer(i uint64) *enode.Node {
+	key, _ := crypto.ToECDSA(crypto.Keccak256([]byte(fmt.Sprintf("mergemock peer %d", i))))
+	return enode.NewV4(&key.PublicKey, net.IPv4(10, 0, byte(i>>8), byte(i)), 30303, 30303)
+}
+
+// dropping reports whether the peer count is simulated to be dropped.
+func (s *PeerSet) dropping() bool {
+	if s.cfg.DropEvery <= 0 {
+		return false
+	}
+	return s.clock.Now().Sub(s.start)%s.cfg.DropEvery >= s.cfg.DropEvery-s.cfg.DropFor
+}
+
+// Peers returns the connected peers, static ones first.
+func (s *PeerSet) Peers() []*p2p.PeerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]*p2p.PeerInfo, 0, uint64(len(s.static))+s.count)
+	for _, n := range s.static {
+		peers = append(peers, peerInfo(n, "static", true))
+	}
+	for i := uint64(0); i < s.count; i++ {
+		peers = append(peers, peerInfo(fakePeer(i), fmt.Sprintf("peer%d", i), false))
+	}
+	if s.dropping() && uint64(len(peers)) > s.cfg.DropTo {
+		peers = peers[:s.cfg.DropTo]
+	}
+	return peers
+}
+
+func peerInfo(n *enode.Node, name string, static bool) *p2p.PeerInfo {
+	info := &p2p.PeerInfo{
+		Enode:     n.URLv4(),
+		ID:        n.ID().String(),
+		Name:      "mergemock/" + name,
+		Caps:      []string{"eth/66", "snap/1"},
+		Protocols: map[string]interface{}{"eth": "handshake"},
+	}
+	info.Network.LocalAddress = "127.0.0.1:30303"
+	info.Network.RemoteAddress = fmt.Sprintf("%v:%d", n.IP(), n.TCP())
+	info.Network.Static = static
+	return info
+}
+
+// SetCount changes the number of fake peers.
+func (s *PeerSet) SetCount(count uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count = count
+}
+
+// AddStatic adds a static peer, unless it is one already.
+func (s *PeerSet) AddStatic(n *enode.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.static {
+		if other.ID() == n.ID() {
+			return
+		}
+	}
+	s.static = append(s.static, n)
+}
+
+// RemoveStatic removes a static peer, and reports whether it was one.
+func (s *PeerSet) RemoveStatic(n *enode.Node) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.static {
+		if other.ID() == n.ID() {
+			s.static = append(s.static[:i], s.static[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NetBackend serves the net namespace, with the peer count of the made-up peers.
+type NetBackend struct {
+	engine *EngineBackend
+}
+
+func NewNetBackend(engine *EngineBackend) *NetBackend {
+	return &NetBackend{engine: engine}
+}
+
+func (b *NetBackend) Register(srv *rpc.Server) error {
+	srv.RegisterName("net", b)
+	return node.RegisterApis([]rpc.API{
+		{
+			Namespace:     "net",
+			Version:       "1.0",
+			Service:       b,
+			Public:        true,
+			Authenticated: false,
+		},
+	}, []string{"net"}, srv, false)
+}
+
+func (b *NetBackend) Listening() bool {
+	return true
+}
+
+func (b *NetBackend) PeerCount() hexutil.Uint {
+	return hexutil.Uint(len(b.engine.peers.Peers()))
+}
+
+// Version returns the network id, which is the chain id of the genesis.
+func (b *NetBackend) Version() string {
+	return b.engine.mockChain.gspec.Config.ChainID.String()
+}
+
+// AdminBackend serves the peer methods of the admin namespace on the made-up peers.
+type AdminBackend struct {
+	engine *EngineBackend
+}
+
+func NewAdminBackend(engine *EngineBackend) *AdminBackend {
+	return &AdminBackend{engine: engine}
+}
+
+func (b *AdminBackend) Register(srv *rpc.Server) error {
+	srv.RegisterName("admin", b)
+	return node.RegisterApis([]rpc.API{
+		{
+			Namespace:     "admin",
+			Version:       "1.0",
+			Service:       b,
+			Public:        true,
+			Authenticated: false,
+		},
+	}, []string{"admin"}, srv, false)
+}
+
+func (b *AdminBackend) Peers(ctx context.Context) []*p2p.PeerInfo {
+	return b.engine.peers.Peers()
+}
+
+// AddPeer adds a static peer, connected at once.
+func (b *AdminBackend) AddPeer(ctx context.Context, url string) (bool, error) {
+	n, err := enode.ParseV4(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	b.engine.peers.AddStatic(n)
+	return true, nil
+}
+
+// RemovePeer removes a static peer, and reports whether it was one.
+func (b *AdminBackend) RemovePeer(ctx context.Context, url string) (bool, error) {
+	n, err := enode.ParseV4(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	return b.engine.peers.RemoveStatic(n), nil
+}
+
+// SetPeerCount changes the number of fake peers, e.g. to 0 to test a consensus client with an isolated engine.
+func (b *MockBackend) SetPeerCount(ctx context.Context, count hexutil.Uint64) {
+	b.engine.peers.SetCount(uint64(count))
+	b.engine.log.WithField("count", uint64(count)).Warn("Changed peer count")
+}
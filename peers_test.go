@@ -0,0 +1,55 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnginePeers(t *testing.T) {
+	static := fakePeer(100).URLv4()
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Peers.Count = 3
+		cmd.Peers.Static = []string{static}
+		cmd.Peers.DropEvery = time.Minute
+		cmd.Peers.DropFor = 10 * time.Second
+		cmd.Peers.DropTo = 1
+	})
+	ctx := context.Background()
+	clock := NewFakeClock(te.backend.peers.start)
+	te.backend.peers.clock = clock
+	peerCount := func() uint {
+		var count hexutil.Uint
+		require.NoError(t, te.client.CallContext(ctx, &count, "net_peerCount"))
+		return uint(count)
+	}
+
+	var peers []*p2p.PeerInfo
+	require.NoError(t, te.client.CallContext(ctx, &peers, "admin_peers"))
+	require.Len(t, peers, 4)
+	require.Equal(t, static, peers[0].Enode)
+	require.True(t, peers[0].Network.Static)
+	require.Equal(t, fakePeer(0).URLv4(), peers[1].Enode, "fake peers are the same on every run")
+	var version string
+	require.NoError(t, te.client.CallContext(ctx, &version, "net_version"))
+	require.Equal(t, te.mockChain().gspec.Config.ChainID.String(), version)
+
+	// The count drops for the last 10s of every minute.
+	clock.Advance(55 * time.Second)
+	require.Equal(t, uint(1), peerCount())
+	clock.Advance(10 * time.Second)
+	require.Equal(t, uint(4), peerCount())
+
+	var ok bool
+	require.NoError(t, te.client.CallContext(ctx, &ok, "admin_removePeer", static))
+	require.True(t, ok)
+	require.NoError(t, te.client.CallContext(ctx, nil, "mock_setPeerCount", hexutil.Uint64(0)))
+	require.Zero(t, peerCount())
+	require.NoError(t, te.client.CallContext(ctx, &ok, "admin_addPeer", static))
+	require.Equal(t, uint(1), peerCount())
+	require.Error(t, te.client.CallContext(ctx, &ok, "admin_addPeer", "enode://invalid"))
+}
@@ -0,0 +1,113 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mergemock/types"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+)
+
+// engineStateFile is the file in the datadir the engine state is persisted to on exit, and restored from on start.
+const engineStateFile = "mergemock-engine.json"
+
+// EngineCheckpoint is the engine state that isn't part of the chain: the payload cache, the last forkchoice state
+// and the payload id counter. The chain itself persists in the datadir.
+type EngineCheckpoint struct {
+	PayloadCounter hexutil.Uint64          `json:"payloadCounter"`
+	Forkchoice     types.ForkchoiceStateV1 `json:"forkchoice"`
+	Payloads       []CheckpointPayload     `json:"payloads"`
+}
+
+// CheckpointPayload is a built payload of the cache, with exactly one of the V2 and V3 payloads, and the
+// execution requests of Prague payloads.
+type CheckpointPayload struct {
+	PayloadID types.PayloadID           `json:"payloadId"`
+	V2        *types.ExecutionPayloadV2 `json:"executionPayloadV2,omitempty"`
+	V3        *types.ExecutionPayloadV3 `json:"executionPayloadV3,omitempty"`
+	Requests  types.ExecutionRequests   `json:"executionRequests"`
+	Value     *hexutil.Big              `json:"blockValue"`
+	Created   time.Time                 `json:"created"`
+}
+
+// Checkpoint returns the engine state, with the payloads from the least to the most recently used.
+func (e *EngineBackend) Checkpoint() *EngineCheckpoint {
+	cp := &EngineCheckpoint{
+		PayloadCounter: hexutil.Uint64(atomic.LoadUint64(&e.payloadIdCounter)),
+		Forkchoice:     e.control.Forkchoice(),
+		Payloads:       []CheckpointPayload{},
+	}
+	for _, key := range e.recentPayloads.Keys() {
+		id, ok := key.(types.PayloadID)
+		if !ok {
+			continue
+		}
+		value, ok := e.recentPayloads.Peek(id)
+		if !ok {
+			continue
+		}
+		built := value.(*builtPayload)
+		cp.Payloads = append(cp.Payloads, CheckpointPayload{id, built.v2, built.v3, built.requests, (*hexutil.Big)(built.value), built.created})
+	}
+	return cp
+}
+
+// Restore replaces the engine state with the checkpoint, journaling the restore with the trigger.
+func (e *EngineBackend) Restore(cp *EngineCheckpoint, trigger string) error {
+	for _, p := range cp.Payloads {
+		if (p.V2 == nil) == (p.V3 == nil) {
+			return fmt.Errorf("payload %s needs exactly one of a V2 and a V3 payload", p.PayloadID)
+		}
+	}
+	e.recentPayloads.Purge()
+	e.parentPayloads.Purge()
+	for _, p := range cp.Payloads {
+		value := new(big.Int)
+		if p.Value != nil {
+			value = p.Value.ToInt()
+		}
+		e.storePayload(p.PayloadID, &builtPayload{v2: p.V2, v3: p.V3, requests: p.Requests, value: value, created: p.Created})
+	}
+	atomic.StoreUint64(&e.payloadIdCounter, uint64(cp.PayloadCounter))
+	e.control.SetForkchoice(cp.Forkchoice)
+	e.mockChain.recordRestore(trigger, cp.Forkchoice.HeadBlockHash)
+	e.log.WithFields(logrus.Fields{"payloads": len(cp.Payloads), "payload_counter": cp.PayloadCounter, "head": cp.Forkchoice.HeadBlockHash}).Info("Restored engine state")
+	return nil
+}
+
+func LoadEngineCheckpoint(path string) (*EngineCheckpoint, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp EngineCheckpoint
+	if err := json.Unmarshal(buf, &cp); err != nil {
+		return nil, fmt.Errorf("invalid engine state %s: %v", path, err)
+	}
+	return &cp, nil
+}
+
+func WriteEngineCheckpoint(path string, cp *EngineCheckpoint) error {
+	buf, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0644)
+}
+
+// SaveState returns the engine state, for a test to restore it later with mock_restoreState.
+func (b *MockBackend) SaveState(ctx context.Context) *EngineCheckpoint {
+	return b.engine.Checkpoint()
+}
+
+// RestoreState replaces the payload cache, forkchoice state and payload id counter with those of the checkpoint.
+// The chain isn't rewound.
+func (b *MockBackend) RestoreState(ctx context.Context, cp EngineCheckpoint) error {
+	return b.engine.Restore(&cp, "mock_restoreState")
+}
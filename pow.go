@@ -0,0 +1,110 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+// TTDReachedEvent is the event field of the log line announcing that the chain reached the terminal total
+// difficulty, for test orchestration to key the steps after the transition off.
+const TTDReachedEvent = "ttd_reached"
+
+// MineTerminalChain extends the chain with proof-of-work blocks of the difficulty until the terminal total
+// difficulty is reached, so the transition can be discovered through the eth namespace like on a real chain.
+// With a difficulty of 0 a single block reaches it. It returns the terminal block, announced if it was mined.
+func (c *MockChain) MineTerminalChain(difficulty *big.Int) (*types.Header, error) {
+	mined := false
+	for !c.TTDReached() {
+		if _, err := c.minePowBlock(difficulty); err != nil {
+			return nil, err
+		}
+		mined = true
+	}
+	head := c.CurrentHeader()
+	if mined {
+		c.AnnounceTerminal(c.log, head)
+	}
+	return head, nil
+}
+
+// minePowBlock mines a proof-of-work block of the difficulty on the head, or of the difficulty left to the terminal
+// total difficulty if it is 0.
+func (c *MockChain) minePowBlock(difficulty *big.Int) (*types.Header, error) {
+	parent := c.CurrentHeader()
+	d := difficulty
+	if d.Sign() == 0 {
+		td := c.chain.GetTd(parent.Hash(), parent.Number.Uint64())
+		d = new(big.Int).Sub(c.gspec.Config.TerminalTotalDifficulty, td)
+	}
+	block, err := c.mineBlock(parent, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mine proof-of-work block %d: %v", parent.Number.Uint64()+1, err)
+	}
+	return block.Header(), nil
+}
+
+// minePowChain mines a proof-of-work block of the difficulty every interval of the clock until the terminal total
+// difficulty is reached, so consensus clients see the transition approach live and have to detect the terminal
+// block themselves, instead of starting on a chain that reached it already.
+func (e *EngineBackend) minePowChain(clock Clock, interval time.Duration, difficulty *big.Int, stop <-chan struct{}) {
+	if e.mockChain.TTDReached() {
+		return
+	}
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			head, err := e.mockChain.minePowBlock(difficulty)
+			if err != nil {
+				e.log.WithError(err).Error("Failed mining proof-of-work block")
+				continue
+			}
+			if e.mockChain.TTDReached() {
+				e.mockChain.AnnounceTerminal(e.log, head)
+				return
+			}
+			e.log.WithFields(logrus.Fields{
+				"number": head.Number,
+				"td":     e.mockChain.chain.GetTd(head.Hash(), head.Number.Uint64()),
+			}).Debug("Mined proof-of-work block")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// IsTerminalBlock returns whether a payload may be built on the block: it reached the terminal total
+// difficulty, and is either a proof-of-stake block or the first proof-of-work block to reach it.
+func (c *MockChain) IsTerminalBlock(header *types.Header) bool {
+	ttd := c.gspec.Config.TerminalTotalDifficulty
+	td := c.chain.GetTd(header.Hash(), header.Number.Uint64())
+	if td == nil || td.Cmp(ttd) < 0 {
+		return false
+	}
+	if header.Difficulty.Sign() == 0 || header.Number.Sign() == 0 {
+		return true
+	}
+	parentTd := c.chain.GetTd(header.ParentHash, header.Number.Uint64()-1)
+	return parentTd != nil && parentTd.Cmp(ttd) < 0
+}
+
+// AnnounceTerminal logs the TTDReachedEvent for the block that reached the terminal total difficulty.
+func (c *MockChain) AnnounceTerminal(log logrus.Ext1FieldLogger, header *types.Header) {
+	log.WithFields(logrus.Fields{
+		"event":  TTDReachedEvent,
+		"number": header.Number,
+		"hash":   header.Hash(),
+		"td":     c.chain.GetTd(header.Hash(), header.Number.Uint64()),
+		"ttd":    c.gspec.Config.TerminalTotalDifficulty,
+	}).Info("Terminal total difficulty reached")
+}
+
+// TTDReached returns whether the head reached the terminal total difficulty.
+func (c *MockChain) TTDReached() bool {
+	return c.CurrentTd().Cmp(c.gspec.Config.TerminalTotalDifficulty) >= 0
+}
@@ -0,0 +1,122 @@
+package mergemock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Preset is a curated combination of faults and delays, selected by name with --preset.
+type Preset struct {
+	Description string
+	// Relay presets configure the relay itself, the others the engine, also the one of the relay.
+	Relay       bool
+	Faults      []string
+	Delays      DelayConfig
+	RelayFaults RelayFaultConfig
+}
+
+var presets = map[string]*Preset{
+	"flaky-el": {
+		Description: "occasional SYNCING payload statuses, internal errors on forkchoiceUpdated and unknown payloads on getPayload",
+		Faults: concat(
+			engineVersions("engine_newPayload", "action=status;status=SYNCING;probability=0.1"),
+			engineVersions("engine_forkchoiceUpdated", "action=error;code=-32603;message=internal error;probability=0.05"),
+			engineVersions("engine_getPayload", "action=error;code=-38001;message=Unknown payload;probability=0.05"),
+		),
+	},
+	"slow-el": {
+		Description: "slow engine calls with jitter, and long-tail spikes of 6s",
+		Delays: DelayConfig{
+			NewPayload:        800 * time.Millisecond,
+			ForkchoiceUpdated: 300 * time.Millisecond,
+			GetPayload:        500 * time.Millisecond,
+			Jitter:            200 * time.Millisecond,
+			SpikeProbability:  0.05,
+			Spike:             6 * time.Second,
+		},
+	},
+	"byzantine-relay": {
+		Description: "a relay that withholds payloads of signed blinded blocks, and serves bids after the getHeader deadline",
+		Relay:       true,
+		RelayFaults: RelayFaultConfig{
+			WithholdProbability: 0.2,
+			LateBidProbability:  0.3,
+			LateBidDelay:        1500 * time.Millisecond,
+		},
+	},
+}
+
+// engineVersions returns the fault rule for every version of the engine method.
+func engineVersions(method, rule string) []string {
+	rules := make([]string, 0, 3)
+	for v := 1; v <= 3; v++ {
+		rules = append(rules, fmt.Sprintf("method=%sV%d;%s", method, v, rule))
+	}
+	return rules
+}
+
+func concat(lists ...[]string) []string {
+	var out []string
+	for _, list := range lists {
+		out = append(out, list...)
+	}
+	return out
+}
+
+// PresetNames returns the names of the presets, sorted.
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupPreset(name string) (*Preset, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q, expected one of %s", name, strings.Join(PresetNames(), ", "))
+	}
+	return preset, nil
+}
+
+// ApplyEngine adds the faults and delays of the preset to the engine configuration. Fault rules of flags
+// match first, and delays set by flags are kept.
+func (p *Preset) ApplyEngine(c *EngineCmd) {
+	c.Faults.Rules = append(append([]string{}, c.Faults.Rules...), p.Faults...)
+	d := &c.Delays
+	if d.NewPayload == 0 {
+		d.NewPayload = p.Delays.NewPayload
+	}
+	if d.ForkchoiceUpdated == 0 {
+		d.ForkchoiceUpdated = p.Delays.ForkchoiceUpdated
+	}
+	if d.GetPayload == 0 {
+		d.GetPayload = p.Delays.GetPayload
+	}
+	if d.Jitter == 0 {
+		d.Jitter = p.Delays.Jitter
+	}
+	if d.SpikeProbability == 0 {
+		d.SpikeProbability = p.Delays.SpikeProbability
+	}
+	if d.Spike == 0 {
+		d.Spike = p.Delays.Spike
+	}
+}
+
+// ApplyRelay sets the relay faults of the preset, where flags didn't set them.
+func (p *Preset) ApplyRelay(c *RelayFaultConfig) {
+	if c.WithholdProbability == 0 {
+		c.WithholdProbability = p.RelayFaults.WithholdProbability
+	}
+	if c.LateBidProbability == 0 {
+		c.LateBidProbability = p.RelayFaults.LateBidProbability
+	}
+	if c.LateBidDelay == 0 {
+		c.LateBidDelay = p.RelayFaults.LateBidDelay
+	}
+}
@@ -0,0 +1,46 @@
+package mergemock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets(t *testing.T) {
+	for _, name := range PresetNames() {
+		preset, err := LookupPreset(name)
+		require.NoError(t, err)
+		for _, rule := range preset.Faults {
+			_, err := ParseFaultRule(rule)
+			require.NoError(t, err, "%s: %s", name, rule)
+		}
+	}
+	_, err := LookupPreset("calm-el")
+	require.Error(t, err)
+
+	// Flags take precedence over the preset.
+	cmd := &EngineCmd{}
+	cmd.Faults.Rules = []string{"method=engine_newPayloadV1;action=drop"}
+	cmd.Delays.NewPayload = time.Second
+	for _, name := range []string{"flaky-el", "slow-el"} {
+		preset, _ := LookupPreset(name)
+		preset.ApplyEngine(cmd)
+	}
+	require.Len(t, cmd.Faults.Rules, 10)
+	require.Equal(t, "method=engine_newPayloadV1;action=drop", cmd.Faults.Rules[0])
+	require.Equal(t, time.Second, cmd.Delays.NewPayload)
+	require.Equal(t, 300*time.Millisecond, cmd.Delays.ForkchoiceUpdated)
+	require.NotNil(t, cmd.Delays.NewDelayer())
+
+	relay := RelayFaultConfig{LateBidDelay: time.Second}
+	preset, _ := LookupPreset("byzantine-relay")
+	require.True(t, preset.Relay)
+	preset.ApplyRelay(&relay)
+	require.Equal(t, time.Second, relay.LateBidDelay)
+	require.Equal(t, 0.2, relay.WithholdProbability)
+	require.Nil(t, (&RelayFaultConfig{LateBidDelay: time.Second}).NewRelayFaults())
+	faults := (&RelayFaultConfig{LateBidProbability: 1, LateBidDelay: time.Second}).NewRelayFaults()
+	require.Equal(t, time.Second, faults.LateBid())
+	require.False(t, faults.Withhold())
+}
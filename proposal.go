@@ -0,0 +1,268 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+type ProposalCmd struct {
+	OutPath   string `ask:"--out" help:"File to write the JSON-RPC messages to (empty for stdout)"`
+	Responses bool   `ask:"--responses" help:"Pair every request with the response of the engine, instead of writing the requests only"`
+
+	Engine EngineCmd `ask:".engine" help:"Configure the engine answering the proposal, whose addresses are picked per run"`
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log logrus.Ext1FieldLogger
+	out io.Writer
+}
+
+func (c *ProposalCmd) Default() {}
+
+func (c *ProposalCmd) Help() string {
+	return "Write the JSON-RPC messages a consensus client sends for one proposal on the head of the engine: forkchoiceUpdated with payload attributes, getPayload, newPayload and forkchoiceUpdated."
+}
+
+// ProposalMessage is a request of the proposal flow, and the response it got.
+type ProposalMessage struct {
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+func (c *ProposalCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+
+	engine := c.Engine
+	engine.ListenAddr, engine.WebsocketAddr = "127.0.0.1:0", "127.0.0.1:0"
+	if err := engine.Run(ctx); err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	probe, err := rpc.DialContext(ctx, "http://"+engine.ListenAddr, engine.jwtSecret)
+	if err != nil {
+		return err
+	}
+	err = waitForEngine(ctx, probe)
+	probe.Close()
+	if err != nil {
+		return fmt.Errorf("engine not answering: %v", err)
+	}
+	// A client of its own records the proposal only, with request ids counting from 1.
+	recorder := &rpcRecorder{next: http.DefaultTransport}
+	client, err := rpc.DialHTTPWithClient("http://"+engine.ListenAddr, engine.jwtSecret, &http.Client{Transport: recorder})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	chain := engine.mockChain()
+	if err := c.propose(ctx, client, chain, chain.CurrentHeader().Hash()); err != nil {
+		return err
+	}
+
+	var out interface{} = recorder.Requests()
+	if c.Responses {
+		out = recorder.Messages()
+	}
+	buf, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if c.OutPath != "" {
+		if err := os.WriteFile(c.OutPath, buf, 0644); err != nil {
+			return fmt.Errorf("failed to write proposal messages: %v", err)
+		}
+		return nil
+	}
+	w := c.out
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// propose runs one proposal on the head, with the method versions of the fork active at the slot of the payload.
+func (c *ProposalCmd) propose(ctx context.Context, client *rpc.Client, chain *MockChain, head common.Hash) error {
+	parent := chain.chain.GetHeaderByHash(chain.ResolveHash(head))
+	if parent == nil {
+		return fmt.Errorf("unknown head %s", head)
+	}
+	timestamp := parent.Time + 12
+	prevRandao := common.BigToHash(new(big.Int).Add(parent.Number, common.Big1))
+
+	var blockHash common.Hash
+	var status types.ExecutePayloadStatus
+	switch {
+	case chain.IsCancun(timestamp):
+		attributes := &types.PayloadAttributesV3{
+			Timestamp:             timestamp,
+			PrevRandao:            prevRandao,
+			SuggestedFeeRecipient: common.Address{0x42},
+			Withdrawals:           []*types.Withdrawal{},
+			ParentBeaconBlockRoot: common.Hash{0x01},
+		}
+		fcu, err := api.ForkchoiceUpdatedV3(ctx, client, c.log, head, head, head, attributes)
+		if err != nil {
+			return err
+		}
+		if fcu.PayloadID == nil {
+			return fmt.Errorf("no payload id returned, status %s", fcu.PayloadStatus.Status)
+		}
+		envelope, err := api.GetPayloadV3(ctx, client, c.log, *fcu.PayloadID)
+		if err != nil {
+			return err
+		}
+		result, err := api.NewPayloadV3(ctx, client, c.log, envelope.ExecutionPayload, []common.Hash{}, attributes.ParentBeaconBlockRoot)
+		if err != nil {
+			return err
+		}
+		blockHash, status = envelope.ExecutionPayload.BlockHash, result.Status
+		if status == types.ExecutionValid {
+			_, err = api.ForkchoiceUpdatedV3(ctx, client, c.log, blockHash, head, head, nil)
+		}
+		if err != nil {
+			return err
+		}
+	case chain.IsShanghai(timestamp):
+		attributes := &types.PayloadAttributesV2{
+			Timestamp:             timestamp,
+			PrevRandao:            prevRandao,
+			SuggestedFeeRecipient: common.Address{0x42},
+			Withdrawals:           []*types.Withdrawal{},
+		}
+		fcu, err := api.ForkchoiceUpdatedV2(ctx, client, c.log, head, head, head, attributes)
+		if err != nil {
+			return err
+		}
+		if fcu.PayloadID == nil {
+			return fmt.Errorf("no payload id returned, status %s", fcu.PayloadStatus.Status)
+		}
+		envelope, err := api.GetPayloadV2(ctx, client, c.log, *fcu.PayloadID)
+		if err != nil {
+			return err
+		}
+		result, err := api.NewPayloadV2(ctx, client, c.log, envelope.ExecutionPayload)
+		if err != nil {
+			return err
+		}
+		blockHash, status = envelope.ExecutionPayload.BlockHash, result.Status
+		if status == types.ExecutionValid {
+			_, err = api.ForkchoiceUpdatedV2(ctx, client, c.log, blockHash, head, head, nil)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		attributes := &types.PayloadAttributesV1{
+			Timestamp:             timestamp,
+			PrevRandao:            prevRandao,
+			SuggestedFeeRecipient: common.Address{0x42},
+		}
+		fcu, err := api.ForkchoiceUpdatedV1(ctx, client, c.log, head, head, head, attributes)
+		if err != nil {
+			return err
+		}
+		if fcu.PayloadID == nil {
+			return fmt.Errorf("no payload id returned, status %s", fcu.PayloadStatus.Status)
+		}
+		payload, err := api.GetPayloadV1(ctx, client, c.log, *fcu.PayloadID)
+		if err != nil {
+			return err
+		}
+		result, err := api.NewPayloadV1(ctx, client, c.log, payload)
+		if err != nil {
+			return err
+		}
+		blockHash, status = payload.BlockHash, result.Status
+		if status == types.ExecutionValid {
+			_, err = api.ForkchoiceUpdatedV1(ctx, client, c.log, blockHash, head, head, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if status != types.ExecutionValid {
+		return fmt.Errorf("payload %s not valid: %s", blockHash, status)
+	}
+	return nil
+}
+
+// rpcRecorder is an HTTP transport keeping the JSON-RPC messages it carries.
+type rpcRecorder struct {
+	next     http.RoundTripper
+	mu       sync.Mutex
+	messages []ProposalMessage
+}
+
+func (r *rpcRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var request []byte
+	if req.Body != nil {
+		var err error
+		if request, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(request))
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(response))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, ProposalMessage{Request: compactJSON(request), Response: compactJSON(response)})
+	return resp, nil
+}
+
+func (r *rpcRecorder) Messages() []ProposalMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ProposalMessage{}, r.messages...)
+}
+
+// Requests returns the recorded requests, which together form a JSON-RPC batch.
+func (r *rpcRecorder) Requests() []json.RawMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := make([]json.RawMessage, len(r.messages))
+	for i, m := range r.messages {
+		requests[i] = m.Request
+	}
+	return requests
+}
+
+// compactJSON strips the trailing newline of the encoder, and keeps anything that isn't JSON as a string.
+func compactJSON(buf []byte) json.RawMessage {
+	var out bytes.Buffer
+	if err := json.Compact(&out, buf); err != nil {
+		quoted, _ := json.Marshal(string(buf))
+		return quoted
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,71 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProposal(t *testing.T) {
+	out := new(bytes.Buffer)
+	cmd := &ProposalCmd{out: out}
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Engine.Default()
+	cmd.Engine.LogCmd.Default()
+	cmd.Engine.GasPriceOracle.Default()
+	cmd.Engine.JwtSecretPath = newJwt(t)
+	cmd.Engine.GenesisPath = newGenesis(t)
+	require.NoError(t, cmd.Run(context.Background()))
+	var requests []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &requests))
+	require.Len(t, requests, 4)
+	require.Equal(t, "2.0", requests[0]["jsonrpc"])
+	require.NotContains(t, requests[0], "result")
+
+	cmd.Responses = true
+	cmd.OutPath = filepath.Join(t.TempDir(), "proposal.json")
+	require.NoError(t, cmd.Run(context.Background()))
+
+	var messages []struct {
+		Request struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		} `json:"request"`
+		Response struct {
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"response"`
+	}
+	buf, err := os.ReadFile(cmd.OutPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(buf, &messages))
+	require.Len(t, messages, 4)
+	for i, method := range []string{"engine_forkchoiceUpdatedV1", "engine_getPayloadV1", "engine_newPayloadV1", "engine_forkchoiceUpdatedV1"} {
+		require.Equal(t, i+1, messages[i].Request.ID)
+		require.Equal(t, method, messages[i].Request.Method)
+		require.Nil(t, messages[i].Response.Error)
+	}
+
+	// The payload id of the first response is the parameter of getPayload, whose payload is imported and selected.
+	var fcu struct {
+		PayloadID string `json:"payloadId"`
+	}
+	require.NoError(t, json.Unmarshal(messages[0].Response.Result, &fcu))
+	require.JSONEq(t, `"`+fcu.PayloadID+`"`, string(messages[1].Request.Params[0]))
+	require.JSONEq(t, string(messages[1].Response.Result), string(messages[2].Request.Params[0]))
+	var payload, heads struct {
+		BlockHash     string `json:"blockHash"`
+		HeadBlockHash string `json:"headBlockHash"`
+	}
+	require.NoError(t, json.Unmarshal(messages[2].Request.Params[0], &payload))
+	require.NoError(t, json.Unmarshal(messages[3].Request.Params[0], &heads))
+	require.Equal(t, payload.BlockHash, heads.HeadBlockHash)
+	require.Equal(t, "null", string(messages[3].Request.Params[1]))
+}
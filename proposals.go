@@ -0,0 +1,78 @@
+package mergemock
+
+import (
+	"fmt"
+	"mergemock/types"
+	"sync"
+)
+
+// Number of slots behind the latest observed slot for which proposals are remembered.
+const proposalHistorySlots = 1024
+
+type observedProposal struct {
+	Slot          uint64     `json:"slot,string"`
+	ProposerIndex uint64     `json:"proposer_index,string"`
+	Root          types.Root `json:"root"`
+}
+
+// ProposalViolation is a pair of distinct blocks signed by the same proposer for the same slot.
+type ProposalViolation struct {
+	Slot          uint64     `json:"slot,string"`
+	ProposerIndex uint64     `json:"proposer_index,string"`
+	First         types.Root `json:"first_root"`
+	Second        types.Root `json:"second_root"`
+}
+
+func (v ProposalViolation) String() string {
+	return fmt.Sprintf("proposer %d signed conflicting blocks %s and %s for slot %d", v.ProposerIndex, v.First, v.Second, v.Slot)
+}
+
+// ProposalTracker records the blocks proposers sign, and flags any proposer signing two for the same slot.
+type ProposalTracker struct {
+	mu         sync.Mutex
+	seen       map[uint64]map[uint64]types.Root // slot -> proposer -> root
+	latest     uint64
+	violations []ProposalViolation
+}
+
+func NewProposalTracker() *ProposalTracker {
+	return &ProposalTracker{seen: make(map[uint64]map[uint64]types.Root)}
+}
+
+// Observe records a signed proposal, and returns the violation it causes if the proposer
+// already signed a different block for the slot.
+func (t *ProposalTracker) Observe(slot, proposerIndex uint64, root types.Root) *ProposalViolation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if slot > t.latest {
+		t.latest = slot
+		for s := range t.seen {
+			if s+proposalHistorySlots < slot {
+				delete(t.seen, s)
+			}
+		}
+	}
+	proposers, ok := t.seen[slot]
+	if !ok {
+		proposers = make(map[uint64]types.Root)
+		t.seen[slot] = proposers
+	}
+	first, ok := proposers[proposerIndex]
+	if !ok {
+		proposers[proposerIndex] = root
+		return nil
+	}
+	if first == root {
+		return nil
+	}
+	v := ProposalViolation{Slot: slot, ProposerIndex: proposerIndex, First: first, Second: root}
+	t.violations = append(t.violations, v)
+	return &v
+}
+
+// Violations returns all conflicting proposals observed so far.
+func (t *ProposalTracker) Violations() []ProposalViolation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ProposalViolation{}, t.violations...)
+}
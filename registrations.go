@@ -0,0 +1,52 @@
+package mergemock
+
+import (
+	"bytes"
+	"mergemock/types"
+	"sort"
+	"sync"
+)
+
+// ValidatorRegistry keeps the latest registration of every validator registered with the relay: the fee recipient
+// and gas limit its bids honor.
+type ValidatorRegistry struct {
+	mu       sync.Mutex
+	byPubkey map[types.PublicKey]types.RegisterValidatorRequestMessage
+}
+
+func NewValidatorRegistry() *ValidatorRegistry {
+	return &ValidatorRegistry{byPubkey: make(map[types.PublicKey]types.RegisterValidatorRequestMessage)}
+}
+
+// Register records the registration, refusing it if it's no later than the registration it replaces.
+func (v *ValidatorRegistry) Register(msg types.RegisterValidatorRequestMessage) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if prev, ok := v.byPubkey[msg.Pubkey]; ok && msg.Timestamp <= prev.Timestamp {
+		return errInvalidTimestamp
+	}
+	v.byPubkey[msg.Pubkey] = msg
+	return nil
+}
+
+// Get returns the latest registration of the validator, if it registered.
+func (v *ValidatorRegistry) Get(pubkey types.PublicKey) (types.RegisterValidatorRequestMessage, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	msg, ok := v.byPubkey[pubkey]
+	return msg, ok
+}
+
+// All returns the latest registrations of all validators, ordered by pubkey.
+func (v *ValidatorRegistry) All() []types.RegisterValidatorRequestMessage {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	all := make([]types.RegisterValidatorRequestMessage, 0, len(v.byPubkey))
+	for _, msg := range v.byPubkey {
+		all = append(all, msg)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return bytes.Compare(all[i].Pubkey[:], all[j].Pubkey[:]) < 0
+	})
+	return all
+}
@@ -1,4 +1,4 @@
-package main
+package mergemock
 
 import (
 	"context"
@@ -6,14 +6,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mergemock/rpc"
 	"mergemock/types"
+	"mime"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ssz "github.com/ferranbt/fastssz"
 	"github.com/gorilla/mux"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/prysmaticlabs/prysm/crypto/bls"
 	"github.com/prysmaticlabs/prysm/runtime/version"
 	"github.com/sirupsen/logrus"
@@ -37,9 +44,24 @@ var (
 	pathRegisterValidator = "/eth/v1/builder/validators"
 	pathGetHeader         = "/eth/v1/builder/header/{slot:[0-9]+}/{parent_hash:0x[a-fA-F0-9]+}/{pubkey:0x[a-fA-F0-9]+}"
 	pathGetPayload        = "/eth/v1/builder/blinded_blocks"
+	pathViolations        = "/mergemock/v1/violations"
+	pathRegistrations     = "/mergemock/v1/validators"
+	pathRegistration      = "/mergemock/v1/validators/{pubkey:0x[a-fA-F0-9]+}"
+
+	// maxRegistrationSkew is how far in the future the timestamps of registrations may be, per the builder spec.
+	maxRegistrationSkew = 10 * time.Second
+
+	// servedBidsCacheSize is how many of the latest bids the relay can unblind the payload of.
+	servedBidsCacheSize = 64
+
+	contentTypeJSON = "application/json"
+	contentTypeSSZ  = "application/octet-stream"
 )
 
 type RelayCmd struct {
+	// config file options
+	ConfigPath string `ask:"--config" help:"YAML file of flags, with groups of flags nested, loaded before the flags of the command line override it (none if empty)"`
+
 	// connectivity options
 	ListenAddr         string `ask:"--listen-addr" help:"Address to bind relay HTTP server to"`
 	EngineListenAddr   string `ask:"--engine-listen-addr" help:"Address to bind engine JSON-RPC server to"`
@@ -47,16 +69,32 @@ type RelayCmd struct {
 
 	// embed timeout and logger options
 	Timeout rpc.Timeout `ask:".timeout" help:"Configure timeouts of the HTTP servers"`
+	Wire    rpc.WireLog `ask:".wire" help:"Log the HTTP traffic of the builder API and the engine API"`
 	LogCmd  `ask:".log" help:"Change logger configuration"`
 
 	GenesisValidatorsRoot string `ask:"--genesis-validators-root" help:"Root of genesis validators"`
+	GenesisForkVersion    string `ask:"--genesis-fork-version" help:"Genesis fork version of the network, of the builder domain registrations and bids are signed over, e.g. 0x90000069 for sepolia"`
+
+	SecretKey string       `ask:"--secret-key" help:"The relay's secret key used to sign payloads"`
+	Signer    SignerConfig `ask:".signer" help:"Sign bids with a remote signer instead of the secret key"`
 
-	SecretKey string `ask:"--secret-key" help:"The relay's secret key used to sign payloads"`
+	Preset string           `ask:"--preset" help:"Named combination of faults: byzantine-relay for the relay, flaky-el or slow-el for its engine, empty for none"`
+	Faults RelayFaultConfig `ask:".fault" help:"Make the relay misbehave towards the proposer"`
+	Bid    BidConfig        `ask:".bid" help:"Choose the value of the bids of the relay"`
+	Seed   int64            `ask:"--seed" help:"Seed the relay faults, bids and the randomized behavior of the engine without a seed of their own from this one, to reproduce a run (0 for random seeds)"`
+	Censor []string         `ask:"--censor" help:"Addresses whose transactions are left out of the payloads of the relay, to simulate a censoring builder"`
 
-	close chan struct{}
-	log   *logrus.Logger
-	ctx   context.Context
-	srv   *http.Server
+	SSZ bool `ask:"--ssz" help:"Accept SSZ request bodies and answer requests preferring application/octet-stream in SSZ, like newer relays (if disabled, SSZ requests get 415 and responses are JSON)"`
+
+	RegistrationsDump string `ask:"--registrations-dump" help:"File to append each accepted validator registration to, as a line of JSON (none if empty)"`
+
+	EconomicsReport string `ask:"--economics-report" help:"File to write per-slot bid and payload statistics to on shutdown, as CSV if it ends in .csv and JSON otherwise"`
+
+	close   chan struct{}
+	log     *logrus.Logger
+	ctx     context.Context
+	srv     *http.Server
+	backend *RelayBackend
 }
 
 func (r *RelayCmd) Default() {
@@ -65,12 +103,15 @@ func (r *RelayCmd) Default() {
 	r.EngineListenAddrWs = "127.0.0.1:8552"
 
 	r.GenesisValidatorsRoot = "0x0000000000000000000000000000000000000000000000000000000000000000"
+	r.GenesisForkVersion = "0x00000000"
 
 	r.Timeout.Read = 30 * time.Second
 	r.Timeout.ReadHeader = 10 * time.Second
 	r.Timeout.Write = 30 * time.Second
 	r.Timeout.Idle = 5 * time.Minute
 
+	r.SSZ = true
+
 	sk, _ := bls.RandKey()
 	r.SecretKey = hex.EncodeToString(sk.Marshal())
 }
@@ -90,9 +131,58 @@ func (r *RelayCmd) Run(ctx context.Context, args ...string) error {
 	if err != nil {
 		r.log.WithField("err", err).Fatal("Unable to initialize backend")
 	}
+	if r.Preset != "" {
+		preset, err := LookupPreset(r.Preset)
+		if err != nil {
+			r.log.WithField("err", err).Fatal("Unable to apply preset")
+		}
+		if preset.Relay {
+			preset.ApplyRelay(&r.Faults)
+		} else {
+			preset.ApplyEngine(backend.engine)
+		}
+		r.log.WithField("preset", r.Preset).Info("Applied preset")
+	}
+	remote, err := r.Signer.NewRemoteSigner(ctx)
+	if err != nil {
+		r.log.WithField("err", err).Fatal("Unable to configure remote signer")
+	}
+	if remote != nil {
+		backend.signer = remote
+		backend.pk = remote.PublicKey()
+		r.log.WithFields(logrus.Fields{"url": r.Signer.URL, "pubkey": backend.pk}).Info("Signing bids with remote signer")
+	}
+	seeds := NewSeeds(r.Seed)
+	r.Faults.Seed = seeds.Derive(r.Faults.Seed)
+	r.Bid.Seed = seeds.Derive(r.Bid.Seed)
+	backend.engine.Seed = seeds.Derive(backend.engine.Seed)
+	backend.faults = r.Faults.NewRelayFaults()
+	backend.ssz = r.SSZ
+	var forkVersion types.ForkVersion
+	if err := forkVersion.UnmarshalText([]byte(r.GenesisForkVersion)); err != nil {
+		r.log.WithField("err", err).Fatal("Unable to parse genesis fork version")
+	}
+	backend.builderDomain = types.ComputeBuilderDomain(forkVersion)
+	if r.RegistrationsDump != "" {
+		if backend.registrationsDump, err = os.OpenFile(r.RegistrationsDump, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			r.log.WithField("err", err).Fatal("Unable to open registrations dump")
+		}
+	}
+	// the engine shares the wire log with the builder API
+	if err := r.Wire.Open(); err != nil {
+		r.log.WithField("err", err).Fatal("Unable to log HTTP traffic")
+	}
+	backend.engine.Wire = r.Wire
+	if backend.bids, err = r.Bid.NewBidder(); err != nil {
+		r.log.WithField("err", err).Fatal("Unable to configure bids")
+	}
+	if backend.engine.censored, err = parseCensored(r.Censor); err != nil {
+		r.log.WithField("err", err).Fatal("Unable to configure censorship")
+	}
 	if err := backend.engine.Run(ctx); err != nil {
 		r.log.WithField("err", err).Fatal("Unable to initialize engine")
 	}
+	r.backend = backend
 	go r.startRESTApi(ctx, backend)
 	return nil
 }
@@ -101,6 +191,18 @@ func (r *RelayCmd) Close() error {
 	if r.close != nil {
 		r.close <- struct{}{}
 	}
+	if r.backend != nil && r.backend.registrationsDump != nil {
+		if err := r.backend.registrationsDump.Close(); err != nil {
+			return fmt.Errorf("failed to close registrations dump: %v", err)
+		}
+	}
+	if r.EconomicsReport != "" && r.backend != nil {
+		report := r.backend.economics.Report(r.backend.engine.mockChain().chain)
+		if err := WriteEconomicsReport(r.EconomicsReport, report); err != nil {
+			return fmt.Errorf("failed to write economics report: %v", err)
+		}
+		r.log.WithField("path", r.EconomicsReport).WithField("slots", len(report)).Info("Wrote economics report")
+	}
 	return nil
 }
 
@@ -116,7 +218,7 @@ func (r *RelayCmd) initLogger(ctx context.Context) error {
 func (r *RelayCmd) startRESTApi(ctx context.Context, backend *RelayBackend) {
 	r.srv = &http.Server{
 		Addr:    r.ListenAddr,
-		Handler: backend.getRouter(),
+		Handler: r.Wire.Handler(backend.getRouter(), r.log),
 
 		ReadTimeout:       r.Timeout.Read,
 		ReadHeaderTimeout: r.Timeout.ReadHeader,
@@ -128,6 +230,9 @@ func (r *RelayCmd) startRESTApi(ctx context.Context, backend *RelayBackend) {
 	go r.srv.ListenAndServe()
 	for range r.close {
 		r.srv.Close()
+		for _, v := range backend.proposals.Violations() {
+			r.log.WithField("violation", v.String()).Warn("Conflicting proposal was observed during the run")
+		}
 		return
 	}
 }
@@ -136,18 +241,34 @@ type RelayBackend struct {
 	log    *logrus.Logger
 	engine *EngineCmd
 	pk     types.PublicKey
-	sk     bls.SecretKey
+	signer BidSigner
 
 	genesisValidatorsRoot types.Root
-	registrations         map[types.PublicKey]*types.RegisterValidatorRequestMessage
+	builderDomain         types.Domain
+	registrations         *ValidatorRegistry
+	registrationsDump     io.WriteCloser
+
+	served    *lru.Cache // block hash of the served header -> *servedBid, for getPayload to unblind
+	proposals *ProposalTracker
+	economics *EconomicsRecorder
+	faults    *RelayFaults
+	bids      *Bidder
+	ssz       bool
+}
 
-	latestPubkey types.PublicKey // cache for pubkey from latest getHeader call
+// servedBid is a bid served to a proposer, with the payload its header was blinded from.
+type servedBid struct {
+	slot     uint64
+	proposer types.PublicKey
+	header   *types.ExecutionPayloadHeader
+	payload  *types.ExecutionPayloadV1
 }
 
 func NewRelayBackend(log *logrus.Logger, engineListenAddr, engineListenAddrWs, genesisValidatorsRoot, secretKey string) (*RelayBackend, error) {
 	engine := &EngineCmd{}
 	engine.Default()
 	engine.LogCmd.Default()
+	engine.GasPriceOracle.Default()
 	engine.ListenAddr = engineListenAddr
 	engine.WebsocketAddr = engineListenAddrWs
 
@@ -159,18 +280,32 @@ func NewRelayBackend(log *logrus.Logger, engineListenAddr, engineListenAddrWs, g
 	if err != nil {
 		return nil, err
 	}
-	var pk types.PublicKey
-	copy(pk[:], sk.PublicKey().Marshal())
+	signer := NewLocalSigner(sk)
 
-	registrations := make(map[types.PublicKey]*types.RegisterValidatorRequestMessage)
+	var bidCfg BidConfig
+	bidCfg.Default()
+	bids, err := bidCfg.NewBidder()
+	if err != nil {
+		return nil, err
+	}
+	served, err := lru.New(servedBidsCacheSize)
+	if err != nil {
+		return nil, err
+	}
 
 	return &RelayBackend{
 		log:                   log,
 		engine:                engine,
-		pk:                    pk,
-		sk:                    sk,
+		pk:                    signer.PublicKey(),
+		signer:                signer,
 		genesisValidatorsRoot: types.Root(common.HexToHash(genesisValidatorsRoot)),
-		registrations:         registrations,
+		builderDomain:         types.DomainBuilder,
+		registrations:         NewValidatorRegistry(),
+		served:                served,
+		proposals:             NewProposalTracker(),
+		economics:             NewEconomicsRecorder(),
+		bids:                  bids,
+		ssz:                   true,
 	}, nil
 }
 
@@ -182,12 +317,76 @@ func (r *RelayBackend) getRouter() http.Handler {
 	router.HandleFunc(pathRegisterValidator, r.handleRegisterValidator).Methods(http.MethodPost)
 	router.HandleFunc(pathGetHeader, r.handleGetHeader).Methods(http.MethodGet)
 	router.HandleFunc(pathGetPayload, r.handleGetPayload).Methods(http.MethodPost)
+	router.HandleFunc(pathViolations, r.handleViolations).Methods(http.MethodGet)
+	router.HandleFunc(pathRegistrations, r.handleRegistrations).Methods(http.MethodGet)
+	router.HandleFunc(pathRegistration, r.handleRegistration).Methods(http.MethodGet)
 
 	// Add logging and return router
 	loggedRouter := LoggingMiddleware(router, r.log)
 	return loggedRouter
 }
 
+// decodeSSZ reports whether the request body is SSZ, answering 415 to SSZ requests the relay doesn't accept.
+func (r *RelayBackend) decodeSSZ(w http.ResponseWriter, req *http.Request) (isSSZ bool, ok bool) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != contentTypeSSZ {
+		return false, true
+	}
+	if !r.ssz {
+		http.Error(w, "SSZ request bodies are not accepted", http.StatusUnsupportedMediaType)
+		return true, false
+	}
+	return true, true
+}
+
+// respond writes the response as SSZ, with the fork in the Eth-Consensus-Version header, if the request prefers it,
+// and as JSON otherwise.
+func (r *RelayBackend) respond(w http.ResponseWriter, req *http.Request, version string, response interface{}, data ssz.Marshaler) error {
+	if r.ssz && prefersSSZ(req.Header.Get("Accept")) {
+		b, err := data.MarshalSSZ()
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", contentTypeSSZ)
+		w.Header().Set("Eth-Consensus-Version", version)
+		_, err = w.Write(b)
+		return err
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// prefersSSZ reports whether an Accept header ranks application/octet-stream above application/json, the first
+// listed winning ties.
+func prefersSSZ(accept string) bool {
+	sszQ, jsonQ := -1.0, -1.0
+	sszFirst := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case contentTypeSSZ:
+			if sszQ < 0 {
+				sszQ = q
+				sszFirst = jsonQ < 0
+			}
+		case contentTypeJSON, "*/*", "application/*":
+			if jsonQ < 0 {
+				jsonQ = q
+			}
+		}
+	}
+	return sszQ > 0 && (sszQ > jsonQ || sszQ == jsonQ && sszFirst)
+}
+
 func (r *RelayBackend) handleStatus(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -196,34 +395,53 @@ func (r *RelayBackend) handleStatus(w http.ResponseWriter, req *http.Request) {
 
 func (r *RelayBackend) handleRegisterValidator(w http.ResponseWriter, req *http.Request) {
 	payload := make([]types.SignedValidatorRegistration, 0)
-	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+	isSSZ, ok := r.decodeSSZ(w, req)
+	if !ok {
+		return
+	}
+	if isSSZ {
+		body, err := io.ReadAll(req.Body)
+		if err == nil {
+			payload, err = types.UnmarshalRegistrationsSSZ(body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	for _, reg := range payload {
-		if len(reg.Message.Pubkey) != 48 {
-			http.Error(w, errInvalidPubkey.Error(), http.StatusBadRequest)
+		if reg.Message == nil {
+			http.Error(w, "missing registration message", http.StatusBadRequest)
 			return
 		}
-		if len(reg.Signature) != 96 {
-			http.Error(w, errInvalidSignature.Error(), http.StatusBadRequest)
+		rlog := r.log.WithField("pubkey", reg.Message.Pubkey.String())
+		if _, err := bls.PublicKeyFromBytes(reg.Message.Pubkey[:]); err != nil {
+			rlog.WithError(err).Warn("Registration of invalid pubkey")
+			http.Error(w, errInvalidPubkey.Error(), http.StatusBadRequest)
 			return
 		}
-		ok, err := types.VerifySignature(reg.Message, types.DomainBuilder, reg.Message.Pubkey[:], reg.Signature[:])
+		ok, err := types.VerifySignature(reg.Message, r.builderDomain, reg.Message.Pubkey[:], reg.Signature[:])
 		if !ok || err != nil {
-			r.log.WithError(err).Error("error verifying signature")
+			rlog.WithError(err).WithField("domain", hexutil.Encode(r.builderDomain[:])).Error("error verifying signature")
 			http.Error(w, errInvalidSignature.Error(), http.StatusBadRequest)
 			return
 		}
-		if prefs, ok := r.registrations[reg.Message.Pubkey]; ok {
-			if prefs.Timestamp <= reg.Message.Timestamp {
-				http.Error(w, errInvalidTimestamp.Error(), http.StatusBadRequest)
-				return
-			}
+		if reg.Message.Timestamp > uint64(time.Now().Add(maxRegistrationSkew).Unix()) {
+			http.Error(w, errInvalidTimestamp.Error(), http.StatusBadRequest)
+			return
 		}
 		// Note, successful registrations are not reverted if an error
 		// is encountered on a later validator.
-		r.registrations[reg.Message.Pubkey] = reg.Message
+		if err := r.registrations.Register(*reg.Message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.dumpRegistration(reg); err != nil {
+			rlog.WithError(err).Warn("Cannot dump registration")
+		}
 	}
 	r.log.Info(fmt.Sprintf("registered %d validator(s) successfully\n", len(payload)))
 	w.Header().Set("Content-Type", "application/json")
@@ -231,6 +449,14 @@ func (r *RelayBackend) handleRegisterValidator(w http.ResponseWriter, req *http.
 	fmt.Fprintf(w, `{}`)
 }
 
+// dumpRegistration appends an accepted registration to the registrations dump, if any.
+func (r *RelayBackend) dumpRegistration(reg types.SignedValidatorRegistration) error {
+	if r.registrationsDump == nil {
+		return nil
+	}
+	return json.NewEncoder(r.registrationsDump).Encode(reg)
+}
+
 func (r *RelayBackend) handleGetHeader(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	slot := vars["slot"]
@@ -243,7 +469,8 @@ func (r *RelayBackend) handleGetHeader(w http.ResponseWriter, req *http.Request)
 	})
 	plog.Info("getHeader")
 
-	if _, err := strconv.ParseInt(slot, 10, 64); err != nil {
+	slotNum, err := strconv.ParseUint(slot, 10, 64)
+	if err != nil {
 		http.Error(w, errInvalidSlot.Error(), http.StatusBadRequest)
 		return
 	}
@@ -258,87 +485,244 @@ func (r *RelayBackend) handleGetHeader(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	payload, ok := r.engine.backend.recentPayloads.Get(common.HexToHash(parentHashHex))
+	payload, ok := r.engine.backend.parentPayloads.Get(common.HexToHash(parentHashHex))
 	if !ok {
-		plog.Warn("Cannot get unknown payload")
-		http.Error(w, "Cannot get unknown payload", http.StatusBadRequest)
+		// As per builder spec, no bid is no content rather than an error.
+		plog.Warn("No payload prepared on parent, no bid")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var proposer types.PublicKey
+	if err := proposer.UnmarshalText([]byte(pubkey)); err != nil {
+		http.Error(w, errInvalidPubkey.Error(), http.StatusBadRequest)
 		return
 	}
+	execPayload := payload.(*types.ExecutionPayloadV1)
+	if reg, ok := r.registrations.Get(proposer); ok {
+		if execPayload, err = r.engine.backend.buildForProposer(execPayload, common.Address(reg.FeeRecipient), reg.GasLimit); err != nil {
+			plog.WithError(err).Warn("Cannot build payload for registration of proposer")
+			http.Error(w, "cannot build payload", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	payloadHeader, err := types.PayloadToPayloadHeader(payload.(*types.ExecutionPayloadV1))
+	payloadHeader, err := types.PayloadToPayloadHeader(execPayload)
 	if err != nil {
 		plog.Warn("Cannot convert payload to header")
 		http.Error(w, "cannot convert payload to header", http.StatusBadRequest)
 		return
 	}
 
-	plog.Info("Consensus client retrieved prepared payload header")
+	value, ok := r.bids.Bid(slotNum)
+	if !ok {
+		plog.Warn("Not bidding on slot")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	plog.WithField("value", value).Info("Consensus client retrieved prepared payload header")
 
 	bid := types.BuilderBid{
 		Header: payloadHeader,
-		Value:  [32]byte{0x1},
+		Value:  *new(types.U256Str).FromBig(value),
 		Pubkey: r.pk,
 	}
-	msg, err := types.ComputeSigningRoot(&bid, types.DomainBuilder)
+	corrupt := r.faults.Corrupt()
+	if corrupt.StaleTimestamp {
+		if payloadHeader.Timestamp > staleBidAge {
+			payloadHeader.Timestamp -= staleBidAge
+		} else {
+			payloadHeader.Timestamp = 0
+		}
+	}
+	if corrupt.MismatchedHash {
+		payloadHeader.BlockHash[0] ^= 0xff
+	}
+	if corrupt.WrongPubkey {
+		bid.Pubkey[len(bid.Pubkey)-1] ^= 0xff
+	}
+	domain := r.builderDomain
+	if corrupt.WrongDomain {
+		// The builder domain of a relay configured for another network.
+		domain = types.ComputeBuilderDomain(types.ForkVersion{0xff, 0xff, 0xff, 0xff})
+	}
+	msg, err := types.ComputeSigningRoot(&bid, domain)
 	if err != nil {
 		plog.Warn("cannot compute signing root")
 		http.Error(w, "cannot compute signing root", http.StatusBadRequest)
 		return
 	}
-	var sig types.Signature
-	tmp := r.sk.Sign(msg[:])
-	copy(sig[:], tmp.Marshal())
+	if corrupt.InvalidSignature {
+		// A well-formed signature, over another message.
+		msg[0] ^= 0xff
+	}
+	if corrupt.Any() {
+		plog.WithFields(logrus.Fields{
+			"invalidSignature": corrupt.InvalidSignature,
+			"wrongPubkey":      corrupt.WrongPubkey,
+			"wrongDomain":      corrupt.WrongDomain,
+			"staleTimestamp":   corrupt.StaleTimestamp,
+			"mismatchedHash":   corrupt.MismatchedHash,
+		}).Warn("Serving malformed bid")
+	}
+	sig, err := r.signer.Sign(req.Context(), msg)
+	if err != nil {
+		plog.WithError(err).Error("Cannot sign bid")
+		http.Error(w, "cannot sign bid", http.StatusInternalServerError)
+		return
+	}
 	response := &types.GetHeaderResponse{
 		Version: "bellatrix",
 		Data:    &types.SignedBuilderBid{Message: &bid, Signature: sig},
 	}
 
-	if err = r.latestPubkey.UnmarshalText([]byte(pubkey)); err != nil {
-		plog.Warn("Cannot unmarshal pubkey")
-		http.Error(w, "cannot unmarshal pubkey", http.StatusBadRequest)
+	// The header as served, malformed or not, is what the proposer signs if it accepts the bid.
+	r.served.Add(common.Hash(payloadHeader.BlockHash), &servedBid{slot: slotNum, proposer: proposer, header: payloadHeader, payload: execPayload})
+
+	if delay := r.faults.LateBid(); delay > 0 {
+		plog.WithField("delay", delay).Warn("Holding bid back")
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if err := r.respond(w, req, response.Version, response, response.Data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	r.economics.BidServed(slotNum, bid.Value)
+}
 
+func (r *RelayBackend) handleViolations(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(r.proposals.Violations()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRegistrations serves the latest registrations of all validators, ordered by pubkey.
+func (r *RelayBackend) handleRegistrations(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.registrations.All()); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 }
 
+// handleRegistration serves the latest registration of a validator, 404 if it never registered.
+func (r *RelayBackend) handleRegistration(w http.ResponseWriter, req *http.Request) {
+	var pubkey types.PublicKey
+	if err := pubkey.UnmarshalText([]byte(mux.Vars(req)["pubkey"])); err != nil {
+		http.Error(w, errInvalidPubkey.Error(), http.StatusBadRequest)
+		return
+	}
+	reg, ok := r.registrations.Get(pubkey)
+	if !ok {
+		http.Error(w, "validator not registered", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetPayload unblinds a signed blinded block: it answers the signed header of a bid served before with the
+// payload the header was blinded from, unless the relay faults withhold, delay or swap it.
 func (r *RelayBackend) handleGetPayload(w http.ResponseWriter, req *http.Request) {
 	plog := r.log.WithField("method", "getPayload")
 
 	payload := new(types.SignedBlindedBeaconBlock)
-	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+	isSSZ, ok := r.decodeSSZ(w, req)
+	if !ok {
+		return
+	}
+	if isSSZ {
+		body, err := io.ReadAll(req.Body)
+		if err == nil {
+			err = payload.UnmarshalSSZ(body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if payload.Message == nil || payload.Message.Body == nil || payload.Message.Body.ExecutionPayloadHeader == nil {
+		http.Error(w, "missing execution payload header", http.StatusBadRequest)
+		return
+	}
 	if len(payload.Signature) != 96 {
 		http.Error(w, errInvalidSignature.Error(), http.StatusBadRequest)
 		return
 	}
 
-	domain := types.ComputeDomain(types.DomainTypeBeaconProposer, version.Bellatrix, &r.genesisValidatorsRoot)
-	ok, err := types.VerifySignature(payload.Message, domain, r.latestPubkey[:], payload.Signature[:])
+	header := payload.Message.Body.ExecutionPayloadHeader
+	plog = plog.WithFields(logrus.Fields{"slot": payload.Message.Slot, "blockHash": header.BlockHash.String()})
+	cached, ok := r.served.Get(common.Hash(header.BlockHash))
+	if !ok {
+		plog.Warn("Signed blinded block of a header the relay didn't serve")
+		http.Error(w, "unknown payload header", http.StatusBadRequest)
+		return
+	}
+	bid := cached.(*servedBid)
+	signedRoot, err := header.HashTreeRoot()
+	if err == nil {
+		var servedRoot [32]byte
+		if servedRoot, err = bid.header.HashTreeRoot(); err == nil && signedRoot != servedRoot {
+			err = errors.New("signed header differs from the header of the bid")
+		}
+	}
+	if err != nil {
+		plog.WithError(err).Warn("Cannot unblind signed blinded block")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	domain := types.ComputeDomain(types.DomainTypeBeaconProposer, types.ForkVersion{version.Bellatrix}, &r.genesisValidatorsRoot)
+	ok, err = types.VerifySignature(payload.Message, domain, bid.proposer[:], payload.Signature[:])
 	if !ok || err != nil {
-		plog.WithError(err).Error("error verifying signature")
+		plog.WithError(err).WithField("pubkey", bid.proposer.String()).Error("error verifying signature")
 		http.Error(w, errInvalidSignature.Error(), http.StatusBadRequest)
 		return
 	}
 
-	parentHashHex := payload.Message.Body.ExecutionPayloadHeader.ParentHash.String()
-	_execPayloadEL, ok := r.engine.backend.recentPayloads.Get(common.HexToHash(parentHashHex))
-	if !ok {
-		plog.Warn("Cannot get unknown payload")
-		http.Error(w, "Cannot get unknown payload", http.StatusBadRequest)
+	if root, err := payload.Message.HashTreeRoot(); err != nil {
+		plog.WithError(err).Warn("Cannot compute block root")
+	} else if v := r.proposals.Observe(payload.Message.Slot, payload.Message.ProposerIndex, types.Root(root)); v != nil {
+		plog.WithFields(logrus.Fields{
+			"slot":          v.Slot,
+			"proposerIndex": v.ProposerIndex,
+			"firstRoot":     v.First.String(),
+			"secondRoot":    v.Second.String(),
+		}).Error("Conflicting proposal observed")
+	}
+
+	if r.faults.Withhold() {
+		plog.Warn("Withholding payload of signed blinded block")
+		http.Error(w, "payload withheld", http.StatusInternalServerError)
 		return
 	}
-	plog.Info(_execPayloadEL)
 
-	execPayload, err := types.ELPayloadToRESTPayload(_execPayloadEL.(*types.ExecutionPayloadV1))
+	execPayload := bid.payload
+	if r.faults.Equivocate() {
+		if execPayload, err = r.engine.backend.buildEquivocation(bid.payload); err != nil {
+			plog.WithError(err).Error("Cannot build equivocating payload")
+			http.Error(w, "cannot build payload", http.StatusInternalServerError)
+			return
+		}
+		plog.WithField("payloadHash", execPayload.BlockHash).Warn("Equivocating with another payload than the one of the signed header")
+	}
+
+	restPayload, err := types.ELPayloadToRESTPayload(execPayload)
 	if err != nil {
 		plog.Warn("Cannot convert payload to payloadREST")
 		http.Error(w, "cannot convert payload to payloadREST", http.StatusBadRequest)
@@ -347,12 +731,21 @@ func (r *RelayBackend) handleGetPayload(w http.ResponseWriter, req *http.Request
 
 	response := types.GetPayloadResponse{
 		Version: "bellatrix",
-		Data:    execPayload,
+		Data:    restPayload,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if delay := r.faults.LatePayload(); delay > 0 {
+		plog.WithField("delay", delay).Warn("Holding payload back")
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if err := r.respond(w, req, response.Version, response, response.Data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	r.economics.PayloadDelivered(payload.Message.Slot, execPayload)
 }
@@ -1,4 +1,4 @@
-package main
+package mergemock
 
 import (
 	"bytes"
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
@@ -34,7 +35,7 @@ func newTestRelay(t *testing.T) *testRelayBackend {
 	sk, err := bls.RandKey()
 	require.NoError(t, err)
 
-	relay, err := NewRelayBackend(logrus.New(), "127.0.0.1:38551", "127.0.0.1:38552", "0x1234000000000000000000000000000000000000000000000000000000000000", hex.EncodeToString(sk.Marshal()))
+	relay, err := NewRelayBackend(logrus.New(), "127.0.0.1:0", "127.0.0.1:0", "0x1234000000000000000000000000000000000000000000000000000000000000", hex.EncodeToString(sk.Marshal()))
 	if err != nil {
 		t.Fatal("unable to create relay")
 	}
@@ -178,6 +179,72 @@ func TestValidatorRegistration(t *testing.T) {
 	require.Equal(t, errInvalidTimestamp.Error()+"\n", rr.Body.String())
 }
 
+func TestValidatorRegistrationDomain(t *testing.T) {
+	relay := newTestRelay(t)
+	sepolia := types.ComputeBuilderDomain(types.ForkVersion{0x90, 0x00, 0x00, 0x69})
+	relay.builderDomain = sepolia
+	dumpPath := fmt.Sprintf("%s/registrations.jsonl", t.TempDir())
+	dump, err := os.Create(dumpPath)
+	require.NoError(t, err)
+	relay.registrationsDump = dump
+	pk, sk := newKeypair(t)
+	var pubkey types.PublicKey
+	pubkey.FromSlice(pk)
+
+	register := func(msg *types.RegisterValidatorRequestMessage, domain types.Domain) *httptest.ResponseRecorder {
+		root, err := types.ComputeSigningRoot(msg, domain)
+		require.NoError(t, err)
+		var sig types.Signature
+		sig.FromSlice(sk.Sign(root[:]).Marshal())
+		return relay.testRequest(t, "POST", "/eth/v1/builder/validators", []types.SignedValidatorRegistration{{Message: msg, Signature: sig}})
+	}
+	now := uint64(time.Now().Unix())
+	msg := &types.RegisterValidatorRequestMessage{FeeRecipient: types.Address{0x42}, GasLimit: 30_000_000, Timestamp: now, Pubkey: pubkey}
+
+	rr := register(msg, types.DomainBuilder)
+	require.Equal(t, http.StatusBadRequest, rr.Code, "signed over the mainnet domain")
+	require.Equal(t, errInvalidSignature.Error()+"\n", rr.Body.String())
+	rr = register(msg, sepolia)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// A later registration replaces the earlier one
+	newer := *msg
+	newer.Timestamp = now + 1
+	newer.GasLimit = 36_000_000
+	rr = register(&newer, sepolia)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	registered, ok := relay.registrations.Get(pubkey)
+	require.True(t, ok)
+	require.Equal(t, uint64(36_000_000), registered.GasLimit)
+
+	future := newer
+	future.Timestamp = now + 60
+	rr = register(&future, sepolia)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, errInvalidTimestamp.Error()+"\n", rr.Body.String())
+
+	invalid := newer
+	invalid.Pubkey = types.PublicKey{0x01}
+	rr = register(&invalid, sepolia)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, errInvalidPubkey.Error()+"\n", rr.Body.String())
+
+	require.NoError(t, dump.Close())
+	lines, err := os.ReadFile(dumpPath)
+	require.NoError(t, err)
+	var dumped []types.SignedValidatorRegistration
+	for _, line := range bytes.Split(bytes.TrimSpace(lines), []byte("\n")) {
+		var reg types.SignedValidatorRegistration
+		require.NoError(t, json.Unmarshal(line, &reg))
+		dumped = append(dumped, reg)
+	}
+	require.Len(t, dumped, 2, "only accepted registrations are dumped")
+	require.Equal(t, newer, *dumped[1].Message)
+	ok, err = types.VerifySignature(dumped[1].Message, sepolia, pk, dumped[1].Signature[:])
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
 func TestGetHeader(t *testing.T) {
 	ctx := context.Background()
 	relay := newTestRelay(t)
@@ -202,8 +269,13 @@ func TestGetHeader(t *testing.T) {
 	)
 	require.NoError(t, err, "unable to initialize engine")
 
-	path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 0, parentHash.Hex(), pk)
+	path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 0, common.Hash{0xff}.Hex(), pk)
 	rr := relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, "no bid without payload on the parent")
+	require.Empty(t, rr.Body.String())
+
+	path = fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 0, parentHash.Hex(), pk)
+	rr = relay.testRequest(t, "GET", path, nil)
 	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
 
 	bid := new(types.GetHeaderResponse)
@@ -215,7 +287,180 @@ func TestGetHeader(t *testing.T) {
 	require.NoError(t, err, "error verifying signature")
 	require.True(t, ok, "bid signature not valid")
 
-	require.Equal(t, pk, relay.latestPubkey[:])
+	served, ok := relay.served.Get(common.Hash(bid.Data.Message.Header.BlockHash))
+	require.True(t, ok, "the bid is remembered to unblind its payload")
+	require.Equal(t, pk, served.(*servedBid).proposer[:])
+	require.Equal(t, uint64(1), bid.Data.Message.Value.ToBig().Uint64(), "default bid value")
+
+	relay.bids, err = (&BidConfig{Strategy: BidFixed, NoBidProbability: 1}).NewBidder()
+	require.NoError(t, err)
+	rr = relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, "no bid of the bid strategy")
+}
+
+func TestRegistrationsHonoredInBids(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	relay.engine.Run(ctx)
+	pk, sk := newKeypair(t)
+	var pubkey types.PublicKey
+	pubkey.FromSlice(pk)
+	parent := relay.engine.mockChain().CurrentHeader()
+	parentHash := parent.Hash()
+
+	path := fmt.Sprintf("/mergemock/v1/validators/0x%x", pk)
+	rr := relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusNotFound, rr.Code, "not registered yet")
+
+	msg := &types.RegisterValidatorRequestMessage{FeeRecipient: types.Address{0x42}, GasLimit: 36_000_000, Timestamp: uint64(time.Now().Unix()), Pubkey: pubkey}
+	root, err := types.ComputeSigningRoot(msg, types.DomainBuilder)
+	require.NoError(t, err)
+	var sig types.Signature
+	sig.FromSlice(sk.Sign(root[:]).Marshal())
+	rr = relay.testRequest(t, "POST", "/eth/v1/builder/validators", []types.SignedValidatorRegistration{{Message: msg, Signature: sig}})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var registered types.RegisterValidatorRequestMessage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &registered))
+	require.Equal(t, *msg, registered)
+	rr = relay.testRequest(t, "GET", "/mergemock/v1/validators", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var all []types.RegisterValidatorRequestMessage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &all))
+	require.Equal(t, []types.RegisterValidatorRequestMessage{*msg}, all)
+
+	_, err = relay.engine.backend.ForkchoiceUpdatedV1(ctx,
+		&types.ForkchoiceStateV1{HeadBlockHash: parentHash, SafeBlockHash: parentHash, FinalizedBlockHash: parentHash},
+		&types.PayloadAttributesV1{Timestamp: parent.Time + 1, PrevRandao: common.Hash{0x01}, SuggestedFeeRecipient: common.Address{0x02}},
+	)
+	require.NoError(t, err)
+
+	rr = relay.testRequest(t, "GET", fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 1, parentHash.Hex(), pk), nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	bid := new(types.GetHeaderResponse)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), bid))
+	header := bid.Data.Message.Header
+	require.Equal(t, msg.FeeRecipient, header.FeeRecipient, "pays the registered fee recipient")
+	require.Equal(t, core.CalcGasLimit(parent.GasLimit, msg.GasLimit), header.GasLimit, "moves toward the registered gas limit")
+	require.Greater(t, header.GasLimit, parent.GasLimit)
+
+	payload, ok := relay.engine.backend.parentPayloads.Get(parentHash)
+	require.True(t, ok)
+	require.Equal(t, header.BlockHash[:], payload.(*types.ExecutionPayloadV1).BlockHash[:], "getPayload serves the payload of the bid")
+}
+
+func TestGetHeaderRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+	pk, sk := newKeypair(t)
+	var signed int
+	signer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/eth2/publicKeys":
+			json.NewEncoder(w).Encode([]string{hexutil.Encode(pk)})
+		case "/api/v1/eth2/sign/" + hexutil.Encode(pk):
+			var body web3SignerRequest
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			require.Equal(t, web3SignerBidType, body.Type)
+			signed++
+			// Web3Signer answers with a bare hex signature, unless JSON is accepted.
+			fmt.Fprint(w, hexutil.Encode(sk.Sign(body.SigningRoot).Marshal()))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer signer.Close()
+
+	relay := newTestRelay(t)
+	relay.engine.Run(ctx)
+	remote, err := (&SignerConfig{URL: signer.URL, Timeout: time.Second}).NewRemoteSigner(ctx)
+	require.NoError(t, err)
+	announced := remote.PublicKey()
+	require.Equal(t, pk, announced[:])
+	relay.signer, relay.pk = remote, remote.PublicKey()
+
+	parent := relay.engine.mockChain().CurrentHeader()
+	_, err = relay.engine.backend.ForkchoiceUpdatedV1(ctx, &types.ForkchoiceStateV1{HeadBlockHash: parent.Hash()},
+		&types.PayloadAttributesV1{Timestamp: parent.Time + 1, SuggestedFeeRecipient: common.Address{0x02}})
+	require.NoError(t, err)
+	path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 0, parent.Hash().Hex(), pk)
+	rr := relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	bid := new(types.GetHeaderResponse)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), bid))
+	require.Equal(t, relay.pk, bid.Data.Message.Pubkey)
+	ok, err := types.VerifySignature(bid.Data.Message, types.DomainBuilder, pk, bid.Data.Signature[:])
+	require.NoError(t, err)
+	require.True(t, ok, "bid signature not valid")
+	require.Equal(t, 1, signed)
+
+	// A failing signer fails the call, instead of serving an unsigned bid.
+	signer.Close()
+	rr = relay.testRequest(t, "GET", path, nil)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestGetHeaderMalformedBids(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	relay.engine.Run(ctx)
+	pk, _ := newKeypair(t)
+	parent := relay.engine.mockChain().CurrentHeader()
+	parentHash := parent.Hash()
+
+	_, err := relay.engine.backend.ForkchoiceUpdatedV1(
+		ctx,
+		&types.ForkchoiceStateV1{
+			HeadBlockHash:      parentHash,
+			SafeBlockHash:      parentHash,
+			FinalizedBlockHash: parentHash,
+		},
+		&types.PayloadAttributesV1{
+			Timestamp:             parent.Time + 100,
+			PrevRandao:            common.Hash{0x01},
+			SuggestedFeeRecipient: common.Address{0x02},
+		},
+	)
+	require.NoError(t, err, "unable to initialize engine")
+	payload, ok := relay.engine.backend.parentPayloads.Get(parentHash)
+	require.True(t, ok)
+	expected := payload.(*types.ExecutionPayloadV1)
+
+	getBid := func(cfg RelayFaultConfig) *types.SignedBuilderBid {
+		relay.faults = cfg.NewRelayFaults()
+		path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 1, parentHash.Hex(), pk)
+		rr := relay.testRequest(t, "GET", path, nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		bid := new(types.GetHeaderResponse)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), bid))
+		return bid.Data
+	}
+	verify := func(bid *types.SignedBuilderBid) bool {
+		ok, _ := types.VerifySignature(bid.Message, types.DomainBuilder, bid.Message.Pubkey[:], bid.Signature[:])
+		return ok
+	}
+
+	bid := getBid(RelayFaultConfig{InvalidSignatureProbability: 1})
+	require.Equal(t, relay.pk, bid.Message.Pubkey)
+	require.False(t, verify(bid), "invalid signature")
+
+	bid = getBid(RelayFaultConfig{WrongPubkeyProbability: 1})
+	require.NotEqual(t, relay.pk, bid.Message.Pubkey)
+	require.False(t, verify(bid), "signed by another key than the one of the bid")
+
+	bid = getBid(RelayFaultConfig{WrongDomainProbability: 1})
+	require.Equal(t, relay.pk, bid.Message.Pubkey)
+	require.False(t, verify(bid), "signed over the builder domain of another network")
+
+	bid = getBid(RelayFaultConfig{StaleTimestampProbability: 1})
+	require.True(t, verify(bid))
+	require.Equal(t, expected.Timestamp-staleBidAge, bid.Message.Header.Timestamp)
+
+	bid = getBid(RelayFaultConfig{MismatchedHashProbability: 1})
+	require.True(t, verify(bid))
+	require.NotEqual(t, expected.BlockHash[:], bid.Message.Header.BlockHash[:])
+	require.Equal(t, expected.Timestamp, bid.Message.Header.Timestamp)
 }
 
 func TestGetPayload(t *testing.T) {
@@ -271,7 +516,7 @@ func TestGetPayload(t *testing.T) {
 	}
 
 	// Sign payload
-	root, err := types.ComputeSigningRoot(msg, types.ComputeDomain(types.DomainTypeBeaconProposer, version.Bellatrix, &relay.genesisValidatorsRoot))
+	root, err := types.ComputeSigningRoot(msg, types.ComputeDomain(types.DomainTypeBeaconProposer, types.ForkVersion{version.Bellatrix}, &relay.genesisValidatorsRoot))
 	require.NoError(t, err)
 	sig := sk.Sign(root[:]).Marshal()
 	var signature types.Signature
@@ -285,6 +530,15 @@ func TestGetPayload(t *testing.T) {
 	})
 	require.Equal(t, http.StatusBadRequest, rr.Code)
 
+	// A byzantine relay withholds the payload of the signed block
+	relay.faults = (&RelayFaultConfig{WithholdProbability: 1}).NewRelayFaults()
+	rr = relay.testRequest(t, "POST", "/eth/v1/builder/blinded_blocks", types.SignedBlindedBeaconBlock{
+		Message:   msg,
+		Signature: signature,
+	})
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	relay.faults = nil
+
 	// Call getPayload with correct signature
 	rr = relay.testRequest(t, "POST", "/eth/v1/builder/blinded_blocks", types.SignedBlindedBeaconBlock{
 		Message:   msg,
@@ -297,6 +551,171 @@ func TestGetPayload(t *testing.T) {
 	err = json.Unmarshal(rr.Body.Bytes(), getPayloadResponse)
 	require.NoError(t, err)
 	require.Equal(t, bid.Data.Message.Header.BlockHash, getPayloadResponse.Data.BlockHash)
+
+	// The bid and the delivered payload are both accounted to their slots
+	report := relay.economics.Report(relay.engine.mockChain().chain)
+	require.Len(t, report, 2)
+	require.Equal(t, uint64(0), report[0].Slot)
+	require.Equal(t, uint64(1), report[0].BidsServed)
+	require.Equal(t, uint64(1), report[1].Slot)
+	require.Equal(t, uint64(1), report[1].PayloadsDelivered)
+	require.Equal(t, common.Hash(bid.Data.Message.Header.BlockHash), *report[1].BlockHash)
+
+	reportPath := fmt.Sprintf("%s/economics.csv", t.TempDir())
+	require.NoError(t, WriteEconomicsReport(reportPath, report))
+	csv, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	require.Len(t, bytes.Split(bytes.TrimSpace(csv), []byte("\n")), 3)
+}
+
+func TestGetPayloadFaults(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	relay.engine.Run(ctx)
+	pk, sk := newKeypair(t)
+	parent := relay.engine.mockChain().CurrentHeader()
+	_, err := relay.engine.backend.ForkchoiceUpdatedV1(ctx,
+		&types.ForkchoiceStateV1{HeadBlockHash: parent.Hash(), SafeBlockHash: parent.Hash(), FinalizedBlockHash: parent.Hash()},
+		&types.PayloadAttributesV1{Timestamp: parent.Time + 1, PrevRandao: common.Hash{0x01}, SuggestedFeeRecipient: common.Address{0x02}})
+	require.NoError(t, err, "unable to initialize engine")
+
+	rr := relay.testRequest(t, "GET", fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 1, parent.Hash().Hex(), pk), nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	bid := new(types.GetHeaderResponse)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), bid))
+
+	getPayload := func(header types.ExecutionPayloadHeader, sk bls.SecretKey) *httptest.ResponseRecorder {
+		msg := &types.BlindedBeaconBlock{
+			Slot:          1,
+			ProposerIndex: 2,
+			Body: &types.BlindedBeaconBlockBody{
+				Eth1Data:               &types.Eth1Data{},
+				SyncAggregate:          &types.SyncAggregate{},
+				ExecutionPayloadHeader: &header,
+			},
+		}
+		root, err := types.ComputeSigningRoot(msg, types.ComputeDomain(types.DomainTypeBeaconProposer, types.ForkVersion{version.Bellatrix}, &relay.genesisValidatorsRoot))
+		require.NoError(t, err)
+		var signature types.Signature
+		signature.FromSlice(sk.Sign(root[:]).Marshal())
+		return relay.testRequest(t, "POST", "/eth/v1/builder/blinded_blocks", types.SignedBlindedBeaconBlock{Message: msg, Signature: signature})
+	}
+	delivered := func(rr *httptest.ResponseRecorder) *types.ExecutionPayloadREST {
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		response := new(types.GetPayloadResponse)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), response))
+		return response.Data
+	}
+	header := *bid.Data.Message.Header
+
+	// Only headers of served bids are unblinded, as signed by the proposer they were served to
+	unknown := header
+	unknown.BlockHash[0] ^= 0xff
+	require.Equal(t, http.StatusBadRequest, getPayload(unknown, sk).Code, "unknown header")
+	altered := header
+	altered.GasUsed++
+	require.Equal(t, http.StatusBadRequest, getPayload(altered, sk).Code, "header differing from the bid")
+	_, other := newKeypair(t)
+	require.Equal(t, http.StatusBadRequest, getPayload(header, other).Code, "signed by another proposer")
+	require.Equal(t, header.BlockHash, delivered(getPayload(header, sk)).BlockHash)
+
+	// An equivocating relay answers with another valid payload on the same parent
+	relay.faults = (&RelayFaultConfig{EquivocateProbability: 1}).NewRelayFaults()
+	equivocation := delivered(getPayload(header, sk))
+	require.NotEqual(t, header.BlockHash, equivocation.BlockHash)
+	require.Equal(t, header.ParentHash, equivocation.ParentHash)
+	require.Equal(t, header.Timestamp, equivocation.Timestamp)
+
+	// A late relay holds the payload back
+	relay.faults = (&RelayFaultConfig{LatePayloadProbability: 1, LatePayloadDelay: 200 * time.Millisecond}).NewRelayFaults()
+	start := time.Now()
+	require.Equal(t, header.BlockHash, delivered(getPayload(header, sk)).BlockHash)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestBuilderSSZ(t *testing.T) {
+	ctx := context.Background()
+	relay := newTestRelay(t)
+	relay.engine.Run(ctx)
+	srv := httptest.NewServer(relay.getRouter())
+	defer srv.Close()
+	pk, sk := newKeypair(t)
+	var pubkey types.PublicKey
+	pubkey.FromSlice(pk)
+
+	// Register in SSZ
+	reg := &types.RegisterValidatorRequestMessage{FeeRecipient: types.Address{0x42}, GasLimit: 15_000_000, Timestamp: uint64(time.Now().Unix()), Pubkey: pubkey}
+	root, err := types.ComputeSigningRoot(reg, types.DomainBuilder)
+	require.NoError(t, err)
+	var regSig types.Signature
+	regSig.FromSlice(sk.Sign(root[:]).Marshal())
+	require.NoError(t, api.BuilderRegisterValidators(ctx, logrus.New(), srv.URL, []types.SignedValidatorRegistration{{Message: reg, Signature: regSig}}, true))
+	registered, ok := relay.registrations.Get(pubkey)
+	require.True(t, ok)
+	require.Equal(t, *reg, registered)
+
+	parent := relay.engine.mockChain().CurrentHeader()
+	_, err = relay.engine.backend.ForkchoiceUpdatedV1(ctx,
+		&types.ForkchoiceStateV1{HeadBlockHash: parent.Hash(), SafeBlockHash: parent.Hash(), FinalizedBlockHash: parent.Hash()},
+		&types.PayloadAttributesV1{Timestamp: parent.Time + 1, PrevRandao: common.Hash{0x01}, SuggestedFeeRecipient: common.Address{0x02}})
+	require.NoError(t, err, "unable to initialize engine")
+
+	// The bid is answered in SSZ, to clients preferring it
+	path := fmt.Sprintf("/eth/v1/builder/header/%d/%s/0x%x", 1, parent.Hash().Hex(), pk)
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/octet-stream;q=1.0,application/json;q=0.9")
+	rr := httptest.NewRecorder()
+	relay.getRouter().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+	require.Equal(t, "bellatrix", rr.Header().Get("Eth-Consensus-Version"))
+	header, err := api.BuilderGetHeader(ctx, logrus.New(), srv.URL, 1, parent.Hash(), pk, types.DomainBuilder, true)
+	require.NoError(t, err)
+
+	msg := &types.BlindedBeaconBlock{
+		Slot:          1,
+		ProposerIndex: 2,
+		Body: &types.BlindedBeaconBlockBody{
+			Eth1Data:               &types.Eth1Data{},
+			SyncAggregate:          &types.SyncAggregate{},
+			ExecutionPayloadHeader: header,
+		},
+	}
+	root, err = types.ComputeSigningRoot(msg, types.ComputeDomain(types.DomainTypeBeaconProposer, types.ForkVersion{version.Bellatrix}, &relay.genesisValidatorsRoot))
+	require.NoError(t, err)
+	var signature types.Signature
+	signature.FromSlice(sk.Sign(root[:]).Marshal())
+	block := &types.SignedBlindedBeaconBlock{Message: msg, Signature: signature}
+	payload, err := api.BuilderGetPayload(ctx, logrus.New(), srv.URL, block, true)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash(header.BlockHash), payload.BlockHash)
+	payloadRoot, err := payload.HashTreeRoot()
+	require.NoError(t, err)
+	headerRoot, err := header.HashTreeRoot()
+	require.NoError(t, err)
+	require.Equal(t, headerRoot, payloadRoot, "the payload matches the header it was blinded to")
+
+	// Without SSZ support SSZ requests are refused and responses are JSON
+	relay.ssz = false
+	body, err := block.MarshalSSZ()
+	require.NoError(t, err)
+	req = httptest.NewRequest("POST", "/eth/v1/builder/blinded_blocks", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr = httptest.NewRecorder()
+	relay.getRouter().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	_, err = api.BuilderGetHeader(ctx, logrus.New(), srv.URL, 1, parent.Hash(), pk, types.DomainBuilder, true)
+	require.NoError(t, err, "falls back to JSON responses")
+}
+
+func TestPrefersSSZ(t *testing.T) {
+	require.True(t, prefersSSZ("application/octet-stream"))
+	require.True(t, prefersSSZ("application/octet-stream;q=1.0,application/json;q=0.9"))
+	require.True(t, prefersSSZ("application/octet-stream, application/json"), "the first listed wins ties")
+	require.False(t, prefersSSZ("application/json, application/octet-stream"))
+	require.False(t, prefersSSZ("application/json;q=1,application/octet-stream;q=0.5"))
+	require.False(t, prefersSSZ("*/*"))
+	require.False(t, prefersSSZ(""))
 }
 
 func TestExecutionPayloadTransformations(t *testing.T) {
@@ -311,7 +730,7 @@ func TestExecutionPayloadTransformations(t *testing.T) {
 	}}
 
 	// Create a block
-	block1, err := relay.engine.mockChain().AddNewBlock(parent.Hash(), common.Address{0x02}, 12345, 23456, txsCreator, common.Hash{0x04}, []byte("hello"), nil, false)
+	block1, err := relay.engine.mockChain().AddNewBlock(parent.Hash(), common.Address{0x02}, 12345, 23456, txsCreator, common.Hash{0x04}, []byte("hello"), nil, nil, false)
 	require.NoError(t, err)
 
 	// Transform to EL payload
@@ -331,3 +750,22 @@ func TestExecutionPayloadTransformations(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, block1.Hash(), block2.Hash())
 }
+
+func TestProposalTracker(t *testing.T) {
+	tracker := NewProposalTracker()
+	require.Nil(t, tracker.Observe(1, 7, types.Root{0x01}))
+	// The same block can be submitted again, for example on a retry.
+	require.Nil(t, tracker.Observe(1, 7, types.Root{0x01}))
+	// Other proposers and slots don't conflict.
+	require.Nil(t, tracker.Observe(1, 8, types.Root{0x02}))
+	require.Nil(t, tracker.Observe(2, 7, types.Root{0x02}))
+
+	v := tracker.Observe(1, 7, types.Root{0x03})
+	require.NotNil(t, v)
+	require.Equal(t, ProposalViolation{Slot: 1, ProposerIndex: 7, First: types.Root{0x01}, Second: types.Root{0x03}}, *v)
+	require.Equal(t, []ProposalViolation{*v}, tracker.Violations())
+
+	// Old slots are forgotten once far enough behind.
+	require.Nil(t, tracker.Observe(proposalHistorySlots+10, 1, types.Root{0x01}))
+	require.Nil(t, tracker.Observe(1, 7, types.Root{0x04}))
+}
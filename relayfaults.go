@@ -0,0 +1,111 @@
+package mergemock
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type RelayFaultConfig struct {
+	WithholdProbability float64       `ask:"--withhold-probability" help:"Probability of withholding the payload of a signed blinded block, failing getPayload"`
+	LateBidProbability  float64       `ask:"--late-bid-probability" help:"Probability of holding a getHeader response back by the late bid delay"`
+	LateBidDelay        time.Duration `ask:"--late-bid-delay" help:"Delay of late getHeader responses"`
+	Seed                int64         `ask:"--seed" help:"Seed of the relay faults (0 for a random seed)"`
+
+	InvalidSignatureProbability float64 `ask:"--invalid-signature-probability" help:"Probability of signing a bid with an invalid BLS signature"`
+	WrongPubkeyProbability      float64 `ask:"--wrong-pubkey-probability" help:"Probability of a bid carrying a pubkey other than the signing key of the relay"`
+	WrongDomainProbability      float64 `ask:"--wrong-domain-probability" help:"Probability of signing a bid over the builder domain of another network"`
+	StaleTimestampProbability   float64 `ask:"--stale-timestamp-probability" help:"Probability of a bid header with the timestamp of the previous slot"`
+	MismatchedHashProbability   float64 `ask:"--mismatched-hash-probability" help:"Probability of a bid header whose block hash doesn't match the payload of getPayload"`
+
+	EquivocateProbability  float64       `ask:"--equivocate-probability" help:"Probability of answering getPayload with another payload on the same parent, whose block hash differs from the header the proposer signed"`
+	LatePayloadProbability float64       `ask:"--late-payload-probability" help:"Probability of holding a getPayload response back by the late payload delay"`
+	LatePayloadDelay       time.Duration `ask:"--late-payload-delay" help:"Delay of late getPayload responses, e.g. 12s to deliver the payload after its slot ended"`
+}
+
+// staleBidAge is how many seconds stale bid headers are behind, a slot.
+const staleBidAge = 12
+
+// NewRelayFaults returns the relay faults of the config, nil if it injects none.
+func (c *RelayFaultConfig) NewRelayFaults() *RelayFaults {
+	if c.WithholdProbability == 0 && (c.LateBidProbability == 0 || c.LateBidDelay == 0) &&
+		c.EquivocateProbability == 0 && (c.LatePayloadProbability == 0 || c.LatePayloadDelay == 0) &&
+		c.InvalidSignatureProbability == 0 && c.WrongPubkeyProbability == 0 && c.WrongDomainProbability == 0 &&
+		c.StaleTimestampProbability == 0 && c.MismatchedHashProbability == 0 {
+		return nil
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &RelayFaults{cfg: *c, rng: rand.New(rand.NewSource(seed))}
+}
+
+// RelayFaults makes the relay misbehave towards the proposer, to test how the consensus client and
+// mev-boost handle a byzantine relay.
+type RelayFaults struct {
+	cfg RelayFaultConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (f *RelayFaults) chance(p float64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return p > 0 && f.rng.Float64() < p
+}
+
+// Withhold reports whether to withhold the payload of the next signed blinded block.
+func (f *RelayFaults) Withhold() bool {
+	return f != nil && f.chance(f.cfg.WithholdProbability)
+}
+
+// LateBid returns how long to hold the next getHeader response back.
+func (f *RelayFaults) LateBid() time.Duration {
+	if f == nil || !f.chance(f.cfg.LateBidProbability) {
+		return 0
+	}
+	return f.cfg.LateBidDelay
+}
+
+// Equivocate reports whether to answer the next signed blinded block with another payload than the one of its header.
+func (f *RelayFaults) Equivocate() bool {
+	return f != nil && f.chance(f.cfg.EquivocateProbability)
+}
+
+// LatePayload returns how long to hold the next getPayload response back.
+func (f *RelayFaults) LatePayload() time.Duration {
+	if f == nil || !f.chance(f.cfg.LatePayloadProbability) {
+		return 0
+	}
+	return f.cfg.LatePayloadDelay
+}
+
+// BidCorruption is how the relay malforms a bid, to exercise the bid validation of mev-boost and consensus clients.
+type BidCorruption struct {
+	InvalidSignature bool
+	WrongPubkey      bool
+	WrongDomain      bool
+	StaleTimestamp   bool
+	MismatchedHash   bool
+}
+
+// Any reports whether the bid is malformed at all.
+func (c BidCorruption) Any() bool {
+	return c.InvalidSignature || c.WrongPubkey || c.WrongDomain || c.StaleTimestamp || c.MismatchedHash
+}
+
+// Corrupt returns how to malform the next bid.
+func (f *RelayFaults) Corrupt() BidCorruption {
+	if f == nil {
+		return BidCorruption{}
+	}
+	return BidCorruption{
+		InvalidSignature: f.chance(f.cfg.InvalidSignatureProbability),
+		WrongPubkey:      f.chance(f.cfg.WrongPubkeyProbability),
+		WrongDomain:      f.chance(f.cfg.WrongDomainProbability),
+		StaleTimestamp:   f.chance(f.cfg.StaleTimestampProbability),
+		MismatchedHash:   f.chance(f.cfg.MismatchedHashProbability),
+	}
+}
@@ -0,0 +1,176 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/sirupsen/logrus"
+)
+
+// Reorg makes the known block with the spec hash the canonical head, e.g. the tip of a side branch built
+// with forkchoiceUpdated and imported with newPayload. Ancestors of the head are refused, as rewinding
+// deletes the blocks above them.
+func (c *MockChain) Reorg(specHash common.Hash, trigger string) (*TimelineReorg, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block := c.chain.GetBlockByHash(c.ResolveHash(specHash))
+	if block == nil {
+		return nil, fmt.Errorf("unknown block %s", specHash)
+	}
+	head := c.chain.CurrentBlock()
+	ancestor := rawdb.FindCommonAncestor(c.database, head.Header(), block.Header())
+	if ancestor == nil {
+		return nil, fmt.Errorf("block %s has no common ancestor with the head", specHash)
+	}
+	if ancestor.Hash() == block.Hash() {
+		return nil, fmt.Errorf("block %s is an ancestor of the head, reorgs need a block of another branch", specHash)
+	}
+	if err := c.chain.SetChainHead(block); err != nil {
+		return nil, fmt.Errorf("failed to set chain head: %v", err)
+	}
+	c.recordHead(trigger, head, block)
+	return &TimelineReorg{
+		OldHead: c.SpecHash(head.Hash()),
+		NewHead: specHash,
+		Depth:   head.NumberU64() - ancestor.Number.Uint64(),
+	}, nil
+}
+
+// moveHead makes the stored block the head unless its import already did, e.g. as it extends the head, and journals
+// the move from the old head, the head before the block was built.
+func (c *MockChain) moveHead(block, oldHead *types.Block, trigger string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chain.CurrentBlock().Hash() != block.Hash() {
+		if err := c.chain.SetChainHead(block); err != nil {
+			return fmt.Errorf("failed to set chain head: %v", err)
+		}
+	}
+	c.recordHead(trigger, oldHead, block)
+	return nil
+}
+
+// Ancestor returns the spec hash of the ancestor depth blocks below the block with the spec hash.
+func (c *MockChain) Ancestor(specHash common.Hash, depth uint64) (common.Hash, error) {
+	header := c.chain.GetHeaderByHash(c.ResolveHash(specHash))
+	if header == nil {
+		return common.Hash{}, fmt.Errorf("unknown block %s", specHash)
+	}
+	if depth > header.Number.Uint64() {
+		return common.Hash{}, fmt.Errorf("block %s at height %d has no ancestor %d blocks below", specHash, header.Number, depth)
+	}
+	ancestor := header
+	for i := uint64(0); i < depth && ancestor != nil; i++ {
+		ancestor = c.chain.GetHeader(ancestor.ParentHash, ancestor.Number.Uint64()-1)
+	}
+	if ancestor == nil {
+		return common.Hash{}, fmt.Errorf("missing ancestor of block %s", specHash)
+	}
+	return c.SpecHash(ancestor.Hash()), nil
+}
+
+type AutoReorgConfig struct {
+	Every  uint64 `ask:"--every" help:"Reorg the head every this many executed payloads (0 to disable)"`
+	Depth  uint64 `ask:"--depth" help:"Number of blocks replaced by automatic reorgs"`
+	Blocks uint64 `ask:"--blocks" help:"Number of blocks of the competing fork of automatic reorgs (0 for one more than the depth)"`
+}
+
+func (c *AutoReorgConfig) Default() {
+	c.Depth = 1
+}
+
+// autoReorg reorgs the head if the executed payload is one of every configured number of payloads.
+func (e *EngineBackend) autoReorg() {
+	cfg := e.autoReorgs
+	if cfg.Every == 0 || atomic.AddUint64(&e.executed, 1)%cfg.Every != 0 {
+		return
+	}
+	blocks := cfg.Blocks
+	if blocks == 0 {
+		blocks = cfg.Depth + 1
+	}
+	reorg, err := e.mockChain.TriggerReorg(cfg.Depth, blocks, "auto-reorg")
+	if err != nil {
+		e.log.WithError(err).Error("Failed automatic reorg")
+		return
+	}
+	e.log.WithFields(logrus.Fields{"old_head": reorg.OldHead, "new_head": reorg.NewHead, "depth": reorg.Depth, "blocks": blocks}).Warn("Automatic reorg")
+}
+
+// forkExtraData marks the blocks of forks built by BuildFork, numbered to set them apart from the blocks at the
+// same height of the branches they compete with, including earlier forks.
+var forkExtraData = []byte("mergemock fork")
+
+// BuildFork builds a branch of empty blocks on the known block with the spec hash, a slot apart, and returns the
+// spec hash of its tip. Like any imported branch, it becomes canonical if it is longer than the chain of the head.
+func (c *MockChain) BuildFork(specHash common.Hash, blocks uint64, trigger string) (common.Hash, error) {
+	parent := c.chain.GetHeaderByHash(c.ResolveHash(specHash))
+	if parent == nil {
+		return common.Hash{}, fmt.Errorf("unknown block %s", specHash)
+	}
+	empty := TransactionsCreator{nil, func(*params.ChainConfig, core.ChainContext, *state.StateDB, *types.Header, vm.Config, []TestAccount) []*types.Transaction {
+		return nil
+	}}
+	extra := append(append([]byte{}, forkExtraData...), fmt.Sprintf(" %d", atomic.AddUint64(&c.builtForks, 1))...)
+	tip := specHash
+	for i := uint64(0); i < blocks; i++ {
+		block, _, _, err := c.buildBlock(tip, common.Address{}, parent.Time+12, parent.GasLimit, empty, common.Hash{}, extra, nil, nil, nil, true)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to build block %d of fork: %v", i+1, err)
+		}
+		parent, tip = block.Header(), c.SpecHash(block.Hash())
+		c.recordBlock(JournalFork, trigger, tip, block)
+	}
+	return tip, nil
+}
+
+// TriggerReorg builds a fork of blocks on the ancestor depth blocks below the head, and makes its tip the head.
+func (c *MockChain) TriggerReorg(depth, blocks uint64, trigger string) (*TimelineReorg, error) {
+	if depth == 0 || blocks == 0 {
+		return nil, fmt.Errorf("reorgs need a depth and blocks")
+	}
+	head := c.chain.CurrentBlock()
+	ancestor, err := c.Ancestor(c.SpecHash(head.Hash()), depth)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := c.BuildFork(ancestor, blocks, trigger)
+	if err != nil {
+		return nil, err
+	}
+	block := c.chain.GetBlockByHash(c.ResolveHash(tip))
+	if err := c.moveHead(block, head, trigger); err != nil {
+		return nil, err
+	}
+	return &TimelineReorg{OldHead: c.SpecHash(head.Hash()), NewHead: tip, Depth: depth}, nil
+}
+
+// TriggerReorg replaces the top depth blocks of the canonical chain with a competing fork of the given number of
+// blocks, and returns the head it replaced with the depth.
+func (b *MockBackend) TriggerReorg(ctx context.Context, depth, blocks uint64) (*TimelineReorg, error) {
+	reorg, err := b.engine.mockChain.TriggerReorg(depth, blocks, "mock_triggerReorg")
+	if err != nil {
+		return nil, err
+	}
+	b.engine.log.WithFields(logrus.Fields{"old_head": reorg.OldHead, "new_head": reorg.NewHead, "depth": reorg.Depth, "blocks": blocks}).Warn("Triggered reorg")
+	return reorg, nil
+}
+
+// Reorg makes the block the head of the canonical chain served by the eth namespace, and returns the
+// head it replaced with the number of blocks that were reorged out.
+func (b *MockBackend) Reorg(ctx context.Context, blockHash common.Hash) (*TimelineReorg, error) {
+	reorg, err := b.engine.mockChain.Reorg(blockHash, "mock_reorg")
+	if err != nil {
+		return nil, err
+	}
+	b.engine.log.WithFields(logrus.Fields{"old_head": reorg.OldHead, "new_head": reorg.NewHead, "depth": reorg.Depth}).Warn("Reorged chain")
+	return reorg, nil
+}
@@ -0,0 +1,277 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mergemock/rpc"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of replaying a recorded session.
+const (
+	ReplayPlay  = "play"  // send the recorded requests to an engine, comparing its responses
+	ReplayServe = "serve" // answer a consensus client with the recorded responses, comparing its requests
+)
+
+type ReplayCmd struct {
+	SessionPath   string `ask:"--session" help:"JSONL session recorded with --record.path of the engine"`
+	Mode          string `ask:"--mode" help:"play sends the recorded requests to the engine at --engine and compares its responses, serve answers a consensus client on --listen-addr with the recorded responses and compares its requests"`
+	EngineAddr    string `ask:"--engine" help:"Address of the engine API to play the session against"`
+	ListenAddr    string `ask:"--listen-addr" help:"Address to serve the recorded responses on"`
+	JwtSecretPath string `ask:"--jwt-secret" help:"JWT secret key to authenticate the played requests"`
+	Timing        bool   `ask:"--timing" help:"Keep the recorded time between played requests, instead of sending them back to back"`
+
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log logrus.Ext1FieldLogger
+}
+
+func (c *ReplayCmd) Default() {
+	c.Mode = ReplayPlay
+	c.EngineAddr = "http://127.0.0.1:8551"
+	c.ListenAddr = "127.0.0.1:8551"
+	c.JwtSecretPath = "jwt.hex"
+}
+
+func (c *ReplayCmd) Help() string {
+	return "Replay an engine API session recorded by the engine, against an execution engine or a consensus client, and fail on diverging messages."
+}
+
+// ReplayResult counts how many exchanges of the session matched, the differing ones are logged.
+type ReplayResult struct {
+	Exchanges  int `json:"exchanges"`
+	Matched    int `json:"matched"`
+	Mismatched int `json:"mismatched"`
+	// Unexpected counts the requests of a consensus client that aren't in the session.
+	Unexpected int `json:"unexpected"`
+}
+
+func (r *ReplayResult) err() error {
+	if r.Mismatched > 0 || r.Unexpected > 0 || r.Matched < r.Exchanges {
+		return fmt.Errorf("replay diverged: %d of %d exchanges matched, %d mismatched, %d unexpected requests", r.Matched, r.Exchanges, r.Mismatched, r.Unexpected)
+	}
+	return nil
+}
+
+func (c *ReplayCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+	if c.SessionPath == "" {
+		return fmt.Errorf("no session to replay, set --session")
+	}
+	exchanges, err := rpc.ReadRecording(c.SessionPath)
+	if err != nil {
+		return fmt.Errorf("failed to read session: %v", err)
+	}
+	var result *ReplayResult
+	switch c.Mode {
+	case ReplayPlay:
+		jwt, err := readJwtSecret(c.JwtSecretPath)
+		if err != nil {
+			return fmt.Errorf("unable to read JWT secret: %v", err)
+		}
+		result, err = c.play(ctx, exchanges, jwt)
+		if err != nil {
+			return err
+		}
+	case ReplayServe:
+		result, err = c.serve(ctx, exchanges)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown replay mode %q, expected %s or %s", c.Mode, ReplayPlay, ReplayServe)
+	}
+	c.log.WithFields(logrus.Fields{
+		"exchanges":  result.Exchanges,
+		"matched":    result.Matched,
+		"mismatched": result.Mismatched,
+		"unexpected": result.Unexpected,
+	}).Info("Replayed session")
+	return result.err()
+}
+
+// play sends the recorded requests in order, and compares the responses with the recorded ones.
+func (c *ReplayCmd) play(ctx context.Context, exchanges []rpc.RecordedExchange, secret []byte) (*ReplayResult, error) {
+	result := &ReplayResult{Exchanges: len(exchanges)}
+	for i, ex := range exchanges {
+		if c.Timing && i > 0 {
+			select {
+			case <-time.After(ex.Time.Sub(exchanges[i-1].Time)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		token, err := rpc.IssueJwtToken().SignedString(secret)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.EngineAddr, bytes.NewReader(ex.Request))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", rpc.EncodeJwtAuthorization(token))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send exchange %d, %s: %v", i+1, ex.Method, err)
+		}
+		response, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if sameMessage(ex.Response, response) {
+			result.Matched++
+			continue
+		}
+		result.Mismatched++
+		c.log.WithFields(logrus.Fields{
+			"exchange": i + 1,
+			"method":   ex.Method,
+			"recorded": string(ex.Response),
+			"got":      string(bytes.TrimSpace(response)),
+		}).Warn("Response differs from the recorded one")
+	}
+	return result, nil
+}
+
+// serve answers the requests of a consensus client with the responses recorded for the same method, in order, and
+// compares the requests with the recorded ones. It returns once every exchange has been served.
+func (c *ReplayCmd) serve(ctx context.Context, exchanges []rpc.RecordedExchange) (*ReplayResult, error) {
+	player := &replayServer{log: c.log, exchanges: exchanges, served: make([]bool, len(exchanges)), done: make(chan struct{})}
+	player.result.Exchanges = len(exchanges)
+	srv := &http.Server{Addr: c.ListenAddr, Handler: player}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	c.log.WithFields(logrus.Fields{"listenAddr": c.ListenAddr, "exchanges": len(exchanges)}).Info("Serving recorded session")
+	if len(exchanges) == 0 {
+		close(player.done)
+	}
+	select {
+	case <-player.done:
+	case <-ctx.Done():
+	case err := <-errs:
+		return nil, err
+	}
+	// let the last response reach the client
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdown)
+	return player.Result(), nil
+}
+
+// replayServer answers with the recorded responses, the consensus client doesn't need to authenticate.
+type replayServer struct {
+	log       logrus.Ext1FieldLogger
+	exchanges []rpc.RecordedExchange
+
+	mu     sync.Mutex
+	served []bool
+	result ReplayResult
+	done   chan struct{}
+}
+
+func (s *replayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "replay only serves single JSON-RPC requests", http.StatusBadRequest)
+		return
+	}
+	if len(msg.ID) == 0 {
+		msg.ID = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	ex := s.next(msg.Method, body)
+	if ex == nil {
+		w.Write([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"method %s not in the recorded session"}}`, msg.ID, msg.Method)))
+		return
+	}
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(ex.Response, &response); err != nil {
+		w.Write(ex.Response)
+		return
+	}
+	response["id"] = msg.ID
+	out, _ := json.Marshal(response)
+	w.Write(out)
+}
+
+// next marks the first exchange of the method not served yet as served, and counts whether the request matches
+// the recorded one.
+func (s *replayServer) next(method string, request []byte) *rpc.RecordedExchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.exchanges {
+		ex := &s.exchanges[i]
+		if s.served[i] || ex.Method != method {
+			continue
+		}
+		s.served[i] = true
+		if sameMessage(ex.Request, request) {
+			s.result.Matched++
+		} else {
+			s.result.Mismatched++
+			s.log.WithFields(logrus.Fields{
+				"exchange": i + 1,
+				"method":   method,
+				"recorded": string(ex.Request),
+				"got":      string(bytes.TrimSpace(request)),
+			}).Warn("Request differs from the recorded one")
+		}
+		if s.result.Matched+s.result.Mismatched == len(s.exchanges) {
+			close(s.done)
+		}
+		return ex
+	}
+	s.result.Unexpected++
+	s.log.WithField("method", method).Warn("Request not in the recorded session")
+	return nil
+}
+
+func (s *replayServer) Result() *ReplayResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := s.result
+	return &result
+}
+
+// sameMessage reports whether the JSON-RPC messages are equal, apart from their ids.
+func sameMessage(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+	}
+	return reflect.DeepEqual(withoutIDs(x), withoutIDs(y))
+}
+
+func withoutIDs(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "id")
+	case []interface{}:
+		for i := range v {
+			v[i] = withoutIDs(v[i])
+		}
+	}
+	return v
+}
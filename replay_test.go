@@ -0,0 +1,80 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mergemock/rpc"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	genesisPath := newGenesis(t)
+	session := filepath.Join(t.TempDir(), "session.jsonl")
+	recorded := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Record.Path = session
+	})
+	genesis := recorded.mockChain().CurrentHeader()
+	payload := recorded.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	recorded.newPayload(t, payload)
+	recorded.setHead(t, payload.BlockHash)
+
+	exchanges, err := rpc.ReadRecording(session)
+	require.NoError(t, err)
+	var methods []string
+	for _, ex := range exchanges {
+		methods = append(methods, ex.Method)
+	}
+	// Calls of the eth namespace, like the readiness probe, aren't recorded.
+	require.Equal(t, []string{"engine_forkchoiceUpdatedV1", "engine_getPayloadV1", "engine_newPayloadV1", "engine_forkchoiceUpdatedV1"}, methods)
+
+	newReplay := func(mode string) *ReplayCmd {
+		c := new(ReplayCmd)
+		c.Default()
+		c.LogCmd.Default()
+		c.SessionPath = session
+		c.Mode = mode
+		return c
+	}
+
+	// A fresh engine on the same genesis answers the same.
+	fresh := newTestEngineWithGenesis(t, genesisPath)
+	play := newReplay(ReplayPlay)
+	play.EngineAddr = "http://" + fresh.ListenAddr
+	play.JwtSecretPath = fresh.JwtSecretPath
+	require.NoError(t, play.Run(ctx))
+	// Played again, the engine hands out another payload id.
+	err = play.Run(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "replay diverged")
+
+	// Served, a consensus client sending the same requests gets the recorded responses.
+	serve := newReplay(ReplayServe)
+	serve.ListenAddr = freeAddr(t)
+	served := make(chan error, 1)
+	go func() { served <- serve.Run(ctx) }()
+	for _, ex := range exchanges {
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			resp, err = http.Post("http://"+serve.ListenAddr, "application/json", bytes.NewReader(ex.Request))
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		response, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.True(t, sameMessage(ex.Response, response), "response of %s", ex.Method)
+	}
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay not done after serving the session")
+	}
+}
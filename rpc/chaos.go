@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of chaos breaking HTTP responses below the JSON-RPC layer.
+const (
+	ChaosDrop     = "drop"     // the connection is reset halfway through the response body
+	ChaosTruncate = "truncate" // the response body is cut in half, with a content length matching the cut body
+	ChaosDrip     = "drip"     // the response body is sent a few bytes at a time
+	Chaos502      = "502"      // 502 Bad Gateway instead of the response
+	Chaos503      = "503"      // 503 Service Unavailable instead of the response
+)
+
+// Chaos configures responses of the HTTP server broken at random in ways a correct server never does, unlike the
+// well-formed errors of fault rules, to test the robustness of the RPC clients of consensus clients.
+type Chaos struct {
+	Probability float64       `ask:"--probability" help:"Probability of a response being broken (no chaos if 0)"`
+	Kinds       []string      `ask:"--kind" help:"Ways to break responses, picked at random: drop (connection reset mid-response), truncate (half of the JSON body), drip (slow drip of the body), 502 or 503"`
+	DripDelay   time.Duration `ask:"--drip-delay" help:"Delay between the chunks of drip responses"`
+	DripChunk   int           `ask:"--drip-chunk" help:"Bytes per chunk of drip responses"`
+	Seed        int64         `ask:"--seed" help:"Seed of the broken responses (0 for a random seed)"`
+}
+
+// Validate checks the kinds of the config.
+func (c *Chaos) Validate() error {
+	for _, kind := range c.Kinds {
+		switch kind {
+		case ChaosDrop, ChaosTruncate, ChaosDrip, Chaos502, Chaos503:
+		default:
+			return fmt.Errorf("unknown chaos kind %q", kind)
+		}
+	}
+	if c.Probability > 0 && len(c.Kinds) == 0 {
+		return fmt.Errorf("chaos probability %v without kinds", c.Probability)
+	}
+	return nil
+}
+
+// Handler breaks responses of the next handler at the configured probability.
+func (c *Chaos) Handler(next http.Handler) http.Handler {
+	if c.Probability <= 0 || len(c.Kinds) == 0 {
+		return next
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	var mu sync.Mutex
+	pick := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if rng.Float64() >= c.Probability {
+			return ""
+		}
+		return c.Kinds[rng.Intn(len(c.Kinds))]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind := pick()
+		switch kind {
+		case "":
+			next.ServeHTTP(w, r)
+			return
+		case Chaos502:
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		case Chaos503:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		// the response is needed whole to break it
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		header := w.Header()
+		for k, v := range rec.Header() {
+			header[k] = v
+		}
+		switch kind {
+		case ChaosTruncate:
+			body = body[:len(body)/2]
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(rec.Code)
+			w.Write(body)
+		case ChaosDrip:
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(rec.Code)
+			c.drip(w, r, body)
+		case ChaosDrop:
+			dropMidResponse(w, rec.Code, header, body)
+		}
+	})
+}
+
+// drip writes the body in chunks, flushing each after the drip delay, until the client goes away.
+func (c *Chaos) drip(w http.ResponseWriter, r *http.Request, body []byte) {
+	flusher, _ := w.(http.Flusher)
+	chunk := c.DripChunk
+	if chunk < 1 {
+		chunk = 1
+	}
+	for len(body) > 0 {
+		select {
+		case <-time.After(c.DripDelay):
+		case <-r.Context().Done():
+			return
+		}
+		n := chunk
+		if n > len(body) {
+			n = len(body)
+		}
+		w.Write(body[:n])
+		if flusher != nil {
+			flusher.Flush()
+		}
+		body = body[n:]
+	}
+}
+
+// dropMidResponse writes the header with the full content length and half of the body on the raw connection, then
+// resets it.
+func dropMidResponse(w http.ResponseWriter, status int, header http.Header, body []byte) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		// HTTP/2 connections can't be taken over, break the stream instead
+		panic(http.ErrAbortHandler)
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	defer conn.Close()
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	writeResponseHead(buf.Writer, status, header)
+	buf.Write(body[:len(body)/2])
+	buf.Flush()
+	resetOnClose(conn)
+}
+
+func writeResponseHead(w *bufio.Writer, status int, header http.Header) {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Write(w)
+	w.WriteString("\r\n")
+}
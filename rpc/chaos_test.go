@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaos(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"result":"0x0123456789abcdef"}`
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+	serve := func(cfg Chaos) *httptest.Server {
+		require.NoError(t, cfg.Validate())
+		srv := httptest.NewServer(cfg.Handler(next))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	srv := serve(Chaos{Probability: 1, Kinds: []string{Chaos503}})
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Truncated bodies are well-formed HTTP, but not JSON.
+	srv = serve(Chaos{Probability: 1, Kinds: []string{ChaosTruncate}})
+	resp, err = http.Get(srv.URL)
+	require.NoError(t, err)
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, body[:len(body)/2], string(got))
+	require.Error(t, json.Unmarshal(got, new(interface{})))
+
+	// Dropped connections end before the announced content length.
+	srv = serve(Chaos{Probability: 1, Kinds: []string{ChaosDrop}})
+	resp, err = http.Get(srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(body)), resp.ContentLength)
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Error(t, err)
+
+	// Drip responses arrive whole, slowly.
+	srv = serve(Chaos{Probability: 1, Kinds: []string{ChaosDrip}, DripDelay: 10 * time.Millisecond, DripChunk: 20})
+	start := time.Now()
+	resp, err = http.Get(srv.URL)
+	require.NoError(t, err)
+	got, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, body, string(got))
+	require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+	// Responses aren't broken without chaos.
+	srv = serve(Chaos{Kinds: []string{ChaosDrop}})
+	resp, err = http.Get(srv.URL)
+	require.NoError(t, err)
+	got, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, body, string(got))
+
+	require.Error(t, (&Chaos{Probability: 1, Kinds: []string{"teapot"}}).Validate())
+	require.Error(t, (&Chaos{Probability: 1}).Validate())
+}
@@ -3,6 +3,7 @@ package rpc
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -35,6 +36,15 @@ func DialContext(ctx context.Context, rawurl string, secret []byte) (*Client, er
 	return &Client{client, secret}, nil
 }
 
+// DialHTTPWithClient connects to an HTTP endpoint through the given HTTP client, e.g. to wrap its transport.
+func DialHTTPWithClient(rawurl string, secret []byte, httpClient *http.Client) (*Client, error) {
+	client, err := rpc.DialHTTPWithClient(rawurl, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client, secret}, nil
+}
+
 func (c *Client) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
 	token, err := IssueJwtToken().SignedString(c.secret)
 	if err != nil {
@@ -44,6 +54,11 @@ func (c *Client) CallContext(ctx context.Context, result interface{}, method str
 	return c.inner.CallContext(ctx, result, method, args...)
 }
 
+// SetHeader sets a header sent with every call, e.g. the User-Agent telling the client apart.
+func (c *Client) SetHeader(key, value string) {
+	c.inner.SetHeader(key, value)
+}
+
 func (c *Client) Close() {
 	c.inner.Close()
 }
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type clientIDKey struct{}
+
+// ClientIDHandler tags the requests to the next handler with the id claim of their JWT, which the engine API lets
+// consensus clients identify themselves with. The token isn't validated.
+func ClientIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := tokenClientID(r); id != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientIDKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenClientID returns the id claim of the JWT of the request, empty if it has none.
+func tokenClientID(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+	id, _ := claims["id"].(string)
+	return id
+}
+
+// ClientID returns who made the call: the id claim of its JWT if tagged, or else the IP address and user agent of
+// the client.
+func ClientID(ctx context.Context) string {
+	if id, ok := ctx.Value(clientIDKey{}).(string); ok {
+		return id
+	}
+	info := gethRpc.PeerInfoFromContext(ctx)
+	host, _, err := net.SplitHostPort(info.RemoteAddr)
+	if err != nil {
+		host = info.RemoteAddr
+	}
+	if info.HTTP.UserAgent == "" {
+		return host
+	}
+	return host + " " + info.HTTP.UserAgent
+}
@@ -0,0 +1,32 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientID(t *testing.T) {
+	var id string
+	srv := httptest.NewServer(ClientIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = ClientID(r.Context())
+	})))
+	defer srv.Close()
+	call := func(claims jwt.MapClaims) string {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(make([]byte, 32))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", EncodeJwtAuthorization(token))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return id
+	}
+	require.Equal(t, "lighthouse-1", call(jwt.MapClaims{"id": "lighthouse-1"}))
+	// Without id claim, and without the peer info of the geth server, nothing tells the client apart.
+	require.Equal(t, "", call(jwt.MapClaims{"iat": 1}))
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentNegotiation configures the encoding and content type of HTTP bodies, with faults breaking the content
+// type of responses, to cover the HTTP layer of clients.
+type ContentNegotiation struct {
+	Gzip             bool    `ask:"--gzip" help:"Decompress gzip request bodies, and gzip responses of requests accepting it like geth (if disabled, compressed requests are answered with 415)"`
+	Charset          string  `ask:"--charset" help:"Charset parameter of the content type of responses, e.g. utf-8 (none if empty)"`
+	WrongType        string  `ask:"--wrong-type" help:"Content type of faulty responses, e.g. text/html, or none to leave the header out (no faults if empty)"`
+	WrongProbability float64 `ask:"--wrong-probability" help:"Probability of a response having the wrong content type"`
+	Seed             int64   `ask:"--seed" help:"Seed of the faulty responses (0 for a random seed)"`
+}
+
+// Handler negotiates the content of the requests to the next handler and of its responses.
+func (c *ContentNegotiation) Handler(next http.Handler) http.Handler {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	var mu sync.Mutex
+	wrong := func() bool {
+		if c.WrongType == "" || c.WrongProbability <= 0 {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64() < c.WrongProbability
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			if !c.Gzip {
+				http.Error(w, "gzip request bodies are not supported", http.StatusUnsupportedMediaType)
+				return
+			}
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{body, r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+		if !c.Gzip {
+			// the geth handler stack compresses responses of requests accepting it
+			r.Header.Del("Accept-Encoding")
+		}
+		contentType := ""
+		if wrong() {
+			contentType = c.WrongType
+		}
+		if contentType == "" && c.Charset == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&contentTypeWriter{ResponseWriter: w, contentType: contentType, charset: c.Charset}, r)
+	})
+}
+
+// contentTypeWriter changes the content type of the response before its header is written.
+type contentTypeWriter struct {
+	http.ResponseWriter
+	contentType string
+	charset     string
+	written     bool
+}
+
+func (w *contentTypeWriter) writeContentType() {
+	if w.written {
+		return
+	}
+	w.written = true
+	h := w.Header()
+	switch w.contentType {
+	case "":
+		if mt := h.Get("Content-Type"); mt != "" {
+			if mt, _, err := mime.ParseMediaType(mt); err == nil {
+				h.Set("Content-Type", mime.FormatMediaType(mt, map[string]string{"charset": w.charset}))
+			}
+		}
+	case "none":
+		// a nil value keeps net/http from sniffing the content type
+		h["Content-Type"] = nil
+	default:
+		h.Set("Content-Type", w.contentType)
+	}
+}
+
+func (w *contentTypeWriter) WriteHeader(status int) {
+	w.writeContentType()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *contentTypeWriter) Write(b []byte) (int, error) {
+	w.writeContentType()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *contentTypeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/stretchr/testify/require"
+)
+
+type echoService struct{}
+
+func (echoService) Echo(s string) string { return s }
+
+func TestContentNegotiation(t *testing.T) {
+	rpcSrv, err := NewServer("test", echoService{}, false)
+	require.NoError(t, err)
+	cfg := ContentNegotiation{Gzip: true, WrongProbability: 1}
+	srv := httptest.NewServer(cfg.Handler(node.NewHTTPHandlerStack(rpcSrv, nil, nil, nil)))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	call := func(compressed bool) *http.Response {
+		body := []byte(`{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["hello"]}`)
+		if compressed {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(body)
+			gz.Close()
+			body = buf.Bytes()
+		}
+		req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if compressed {
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	result := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		var r io.Reader = resp.Body
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			r = gz
+		}
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	resp := call(true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Contains(t, result(resp), `"result":"hello"`)
+
+	cfg.Charset = "utf-8"
+	resp = call(false)
+	require.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Contains(t, result(resp), `"result":"hello"`)
+
+	cfg.WrongType = "text/html"
+	resp = call(false)
+	require.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	resp.Body.Close()
+	cfg.WrongType = "none"
+	resp = call(false)
+	require.NotContains(t, resp.Header, "Content-Type")
+	resp.Body.Close()
+
+	// Without gzip, compressed requests are refused and responses left uncompressed.
+	cfg = ContentNegotiation{}
+	resp = call(true)
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	resp.Body.Close()
+	resp = call(false)
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.Contains(t, result(resp), `"result":"hello"`)
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+// HookedCall is an engine API call seen by response hooks: the request, and its response, which hooks rewrite.
+// Responses have either a result or an error: setting the error of a call drops its result.
+type HookedCall struct {
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  json.RawMessage
+}
+
+// ResponseHook rewrites the responses of engine API calls, for faults the fault injector can't express, e.g.
+// flipping a byte of the state root of every 10th payload.
+type ResponseHook interface {
+	Rewrite(call *HookedCall) error
+}
+
+// ResponseHookFunc is a response hook function.
+type ResponseHookFunc func(call *HookedCall) error
+
+func (f ResponseHookFunc) Rewrite(call *HookedCall) error { return f(call) }
+
+// ResponseHooks rewrite the responses of engine API calls, in order.
+type ResponseHooks []ResponseHook
+
+type hookedRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type hookedResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// Handler passes the responses of the next handler to engine API calls through the hooks, single calls and
+// batches alike. Hooks failing to rewrite a response turn it into an internal error. Other requests, e.g. of the eth
+// namespace, are passed through.
+func (h ResponseHooks) Handler(next http.Handler) http.Handler {
+	if len(h) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(&r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(RequestMethod(body), "engine_") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// hooks rewrite plain JSON, compressed again for requests accepting it
+		gzipped := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+		r.Header.Del("Accept-Encoding")
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		out := rec.Body.Bytes()
+		if rewritten, err := h.rewrite(body, out); err == nil {
+			out = rewritten
+		}
+		header := w.Header()
+		for k, v := range rec.Header() {
+			header[k] = v
+		}
+		if gzipped {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(out)
+			zw.Close()
+			out = buf.Bytes()
+			header.Set("Content-Encoding", "gzip")
+		}
+		header.Set("Content-Length", strconv.Itoa(len(out)))
+		w.WriteHeader(rec.Code)
+		w.Write(out)
+	})
+}
+
+// rewrite passes the responses of the body through the hooks, matched to the requests by their ids.
+func (h ResponseHooks) rewrite(request, response []byte) ([]byte, error) {
+	var requests []hookedRequest
+	var responses []*hookedResponse
+	batch := len(bytes.TrimSpace(request)) > 0 && bytes.TrimSpace(request)[0] == '['
+	if batch {
+		if err := json.Unmarshal(request, &requests); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(response, &responses); err != nil {
+			return nil, err
+		}
+	} else {
+		var req hookedRequest
+		var resp hookedResponse
+		if err := json.Unmarshal(request, &req); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return nil, err
+		}
+		requests, responses = []hookedRequest{req}, []*hookedResponse{&resp}
+	}
+	byID := make(map[string]hookedRequest, len(requests))
+	for _, req := range requests {
+		byID[string(req.ID)] = req
+	}
+	for _, resp := range responses {
+		req, ok := byID[string(resp.ID)]
+		if !ok || !strings.HasPrefix(req.Method, "engine_") {
+			continue
+		}
+		call := &HookedCall{Method: req.Method, Params: req.Params, Result: resp.Result, Error: resp.Error}
+		for _, hook := range h {
+			if err := hook.Rewrite(call); err != nil {
+				msg, _ := json.Marshal(fmt.Sprintf("response hook failed: %v", err))
+				call.Error = json.RawMessage(fmt.Sprintf(`{"code":-32603,"message":%s}`, msg))
+				break
+			}
+		}
+		resp.Result, resp.Error = call.Result, call.Error
+		if resp.Error != nil {
+			resp.Result = nil
+		} else if resp.Result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+	}
+	var out []byte
+	var err error
+	if batch {
+		out, err = json.Marshal(responses)
+	} else {
+		out, err = json.Marshal(responses[0])
+	}
+	return append(out, '\n'), err
+}
@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseHooks(t *testing.T) {
+	rpcSrv, err := NewServer("engine", echoService{}, false)
+	require.NoError(t, err)
+	var calls []string
+	hooks := ResponseHooks{
+		ResponseHookFunc(func(call *HookedCall) error {
+			calls = append(calls, call.Method+" "+string(call.Params))
+			var s string
+			if err := json.Unmarshal(call.Result, &s); err != nil {
+				return err
+			}
+			if s == "fail" {
+				return fmt.Errorf("no %s", s)
+			}
+			call.Result, err = json.Marshal(s + "!")
+			return err
+		}),
+		ResponseHookFunc(func(call *HookedCall) error {
+			if string(call.Result) == `"error!"` {
+				call.Error = json.RawMessage(`{"code":-38001,"message":"Unknown payload"}`)
+			}
+			return nil
+		}),
+	}
+	srv := httptest.NewServer(hooks.Handler(node.NewHTTPHandlerStack(rpcSrv, nil, nil, nil)))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	call := func(body string, compressed bool) string {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if compressed {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var r io.Reader = resp.Body
+		if compressed {
+			require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			gz, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			r = gz
+		}
+		out, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(bytes.TrimSpace(out))
+	}
+
+	require.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"hello!"}`, call(`{"jsonrpc":"2.0","id":1,"method":"engine_echo","params":["hello"]}`, false))
+	require.Equal(t, []string{`engine_echo ["hello"]`}, calls)
+	require.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"hello!"}`, call(`{"jsonrpc":"2.0","id":1,"method":"engine_echo","params":["hello"]}`, true), "compressed again")
+	require.Equal(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-38001,"message":"Unknown payload"}}`, call(`{"jsonrpc":"2.0","id":1,"method":"engine_echo","params":["error"]}`, false))
+	require.Equal(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"response hook failed: no fail"}}`, call(`{"jsonrpc":"2.0","id":1,"method":"engine_echo","params":["fail"]}`, false))
+	require.Equal(t, `[{"jsonrpc":"2.0","id":1,"result":"a!"},{"jsonrpc":"2.0","id":2,"result":"b!"}]`,
+		call(`[{"jsonrpc":"2.0","id":1,"method":"engine_echo","params":["a"]},{"jsonrpc":"2.0","id":2,"method":"engine_echo","params":["b"]}]`, false))
+}
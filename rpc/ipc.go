@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ListenIPC listens on the unix domain socket at the path, replacing the socket file left by an earlier run.
+// IPC connections aren't authenticated, so the socket is only accessible by its owner, as in geth.
+// Windows (10 and newer) serves unix sockets too, named pipes aren't supported.
+func ListenIPC(path string) (net.Listener, error) {
+	if runtime.GOOS == "windows" && strings.HasPrefix(path, `\\.\pipe\`) {
+		return nil, fmt.Errorf("named pipe %s is not supported, use a socket file path", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0751); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JwtAuth configures the validation of the JWTs of authenticated requests, with knobs to break it on purpose.
+type JwtAuth struct {
+	HTTP              bool          `ask:"--http" help:"Authenticate the requests of the HTTP server too, not only those of the websocket server"`
+	MaxSkew           time.Duration `ask:"--max-skew" help:"Maximum difference between the issued-at claim of tokens and the server clock, e.g. 100ms to reject slightly skewed tokens"`
+	RequireClaims     []string      `ask:"--require-claim" help:"Claims tokens must carry, e.g. id or clv"`
+	ForbidClaims      []string      `ask:"--forbid-claim" help:"Claims tokens must not carry, e.g. exp"`
+	RejectProbability float64       `ask:"--reject-probability" help:"Probability of answering 401 to a request with a valid token"`
+	Seed              int64         `ask:"--seed" help:"Seed of the rejected requests (0 for a random seed)"`
+}
+
+// NewAuthenticator returns the authenticator validating tokens signed with the secret, by the config.
+func (c *JwtAuth) NewAuthenticator(secret []byte) *Authenticator {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Authenticator{cfg: *c, secret: secret, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Authenticator validates the JWTs of requests like geth does, with the skew tolerance and claims of the config,
+// and rejects a share of the valid ones. Its secret can be rotated at runtime.
+type Authenticator struct {
+	cfg JwtAuth
+
+	mu     sync.Mutex
+	secret []byte
+	rng    *rand.Rand
+}
+
+// Secret returns the secret tokens must be signed with.
+func (a *Authenticator) Secret() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.secret
+}
+
+// SetSecret rotates the secret, tokens signed with the previous one are rejected from then on.
+func (a *Authenticator) SetSecret(secret []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secret = secret
+}
+
+func (a *Authenticator) reject() bool {
+	if a.cfg.RejectProbability <= 0 {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rng.Float64() < a.cfg.RejectProbability
+}
+
+// validate returns why the token of the request is rejected, empty if it is valid.
+func (a *Authenticator) validate(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || len(auth) == len("Bearer ") {
+		return "missing token"
+	}
+	secret := a.Secret()
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithoutClaimsValidation())
+	switch {
+	case err != nil:
+		return err.Error()
+	case !token.Valid:
+		return "invalid token"
+	case !claims.VerifyExpiresAt(time.Now().Unix(), false):
+		return "token is expired"
+	}
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return "missing issued-at"
+	}
+	skew := time.Until(time.Unix(int64(iat), 0))
+	switch {
+	case skew < -a.cfg.MaxSkew:
+		return "stale token"
+	case skew > a.cfg.MaxSkew:
+		return "future token"
+	}
+	for _, claim := range a.cfg.RequireClaims {
+		if _, ok := claims[claim]; !ok {
+			return "missing " + claim + " claim"
+		}
+	}
+	for _, claim := range a.cfg.ForbidClaims {
+		if _, ok := claims[claim]; ok {
+			return "forbidden " + claim + " claim"
+		}
+	}
+	return ""
+}
+
+// Handler authenticates the requests to the next handler. Invalid tokens are answered with 403 like geth does,
+// the rejected share of valid tokens with 401.
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := a.validate(r); reason != "" {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		if a.reject() {
+			http.Error(w, "rejected token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator(t *testing.T) {
+	secret := make([]byte, 32)
+	cfg := JwtAuth{MaxSkew: time.Second, RequireClaims: []string{"id"}, ForbidClaims: []string{"exp"}}
+	auth := cfg.NewAuthenticator(secret)
+	srv := httptest.NewServer(auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer srv.Close()
+	status := func(key []byte, claims jwt.MapClaims) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		if claims != nil {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", EncodeJwtAuthorization(token))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	now := time.Now().Unix()
+
+	require.Equal(t, http.StatusOK, status(secret, jwt.MapClaims{"iat": now, "id": "cl"}))
+	require.Equal(t, http.StatusForbidden, status(secret, nil), "missing token")
+	require.Equal(t, http.StatusForbidden, status(secret, jwt.MapClaims{"iat": now - 3, "id": "cl"}), "stale beyond the skew")
+	require.Equal(t, http.StatusForbidden, status(secret, jwt.MapClaims{"iat": now + 3, "id": "cl"}), "future beyond the skew")
+	require.Equal(t, http.StatusForbidden, status(secret, jwt.MapClaims{"iat": now}), "required claim missing")
+	require.Equal(t, http.StatusForbidden, status(secret, jwt.MapClaims{"iat": now, "id": "cl", "exp": now + 60}), "forbidden claim")
+
+	// Rotating the secret rejects tokens signed with the previous one.
+	rotated := append([]byte{0x01}, secret[1:]...)
+	auth.SetSecret(rotated)
+	require.Equal(t, http.StatusForbidden, status(secret, jwt.MapClaims{"iat": now, "id": "cl"}))
+	require.Equal(t, http.StatusOK, status(rotated, jwt.MapClaims{"iat": now, "id": "cl"}))
+
+	auth.cfg.RejectProbability = 1
+	require.Equal(t, http.StatusUnauthorized, status(rotated, jwt.MapClaims{"iat": now, "id": "cl"}))
+}
@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit configures limits on the requests and connections of the servers, to test how consensus clients back
+// off when their execution engine is overloaded.
+type RateLimit struct {
+	Rate       float64       `ask:"--rate" help:"Requests per second accepted from all clients together, answering 429 above (0 for no limit)"`
+	ClientRate float64       `ask:"--client-rate" help:"Requests per second accepted from each client, by the id claim of its JWT or else its IP address, answering 429 above (0 for no limit)"`
+	Burst      int           `ask:"--burst" help:"Requests accepted at once on top of the rates, per limit"`
+	RetryAfter time.Duration `ask:"--retry-after" help:"Retry-After of 429 responses, rounded up to seconds (header left out if 0)"`
+	MaxConns   int           `ask:"--max-conns" help:"Concurrent HTTP connections accepted, resetting further connections (0 for no limit)"`
+	MaxWSConns int           `ask:"--max-ws-conns" help:"Concurrent websocket connections accepted, resetting further connections (0 for no limit)"`
+}
+
+// Enabled reports whether any request or connection is limited.
+func (c *RateLimit) Enabled() bool {
+	return c.Rate > 0 || c.ClientRate > 0 || c.MaxConns > 0 || c.MaxWSConns > 0
+}
+
+// Handler answers requests above the rates with 429 Too Many Requests, and passes the others to the next handler.
+func (c *RateLimit) Handler(next http.Handler) http.Handler {
+	if c.Rate <= 0 && c.ClientRate <= 0 {
+		return next
+	}
+	burst := float64(c.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	var (
+		mu      sync.Mutex
+		global  = &tokenBucket{rate: c.Rate, burst: burst, tokens: burst}
+		clients = make(map[string]*tokenBucket)
+	)
+	allow := func(client string, now time.Time) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if c.ClientRate > 0 {
+			b, ok := clients[client]
+			if !ok {
+				b = &tokenBucket{rate: c.ClientRate, burst: burst, tokens: burst}
+				clients[client] = b
+			}
+			if !b.take(now) {
+				return false
+			}
+		}
+		return c.Rate <= 0 || global.take(now)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := tokenClientID(r)
+		if client == "" {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				client = host
+			} else {
+				client = r.RemoteAddr
+			}
+		}
+		if !allow(client, time.Now()) {
+			if c.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(c.RetryAfter.Seconds()))))
+			}
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenBucket accepts rate events per second on average, and burst at once.
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Listener limits the concurrent connections of the HTTP server listener to the configured maximum.
+func (c *RateLimit) Listener(l net.Listener) net.Listener {
+	return limitListener(l, c.MaxConns)
+}
+
+// WSListener limits the concurrent connections of the websocket server listener to the configured maximum.
+func (c *RateLimit) WSListener(l net.Listener) net.Listener {
+	return limitListener(l, c.MaxWSConns)
+}
+
+func limitListener(l net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return l
+	}
+	return &connLimitListener{Listener: l, max: int64(max)}
+}
+
+// connLimitListener resets connections accepted above the maximum number of open connections, like an overloaded
+// server whose accept queue overflows.
+type connLimitListener struct {
+	net.Listener
+	max  int64
+	open int64
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if atomic.AddInt64(&l.open, 1) > l.max {
+			atomic.AddInt64(&l.open, -1)
+			resetOnClose(conn)
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { atomic.AddInt64(&l.open, -1) }}, nil
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// resetOnClose makes closing the connection send a reset instead of a graceful close.
+func resetOnClose(conn net.Conn) {
+	if limited, ok := conn.(*limitedConn); ok {
+		conn = limited.Conn
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+}
@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	cfg := RateLimit{ClientRate: 0.001, Burst: 2, RetryAfter: 1500 * time.Millisecond}
+	srv := httptest.NewServer(cfg.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer srv.Close()
+	call := func(id string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		if id != "" {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": id}).SignedString([]byte("secret"))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	require.Equal(t, http.StatusOK, call("").StatusCode)
+	require.Equal(t, http.StatusOK, call("").StatusCode)
+	limited := call("")
+	require.Equal(t, http.StatusTooManyRequests, limited.StatusCode)
+	require.Equal(t, "2", limited.Header.Get("Retry-After"))
+	// Clients identified by their JWT have limits of their own.
+	require.Equal(t, http.StatusOK, call("lighthouse").StatusCode)
+
+	// The global rate limits all clients together.
+	cfg = RateLimit{Rate: 0.001, Burst: 1}
+	global := httptest.NewServer(cfg.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer global.Close()
+	resp, err := http.Get(global.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(global.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestConnectionLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cfg := RateLimit{MaxConns: 1}
+	limited := cfg.Listener(l)
+	defer limited.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	held := <-accepted
+
+	// Connections above the limit are reset.
+	second, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "timeout")
+
+	// Closing a connection frees its place.
+	held.Close()
+	third, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted after another closed")
+	}
+}
@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TrafficRecord struct {
+	Path string `ask:"--path" help:"JSONL file to append every engine API request and its response to, with timestamps, to replay with the replay command (disabled if empty)"`
+}
+
+// RecordedExchange is an engine API request, and the response it got.
+type RecordedExchange struct {
+	Time      time.Time       `json:"time"`
+	Responded time.Time       `json:"responded"`
+	Method    string          `json:"method"`
+	Request   json.RawMessage `json:"request"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// NewRecorder opens the recording file, appending to it, or returns nil if recording is disabled.
+func (c *TrafficRecord) NewRecorder() (*Recorder, error) {
+	if c.Path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open traffic recording: %v", err)
+	}
+	return &Recorder{f: f}, nil
+}
+
+// Recorder writes the engine API exchanges of the HTTP handler to a JSONL file.
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Handler records the engine API requests of the next handler and their responses. Other requests, e.g. of the
+// eth namespace, are passed through without being recorded.
+func (r *Recorder) Handler(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readBody(&req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		method := RequestMethod(body)
+		if !strings.HasPrefix(method, "engine_") {
+			next.ServeHTTP(w, req)
+			return
+		}
+		received := time.Now().UTC()
+		rec := &wireRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		response := rec.body.Bytes()
+		if strings.EqualFold(w.Header().Get("Content-Encoding"), "gzip") {
+			if zr, err := gzip.NewReader(bytes.NewReader(response)); err == nil {
+				if plain, err := io.ReadAll(zr); err == nil {
+					response = plain
+				}
+			}
+		}
+		r.record(RecordedExchange{
+			Time:      received,
+			Responded: time.Now().UTC(),
+			Method:    method,
+			Request:   compact(body),
+			Response:  compact(response),
+		})
+	})
+}
+
+func (r *Recorder) record(ex RecordedExchange) {
+	line, err := json.Marshal(ex)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Write(append(line, '\n'))
+}
+
+// Close closes the recording file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// RequestMethod returns the method of a JSON-RPC request, or of the first request of a batch.
+func RequestMethod(body []byte) string {
+	var msg struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &msg) == nil {
+		return msg.Method
+	}
+	var batch []struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &batch) == nil && len(batch) > 0 {
+		return batch[0].Method
+	}
+	return ""
+}
+
+// compact removes the insignificant space of a JSON body, bodies that aren't JSON are kept as a JSON string.
+func compact(body []byte) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		quoted, _ := json.Marshal(string(body))
+		return quoted
+	}
+	return buf.Bytes()
+}
+
+// ReadRecording reads the exchanges of a recording, in order.
+func ReadRecording(path string) ([]RecordedExchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var exchanges []RecordedExchange
+	scanner := bufio.NewScanner(f)
+	// payloads with many transactions make for long lines
+	scanner.Buffer(make([]byte, 0, 1<<20), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var ex RecordedExchange
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("invalid exchange on line %d of %s: %v", line, path, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, scanner.Err()
+}
@@ -73,8 +73,8 @@ func NewHTTPServer(ctx context.Context, log logrus.Ext1FieldLogger, rpcSrv *Serv
 	}
 }
 
-func NewWSServer(ctx context.Context, log logrus.Ext1FieldLogger, rpcSrv *Server, addr string, jwt []byte, timeout Timeout, cors []string) *http.Server {
-	wsHandler := node.NewWSHandlerStack(rpcSrv.WebsocketHandler(cors), jwt)
+func NewWSServer(ctx context.Context, log logrus.Ext1FieldLogger, rpcSrv *Server, addr string, auth *Authenticator, timeout Timeout, cors []string) *http.Server {
+	wsHandler := auth.Handler(rpcSrv.WebsocketHandler(cors))
 	wsMux := http.NewServeMux()
 	wsMux.Handle("/", wsHandler)
 	wsMux.Handle("/ws", wsHandler)
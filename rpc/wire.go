@@ -0,0 +1,294 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type WireLog struct {
+	Enable   bool   `ask:"--enable" help:"Log the headers and bodies of HTTP requests and responses, with JWTs redacted"`
+	Level    string `ask:"--level" help:"Level to log the traffic at, e.g. trace or debug"`
+	File     string `ask:"--file" help:"File to append the traffic to instead of the main log, each body on its own lines (enables the wire log, disabled if empty)"`
+	Pretty   bool   `ask:"--pretty" help:"Pretty-print JSON bodies, best read in the wire log file"`
+	MaxField int    `ask:"--max-field" help:"Length beyond which string fields of logged bodies, e.g. transactions and blooms, are truncated (0 to log them in full)"`
+	MaxBody  int    `ask:"--max-body" help:"Length beyond which logged bodies are truncated as a whole, after their fields are (0 to log them in full)"`
+
+	opened bool
+	level  logrus.Level
+	file   *os.File
+	out    *logrus.Logger
+}
+
+func (c *WireLog) Default() {
+	c.Level = "trace"
+	c.MaxField = 256
+}
+
+// Enabled returns whether the traffic is logged, to the main log or a file.
+func (c *WireLog) Enabled() bool {
+	return c.Enable || c.File != ""
+}
+
+// Open checks the log level and opens the file the traffic is logged to, if any. Copies of the config opened
+// before share the file, and opening it again is a no-op.
+func (c *WireLog) Open() error {
+	if !c.Enabled() || c.opened {
+		return nil
+	}
+	if c.Level == "" {
+		c.Level = "trace"
+	}
+	level, err := logrus.ParseLevel(c.Level)
+	if err != nil {
+		return fmt.Errorf("invalid wire log level: %v", err)
+	}
+	if c.File != "" {
+		if c.file, err = os.OpenFile(c.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+			return fmt.Errorf("failed to open wire log: %v", err)
+		}
+		c.out = logrus.New()
+		c.out.SetLevel(logrus.TraceLevel)
+		c.out.SetOutput(c.file)
+		c.out.SetFormatter(wireFormatter{})
+	}
+	c.opened, c.level = true, level
+	return nil
+}
+
+// Close closes the file the traffic is logged to, if any.
+func (c *WireLog) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// logger returns the logger the traffic is logged to, the one of the file if any, and the level to log it at.
+// Without Open, it's the given logger at trace level.
+func (c *WireLog) logger(log logrus.Ext1FieldLogger) (logrus.Ext1FieldLogger, logrus.Level) {
+	if !c.opened {
+		return log.WithField("type", "wire"), logrus.TraceLevel
+	}
+	if c.out != nil {
+		log = c.out
+	}
+	return log.WithField("type", "wire"), c.level
+}
+
+// Handler logs the requests of the HTTP handler and its responses, if enabled.
+func (c *WireLog) Handler(next http.Handler, log logrus.Ext1FieldLogger) http.Handler {
+	if !c.Enabled() {
+		return next
+	}
+	wlog, level := c.logger(log)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(&r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wlog.WithFields(logrus.Fields{
+			"method":  r.Method,
+			"path":    r.URL.RequestURI(),
+			"headers": redactHeaders(r.Header),
+			"body":    c.summarize(body),
+		}).Log(level, "Wire request received")
+		rec := &wireRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		wlog.WithFields(logrus.Fields{
+			"path":    r.URL.RequestURI(),
+			"status":  rec.status,
+			"headers": redactHeaders(w.Header()),
+			"body":    c.summarize(rec.body.Bytes()),
+		}).Log(level, "Wire response sent")
+	})
+}
+
+// Transport logs the requests of the HTTP client transport and their responses, if enabled.
+func (c *WireLog) Transport(next http.RoundTripper, log logrus.Ext1FieldLogger) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if !c.Enabled() {
+		return next
+	}
+	wlog, level := c.logger(log)
+	return &wireTransport{cfg: c, next: next, log: wlog, level: level}
+}
+
+type wireTransport struct {
+	cfg   *WireLog
+	next  http.RoundTripper
+	log   logrus.Ext1FieldLogger
+	level logrus.Level
+}
+
+func (t *wireTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = readBody(&req.Body); err != nil {
+			return nil, err
+		}
+	}
+	t.log.WithFields(logrus.Fields{
+		"method":  req.Method,
+		"url":     req.URL.String(),
+		"headers": redactHeaders(req.Header),
+		"body":    t.cfg.summarize(body),
+	}).Log(t.level, "Wire request sent")
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.log.WithField("url", req.URL.String()).WithError(err).Log(t.level, "Wire request failed")
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	t.log.WithFields(logrus.Fields{
+		"url":     req.URL.String(),
+		"status":  resp.StatusCode,
+		"headers": redactHeaders(resp.Header),
+		"body":    t.cfg.summarize(respBody),
+	}).Log(t.level, "Wire response received")
+	return resp, nil
+}
+
+// readBody reads the body in full, and replaces it with a reader of the read bytes.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, err
+}
+
+type wireRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *wireRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *wireRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *wireRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// redactHeaders formats the headers, without the credentials of the Authorization header.
+func redactHeaders(h http.Header) string {
+	var b strings.Builder
+	for name, values := range h {
+		for _, v := range values {
+			if http.CanonicalHeaderKey(name) == "Authorization" {
+				if scheme, _, ok := strings.Cut(v, " "); ok {
+					v = scheme + " [redacted]"
+				} else {
+					v = "[redacted]"
+				}
+			}
+			if b.Len() > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s: %s", name, v)
+		}
+	}
+	return b.String()
+}
+
+// summarize compacts or pretty-prints a JSON body and truncates its long string fields, or truncates the body as a
+// whole if it isn't JSON. The result is truncated to the maximum body length.
+func (c *WireLog) summarize(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return truncate(c.truncate(string(body)), c.MaxBody)
+	}
+	var out []byte
+	var err error
+	if c.Pretty {
+		out, err = json.MarshalIndent(c.truncateFields(v), "", "  ")
+	} else {
+		out, err = json.Marshal(c.truncateFields(v))
+	}
+	if err != nil {
+		return truncate(c.truncate(string(body)), c.MaxBody)
+	}
+	return truncate(string(out), c.MaxBody)
+}
+
+func (c *WireLog) truncateFields(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return c.truncate(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = c.truncateFields(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = c.truncateFields(v[k])
+		}
+	}
+	return v
+}
+
+func (c *WireLog) truncate(s string) string {
+	return truncate(s, c.MaxField)
+}
+
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s...(%d chars)", s[:max], len(s))
+}
+
+// wireFormatter formats the entries of the wire log file: a line with the time, message and fields, followed by
+// the body on its own lines, so that pretty-printed bodies stay readable.
+type wireFormatter struct{}
+
+func (wireFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %s", e.Time.UTC().Format(time.RFC3339Nano), strings.ToUpper(e.Level.String()), e.Message)
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		if k != "body" && k != "type" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, fmt.Sprint(e.Data[k]))
+	}
+	b.WriteByte('\n')
+	if body, ok := e.Data["body"].(string); ok && body != "" {
+		b.WriteString(body)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
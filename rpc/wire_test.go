@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireLog(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	log.SetLevel(logrus.TraceLevel)
+	wire := &WireLog{Enable: true, MaxField: 8}
+	srv := httptest.NewServer(wire.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{"params": ["0x0123456789abcdef"]}`, string(body), "body is passed on in full")
+		w.Write([]byte(`{"result":"0x00000000000000000000"}`))
+	}), log))
+	defer srv.Close()
+
+	client := &http.Client{Transport: wire.Transport(nil, log)}
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"params": ["0x0123456789abcdef"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret.jwt.token")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"result":"0x00000000000000000000"}`, string(body), "body is passed on in full")
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 4)
+	for _, e := range entries {
+		require.Equal(t, logrus.TraceLevel, e.Level)
+		require.NotContains(t, e.Data["headers"], "secret")
+	}
+	require.Equal(t, "Wire request sent", entries[0].Message)
+	require.Contains(t, entries[0].Data["headers"], "Authorization: Bearer [redacted]")
+	require.Equal(t, `{"params":["0x012345...(18 chars)"]}`, entries[0].Data["body"])
+	require.Equal(t, "Wire request received", entries[1].Message)
+	require.Equal(t, `{"params":["0x012345...(18 chars)"]}`, entries[1].Data["body"])
+	require.Equal(t, "Wire response sent", entries[2].Message)
+	require.Equal(t, `{"result":"0x000000...(22 chars)"}`, entries[2].Data["body"])
+	require.Equal(t, "Wire response received", entries[3].Message)
+	require.Equal(t, http.StatusOK, entries[3].Data["status"])
+
+	require.Equal(t, "not json...(12 chars)", wire.summarize([]byte("not json ok!")))
+}
+
+func TestWireLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wire.log")
+	log, hook := logtest.NewNullLogger()
+	wire := &WireLog{File: path, Level: "debug", Pretty: true, MaxField: 8, MaxBody: 60}
+	require.NoError(t, wire.Open())
+	copied := *wire
+	require.NoError(t, copied.Open(), "opening a copy again is a no-op")
+	srv := httptest.NewServer(copied.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"status":"VALID","latestValidHash":"0x0123456789abcdef"}}`))
+	}), log))
+	defer srv.Close()
+
+	client := &http.Client{Transport: wire.Transport(nil, log)}
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"id":1,"method":"engine_newPayloadV1"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret.jwt.token")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, wire.Close())
+	require.Empty(t, hook.AllEntries(), "nothing is logged to the main log")
+
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	entries := strings.Split(strings.TrimSuffix(string(out), "\n\n"), "\n\n")
+	require.Len(t, entries, 4)
+	require.NotContains(t, string(out), "secret")
+	lines := strings.Split(entries[0], "\n")
+	require.Contains(t, lines[0], "DEBUG Wire request sent")
+	require.Contains(t, lines[0], `headers="Authorization: Bearer [redacted]`)
+	require.Equal(t, []string{"{", `  "id": 1,`, `  "method": "engine_n...(19 chars)"`, "}"}, lines[1:])
+	require.Contains(t, entries[2], "DEBUG Wire response sent")
+	require.Regexp(t, `(?s)\n\{\n  "id": 1,\n.*\.\.\.\(\d+ chars\)$`, entries[2], "body truncated as a whole")
+
+	require.Error(t, (&WireLog{Enable: true, Level: "loud"}).Open())
+}
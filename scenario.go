@@ -0,0 +1,166 @@
+package mergemock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mergemock/types"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scenario behaviors. All but reorg replace the response of the engine, like faults do.
+const (
+	ScenarioValid    = "valid"    // respond normally
+	ScenarioInvalid  = "invalid"  // respond with an INVALID payload status
+	ScenarioSyncing  = "syncing"  // respond with a SYNCING payload status
+	ScenarioAccepted = "accepted" // respond with an ACCEPTED payload status
+	ScenarioDelay    = "delay"    // respond normally after the delay of the step
+	ScenarioReorg    = "reorg"    // build the payload on the ancestor depth blocks below the head, forkchoiceUpdated only
+)
+
+// ScenarioStep is the behavior of the engine at a slot.
+type ScenarioStep struct {
+	Slot uint64 `json:"slot"`
+	// Method is the engine method the step applies to, newPayload or forkchoiceUpdated of any version,
+	// both if empty.
+	Method   string   `json:"method,omitempty"`
+	Behavior string   `json:"behavior"`
+	Delay    Duration `json:"delay,omitempty"`
+	Depth    uint64   `json:"depth,omitempty"`
+}
+
+// Scenario is a script of the behavior of the engine per slot, to reproduce multi-slot test cases.
+// The slot of a call is that of the payload timestamp: of the payload of newPayload, and of the payload
+// to build of forkchoiceUpdated, or its head block without attributes. Slots without steps behave normally.
+type Scenario struct {
+	// GenesisTime is the time of slot 0, the timestamp of the genesis block if nil.
+	GenesisTime *uint64        `json:"genesisTime,omitempty"`
+	SlotTime    Duration       `json:"slotTime,omitempty"`
+	Steps       []ScenarioStep `json:"steps"`
+
+	// Slots is the number of slots the scenario runner proposes in, up to the last step if 0.
+	Slots uint64 `json:"slots,omitempty"`
+	// Expect is what the scenario runner checks after the last slot.
+	Expect *ScenarioExpect `json:"expect,omitempty"`
+}
+
+// ScenarioExpect is the outcome of a scenario run.
+type ScenarioExpect struct {
+	// HeadNumber is the number of the head block after the last slot.
+	HeadNumber *uint64 `json:"headNumber,omitempty"`
+}
+
+// LoadScenario reads a JSON scenario from the path, or the scenario itself if the path is JSON, whose slots start at the genesis time unless it sets its own.
+func LoadScenario(path string, genesisTime uint64) (*Scenario, error) {
+	var (
+		buf []byte
+		err error
+	)
+	if inline := strings.TrimSpace(path); strings.HasPrefix(inline, "{") {
+		// config files inline the scenario
+		buf = []byte(inline)
+	} else if buf, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+	scenario := new(Scenario)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	if scenario.GenesisTime == nil {
+		scenario.GenesisTime = &genesisTime
+	}
+	if scenario.SlotTime == 0 {
+		scenario.SlotTime = Duration(12 * time.Second)
+	}
+	if scenario.Slots == 0 {
+		for _, step := range scenario.Steps {
+			if step.Slot > scenario.Slots {
+				scenario.Slots = step.Slot
+			}
+		}
+	}
+	return scenario, scenario.validate()
+}
+
+func (s *Scenario) validate() error {
+	if time.Duration(s.SlotTime) < time.Second {
+		return fmt.Errorf("slot time %s of scenario is shorter than a second", time.Duration(s.SlotTime))
+	}
+	seen := make(map[string]bool)
+	for i, step := range s.Steps {
+		switch step.Method {
+		case "", "newPayload", "forkchoiceUpdated":
+		default:
+			return fmt.Errorf("step %d: unknown method %q, expected newPayload or forkchoiceUpdated", i, step.Method)
+		}
+		switch step.Behavior {
+		case ScenarioValid, ScenarioInvalid, ScenarioSyncing, ScenarioAccepted:
+		case ScenarioDelay:
+			if step.Delay <= 0 {
+				return fmt.Errorf("step %d: missing delay of delay behavior", i)
+			}
+		case ScenarioReorg:
+			if step.Method != "forkchoiceUpdated" {
+				return fmt.Errorf("step %d: reorgs only apply to forkchoiceUpdated", i)
+			}
+			if step.Depth == 0 {
+				return fmt.Errorf("step %d: missing depth of reorg", i)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown behavior %q", i, step.Behavior)
+		}
+		for _, method := range []string{"newPayload", "forkchoiceUpdated"} {
+			if step.Method != "" && step.Method != method {
+				continue
+			}
+			key := fmt.Sprintf("%d/%s", step.Slot, method)
+			if seen[key] {
+				return fmt.Errorf("step %d: more than one step for %s at slot %d", i, method, step.Slot)
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
+
+// Timestamp returns the timestamp of the slot.
+func (s *Scenario) Timestamp(slot uint64) uint64 {
+	return *s.GenesisTime + slot*uint64(time.Duration(s.SlotTime)/time.Second)
+}
+
+// Step returns the step of the call to the engine method at the timestamp, nil if there is none.
+func (s *Scenario) Step(method string, timestamp uint64) *ScenarioStep {
+	if s == nil || timestamp < *s.GenesisTime {
+		return nil
+	}
+	if !strings.HasPrefix(method, "engine_newPayload") && !strings.HasPrefix(method, "engine_forkchoiceUpdated") {
+		return nil
+	}
+	slot := (timestamp - *s.GenesisTime) / uint64(time.Duration(s.SlotTime)/time.Second)
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Slot == slot && (step.Method == "" || strings.HasPrefix(method, "engine_"+step.Method)) {
+			return step
+		}
+	}
+	return nil
+}
+
+// rule returns the fault the step injects into the response, nil if the response is left alone.
+func (s *ScenarioStep) rule() *FaultRule {
+	switch s.Behavior {
+	case ScenarioInvalid:
+		return &FaultRule{Action: FaultStatus, Status: types.ExecutionInvalid}
+	case ScenarioSyncing:
+		return &FaultRule{Action: FaultStatus, Status: types.ExecutionSyncing}
+	case ScenarioAccepted:
+		return &FaultRule{Action: FaultStatus, Status: types.ExecutionAccepted}
+	case ScenarioDelay:
+		return &FaultRule{Action: FaultTimeout, Delay: s.Delay}
+	}
+	return nil
+}
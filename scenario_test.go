@@ -0,0 +1,81 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func writeScenario(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadScenario(t *testing.T) {
+	scenario, err := LoadScenario(writeScenario(t, `{"steps": [
+		{"slot": 1, "method": "newPayload", "behavior": "invalid"},
+		{"slot": 1, "method": "forkchoiceUpdated", "behavior": "delay", "delay": "2s"},
+		{"slot": 3, "behavior": "syncing"}
+	]}`), 100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), *scenario.GenesisTime)
+	require.Equal(t, Duration(12*time.Second), scenario.SlotTime)
+
+	require.Nil(t, scenario.Step("engine_newPayloadV1", 100))
+	require.Equal(t, ScenarioInvalid, scenario.Step("engine_newPayloadV2", 112).Behavior)
+	require.Equal(t, ScenarioDelay, scenario.Step("engine_forkchoiceUpdatedV1", 123).Behavior)
+	require.Equal(t, ScenarioSyncing, scenario.Step("engine_forkchoiceUpdatedV3", 136).Behavior)
+	require.Nil(t, scenario.Step("engine_getPayloadV1", 136))
+	require.Nil(t, scenario.Step("engine_newPayloadV1", 50))
+
+	for _, invalid := range []string{
+		`{"steps": [{"slot": 1, "behavior": "explode"}]}`,
+		`{"steps": [{"slot": 1, "method": "getPayload", "behavior": "valid"}]}`,
+		`{"steps": [{"slot": 1, "behavior": "delay"}]}`,
+		`{"steps": [{"slot": 1, "behavior": "reorg", "depth": 1}]}`,
+		`{"steps": [{"slot": 1, "method": "forkchoiceUpdated", "behavior": "reorg"}]}`,
+		`{"steps": [{"slot": 1, "behavior": "valid"}, {"slot": 1, "method": "newPayload", "behavior": "invalid"}]}`,
+		`{"slotTime": "500ms", "steps": []}`,
+		`{"step": []}`,
+	} {
+		_, err := LoadScenario(writeScenario(t, invalid), 100)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestEngineScenario(t *testing.T) {
+	path := writeScenario(t, `{"steps": [
+		{"slot": 1, "method": "newPayload", "behavior": "invalid"},
+		{"slot": 2, "method": "newPayload", "behavior": "syncing"},
+		{"slot": 3, "method": "forkchoiceUpdated", "behavior": "reorg", "depth": 1}
+	]}`)
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.ScenarioPath = path
+	})
+	genesis := te.mockChain().CurrentHeader()
+
+	a := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+	require.Equal(t, types.ExecutionInvalid, te.newPayload(t, a))
+	b := te.buildPayload(t, a.BlockHash, genesis.Time+24, common.Hash{0x02})
+	require.Equal(t, types.ExecutionSyncing, te.newPayload(t, b))
+	// The steps only replace the responses, the payloads were imported.
+	te.requireKnownBlock(t, b.BlockHash)
+
+	ctx := context.Background()
+	attributes := &types.PayloadAttributesV1{Timestamp: genesis.Time + 36, SuggestedFeeRecipient: common.Address{0x02}}
+	result, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, b.BlockHash, genesis.Hash(), genesis.Hash(), attributes)
+	require.NoError(t, err)
+	require.NotNil(t, result.PayloadID)
+	c, err := api.GetPayloadV1(ctx, te.client, te.log, *result.PayloadID)
+	require.NoError(t, err)
+	require.Equal(t, a.BlockHash, c.ParentHash, "reorg of depth 1 builds on the parent of the head")
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, c))
+}
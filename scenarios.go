@@ -0,0 +1,281 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mergemock/rpc"
+	"mergemock/types"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+type ScenariosCmd struct {
+	Parallel   int      `ask:"--parallel" help:"Number of engine instances running scenarios concurrently"`
+	ReportPath string   `ask:"--report" help:"File to write the JSON results to (empty for log output only)"`
+	Forks      []string `ask:"--fork" help:"Forks to run every scenario in, active from genesis and called with the engine API methods of their version: paris (V1), shanghai (V2), cancun (V3) or prague (V4), for a pass/fail matrix of scenario by fork (empty for the fork schedule of the engine)"`
+
+	Engine EngineCmd `ask:".engine" help:"Configure the engine instances, whose addresses, data directory and scenario are set per instance"`
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log logrus.Ext1FieldLogger
+}
+
+func (c *ScenariosCmd) Default() {
+	c.Parallel = runtime.NumCPU()
+}
+
+func (c *ScenariosCmd) Help() string {
+	return "Run scenario files, each on a fresh in-process engine, sharded across concurrent instances: mergemock scenarios [flags] <scenario.json>..."
+}
+
+// ScenarioSlotResult is what happened to the proposal of a slot.
+type ScenarioSlotResult struct {
+	Slot             uint64                     `json:"slot"`
+	ForkchoiceStatus types.ExecutePayloadStatus `json:"forkchoiceStatus,omitempty"`
+	PayloadStatus    types.ExecutePayloadStatus `json:"payloadStatus,omitempty"`
+	Head             common.Hash                `json:"head"`
+	Error            string                     `json:"error,omitempty"`
+}
+
+type ScenarioResult struct {
+	Path       string               `json:"path"`
+	Fork       string               `json:"fork,omitempty"`
+	Shard      int                  `json:"shard"`
+	Passed     bool                 `json:"passed"`
+	Duration   string               `json:"duration"`
+	HeadNumber uint64               `json:"headNumber"`
+	Failures   []string             `json:"failures"`
+	Slots      []ScenarioSlotResult `json:"slots"`
+}
+
+type ScenariosReport struct {
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Passed   int              `json:"passed"`
+	Failed   int              `json:"failed"`
+	Results  []ScenarioResult `json:"results"`
+	// Matrix is whether each scenario passed, by path and fork, when run in several forks.
+	Matrix map[string]map[string]bool `json:"matrix,omitempty"`
+}
+
+func (c *ScenariosCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+	if len(args) == 0 {
+		return fmt.Errorf("no scenario files given")
+	}
+	if c.Parallel < 1 {
+		return fmt.Errorf("parallelism must be at least 1")
+	}
+	// Engines exit on invalid scenarios, so they are checked up front.
+	for _, path := range args {
+		if _, err := LoadScenario(path, 0); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	for _, fork := range c.Forks {
+		if err := activateFork(new(ForkTimesConfig), fork); err != nil {
+			return err
+		}
+	}
+	// All instances share the secret, which must exist before they start.
+	if _, _, err := loadJwtSecret(c.Engine.JwtSecretPath); err != nil {
+		return fmt.Errorf("unable to read JWT secret: %v", err)
+	}
+
+	// Every scenario runs once per fork, or once in the fork schedule of the engine.
+	forks := c.Forks
+	if len(forks) == 0 {
+		forks = []string{""}
+	}
+	type job struct{ path, fork string }
+	var jobs []job
+	for _, path := range args {
+		for _, fork := range forks {
+			jobs = append(jobs, job{path, fork})
+		}
+	}
+
+	report := &ScenariosReport{Started: time.Now(), Results: make([]ScenarioResult, len(jobs))}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for shard := 0; shard < c.Parallel && shard < len(jobs); shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			for i := range work {
+				report.Results[i] = c.runScenario(ctx, shard, jobs[i].path, jobs[i].fork)
+			}
+		}(shard)
+	}
+	for i := range jobs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	report.Finished = time.Now()
+
+	if len(c.Forks) > 0 {
+		report.Matrix = make(map[string]map[string]bool)
+	}
+	for _, result := range report.Results {
+		fields := logrus.Fields{"scenario": result.Path, "shard": result.Shard, "duration": result.Duration}
+		if result.Fork != "" {
+			fields["fork"] = result.Fork
+			if report.Matrix[result.Path] == nil {
+				report.Matrix[result.Path] = make(map[string]bool)
+			}
+			report.Matrix[result.Path][result.Fork] = result.Passed
+		}
+		if result.Passed {
+			report.Passed++
+			c.log.WithFields(fields).Info("Scenario passed")
+		} else {
+			report.Failed++
+			c.log.WithFields(fields).WithField("failures", result.Failures).Error("Scenario failed")
+		}
+	}
+	c.log.WithFields(logrus.Fields{
+		"passed":   report.Passed,
+		"failed":   report.Failed,
+		"duration": report.Finished.Sub(report.Started),
+	}).Info("Scenarios finished")
+	if c.ReportPath != "" {
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.ReportPath, buf, 0644); err != nil {
+			return fmt.Errorf("failed to write scenario report: %v", err)
+		}
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d scenario runs failed", report.Failed, len(jobs))
+	}
+	return nil
+}
+
+// runScenario starts a fresh engine with the scenario, and proposes in its slots like a consensus client would. A
+// fork, if set, is active from genesis on instead of the fork schedule of the engine.
+func (c *ScenariosCmd) runScenario(ctx context.Context, shard int, path, fork string) ScenarioResult {
+	start := time.Now()
+	result := ScenarioResult{Path: path, Fork: fork, Shard: shard, Failures: []string{}, Slots: []ScenarioSlotResult{}}
+	fail := func(format string, args ...interface{}) ScenarioResult {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		result.Duration = time.Since(start).String()
+		return result
+	}
+
+	engine := c.Engine
+	engine.ScenarioPath = path
+	if engine.DataDir != AutoDataDir {
+		engine.DataDir = ""
+	}
+	engine.IPCPath = ""
+	engine.ListenAddr, engine.WebsocketAddr = "127.0.0.1:0", "127.0.0.1:0"
+	if fork != "" {
+		if err := activateFork(&engine.Forks, fork); err != nil {
+			return fail("%v", err)
+		}
+	}
+	if err := engine.Run(ctx); err != nil {
+		return fail("engine failed to start: %v", err)
+	}
+	defer engine.Close()
+	client, err := rpc.DialContext(ctx, "http://"+engine.ListenAddr, engine.jwtSecret)
+	if err != nil {
+		return fail("unable to connect to engine: %v", err)
+	}
+	defer client.Close()
+	if err := waitForEngine(ctx, client); err != nil {
+		return fail("engine not answering: %v", err)
+	}
+
+	scenario := engine.backend.scenario
+	head := engine.mockChain().CurrentHeader().Hash()
+	for slot := uint64(1); slot <= scenario.Slots; slot++ {
+		timestamp := scenario.Timestamp(slot)
+		proposer := forkClient{client: client, log: c.log, fork: engine.mockChain().ForkAt(timestamp)}
+		slotResult := c.proposeSlot(ctx, proposer, head, slot, timestamp)
+		if slotResult.Error != "" {
+			result.Failures = append(result.Failures, fmt.Sprintf("slot %d: %s", slot, slotResult.Error))
+		}
+		head = slotResult.Head
+		result.Slots = append(result.Slots, slotResult)
+	}
+	if header := engine.mockChain().chain.GetHeaderByHash(engine.mockChain().ResolveHash(head)); header != nil {
+		result.HeadNumber = header.Number.Uint64()
+	}
+	if expect := scenario.Expect; expect != nil && expect.HeadNumber != nil && *expect.HeadNumber != result.HeadNumber {
+		result.Failures = append(result.Failures, fmt.Sprintf("expected head number %d, got %d", *expect.HeadNumber, result.HeadNumber))
+	}
+	result.Passed = len(result.Failures) == 0
+	result.Duration = time.Since(start).String()
+	return result
+}
+
+// proposeSlot builds, imports and selects a payload with the method versions of the fork of the proposer, and keeps
+// the head when the engine doesn't accept it as valid.
+func (c *ScenariosCmd) proposeSlot(ctx context.Context, proposer forkClient, head common.Hash, slot, timestamp uint64) ScenarioSlotResult {
+	result := ScenarioSlotResult{Slot: slot, Head: head}
+	random := common.BigToHash(new(big.Int).SetUint64(slot))
+	attributes := &types.PayloadAttributesV3{
+		Timestamp:             timestamp,
+		PrevRandao:            random,
+		SuggestedFeeRecipient: common.Address{0x42},
+		Withdrawals:           []*types.Withdrawal{},
+		ParentBeaconBlockRoot: random,
+	}
+	fcu, err := proposer.forkchoiceUpdated(ctx, head, head, head, attributes)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.ForkchoiceStatus = fcu.PayloadStatus.Status
+	if fcu.PayloadID == nil {
+		return result
+	}
+	hash, parent, status, err := proposer.importPayload(ctx, *fcu.PayloadID, random)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.PayloadStatus = status.Status
+	if status.Status != types.ExecutionValid {
+		return result
+	}
+	if _, err := proposer.forkchoiceUpdated(ctx, hash, parent, parent, nil); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Head = hash
+	return result
+}
+
+// waitForEngine waits until the engine, whose server starts in the background, answers calls.
+func waitForEngine(ctx context.Context, client *rpc.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for {
+		var block map[string]interface{}
+		err := client.CallContext(ctx, &block, "eth_getBlockByNumber", "latest", false)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
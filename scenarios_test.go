@@ -0,0 +1,101 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenariosRunner(t *testing.T) {
+	invalid := writeScenario(t, `{"slots": 4, "expect": {"headNumber": 3}, "steps": [
+		{"slot": 2, "method": "newPayload", "behavior": "invalid"}
+	]}`)
+	reorg := writeScenario(t, `{"expect": {"headNumber": 2}, "steps": [
+		{"slot": 3, "method": "forkchoiceUpdated", "behavior": "reorg", "depth": 1}
+	]}`)
+	wrong := writeScenario(t, `{"slots": 2, "expect": {"headNumber": 5}, "steps": []}`)
+
+	cmd := new(ScenariosCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Engine.Default()
+	cmd.Engine.LogCmd.Default()
+	cmd.Engine.GasPriceOracle.Default()
+	cmd.Engine.JwtSecretPath = newJwt(t)
+	cmd.Engine.GenesisPath = newGenesis(t)
+	cmd.Parallel = 2
+	cmd.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	require.Error(t, cmd.Run(context.Background(), invalid, reorg, wrong))
+
+	buf, err := os.ReadFile(cmd.ReportPath)
+	require.NoError(t, err)
+	var report ScenariosReport
+	require.NoError(t, json.Unmarshal(buf, &report))
+	require.Equal(t, 2, report.Passed)
+	require.Equal(t, 1, report.Failed)
+	require.Len(t, report.Results, 3)
+
+	require.True(t, report.Results[0].Passed, report.Results[0].Failures)
+	require.Len(t, report.Results[0].Slots, 4)
+	require.Equal(t, types.ExecutionInvalid, report.Results[0].Slots[1].PayloadStatus)
+	require.True(t, report.Results[1].Passed, report.Results[1].Failures)
+	require.Equal(t, uint64(2), report.Results[1].HeadNumber)
+	require.False(t, report.Results[2].Passed)
+	require.Equal(t, []string{"expected head number 5, got 2"}, report.Results[2].Failures)
+}
+
+func TestScenariosRunnerRejectsInvalidScenarios(t *testing.T) {
+	cmd := new(ScenariosCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	require.Error(t, cmd.Run(context.Background()))
+	require.Error(t, cmd.Run(context.Background(), writeScenario(t, `{"steps": [{"slot": 1, "behavior": "explode"}]}`)))
+}
+
+func TestScenariosRunnerForkMatrix(t *testing.T) {
+	invalid := writeScenario(t, `{"slots": 3, "expect": {"headNumber": 2}, "steps": [
+		{"slot": 2, "method": "newPayload", "behavior": "invalid"}
+	]}`)
+	wrong := writeScenario(t, `{"slots": 2, "expect": {"headNumber": 5}, "steps": []}`)
+
+	cmd := new(ScenariosCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Engine.Default()
+	cmd.Engine.LogCmd.Default()
+	cmd.Engine.GasPriceOracle.Default()
+	cmd.Engine.JwtSecretPath = newJwt(t)
+	cmd.Engine.GenesisPath = newGenesis(t)
+	cmd.Forks = []string{ForkParis, ForkShanghai, ForkCancun, ForkPrague}
+	cmd.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	require.Error(t, cmd.Run(context.Background(), invalid, wrong))
+
+	buf, err := os.ReadFile(cmd.ReportPath)
+	require.NoError(t, err)
+	var report ScenariosReport
+	require.NoError(t, json.Unmarshal(buf, &report))
+	require.Equal(t, 4, report.Passed)
+	require.Equal(t, 4, report.Failed)
+	require.Len(t, report.Results, 8)
+	for _, fork := range cmd.Forks {
+		require.True(t, report.Matrix[invalid][fork], fork)
+		require.False(t, report.Matrix[wrong][fork], fork)
+	}
+	for _, result := range report.Results[:4] {
+		require.True(t, result.Passed, result.Fork, result.Failures)
+		require.Equal(t, types.ExecutionInvalid, result.Slots[1].PayloadStatus, result.Fork)
+	}
+}
+
+func TestScenariosRunnerRejectsUnknownForks(t *testing.T) {
+	cmd := new(ScenariosCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Forks = []string{ForkCancun, "osaka"}
+	require.EqualError(t, cmd.Run(context.Background(), writeScenario(t, `{"steps": []}`)), `unknown fork "osaka", expected paris, shanghai, cancun or prague`)
+}
@@ -0,0 +1,53 @@
+package mergemock
+
+import (
+	"math/rand"
+)
+
+// Seeds derives the seeds of the randomized components from a single seed, so that a whole run can be reproduced
+// from it.
+type Seeds struct {
+	rng *rand.Rand
+}
+
+// NewSeeds returns the seeds derived from the seed, nil if the seed is 0 and the components keep their own seeds.
+func NewSeeds(seed int64) *Seeds {
+	if seed == 0 {
+		return nil
+	}
+	return &Seeds{rng: rand.New(rand.NewSource(seed))}
+}
+
+// Derive returns the seed of the next component: its own seed if set, the next derived seed otherwise. The
+// derived seed is drawn either way, so that the seeds of the other components don't depend on which ones are set.
+func (s *Seeds) Derive(own int64) int64 {
+	if s == nil {
+		return own
+	}
+	derived := s.rng.Int63()
+	if own != 0 {
+		return own
+	}
+	if derived == 0 {
+		// 0 stands for a random seed
+		derived = 1
+	}
+	return derived
+}
+
+// deriveSeeds sets the seeds of the randomized behavior of the engine that has none of its own from --seed, in a
+// fixed order, so that new components have to be appended to keep the seeds of recorded runs.
+func (c *EngineCmd) deriveSeeds() {
+	seeds := NewSeeds(c.Seed)
+	if seeds == nil {
+		return
+	}
+	c.Txs.Seed = seeds.Derive(c.Txs.Seed)
+	c.Delays.Seed = seeds.Derive(c.Delays.Seed)
+	c.Faults.Seed = seeds.Derive(c.Faults.Seed)
+	c.Optimistic.Seed = seeds.Derive(c.Optimistic.Seed)
+	c.Content.Seed = seeds.Derive(c.Content.Seed)
+	c.Jwt.Seed = seeds.Derive(c.Jwt.Seed)
+	c.Chaos.Seed = seeds.Derive(c.Chaos.Seed)
+	c.log.WithField("seed", c.Seed).Info("Derived the seeds of randomized behavior")
+}
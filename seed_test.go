@@ -0,0 +1,41 @@
+package mergemock
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeeds(t *testing.T) {
+	require.Nil(t, NewSeeds(0))
+	require.Equal(t, int64(5), NewSeeds(0).Derive(5), "without seeds components keep their own")
+
+	a, b := NewSeeds(7), NewSeeds(7)
+	first := a.Derive(0)
+	require.NotZero(t, first)
+	require.Equal(t, first, b.Derive(0))
+	require.Equal(t, int64(3), a.Derive(3), "components with a seed keep it")
+	b.Derive(0)
+	require.Equal(t, a.Derive(0), b.Derive(0), "later seeds don't depend on the earlier components having a seed")
+	require.NotEqual(t, first, NewSeeds(8).Derive(0))
+}
+
+func TestEngineSeed(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	genesisPath := newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey))
+	build := func(seed int64) common.Hash {
+		te := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+			cmd.Txs.Default()
+			require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+			cmd.Txs.Mode = TxModeTransfer
+			cmd.Txs.Count = 5
+			cmd.Seed = seed
+		})
+		return te.buildAndImport(t, nil, 1).BlockHash
+	}
+	require.Equal(t, build(42), build(42), "the same seed builds the same transactions")
+	require.NotEqual(t, build(42), build(43))
+}
@@ -0,0 +1,171 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethRpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+type ShadowForkConfig struct {
+	URL      string        `ask:"--url" help:"RPC endpoint of a live chain to fork the genesis of the mock chain from, like anvil and hardhat forking (disabled if empty)"`
+	Block    string        `ask:"--block" help:"Block to fork from, a number or latest"`
+	Accounts []string      `ask:"--account" help:"Accounts whose balance, nonce, code and storage are copied from the forked block"`
+	MaxSlots int           `ask:"--max-slots" help:"Maximum number of storage slots copied per account, with debug_storageRangeAt (0 to copy no storage)"`
+	Timeout  time.Duration `ask:"--timeout" help:"Timeout of fetching the forked block and accounts"`
+}
+
+// ShadowState is the state of the accounts of a forked block, with its header.
+type ShadowState struct {
+	Header  *types.Header
+	Hash    common.Hash
+	ChainID *big.Int
+	Alloc   core.GenesisAlloc
+}
+
+// Fetch copies the header of the forked block and the state of the accounts from the endpoint, it returns nil if
+// forking is disabled.
+func (c *ShadowForkConfig) Fetch(ctx context.Context, log logrus.Ext1FieldLogger) (*ShadowState, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	client, err := gethRpc.DialContext(ctx, c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %v", c.URL, err)
+	}
+	defer client.Close()
+
+	block := c.Block
+	if block != "latest" {
+		number, ok := new(big.Int).SetString(block, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid block to fork from %q, expected a number or latest", block)
+		}
+		block = hexutil.EncodeBig(number)
+	}
+	var raw json.RawMessage
+	if err := client.CallContext(ctx, &raw, "eth_getBlockByNumber", block, false); err != nil {
+		return nil, fmt.Errorf("failed to fetch block %s: %v", c.Block, err)
+	} else if len(raw) == 0 || string(raw) == "null" {
+		return nil, fmt.Errorf("block %s not found", c.Block)
+	}
+	var header types.Header
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("invalid block %s: %v", c.Block, err)
+	}
+	var body struct {
+		Hash         common.Hash       `json:"hash"`
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("invalid block %s: %v", c.Block, err)
+	}
+
+	var chainID hexutil.Big
+	if err := client.CallContext(ctx, &chainID, "eth_chainId"); err != nil {
+		return nil, fmt.Errorf("failed to fetch the chain id: %v", err)
+	}
+
+	shadow := &ShadowState{Header: &header, Hash: body.Hash, ChainID: chainID.ToInt(), Alloc: make(core.GenesisAlloc, len(c.Accounts))}
+	at := hexutil.EncodeBig(header.Number)
+	for _, a := range c.Accounts {
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("invalid account %q", a)
+		}
+		addr := common.HexToAddress(a)
+		var balance hexutil.Big
+		var nonce hexutil.Uint64
+		var code hexutil.Bytes
+		batch := []gethRpc.BatchElem{
+			{Method: "eth_getBalance", Args: []interface{}{addr, at}, Result: &balance},
+			{Method: "eth_getTransactionCount", Args: []interface{}{addr, at}, Result: &nonce},
+			{Method: "eth_getCode", Args: []interface{}{addr, at}, Result: &code},
+		}
+		if err := client.BatchCallContext(ctx, batch); err != nil {
+			return nil, fmt.Errorf("failed to fetch account %s: %v", addr, err)
+		}
+		for _, elem := range batch {
+			if elem.Error != nil {
+				return nil, fmt.Errorf("failed to fetch account %s with %s: %v", addr, elem.Method, elem.Error)
+			}
+		}
+		account := core.GenesisAccount{Balance: balance.ToInt(), Nonce: uint64(nonce), Code: code}
+		if len(code) > 0 && c.MaxSlots > 0 {
+			storage, err := fetchStorage(ctx, client, body.Hash, len(body.Transactions), addr, c.MaxSlots)
+			if err != nil {
+				log.WithFields(logrus.Fields{"account": addr, "err": err}).Warn("Unable to copy the storage of the account")
+			}
+			account.Storage = storage
+		}
+		shadow.Alloc[addr] = account
+	}
+	log.WithFields(logrus.Fields{"url": c.URL, "number": header.Number, "hash": body.Hash, "accounts": len(shadow.Alloc)}).Info("Forked the state of the live chain")
+	return shadow, nil
+}
+
+// fetchStorage copies the storage of the account after the transactions of the block, up to max slots, with the
+// preimages of the slot hashes the endpoint knows.
+func fetchStorage(ctx context.Context, client *gethRpc.Client, block common.Hash, txs int, addr common.Address, max int) (map[common.Hash]common.Hash, error) {
+	storage := make(map[common.Hash]common.Hash)
+	start := hexutil.Bytes{}
+	for len(storage) < max {
+		var result struct {
+			Storage map[common.Hash]struct {
+				Key   *common.Hash `json:"key"`
+				Value common.Hash  `json:"value"`
+			} `json:"storage"`
+			NextKey *common.Hash `json:"nextKey"`
+		}
+		if err := client.CallContext(ctx, &result, "debug_storageRangeAt", block, txs, addr, start, max-len(storage)); err != nil {
+			return storage, err
+		}
+		for hash, entry := range result.Storage {
+			if entry.Key == nil {
+				return storage, fmt.Errorf("no preimage of slot hash %s", hash)
+			}
+			storage[*entry.Key] = entry.Value
+		}
+		if result.NextKey == nil || len(result.Storage) == 0 {
+			break
+		}
+		start = result.NextKey.Bytes()
+	}
+	return storage, nil
+}
+
+// apply makes the genesis a copy of the forked block, with the state of its accounts on top of the genesis alloc.
+// The genesis keeps the block number 0.
+func (s *ShadowState) apply(log logrus.Ext1FieldLogger, genesis *core.Genesis) {
+	if genesis.Config != nil && genesis.Config.ChainID != nil && genesis.Config.ChainID.Cmp(s.ChainID) != 0 {
+		log.WithFields(logrus.Fields{"genesis": genesis.Config.ChainID, "forked": s.ChainID}).Warn("Chain id of the genesis differs from the forked chain, its transactions won't replay")
+	}
+	h := s.Header
+	genesis.ParentHash = h.ParentHash
+	genesis.Timestamp = h.Time
+	if genesis.Config == nil || genesis.Config.Clique == nil {
+		// the extra data of clique genesis blocks lists the signers, geth refuses it without
+		genesis.ExtraData = h.Extra
+	}
+	genesis.GasLimit = h.GasLimit
+	genesis.Difficulty = h.Difficulty
+	genesis.Mixhash = h.MixDigest
+	genesis.Coinbase = h.Coinbase
+	genesis.Nonce = h.Nonce.Uint64()
+	genesis.BaseFee = h.BaseFee
+	if genesis.Alloc == nil {
+		genesis.Alloc = make(core.GenesisAlloc, len(s.Alloc))
+	}
+	for addr, account := range s.Alloc {
+		genesis.Alloc[addr] = account
+	}
+}
@@ -0,0 +1,86 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// storageRangeAPI serves debug_storageRangeAt with the storage of a single contract, in one page.
+type storageRangeAPI struct {
+	contract common.Address
+	storage  map[common.Hash]common.Hash
+}
+
+type storageEntry struct {
+	Key   *common.Hash `json:"key"`
+	Value common.Hash  `json:"value"`
+}
+
+func (api *storageRangeAPI) StorageRangeAt(blockHash common.Hash, txIndex int, addr common.Address, start hexutil.Bytes, max int) map[string]interface{} {
+	entries := make(map[common.Hash]storageEntry)
+	if addr == api.contract {
+		for key, value := range api.storage {
+			key := key
+			entries[crypto.Keccak256Hash(key[:])] = storageEntry{&key, value}
+		}
+	}
+	return map[string]interface{}{"storage": entries, "nextKey": nil}
+}
+
+func TestShadowFork(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.Address{0xcc}
+	storage := map[common.Hash]common.Hash{{0x01}: {0x2a}}
+	genesisPath := newFundedGenesis(t, sender)
+	buf, err := os.ReadFile(genesisPath)
+	require.NoError(t, err)
+	var genesis core.Genesis
+	require.NoError(t, json.Unmarshal(buf, &genesis))
+	genesis.Alloc[contract] = core.GenesisAccount{Balance: common.Big1, Code: logEmitterCode, Storage: storage}
+	buf, err = json.Marshal(&genesis)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(genesisPath, buf, 0644))
+
+	live := newTestEngineWithGenesis(t, genesisPath, func(cmd *EngineCmd) {
+		cmd.Txs.Default()
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Txs.Seed = 1
+		cmd.Txs.Mode = TxModeTransfer
+		cmd.Txs.Count = 2
+	})
+	require.NoError(t, live.rpcSrv.RegisterName("debug", &storageRangeAPI{contract, storage}))
+	payload := live.buildAndImport(t, nil, 1)
+	live.setHead(t, payload.BlockHash)
+
+	forked := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Shadow.URL = "http://" + live.ListenAddr
+		cmd.Shadow.Accounts = []string{sender.Hex(), contract.Hex()}
+	})
+	liveState, err := live.mockChain().chain.State()
+	require.NoError(t, err)
+	forkedState, err := forked.mockChain().chain.State()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), forkedState.GetNonce(sender))
+	require.Equal(t, liveState.GetBalance(sender), forkedState.GetBalance(sender))
+	require.Equal(t, logEmitterCode, forkedState.GetCode(contract))
+	require.Equal(t, common.Hash{0x2a}, forkedState.GetState(contract, common.Hash{0x01}))
+
+	// The genesis is a copy of the forked block, apart from its number and state.
+	head := forked.mockChain().chain.Genesis()
+	require.Equal(t, payload.ParentHash, head.ParentHash())
+	require.Equal(t, payload.Timestamp, head.Time())
+	require.Equal(t, payload.FeeRecipient, head.Coinbase())
+	require.Zero(t, head.NumberU64())
+	// Payloads are built on top of the forked state.
+	next := forked.buildPayload(t, head.Hash(), head.Time()+12, common.Hash{0x02})
+	require.Equal(t, head.Hash(), next.ParentHash)
+}
@@ -0,0 +1,162 @@
+package mergemock
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mergemock/rpc"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const shellPrompt = "mergemock> "
+
+type ShellCmd struct {
+	JwtSecretPath string        `ask:"--jwt-secret" help:"JWT secret key of the instance (empty to call without authentication)"`
+	Timeout       time.Duration `ask:"--timeout" help:"Timeout of every call"`
+
+	in  io.Reader
+	out io.Writer
+}
+
+func (c *ShellCmd) Default() {
+	c.Timeout = 10 * time.Second
+}
+
+func (c *ShellCmd) Help() string {
+	return "Control a running mergemock instance interactively: mergemock shell [flags] <endpoint>, then the commands of ctl, tab-completed, and help or exit."
+}
+
+func (c *ShellCmd) Run(ctx context.Context, args ...string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected an endpoint, see --help")
+	}
+	secret, err := loadCtlSecret(c.JwtSecretPath)
+	if err != nil {
+		return err
+	}
+	client, err := rpc.DialContext(ctx, args[0], secret)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	in, out := c.in, c.out
+	if in == nil && out == nil && term.IsTerminal(int(os.Stdin.Fd())) {
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		defer term.Restore(int(os.Stdin.Fd()), state)
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, shellPrompt)
+		t.AutoCompleteCallback = completeShellLine
+		fmt.Fprintln(t, "Connected to", args[0]+", type help for the commands, tab to complete them")
+		return c.loop(ctx, client, t.ReadLine, t)
+	}
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	// Without a terminal, e.g. with commands piped in, lines are read as they come, without prompt.
+	scanner := bufio.NewScanner(in)
+	readLine := func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+	return c.loop(ctx, client, readLine, out)
+}
+
+// loop runs the commands of the lines until exit, or the end of the input. Failed commands are reported, and
+// don't end the session.
+func (c *ShellCmd) loop(ctx context.Context, client *rpc.Client, readLine func() (string, error), out io.Writer) error {
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch name := fields[0]; name {
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Fprintln(out, shellHelp())
+		default:
+			if err := runCtlCommand(ctx, client, c.Timeout, name, fields[1:], out); err != nil {
+				fmt.Fprintln(out, "error:", err)
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func shellHelp() string {
+	lines := make([]string, 0, len(ctlCommands)+2)
+	for name, cmd := range ctlCommands {
+		lines = append(lines, fmt.Sprintf("  %-34s %s", name+" "+cmd.args, cmd.help))
+	}
+	lines = append(lines, fmt.Sprintf("  %-34s %s", "help", "Show the commands"), fmt.Sprintf("  %-34s %s", "exit", "End the session"))
+	sort.Strings(lines)
+	return "Commands:\n" + strings.Join(lines, "\n")
+}
+
+// shellCommands returns the names of the commands of the shell, sorted.
+func shellCommands() []string {
+	names := []string{"exit", "help", "quit"}
+	for name := range ctlCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeShellLine completes the command name being typed on tab, as far as the commands starting with it agree.
+func completeShellLine(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || strings.ContainsAny(line[:pos], " ") {
+		return "", 0, false
+	}
+	prefix := line[:pos]
+	var matches []string
+	for _, name := range shellCommands() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completion += " "
+	}
+	if completion == prefix {
+		return "", 0, false
+	}
+	return completion + line[pos:], len(completion), true
+}
@@ -0,0 +1,37 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShell(t *testing.T) {
+	te := newTestEngine(t)
+	var out bytes.Buffer
+	cmd := &ShellCmd{JwtSecretPath: te.JwtSecretPath, in: strings.NewReader("help\n\n# comment\nstats\nbogus\nfreeze\nexit\nunfreeze\n"), out: &out}
+	cmd.Default()
+	require.NoError(t, cmd.Run(context.Background(), "http://"+te.ListenAddr))
+	output := out.String()
+	require.Contains(t, output, "Commands:")
+	require.Contains(t, output, `"number": 0`)
+	require.Contains(t, output, `error: unknown command "bogus"`)
+	require.True(t, NewMockBackend(te.backend).State(context.Background()).Frozen, "commands after a failed one still run")
+	require.NotContains(t, output, "error: unfreeze", "nothing runs after exit")
+
+	complete := func(line string) string {
+		completed, _, ok := completeShellLine(line, len(line), '\t')
+		if !ok {
+			return line
+		}
+		return completed
+	}
+	require.Equal(t, "stat", complete("sta"), "as far as state and stats agree")
+	require.Equal(t, "stats ", complete("stats"))
+	require.Equal(t, "unfreeze ", complete("u"))
+	require.Equal(t, "f", complete("f"), "fault, faults, flush-payloads and freeze don't agree")
+	require.Equal(t, "stats x", complete("stats x"), "only command names complete")
+}
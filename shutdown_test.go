@@ -0,0 +1,124 @@
+package mergemock
+
+import (
+	"context"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.Delays.NewPayload = 300 * time.Millisecond
+	})
+	genesis := te.mockChain().CurrentHeader()
+	payload := te.buildPayload(t, genesis.Hash(), genesis.Time+12, common.Hash{0x01})
+
+	type result struct {
+		status *types.PayloadStatusV1
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, err := api.NewPayloadV1(context.Background(), te.client, te.log, payload)
+		done <- result{status, err}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, te.Close())
+	te.close, te.backend = nil, nil
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "shutdown waits for the call in flight")
+
+	res := <-done
+	require.NoError(t, res.err, "the call in flight is answered")
+	require.Equal(t, types.ExecutionValid, res.status.Status)
+	var number hexutil.Uint64
+	require.Error(t, te.client.CallContext(context.Background(), &number, "eth_blockNumber"), "no new requests after shutdown")
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config := filepath.Join(dir, "engine.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("fault.rule:\n  - method=engine_getPayloadV1;action=status;status=SYNCING\n"), 0o644))
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.ConfigPath = config
+		cmd.Jwt.HTTP = true
+		cmd.Faults.Rules = []string{"method=engine_getPayloadV1;action=status;status=SYNCING"}
+	})
+	require.Len(t, te.backend.faults.Rules(), 1)
+	var added uint64
+	require.NoError(t, te.client.CallContext(ctx, &added, "mock_injectFault", FaultRule{Method: "engine_getPayloadV2", Action: FaultDrop}))
+
+	secret := common.Hash{0x42}
+	require.NoError(t, os.WriteFile(te.JwtSecretPath, []byte(common.Bytes2Hex(secret[:])), 0o600))
+	require.NoError(t, os.WriteFile(config, []byte(`
+fault.rule:
+  - method=engine_newPayloadV1;action=status;status=SYNCING
+  - method=engine_forkchoiceUpdatedV1;action=error;code=-32000
+delay.fcu: 10ms
+`), 0o644))
+	genesis := te.mockChain().CurrentHeader()
+	require.NoError(t, te.Reload())
+
+	var number hexutil.Uint64
+	require.Error(t, te.client.CallContext(ctx, &number, "eth_blockNumber"), "the previous secret is rejected")
+	client, err := rpc.DialContext(ctx, "http://"+te.ListenAddr, secret[:])
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.CallContext(ctx, &number, "eth_blockNumber"))
+	require.Equal(t, genesis.Hash(), te.mockChain().CurrentHeader().Hash(), "the chain is kept")
+
+	// the rules of the file are replaced, the one added at runtime is kept
+	rules := te.backend.faults.Rules()
+	require.Len(t, rules, 3)
+	require.Equal(t, added, rules[0].ID)
+	require.Equal(t, "engine_newPayloadV1", rules[1].Method)
+	require.Equal(t, "engine_forkchoiceUpdatedV1", rules[2].Method)
+	require.NotNil(t, te.backend.control.Delays())
+
+	// invalid rules leave the previous ones in place
+	require.NoError(t, os.WriteFile(config, []byte("fault.rule: [method=engine_newPayloadV1;action=spam]\n"), 0o644))
+	require.NoError(t, os.WriteFile(te.JwtSecretPath, []byte(common.Bytes2Hex(common.Hash{0x43}.Bytes())), 0o600))
+	require.Error(t, te.Reload())
+	require.Len(t, te.backend.faults.Rules(), 3)
+	require.Equal(t, secret[:], te.backend.auth.Secret(), "nor the secret")
+
+	// a missing secret is an error, rather than replaced by a random one
+	require.NoError(t, os.WriteFile(config, []byte("fault.rule: []\n"), 0o644))
+	require.NoError(t, os.Remove(te.JwtSecretPath))
+	require.Error(t, te.Reload())
+	require.Len(t, te.backend.faults.Rules(), 3)
+	require.Equal(t, secret[:], te.backend.auth.Secret())
+	_, err = os.Stat(te.JwtSecretPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReloadKeepsCommandLineFlags(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "engine.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("fault.rule: [method=engine_getPayloadV1;action=drop]\ndelay.fcu: 10ms\n"), 0o644))
+	te := newTestEngineWithGenesis(t, newGenesis(t), func(cmd *EngineCmd) {
+		cmd.ConfigPath = config
+		// as if given on the command line after the file
+		cmd.Faults.Rules = []string{"method=engine_newPayloadV1;action=drop"}
+		cmd.Delays.ForkchoiceUpdated = 10 * time.Millisecond
+		cmd.Delays.NewPayload = 20 * time.Millisecond
+	})
+
+	require.NoError(t, os.WriteFile(config, []byte("fault.rule: [method=engine_getPayloadV2;action=drop]\ndelay.fcu: 30ms\ndelay.getpayload: 40ms\n"), 0o644))
+	require.NoError(t, te.Reload())
+	rules := te.backend.faults.Rules()
+	require.Len(t, rules, 1)
+	require.Equal(t, "engine_newPayloadV1", rules[0].Method)
+	delays := te.backend.control.Delays()
+	require.NotNil(t, delays)
+	require.Equal(t, DelayConfig{NewPayload: 20 * time.Millisecond, ForkchoiceUpdated: 30 * time.Millisecond, GetPayload: 40 * time.Millisecond}, delays.cfg)
+}
@@ -0,0 +1,147 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mergemock/types"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/prysmaticlabs/prysm/crypto/bls"
+)
+
+// BidSigner signs the builder bids of the relay.
+type BidSigner interface {
+	// PublicKey returns the key bids are signed with, which the relay announces in its bids.
+	PublicKey() types.PublicKey
+	// Sign signs the signing root of a bid.
+	Sign(ctx context.Context, root [32]byte) (types.Signature, error)
+}
+
+// LocalSigner signs bids with a secret key held by the relay.
+type LocalSigner struct {
+	sk bls.SecretKey
+	pk types.PublicKey
+}
+
+func NewLocalSigner(sk bls.SecretKey) *LocalSigner {
+	s := &LocalSigner{sk: sk}
+	s.pk.FromSlice(sk.PublicKey().Marshal())
+	return s
+}
+
+func (s *LocalSigner) PublicKey() types.PublicKey {
+	return s.pk
+}
+
+func (s *LocalSigner) Sign(ctx context.Context, root [32]byte) (types.Signature, error) {
+	var sig types.Signature
+	sig.FromSlice(s.sk.Sign(root[:]).Marshal())
+	return sig, nil
+}
+
+// web3SignerBidType is the signing type the remote signer is asked to sign bids as. Web3Signer has no type of its
+// own for builder bids, signers fronting it have to map it to the builder domain.
+const web3SignerBidType = "BUILDER_BID"
+
+type SignerConfig struct {
+	URL     string        `ask:"--url" help:"Web3Signer-compatible remote signer to sign bids with, empty to sign with --secret-key"`
+	Pubkey  string        `ask:"--pubkey" help:"Key of the remote signer to sign bids with, empty for the first key it lists"`
+	Timeout time.Duration `ask:"--timeout" help:"Timeout of requests to the remote signer"`
+}
+
+func (c *SignerConfig) Default() {
+	c.Timeout = 5 * time.Second
+}
+
+// NewRemoteSigner returns the remote signer, nil if bids are signed locally. Without a configured key, the
+// first key listed by the signer is used.
+func (c *SignerConfig) NewRemoteSigner(ctx context.Context) (*RemoteSigner, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+	s := &RemoteSigner{url: strings.TrimSuffix(c.URL, "/"), client: &http.Client{Timeout: c.Timeout}}
+	if c.Pubkey != "" {
+		if err := s.pk.UnmarshalText([]byte(c.Pubkey)); err != nil {
+			return nil, fmt.Errorf("invalid signer pubkey %q: %v", c.Pubkey, err)
+		}
+		return s, nil
+	}
+	var keys []types.PublicKey
+	if err := s.do(ctx, http.MethodGet, "/api/v1/eth2/publicKeys", nil, &keys); err != nil {
+		return nil, fmt.Errorf("failed to list signer keys: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("remote signer %s has no keys", c.URL)
+	}
+	s.pk = keys[0]
+	return s, nil
+}
+
+// RemoteSigner signs bids with the eth2 signing API of Web3Signer.
+type RemoteSigner struct {
+	url    string
+	pk     types.PublicKey
+	client *http.Client
+}
+
+func (s *RemoteSigner) PublicKey() types.PublicKey {
+	return s.pk
+}
+
+type web3SignerRequest struct {
+	Type        string        `json:"type"`
+	SigningRoot hexutil.Bytes `json:"signingRoot"`
+}
+
+type web3SignerResponse struct {
+	Signature types.Signature `json:"signature"`
+}
+
+func (s *RemoteSigner) Sign(ctx context.Context, root [32]byte) (types.Signature, error) {
+	var resp web3SignerResponse
+	req := &web3SignerRequest{Type: web3SignerBidType, SigningRoot: root[:]}
+	if err := s.do(ctx, http.MethodPost, "/api/v1/eth2/sign/"+s.pk.String(), req, &resp); err != nil {
+		return types.Signature{}, fmt.Errorf("remote signer failed to sign: %v", err)
+	}
+	return resp.Signature, nil
+}
+
+// do calls the signer. Signatures come back as JSON, or as hex text from signers ignoring the Accept header.
+func (s *RemoteSigner) do(ctx context.Context, method, path string, body, dst interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(buf)))
+	}
+	if signed, ok := dst.(*web3SignerResponse); ok && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return signed.Signature.UnmarshalText(bytes.TrimSpace(buf))
+	}
+	return json.Unmarshal(buf, dst)
+}
@@ -0,0 +1,254 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mergemock/rpc"
+	"mergemock/types"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+type SoakCmd struct {
+	Duration    time.Duration `ask:"--duration" help:"How long to keep driving the engine"`
+	SlotTime    time.Duration `ask:"--slot-time" help:"Interval between proposals"`
+	WarmupSlots uint64        `ask:"--warmup-slots" help:"Number of proposals before the baseline for leak and drift detection is taken"`
+	Window      uint64        `ask:"--window" help:"Number of proposals averaged for the baseline and current latency"`
+
+	MaxHeapGrowth      uint64  `ask:"--max-heap-growth" help:"Maximum growth of the live heap over the baseline, in MiB"`
+	MaxGoroutineGrowth int     `ask:"--max-goroutine-growth" help:"Maximum growth of the goroutine count over the baseline"`
+	MaxLatencyDrift    float64 `ask:"--max-latency-drift" help:"Maximum ratio between the current and baseline proposal latency"`
+
+	ReportPath string `ask:"--report" help:"File to write the JSON soak report to (empty for log output only)"`
+
+	Engine EngineCmd `ask:".engine" help:"Configure the engine under test"`
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log logrus.Ext1FieldLogger
+}
+
+func (c *SoakCmd) Default() {
+	c.Duration = time.Hour
+	c.SlotTime = time.Second
+	c.WarmupSlots = 60
+	c.Window = 30
+
+	c.MaxHeapGrowth = 256
+	c.MaxGoroutineGrowth = 50
+	c.MaxLatencyDrift = 3
+}
+
+func (c *SoakCmd) Help() string {
+	return "Drive an in-process mock engine with proposals and fail on resource leaks or latency drift."
+}
+
+type soakSample struct {
+	Slot       uint64        `json:"slot"`
+	Time       time.Time     `json:"time"`
+	HeapAlloc  uint64        `json:"heapAlloc"`
+	Goroutines int           `json:"goroutines"`
+	Latency    time.Duration `json:"latency"`
+}
+
+type SoakReport struct {
+	Started    time.Time    `json:"started"`
+	Finished   time.Time    `json:"finished"`
+	Proposals  uint64       `json:"proposals"`
+	Baseline   *soakSample  `json:"baseline,omitempty"`
+	Last       *soakSample  `json:"last,omitempty"`
+	MaxLatency string       `json:"maxLatency"`
+	Violations []string     `json:"violations"`
+	Samples    []soakSample `json:"samples"`
+	// LatencyAlerts counts the engine calls exceeding their latency budget, per method.
+	LatencyAlerts map[string]uint64 `json:"latencyAlerts"`
+}
+
+func (c *SoakCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+	if c.Window == 0 {
+		return fmt.Errorf("latency window must be at least 1 proposal")
+	}
+	if err := c.Engine.Run(ctx); err != nil {
+		return err
+	}
+	defer c.Engine.Close()
+
+	client, err := rpc.DialContext(ctx, "http://"+c.Engine.ListenAddr, c.Engine.jwtSecret)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	report := c.soak(ctx, client)
+	if c.ReportPath != "" {
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.ReportPath, buf, 0644); err != nil {
+			return fmt.Errorf("failed to write soak report: %v", err)
+		}
+	}
+	if len(report.Violations) > 0 {
+		return fmt.Errorf("soak test failed after %d proposals: %v", report.Proposals, report.Violations)
+	}
+	return nil
+}
+
+func (c *SoakCmd) soak(ctx context.Context, client *rpc.Client) *SoakReport {
+	report := &SoakReport{Started: time.Now(), Violations: []string{}}
+	// Calls use the outer context, so the one in flight when the duration is up can still complete.
+	deadline, cancel := context.WithTimeout(ctx, c.Duration)
+	defer cancel()
+
+	slots := time.NewTicker(c.SlotTime)
+	defer slots.Stop()
+
+	head := c.Engine.mockChain().CurrentHeader()
+	parent, timestamp := head.Hash(), head.Time
+	var maxLatency time.Duration
+	var latencies []time.Duration
+	for {
+		select {
+		case <-deadline.Done():
+			report.Finished = time.Now()
+			report.MaxLatency = maxLatency.String()
+			report.LatencyAlerts = c.Engine.backend.latency.Alerts()
+			c.log.WithFields(logrus.Fields{
+				"proposals":  report.Proposals,
+				"violations": len(report.Violations),
+				"maxLatency": report.MaxLatency,
+			}).Info("Soak test finished")
+			return report
+		case <-slots.C:
+		}
+		timestamp++
+		start := time.Now()
+		hash, err := c.propose(ctx, client, parent, timestamp)
+		latency := time.Since(start)
+		if err != nil {
+			if deadline.Err() != nil {
+				continue
+			}
+			report.Violations = append(report.Violations, fmt.Sprintf("slot %d: proposal failed: %v", report.Proposals+1, err))
+			cancel()
+			continue
+		}
+		parent = hash
+		report.Proposals++
+		if latency > maxLatency {
+			maxLatency = latency
+		}
+		latencies = append(latencies, latency)
+		if uint64(len(latencies)) > c.Window {
+			latencies = latencies[1:]
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		sample := soakSample{
+			Slot:       report.Proposals,
+			Time:       time.Now(),
+			HeapAlloc:  mem.HeapAlloc,
+			Goroutines: runtime.NumGoroutine(),
+			Latency:    meanLatency(latencies),
+		}
+		report.Last = &sample
+		if report.Proposals%c.Window == 0 {
+			report.Samples = append(report.Samples, sample)
+		}
+
+		if report.Proposals == c.WarmupSlots+c.Window {
+			// force a collection, so the baseline heap isn't inflated by garbage
+			runtime.GC()
+			runtime.ReadMemStats(&mem)
+			sample.HeapAlloc = mem.HeapAlloc
+			report.Baseline = &sample
+			c.log.WithFields(logrus.Fields{
+				"heapAlloc":  sample.HeapAlloc,
+				"goroutines": sample.Goroutines,
+				"latency":    sample.Latency,
+			}).Info("Soak baseline taken")
+			continue
+		}
+		if base := report.Baseline; base != nil {
+			if violations := c.check(base, &sample); len(violations) > 0 {
+				report.Violations = append(report.Violations, violations...)
+				cancel()
+			}
+		}
+	}
+}
+
+// check compares a sample against the baseline. The heap is only judged after a collection,
+// so garbage that simply wasn't collected yet isn't reported as a leak.
+func (c *SoakCmd) check(base *soakSample, sample *soakSample) []string {
+	var violations []string
+	if sample.HeapAlloc > base.HeapAlloc+c.MaxHeapGrowth<<20 {
+		runtime.GC()
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		sample.HeapAlloc = mem.HeapAlloc
+		if growth := int64(sample.HeapAlloc) - int64(base.HeapAlloc); growth > int64(c.MaxHeapGrowth<<20) {
+			violations = append(violations, fmt.Sprintf("slot %d: heap grew by %d MiB", sample.Slot, growth>>20))
+		}
+	}
+	if growth := sample.Goroutines - base.Goroutines; growth > c.MaxGoroutineGrowth {
+		violations = append(violations, fmt.Sprintf("slot %d: goroutine count grew by %d", sample.Slot, growth))
+	}
+	if base.Latency > 0 && float64(sample.Latency)/float64(base.Latency) > c.MaxLatencyDrift {
+		violations = append(violations, fmt.Sprintf("slot %d: proposal latency drifted from %s to %s", sample.Slot, base.Latency, sample.Latency))
+	}
+	return violations
+}
+
+// propose runs one proposal through the engine the way a consensus client would, with the method versions of the
+// fork active at the timestamp, and returns the new head. The randao and the parent beacon block root are derived
+// from the timestamp.
+func (c *SoakCmd) propose(ctx context.Context, client *rpc.Client, parent common.Hash, timestamp uint64) (common.Hash, error) {
+	random := common.BigToHash(new(big.Int).SetUint64(timestamp))
+	engine := forkClient{client: client, log: c.log, fork: c.Engine.mockChain().ForkAt(timestamp)}
+	attributes := &types.PayloadAttributesV3{
+		Timestamp:             timestamp,
+		PrevRandao:            random,
+		SuggestedFeeRecipient: common.Address{0x42},
+		Withdrawals:           []*types.Withdrawal{},
+		ParentBeaconBlockRoot: random,
+	}
+	result, err := engine.forkchoiceUpdated(ctx, parent, parent, parent, attributes)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if result.PayloadID == nil {
+		return common.Hash{}, fmt.Errorf("no payload id returned, status %s", result.PayloadStatus.Status)
+	}
+	hash, _, status, err := engine.importPayload(ctx, *result.PayloadID, random)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if status.Status != types.ExecutionValid {
+		return common.Hash{}, fmt.Errorf("payload %s not valid: %s", hash, status.Status)
+	}
+	if _, err := engine.forkchoiceUpdated(ctx, hash, parent, parent, nil); err != nil {
+		return common.Hash{}, err
+	}
+	return hash, nil
+}
+
+func meanLatency(latencies []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(latencies))
+}
@@ -0,0 +1,46 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoak(t *testing.T) {
+	for name, forks := range map[string]ForkTimesConfig{
+		"paris":  {},
+		"cancun": {Shanghai: "0", Cancun: "0"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := new(SoakCmd)
+			cmd.Default()
+			cmd.LogCmd.Default()
+			cmd.Engine.Default()
+			cmd.Engine.LogCmd.Default()
+			cmd.Engine.GasPriceOracle.Default()
+			cmd.Engine.JwtSecretPath = newJwt(t)
+			cmd.Engine.GenesisPath = newGenesis(t)
+			cmd.Engine.ListenAddr = freeAddr(t)
+			cmd.Engine.WebsocketAddr = freeAddr(t)
+			cmd.Engine.Forks = forks
+			cmd.Duration = 200 * time.Millisecond
+			cmd.SlotTime = 10 * time.Millisecond
+			// no baseline, the proposals alone are checked
+			cmd.WarmupSlots = 1000
+			require.NoError(t, cmd.Run(context.Background()))
+
+			chain := cmd.Engine.mockChain()
+			head := chain.CurrentHeader()
+			require.Positive(t, head.Number.Uint64())
+			require.Equal(t, name, chain.ForkAt(head.Time))
+			fork := chain.ForkFields(head.Hash())
+			if name == ForkParis {
+				require.Nil(t, fork)
+			} else {
+				require.NotNil(t, fork.ParentBeaconRoot, "proposed with the V3 methods")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"mergemock/api"
+	"mergemock/rpc"
+	"mergemock/types"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Interleavings of the stress command, each run once per round.
+const (
+	StressSameID         = "same-id"         // every connection retrieves the same payload id at once
+	StressOverlappingFcu = "overlapping-fcu" // every connection starts a build on the head at once, then retrieves those of the others
+	StressGetDuringFcu   = "get-during-fcu"  // a payload is retrieved while builds of the next are started
+)
+
+var stressInterleavings = []string{StressSameID, StressOverlappingFcu, StressGetDuringFcu}
+
+type StressCmd struct {
+	Rounds        uint64        `ask:"--rounds" help:"Number of rounds, each running the interleavings on the head and then advancing it by one block"`
+	Connections   int           `ask:"--connections" help:"Number of HTTP connections making the concurrent calls"`
+	Stagger       time.Duration `ask:"--stagger" help:"Offset between the start of the concurrent calls of consecutive connections (0 to start them all at once)"`
+	Interleavings []string      `ask:"--interleaving" help:"Interleavings to run: same-id, overlapping-fcu and get-during-fcu (empty for all)"`
+
+	Endpoint      string `ask:"--endpoint" help:"Engine API endpoint to stress, empty for an in-process mock engine"`
+	JwtSecretPath string `ask:"--jwt-secret" help:"JWT secret key of the endpoint"`
+
+	Engine EngineCmd `ask:".engine" help:"Configure the in-process engine"`
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log   logrus.Ext1FieldLogger
+	calls uint64
+}
+
+func (c *StressCmd) Default() {
+	c.Rounds = 100
+	c.Connections = 4
+	c.JwtSecretPath = "jwt.hex"
+}
+
+func (c *StressCmd) Help() string {
+	return "Issue overlapping forkchoiceUpdated and getPayload calls to an engine from multiple connections, and fail on errors or mixed up payloads."
+}
+
+func (c *StressCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+	if c.Connections < 2 {
+		return fmt.Errorf("stressing needs at least 2 connections")
+	}
+	interleavings := c.Interleavings
+	if len(interleavings) == 0 {
+		interleavings = stressInterleavings
+	}
+	for _, name := range interleavings {
+		if name != StressSameID && name != StressOverlappingFcu && name != StressGetDuringFcu {
+			return fmt.Errorf("unknown interleaving %q", name)
+		}
+	}
+
+	endpoint, secret := c.Endpoint, []byte(nil)
+	if endpoint == "" {
+		if err := c.Engine.Run(ctx); err != nil {
+			return err
+		}
+		defer c.Engine.Close()
+		endpoint, secret = "http://"+c.Engine.ListenAddr, c.Engine.jwtSecret
+	} else if secret, err = readJwtSecret(c.JwtSecretPath); err != nil {
+		return fmt.Errorf("unable to read JWT secret: %v", err)
+	}
+	clients := make([]*rpc.Client, c.Connections)
+	for i := range clients {
+		// every client has its own transport, so calls are made over distinct connections
+		client, err := rpc.DialHTTPWithClient(endpoint, secret, &http.Client{Transport: &http.Transport{}})
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+
+	var head struct {
+		Hash      common.Hash    `json:"hash"`
+		Timestamp hexutil.Uint64 `json:"timestamp"`
+	}
+	if err := c.waitForHead(ctx, clients[0], &head); err != nil {
+		return err
+	}
+	parent, timestamp := head.Hash, uint64(head.Timestamp)
+	start := time.Now()
+	for round := uint64(1); round <= c.Rounds; round++ {
+		timestamp += 12
+		var next *types.ExecutionPayloadV1
+		for _, name := range interleavings {
+			payload, err := c.interleave(ctx, name, clients, parent, timestamp)
+			if err != nil {
+				return fmt.Errorf("round %d, %s: %v", round, name, err)
+			}
+			next = payload
+		}
+		if err := c.advance(ctx, clients[0], next); err != nil {
+			return fmt.Errorf("round %d: %v", round, err)
+		}
+		parent = next.BlockHash
+	}
+	c.log.WithFields(logrus.Fields{
+		"rounds":   c.Rounds,
+		"calls":    atomic.LoadUint64(&c.calls),
+		"duration": time.Since(start),
+	}).Info("Stress test finished")
+	return nil
+}
+
+// waitForHead waits for the engine to answer, and reads its head.
+func (c *StressCmd) waitForHead(ctx context.Context, client *rpc.Client, head interface{}) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = client.CallContext(ctx, head, "eth_getBlockByNumber", "latest", false); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("engine not reachable: %v", err)
+}
+
+// concurrently calls fn for every connection, started together or by the stagger apart, and returns the first error.
+func (c *StressCmd) concurrently(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			time.Sleep(time.Duration(i) * c.Stagger)
+			errs[i] = fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("connection %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (c *StressCmd) build(ctx context.Context, client *rpc.Client, parent common.Hash, timestamp uint64, feeRecipient common.Address) (types.PayloadID, error) {
+	atomic.AddUint64(&c.calls, 1)
+	attributes := &types.PayloadAttributesV1{
+		Timestamp:             timestamp,
+		PrevRandao:            common.BigToHash(new(big.Int).SetUint64(timestamp)),
+		SuggestedFeeRecipient: feeRecipient,
+	}
+	result, err := api.ForkchoiceUpdatedV1(ctx, client, c.log, parent, parent, parent, attributes)
+	if err != nil {
+		return types.PayloadID{}, err
+	}
+	if result.PayloadID == nil {
+		return types.PayloadID{}, fmt.Errorf("no payload id returned, status %s", result.PayloadStatus.Status)
+	}
+	return *result.PayloadID, nil
+}
+
+func (c *StressCmd) get(ctx context.Context, client *rpc.Client, id types.PayloadID, parent common.Hash, feeRecipient common.Address) (*types.ExecutionPayloadV1, error) {
+	atomic.AddUint64(&c.calls, 1)
+	payload, err := api.GetPayloadV1(ctx, client, c.log, id)
+	if err != nil {
+		return nil, err
+	}
+	if payload.ParentHash != parent || payload.FeeRecipient != feeRecipient {
+		return nil, fmt.Errorf("payload %s is built on %s for %s, expected %s for %s", id, payload.ParentHash, payload.FeeRecipient, parent, feeRecipient)
+	}
+	return payload, nil
+}
+
+// interleave runs the interleaving on the head, and returns one of the payloads it built.
+func (c *StressCmd) interleave(ctx context.Context, name string, clients []*rpc.Client, parent common.Hash, timestamp uint64) (*types.ExecutionPayloadV1, error) {
+	n := len(clients)
+	recipient := func(i int) common.Address { return common.Address{0x42, byte(i)} }
+	switch name {
+	case StressSameID:
+		id, err := c.build(ctx, clients[0], parent, timestamp, recipient(0))
+		if err != nil {
+			return nil, err
+		}
+		payloads := make([]*types.ExecutionPayloadV1, n)
+		err = c.concurrently(n, func(i int) (err error) {
+			payloads[i], err = c.get(ctx, clients[i], id, parent, recipient(0))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, payload := range payloads {
+			if payload.BlockHash != payloads[0].BlockHash {
+				return nil, fmt.Errorf("connection %d got block %s of payload %s, connection 0 got %s", i, payload.BlockHash, id, payloads[0].BlockHash)
+			}
+		}
+		return payloads[0], nil
+	case StressOverlappingFcu:
+		ids := make([]types.PayloadID, n)
+		err := c.concurrently(n, func(i int) (err error) {
+			ids[i], err = c.build(ctx, clients[i], parent, timestamp, recipient(i))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		// every connection retrieves the payload built by the next one
+		payloads := make([]*types.ExecutionPayloadV1, n)
+		err = c.concurrently(n, func(i int) (err error) {
+			j := (i + 1) % n
+			payloads[j], err = c.get(ctx, clients[i], ids[j], parent, recipient(j))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return payloads[0], nil
+	default:
+		id, err := c.build(ctx, clients[0], parent, timestamp, recipient(0))
+		if err != nil {
+			return nil, err
+		}
+		var payload *types.ExecutionPayloadV1
+		err = c.concurrently(n, func(i int) (err error) {
+			if i == 0 {
+				payload, err = c.get(ctx, clients[i], id, parent, recipient(0))
+				return err
+			}
+			_, err = c.build(ctx, clients[i], parent, timestamp, recipient(i))
+			return err
+		})
+		return payload, err
+	}
+}
+
+// advance imports the payload, and makes it the head.
+func (c *StressCmd) advance(ctx context.Context, client *rpc.Client, payload *types.ExecutionPayloadV1) error {
+	atomic.AddUint64(&c.calls, 2)
+	status, err := api.NewPayloadV1(ctx, client, c.log, payload)
+	if err != nil {
+		return err
+	}
+	if status.Status != types.ExecutionValid {
+		return fmt.Errorf("payload %s not valid: %s", payload.BlockHash, status.Status)
+	}
+	_, err = api.ForkchoiceUpdatedV1(ctx, client, c.log, payload.BlockHash, payload.BlockHash, payload.BlockHash, nil)
+	return err
+}
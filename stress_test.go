@@ -0,0 +1,47 @@
+package mergemock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStressInProcess(t *testing.T) {
+	cmd := new(StressCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Engine.Default()
+	cmd.Engine.LogCmd.Default()
+	cmd.Engine.GasPriceOracle.Default()
+	cmd.Engine.JwtSecretPath = newJwt(t)
+	cmd.Engine.GenesisPath = newGenesis(t)
+	cmd.Engine.ListenAddr = freeAddr(t)
+	cmd.Engine.WebsocketAddr = freeAddr(t)
+	cmd.Rounds = 3
+	cmd.Connections = 3
+	require.NoError(t, cmd.Run(context.Background()))
+	require.Equal(t, uint64(3), cmd.Engine.mockChain().CurrentHeader().Number.Uint64())
+	// Per round: 1+3 same-id, 3+3 overlapping-fcu, 1+3 get-during-fcu, and the import of the head.
+	require.Equal(t, uint64(3*(4+6+4+2)), cmd.calls)
+}
+
+func TestStressEndpoint(t *testing.T) {
+	te := newTestEngine(t)
+	cmd := new(StressCmd)
+	cmd.Default()
+	cmd.LogCmd.Default()
+	cmd.Endpoint = "http://" + te.ListenAddr
+	cmd.JwtSecretPath = te.JwtSecretPath
+	cmd.Rounds = 2
+	cmd.Stagger = time.Millisecond
+	cmd.Interleavings = []string{StressSameID}
+	require.NoError(t, cmd.Run(context.Background()))
+	require.Equal(t, uint64(2), te.mockChain().CurrentHeader().Number.Uint64())
+
+	cmd.Interleavings = []string{"interleaved"}
+	require.Error(t, cmd.Run(context.Background()))
+	cmd.Connections = 1
+	require.Error(t, cmd.Run(context.Background()))
+}
@@ -0,0 +1,258 @@
+package mergemock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+)
+
+type SyncConfig struct {
+	Duration time.Duration `ask:"--duration" help:"Time after start the engine pretends to be syncing for"`
+	Blocks   uint64        `ask:"--blocks" help:"Number of payloads the engine imports while pretending to be syncing, before it catches up"`
+	Phases   []string      `ask:"--phases" help:"Snap sync phases the engine goes through one after the other, as phase=duration of headers, bodies, state and heal, reported by the detail fields of eth_syncing (their total is the least sync duration)"`
+}
+
+// Snap sync phases, as reported by eth_syncing.
+const (
+	SyncPhaseHeaders = "headers" // downloading headers, the current block stays at the start
+	SyncPhaseBodies  = "bodies"  // downloading bodies and receipts, the current block moving to the highest
+	SyncPhaseState   = "state"   // downloading accounts, storage and bytecodes of the state
+	SyncPhaseHeal    = "heal"    // healing the trie nodes that changed during the state download
+)
+
+// Sizes of the scripted state sync, the orders of magnitude of a small testnet.
+const (
+	snapAccounts        = 500_000
+	snapAccountBytes    = 100
+	snapStorage         = 2_000_000
+	snapStorageBytes    = 70
+	snapBytecodes       = 10_000
+	snapBytecodeBytes   = 4_000
+	snapTrienodes       = 50_000
+	snapTrienodeBytes   = 500
+	snapHealedBytecodes = 200
+)
+
+type syncPhase struct {
+	name     string
+	duration time.Duration
+}
+
+// NewSyncSimulator returns the sync simulator of the config, nil if the engine is never syncing.
+func (c *SyncConfig) NewSyncSimulator(log logrus.Ext1FieldLogger, head uint64) (*SyncSimulator, error) {
+	if c.Duration == 0 && c.Blocks == 0 && len(c.Phases) == 0 {
+		return nil, nil
+	}
+	cfg := *c
+	var phases []syncPhase
+	var total time.Duration
+	for _, p := range c.Phases {
+		name, durationStr, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sync phase %q, expected phase=duration", p)
+		}
+		switch name {
+		case SyncPhaseHeaders, SyncPhaseBodies, SyncPhaseState, SyncPhaseHeal:
+		default:
+			return nil, fmt.Errorf("unknown sync phase %q", name)
+		}
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration of sync phase %q", p)
+		}
+		phases = append(phases, syncPhase{name, duration})
+		total += duration
+	}
+	if total > cfg.Duration {
+		cfg.Duration = total
+	}
+	return &SyncSimulator{cfg: cfg, log: log, phases: phases, start: time.Now(), startingBlock: head, highestBlock: head}, nil
+}
+
+// SyncSimulator pretends the engine is syncing after start: newPayload and forkchoiceUpdated answer SYNCING
+// until both the duration has passed and the number of blocks was imported, then the engine behaves normally.
+// Payloads are still imported while syncing, like a real engine backfilling the chain.
+type SyncSimulator struct {
+	cfg    SyncConfig
+	log    logrus.Ext1FieldLogger
+	phases []syncPhase
+
+	mu            sync.Mutex
+	start         time.Time
+	imported      uint64
+	startingBlock uint64
+	highestBlock  uint64
+	synced        bool
+}
+
+// progress returns the fraction of the sync that is done, of the slowest of the duration and the blocks.
+func (s *SyncSimulator) progress() float64 {
+	progress := 1.0
+	if s.cfg.Duration > 0 {
+		if p := float64(time.Since(s.start)) / float64(s.cfg.Duration); p < progress {
+			progress = p
+		}
+	}
+	if s.cfg.Blocks > 0 {
+		if p := float64(s.imported) / float64(s.cfg.Blocks); p < progress {
+			progress = p
+		}
+	}
+	return progress
+}
+
+// Syncing reports whether the call to the method is answered with SYNCING.
+func (s *SyncSimulator) Syncing(method string) bool {
+	if s == nil {
+		return false
+	}
+	if !strings.HasPrefix(method, "engine_newPayload") && !strings.HasPrefix(method, "engine_forkchoiceUpdated") {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced {
+		return false
+	}
+	if s.progress() >= 1 {
+		s.synced = true
+		s.log.WithFields(logrus.Fields{"imported": s.imported, "highest_block": s.highestBlock}).Info("Finished simulated sync")
+		return false
+	}
+	return true
+}
+
+// Imported counts an executed payload with the block number.
+func (s *SyncSimulator) Imported(number uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced {
+		return
+	}
+	s.imported++
+	if number > s.highestBlock {
+		s.highestBlock = number
+	}
+}
+
+// SyncProgress is the eth_syncing result of a syncing engine.
+type SyncProgress struct {
+	StartingBlock hexutil.Uint64 `json:"startingBlock"`
+	CurrentBlock  hexutil.Uint64 `json:"currentBlock"`
+	HighestBlock  hexutil.Uint64 `json:"highestBlock"`
+	*SnapSyncProgress
+}
+
+// SnapSyncProgress is the detail of the state sync, in the fields of geth, with the phase it is in.
+type SnapSyncProgress struct {
+	Phase               string         `json:"phase"`
+	SyncedAccounts      hexutil.Uint64 `json:"syncedAccounts"`
+	SyncedAccountBytes  hexutil.Uint64 `json:"syncedAccountBytes"`
+	SyncedBytecodes     hexutil.Uint64 `json:"syncedBytecodes"`
+	SyncedBytecodeBytes hexutil.Uint64 `json:"syncedBytecodeBytes"`
+	SyncedStorage       hexutil.Uint64 `json:"syncedStorage"`
+	SyncedStorageBytes  hexutil.Uint64 `json:"syncedStorageBytes"`
+	HealedTrienodes     hexutil.Uint64 `json:"healedTrienodes"`
+	HealedTrienodeBytes hexutil.Uint64 `json:"healedTrienodeBytes"`
+	HealedBytecodes     hexutil.Uint64 `json:"healedBytecodes"`
+	HealedBytecodeBytes hexutil.Uint64 `json:"healedBytecodeBytes"`
+	HealingTrienodes    hexutil.Uint64 `json:"healingTrienodes"`
+	HealingBytecode     hexutil.Uint64 `json:"healingBytecode"`
+}
+
+// phase returns the scripted phase the sync is in and how much of it is done, the last one complete once all of them
+// passed, false without phases.
+func (s *SyncSimulator) phase() (string, float64, bool) {
+	if len(s.phases) == 0 {
+		return "", 0, false
+	}
+	elapsed := time.Since(s.start)
+	for _, p := range s.phases {
+		if elapsed < p.duration {
+			return p.name, float64(elapsed) / float64(p.duration), true
+		}
+		elapsed -= p.duration
+	}
+	return s.phases[len(s.phases)-1].name, 1, true
+}
+
+// snapProgress returns the detail fields of the state sync in the phase, done to the fraction. The state is
+// downloaded in full by the end of the state phase, and healed by the end of the heal phase.
+func snapProgress(phase string, done float64) *SnapSyncProgress {
+	progress := &SnapSyncProgress{Phase: phase}
+	var state, heal float64
+	switch phase {
+	case SyncPhaseState:
+		state = done
+	case SyncPhaseHeal:
+		state, heal = 1, done
+	}
+	scale := func(total uint64, fraction float64) hexutil.Uint64 {
+		return hexutil.Uint64(float64(total) * fraction)
+	}
+	progress.SyncedAccounts = scale(snapAccounts, state)
+	progress.SyncedAccountBytes = progress.SyncedAccounts * snapAccountBytes
+	progress.SyncedStorage = scale(snapStorage, state)
+	progress.SyncedStorageBytes = progress.SyncedStorage * snapStorageBytes
+	progress.SyncedBytecodes = scale(snapBytecodes, state)
+	progress.SyncedBytecodeBytes = progress.SyncedBytecodes * snapBytecodeBytes
+	if phase == SyncPhaseHeal {
+		progress.HealedTrienodes = scale(snapTrienodes, heal)
+		progress.HealedTrienodeBytes = progress.HealedTrienodes * snapTrienodeBytes
+		progress.HealedBytecodes = scale(snapHealedBytecodes, heal)
+		progress.HealedBytecodeBytes = progress.HealedBytecodes * snapBytecodeBytes
+		progress.HealingTrienodes = snapTrienodes - progress.HealedTrienodes
+		progress.HealingBytecode = snapHealedBytecodes - progress.HealedBytecodes
+	}
+	return progress
+}
+
+// Progress returns the simulated backfill progress, nil once synced: the current block moves from the head at
+// start to the highest imported block as the sync advances. With phases, it moves during the bodies phase only,
+// and the detail fields report the phase.
+func (s *SyncSimulator) Progress() *SyncProgress {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progress := s.progress()
+	if s.synced || progress >= 1 {
+		return nil
+	}
+	var snap *SnapSyncProgress
+	if phase, done, ok := s.phase(); ok {
+		snap = snapProgress(phase, done)
+		switch phase {
+		case SyncPhaseHeaders:
+			progress = 0
+		case SyncPhaseBodies:
+			progress = done
+		default:
+			progress = 1
+		}
+	}
+	current := s.startingBlock + uint64(float64(s.highestBlock-s.startingBlock)*progress)
+	return &SyncProgress{
+		StartingBlock:    hexutil.Uint64(s.startingBlock),
+		CurrentBlock:     hexutil.Uint64(current),
+		HighestBlock:     hexutil.Uint64(s.highestBlock),
+		SnapSyncProgress: snap,
+	}
+}
+
+// Syncing returns the progress of a simulated sync, or false if the engine isn't syncing.
+func (b *EthBackend) Syncing(ctx context.Context) (interface{}, error) {
+	if progress := b.sync.Progress(); progress != nil {
+		return progress, nil
+	}
+	return false, nil
+}
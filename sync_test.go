@@ -0,0 +1,79 @@
+package mergemock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncSimulatorDuration(t *testing.T) {
+	none, err := (&SyncConfig{}).NewSyncSimulator(logrus.New(), 0)
+	require.NoError(t, err)
+	require.Nil(t, none)
+	require.False(t, none.Syncing("engine_newPayloadV1"))
+	require.Nil(t, none.Progress())
+
+	s, err := (&SyncConfig{Duration: 200 * time.Millisecond}).NewSyncSimulator(logrus.New(), 10)
+	require.NoError(t, err)
+	require.True(t, s.Syncing("engine_newPayloadV2"))
+	require.True(t, s.Syncing("engine_forkchoiceUpdatedV1"))
+	require.False(t, s.Syncing("engine_getPayloadV1"))
+	s.Imported(20)
+	progress := s.Progress()
+	require.NotNil(t, progress)
+	require.Equal(t, uint64(10), uint64(progress.StartingBlock))
+	require.Equal(t, uint64(20), uint64(progress.HighestBlock))
+	require.Less(t, uint64(progress.CurrentBlock), uint64(20))
+	require.Nil(t, progress.SnapSyncProgress, "no detail without phases")
+
+	require.Eventually(t, func() bool { return !s.Syncing("engine_newPayloadV1") }, 5*time.Second, 10*time.Millisecond)
+	require.Nil(t, s.Progress())
+}
+
+func TestSyncSimulatorPhases(t *testing.T) {
+	for _, phases := range [][]string{{"headers"}, {"blocks=1s"}, {"heal=0s"}, {"state=soon"}} {
+		_, err := (&SyncConfig{Phases: phases}).NewSyncSimulator(logrus.New(), 0)
+		require.Error(t, err, phases)
+	}
+
+	s, err := (&SyncConfig{Phases: []string{"headers=10s", "bodies=10s", "state=10s", "heal=10s"}}).NewSyncSimulator(logrus.New(), 10)
+	require.NoError(t, err)
+	require.Equal(t, 40*time.Second, s.cfg.Duration, "the phases last at least their total")
+	s.Imported(30)
+	at := func(elapsed time.Duration) *SyncProgress {
+		s.start = time.Now().Add(-elapsed)
+		progress := s.Progress()
+		require.NotNil(t, progress)
+		require.NotNil(t, progress.SnapSyncProgress)
+		return progress
+	}
+
+	progress := at(5 * time.Second)
+	require.Equal(t, SyncPhaseHeaders, progress.Phase)
+	require.Equal(t, uint64(10), uint64(progress.CurrentBlock))
+	require.Zero(t, progress.SyncedAccounts)
+
+	progress = at(15 * time.Second)
+	require.Equal(t, SyncPhaseBodies, progress.Phase)
+	require.InDelta(t, 20, uint64(progress.CurrentBlock), 1)
+	require.Zero(t, progress.SyncedAccounts)
+
+	progress = at(25 * time.Second)
+	require.Equal(t, SyncPhaseState, progress.Phase)
+	require.Equal(t, uint64(30), uint64(progress.CurrentBlock))
+	require.InDelta(t, snapAccounts/2, uint64(progress.SyncedAccounts), snapAccounts/100)
+	require.Equal(t, progress.SyncedAccounts*snapAccountBytes, progress.SyncedAccountBytes)
+	require.Zero(t, progress.HealedTrienodes)
+
+	progress = at(35 * time.Second)
+	require.Equal(t, SyncPhaseHeal, progress.Phase)
+	require.Equal(t, uint64(snapAccounts), uint64(progress.SyncedAccounts))
+	require.InDelta(t, snapTrienodes/2, uint64(progress.HealedTrienodes), snapTrienodes/100)
+	require.Equal(t, uint64(snapTrienodes), uint64(progress.HealedTrienodes+progress.HealingTrienodes))
+
+	s.start = time.Now().Add(-41 * time.Second)
+	require.False(t, s.Syncing("engine_newPayloadV1"))
+	require.Nil(t, s.Progress())
+}
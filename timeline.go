@@ -0,0 +1,171 @@
+package mergemock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type TimelineConfig struct {
+	Path        string        `ask:"--path" help:"File to write the slot-indexed event timeline to on exit, to render with web/timeline.html (empty to disable)"`
+	GenesisTime uint64        `ask:"--genesis-time" help:"Beacon genesis time the slots of the timeline are counted from (0 for the start of the engine)"`
+	SlotTime    time.Duration `ask:"--slot-time" help:"Slot duration of the timeline"`
+}
+
+func (c *TimelineConfig) Default() {
+	c.SlotTime = 12 * time.Second
+}
+
+// NewTimeline returns the timeline to record into, nil if no timeline is written.
+func (c *TimelineConfig) NewTimeline() *Timeline {
+	if c.Path == "" {
+		return nil
+	}
+	genesis := time.Unix(int64(c.GenesisTime), 0)
+	if c.GenesisTime == 0 {
+		genesis = time.Now()
+	}
+	return &Timeline{genesis: genesis, slotTime: c.SlotTime, slots: make(map[uint64]*TimelineSlot)}
+}
+
+// TimelineReorg is a forkchoice head that doesn't descend from the previous one.
+type TimelineReorg struct {
+	OldHead common.Hash `json:"oldHead"`
+	NewHead common.Hash `json:"newHead"`
+	Depth   uint64      `json:"depth"`
+}
+
+// TimelineFault is a fault injected into a call.
+type TimelineFault struct {
+	Rule   uint64 `json:"rule"`
+	Method string `json:"method"`
+	Action string `json:"action"`
+}
+
+// TimelineSlot is what happened at the engine during a slot.
+type TimelineSlot struct {
+	Slot        uint64            `json:"slot"`
+	Calls       map[string]uint64 `json:"calls"`
+	Faults      []TimelineFault   `json:"faults"`
+	BlocksBuilt []common.Hash     `json:"blocksBuilt"`
+	Reorgs      []TimelineReorg   `json:"reorgs"`
+}
+
+// Timeline records engine events by the slot of the wall clock time they happen at.
+type Timeline struct {
+	genesis  time.Time
+	slotTime time.Duration
+
+	mu    sync.Mutex
+	slots map[uint64]*TimelineSlot
+	head  *types.Header // last forkchoice head, to detect reorgs
+}
+
+func (t *Timeline) slot() *TimelineSlot {
+	var slot uint64
+	if since := time.Since(t.genesis); since > 0 && t.slotTime > 0 {
+		slot = uint64(since / t.slotTime)
+	}
+	s, ok := t.slots[slot]
+	if !ok {
+		s = &TimelineSlot{Slot: slot, Calls: make(map[string]uint64), Faults: []TimelineFault{}, BlocksBuilt: []common.Hash{}, Reorgs: []TimelineReorg{}}
+		t.slots[slot] = s
+	}
+	return s
+}
+
+func (t *Timeline) Call(method string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slot().Calls[method]++
+}
+
+func (t *Timeline) Fault(method string, rule *FaultRule) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.slot()
+	s.Faults = append(s.Faults, TimelineFault{Rule: rule.ID, Method: method, Action: rule.Action})
+}
+
+func (t *Timeline) BlockBuilt(hash common.Hash) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.slot()
+	s.BlocksBuilt = append(s.BlocksBuilt, hash)
+}
+
+// Head records a forkchoice head, and a reorg if it doesn't descend from the previous head.
+// Heads that aren't known blocks are skipped.
+func (t *Timeline) Head(chain *MockChain, specHash common.Hash) {
+	if t == nil {
+		return
+	}
+	head := chain.chain.GetHeaderByHash(chain.ResolveHash(specHash))
+	if head == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.head
+	t.head = head
+	if old == nil || old.Hash() == head.Hash() {
+		return
+	}
+	ancestor := rawdb.FindCommonAncestor(chain.database, old, head)
+	if ancestor == nil || ancestor.Hash() == old.Hash() {
+		return
+	}
+	s := t.slot()
+	s.Reorgs = append(s.Reorgs, TimelineReorg{
+		OldHead: chain.SpecHash(old.Hash()),
+		NewHead: specHash,
+		Depth:   old.Number.Uint64() - ancestor.Number.Uint64(),
+	})
+}
+
+// Slots returns the slots that had events, in order.
+func (t *Timeline) Slots() []TimelineSlot {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slots := make([]TimelineSlot, 0, len(t.slots))
+	for _, s := range t.slots {
+		slots = append(slots, *s)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slot < slots[j].Slot })
+	return slots
+}
+
+// Write writes the timeline as JSON.
+func (t *Timeline) Write(path string) error {
+	buf, err := json.MarshalIndent(struct {
+		GenesisTime int64          `json:"genesisTime"`
+		SlotTime    string         `json:"slotTime"`
+		Slots       []TimelineSlot `json:"slots"`
+	}{t.genesis.Unix(), t.slotTime.String(), t.Slots()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		return fmt.Errorf("failed to write timeline: %v", err)
+	}
+	return nil
+}
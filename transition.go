@@ -0,0 +1,52 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"mergemock/types"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type TransitionConfigOverrides struct {
+	TerminalTotalDifficulty string `ask:"--ttd" help:"Terminal total difficulty to report instead of the genesis one (decimal)"`
+	TerminalBlockHash       string `ask:"--terminal-block-hash" help:"Terminal block hash to report instead of the genesis one"`
+	TerminalBlockNumber     string `ask:"--terminal-block-number" help:"Terminal block number to report instead of the genesis one"`
+}
+
+// Apply replaces the values of the transition configuration that have an override.
+func (o *TransitionConfigOverrides) Apply(config *types.TransitionConfigurationV1) error {
+	if o.TerminalTotalDifficulty != "" {
+		ttd, ok := new(big.Int).SetString(o.TerminalTotalDifficulty, 10)
+		if !ok {
+			return fmt.Errorf("invalid terminal total difficulty %q", o.TerminalTotalDifficulty)
+		}
+		config.TerminalTotalDifficulty = (*hexutil.Big)(ttd)
+	}
+	if o.TerminalBlockHash != "" {
+		var hash common.Hash
+		if err := hash.UnmarshalText([]byte(o.TerminalBlockHash)); err != nil {
+			return fmt.Errorf("invalid terminal block hash: %v", err)
+		}
+		config.TerminalBlockHash = hash
+	}
+	if o.TerminalBlockNumber != "" {
+		number, err := strconv.ParseUint(o.TerminalBlockNumber, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid terminal block number: %v", err)
+		}
+		config.TerminalBlockNumber = hexutil.Uint64(number)
+	}
+	return nil
+}
+
+// TransitionConfig returns the transition configuration of the genesis.
+func (c *MockChain) TransitionConfig() *types.TransitionConfigurationV1 {
+	return &types.TransitionConfigurationV1{
+		TerminalTotalDifficulty: (*hexutil.Big)(c.gspec.Config.TerminalTotalDifficulty),
+		TerminalBlockHash:       c.terminal.Hash,
+		TerminalBlockNumber:     hexutil.Uint64(c.terminal.Number),
+	}
+}
@@ -0,0 +1,401 @@
+package mergemock
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Transaction generation modes.
+const (
+	TxModeNone     = "none"     // build empty payloads
+	TxModeTransfer = "transfer" // ETH transfers between the accounts
+	TxModeERC20    = "erc20"    // token transfers between the accounts, the token is deployed as needed
+	TxModeCall     = "call"     // calls of a contract that burns the gas of the call, deployed as needed
+	TxModeCalldata = "calldata" // transactions carrying large random calldata, for payloads of the largest sizes
+	TxModeLogs     = "logs"     // calls of a contract that logs its calldata until the gas of the call runs out
+)
+
+// Gas limits of generated transactions. Token transfers to new holders take about 52000 gas.
+const (
+	transferGas      = params.TxGas
+	erc20TransferGas = 60_000
+	deployGas        = 300_000
+)
+
+type TxGenConfig struct {
+	Mode         string        `ask:"--mode" help:"Transactions of built payloads: none, transfer, erc20, call, calldata or logs"`
+	Count        uint64        `ask:"--count" help:"Maximum number of transactions per payload (0 for as many as fit the gas target)"`
+	GasTarget    float64       `ask:"--gas-target" help:"Gas of built payloads to fill with transactions, in percent of the gas limit"`
+	CallGas      uint64        `ask:"--call-gas" help:"Gas used by every contract call of the call and logs modes"`
+	CalldataSize uint64        `ask:"--calldata-size" help:"Bytes of random calldata of every transaction of the calldata mode, and of every log of the logs mode"`
+	Slots        []string      `ask:"--slot" help:"Slots to build payloads with another mode at, as slot=mode pairs counted from the genesis block, e.g. 64=calldata to stress a single slot with the largest payloads"`
+	SlotTime     time.Duration `ask:"--slot-time" help:"Slot duration the slots of --tx.slot are counted in"`
+	Accounts     TestAccounts  `ask:"--accounts" help:"Comma-separated list of hex encoded private keys of funded accounts to send transactions from"`
+	Seed         int64         `ask:"--seed" help:"Seed of the recipients and values of transactions (0 for a random seed)"`
+}
+
+func (c *TxGenConfig) Default() {
+	c.Mode = TxModeNone
+	c.GasTarget = 50
+	c.CallGas = 100_000
+	c.CalldataSize = 1024
+	c.SlotTime = 12 * time.Second
+}
+
+func validTxMode(mode string) bool {
+	switch mode {
+	case TxModeNone, TxModeTransfer, TxModeERC20, TxModeCall, TxModeCalldata, TxModeLogs:
+		return true
+	}
+	return false
+}
+
+// NewTxGenerator returns the generator of the config, nil if payloads are built empty. Slots are counted from the
+// genesis time.
+func (c *TxGenConfig) NewTxGenerator(genesisTime uint64) (*TxGenerator, error) {
+	mode := c.Mode
+	if mode == "" {
+		mode = TxModeNone
+	}
+	if !validTxMode(mode) {
+		return nil, fmt.Errorf("unknown transaction mode %q", c.Mode)
+	}
+	slots := make(map[uint64]string)
+	for _, s := range c.Slots {
+		slotStr, slotMode, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("transaction mode slot %q, expected slot=mode", s)
+		}
+		slot, err := strconv.ParseUint(slotStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot of %q: %v", s, err)
+		}
+		if !validTxMode(slotMode) {
+			return nil, fmt.Errorf("unknown transaction mode %q of slot %d", slotMode, slot)
+		}
+		slots[slot] = slotMode
+	}
+	if mode == TxModeNone && len(slots) == 0 {
+		return nil, nil
+	}
+	if len(slots) > 0 && c.SlotTime < time.Second {
+		return nil, fmt.Errorf("slot time %s is shorter than a second", c.SlotTime)
+	}
+	if len(c.Accounts.accounts) == 0 {
+		return nil, fmt.Errorf("transaction mode %s needs funded accounts", c.Mode)
+	}
+	if c.GasTarget <= 0 || c.GasTarget > 100 {
+		return nil, fmt.Errorf("gas target must be a percentage of the gas limit, got %v", c.GasTarget)
+	}
+	if c.CallGas < params.TxGas+1000 {
+		return nil, fmt.Errorf("call gas must be at least %d", params.TxGas+1000)
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &TxGenerator{
+		cfg:         *c,
+		mode:        mode,
+		slots:       slots,
+		genesisTime: genesisTime,
+		rng:         rand.New(rand.NewSource(seed)),
+		contracts:   make(map[string]common.Address),
+	}, nil
+}
+
+// TxGenerator creates the candidate transactions of built payloads.
+type TxGenerator struct {
+	cfg         TxGenConfig
+	mode        string
+	slots       map[uint64]string
+	genesisTime uint64
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	contracts map[string]common.Address // token, gas burner or logger by mode, deployed again if missing from the parent state
+}
+
+// modeAt returns the mode of the payload built at the timestamp.
+func (g *TxGenerator) modeAt(timestamp uint64) string {
+	if len(g.slots) > 0 && timestamp >= g.genesisTime {
+		if mode, ok := g.slots[(timestamp-g.genesisTime)/uint64(g.cfg.SlotTime/time.Second)]; ok {
+			return mode
+		}
+	}
+	return g.mode
+}
+
+// Creator returns the transactions creator of built payloads, which creates no transactions without generator.
+func (g *TxGenerator) Creator() TransactionsCreator {
+	if g == nil {
+		return TransactionsCreator{nil, func(*params.ChainConfig, core.ChainContext, *state.StateDB, *types.Header, vm.Config, []TestAccount) []*types.Transaction {
+			return nil
+		}}
+	}
+	return TransactionsCreator{g.cfg.Accounts.accounts, g.generate}
+}
+
+// txBatch signs the transactions of a block, with the nonces of the accounts and gas used so far.
+type txBatch struct {
+	signer  types.Signer
+	statedb *state.StateDB
+	header  *types.Header
+	chainID *big.Int
+	nonces  map[common.Address]uint64
+	gas     uint64
+	budget  uint64
+	txs     []*types.Transaction
+}
+
+// add signs and appends a transaction, and reports whether it still fits the gas budget.
+func (b *txBatch) add(from TestAccount, to *common.Address, value *big.Int, gas uint64, data []byte) bool {
+	if b.gas+gas > b.budget {
+		return false
+	}
+	nonce, ok := b.nonces[from.addr]
+	if !ok {
+		nonce = b.statedb.GetNonce(from.addr)
+	}
+	tip := big.NewInt(params.GWei)
+	feeCap := new(big.Int).Add(tip, big.NewInt(params.GWei))
+	if b.header.BaseFee != nil {
+		feeCap = new(big.Int).Add(tip, new(big.Int).Mul(b.header.BaseFee, common.Big2))
+	}
+	tx, err := types.SignNewTx(from.pk, b.signer, &types.DynamicFeeTx{
+		ChainID:   b.chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        to,
+		Value:     value,
+		Data:      data,
+	})
+	if err != nil {
+		return false
+	}
+	b.nonces[from.addr] = nonce + 1
+	b.gas += gas
+	b.txs = append(b.txs, tx)
+	return true
+}
+
+func (g *TxGenerator) generate(config *params.ChainConfig, bc core.ChainContext, statedb *state.StateDB, header *types.Header, cfg vm.Config, accounts []TestAccount) []*types.Transaction {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	batch := &txBatch{
+		signer:  types.LatestSigner(config),
+		statedb: statedb,
+		header:  header,
+		chainID: config.ChainID,
+		nonces:  make(map[common.Address]uint64),
+		budget:  uint64(float64(header.GasLimit) * g.cfg.GasTarget / 100),
+	}
+	mode := g.modeAt(header.Time)
+	if mode == TxModeNone {
+		return nil
+	}
+	contract, deployed := g.contracts[mode]
+	if code, ok := contractInitCode[mode]; ok && (!deployed || statedb.GetCodeSize(contract) == 0) {
+		deployer := accounts[0]
+		contract = crypto.CreateAddress(deployer.addr, statedb.GetNonce(deployer.addr))
+		if !batch.add(deployer, nil, new(big.Int), deployGas, code) {
+			return nil
+		}
+		g.contracts[mode] = contract
+	}
+	for i := 0; g.cfg.Count == 0 || uint64(len(batch.txs)) < g.cfg.Count; i++ {
+		from := accounts[i%len(accounts)]
+		var ok bool
+		switch mode {
+		case TxModeTransfer:
+			to := g.recipient(accounts)
+			ok = batch.add(from, &to, new(big.Int).SetUint64(uint64(g.rng.Int63n(params.GWei*1000))+1), transferGas, nil)
+		case TxModeERC20:
+			// Only the deployer holds tokens at first, the others send what they were sent.
+			if statedb.GetState(contract, from.addr.Hash()) == (common.Hash{}) {
+				from = accounts[0]
+			}
+			to := g.recipient(accounts)
+			ok = batch.add(from, &contract, new(big.Int), erc20TransferGas, tokenTransferData(to, big.NewInt(g.rng.Int63n(1000)+1)))
+		case TxModeCall:
+			ok = batch.add(from, &contract, new(big.Int), g.cfg.CallGas, nil)
+		case TxModeCalldata:
+			to := g.recipient(accounts)
+			data := g.calldata()
+			ok = batch.add(from, &to, new(big.Int), calldataGas(data), data)
+		case TxModeLogs:
+			data := g.calldata()
+			ok = batch.add(from, &contract, new(big.Int), calldataGas(data)+g.cfg.CallGas, data)
+		}
+		if !ok {
+			break
+		}
+	}
+	return batch.txs
+}
+
+// calldata returns random calldata of the configured size, which compresses badly like real calldata.
+func (g *TxGenerator) calldata() []byte {
+	data := make([]byte, g.cfg.CalldataSize)
+	g.rng.Read(data)
+	return data
+}
+
+// calldataGas returns the intrinsic gas of a call with the data.
+func calldataGas(data []byte) uint64 {
+	gas, err := core.IntrinsicGas(data, nil, false, true, true)
+	if err != nil {
+		// only overflows for data far beyond the gas limit
+		panic(err)
+	}
+	return gas
+}
+
+// recipient picks one of the accounts, or a fresh address once in a while.
+func (g *TxGenerator) recipient(accounts []TestAccount) common.Address {
+	if len(accounts) == 1 || g.rng.Intn(4) == 0 {
+		var addr common.Address
+		g.rng.Read(addr[:])
+		return addr
+	}
+	return accounts[g.rng.Intn(len(accounts))].addr
+}
+
+// program assembles EVM code. Jumps refer to labels, which must be within the first 256 bytes of code.
+type program struct {
+	code   []byte
+	labels map[string]byte
+	jumps  map[int]string
+}
+
+func newProgram() *program {
+	return &program{labels: make(map[string]byte), jumps: make(map[int]string)}
+}
+
+func (p *program) op(ops ...vm.OpCode) *program {
+	for _, op := range ops {
+		p.code = append(p.code, byte(op))
+	}
+	return p
+}
+
+// push pushes the value with the smallest PUSH instruction that fits it.
+func (p *program) push(value []byte) *program {
+	if len(value) == 0 {
+		value = []byte{0}
+	}
+	p.code = append(p.code, byte(vm.PUSH1)+byte(len(value)-1))
+	p.code = append(p.code, value...)
+	return p
+}
+
+func (p *program) pushInt(v uint64) *program {
+	return p.push(new(big.Int).SetUint64(v).Bytes())
+}
+
+// label marks the next instruction as jump destination.
+func (p *program) label(name string) *program {
+	p.labels[name] = byte(len(p.code))
+	return p.op(vm.JUMPDEST)
+}
+
+// jump jumps to the label, if the top of the stack is not zero with JUMPI.
+func (p *program) jump(name string, op vm.OpCode) *program {
+	p.jumps[len(p.code)+1] = name
+	return p.push([]byte{0}).op(op)
+}
+
+func (p *program) bytes() []byte {
+	code := common.CopyBytes(p.code)
+	for offset, name := range p.jumps {
+		dest, ok := p.labels[name]
+		if !ok {
+			panic(fmt.Sprintf("unknown label %s", name))
+		}
+		code[offset] = dest
+	}
+	return code
+}
+
+// deployCode returns init code that runs the constructor, and deploys the runtime code.
+func deployCode(constructor *program, runtime []byte) []byte {
+	// The copy is 11 bytes long, as long as the runtime is shorter than 256 bytes.
+	offset := len(constructor.code) + 11
+	init := constructor.
+		pushInt(uint64(len(runtime))).op(vm.DUP1).
+		pushInt(uint64(offset)).pushInt(0).op(vm.CODECOPY).
+		pushInt(0).op(vm.RETURN).bytes()
+	return append(init, runtime...)
+}
+
+// The gas burner loops until the call has about 100 gas left.
+var burnerInitCode = deployCode(newProgram(), newProgram().
+	label("loop").pushInt(100).op(vm.GAS, vm.GT).jump("loop", vm.JUMPI).
+	op(vm.STOP).bytes())
+
+// The logger copies its calldata to memory, and logs it until the call has too little gas left for another log.
+var loggerInitCode = deployCode(newProgram(), newProgram().
+	op(vm.CALLDATASIZE).pushInt(0).pushInt(0).op(vm.CALLDATACOPY).
+	label("loop").op(vm.CALLDATASIZE).pushInt(8).op(vm.MUL).pushInt(1000).op(vm.ADD, vm.GAS, vm.GT).jump("log", vm.JUMPI).
+	op(vm.STOP).
+	label("log").op(vm.CALLDATASIZE).pushInt(0).op(vm.LOG0).jump("loop", vm.JUMP).
+	bytes())
+
+// contractInitCode is the code of the contract the transactions of the modes call, deployed as needed.
+var contractInitCode = map[string][]byte{
+	TxModeERC20: tokenInitCode,
+	TxModeCall:  burnerInitCode,
+	TxModeLogs:  loggerInitCode,
+}
+
+var (
+	// tokenTransferSelector is the selector of transfer(address,uint256).
+	tokenTransferSelector = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+	// tokenBalanceOfSelector is the selector of balanceOf(address).
+	tokenBalanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	tokenTransferTopic     = crypto.Keccak256([]byte("Transfer(address,address,uint256)"))
+)
+
+// The token implements the transfer and balanceOf methods and the Transfer event of ERC20, the deployer holds
+// the whole supply. Balances are stored at the address of their holder.
+var tokenInitCode = deployCode(
+	newProgram().push(new(big.Int).Lsh(common.Big1, 128).Bytes()).op(vm.CALLER, vm.SSTORE),
+	newProgram().
+		pushInt(0).op(vm.CALLDATALOAD).pushInt(0xe0).op(vm.SHR).
+		op(vm.DUP1).push(tokenTransferSelector).op(vm.EQ).jump("transfer", vm.JUMPI).
+		op(vm.DUP1).push(tokenBalanceOfSelector).op(vm.EQ).jump("balanceOf", vm.JUMPI).
+		label("revert").pushInt(0).op(vm.DUP1, vm.REVERT).
+		// stack: amount, balance of the sender
+		label("transfer").pushInt(0x24).op(vm.CALLDATALOAD).op(vm.CALLER, vm.SLOAD).
+		op(vm.DUP2, vm.DUP2, vm.LT).jump("revert", vm.JUMPI).
+		op(vm.DUP2, vm.SWAP1, vm.SUB, vm.CALLER, vm.SSTORE).
+		// stack: amount, recipient, balance of the recipient
+		pushInt(0x04).op(vm.CALLDATALOAD).op(vm.DUP1, vm.SLOAD, vm.DUP3, vm.ADD, vm.SWAP1, vm.SSTORE).
+		pushInt(0).op(vm.MSTORE).
+		pushInt(0x04).op(vm.CALLDATALOAD).op(vm.CALLER).push(tokenTransferTopic).pushInt(0x20).pushInt(0).op(vm.LOG3).
+		pushInt(1).pushInt(0).op(vm.MSTORE).pushInt(0x20).pushInt(0).op(vm.RETURN).
+		label("balanceOf").pushInt(0x04).op(vm.CALLDATALOAD, vm.SLOAD).pushInt(0).op(vm.MSTORE).
+		pushInt(0x20).pushInt(0).op(vm.RETURN).
+		bytes(),
+)
+
+func tokenTransferData(to common.Address, amount *big.Int) []byte {
+	data := common.CopyBytes(tokenTransferSelector)
+	data = append(data, common.LeftPadBytes(to.Bytes(), 32)...)
+	return append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+}
@@ -0,0 +1,236 @@
+package mergemock
+
+import (
+	"context"
+	"math/big"
+	"mergemock/api"
+	"mergemock/types"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func newTxGenEngine(t *testing.T, configure func(cfg *TxGenConfig)) *testEngine {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return newTestEngineWithGenesis(t, newFundedGenesis(t, crypto.PubkeyToAddress(key.PublicKey)), func(cmd *EngineCmd) {
+		cmd.Txs.Default()
+		require.NoError(t, cmd.Txs.Accounts.Set(common.Bytes2Hex(crypto.FromECDSA(key))))
+		cmd.Txs.Seed = 1
+		configure(&cmd.Txs)
+	})
+}
+
+// buildAndImport builds a payload on the parent, imports it and checks that every candidate was included.
+func (te *testEngine) buildAndImport(t *testing.T, parent *types.ExecutionPayloadV1, number uint64) *types.ExecutionPayloadV1 {
+	genesis := te.mockChain().CurrentHeader()
+	parentHash, timestamp := genesis.Hash(), genesis.Time+12
+	if parent != nil {
+		parentHash, timestamp = parent.BlockHash, parent.Timestamp+12
+	}
+	payload := te.buildPayload(t, parentHash, timestamp, common.Hash{byte(number)})
+	require.Equal(t, types.ExecutionValid, te.newPayload(t, payload))
+	var log BuildLog
+	require.NoError(t, te.client.CallContext(context.Background(), &log, "mock_getBuildLog", payload.BlockHash))
+	require.Len(t, log.Transactions, len(payload.Transactions))
+	require.Empty(t, log.Excluded())
+	return payload
+}
+
+func decodeTxs(t *testing.T, payload *types.ExecutionPayloadV1) []*ethTypes.Transaction {
+	txs := make([]*ethTypes.Transaction, 0, len(payload.Transactions))
+	for _, enc := range payload.Transactions {
+		tx := new(ethTypes.Transaction)
+		require.NoError(t, tx.UnmarshalBinary(enc))
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func TestTxGenTransfers(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeTransfer
+		cfg.Count = 5
+	})
+	first := te.buildAndImport(t, nil, 1)
+	require.Len(t, first.Transactions, 5)
+	second := te.buildAndImport(t, first, 2)
+	require.Len(t, second.Transactions, 5)
+	for i, tx := range decodeTxs(t, second) {
+		require.Equal(t, uint64(5+i), tx.Nonce())
+		require.Equal(t, transferGas, tx.Gas())
+	}
+}
+
+func TestTxGenERC20(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeERC20
+		cfg.GasTarget = 1
+	})
+	first := te.buildAndImport(t, nil, 1)
+	txs := decodeTxs(t, first)
+	require.Nil(t, txs[0].To(), "the token is deployed first")
+	// 1% of 30M gas fits the deployment and transfers
+	require.Len(t, txs, 1+(300_000-deployGas)/erc20TransferGas)
+
+	second := te.buildAndImport(t, first, 2)
+	txs = decodeTxs(t, second)
+	require.Len(t, txs, 300_000/erc20TransferGas, "the token isn't deployed again")
+	token := *txs[0].To()
+
+	ctx := context.Background()
+	balanceOf := func(holder common.Address) *big.Int {
+		data := hexutil.Bytes(append(common.CopyBytes(tokenBalanceOfSelector), common.LeftPadBytes(holder.Bytes(), 32)...))
+		var result hexutil.Bytes
+		require.NoError(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{To: &token, Data: &data}, second.BlockHash.Hex()))
+		return new(big.Int).SetBytes(result)
+	}
+	for _, tx := range txs {
+		to := common.BytesToAddress(tx.Data()[4:36])
+		require.Positive(t, balanceOf(to).Sign(), "balance of %s", to)
+	}
+	// Transfers beyond the balance revert.
+	data := hexutil.Bytes(tokenTransferData(common.Address{0x01}, big.NewInt(1)))
+	from := common.Address{0x99}
+	var result hexutil.Bytes
+	require.Error(t, te.client.CallContext(ctx, &result, "eth_call", CallArgs{From: &from, To: &token, Data: &data}, second.BlockHash.Hex()))
+}
+
+func TestTxGenCalls(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeCall
+		cfg.GasTarget = 10
+		cfg.CallGas = 200_000
+	})
+	first := te.buildAndImport(t, nil, 1)
+	second := te.buildAndImport(t, first, 2)
+	// 10% of 30M gas in calls that use almost all of their gas
+	require.Len(t, second.Transactions, 15)
+	require.Greater(t, second.GasUsed, uint64(2_900_000))
+	require.LessOrEqual(t, second.GasUsed, uint64(3_000_000))
+}
+
+func TestTxGenCalldata(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeCalldata
+		cfg.GasTarget = 10
+		cfg.CalldataSize = 10_000
+	})
+	payload := te.buildAndImport(t, nil, 1)
+	txs := decodeTxs(t, payload)
+	require.Greater(t, len(txs), 10)
+	size := 0
+	for _, tx := range txs {
+		require.Len(t, tx.Data(), 10_000)
+		require.Equal(t, calldataGas(tx.Data()), tx.Gas())
+		size += len(tx.Data())
+	}
+	require.Greater(t, size, 100_000)
+	require.LessOrEqual(t, payload.GasUsed, uint64(3_000_000))
+}
+
+func TestTxGenLogs(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeLogs
+		cfg.Count = 3
+		cfg.CallGas = 200_000
+	})
+	payload := te.buildAndImport(t, nil, 1)
+	te.setHead(t, payload.BlockHash)
+	txs := decodeTxs(t, payload)
+	require.Len(t, txs, 3)
+	require.Nil(t, txs[0].To(), "the logger is deployed first")
+	var receipt struct {
+		Status string         `json:"status"`
+		Logs   []ethTypes.Log `json:"logs"`
+	}
+	require.NoError(t, te.client.CallContext(context.Background(), &receipt, "eth_getTransactionReceipt", txs[1].Hash()))
+	require.Equal(t, "0x1", receipt.Status)
+	// every log of 1024 bytes costs about 8.6k gas
+	require.Greater(t, len(receipt.Logs), 15)
+	for _, log := range receipt.Logs {
+		require.Equal(t, txs[1].Data(), log.Data)
+	}
+}
+
+func TestTxGenSlots(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Slots = []string{"2=calldata"}
+		cfg.GasTarget = 1
+	})
+	first := te.buildAndImport(t, nil, 1)
+	require.Empty(t, first.Transactions)
+	second := te.buildAndImport(t, first, 2)
+	require.NotEmpty(t, second.Transactions)
+	for _, tx := range decodeTxs(t, second) {
+		require.Len(t, tx.Data(), 1024)
+	}
+	third := te.buildAndImport(t, second, 3)
+	require.Empty(t, third.Transactions)
+}
+
+func TestTxGenConfigValidation(t *testing.T) {
+	var cfg TxGenConfig
+	cfg.Default()
+	g, err := cfg.NewTxGenerator(0)
+	require.NoError(t, err)
+	require.Nil(t, g)
+	cfg.Mode = TxModeTransfer
+	_, err = cfg.NewTxGenerator(0)
+	require.Error(t, err, "accounts are needed")
+	cfg.Mode = "spam"
+	_, err = cfg.NewTxGenerator(0)
+	require.Error(t, err)
+	cfg.Mode = TxModeNone
+	cfg.Slots = []string{"64"}
+	_, err = cfg.NewTxGenerator(0)
+	require.Error(t, err, "slots need a mode")
+	cfg.Slots = []string{"64=spam"}
+	_, err = cfg.NewTxGenerator(0)
+	require.Error(t, err)
+}
+
+func TestEthReceiptsAndLogs(t *testing.T) {
+	te := newTxGenEngine(t, func(cfg *TxGenConfig) {
+		cfg.Mode = TxModeERC20
+		cfg.GasTarget = 1
+	})
+	ctx := context.Background()
+	genesis := te.mockChain().CurrentHeader().Hash()
+	payload := te.buildAndImport(t, nil, 1)
+	_, err := api.ForkchoiceUpdatedV1(ctx, te.client, te.log, payload.BlockHash, genesis, genesis, nil)
+	require.NoError(t, err)
+	txs := decodeTxs(t, payload)
+
+	var number, chainID hexutil.Uint64
+	require.NoError(t, te.client.CallContext(ctx, &number, "eth_blockNumber"))
+	require.Equal(t, hexutil.Uint64(1), number)
+	require.NoError(t, te.client.CallContext(ctx, &chainID, "eth_chainId"))
+	require.Equal(t, hexutil.Uint64(te.mockChain().chain.Config().ChainID.Uint64()), chainID)
+
+	var deployment map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &deployment, "eth_getTransactionReceipt", txs[0].Hash()))
+	require.Equal(t, "0x1", deployment["status"])
+	require.Equal(t, "0x1", deployment["blockNumber"])
+	token := common.HexToAddress(deployment["contractAddress"].(string))
+	var unknown map[string]interface{}
+	require.NoError(t, te.client.CallContext(ctx, &unknown, "eth_getTransactionReceipt", common.Hash{0xff}))
+	require.Nil(t, unknown)
+
+	var logs []ethTypes.Log
+	filter := map[string]interface{}{"fromBlock": "earliest", "address": token, "topics": []interface{}{common.BytesToHash(tokenTransferTopic)}}
+	require.NoError(t, te.client.CallContext(ctx, &logs, "eth_getLogs", filter))
+	require.Len(t, logs, len(txs)-1, "every transfer logs once")
+	for i, log := range logs {
+		require.Equal(t, txs[i+1].Hash(), log.TxHash)
+		require.Equal(t, common.BytesToAddress(txs[i+1].Data()[4:36]), common.BytesToAddress(log.Topics[2].Bytes()))
+	}
+	require.NoError(t, te.client.CallContext(ctx, &logs, "eth_getLogs", map[string]interface{}{"blockHash": genesis}))
+	require.Empty(t, logs)
+	require.NoError(t, te.client.CallContext(ctx, &logs, "eth_getLogs", map[string]interface{}{"address": common.Address{0x01}}))
+	require.Empty(t, logs)
+}
@@ -0,0 +1,48 @@
+package types
+
+import "math/big"
+
+// EIP-4844 blob gas parameters, as activated in Cancun.
+const (
+	BlobGasPerBlob             = 1 << 17
+	TargetBlobsPerBlock        = 3
+	MaxBlobsPerBlock           = 6
+	MinBlobGasPrice            = 1
+	BlobGasPriceUpdateFraction = 3338477
+)
+
+// BlobGasPriceUpdateFractionPrague is the update fraction of the blob gas price in Prague, per EIP-7691.
+const BlobGasPriceUpdateFractionPrague = 5007716
+
+// CalcExcessBlobGas returns the excess blob gas of a block building on a parent with the given
+// excess and used blob gas, for a chain targeting targetBlobs blobs per block.
+func CalcExcessBlobGas(parentExcessBlobGas uint64, parentBlobGasUsed uint64, targetBlobs uint64) uint64 {
+	target := targetBlobs * BlobGasPerBlob
+	if parentExcessBlobGas+parentBlobGasUsed < target {
+		return 0
+	}
+	return parentExcessBlobGas + parentBlobGasUsed - target
+}
+
+// CalcBlobFee returns the blob gas price of a block with the given excess blob gas, for a chain whose blob gas
+// price changes by the given update fraction.
+func CalcBlobFee(excessBlobGas uint64, updateFraction uint64) *big.Int {
+	return fakeExponential(big.NewInt(MinBlobGasPrice), new(big.Int).SetUint64(excessBlobGas), new(big.Int).SetUint64(updateFraction))
+}
+
+// fakeExponential approximates factor * e ** (numerator / denominator) using Taylor expansion,
+// exactly as specified in EIP-4844.
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	var (
+		output = new(big.Int)
+		accum  = new(big.Int).Mul(factor, denominator)
+	)
+	for i := 1; accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+
+		accum.Mul(accum, numerator)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(int64(i)))
+	}
+	return output.Div(output, denominator)
+}
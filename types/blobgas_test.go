@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcExcessBlobGas(t *testing.T) {
+	target := uint64(TargetBlobsPerBlock * BlobGasPerBlob)
+	tests := []struct {
+		excess, blobs, want uint64
+	}{
+		{0, 0, 0},
+		{0, 1, 0},
+		{0, TargetBlobsPerBlock, 0},
+		{0, TargetBlobsPerBlock + 1, BlobGasPerBlob},
+		{0, MaxBlobsPerBlock, target},
+		{target, MaxBlobsPerBlock, 2 * target},
+		{target, 0, 0},
+		{BlobGasPerBlob, TargetBlobsPerBlock - 1, 0},
+		{2 * BlobGasPerBlob, TargetBlobsPerBlock - 1, BlobGasPerBlob},
+	}
+	for _, tt := range tests {
+		got := CalcExcessBlobGas(tt.excess, tt.blobs*BlobGasPerBlob, TargetBlobsPerBlock)
+		require.Equal(t, tt.want, got, "excess %d, blobs %d", tt.excess, tt.blobs)
+	}
+	// A higher target absorbs more blobs before excess starts to build up.
+	require.Zero(t, CalcExcessBlobGas(0, 6*BlobGasPerBlob, 6))
+}
+
+func TestCalcBlobFee(t *testing.T) {
+	tests := []struct {
+		excess uint64
+		want   int64
+	}{
+		{0, 1},
+		{2314057, 1},
+		{2314058, 2},
+		{10 * 1024 * 1024, 23},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, CalcBlobFee(tt.excess, BlobGasPriceUpdateFraction).Int64(), "excess %d", tt.excess)
+	}
+	// The larger update fraction of Prague raises the price slower.
+	require.Equal(t, int64(1), CalcBlobFee(2314058, BlobGasPriceUpdateFractionPrague).Int64())
+	require.Equal(t, int64(8), CalcBlobFee(10*1024*1024, BlobGasPriceUpdateFractionPrague).Int64())
+}
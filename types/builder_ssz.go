@@ -0,0 +1,528 @@
+package types
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ssz "github.com/ferranbt/fastssz"
+)
+
+// The SSZ encodings of the builder messages sszgen can't generate: the payloads hold hexutil and big integer fields,
+// and the signed messages hold the signature types. They follow the layout of the generated code.
+
+const (
+	// maxExtraDataBytes is MAX_EXTRA_DATA_BYTES of the bellatrix spec.
+	maxExtraDataBytes = 32
+	// maxTransactionsPerPayload and maxBytesPerTransaction are the bellatrix limits of the payload transactions.
+	maxTransactionsPerPayload = 1048576
+	maxBytesPerTransaction    = 1073741824
+	// maxValidatorRegistrations is VALIDATOR_REGISTRY_LIMIT, the limit of the registrations of a request.
+	maxValidatorRegistrations = 1099511627776
+)
+
+// MarshalSSZ ssz marshals the ExecutionPayloadREST object
+func (e *ExecutionPayloadREST) MarshalSSZ() ([]byte, error) {
+	return ssz.MarshalSSZ(e)
+}
+
+// MarshalSSZTo ssz marshals the ExecutionPayloadREST object to a target array
+func (e *ExecutionPayloadREST) MarshalSSZTo(buf []byte) (dst []byte, err error) {
+	dst = buf
+	offset := int(508)
+
+	// Field (0) 'ParentHash'
+	dst = append(dst, e.ParentHash[:]...)
+
+	// Field (1) 'FeeRecipient'
+	dst = append(dst, e.FeeRecipient[:]...)
+
+	// Field (2) 'StateRoot'
+	dst = append(dst, e.StateRoot[:]...)
+
+	// Field (3) 'ReceiptsRoot'
+	dst = append(dst, e.ReceiptsRoot[:]...)
+
+	// Field (4) 'LogsBloom'
+	dst = append(dst, e.LogsBloom[:]...)
+
+	// Field (5) 'Random'
+	dst = append(dst, e.Random[:]...)
+
+	// Field (6) 'BlockNumber'
+	dst = ssz.MarshalUint64(dst, e.BlockNumber)
+
+	// Field (7) 'GasLimit'
+	dst = ssz.MarshalUint64(dst, e.GasLimit)
+
+	// Field (8) 'GasUsed'
+	dst = ssz.MarshalUint64(dst, e.GasUsed)
+
+	// Field (9) 'Timestamp'
+	dst = ssz.MarshalUint64(dst, e.Timestamp)
+
+	// Offset (10) 'ExtraData'
+	dst = ssz.WriteOffset(dst, offset)
+	offset += len(e.ExtraData)
+
+	// Field (11) 'BaseFeePerGas'
+	dst = append(dst, e.BaseFeePerGas[:]...)
+
+	// Field (12) 'BlockHash'
+	dst = append(dst, e.BlockHash[:]...)
+
+	// Offset (13) 'Transactions'
+	dst = ssz.WriteOffset(dst, offset)
+
+	// Field (10) 'ExtraData'
+	if len(e.ExtraData) > maxExtraDataBytes {
+		err = ssz.ErrBytesLength
+		return
+	}
+	dst = append(dst, e.ExtraData...)
+
+	// Field (13) 'Transactions'
+	if len(e.Transactions) > maxTransactionsPerPayload {
+		err = ssz.ErrListTooBig
+		return
+	}
+	{
+		offset = 4 * len(e.Transactions)
+		for ii := 0; ii < len(e.Transactions); ii++ {
+			dst = ssz.WriteOffset(dst, offset)
+			offset += len(e.Transactions[ii])
+		}
+	}
+	for ii := 0; ii < len(e.Transactions); ii++ {
+		if len(e.Transactions[ii]) > maxBytesPerTransaction {
+			err = ssz.ErrBytesLength
+			return
+		}
+		dst = append(dst, e.Transactions[ii]...)
+	}
+
+	return
+}
+
+// UnmarshalSSZ ssz unmarshals the ExecutionPayloadREST object
+func (e *ExecutionPayloadREST) UnmarshalSSZ(buf []byte) error {
+	var err error
+	size := uint64(len(buf))
+	if size < 508 {
+		return ssz.ErrSize
+	}
+
+	tail := buf
+	var o10, o13 uint64
+
+	// Field (0) 'ParentHash'
+	copy(e.ParentHash[:], buf[0:32])
+
+	// Field (1) 'FeeRecipient'
+	copy(e.FeeRecipient[:], buf[32:52])
+
+	// Field (2) 'StateRoot'
+	copy(e.StateRoot[:], buf[52:84])
+
+	// Field (3) 'ReceiptsRoot'
+	copy(e.ReceiptsRoot[:], buf[84:116])
+
+	// Field (4) 'LogsBloom'
+	copy(e.LogsBloom[:], buf[116:372])
+
+	// Field (5) 'Random'
+	copy(e.Random[:], buf[372:404])
+
+	// Field (6) 'BlockNumber'
+	e.BlockNumber = ssz.UnmarshallUint64(buf[404:412])
+
+	// Field (7) 'GasLimit'
+	e.GasLimit = ssz.UnmarshallUint64(buf[412:420])
+
+	// Field (8) 'GasUsed'
+	e.GasUsed = ssz.UnmarshallUint64(buf[420:428])
+
+	// Field (9) 'Timestamp'
+	e.Timestamp = ssz.UnmarshallUint64(buf[428:436])
+
+	// Offset (10) 'ExtraData'
+	if o10 = ssz.ReadOffset(buf[436:440]); o10 > size {
+		return ssz.ErrOffset
+	}
+
+	if o10 < 508 {
+		return ssz.ErrInvalidVariableOffset
+	}
+
+	// Field (11) 'BaseFeePerGas'
+	copy(e.BaseFeePerGas[:], buf[440:472])
+
+	// Field (12) 'BlockHash'
+	copy(e.BlockHash[:], buf[472:504])
+
+	// Offset (13) 'Transactions'
+	if o13 = ssz.ReadOffset(buf[504:508]); o13 > size || o10 > o13 {
+		return ssz.ErrOffset
+	}
+
+	// Field (10) 'ExtraData'
+	{
+		buf = tail[o10:o13]
+		if len(buf) > maxExtraDataBytes {
+			return ssz.ErrBytesLength
+		}
+		e.ExtraData = append(make([]byte, 0, len(buf)), buf...)
+	}
+
+	// Field (13) 'Transactions'
+	{
+		buf = tail[o13:]
+		num, err := ssz.DecodeDynamicLength(buf, maxTransactionsPerPayload)
+		if err != nil {
+			return err
+		}
+		e.Transactions = make([]hexutil.Bytes, num)
+		err = ssz.UnmarshalDynamic(buf, num, func(indx int, buf []byte) (err error) {
+			if len(buf) > maxBytesPerTransaction {
+				return ssz.ErrBytesLength
+			}
+			e.Transactions[indx] = append(make([]byte, 0, len(buf)), buf...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// SizeSSZ returns the ssz encoded size in bytes for the ExecutionPayloadREST object
+func (e *ExecutionPayloadREST) SizeSSZ() (size int) {
+	size = 508
+
+	// Field (10) 'ExtraData'
+	size += len(e.ExtraData)
+
+	// Field (13) 'Transactions'
+	for ii := 0; ii < len(e.Transactions); ii++ {
+		size += 4
+		size += len(e.Transactions[ii])
+	}
+
+	return
+}
+
+// HashTreeRoot ssz hashes the ExecutionPayloadREST object
+func (e *ExecutionPayloadREST) HashTreeRoot() ([32]byte, error) {
+	return ssz.HashWithDefaultHasher(e)
+}
+
+// HashTreeRootWith ssz hashes the ExecutionPayloadREST object with a hasher
+func (e *ExecutionPayloadREST) HashTreeRootWith(hh *ssz.Hasher) (err error) {
+	indx := hh.Index()
+
+	// Field (0) 'ParentHash'
+	hh.PutBytes(e.ParentHash[:])
+
+	// Field (1) 'FeeRecipient'
+	hh.PutBytes(e.FeeRecipient[:])
+
+	// Field (2) 'StateRoot'
+	hh.PutBytes(e.StateRoot[:])
+
+	// Field (3) 'ReceiptsRoot'
+	hh.PutBytes(e.ReceiptsRoot[:])
+
+	// Field (4) 'LogsBloom'
+	hh.PutBytes(e.LogsBloom[:])
+
+	// Field (5) 'Random'
+	hh.PutBytes(e.Random[:])
+
+	// Field (6) 'BlockNumber'
+	hh.PutUint64(e.BlockNumber)
+
+	// Field (7) 'GasLimit'
+	hh.PutUint64(e.GasLimit)
+
+	// Field (8) 'GasUsed'
+	hh.PutUint64(e.GasUsed)
+
+	// Field (9) 'Timestamp'
+	hh.PutUint64(e.Timestamp)
+
+	// Field (10) 'ExtraData'
+	{
+		elemIndx := hh.Index()
+		byteLen := uint64(len(e.ExtraData))
+		if byteLen > maxExtraDataBytes {
+			err = ssz.ErrIncorrectListSize
+			return
+		}
+		hh.PutBytes(e.ExtraData)
+		hh.MerkleizeWithMixin(elemIndx, byteLen, (maxExtraDataBytes+31)/32)
+	}
+
+	// Field (11) 'BaseFeePerGas'
+	hh.PutBytes(e.BaseFeePerGas[:])
+
+	// Field (12) 'BlockHash'
+	hh.PutBytes(e.BlockHash[:])
+
+	// Field (13) 'Transactions'
+	{
+		txs := transactions{Transactions: make([][]byte, len(e.Transactions))}
+		for i, tx := range e.Transactions {
+			txs.Transactions[i] = tx
+		}
+		root, err := txs.HashTreeRoot()
+		if err != nil {
+			return err
+		}
+		hh.PutBytes(root[:])
+	}
+
+	hh.Merkleize(indx)
+	return
+}
+
+// MarshalSSZ ssz marshals the ExecutionPayloadV1 object, like the ExecutionPayloadREST it converts to
+func (e *ExecutionPayloadV1) MarshalSSZ() ([]byte, error) {
+	p, err := ELPayloadToRESTPayload(e)
+	if err != nil {
+		return nil, err
+	}
+	return p.MarshalSSZ()
+}
+
+// UnmarshalSSZ ssz unmarshals the ExecutionPayloadV1 object
+func (e *ExecutionPayloadV1) UnmarshalSSZ(buf []byte) error {
+	p := new(ExecutionPayloadREST)
+	if err := p.UnmarshalSSZ(buf); err != nil {
+		return err
+	}
+	el, err := RESTPayloadToELPayload(p)
+	if err != nil {
+		return err
+	}
+	*e = *el
+	return nil
+}
+
+// SizeSSZ returns the ssz encoded size in bytes for the ExecutionPayloadV1 object
+func (e *ExecutionPayloadV1) SizeSSZ() (size int) {
+	size = 508 + len(e.ExtraData)
+	for _, tx := range e.Transactions {
+		size += 4 + len(tx)
+	}
+	return
+}
+
+// HashTreeRoot ssz hashes the ExecutionPayloadV1 object
+func (e *ExecutionPayloadV1) HashTreeRoot() ([32]byte, error) {
+	p, err := ELPayloadToRESTPayload(e)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return p.HashTreeRoot()
+}
+
+// MarshalSSZ ssz marshals the SignedValidatorRegistration object
+func (s *SignedValidatorRegistration) MarshalSSZ() ([]byte, error) {
+	return ssz.MarshalSSZ(s)
+}
+
+// MarshalSSZTo ssz marshals the SignedValidatorRegistration object to a target array
+func (s *SignedValidatorRegistration) MarshalSSZTo(buf []byte) (dst []byte, err error) {
+	dst = buf
+
+	// Field (0) 'Message'
+	if s.Message == nil {
+		s.Message = new(RegisterValidatorRequestMessage)
+	}
+	if dst, err = s.Message.MarshalSSZTo(dst); err != nil {
+		return
+	}
+
+	// Field (1) 'Signature'
+	dst = append(dst, s.Signature[:]...)
+
+	return
+}
+
+// UnmarshalSSZ ssz unmarshals the SignedValidatorRegistration object
+func (s *SignedValidatorRegistration) UnmarshalSSZ(buf []byte) error {
+	var err error
+	size := uint64(len(buf))
+	if size != 180 {
+		return ssz.ErrSize
+	}
+
+	// Field (0) 'Message'
+	if s.Message == nil {
+		s.Message = new(RegisterValidatorRequestMessage)
+	}
+	if err = s.Message.UnmarshalSSZ(buf[0:84]); err != nil {
+		return err
+	}
+
+	// Field (1) 'Signature'
+	copy(s.Signature[:], buf[84:180])
+
+	return err
+}
+
+// SizeSSZ returns the ssz encoded size in bytes for the SignedValidatorRegistration object
+func (s *SignedValidatorRegistration) SizeSSZ() (size int) {
+	size = 180
+	return
+}
+
+// HashTreeRoot ssz hashes the SignedValidatorRegistration object
+func (s *SignedValidatorRegistration) HashTreeRoot() ([32]byte, error) {
+	return ssz.HashWithDefaultHasher(s)
+}
+
+// HashTreeRootWith ssz hashes the SignedValidatorRegistration object with a hasher
+func (s *SignedValidatorRegistration) HashTreeRootWith(hh *ssz.Hasher) (err error) {
+	indx := hh.Index()
+
+	// Field (0) 'Message'
+	if s.Message == nil {
+		s.Message = new(RegisterValidatorRequestMessage)
+	}
+	if err = s.Message.HashTreeRootWith(hh); err != nil {
+		return
+	}
+
+	// Field (1) 'Signature'
+	hh.PutBytes(s.Signature[:])
+
+	hh.Merkleize(indx)
+	return
+}
+
+// MarshalRegistrationsSSZ ssz marshals the registrations of a registerValidator request, a list of fixed size
+// registrations.
+func MarshalRegistrationsSSZ(registrations []SignedValidatorRegistration) ([]byte, error) {
+	if len(registrations) > maxValidatorRegistrations {
+		return nil, ssz.ErrListTooBig
+	}
+	dst := make([]byte, 0, 180*len(registrations))
+	for i := range registrations {
+		var err error
+		if dst, err = registrations[i].MarshalSSZTo(dst); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// UnmarshalRegistrationsSSZ ssz unmarshals the registrations of a registerValidator request.
+func UnmarshalRegistrationsSSZ(buf []byte) ([]SignedValidatorRegistration, error) {
+	num, err := ssz.DivideInt2(len(buf), 180, maxValidatorRegistrations)
+	if err != nil {
+		return nil, err
+	}
+	registrations := make([]SignedValidatorRegistration, num)
+	for i := range registrations {
+		if err := registrations[i].UnmarshalSSZ(buf[i*180 : (i+1)*180]); err != nil {
+			return nil, err
+		}
+	}
+	return registrations, nil
+}
+
+// MarshalSSZ ssz marshals the SignedBlindedBeaconBlock object
+func (s *SignedBlindedBeaconBlock) MarshalSSZ() ([]byte, error) {
+	return ssz.MarshalSSZ(s)
+}
+
+// MarshalSSZTo ssz marshals the SignedBlindedBeaconBlock object to a target array
+func (s *SignedBlindedBeaconBlock) MarshalSSZTo(buf []byte) (dst []byte, err error) {
+	dst = buf
+	offset := int(100)
+
+	// Offset (0) 'Message'
+	dst = ssz.WriteOffset(dst, offset)
+	if s.Message == nil {
+		s.Message = new(BlindedBeaconBlock)
+	}
+
+	// Field (1) 'Signature'
+	dst = append(dst, s.Signature[:]...)
+
+	// Field (0) 'Message'
+	if dst, err = s.Message.MarshalSSZTo(dst); err != nil {
+		return
+	}
+
+	return
+}
+
+// UnmarshalSSZ ssz unmarshals the SignedBlindedBeaconBlock object
+func (s *SignedBlindedBeaconBlock) UnmarshalSSZ(buf []byte) error {
+	var err error
+	size := uint64(len(buf))
+	if size < 100 {
+		return ssz.ErrSize
+	}
+
+	tail := buf
+	var o0 uint64
+
+	// Offset (0) 'Message'
+	if o0 = ssz.ReadOffset(buf[0:4]); o0 > size {
+		return ssz.ErrOffset
+	}
+
+	if o0 < 100 {
+		return ssz.ErrInvalidVariableOffset
+	}
+
+	// Field (1) 'Signature'
+	copy(s.Signature[:], buf[4:100])
+
+	// Field (0) 'Message'
+	{
+		buf = tail[o0:]
+		if s.Message == nil {
+			s.Message = new(BlindedBeaconBlock)
+		}
+		if err = s.Message.UnmarshalSSZ(buf); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// SizeSSZ returns the ssz encoded size in bytes for the SignedBlindedBeaconBlock object
+func (s *SignedBlindedBeaconBlock) SizeSSZ() (size int) {
+	size = 100
+
+	// Field (0) 'Message'
+	if s.Message == nil {
+		s.Message = new(BlindedBeaconBlock)
+	}
+	size += s.Message.SizeSSZ()
+
+	return
+}
+
+// HashTreeRoot ssz hashes the SignedBlindedBeaconBlock object
+func (s *SignedBlindedBeaconBlock) HashTreeRoot() ([32]byte, error) {
+	return ssz.HashWithDefaultHasher(s)
+}
+
+// HashTreeRootWith ssz hashes the SignedBlindedBeaconBlock object with a hasher
+func (s *SignedBlindedBeaconBlock) HashTreeRootWith(hh *ssz.Hasher) (err error) {
+	indx := hh.Index()
+
+	// Field (0) 'Message'
+	if err = s.Message.HashTreeRootWith(hh); err != nil {
+		return
+	}
+
+	// Field (1) 'Signature'
+	hh.PutBytes(s.Signature[:])
+
+	hh.Merkleize(indx)
+	return
+}
@@ -300,3 +300,84 @@ func TestMerkelizePayload(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "87b57a69321ec21e8a83a39f2f0f885a3be9bbddb80794b3b2700c3cf8230aa1", common.Bytes2Hex(root[:]))
 }
+
+func TestExecutionPayloadSSZ(t *testing.T) {
+	payload := &ExecutionPayloadV1{
+		ParentHash:    common.Hash{0x01},
+		FeeRecipient:  common.Address{0x02},
+		StateRoot:     common.Hash{0x09},
+		ReceiptsRoot:  common.Hash{0x0a},
+		LogsBloom:     types.Bloom{0x0b},
+		Random:        common.Hash{0x0c},
+		Number:        5001,
+		GasLimit:      5002,
+		GasUsed:       5003,
+		Timestamp:     5004,
+		ExtraData:     []byte{0x0d, 0x0e},
+		BaseFeePerGas: big.NewInt(1234567),
+		BlockHash:     common.Hash{0xa1},
+		Transactions:  [][]byte{{0x01}, {0x02, 0x03}},
+	}
+	b, err := payload.MarshalSSZ()
+	require.NoError(t, err)
+	require.Len(t, b, payload.SizeSSZ())
+	require.Equal(t, uint32(508), uint32(b[436])|uint32(b[437])<<8, "extra data follows the fixed part")
+
+	payload2 := new(ExecutionPayloadV1)
+	require.NoError(t, payload2.UnmarshalSSZ(b))
+	require.Equal(t, payload, payload2)
+
+	// the payload has the root of its header
+	header, err := PayloadToPayloadHeader(payload)
+	require.NoError(t, err)
+	headerRoot, err := header.HashTreeRoot()
+	require.NoError(t, err)
+	root, err := payload.HashTreeRoot()
+	require.NoError(t, err)
+	require.Equal(t, headerRoot, root)
+	require.Equal(t, payload.BaseFeePerGas, (*U256Str)(&header.BaseFeePerGas).ToBig())
+
+	require.Error(t, payload2.UnmarshalSSZ(b[:507]))
+	payload.ExtraData = make([]byte, 33)
+	_, err = payload.MarshalSSZ()
+	require.Error(t, err)
+}
+
+func TestBuilderMessagesSSZ(t *testing.T) {
+	registrations := []SignedValidatorRegistration{
+		{Message: &RegisterValidatorRequestMessage{FeeRecipient: Address{0x01}, GasLimit: 30000000, Timestamp: 1652735778, Pubkey: PublicKey{0x02}}, Signature: Signature{0x03}},
+		{Message: &RegisterValidatorRequestMessage{FeeRecipient: Address{0x04}, GasLimit: 30000000, Timestamp: 1652735779, Pubkey: PublicKey{0x05}}, Signature: Signature{0x06}},
+	}
+	b, err := MarshalRegistrationsSSZ(registrations)
+	require.NoError(t, err)
+	require.Len(t, b, 360)
+	registrations2, err := UnmarshalRegistrationsSSZ(b)
+	require.NoError(t, err)
+	require.Equal(t, registrations, registrations2)
+	_, err = UnmarshalRegistrationsSSZ(b[:359])
+	require.Error(t, err)
+
+	block := &SignedBlindedBeaconBlock{
+		Message: &BlindedBeaconBlock{
+			Slot:          1,
+			ProposerIndex: 7,
+			Body: &BlindedBeaconBlockBody{
+				Eth1Data:               &Eth1Data{},
+				SyncAggregate:          &SyncAggregate{},
+				ExecutionPayloadHeader: &ExecutionPayloadHeader{BlockNumber: 1},
+			},
+		},
+		Signature: Signature{0x07},
+	}
+	b, err = block.MarshalSSZ()
+	require.NoError(t, err)
+	require.Len(t, b, block.SizeSSZ())
+	block2 := new(SignedBlindedBeaconBlock)
+	require.NoError(t, block2.UnmarshalSSZ(b))
+	require.Equal(t, block.Signature, block2.Signature)
+	root, err := block.Message.HashTreeRoot()
+	require.NoError(t, err)
+	root2, err := block2.Message.HashTreeRoot()
+	require.NoError(t, err)
+	require.Equal(t, root, root2)
+}
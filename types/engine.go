@@ -1,6 +1,7 @@
 package types
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 
@@ -8,11 +9,15 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/beacon"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/crypto/sha3"
 )
 
 type PayloadID = beacon.PayloadID
 
+type TransitionConfigurationV1 = beacon.TransitionConfigurationV1
+
 //go:generate go run github.com/fjl/gencodec -type PayloadAttributesV1 -field-override payloadAttributesMarshalling -out gen_blockparams.go
 type PayloadAttributesV1 struct {
 	Timestamp             uint64         `json:"timestamp"`
@@ -52,12 +57,31 @@ type executionPayloadMarshalling struct {
 	Transactions  []hexutil.Bytes
 }
 
-func (params *ExecutionPayloadV1) ValidateHash() bool {
+// ComputeHash returns the hash of the execution block header described by the payload.
+func (params *ExecutionPayloadV1) ComputeHash() (common.Hash, error) {
+	header, err := params.header()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// ComputeHashWithForkFields returns the hash of the execution block header described by the payload, with
+// the given header fields added after London, for payloads whose fork fields don't all come with the payload.
+func (params *ExecutionPayloadV1) ComputeHashWithForkFields(fork *ForkFields) (common.Hash, error) {
+	header, err := params.header()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return HeaderHash(header, fork), nil
+}
+
+func (params *ExecutionPayloadV1) header() (*types.Header, error) {
 	txs, err := decodeTransactions(params.Transactions)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	header := &types.Header{
+	return &types.Header{
 		ParentHash:  params.ParentHash,
 		UncleHash:   types.EmptyUncleHash,
 		Coinbase:    params.FeeRecipient,
@@ -73,8 +97,439 @@ func (params *ExecutionPayloadV1) ValidateHash() bool {
 		BaseFee:     params.BaseFeePerGas,
 		Extra:       params.ExtraData,
 		MixDigest:   params.Random,
+	}, nil
+}
+
+// PayloadV2 returns the payload with the withdrawals, nil for a payload from before Shanghai.
+func (params *ExecutionPayloadV1) PayloadV2(withdrawals []*Withdrawal) *ExecutionPayloadV2 {
+	return &ExecutionPayloadV2{
+		ParentHash:    params.ParentHash,
+		FeeRecipient:  params.FeeRecipient,
+		StateRoot:     params.StateRoot,
+		ReceiptsRoot:  params.ReceiptsRoot,
+		LogsBloom:     params.LogsBloom,
+		Random:        params.Random,
+		Number:        params.Number,
+		GasLimit:      params.GasLimit,
+		GasUsed:       params.GasUsed,
+		Timestamp:     params.Timestamp,
+		ExtraData:     params.ExtraData,
+		BaseFeePerGas: params.BaseFeePerGas,
+		BlockHash:     params.BlockHash,
+		Transactions:  params.Transactions,
+		Withdrawals:   withdrawals,
+	}
+}
+
+func (params *ExecutionPayloadV1) ValidateHash() bool {
+	hash, err := params.ComputeHash()
+	if err != nil {
+		return false
+	}
+	return hash == params.BlockHash
+}
+
+//go:generate go run github.com/fjl/gencodec -type Withdrawal -field-override withdrawalMarshalling -out gen_withdrawal.go
+type Withdrawal struct {
+	Index     uint64         `json:"index"`
+	Validator uint64         `json:"validatorIndex"`
+	Address   common.Address `json:"address"`
+	Amount    uint64         `json:"amount"` // in Gwei
+}
+
+type withdrawalMarshalling struct {
+	Index     hexutil.Uint64
+	Validator hexutil.Uint64
+	Amount    hexutil.Uint64
+}
+
+// Withdrawals implements types.DerivableList, to compute the withdrawals root of a block.
+type Withdrawals []*Withdrawal
+
+func (ws Withdrawals) Len() int { return len(ws) }
+
+func (ws Withdrawals) EncodeIndex(i int, w *bytes.Buffer) {
+	rlp.Encode(w, ws[i])
+}
+
+//go:generate go run github.com/fjl/gencodec -type PayloadAttributesV2 -field-override payloadAttributesV2Marshalling -out gen_blockparams_v2.go
+type PayloadAttributesV2 struct {
+	Timestamp             uint64         `json:"timestamp"`
+	PrevRandao            common.Hash    `json:"prevRandao"`
+	SuggestedFeeRecipient common.Address `json:"suggestedFeeRecipient"`
+	Withdrawals           []*Withdrawal  `json:"withdrawals"`
+}
+
+type payloadAttributesV2Marshalling struct {
+	Timestamp hexutil.Uint64
+}
+
+// ExecutionPayloadV2 is the Shanghai payload. Before Shanghai the withdrawals are nil,
+// and the payload is interpreted like an ExecutionPayloadV1.
+//
+//go:generate go run github.com/fjl/gencodec -type ExecutionPayloadV2 -field-override executionPayloadV2Marshalling -out gen_ep_v2.go
+type ExecutionPayloadV2 struct {
+	ParentHash    common.Hash    `json:"parentHash"    gencodec:"required"`
+	FeeRecipient  common.Address `json:"feeRecipient"  gencodec:"required"`
+	StateRoot     common.Hash    `json:"stateRoot"     gencodec:"required"`
+	ReceiptsRoot  common.Hash    `json:"receiptsRoot"  gencodec:"required"`
+	LogsBloom     types.Bloom    `json:"logsBloom"     gencodec:"required"`
+	Random        common.Hash    `json:"prevRandao"    gencodec:"required"`
+	Number        uint64         `json:"blockNumber"   gencodec:"required"`
+	GasLimit      uint64         `json:"gasLimit"      gencodec:"required"`
+	GasUsed       uint64         `json:"gasUsed"       gencodec:"required"`
+	Timestamp     uint64         `json:"timestamp"     gencodec:"required"`
+	ExtraData     []byte         `json:"extraData"     gencodec:"required"`
+	BaseFeePerGas *big.Int       `json:"baseFeePerGas" gencodec:"required"`
+	BlockHash     common.Hash    `json:"blockHash"     gencodec:"required"`
+	Transactions  [][]byte       `json:"transactions"  gencodec:"required"`
+	Withdrawals   []*Withdrawal  `json:"withdrawals"`
+}
+
+type executionPayloadV2Marshalling struct {
+	Number        hexutil.Uint64
+	GasLimit      hexutil.Uint64
+	GasUsed       hexutil.Uint64
+	Timestamp     hexutil.Uint64
+	BaseFeePerGas *hexutil.Big
+	ExtraData     hexutil.Bytes
+	Transactions  []hexutil.Bytes
+}
+
+// PayloadV1 returns the payload without its withdrawals.
+func (params *ExecutionPayloadV2) PayloadV1() *ExecutionPayloadV1 {
+	return &ExecutionPayloadV1{
+		ParentHash:    params.ParentHash,
+		FeeRecipient:  params.FeeRecipient,
+		StateRoot:     params.StateRoot,
+		ReceiptsRoot:  params.ReceiptsRoot,
+		LogsBloom:     params.LogsBloom,
+		Random:        params.Random,
+		Number:        params.Number,
+		GasLimit:      params.GasLimit,
+		GasUsed:       params.GasUsed,
+		Timestamp:     params.Timestamp,
+		ExtraData:     params.ExtraData,
+		BaseFeePerGas: params.BaseFeePerGas,
+		BlockHash:     params.BlockHash,
+		Transactions:  params.Transactions,
+	}
+}
+
+// ComputeHash returns the hash of the execution block header described by the payload,
+// including the withdrawals root if the payload has withdrawals.
+func (params *ExecutionPayloadV2) ComputeHash() (common.Hash, error) {
+	header, err := params.PayloadV1().header()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return HeaderHash(header, params.ForkFields()), nil
+}
+
+func (params *ExecutionPayloadV2) ValidateHash() bool {
+	hash, err := params.ComputeHash()
+	if err != nil {
+		return false
+	}
+	return hash == params.BlockHash
+}
+
+// ForkFields returns the header fields of the payload added after London, nil before Shanghai.
+func (params *ExecutionPayloadV2) ForkFields() *ForkFields {
+	if params.Withdrawals == nil {
+		return nil
+	}
+	return &ForkFields{Withdrawals: params.Withdrawals}
+}
+
+// ExecutionPayloadBodyV1 is the body of a canonical block, served to consensus clients backfilling the payloads of
+// the blocks they only have the headers of. The withdrawals are nil before Shanghai.
+type ExecutionPayloadBodyV1 struct {
+	Transactions []hexutil.Bytes `json:"transactions"`
+	Withdrawals  []*Withdrawal   `json:"withdrawals"`
+}
+
+type ExecutionPayloadEnvelopeV2 struct {
+	ExecutionPayload *ExecutionPayloadV2 `json:"executionPayload"`
+	BlockValue       *hexutil.Big        `json:"blockValue"`
+}
+
+//go:generate go run github.com/fjl/gencodec -type PayloadAttributesV3 -field-override payloadAttributesV3Marshalling -out gen_blockparams_v3.go
+type PayloadAttributesV3 struct {
+	Timestamp             uint64         `json:"timestamp"`
+	PrevRandao            common.Hash    `json:"prevRandao"`
+	SuggestedFeeRecipient common.Address `json:"suggestedFeeRecipient"`
+	Withdrawals           []*Withdrawal  `json:"withdrawals"`
+	ParentBeaconBlockRoot common.Hash    `json:"parentBeaconBlockRoot" gencodec:"required"`
+}
+
+type payloadAttributesV3Marshalling struct {
+	Timestamp hexutil.Uint64
+}
+
+// ExecutionPayloadV3 is the Cancun payload. The parent beacon block root is part of the block
+// header, but not of the payload: it's passed to engine_newPayloadV3 next to it.
+//
+//go:generate go run github.com/fjl/gencodec -type ExecutionPayloadV3 -field-override executionPayloadV3Marshalling -out gen_ep_v3.go
+type ExecutionPayloadV3 struct {
+	ParentHash    common.Hash    `json:"parentHash"    gencodec:"required"`
+	FeeRecipient  common.Address `json:"feeRecipient"  gencodec:"required"`
+	StateRoot     common.Hash    `json:"stateRoot"     gencodec:"required"`
+	ReceiptsRoot  common.Hash    `json:"receiptsRoot"  gencodec:"required"`
+	LogsBloom     types.Bloom    `json:"logsBloom"     gencodec:"required"`
+	Random        common.Hash    `json:"prevRandao"    gencodec:"required"`
+	Number        uint64         `json:"blockNumber"   gencodec:"required"`
+	GasLimit      uint64         `json:"gasLimit"      gencodec:"required"`
+	GasUsed       uint64         `json:"gasUsed"       gencodec:"required"`
+	Timestamp     uint64         `json:"timestamp"     gencodec:"required"`
+	ExtraData     []byte         `json:"extraData"     gencodec:"required"`
+	BaseFeePerGas *big.Int       `json:"baseFeePerGas" gencodec:"required"`
+	BlockHash     common.Hash    `json:"blockHash"     gencodec:"required"`
+	Transactions  [][]byte       `json:"transactions"  gencodec:"required"`
+	Withdrawals   []*Withdrawal  `json:"withdrawals"   gencodec:"required"`
+	BlobGasUsed   uint64         `json:"blobGasUsed"   gencodec:"required"`
+	ExcessBlobGas uint64         `json:"excessBlobGas" gencodec:"required"`
+}
+
+type executionPayloadV3Marshalling struct {
+	Number        hexutil.Uint64
+	GasLimit      hexutil.Uint64
+	GasUsed       hexutil.Uint64
+	Timestamp     hexutil.Uint64
+	BaseFeePerGas *hexutil.Big
+	ExtraData     hexutil.Bytes
+	Transactions  []hexutil.Bytes
+	BlobGasUsed   hexutil.Uint64
+	ExcessBlobGas hexutil.Uint64
+}
+
+// PayloadV2 returns the payload without its blob gas fields.
+func (params *ExecutionPayloadV3) PayloadV2() *ExecutionPayloadV2 {
+	return &ExecutionPayloadV2{
+		ParentHash:    params.ParentHash,
+		FeeRecipient:  params.FeeRecipient,
+		StateRoot:     params.StateRoot,
+		ReceiptsRoot:  params.ReceiptsRoot,
+		LogsBloom:     params.LogsBloom,
+		Random:        params.Random,
+		Number:        params.Number,
+		GasLimit:      params.GasLimit,
+		GasUsed:       params.GasUsed,
+		Timestamp:     params.Timestamp,
+		ExtraData:     params.ExtraData,
+		BaseFeePerGas: params.BaseFeePerGas,
+		BlockHash:     params.BlockHash,
+		Transactions:  params.Transactions,
+		Withdrawals:   params.Withdrawals,
+	}
+}
+
+// ComputeHash returns the hash of the execution block header described by the payload,
+// with the given parent beacon block root.
+func (params *ExecutionPayloadV3) ComputeHash(parentBeaconRoot common.Hash) (common.Hash, error) {
+	header, err := params.PayloadV2().PayloadV1().header()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return HeaderHash(header, params.ForkFields(parentBeaconRoot)), nil
+}
+
+func (params *ExecutionPayloadV3) ValidateHash(parentBeaconRoot common.Hash) bool {
+	hash, err := params.ComputeHash(parentBeaconRoot)
+	if err != nil {
+		return false
+	}
+	return hash == params.BlockHash
+}
+
+// ComputeHashV4 returns the hash of the execution block header described by the Prague payload,
+// with the given parent beacon block root and the requests hash of the execution requests.
+func (params *ExecutionPayloadV3) ComputeHashV4(parentBeaconRoot common.Hash, requests ExecutionRequests) (common.Hash, error) {
+	header, err := params.PayloadV2().PayloadV1().header()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return HeaderHash(header, params.ForkFieldsV4(parentBeaconRoot, requests)), nil
+}
+
+func (params *ExecutionPayloadV3) ValidateHashV4(parentBeaconRoot common.Hash, requests ExecutionRequests) bool {
+	hash, err := params.ComputeHashV4(parentBeaconRoot, requests)
+	if err != nil {
+		return false
+	}
+	return hash == params.BlockHash
+}
+
+// ForkFieldsV4 returns the header fields of the Prague payload added after London.
+func (params *ExecutionPayloadV3) ForkFieldsV4(parentBeaconRoot common.Hash, requests ExecutionRequests) *ForkFields {
+	fork := params.ForkFields(parentBeaconRoot)
+	requestsHash := requests.Hash()
+	fork.RequestsHash = &requestsHash
+	return fork
+}
+
+// ForkFields returns the header fields of the payload added after London.
+func (params *ExecutionPayloadV3) ForkFields(parentBeaconRoot common.Hash) *ForkFields {
+	withdrawals := params.Withdrawals
+	if withdrawals == nil {
+		withdrawals = []*Withdrawal{}
+	}
+	blobGasUsed, excessBlobGas := params.BlobGasUsed, params.ExcessBlobGas
+	return &ForkFields{
+		Withdrawals:      withdrawals,
+		BlobGasUsed:      &blobGasUsed,
+		ExcessBlobGas:    &excessBlobGas,
+		ParentBeaconRoot: &parentBeaconRoot,
+	}
+}
+
+// BlobsBundleV1 holds the blobs of the transactions in a payload, with their KZG commitments and proofs.
+type BlobsBundleV1 struct {
+	Commitments []hexutil.Bytes `json:"commitments"`
+	Proofs      []hexutil.Bytes `json:"proofs"`
+	Blobs       []hexutil.Bytes `json:"blobs"`
+}
+
+type ExecutionPayloadEnvelopeV3 struct {
+	ExecutionPayload      *ExecutionPayloadV3 `json:"executionPayload"`
+	BlockValue            *hexutil.Big        `json:"blockValue"`
+	BlobsBundle           *BlobsBundleV1      `json:"blobsBundle"`
+	ShouldOverrideBuilder bool                `json:"shouldOverrideBuilder"`
+}
+
+// ExecutionPayloadEnvelopeV4 is the Prague payload envelope, the Cancun one with the execution requests
+// of the payload, which are part of its block hash through the requests hash.
+type ExecutionPayloadEnvelopeV4 struct {
+	ExecutionPayload      *ExecutionPayloadV3 `json:"executionPayload"`
+	BlockValue            *hexutil.Big        `json:"blockValue"`
+	BlobsBundle           *BlobsBundleV1      `json:"blobsBundle"`
+	ExecutionRequests     ExecutionRequests   `json:"executionRequests"`
+	ShouldOverrideBuilder bool                `json:"shouldOverrideBuilder"`
+}
+
+// ForkFields are the block header fields added after London, nil where their fork isn't active.
+// Withdrawals are set from Shanghai on, the requests hash from Prague on, the others from Cancun on.
+type ForkFields struct {
+	Withdrawals      []*Withdrawal
+	BlobGasUsed      *uint64      `rlp:"optional"`
+	ExcessBlobGas    *uint64      `rlp:"optional"`
+	ParentBeaconRoot *common.Hash `rlp:"optional"`
+	RequestsHash     *common.Hash `rlp:"optional"`
+}
+
+// extendedHeader is the block header with the fields of Shanghai, Cancun and Prague appended.
+// The geth version in use predates them, so the header is hashed here.
+type extendedHeader struct {
+	ParentHash       common.Hash
+	UncleHash        common.Hash
+	Coinbase         common.Address
+	Root             common.Hash
+	TxHash           common.Hash
+	ReceiptHash      common.Hash
+	Bloom            types.Bloom
+	Difficulty       *big.Int
+	Number           *big.Int
+	GasLimit         uint64
+	GasUsed          uint64
+	Time             uint64
+	Extra            []byte
+	MixDigest        common.Hash
+	Nonce            types.BlockNonce
+	BaseFee          *big.Int     `rlp:"optional"`
+	WithdrawalsHash  *common.Hash `rlp:"optional"`
+	BlobGasUsed      *uint64      `rlp:"optional"`
+	ExcessBlobGas    *uint64      `rlp:"optional"`
+	ParentBeaconRoot *common.Hash `rlp:"optional"`
+	RequestsHash     *common.Hash `rlp:"optional"`
+}
+
+// WithdrawalsRoot returns the root of the trie of the given withdrawals.
+func WithdrawalsRoot(withdrawals []*Withdrawal) common.Hash {
+	return types.DeriveSha(Withdrawals(withdrawals), trie.NewStackTrie(nil))
+}
+
+// HeaderHash returns the hash of the header, which commits to the fork fields if they are not nil.
+func HeaderHash(h *types.Header, fork *ForkFields) common.Hash {
+	if fork == nil {
+		return h.Hash()
+	}
+	hasher := sha3.NewLegacyKeccak256()
+	rlp.Encode(hasher, newExtendedHeader(h, fork))
+	var hash common.Hash
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// EncodeHeader returns the RLP encoding of the header with the fork fields, as defined by the spec. It's the
+// encoding of geth if the fork fields are nil.
+func EncodeHeader(h *types.Header, fork *ForkFields) ([]byte, error) {
+	if fork == nil {
+		return rlp.EncodeToBytes(h)
+	}
+	return rlp.EncodeToBytes(newExtendedHeader(h, fork))
+}
+
+// DecodeHeader decodes a header encoded as defined by the spec, into the geth header and the fork fields, which
+// are nil before Shanghai. The withdrawals of the fork fields are left out, they are part of the block body.
+func DecodeHeader(enc []byte) (*types.Header, *ForkFields, error) {
+	var dec extendedHeader
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		return nil, nil, err
+	}
+	h := &types.Header{
+		ParentHash:  dec.ParentHash,
+		UncleHash:   dec.UncleHash,
+		Coinbase:    dec.Coinbase,
+		Root:        dec.Root,
+		TxHash:      dec.TxHash,
+		ReceiptHash: dec.ReceiptHash,
+		Bloom:       dec.Bloom,
+		Difficulty:  dec.Difficulty,
+		Number:      dec.Number,
+		GasLimit:    dec.GasLimit,
+		GasUsed:     dec.GasUsed,
+		Time:        dec.Time,
+		Extra:       dec.Extra,
+		MixDigest:   dec.MixDigest,
+		Nonce:       dec.Nonce,
+		BaseFee:     dec.BaseFee,
+	}
+	if dec.WithdrawalsHash == nil {
+		return h, nil, nil
+	}
+	return h, &ForkFields{
+		BlobGasUsed:      dec.BlobGasUsed,
+		ExcessBlobGas:    dec.ExcessBlobGas,
+		ParentBeaconRoot: dec.ParentBeaconRoot,
+		RequestsHash:     dec.RequestsHash,
+	}, nil
+}
+
+func newExtendedHeader(h *types.Header, fork *ForkFields) *extendedHeader {
+	withdrawalsRoot := WithdrawalsRoot(fork.Withdrawals)
+	return &extendedHeader{
+		ParentHash:       h.ParentHash,
+		UncleHash:        h.UncleHash,
+		Coinbase:         h.Coinbase,
+		Root:             h.Root,
+		TxHash:           h.TxHash,
+		ReceiptHash:      h.ReceiptHash,
+		Bloom:            h.Bloom,
+		Difficulty:       h.Difficulty,
+		Number:           h.Number,
+		GasLimit:         h.GasLimit,
+		GasUsed:          h.GasUsed,
+		Time:             h.Time,
+		Extra:            h.Extra,
+		MixDigest:        h.MixDigest,
+		Nonce:            h.Nonce,
+		BaseFee:          h.BaseFee,
+		WithdrawalsHash:  &withdrawalsRoot,
+		BlobGasUsed:      fork.BlobGasUsed,
+		ExcessBlobGas:    fork.ExcessBlobGas,
+		ParentBeaconRoot: fork.ParentBeaconRoot,
+		RequestsHash:     fork.RequestsHash,
 	}
-	return header.Hash() == params.BlockHash
 }
 
 type ExecutePayloadStatus string
@@ -111,6 +566,15 @@ type ForkchoiceUpdatedResult struct {
 	PayloadID     *PayloadID      `json:"payloadId"`
 }
 
+// ClientVersionV1 identifies the client of either side of engine_getClientVersionV1: the two-letter client code, e.g.
+// GE for geth, the client name and version, and the first 4 bytes of the commit it was built from, as hex.
+type ClientVersionV1 struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
 func decodeTransactions(enc [][]byte) ([]*types.Transaction, error) {
 	var txs = make([]*types.Transaction, len(enc))
 	for i, encTx := range enc {
@@ -0,0 +1,135 @@
+package types
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden JSON fixtures in testdata/golden")
+
+// goldenTypes lists every type sent over the engine and builder APIs. New types and versions get an
+// entry here, and their fixture is created with `go test ./types -run TestGoldenJSON -update`.
+var goldenTypes = map[string]interface{}{
+	"engine_payload_attributes_v1":         new(PayloadAttributesV1),
+	"engine_execution_payload_v1":          new(ExecutionPayloadV1),
+	"engine_payload_status_v1":             new(PayloadStatusV1),
+	"engine_forkchoice_state_v1":           new(ForkchoiceStateV1),
+	"engine_forkchoice_updated_result":     new(ForkchoiceUpdatedResult),
+	"engine_withdrawal":                    new(Withdrawal),
+	"engine_payload_attributes_v2":         new(PayloadAttributesV2),
+	"engine_execution_payload_v2":          new(ExecutionPayloadV2),
+	"engine_execution_payload_envelope_v2": new(ExecutionPayloadEnvelopeV2),
+	"engine_payload_attributes_v3":         new(PayloadAttributesV3),
+	"engine_execution_payload_v3":          new(ExecutionPayloadV3),
+	"engine_blobs_bundle_v1":               new(BlobsBundleV1),
+	"engine_execution_payload_envelope_v3": new(ExecutionPayloadEnvelopeV3),
+	"engine_execution_requests":            new(ExecutionRequests),
+	"engine_execution_payload_envelope_v4": new(ExecutionPayloadEnvelopeV4),
+	"engine_execution_payload_body_v1":     new(ExecutionPayloadBodyV1),
+	"engine_client_version_v1":             new(ClientVersionV1),
+	"engine_transition_configuration_v1":   new(TransitionConfigurationV1),
+
+	"builder_register_validator_request":    new(RegisterValidatorRequestMessage),
+	"builder_signed_validator_registration": new(SignedValidatorRegistration),
+	"builder_get_header_response":           new(GetHeaderResponse),
+	"builder_signed_blinded_beacon_block":   new(SignedBlindedBeaconBlock),
+	"builder_get_payload_response":          new(GetPayloadResponse),
+}
+
+// goldenVariants are fixtures of types of goldenTypes with fields changed after filling, for the encodings of
+// fields that are null in some forks.
+var goldenVariants = map[string]struct {
+	typ    interface{}
+	adjust func(v interface{})
+}{
+	"engine_execution_payload_body_v1_pre_shanghai": {new(ExecutionPayloadBodyV1), func(v interface{}) {
+		v.(*ExecutionPayloadBodyV1).Withdrawals = nil
+	}},
+}
+
+// fillDeterministic sets every field reachable from v to a distinct, reproducible value,
+// so that a field added to or dropped from an encoding changes the output.
+func fillDeterministic(v reflect.Value, counter *byte) {
+	next := func() byte {
+		*counter++
+		return *counter
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.Type() == reflect.TypeOf(new(big.Int)) {
+			v.Set(reflect.ValueOf(big.NewInt(int64(next()) * 1000)))
+			return
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		fillDeterministic(v.Elem(), counter)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fillDeterministic(v.Field(i), counter)
+			}
+		}
+	case reflect.Array:
+		b := next()
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).SetUint(uint64(b))
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.Index(0).SetUint(uint64(next()))
+		} else {
+			fillDeterministic(v.Index(0), counter)
+		}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(next()))
+	case reflect.String:
+		v.SetString(fmt.Sprintf("value-%d", next()))
+	case reflect.Bool:
+		v.SetBool(true)
+	default:
+		panic(fmt.Sprintf("cannot fill %s", v.Type()))
+	}
+}
+
+func TestGoldenJSON(t *testing.T) {
+	for name, typ := range goldenTypes {
+		t.Run(name, func(t *testing.T) { testGolden(t, name, typ, nil) })
+	}
+	for name, variant := range goldenVariants {
+		t.Run(name, func(t *testing.T) { testGolden(t, name, variant.typ, variant.adjust) })
+	}
+}
+
+func testGolden(t *testing.T, name string, typ interface{}, adjust func(v interface{})) {
+	path := filepath.Join("testdata", "golden", name+".json")
+	var counter byte
+	value := reflect.New(reflect.TypeOf(typ).Elem())
+	fillDeterministic(value.Elem(), &counter)
+	if adjust != nil {
+		adjust(value.Interface())
+	}
+	enc, err := json.MarshalIndent(value.Interface(), "", "  ")
+	require.NoError(t, err)
+
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(path, append(enc, '\n'), 0644))
+	}
+	golden, err := os.ReadFile(path)
+	require.NoError(t, err, "missing fixture, run with -update to create it")
+	require.JSONEq(t, string(golden), string(enc), "encoding changed")
+
+	// Decoding the fixture and encoding it again must be lossless.
+	decoded := reflect.New(reflect.TypeOf(typ).Elem()).Interface()
+	require.NoError(t, json.Unmarshal(golden, decoded))
+	reenc, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	require.JSONEq(t, string(golden), string(reenc), "round trip changed")
+}
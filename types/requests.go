@@ -0,0 +1,36 @@
+package types
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ExecutionRequests are the EIP-7685 requests of a Prague payload, each a request type byte followed by
+// the request data, in ascending order of type and without empty requests.
+type ExecutionRequests []hexutil.Bytes
+
+// Validate checks that the requests are ordered by type, with at most one per type, and none empty.
+func (r ExecutionRequests) Validate() error {
+	for i, req := range r {
+		if len(req) < 2 {
+			return fmt.Errorf("empty execution request %d", i)
+		}
+		if i > 0 && req[0] <= r[i-1][0] {
+			return fmt.Errorf("execution request %d of type %d not in ascending order of type", i, req[0])
+		}
+	}
+	return nil
+}
+
+// Hash returns the requests hash committed to by the block header, as specified in EIP-7685.
+func (r ExecutionRequests) Hash() common.Hash {
+	outer := sha256.New()
+	for _, req := range r {
+		inner := sha256.Sum256(req)
+		outer.Write(inner[:])
+	}
+	return common.BytesToHash(outer.Sum(nil))
+}
@@ -0,0 +1,30 @@
+package types
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionRequestsHash(t *testing.T) {
+	// The requests hash of a block without requests, as given in EIP-7685.
+	empty := common.HexToHash("0xe3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	require.Equal(t, empty, ExecutionRequests{}.Hash())
+	require.Equal(t, empty, ExecutionRequests(nil).Hash())
+
+	deposit, withdrawal := hexutil.Bytes{0x00, 0x01, 0x02}, hexutil.Bytes{0x01, 0x03}
+	inner0, inner1 := sha256.Sum256(deposit), sha256.Sum256(withdrawal)
+	want := sha256.Sum256(append(inner0[:], inner1[:]...))
+	require.Equal(t, common.Hash(want), ExecutionRequests{deposit, withdrawal}.Hash())
+}
+
+func TestExecutionRequestsValidate(t *testing.T) {
+	require.NoError(t, ExecutionRequests{}.Validate())
+	require.NoError(t, ExecutionRequests{{0x00, 0x01}, {0x02, 0x01}}.Validate())
+	require.Error(t, ExecutionRequests{{0x00}}.Validate(), "empty request")
+	require.Error(t, ExecutionRequests{{0x01, 0x01}, {0x00, 0x01}}.Validate(), "descending types")
+	require.Error(t, ExecutionRequests{{0x01, 0x01}, {0x01, 0x02}}.Validate(), "duplicate types")
+}
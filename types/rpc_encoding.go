@@ -3,7 +3,7 @@
 package types
 
 import (
-	"errors"
+	"encoding/json"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -11,10 +11,12 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 )
 
-func RPCMarshalHeader(head *types.Header) map[string]interface{} {
+// RPCMarshalHeader encodes the header with the fork fields, which may be nil before Shanghai. The parent hash of
+// the header has to be the spec hash of the parent, the hash of the header commits to it.
+func RPCMarshalHeader(head *types.Header, fork *ForkFields) map[string]interface{} {
 	result := map[string]interface{}{
 		"number":           (*hexutil.Big)(head.Number),
-		"hash":             head.Hash(),
+		"hash":             HeaderHash(head, fork),
 		"parentHash":       head.ParentHash,
 		"nonce":            head.Nonce,
 		"mixHash":          head.MixDigest,
@@ -35,26 +37,46 @@ func RPCMarshalHeader(head *types.Header) map[string]interface{} {
 	if head.BaseFee != nil {
 		result["baseFeePerGas"] = (*hexutil.Big)(head.BaseFee)
 	}
+	if fork != nil {
+		result["withdrawalsRoot"] = WithdrawalsRoot(fork.Withdrawals)
+		if fork.BlobGasUsed != nil {
+			result["blobGasUsed"] = hexutil.Uint64(*fork.BlobGasUsed)
+			result["excessBlobGas"] = hexutil.Uint64(*fork.ExcessBlobGas)
+		}
+		if fork.ParentBeaconRoot != nil {
+			result["parentBeaconBlockRoot"] = *fork.ParentBeaconRoot
+		}
+		if fork.RequestsHash != nil {
+			result["requestsHash"] = *fork.RequestsHash
+		}
+	}
 
 	return result
 }
 
-func RPCMarshalBlock(block *types.Block, inclTx bool, fullTx bool, config *params.ChainConfig) (map[string]interface{}, error) {
-	fields := RPCMarshalHeader(block.Header())
+// RPCMarshalBlock encodes the block with the spec hash of its parent and its fork fields, like RPCMarshalHeader,
+// and the withdrawals of the fork fields.
+func RPCMarshalBlock(block *types.Block, parentHash common.Hash, fork *ForkFields, inclTx bool, fullTx bool, config *params.ChainConfig) (map[string]interface{}, error) {
+	header := block.Header()
+	header.ParentHash = parentHash
+	fields := RPCMarshalHeader(header, fork)
 	fields["size"] = hexutil.Uint64(block.Size())
+	hash := fields["hash"].(common.Hash)
 
 	if inclTx {
-		formatTx := func(tx *types.Transaction) (interface{}, error) {
+		formatTx := func(tx *types.Transaction, index int) (interface{}, error) {
 			return tx.Hash(), nil
 		}
 		if fullTx {
-			return nil, errors.New("not implemented")
+			formatTx = func(tx *types.Transaction, index int) (interface{}, error) {
+				return rpcMarshalTransaction(tx, block, hash, index, config)
+			}
 		}
 		txs := block.Transactions()
 		transactions := make([]interface{}, len(txs))
 		var err error
 		for i, tx := range txs {
-			if transactions[i], err = formatTx(tx); err != nil {
+			if transactions[i], err = formatTx(tx, i); err != nil {
 				return nil, err
 			}
 		}
@@ -66,6 +88,27 @@ func RPCMarshalBlock(block *types.Block, inclTx bool, fullTx bool, config *param
 		uncleHashes[i] = uncle.Hash()
 	}
 	fields["uncles"] = uncleHashes
+	if fork != nil {
+		fields["withdrawals"] = fork.Withdrawals
+	}
 
 	return fields, nil
 }
+
+// rpcMarshalTransaction encodes the transaction like the geth transaction JSON, with the block it is in and its sender.
+func rpcMarshalTransaction(tx *types.Transaction, block *types.Block, blockHash common.Hash, index int, config *params.ChainConfig) (map[string]interface{}, error) {
+	enc, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(enc, &fields); err != nil {
+		return nil, err
+	}
+	from, _ := types.Sender(types.MakeSigner(config, block.Number()), tx)
+	fields["blockHash"] = blockHash
+	fields["blockNumber"] = (*hexutil.Big)(block.Number())
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	fields["from"] = from
+	return fields, nil
+}
@@ -1,13 +1,38 @@
 package types
 
 import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/prysmaticlabs/prysm/crypto/bls"
 )
 
 type Domain [32]byte
 type DomainType [4]byte
 
+// ForkVersion is the 4 byte version of a fork, e.g. the genesis fork version 0x90000069 of sepolia.
+type ForkVersion [4]byte
+
+func (v ForkVersion) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(v[:]).MarshalText()
+}
+
+func (v *ForkVersion) UnmarshalText(input []byte) error {
+	var b hexutil.Bytes
+	if err := b.UnmarshalText(input); err != nil {
+		return err
+	}
+	if len(b) != 4 {
+		return ErrLength
+	}
+	copy(v[:], b)
+	return nil
+}
+
+func (v ForkVersion) String() string {
+	return hexutil.Encode(v[:])
+}
+
 var (
+	// DomainBuilder is the builder domain of mainnet, of the genesis fork version 0x00000000.
 	DomainBuilder Domain
 
 	DomainTypeBeaconProposer DomainType = DomainType{0x00, 0x00, 0x00, 0x00}
@@ -15,7 +40,7 @@ var (
 )
 
 func init() {
-	DomainBuilder = ComputeApplicationDomain(DomainTypeAppBuilder)
+	DomainBuilder = ComputeBuilderDomain(ForkVersion{})
 }
 
 type SigningData struct {
@@ -24,15 +49,15 @@ type SigningData struct {
 }
 
 type forkData struct {
-	CurrentVersion        uint32
-	GenesisValidatorsRoot Root `ssz-size:"32"`
+	CurrentVersion        ForkVersion `ssz-size:"4"`
+	GenesisValidatorsRoot Root        `ssz-size:"32"`
 }
 
 type HashTreeRoot interface {
 	HashTreeRoot() ([32]byte, error)
 }
 
-func ComputeDomain(dt DomainType, forkVersion uint32, genesisValidatorsRoot *Root) [32]byte {
+func ComputeDomain(dt DomainType, forkVersion ForkVersion, genesisValidatorsRoot *Root) [32]byte {
 	if genesisValidatorsRoot == nil {
 		var tmp Root
 		genesisValidatorsRoot = &tmp
@@ -49,8 +74,16 @@ func ComputeDomain(dt DomainType, forkVersion uint32, genesisValidatorsRoot *Roo
 	return domain
 }
 
-func ComputeApplicationDomain(dt DomainType) [32]byte {
-	return ComputeDomain(dt, 0, nil)
+// ComputeApplicationDomain computes the domain of an application, of the genesis fork version and no genesis
+// validators root, as it's valid before genesis.
+func ComputeApplicationDomain(dt DomainType, genesisForkVersion ForkVersion) [32]byte {
+	return ComputeDomain(dt, genesisForkVersion, nil)
+}
+
+// ComputeBuilderDomain computes the domain of validator registrations and builder bids of the network of the genesis
+// fork version.
+func ComputeBuilderDomain(genesisForkVersion ForkVersion) Domain {
+	return ComputeApplicationDomain(DomainTypeAppBuilder, genesisForkVersion)
 }
 
 func ComputeSigningRoot(obj HashTreeRoot, d Domain) ([32]byte, error) {
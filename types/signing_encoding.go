@@ -76,7 +76,7 @@ func (f *forkData) MarshalSSZTo(buf []byte) (dst []byte, err error) {
 	dst = buf
 
 	// Field (0) 'CurrentVersion'
-	dst = ssz.MarshalUint32(dst, f.CurrentVersion)
+	dst = append(dst, f.CurrentVersion[:]...)
 
 	// Field (1) 'GenesisValidatorsRoot'
 	dst = append(dst, f.GenesisValidatorsRoot[:]...)
@@ -93,7 +93,7 @@ func (f *forkData) UnmarshalSSZ(buf []byte) error {
 	}
 
 	// Field (0) 'CurrentVersion'
-	f.CurrentVersion = ssz.UnmarshallUint32(buf[0:4])
+	copy(f.CurrentVersion[:], buf[0:4])
 
 	// Field (1) 'GenesisValidatorsRoot'
 	copy(f.GenesisValidatorsRoot[:], buf[4:36])
@@ -117,7 +117,7 @@ func (f *forkData) HashTreeRootWith(hh *ssz.Hasher) (err error) {
 	indx := hh.Index()
 
 	// Field (0) 'CurrentVersion'
-	hh.PutUint32(f.CurrentVersion)
+	hh.PutBytes(f.CurrentVersion[:])
 
 	// Field (1) 'GenesisValidatorsRoot'
 	hh.PutBytes(f.GenesisValidatorsRoot[:])
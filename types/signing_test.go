@@ -3,6 +3,7 @@ package types
 import (
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/prysmaticlabs/prysm/crypto/bls"
 	"github.com/stretchr/testify/require"
 )
@@ -31,3 +32,14 @@ func TestVerifySignature(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok)
 }
+
+func TestComputeBuilderDomain(t *testing.T) {
+	require.Equal(t, "0x00000001f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a9", hexutil.Encode(DomainBuilder[:]))
+
+	var sepolia ForkVersion
+	require.NoError(t, sepolia.UnmarshalText([]byte("0x90000069")))
+	domain := ComputeBuilderDomain(sepolia)
+	require.NotEqual(t, DomainBuilder, domain)
+	require.Equal(t, DomainTypeAppBuilder[:], domain[:4])
+	require.Error(t, sepolia.UnmarshalText([]byte("0x900000")))
+}
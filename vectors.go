@@ -0,0 +1,444 @@
+package mergemock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"mergemock/rpc"
+	"mergemock/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+// vectorsMnemonic derives the test accounts the transfers of the vectors are sent from, the one of Anvil and Hardhat.
+const vectorsMnemonic = "test test test test test test test test test test test junk"
+
+// vectorsSlotTime is the time between the payloads of the vectors, in seconds.
+const vectorsSlotTime = 12
+
+type VectorsCmd struct {
+	OutDir      string          `ask:"--out" help:"Directory to write the vectors to, created if missing"`
+	GenesisPath string          `ask:"--genesis" help:"Genesis execution-config file of the chain the vectors build on (the embedded default genesis if missing)"`
+	Seed        int64           `ask:"--seed" help:"Seed of the payload attributes, withdrawals and transactions: the same seed and genesis generate the same vectors"`
+	Slots       uint64          `ask:"--slots" help:"Payloads of the valid chain the other vectors build on"`
+	Txs         uint64          `ask:"--txs" help:"Transfers between the well-known test accounts of every payload (0 for empty payloads)"`
+	Forks       ForkTimesConfig `ask:".fork" help:"Override the activation timestamps of the forks of the genesis, to generate vectors across fork transitions"`
+
+	LogCmd `ask:".log" help:"Change logger configuration"`
+
+	log logrus.Ext1FieldLogger
+}
+
+func (c *VectorsCmd) Default() {
+	c.OutDir = "vectors"
+	c.GenesisPath = "genesis.json"
+	c.Seed = 1
+	c.Slots = 8
+	c.Txs = 4
+}
+
+func (c *VectorsCmd) Help() string {
+	return "Generate engine API request and response fixtures of valid and invalid payloads, fork transitions and reorgs from a seed and genesis, for consensus clients to share in their unit tests."
+}
+
+// VectorExchange is a JSON-RPC request of the engine API, and the response of the engine to it.
+type VectorExchange struct {
+	Method   string          `json:"method"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+// Vector is a sequence of exchanges, to be sent in order to an engine with the head left by the vectors before it.
+type Vector struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Exchanges   []VectorExchange `json:"exchanges"`
+}
+
+// VectorsManifest lists the vector files in order, with what they were generated from.
+type VectorsManifest struct {
+	Mergemock VersionInfo `json:"mergemock"`
+	Seed      int64       `json:"seed"`
+	Genesis   common.Hash `json:"genesis"`
+	Head      common.Hash `json:"head"`
+	Vectors   []string    `json:"vectors"`
+}
+
+func (c *VectorsCmd) Run(ctx context.Context, args ...string) error {
+	log, err := c.LogCmd.Create()
+	if err != nil {
+		return err
+	}
+	c.log = log
+	vectors, manifest, err := c.Generate(ctx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.OutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create vectors directory: %v", err)
+	}
+	for i, v := range vectors {
+		name := fmt.Sprintf("%02d-%s.json", i, v.Name)
+		if err := writeVectorFile(filepath.Join(c.OutDir, name), v); err != nil {
+			return err
+		}
+		manifest.Vectors = append(manifest.Vectors, name)
+	}
+	if err := writeVectorFile(filepath.Join(c.OutDir, "manifest.json"), manifest); err != nil {
+		return err
+	}
+	c.log.WithFields(logrus.Fields{"out": c.OutDir, "vectors": len(vectors), "seed": c.Seed, "genesis": manifest.Genesis}).Info("Generated vectors")
+	return nil
+}
+
+func writeVectorFile(path string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(buf, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write vector: %v", err)
+	}
+	return nil
+}
+
+// Generate runs a fresh in-process engine, and drives it like a consensus client would to record the vectors.
+func (c *VectorsCmd) Generate(ctx context.Context) ([]*Vector, *VectorsManifest, error) {
+	if c.Seed == 0 {
+		return nil, nil, fmt.Errorf("vectors need a seed other than 0")
+	}
+	if c.Slots == 0 {
+		return nil, nil, fmt.Errorf("vectors need at least one slot")
+	}
+	engine, err := NewEngine(EngineOptions{GenesisPath: c.GenesisPath, Configure: func(cmd *EngineCmd) {
+		cmd.Forks = c.Forks
+		cmd.LogCmd.LogLvl = "error"
+		if c.Txs != 0 {
+			cmd.Accounts.Mnemonic = vectorsMnemonic
+			cmd.Txs.Mode = TxModeTransfer
+			cmd.Txs.Count = c.Txs
+			cmd.Txs.Seed = c.Seed
+		}
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := engine.Start(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer engine.Stop()
+
+	chain := engine.MockChain()
+	genesis := chain.CurrentHeader()
+	rng := rand.New(rand.NewSource(c.Seed))
+	var withdrawalsCfg WithdrawalsConfig
+	withdrawalsCfg.Default()
+	withdrawals, err := withdrawalsCfg.NewWithdrawalGenerator(rng)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &vectorGenerator{
+		url:         engine.URL(),
+		secret:      engine.JwtSecret(),
+		chain:       chain,
+		genesis:     chain.SpecHash(genesis.Hash()),
+		genesisTime: genesis.Time,
+		rng:         rng,
+		withdrawals: withdrawals,
+	}
+
+	// the chain takes the first slots, the reorg the next one, and every payload at the slots after is broken in
+	// one way
+	reorgSlot := c.Slots + 1
+	invalidSlot := func(i int) uint64 { return reorgSlot + 1 + uint64(i) }
+	kinds := []string{InvalidStateRoot, InvalidBlockHash, InvalidBaseFee, InvalidGasLimit}
+	if c.Txs != 0 {
+		kinds = append(kinds, InvalidDuplicateTxs)
+	}
+	if chain.IsShanghai(g.timestamp(invalidSlot(len(kinds)))) {
+		kinds = append(kinds, InvalidWithdrawals)
+	}
+	invalid := InvalidPayloadConfig{SlotTime: vectorsSlotTime * time.Second}
+	for i, kind := range kinds {
+		invalid.Slots = append(invalid.Slots, fmt.Sprintf("%d=%s", invalidSlot(i), kind))
+	}
+	if engine.Backend().invalid, err = invalid.NewInvalidPayloads(genesis.Time); err != nil {
+		return nil, nil, err
+	}
+
+	chainVector := &Vector{Name: "chain", Description: fmt.Sprintf("%d valid payloads built, imported and made the head one after the other, on the genesis block", c.Slots)}
+	head := g.genesis
+	for slot := uint64(1); slot <= c.Slots; slot++ {
+		if head, err = g.proposeValid(ctx, chainVector, head, slot); err != nil {
+			return nil, nil, fmt.Errorf("slot %d: %v", slot, err)
+		}
+	}
+	vectors := []*Vector{chainVector}
+
+	// engines skip forkchoice updates to canonical ancestors of the head, so the competing payloads are both built
+	// on the head
+	reorg := &Vector{Name: "reorg", Description: "two competing payloads built on the head and imported, the first made the head, and then reorged out by the second"}
+	var competing [2]common.Hash
+	for i := range competing {
+		hash, status, err := g.propose(ctx, reorg, head, reorgSlot)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reorg: %v", err)
+		}
+		if status.Status != types.ExecutionValid {
+			return nil, nil, fmt.Errorf("reorg: payload %s is %s: %s", hash, status.Status, status.ValidationError)
+		}
+		competing[i] = hash
+	}
+	for _, hash := range competing {
+		if err := g.setHead(ctx, reorg, hash, reorgSlot); err != nil {
+			return nil, nil, fmt.Errorf("reorg: %v", err)
+		}
+	}
+	head = competing[1]
+	vectors = append(vectors, reorg)
+
+	unknown := &Vector{Name: "unknown-head", Description: "a forkchoice update to a head the engine doesn't know, which it answers SYNCING to"}
+	var unknownHead common.Hash
+	g.rng.Read(unknownHead[:])
+	if _, err := g.forkchoice(ctx, unknown, unknownHead, nil, reorgSlot); err != nil {
+		return nil, nil, fmt.Errorf("unknown head: %v", err)
+	}
+	vectors = append(vectors, unknown)
+
+	vectors = append(vectors, g.wrongVersion(ctx, head, invalidSlot(0)))
+
+	for i, kind := range kinds {
+		v := &Vector{Name: "invalid-" + kind, Description: fmt.Sprintf("a payload built on the head with a broken %s, which the engine refuses to import", kind)}
+		hash, status, err := g.propose(ctx, v, head, invalidSlot(i))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %v", kind, err)
+		}
+		if status.Status == types.ExecutionValid {
+			return nil, nil, fmt.Errorf("invalid %s: broken payload %s imported as valid", kind, hash)
+		}
+		vectors = append(vectors, v)
+	}
+	manifest := &VectorsManifest{Mergemock: Version(), Seed: c.Seed, Genesis: g.genesis, Head: head, Vectors: []string{}}
+	return vectors, manifest, nil
+}
+
+// vectorGenerator drives the engine over its authenticated endpoint with payload attributes drawn from the seed.
+type vectorGenerator struct {
+	url         string
+	secret      []byte
+	chain       *MockChain
+	genesis     common.Hash
+	genesisTime uint64
+	rng         *rand.Rand
+	withdrawals *WithdrawalGenerator
+}
+
+func (g *vectorGenerator) timestamp(slot uint64) uint64 {
+	return g.genesisTime + slot*vectorsSlotTime
+}
+
+// version returns the version of the engine API methods at the timestamp: forkchoiceUpdated stays at V3 from
+// Cancun on, getPayload and newPayload go on to V4 with Prague.
+func (g *vectorGenerator) version(timestamp uint64) int {
+	switch {
+	case g.chain.IsPrague(timestamp):
+		return 4
+	case g.chain.IsCancun(timestamp):
+		return 3
+	case g.chain.IsShanghai(timestamp):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// attributes returns the payload attributes of the version at the slot, on the parent.
+func (g *vectorGenerator) attributes(version int, parent common.Hash, slot uint64) interface{} {
+	var prevRandao, beaconRoot common.Hash
+	var feeRecipient common.Address
+	g.rng.Read(prevRandao[:])
+	g.rng.Read(feeRecipient[:])
+	v1 := types.PayloadAttributesV1{Timestamp: g.timestamp(slot), PrevRandao: prevRandao, SuggestedFeeRecipient: feeRecipient}
+	if version == 1 {
+		return &v1
+	}
+	last := g.chain.lastWithdrawal(g.chain.chain.GetHeaderByHash(g.chain.ResolveHash(parent)))
+	v2 := types.PayloadAttributesV2{Timestamp: v1.Timestamp, PrevRandao: prevRandao, SuggestedFeeRecipient: feeRecipient, Withdrawals: g.withdrawals.Next(last)}
+	if version == 2 {
+		return &v2
+	}
+	g.rng.Read(beaconRoot[:])
+	return &types.PayloadAttributesV3{Timestamp: v2.Timestamp, PrevRandao: prevRandao, SuggestedFeeRecipient: feeRecipient, Withdrawals: v2.Withdrawals, ParentBeaconBlockRoot: beaconRoot}
+}
+
+// forkchoice updates the head, with the safe and finalized blocks at the genesis, preparing a payload if there are
+// attributes. The version is the one at the slot.
+func (g *vectorGenerator) forkchoice(ctx context.Context, v *Vector, head common.Hash, attributes interface{}, slot uint64) (*types.ForkchoiceUpdatedResult, error) {
+	version := g.version(g.timestamp(slot))
+	if version > 3 {
+		version = 3
+	}
+	var result types.ForkchoiceUpdatedResult
+	heads := &types.ForkchoiceStateV1{HeadBlockHash: head, SafeBlockHash: g.genesis, FinalizedBlockHash: g.genesis}
+	if err := g.call(ctx, v, &result, fmt.Sprintf("engine_forkchoiceUpdatedV%d", version), heads, attributes); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// propose builds a payload on the parent at the slot, and imports it. It returns the block hash of the payload
+// and the status of its import.
+func (g *vectorGenerator) propose(ctx context.Context, v *Vector, parent common.Hash, slot uint64) (common.Hash, *types.PayloadStatusV1, error) {
+	version := g.version(g.timestamp(slot))
+	attributes := g.attributes(version, parent, slot)
+	fcu, err := g.forkchoice(ctx, v, parent, attributes, slot)
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	if fcu.PayloadID == nil {
+		return common.Hash{}, nil, fmt.Errorf("no payload prepared, forkchoice status %s", fcu.PayloadStatus.Status)
+	}
+	var envelope struct {
+		ExecutionPayload  json.RawMessage `json:"executionPayload"`
+		ExecutionRequests json.RawMessage `json:"executionRequests"`
+	}
+	var payload json.RawMessage
+	if version == 1 {
+		err = g.call(ctx, v, &payload, "engine_getPayloadV1", fcu.PayloadID)
+	} else if err = g.call(ctx, v, &envelope, fmt.Sprintf("engine_getPayloadV%d", version), fcu.PayloadID); err == nil {
+		payload = envelope.ExecutionPayload
+	}
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	var block struct {
+		BlockHash common.Hash `json:"blockHash"`
+	}
+	if err := json.Unmarshal(payload, &block); err != nil {
+		return common.Hash{}, nil, err
+	}
+	params := []interface{}{payload}
+	if version >= 3 {
+		params = append(params, []common.Hash{}, attributes.(*types.PayloadAttributesV3).ParentBeaconBlockRoot)
+	}
+	if version >= 4 {
+		params = append(params, envelope.ExecutionRequests)
+	}
+	var status types.PayloadStatusV1
+	if err := g.call(ctx, v, &status, fmt.Sprintf("engine_newPayloadV%d", version), params...); err != nil {
+		return common.Hash{}, nil, err
+	}
+	return block.BlockHash, &status, nil
+}
+
+// proposeValid proposes a payload that has to be valid, and makes it the head.
+func (g *vectorGenerator) proposeValid(ctx context.Context, v *Vector, parent common.Hash, slot uint64) (common.Hash, error) {
+	hash, status, err := g.propose(ctx, v, parent, slot)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if status.Status != types.ExecutionValid {
+		return common.Hash{}, fmt.Errorf("payload %s is %s: %s", hash, status.Status, status.ValidationError)
+	}
+	return hash, g.setHead(ctx, v, hash, slot)
+}
+
+// setHead makes the imported block the head.
+func (g *vectorGenerator) setHead(ctx context.Context, v *Vector, hash common.Hash, slot uint64) error {
+	fcu, err := g.forkchoice(ctx, v, hash, nil, slot)
+	if err != nil {
+		return err
+	}
+	if fcu.PayloadStatus.Status != types.ExecutionValid {
+		return fmt.Errorf("forkchoice update to %s is %s", hash, fcu.PayloadStatus.Status)
+	}
+	return nil
+}
+
+// wrongVersion records a forkchoiceUpdated preparing a payload at the slot with attributes of the wrong version
+// for the fork at the slot, which the engine answers with an error.
+func (g *vectorGenerator) wrongVersion(ctx context.Context, head common.Hash, slot uint64) *Vector {
+	v := &Vector{Name: "wrong-fork-version"}
+	timestamp := g.timestamp(slot)
+	method, attributes := "engine_forkchoiceUpdatedV2", g.attributes(2, head, slot)
+	switch version := g.version(timestamp); {
+	case version == 1:
+		attributes.(*types.PayloadAttributesV2).Withdrawals = []*types.Withdrawal{}
+		v.Description = "a forkchoice update with withdrawals before Shanghai, refused with the invalid payload attributes error"
+	case version == 2:
+		method, attributes = "engine_forkchoiceUpdatedV1", g.attributes(1, head, slot)
+		v.Description = "a forkchoice update without withdrawals after Shanghai, refused with the invalid payload attributes error"
+	default:
+		v.Description = fmt.Sprintf("a forkchoice update of version 2 in %s, refused with the unsupported fork error", g.chain.ForkAt(timestamp))
+	}
+	heads := &types.ForkchoiceStateV1{HeadBlockHash: head, SafeBlockHash: g.genesis, FinalizedBlockHash: g.genesis}
+	// the error is what's recorded
+	g.call(ctx, v, nil, method, heads, attributes)
+	return v
+}
+
+// vectorError is the JSON-RPC error of a response.
+type vectorError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *vectorError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// call sends a JSON-RPC request with the params, records the exchange in the vector, and decodes its result into
+// result. Requests are numbered from 1 within each vector.
+func (g *vectorGenerator) call(ctx context.Context, v *Vector, result interface{}, method string, params ...interface{}) error {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      len(v.Exchanges) + 1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	token, err := rpc.IssueJwtToken().SignedString(g.secret)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", rpc.EncodeJwtAuthorization(token))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var msg struct {
+		Result json.RawMessage `json:"result"`
+		Error  *vectorError    `json:"error"`
+	}
+	if err := json.Unmarshal(response, &msg); err != nil {
+		return fmt.Errorf("invalid response to %s: %v", method, err)
+	}
+	v.Exchanges = append(v.Exchanges, VectorExchange{Method: method, Request: request, Response: bytes.TrimSpace(response)})
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(msg.Result, result)
+}
@@ -0,0 +1,96 @@
+package mergemock
+
+import (
+	"context"
+	"encoding/json"
+	"mergemock/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newVectorsCmd(t *testing.T, seed int64) *VectorsCmd {
+	c := &VectorsCmd{OutDir: t.TempDir(), Seed: seed, Slots: 4, Txs: 2}
+	c.LogCmd.Default()
+	c.LogLvl = "warn"
+	// Shanghai at slot 2 and Cancun at slot 4, the invalid payloads after the chain are Cancun payloads
+	c.Forks.Shanghai, c.Forks.Cancun = "24", "48"
+	return c
+}
+
+func TestVectors(t *testing.T) {
+	ctx := context.Background()
+	c := newVectorsCmd(t, 7)
+	require.NoError(t, c.Run(ctx))
+
+	buf, err := os.ReadFile(filepath.Join(c.OutDir, "manifest.json"))
+	require.NoError(t, err)
+	var manifest VectorsManifest
+	require.NoError(t, json.Unmarshal(buf, &manifest))
+	require.Equal(t, []string{
+		"00-chain.json", "01-reorg.json", "02-unknown-head.json", "03-wrong-fork-version.json",
+		"04-invalid-state-root.json", "05-invalid-block-hash.json", "06-invalid-base-fee.json",
+		"07-invalid-gas-limit.json", "08-invalid-duplicate-txs.json", "09-invalid-withdrawals.json",
+	}, manifest.Vectors)
+
+	var chain Vector
+	buf, err = os.ReadFile(filepath.Join(c.OutDir, manifest.Vectors[0]))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(buf, &chain))
+	methods := make([]string, 0, len(chain.Exchanges))
+	for _, ex := range chain.Exchanges {
+		methods = append(methods, ex.Method)
+	}
+	// The chain crosses the fork transitions, switching to the methods of each fork.
+	require.Equal(t, []string{
+		"engine_forkchoiceUpdatedV1", "engine_getPayloadV1", "engine_newPayloadV1", "engine_forkchoiceUpdatedV1",
+		"engine_forkchoiceUpdatedV2", "engine_getPayloadV2", "engine_newPayloadV2", "engine_forkchoiceUpdatedV2",
+		"engine_forkchoiceUpdatedV2", "engine_getPayloadV2", "engine_newPayloadV2", "engine_forkchoiceUpdatedV2",
+		"engine_forkchoiceUpdatedV3", "engine_getPayloadV3", "engine_newPayloadV3", "engine_forkchoiceUpdatedV3",
+	}, methods)
+	var payload struct {
+		Transactions []string `json:"transactions"`
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(chain.Exchanges[1].Response, &response))
+	require.NoError(t, json.Unmarshal(response.Result, &payload))
+	require.Len(t, payload.Transactions, 2)
+
+	statuses := map[string]types.ExecutePayloadStatus{
+		"04-invalid-state-root.json":    types.ExecutionInvalid,
+		"05-invalid-block-hash.json":    types.ExecutionInvalidBlockHash,
+		"09-invalid-withdrawals.json":   types.ExecutionInvalidBlockHash,
+		"08-invalid-duplicate-txs.json": types.ExecutionInvalid,
+	}
+	for name, status := range statuses {
+		var v Vector
+		buf, err := os.ReadFile(filepath.Join(c.OutDir, name))
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(buf, &v))
+		var result struct {
+			Result types.PayloadStatusV1 `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(v.Exchanges[len(v.Exchanges)-1].Response, &result))
+		require.Equal(t, status, result.Result.Status, name)
+	}
+}
+
+func TestVectorsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	generate := func(seed int64) []byte {
+		vectors, manifest, err := newVectorsCmd(t, seed).Generate(ctx)
+		require.NoError(t, err)
+		buf, err := json.Marshal(vectors)
+		require.NoError(t, err)
+		return append(buf, manifest.Head[:]...)
+	}
+	require.Equal(t, generate(7), generate(7), "the same seed generates the same vectors")
+	require.NotEqual(t, generate(7), generate(8))
+
+	_, _, err := (&VectorsCmd{Slots: 1}).Generate(ctx)
+	require.Error(t, err, "seed 0 is random")
+}
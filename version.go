@@ -0,0 +1,55 @@
+package mergemock
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g. -ldflags "-X mergemock.GitCommit=$(git rev-parse HEAD)". See the Makefile.
+var (
+	GitCommit = ""
+	BuildTime = ""
+)
+
+type VersionInfo struct {
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Version returns the build information, falling back to the VCS stamp the go tool embeds
+// when the binary was built without the ldflags.
+func Version() VersionInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return buildVersion(GitCommit, BuildTime, bi)
+}
+
+// buildVersion is Version of the given ldflags values and build info, nil if the binary has none.
+func buildVersion(commit, buildTime string, bi *debug.BuildInfo) VersionInfo {
+	info := VersionInfo{GitCommit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if bi != nil && info.GitCommit == "" {
+		var modified bool
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.GitCommit = s.Value
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && info.GitCommit != "" {
+			info.GitCommit += "-dirty"
+		}
+	}
+	if info.GitCommit == "" {
+		info.GitCommit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
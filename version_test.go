@@ -0,0 +1,55 @@
+package mergemock
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	stamped := &debug.BuildInfo{Settings: []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "abc123"},
+		{Key: "vcs.time", Value: "2024-01-02T03:04:05Z"},
+		{Key: "vcs.modified", Value: "true"},
+	}}
+	for _, tc := range []struct {
+		name              string
+		commit, buildTime string
+		bi                *debug.BuildInfo
+		expected          VersionInfo
+	}{
+		{"ldflags", "def456", "now", stamped, VersionInfo{GitCommit: "def456", BuildTime: "now"}},
+		{"vcs stamp", "", "", stamped, VersionInfo{GitCommit: "abc123-dirty", BuildTime: "2024-01-02T03:04:05Z"}},
+		{"ldflags commit only", "def456", "", stamped, VersionInfo{GitCommit: "def456", BuildTime: "unknown"}},
+		{"unknown", "", "", &debug.BuildInfo{}, VersionInfo{GitCommit: "unknown", BuildTime: "unknown"}},
+		{"no build info", "", "", nil, VersionInfo{GitCommit: "unknown", BuildTime: "unknown"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info := buildVersion(tc.commit, tc.buildTime, tc.bi)
+			require.NotEmpty(t, info.GoVersion)
+			info.GoVersion = ""
+			require.Equal(t, tc.expected, info)
+		})
+	}
+}
+
+func TestMockVersion(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+
+	commit, buildTime := GitCommit, BuildTime
+	t.Cleanup(func() { GitCommit, BuildTime = commit, buildTime })
+	GitCommit, BuildTime = "0123456789abcdef", "2024-01-02T03:04:05Z"
+	var info VersionInfo
+	require.NoError(t, te.client.CallContext(ctx, &info, "mock_version"))
+	require.Equal(t, "0123456789abcdef", info.GitCommit, "the commit of the ldflags")
+	require.Equal(t, "2024-01-02T03:04:05Z", info.BuildTime)
+
+	// test binaries have no VCS stamp to fall back to
+	GitCommit, BuildTime = "", ""
+	require.NoError(t, te.client.CallContext(ctx, &info, "mock_version"))
+	require.Equal(t, "unknown", info.GitCommit)
+	require.Equal(t, "unknown", info.BuildTime)
+}
@@ -0,0 +1,105 @@
+package mergemock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"mergemock/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// MaxWithdrawalsPerPayload is the most withdrawals a payload can have, as per the consensus specs.
+const MaxWithdrawalsPerPayload = 16
+
+type WithdrawalsConfig struct {
+	Count            uint64  `ask:"--count" help:"Withdrawals of every payload from Shanghai on, at most 16"`
+	EmptyProbability float64 `ask:"--empty-probability" help:"Probability of a payload without any withdrawal"`
+	FullProbability  float64 `ask:"--full-probability" help:"Probability of a payload with the maximum of 16 withdrawals"`
+	MinValidator     uint64  `ask:"--min-validator" help:"Lowest validator index withdrawn for"`
+	MaxValidator     uint64  `ask:"--max-validator" help:"Highest validator index withdrawn for, after which the sweep starts over at the lowest"`
+	MinAmount        uint64  `ask:"--min-amount" help:"Smallest withdrawn amount, in Gwei"`
+	MaxAmount        uint64  `ask:"--max-amount" help:"Largest withdrawn amount, in Gwei"`
+}
+
+func (c *WithdrawalsConfig) Default() {
+	c.Count = 4
+	c.MaxValidator = 63
+	c.MinAmount = 1_000_000      // 0.001 ether, a partial withdrawal of rewards
+	c.MaxAmount = 32_000_000_000 // a full withdrawal
+}
+
+// NewWithdrawalGenerator checks the config, and returns the generator drawing from the RNG.
+func (c *WithdrawalsConfig) NewWithdrawalGenerator(rng *rand.Rand) (*WithdrawalGenerator, error) {
+	if c.Count > MaxWithdrawalsPerPayload {
+		return nil, fmt.Errorf("%d withdrawals per payload, at most %d allowed", c.Count, MaxWithdrawalsPerPayload)
+	}
+	if c.EmptyProbability < 0 || c.FullProbability < 0 || c.EmptyProbability+c.FullProbability > 1 {
+		return nil, fmt.Errorf("invalid withdrawal probabilities, empty %v and full %v", c.EmptyProbability, c.FullProbability)
+	}
+	if c.MinValidator > c.MaxValidator {
+		return nil, fmt.Errorf("validator index range %d-%d is empty", c.MinValidator, c.MaxValidator)
+	}
+	if c.MinAmount > c.MaxAmount {
+		return nil, fmt.Errorf("withdrawal amount range %d-%d is empty", c.MinAmount, c.MaxAmount)
+	}
+	return &WithdrawalGenerator{cfg: *c, rng: rng}, nil
+}
+
+// WithdrawalGenerator makes up the withdrawals of payload attributes the way the beacon chain sweeps validators:
+// the withdrawal indices count on across blocks, and the validators are withdrawn for in order of their index.
+type WithdrawalGenerator struct {
+	cfg WithdrawalsConfig
+	rng *rand.Rand
+}
+
+// Next returns the withdrawals of a payload following the last withdrawal of its chain, nil if there is none yet.
+// It's never nil, as payloads from Shanghai on have withdrawals, if empty.
+func (g *WithdrawalGenerator) Next(last *types.Withdrawal) []*types.Withdrawal {
+	count := g.cfg.Count
+	switch r := g.rng.Float64(); {
+	case r < g.cfg.EmptyProbability:
+		count = 0
+	case r < g.cfg.EmptyProbability+g.cfg.FullProbability:
+		count = MaxWithdrawalsPerPayload
+	}
+	index, validator := uint64(0), g.cfg.MinValidator
+	if last != nil {
+		index, validator = last.Index+1, last.Validator+1
+	}
+	withdrawals := make([]*types.Withdrawal, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if validator < g.cfg.MinValidator || validator > g.cfg.MaxValidator {
+			validator = g.cfg.MinValidator
+		}
+		withdrawals = append(withdrawals, &types.Withdrawal{
+			Index:     index + i,
+			Validator: validator,
+			Address:   withdrawalAddress(validator),
+			Amount:    g.cfg.MinAmount + uint64(g.rng.Int63n(int64(g.cfg.MaxAmount-g.cfg.MinAmount+1))),
+		})
+		validator++
+	}
+	return withdrawals
+}
+
+// withdrawalAddress is the execution address the validator withdraws to, its index prefixed with 0xee, so that the
+// credited balances are easy to tell apart.
+func withdrawalAddress(validator uint64) common.Address {
+	var addr common.Address
+	addr[0] = 0xee
+	binary.BigEndian.PutUint64(addr[common.AddressLength-8:], validator)
+	return addr
+}
+
+// lastWithdrawal returns the last withdrawal of the chain up to the block, nil if there is none.
+func (c *MockChain) lastWithdrawal(header *ethTypes.Header) *types.Withdrawal {
+	for header != nil && c.IsShanghai(header.Time) {
+		if withdrawals := c.Withdrawals(header.Hash()); len(withdrawals) > 0 {
+			return withdrawals[len(withdrawals)-1]
+		}
+		header = c.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package mergemock
+
+import (
+	"math/rand"
+	"mergemock/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithdrawalGenerator(t *testing.T) {
+	var cfg WithdrawalsConfig
+	cfg.Default()
+	cfg.Count = 3
+	cfg.MinValidator, cfg.MaxValidator = 10, 13
+	cfg.MinAmount, cfg.MaxAmount = 5, 7
+	g, err := cfg.NewWithdrawalGenerator(rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+
+	// The sweep starts at the lowest validator, and wraps around after the highest.
+	first := g.Next(nil)
+	require.Len(t, first, 3)
+	second := g.Next(first[len(first)-1])
+	var validators []uint64
+	for i, w := range append(first, second...) {
+		require.Equal(t, uint64(i), w.Index)
+		require.Equal(t, withdrawalAddress(w.Validator), w.Address)
+		require.GreaterOrEqual(t, w.Amount, uint64(5))
+		require.LessOrEqual(t, w.Amount, uint64(7))
+		validators = append(validators, w.Validator)
+	}
+	require.Equal(t, []uint64{10, 11, 12, 13, 10, 11}, validators)
+
+	cfg.EmptyProbability = 1
+	g, err = cfg.NewWithdrawalGenerator(rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	empty := g.Next(second[len(second)-1])
+	require.NotNil(t, empty)
+	require.Empty(t, empty)
+
+	cfg.EmptyProbability, cfg.FullProbability = 0, 1
+	g, err = cfg.NewWithdrawalGenerator(rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	full := g.Next(second[len(second)-1])
+	require.Len(t, full, MaxWithdrawalsPerPayload)
+	require.Equal(t, uint64(6), full[0].Index)
+	require.Equal(t, uint64(12), full[0].Validator)
+
+	for _, invalid := range []func(c *WithdrawalsConfig){
+		func(c *WithdrawalsConfig) { c.Count = MaxWithdrawalsPerPayload + 1 },
+		func(c *WithdrawalsConfig) { c.EmptyProbability, c.FullProbability = 0.6, 0.6 },
+		func(c *WithdrawalsConfig) { c.MinValidator = c.MaxValidator + 1 },
+		func(c *WithdrawalsConfig) { c.MinAmount = c.MaxAmount + 1 },
+	} {
+		var c WithdrawalsConfig
+		c.Default()
+		invalid(&c)
+		_, err := c.NewWithdrawalGenerator(rand.New(rand.NewSource(1)))
+		require.Error(t, err)
+	}
+}
+
+func TestInvalidWithdrawals(t *testing.T) {
+	te := newTestEngineWithGenesis(t, newForkGenesis(t, map[string]uint64{"shanghaiTime": 0}), func(cmd *EngineCmd) {
+		cmd.Invalid.Slots = []string{"1=withdrawals", "2=withdrawals"}
+	})
+	genesis := te.mockChain().CurrentHeader()
+	withdrawals := []*types.Withdrawal{{Index: 0, Validator: 1, Address: withdrawalAddress(1), Amount: 32}}
+
+	// The withdrawals no longer match the root the block hash commits to, either amended or made up.
+	_, envelope, err := te.buildPayloadV2(t, genesis.Hash(), genesis.Time+12, withdrawals)
+	require.NoError(t, err)
+	require.Len(t, envelope.ExecutionPayload.Withdrawals, 1)
+	require.Equal(t, uint64(33), envelope.ExecutionPayload.Withdrawals[0].Amount)
+	require.Equal(t, uint64(32), withdrawals[0].Amount, "attributes withdrawals are left alone")
+	require.False(t, envelope.ExecutionPayload.ValidateHash())
+
+	_, envelope, err = te.buildPayloadV2(t, genesis.Hash(), genesis.Time+24, []*types.Withdrawal{})
+	require.NoError(t, err)
+	require.Len(t, envelope.ExecutionPayload.Withdrawals, 1)
+	require.False(t, envelope.ExecutionPayload.ValidateHash())
+
+	_, envelope, err = te.buildPayloadV2(t, genesis.Hash(), genesis.Time+36, withdrawals)
+	require.NoError(t, err)
+	require.True(t, envelope.ExecutionPayload.ValidateHash())
+}